@@ -2,6 +2,7 @@ package main
 
 import (
     "context"
+    "flag"
     "fmt"
     "net/http"
     "os"
@@ -12,34 +13,56 @@ import (
     "github.com/bezata/blockchainml-email/internal/api/handlers"
     "github.com/bezata/blockchainml-email/internal/api/middleware"
     "github.com/bezata/blockchainml-email/internal/api/router"
+    "github.com/bezata/blockchainml-email/internal/backup"
     "github.com/bezata/blockchainml-email/internal/config"
+    "github.com/bezata/blockchainml-email/internal/migrations"
     "github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+    "github.com/bezata/blockchainml-email/internal/security"
     "github.com/bezata/blockchainml-email/internal/services"
     "github.com/bezata/blockchainml-email/internal/storage"
+    "github.com/bezata/blockchainml-email/internal/storage/memory"
+    "github.com/bezata/blockchainml-email/internal/storage/mongodb"
+    "github.com/bezata/blockchainml-email/internal/storage/postgres"
+    "github.com/bezata/blockchainml-email/internal/storage/r2"
     "github.com/bezata/blockchainml-email/pkg/cache"
     "github.com/bezata/blockchainml-email/pkg/realtime"
     "github.com/bezata/blockchainml-email/pkg/search"
+    "github.com/redis/go-redis/v9"
     "go.mongodb.org/mongo-driver/mongo"
     "go.uber.org/zap"
 )
 
 func main() {
+    configPath := flag.String("config", os.Getenv("CONFIG_PATH"), "path to a JSON or YAML configuration file")
+    flag.Parse()
+
     // Initialize context
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
-    // Initialize logger
-    logger, err := zap.NewProduction()
+    // Initialize logger, its level follows the configuration
+    logLevel := zap.NewAtomicLevel()
+    logConfig := zap.NewProductionConfig()
+    logConfig.Level = logLevel
+    logger, err := logConfig.Build()
     if err != nil {
         panic(err)
     }
     defer logger.Sync()
 
     // Load configuration
-    cfg, err := config.Load()
+    cfg, err := config.Load(*configPath)
     if err != nil {
         logger.Fatal("Failed to load configuration", zap.Error(err))
     }
+    if err := logLevel.UnmarshalText([]byte(cfg.Monitoring.Logging.Level)); err != nil {
+        logger.Fatal("Invalid log level", zap.Error(err))
+    }
+
+    // Run maintenance subcommands instead of the server
+    if flag.Arg(0) == "migrate" {
+        os.Exit(runMigrate(ctx, cfg, logger, flag.Args()[1:]))
+    }
 
     // Initialize metrics
     metrics := metrics.NewMetrics("email_server")
@@ -54,11 +77,51 @@ func main() {
     // Initialize services
     services := initializeServices(cfg, deps, logger, metrics)
 
+    // Start scheduled backups
+    if cfg.Backup.Enabled {
+        scheduler, err := initializeBackups(cfg, deps, logger, metrics)
+        if err != nil {
+            logger.Fatal("Failed to initialize backups", zap.Error(err))
+        }
+        scheduler.Start(ctx)
+        defer scheduler.Stop()
+    }
+
+    // Close outbound transports once the worker delivering through them
+    // has stopped
+    if services.Transports != nil {
+        defer func() {
+            if err := services.Transports.Close(); err != nil {
+                logger.Error("Failed to close transports", zap.Error(err))
+            }
+        }()
+    }
+
+    // Start background jobs such as campaign sending and delivery
+    if cfg.Jobs.Enabled {
+        services.Worker.Start(ctx)
+        defer services.Worker.Stop()
+    }
+
     // Initialize API components
     apiHandlers := handlers.NewHandlers(services, logger, metrics)  // Pass the entire services struct
-    mw := middleware.NewMiddleware(logger, metrics)
+    mw := middleware.NewMiddleware(services.Auth, logger, metrics)
+    mw.RateLimit.UpdateLimits(cfg.Security.RateLimit)
+    mw.BodyLimit.UpdateLimit(cfg.Server.MaxRequestSize)
     r := router.NewRouter(apiHandlers, mw)
 
+    // Apply log level, rate limit and body limit changes on SIGHUP
+    reloader := config.NewReloader(*configPath, cfg, logger)
+    reloader.OnReload(func(next *config.Config) {
+        if err := logLevel.UnmarshalText([]byte(next.Monitoring.Logging.Level)); err != nil {
+            logger.Error("Invalid log level", zap.Error(err))
+        }
+        mw.RateLimit.UpdateLimits(next.Security.RateLimit)
+        mw.BodyLimit.UpdateLimit(next.Server.MaxRequestSize)
+        services.Attachments.UpdateMaxSize(next.Server.MaxRequestSize)
+    })
+    go reloader.Watch(ctx)
+
     // Create server
     srv := &http.Server{
         Addr:         ":" + cfg.Server.Port,
@@ -94,19 +157,29 @@ func main() {
 
 type dependencies struct {
     db          *mongo.Database
+    repos       *storage.Repositories
+    redis       *redis.Client
     cache       *cache.Cache
     search      *search.SearchEngine
     notifier    *realtime.Notifier
+    r2          *r2.Client
     cleanup     func()
 }
 
 func initializeDependencies(ctx context.Context, cfg *config.Config, logger *zap.Logger, metrics *metrics.Metrics) (*dependencies, error) {
-    // Initialize MongoDB
-    db, err := storage.ConnectMongoDB(ctx, cfg.MongoDB)
+    // Initialize storage repositories for the configured backend
+    repos, db, closeStorage, err := initializeStorage(ctx, cfg, logger, metrics)
     if err != nil {
-        return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+        return nil, err
     }
 
+    // Initialize Redis, shared state of all replicas
+    redisClient := redis.NewClient(&redis.Options{
+        Addr:     cfg.Redis.Addr,
+        Password: cfg.Redis.Password,
+        DB:       cfg.Redis.DB,
+    })
+
     // Initialize Redis-based cache
     cache, err := cache.NewCache(cfg.Redis, cfg.Cache, logger, metrics)
     if err != nil {
@@ -120,12 +193,25 @@ func initializeDependencies(ctx context.Context, cfg *config.Config, logger *zap
     }
 
     // Initialize real-time notifier
-    notifier := realtime.NewNotifier(cfg.Redis, logger, metrics)
+    notifier := realtime.NewNotifier(redisClient, logger, metrics)
+
+    // Initialize R2 object storage
+    r2Client, err := r2.NewClient(r2.Config{
+        AccountID:  cfg.Cloudflare.AccountID,
+        AccessKey:  cfg.R2.AccessKeyID,
+        SecretKey:  cfg.R2.SecretAccessKey,
+        BucketName: cfg.R2.Bucket,
+        Endpoint:   cfg.R2.Endpoint,
+    }, logger)
+    if err != nil {
+        return nil, fmt.Errorf("failed to initialize R2 client: %w", err)
+    }
 
     // Create cleanup function
     cleanup := func() {
-        if err := db.Client().Disconnect(ctx); err != nil {
-            logger.Error("Failed to disconnect MongoDB", zap.Error(err))
+        closeStorage()
+        if err := redisClient.Close(); err != nil {
+            logger.Error("Failed to close Redis client", zap.Error(err))
         }
         if err := cache.Close(); err != nil {
             logger.Error("Failed to close cache", zap.Error(err))
@@ -138,30 +224,131 @@ func initializeDependencies(ctx context.Context, cfg *config.Config, logger *zap
 
     return &dependencies{
         db:       db,
+        repos:    repos,
+        redis:    redisClient,
         cache:    cache,
         search:   searchEngine,
         notifier: notifier,
+        r2:       r2Client,
         cleanup:  cleanup,
     }, nil
 }
 
+// initializeStorage opens the configured storage backend and returns a
+// function closing it. The MongoDB database is also returned for backups
+// and is nil for other backends.
+func initializeStorage(
+    ctx context.Context,
+    cfg *config.Config,
+    logger *zap.Logger,
+    metrics *metrics.Metrics,
+) (*storage.Repositories, *mongo.Database, func(), error) {
+    switch cfg.Storage.Backend {
+    case config.StorageMemory:
+        logger.Warn("Using in-memory storage, data is lost on restart")
+        return memory.NewRepositories(), nil, func() {}, nil
+
+    case config.StorageMongoDB:
+        db, err := mongodb.Connect(ctx, cfg.MongoDB)
+        if err != nil {
+            return nil, nil, nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+        }
+        closeDB := func() {
+            if err := db.Client().Disconnect(ctx); err != nil {
+                logger.Error("Failed to disconnect MongoDB", zap.Error(err))
+            }
+        }
+
+        // Apply schema migrations, replicas wait for whichever one holds the lock
+        if cfg.MongoDB.MigrateOnStart {
+            if _, err := migrations.NewRunner(db, logger).Up(ctx, migrations.RunOptions{}); err != nil {
+                closeDB()
+                return nil, nil, nil, fmt.Errorf("failed to apply migrations: %w", err)
+            }
+        }
+
+        repositories := mongodb.NewRepository(db, logger, metrics)
+        if err := repositories.EnsureIndexes(ctx); err != nil {
+            closeDB()
+            return nil, nil, nil, fmt.Errorf("failed to create MongoDB indexes: %w", err)
+        }
+        return repositories.Repositories(), db, closeDB, nil
+
+    case config.StoragePostgres:
+        db, err := postgres.Connect(ctx, cfg.Postgres)
+        if err != nil {
+            return nil, nil, nil, err
+        }
+        closeDB := func() {
+            if err := db.Close(); err != nil {
+                logger.Error("Failed to close PostgreSQL", zap.Error(err))
+            }
+        }
+
+        // The schema lives in SQL migrations, so they also create the tables
+        if cfg.Postgres.MigrateOnStart {
+            migrator, err := postgres.NewMigrator(db, logger)
+            if err == nil {
+                _, err = migrator.Up(ctx, migrations.RunOptions{})
+            }
+            if err != nil {
+                closeDB()
+                return nil, nil, nil, fmt.Errorf("failed to apply migrations: %w", err)
+            }
+        }
+
+        return postgres.NewRepository(db, logger, metrics).Repositories(), nil, closeDB, nil
+    }
+
+    return nil, nil, nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+}
+
 func initializeServices(
     cfg *config.Config,
     deps *dependencies,
     logger *zap.Logger,
     metrics *metrics.Metrics,
 ) *services.Services {
-    // Initialize storage repositories
-    repositories := storage.NewRepositories(deps.db)
+    // Messages forwarded by Cloudflare Email Workers are sealed with the
+    // encryption key; without one they are refused
+    var cloudflare services.CloudflareDecrypter
+    if cfg.Security.EncryptionKey != "" {
+        cloudflare = security.NewService(&cfg.Security, logger)
+    }
 
     // Initialize services
     return services.New(services.Config{
-        Repositories: repositories,
+        Repositories: deps.repos,
+        Redis:       deps.redis,
         Cache:       deps.cache,
         Search:      deps.search,
         Notifier:    deps.notifier,
+        Cloudflare:  cloudflare,
+        R2:          deps.r2,
         Config:      cfg,
         Logger:      logger,
         Metrics:     metrics,
     })
 }
+
+func initializeBackups(
+    cfg *config.Config,
+    deps *dependencies,
+    logger *zap.Logger,
+    metrics *metrics.Metrics,
+) (*backup.Scheduler, error) {
+    repo := mongodb.NewBackupRepository(deps.db, logger, metrics)
+    manager := backup.NewBackupManager(deps.db, repo, deps.r2, logger, cfg)
+
+    var scratch *mongo.Database
+    if name := cfg.Backup.Verification.ScratchDatabase; name != "" {
+        scratch = deps.db.Client().Database(name)
+    }
+
+    var alerter backup.Alerter = backup.NewLogAlerter(logger)
+    if cfg.Backup.AlertWebhookURL != "" {
+        alerter = backup.NewWebhookAlerter(cfg.Backup.AlertWebhookURL, logger)
+    }
+
+    return backup.NewScheduler(manager, cfg.Backup, scratch, alerter, logger, metrics)
+}
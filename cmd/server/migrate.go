@@ -0,0 +1,195 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "text/tabwriter"
+
+    "github.com/bezata/blockchainml-email/internal/config"
+    "github.com/bezata/blockchainml-email/internal/migrations"
+    "github.com/bezata/blockchainml-email/internal/storage/mongodb"
+    "github.com/bezata/blockchainml-email/internal/storage/postgres"
+    "go.uber.org/zap"
+)
+
+const migrateUsage = `usage: server migrate <command> [flags]
+
+commands:
+  status          list migrations and whether they are applied
+  up              apply pending migrations
+  down            roll back applied migrations
+
+flags:
+  -to int         up: last version to apply; down: version to roll back to
+  -steps int      down: number of migrations to roll back (default 1)
+  -dry-run        print the plan without changing anything
+`
+
+// runMigrate implements the migrate subcommand and returns the exit code
+func runMigrate(ctx context.Context, cfg *config.Config, logger *zap.Logger, args []string) int {
+    if len(args) == 0 {
+        fmt.Fprint(os.Stderr, migrateUsage)
+        return 2
+    }
+
+    command := args[0]
+    fs := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+    fs.Usage = func() { fmt.Fprint(os.Stderr, migrateUsage) }
+    to := fs.Int("to", 0, "")
+    steps := fs.Int("steps", 1, "")
+    dryRun := fs.Bool("dry-run", false, "")
+    if err := fs.Parse(args[1:]); err != nil {
+        return 2
+    }
+
+    runner, err := openMigrator(ctx, cfg, logger)
+    if err != nil {
+        logger.Error("Failed to open migrations", zap.String("backend", cfg.Storage.Backend), zap.Error(err))
+        return 1
+    }
+    defer runner.close()
+
+    opts := migrations.RunOptions{Target: *to, Steps: *steps, DryRun: *dryRun}
+
+    switch command {
+    case "status":
+        statuses, err := runner.status(ctx)
+        if err != nil {
+            logger.Error("Failed to read migration status", zap.Error(err))
+            return 1
+        }
+        printStatus(os.Stdout, statuses)
+
+    case "up", "down":
+        run, verb := runner.up, "apply"
+        if command == "down" {
+            run, verb = runner.down, "roll back"
+        }
+
+        plan, err := run(ctx, opts)
+        printPlan(os.Stdout, verb, plan, *dryRun)
+        if err != nil {
+            logger.Error("Migration failed", zap.String("command", command), zap.Error(err))
+            return 1
+        }
+
+    default:
+        fmt.Fprintf(os.Stderr, "unknown migrate command %q\n\n%s", command, migrateUsage)
+        return 2
+    }
+
+    return 0
+}
+
+// migrationStep is a migration planned or run by either backend
+type migrationStep struct {
+    Version     int
+    Description string
+}
+
+// migrator runs the migrations of the configured storage backend
+type migrator struct {
+    status func(ctx context.Context) ([]migrations.Status, error)
+    up     func(ctx context.Context, opts migrations.RunOptions) ([]migrationStep, error)
+    down   func(ctx context.Context, opts migrations.RunOptions) ([]migrationStep, error)
+    close  func()
+}
+
+func openMigrator(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*migrator, error) {
+    switch cfg.Storage.Backend {
+    case config.StorageMongoDB:
+        db, err := mongodb.Connect(ctx, cfg.MongoDB)
+        if err != nil {
+            return nil, err
+        }
+        runner := migrations.NewRunner(db, logger)
+        steps := func(ms []migrations.Migration) []migrationStep {
+            out := make([]migrationStep, len(ms))
+            for i, m := range ms {
+                out[i] = migrationStep{Version: m.Version, Description: m.Description}
+            }
+            return out
+        }
+        return &migrator{
+            status: runner.Status,
+            up: func(ctx context.Context, opts migrations.RunOptions) ([]migrationStep, error) {
+                done, err := runner.Up(ctx, opts)
+                return steps(done), err
+            },
+            down: func(ctx context.Context, opts migrations.RunOptions) ([]migrationStep, error) {
+                done, err := runner.Down(ctx, opts)
+                return steps(done), err
+            },
+            close: func() { db.Client().Disconnect(ctx) },
+        }, nil
+
+    case config.StoragePostgres:
+        db, err := postgres.Connect(ctx, cfg.Postgres)
+        if err != nil {
+            return nil, err
+        }
+        runner, err := postgres.NewMigrator(db, logger)
+        if err != nil {
+            db.Close()
+            return nil, err
+        }
+        steps := func(ms []postgres.Migration) []migrationStep {
+            out := make([]migrationStep, len(ms))
+            for i, m := range ms {
+                out[i] = migrationStep{Version: m.Version, Description: m.Description}
+            }
+            return out
+        }
+        return &migrator{
+            status: runner.Status,
+            up: func(ctx context.Context, opts migrations.RunOptions) ([]migrationStep, error) {
+                done, err := runner.Up(ctx, opts)
+                return steps(done), err
+            },
+            down: func(ctx context.Context, opts migrations.RunOptions) ([]migrationStep, error) {
+                done, err := runner.Down(ctx, opts)
+                return steps(done), err
+            },
+            close: func() { db.Close() },
+        }, nil
+    }
+
+    return nil, fmt.Errorf("storage backend %q has no migrations", cfg.Storage.Backend)
+}
+
+func printStatus(w io.Writer, statuses []migrations.Status) {
+    tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "VERSION\tSTATUS\tAPPLIED AT\tDESCRIPTION")
+    for _, s := range statuses {
+        state, appliedAt := "pending", "-"
+        if s.Applied {
+            state, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+        }
+        if s.Unknown {
+            state = "unknown"
+        }
+        if !s.Reversible && !s.Unknown {
+            state += " (irreversible)"
+        }
+        fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, state, appliedAt, s.Description)
+    }
+    tw.Flush()
+}
+
+func printPlan(w io.Writer, verb string, plan []migrationStep, dryRun bool) {
+    if len(plan) == 0 {
+        fmt.Fprintf(w, "nothing to %s\n", verb)
+        return
+    }
+
+    prefix := "done:"
+    if dryRun {
+        prefix = "would " + verb + ":"
+    }
+    for _, m := range plan {
+        fmt.Fprintf(w, "%s %04d %s\n", prefix, m.Version, m.Description)
+    }
+}
@@ -0,0 +1,195 @@
+package antivirus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultChunkSize is the size of the chunks content is streamed to clamd
+// in. It must stay below clamd's StreamMaxLength.
+const DefaultChunkSize = 64 << 10
+
+var (
+	// ErrSizeLimit is returned when the content exceeds clamd's
+	// StreamMaxLength. The content was not scanned.
+	ErrSizeLimit = errors.New("antivirus: stream size limit exceeded")
+	// ErrUnavailable is returned when clamd cannot be reached
+	ErrUnavailable = errors.New("antivirus: scanner unavailable")
+)
+
+// Result is the verdict of one scan
+type Result struct {
+	Infected bool
+	// Signature names the detected malware of an infected result
+	Signature string
+}
+
+type Config struct {
+	// Network is "tcp" or "unix"
+	Network string
+	Address string
+	// Timeout bounds a whole scan, including streaming the content
+	Timeout   time.Duration
+	ChunkSize int
+}
+
+// Client talks to clamd over its INSTREAM protocol. Every scan uses its own
+// connection, so a Client is safe for concurrent use.
+type Client struct {
+	network   string
+	address   string
+	timeout   time.Duration
+	chunkSize int
+	dialer    net.Dialer
+}
+
+func NewClient(cfg Config) *Client {
+	network := cfg.Network
+	if network == "" {
+		network = "tcp"
+	}
+	chunkSize := cfg.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	return &Client{
+		network:   network,
+		address:   cfg.Address,
+		timeout:   cfg.Timeout,
+		chunkSize: chunkSize,
+	}
+}
+
+// Ping checks that clamd is up
+func (c *Client) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("antivirus: unexpected reply to PING: %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd and returns its verdict
+func (c *Client) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	reply, err := c.command(ctx, "INSTREAM", r)
+	if err != nil {
+		return nil, err
+	}
+	return parseReply(reply)
+}
+
+// command sends a null terminated command, followed by the chunks of body
+// for INSTREAM, and reads the single reply
+func (c *Client) command(ctx context.Context, name string, body io.Reader) (string, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	conn, err := c.dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock reads and writes when ctx is canceled without a deadline
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := io.WriteString(conn, "z"+name+"\x00"); err != nil {
+		return "", c.connError(ctx, err)
+	}
+
+	if body != nil {
+		if err := c.stream(conn, body); err != nil {
+			// clamd closes the connection once the stream is too long
+			// and has written its reply by then
+			if reply, readErr := readReply(conn); readErr == nil && reply != "" {
+				return reply, nil
+			}
+			return "", c.connError(ctx, err)
+		}
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return "", c.connError(ctx, err)
+	}
+	return reply, nil
+}
+
+// stream writes body as length prefixed chunks, ending with an empty chunk
+func (c *Client) stream(w io.Writer, body io.Reader) error {
+	buf := make([]byte, 4+c.chunkSize)
+	for {
+		n, err := io.ReadFull(body, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("antivirus: failed to read content: %w", err)
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func (c *Client) connError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, ctx.Err())
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+func readReply(r io.Reader) (string, error) {
+	reply, err := bufio.NewReader(r).ReadBytes(0)
+	if err != nil && !(err == io.EOF && len(reply) > 0) {
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseReply reads an INSTREAM reply: "stream: OK",
+// "stream: <signature> FOUND" or "<message> ERROR"
+func parseReply(reply string) (*Result, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	case strings.HasPrefix(reply, "INSTREAM size limit exceeded"):
+		return nil, ErrSizeLimit
+	case strings.HasSuffix(reply, " ERROR"):
+		return nil, fmt.Errorf("antivirus: scan failed: %s", strings.TrimSuffix(reply, " ERROR"))
+	}
+	return nil, fmt.Errorf("antivirus: unexpected reply: %q", reply)
+}
@@ -0,0 +1,126 @@
+package antivirus_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/antivirus"
+	"github.com/bezata/blockchainml-email/internal/antivirus/clamdtest"
+)
+
+func TestScan(t *testing.T) {
+	server := clamdtest.NewServer(t)
+	client := antivirus.NewClient(antivirus.Config{Address: server.Addr(), Timeout: 5 * time.Second, ChunkSize: 16})
+	ctx := context.Background()
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	// Spans several chunks, so the stream is reassembled in order
+	clean := strings.Repeat("quarterly figures ", 10)
+	result, err := client.Scan(ctx, strings.NewReader(clean))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Infected {
+		t.Errorf("clean content reported infected with %s", result.Signature)
+	}
+	if scanned := server.Scanned(); len(scanned) != 1 || string(scanned[0]) != clean {
+		t.Errorf("clamd received %q, want %q", scanned, clean)
+	}
+
+	result, err = client.Scan(ctx, strings.NewReader("prefix "+clamdtest.Marker+" suffix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Infected || result.Signature != clamdtest.Signature {
+		t.Errorf("infected content got %+v, want signature %s", result, clamdtest.Signature)
+	}
+}
+
+func TestScanSizeLimit(t *testing.T) {
+	server := clamdtest.NewServer(t)
+	server.MaxLength = 64
+	client := antivirus.NewClient(antivirus.Config{Address: server.Addr(), Timeout: 5 * time.Second, ChunkSize: 16})
+
+	_, err := client.Scan(context.Background(), strings.NewReader(strings.Repeat("x", 1024)))
+	if !errors.Is(err, antivirus.ErrSizeLimit) {
+		t.Fatalf("got %v, want ErrSizeLimit", err)
+	}
+}
+
+func TestScanConnectionErrors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Refused", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := listener.Addr().String()
+		listener.Close()
+
+		client := antivirus.NewClient(antivirus.Config{Address: addr, Timeout: time.Second})
+		if _, err := client.Scan(ctx, strings.NewReader("content")); !errors.Is(err, antivirus.ErrUnavailable) {
+			t.Fatalf("got %v, want ErrUnavailable", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		// Accepts connections but never replies
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			var conns []net.Conn
+			defer func() {
+				for _, conn := range conns {
+					conn.Close()
+				}
+			}()
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conns = append(conns, conn)
+			}
+		}()
+
+		client := antivirus.NewClient(antivirus.Config{Address: listener.Addr().String(), Timeout: 200 * time.Millisecond})
+		if _, err := client.Scan(ctx, strings.NewReader("content")); !errors.Is(err, antivirus.ErrUnavailable) {
+			t.Fatalf("got %v, want ErrUnavailable", err)
+		}
+	})
+
+	t.Run("ClosedMidStream", func(t *testing.T) {
+		// Hangs up without a reply as soon as the command is read
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Read(make([]byte, 16))
+				conn.Close()
+			}
+		}()
+
+		client := antivirus.NewClient(antivirus.Config{Address: listener.Addr().String(), Timeout: time.Second})
+		if _, err := client.Scan(ctx, strings.NewReader(strings.Repeat("x", 1<<20))); err == nil {
+			t.Fatal("scan succeeded without a reply")
+		}
+	})
+}
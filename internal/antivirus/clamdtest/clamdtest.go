@@ -0,0 +1,123 @@
+// Package clamdtest runs a fake clamd speaking the PING and INSTREAM
+// commands, for tests of code that scans content
+package clamdtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// Signature is the malware name reported for content containing Marker
+const Signature = "Eicar-Test-Signature"
+
+// Marker is a harmless string the fake detects as malware
+const Marker = "EICAR-TEST-FILE"
+
+// Server is a fake clamd on a local TCP port. Content containing Marker is
+// reported infected; content longer than MaxLength is refused the way
+// clamd refuses streams longer than StreamMaxLength.
+type Server struct {
+	MaxLength int
+
+	listener net.Listener
+	mu       sync.Mutex
+	scanned  [][]byte
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a fake clamd, stopped when the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{MaxLength: 1 << 20, listener: listener}
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// Addr is the TCP address of the fake
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Scanned returns the content of every completed scan
+func (s *Server) Scanned() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.scanned...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	switch command {
+	case "zPING\x00":
+		io.WriteString(conn, "PONG\x00")
+	case "zINSTREAM\x00":
+		var content []byte
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if len(content)+int(size) > s.MaxLength {
+				io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+				return
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			content = append(content, chunk...)
+		}
+
+		s.mu.Lock()
+		s.scanned = append(s.scanned, content)
+		s.mu.Unlock()
+
+		if bytes.Contains(content, []byte(Marker)) {
+			io.WriteString(conn, "stream: "+Signature+" FOUND\x00")
+			return
+		}
+		io.WriteString(conn, "stream: OK\x00")
+	default:
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// accessTokenHeader carries the token Cloudflare Access adds to the
+// requests it lets through
+const accessTokenHeader = "Cf-Access-Jwt-Assertion"
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// Login starts a session for the staff member Cloudflare Access
+// authenticated
+func (h *AuthHandler) Login(c *gin.Context) {
+	token := c.GetHeader(accessTokenHeader)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No Cloudflare Access token"})
+		return
+	}
+
+	tokens, err := h.authService.Login(c.Request.Context(), token)
+	if err != nil {
+		h.respondError(c, err, "failed to log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tokens, err := h.authService.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		h.respondError(c, err, "failed to refresh token")
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *AuthHandler) respondError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, services.ErrUnauthenticated):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrLoginDisabled):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrLoginNotEnabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error(msg, zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
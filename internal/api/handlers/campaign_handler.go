@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bezata/blockchainml-email/internal/api/middleware"
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxRecipientImport bounds the size of an imported CSV file
+const maxRecipientImport = 16 << 20
+
+type CampaignRequest struct {
+	Name       string            `json:"name" binding:"required"`
+	TemplateID string            `json:"templateId" binding:"required"`
+	Subject    string            `json:"subject"`
+	Signature  bool              `json:"signature"`
+	Variables  map[string]string `json:"variables"`
+	// Rate is the number of emails sent per minute
+	Rate int `json:"rate" binding:"omitempty,min=1"`
+}
+
+type CampaignRecipient struct {
+	Email     string            `json:"email" binding:"required"`
+	Name      string            `json:"name"`
+	Variables map[string]string `json:"variables"`
+}
+
+type AddRecipientsRequest struct {
+	Recipients []CampaignRecipient `json:"recipients" binding:"required,min=1,dive"`
+}
+
+func (h *CampaignHandler) CreateCampaign(c *gin.Context) {
+	var req CampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	campaign, err := h.campaignService.CreateCampaign(c.Request.Context(), c.GetString(middleware.ContextUserID), services.CampaignParams{
+		Name:       req.Name,
+		TemplateID: req.TemplateID,
+		Subject:    req.Subject,
+		Signature:  req.Signature,
+		Variables:  req.Variables,
+		Rate:       req.Rate,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to create campaign")
+		return
+	}
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+// ListCampaigns lists the caller's campaigns, or every campaign for admins,
+// optionally of one status
+func (h *CampaignHandler) ListCampaigns(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	campaigns, err := h.campaignService.ListCampaigns(c.Request.Context(), c.GetString(middleware.ContextUserID),
+		c.Query("status"), c.Query("cursor"), limit)
+	if err != nil {
+		h.respondError(c, err, "failed to list campaigns")
+		return
+	}
+
+	response := gin.H{"campaigns": campaigns}
+	if len(campaigns) > 0 && len(campaigns) == storage.PageSize(limit) {
+		response["nextCursor"] = storage.CampaignCursor(campaigns[len(campaigns)-1])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *CampaignHandler) GetCampaign(c *gin.Context) {
+	campaign, err := h.campaignService.GetCampaign(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get campaign")
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+func (h *CampaignHandler) DeleteCampaign(c *gin.Context) {
+	if err := h.campaignService.DeleteCampaign(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to delete campaign")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// AddRecipients adds a JSON list of recipients, or imports a CSV file sent
+// as text/csv with an email column, an optional name column and a column
+// per template variable
+func (h *CampaignHandler) AddRecipients(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetString(middleware.ContextUserID)
+
+	var (
+		added int
+		err   error
+	)
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, maxRecipientImport)
+		added, err = h.campaignService.ImportRecipients(ctx, userID, c.Param("id"), body)
+	} else {
+		var req AddRecipientsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		inputs := make([]services.RecipientInput, len(req.Recipients))
+		for i, recipient := range req.Recipients {
+			inputs[i] = services.RecipientInput{Email: recipient.Email, Name: recipient.Name, Variables: recipient.Variables}
+		}
+		added, err = h.campaignService.AddRecipients(ctx, userID, c.Param("id"), inputs)
+	}
+	if err != nil {
+		h.respondError(c, err, "failed to add recipients")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"added": added})
+}
+
+// ListRecipients lists a campaign's recipients, optionally of one status
+func (h *CampaignHandler) ListRecipients(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	recipients, err := h.campaignService.ListRecipients(c.Request.Context(), c.GetString(middleware.ContextUserID),
+		c.Param("id"), c.Query("status"), c.Query("cursor"), limit)
+	if err != nil {
+		h.respondError(c, err, "failed to list recipients")
+		return
+	}
+
+	response := gin.H{"recipients": recipients}
+	if len(recipients) > 0 && len(recipients) == storage.PageSize(limit) {
+		response["nextCursor"] = storage.RecipientCursor(recipients[len(recipients)-1])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *CampaignHandler) StartCampaign(c *gin.Context) {
+	campaign, err := h.campaignService.StartCampaign(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to start campaign")
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+func (h *CampaignHandler) PauseCampaign(c *gin.Context) {
+	campaign, err := h.campaignService.PauseCampaign(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to pause campaign")
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+func (h *CampaignHandler) CancelCampaign(c *gin.Context) {
+	campaign, err := h.campaignService.CancelCampaign(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to cancel campaign")
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// Progress returns a campaign with the number of its recipients by status
+func (h *CampaignHandler) Progress(c *gin.Context) {
+	progress, err := h.campaignService.Progress(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get campaign progress")
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// Unsubscribe handles the unsubscribe link of a campaign email, both when
+// followed by the recipient and as a one-click POST from their mail client
+// (RFC 8058)
+func (h *CampaignHandler) Unsubscribe(c *gin.Context) {
+	if err := h.campaignService.Unsubscribe(c.Request.Context(), c.Param("token")); err != nil {
+		h.respondError(c, err, "failed to unsubscribe")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "You have been unsubscribed"})
+}
+
+// respondError maps service errors to status codes and logs unexpected ones
+func (h *CampaignHandler) respondError(c *gin.Context, err error, msg string) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrCampaignNotFound), errors.Is(err, services.ErrUnknownUnsubscribe),
+		errors.Is(err, services.ErrTemplateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCampaignAccessDenied), errors.Is(err, services.ErrTemplateAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCampaignStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Recipient file too large"})
+	case errors.Is(err, services.ErrInvalidCampaign), errors.Is(err, services.ErrTooManyRecipients),
+		errors.Is(err, storage.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error(msg, zap.String("campaign_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
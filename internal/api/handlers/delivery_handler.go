@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDeliveryEvents bounds the body of a delivery event webhook
+const maxDeliveryEvents = 1 << 20
+
+// DeliveryEvents receives the signed delivery events a transport's
+// provider posts after accepting messages
+func (h *EmailHandler) DeliveryEvents(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxDeliveryEvents))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+		return
+	}
+
+	recorded, err := h.emailService.ReceiveDeliveryEvents(c.Request.Context(), c.Param("transport"), c.Request.Header, body)
+	if err != nil {
+		h.respondError(c, err, "failed to record delivery events")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recorded": recorded})
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bezata/blockchainml-email/internal/api/middleware"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// DraftRequest is the content of a draft. Every save sends the whole
+// draft; recipients are only validated when it is sent.
+//
+// Drafts are versioned. Responses carry the version as ETag, and changes
+// are made against it with If-Match, so a draft open in two tabs is not
+// overwritten by the one with stale content. A change based on an old
+// version fails with 412 and the current draft.
+type DraftRequest struct {
+	To       []string     `json:"to"`
+	CC       []string     `json:"cc,omitempty"`
+	BCC      []string     `json:"bcc,omitempty"`
+	Subject  string       `json:"subject"`
+	Content  DraftContent `json:"content"`
+	ThreadID *string      `json:"threadId,omitempty"`
+	// Uploads are keys of staged attachments to attach on creation
+	Uploads []string `json:"uploads,omitempty"`
+	// Version is the version the edit is based on, for clients that
+	// cannot send If-Match
+	Version *int64 `json:"version,omitempty"`
+}
+
+type DraftContent struct {
+	Text string `json:"text"`
+	HTML string `json:"html,omitempty"`
+}
+
+type DraftAttachmentsRequest struct {
+	Uploads []string `json:"uploads" binding:"required,min=1"`
+}
+
+// CreateDraft stores a new draft in the caller's mailbox
+func (h *EmailHandler) CreateDraft(c *gin.Context) {
+	var req DraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, fmt.Errorf("%w: %w", errInvalidRequest, err), "invalid request body")
+		return
+	}
+
+	draft, err := h.emailService.CreateDraft(c.Request.Context(), c.GetString(middleware.ContextUserID), req.params(), req.Uploads)
+	if err != nil {
+		h.respondError(c, err, "failed to create draft")
+		return
+	}
+
+	setDraftETag(c, draft)
+	c.JSON(http.StatusCreated, draft)
+}
+
+// ListDrafts returns the caller's drafts, most recently created first
+func (h *EmailHandler) ListDrafts(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	drafts, err := h.emailService.ListDrafts(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Query("cursor"), limit)
+	if err != nil {
+		h.respondError(c, err, "failed to list drafts")
+		return
+	}
+
+	response := gin.H{"drafts": drafts}
+	if len(drafts) > 0 && len(drafts) == storage.PageSize(limit) {
+		response["nextCursor"] = storage.EmailCursor(drafts[len(drafts)-1])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetDraft returns a draft with its revision history
+func (h *EmailHandler) GetDraft(c *gin.Context) {
+	draft, err := h.emailService.GetDraft(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get draft")
+		return
+	}
+
+	setDraftETag(c, draft)
+	c.JSON(http.StatusOK, draft)
+}
+
+// UpdateDraft saves a draft. The version it is based on is required, from
+// If-Match or the request body.
+func (h *EmailHandler) UpdateDraft(c *gin.Context) {
+	var req DraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, fmt.Errorf("%w: %w", errInvalidRequest, err), "invalid request body")
+		return
+	}
+
+	version, err := draftVersion(c, req.Version)
+	if err != nil {
+		h.respondError(c, err, "invalid draft version")
+		return
+	}
+	if version == services.AnyVersion {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match or version is required"})
+		return
+	}
+
+	draft, err := h.emailService.UpdateDraft(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"), version, req.params())
+	if err != nil {
+		h.respondDraftError(c, err, "failed to update draft")
+		return
+	}
+
+	setDraftETag(c, draft)
+	c.JSON(http.StatusOK, draft)
+}
+
+// DeleteDraft deletes a draft and its attachments
+func (h *EmailHandler) DeleteDraft(c *gin.Context) {
+	if err := h.emailService.DeleteDraft(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to delete draft")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// AddDraftAttachments attaches staged uploads to a draft
+func (h *EmailHandler) AddDraftAttachments(c *gin.Context) {
+	var req DraftAttachmentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, fmt.Errorf("%w: %w", errInvalidRequest, err), "invalid request body")
+		return
+	}
+
+	version, err := draftVersion(c, nil)
+	if err != nil {
+		h.respondError(c, err, "invalid draft version")
+		return
+	}
+
+	draft, err := h.emailService.AddDraftAttachments(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"), version, req.Uploads)
+	if err != nil {
+		h.respondDraftError(c, err, "failed to attach to draft")
+		return
+	}
+
+	setDraftETag(c, draft)
+	c.JSON(http.StatusOK, draft)
+}
+
+// RemoveDraftAttachment detaches and deletes an attachment of a draft
+func (h *EmailHandler) RemoveDraftAttachment(c *gin.Context) {
+	version, err := draftVersion(c, nil)
+	if err != nil {
+		h.respondError(c, err, "invalid draft version")
+		return
+	}
+
+	draft, err := h.emailService.RemoveDraftAttachment(c.Request.Context(), c.GetString(middleware.ContextUserID),
+		c.Param("id"), version, c.Param("filename"))
+	if err != nil {
+		h.respondDraftError(c, err, "failed to remove draft attachment")
+		return
+	}
+
+	setDraftETag(c, draft)
+	c.JSON(http.StatusOK, draft)
+}
+
+// SendDraft sends a draft as it was last saved. With If-Match it is only
+// sent if no other save came in since.
+func (h *EmailHandler) SendDraft(c *gin.Context) {
+	version, err := draftVersion(c, nil)
+	if err != nil {
+		h.respondError(c, err, "invalid draft version")
+		return
+	}
+
+	sent, err := h.emailService.SendDraft(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"), version)
+	if err != nil {
+		h.respondDraftError(c, err, "failed to send draft")
+		return
+	}
+
+	c.JSON(http.StatusOK, sent)
+}
+
+// respondDraftError answers a conflicting change with the current draft,
+// so the client can merge its edits
+func (h *EmailHandler) respondDraftError(c *gin.Context, err error, msg string) {
+	if !errors.Is(err, services.ErrDraftConflict) {
+		h.respondError(c, err, msg)
+		return
+	}
+
+	current, getErr := h.emailService.GetDraft(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if getErr != nil {
+		h.respondError(c, getErr, msg)
+		return
+	}
+	setDraftETag(c, current)
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error(), "draft": current})
+}
+
+func (r *DraftRequest) params() services.DraftParams {
+	return services.DraftParams{
+		To:      r.To,
+		CC:      r.CC,
+		BCC:     r.BCC,
+		Subject: r.Subject,
+		Content: email.EmailContent{
+			Text: r.Content.Text,
+			HTML: r.Content.HTML,
+		},
+		ThreadID: r.ThreadID,
+	}
+}
+
+func setDraftETag(c *gin.Context, draft *email.Email) {
+	if draft.Draft != nil {
+		c.Header("ETag", strconv.Quote(strconv.FormatInt(draft.Draft.Version, 10)))
+	}
+}
+
+// draftVersion returns the version of If-Match, else of the body, else
+// services.AnyVersion
+func draftVersion(c *gin.Context, body *int64) (int64, error) {
+	match := strings.TrimSpace(c.GetHeader("If-Match"))
+	if match == "" || match == "*" {
+		if body != nil {
+			return *body, nil
+		}
+		return services.AnyVersion, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(match, "W/"), `"`), 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("%w: invalid If-Match %q", errInvalidRequest, match)
+	}
+	return version, nil
+}
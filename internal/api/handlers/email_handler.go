@@ -1,62 +1,367 @@
 package handlers
 
 import (
-    "net/http"
-    "encoding/json"
-    "github.com/bezata/blockchainml-email/internal/services"  // Add this import 
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bezata/blockchainml-email/internal/api/middleware"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/transport"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
 )
 
+// maxMessagePartSize bounds the JSON "message" part of a multipart send
+const maxMessagePartSize = 1 << 20
+
+// errInvalidRequest marks malformed send requests
+var errInvalidRequest = errors.New("invalid request")
+
 type SendEmailRequest struct {
-    To          []string          `json:"to" validate:"required,min=1,dive,email"`
-    Subject     string            `json:"subject" validate:"required"`
-    Content     EmailContent      `json:"content" validate:"required"`
-    Attachments []AttachmentInput `json:"attachments,omitempty"`
-    ThreadID    *string           `json:"threadId,omitempty"`
+	To      []string      `json:"to" binding:"required,min=1,dive,email"`
+	CC      []string      `json:"cc,omitempty" binding:"dive,email"`
+	Subject string        `json:"subject" binding:"required_without=TemplateID"`
+	Content *EmailContent `json:"content,omitempty" binding:"required_without=TemplateID,excluded_with=TemplateID"`
+	// TemplateID renders the content, and the subject unless given, from a
+	// template with Variables
+	TemplateID string            `json:"templateId,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	// Signature appends the sender's signature
+	Signature bool `json:"signature,omitempty"`
+	// Attachments are sent inline as base64. Large files should be sent as
+	// multipart/form-data or uploaded beforehand and listed in Uploads.
+	Attachments []AttachmentInput `json:"attachments,omitempty" binding:"dive"`
+	// Uploads are keys of attachments staged with the upload endpoints
+	Uploads  []string `json:"uploads,omitempty"`
+	ThreadID *string  `json:"threadId,omitempty"`
 }
 
 type EmailContent struct {
-    Text string `json:"text" validate:"required"`
-    HTML string `json:"html,omitempty"`
+	Text string `json:"text" binding:"required"`
+	HTML string `json:"html,omitempty"`
 }
 
 type AttachmentInput struct {
-    Filename    string `json:"filename" validate:"required"`
-    ContentType string `json:"contentType" validate:"required"`
-    Content     []byte `json:"content" validate:"required"`
-}
-
-func (h *EmailHandler) SendEmail(w http.ResponseWriter, r *http.Request) {
-    ctx := r.Context()
-    
-    var req SendEmailRequest
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        h.respondError(w, http.StatusBadRequest, "Invalid request body")
-        return
-    }
-
-    email, err := h.emailService.SendEmail(ctx, services.SendEmailParams{
-        From:        ctx.Value("userId").(string),
-        To:          req.To,
-        Subject:     req.Subject,
-        Content:     req.Content,
-        Attachments: req.Attachments,
-        ThreadID:    req.ThreadID,
-    })
-    if err != nil {
-        h.logger.Error("failed to send email", zap.Error(err))
-        h.respondError(w, http.StatusInternalServerError, "Failed to send email")
-        return
-    }
-
-    h.respondJSON(w, http.StatusCreated, email)
-}
-
-func (h *EmailHandler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
-    w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(status)
-    json.NewEncoder(w).Encode(data)
-}
-
-func (h *EmailHandler) respondError(w http.ResponseWriter, status int, message string) {
-    h.respondJSON(w, status, map[string]string{"error": message})
+	Filename    string `json:"filename" binding:"required"`
+	ContentType string `json:"contentType" binding:"required"`
+	Content     []byte `json:"content" binding:"required"`
+}
+
+// SendEmail accepts either a JSON SendEmailRequest or multipart/form-data
+// with the request as JSON in a "message" field and any number of file
+// fields. Files are streamed to storage as they arrive, so their size is
+// bounded by the request size limit rather than by memory.
+func (h *EmailHandler) SendEmail(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetString(middleware.ContextUserID)
+
+	var req SendEmailRequest
+	var staged []string
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		var err error
+		staged, err = h.readMultipart(c, &req)
+		if err != nil {
+			h.attachmentService.Discard(ctx, userID, staged)
+			h.respondError(c, err, "failed to read attachments")
+			return
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, fmt.Errorf("%w: %w", errInvalidRequest, err), "invalid request body")
+		return
+	}
+
+	attachments := make([]email.AttachmentInput, len(req.Attachments))
+	for i, a := range req.Attachments {
+		attachments[i] = email.AttachmentInput{
+			Filename:    a.Filename,
+			Content:     bytes.NewReader(a.Content),
+			ContentType: a.ContentType,
+		}
+	}
+
+	var content email.EmailContent
+	if req.Content != nil {
+		content = email.EmailContent{
+			Text: req.Content.Text,
+			HTML: req.Content.HTML,
+		}
+	}
+
+	sent, err := h.emailService.SendEmail(ctx, services.SendEmailParams{
+		From:        userID,
+		To:          req.To,
+		CC:          req.CC,
+		Subject:     req.Subject,
+		Content:     content,
+		TemplateID:  req.TemplateID,
+		Variables:   req.Variables,
+		Signature:   req.Signature,
+		Attachments: attachments,
+		Uploads:     append(req.Uploads, staged...),
+		ThreadID:    req.ThreadID,
+	})
+	if err != nil {
+		h.attachmentService.Discard(ctx, userID, staged)
+		h.respondError(c, err, "failed to send email")
+		return
+	}
+
+	c.JSON(http.StatusCreated, sent)
+}
+
+// SearchEmails searches a mailbox, the caller's own unless another is
+// given, for the text of q, best match first. Only the postgres storage
+// backend has full-text search; with mongodb or memory storage it answers
+// 501 Not Implemented.
+func (h *EmailHandler) SearchEmails(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	emails, err := h.emailService.SearchEmails(c.Request.Context(), c.GetString(middleware.ContextUserID), services.SearchParams{
+		Text:    c.Query("q"),
+		Mailbox: c.Query("mailbox"),
+		Labels:  c.QueryArray("label"),
+		Limit:   limit,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to search emails")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"emails": emails})
+}
+
+// ListEmails returns a page of a mailbox, the caller's own unless another
+// is given, most recently created first
+func (h *EmailHandler) ListEmails(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	emails, err := h.emailService.ListEmails(c.Request.Context(), c.GetString(middleware.ContextUserID), services.ListEmailsParams{
+		Mailbox: c.Query("mailbox"),
+		Labels:  c.QueryArray("label"),
+		Cursor:  c.Query("cursor"),
+		Limit:   limit,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to list emails")
+		return
+	}
+
+	response := gin.H{"emails": emails}
+	if len(emails) > 0 && len(emails) == storage.PageSize(limit) {
+		response["nextCursor"] = storage.EmailCursor(emails[len(emails)-1])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetEmail returns an email of a mailbox the caller has access to
+func (h *EmailHandler) GetEmail(c *gin.Context) {
+	e, err := h.emailService.GetEmail(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get email")
+		return
+	}
+
+	c.JSON(http.StatusOK, e)
+}
+
+// GetAttachmentURL returns a short lived download URL for an attachment,
+// once the caller's access to the email has been checked
+func (h *EmailHandler) GetAttachmentURL(c *gin.Context) {
+	url, err := h.emailService.GetAttachmentURL(c.Request.Context(), c.GetString(middleware.ContextUserID),
+		c.Param("id"), c.Param("filename"))
+	if err != nil {
+		h.respondError(c, err, "failed to get attachment URL")
+		return
+	}
+
+	c.JSON(http.StatusOK, url)
+}
+
+// GetDelivery returns the delivery state and attempt history of every
+// recipient of a sent email
+func (h *EmailHandler) GetDelivery(c *gin.Context) {
+	delivery, err := h.emailService.GetDelivery(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get delivery state")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"delivery": delivery})
+}
+
+// ReplyRequest is the new content of a reply or forward. Recipients,
+// subject and quoting are derived from the original email.
+type ReplyRequest struct {
+	Content EmailContent `json:"content" binding:"required"`
+	// To and CC add recipients; forwards require To
+	To      []string `json:"to,omitempty" binding:"dive,email"`
+	CC      []string `json:"cc,omitempty" binding:"dive,email"`
+	Uploads []string `json:"uploads,omitempty"`
+}
+
+// Reply answers the sender of an email
+func (h *EmailHandler) Reply(c *gin.Context) {
+	h.sendReply(c, func(params services.ReplyParams) (*email.Email, error) {
+		return h.emailService.Reply(c.Request.Context(), c.Param("id"), false, params)
+	})
+}
+
+// ReplyAll answers the sender and every other recipient of an email
+func (h *EmailHandler) ReplyAll(c *gin.Context) {
+	h.sendReply(c, func(params services.ReplyParams) (*email.Email, error) {
+		return h.emailService.Reply(c.Request.Context(), c.Param("id"), true, params)
+	})
+}
+
+// Forward sends an email with its attachments on to new recipients
+func (h *EmailHandler) Forward(c *gin.Context) {
+	h.sendReply(c, func(params services.ReplyParams) (*email.Email, error) {
+		return h.emailService.Forward(c.Request.Context(), c.Param("id"), params)
+	})
+}
+
+func (h *EmailHandler) sendReply(c *gin.Context, send func(services.ReplyParams) (*email.Email, error)) {
+	var req ReplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, fmt.Errorf("%w: %w", errInvalidRequest, err), "invalid request body")
+		return
+	}
+
+	sent, err := send(services.ReplyParams{
+		From: c.GetString(middleware.ContextUserID),
+		Content: email.EmailContent{
+			Text: req.Content.Text,
+			HTML: req.Content.HTML,
+		},
+		To:      req.To,
+		CC:      req.CC,
+		Uploads: req.Uploads,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to send email")
+		return
+	}
+
+	c.JSON(http.StatusCreated, sent)
+}
+
+// MarkSpam moves an email to spam and trains the caller's spam filter
+func (h *EmailHandler) MarkSpam(c *gin.Context) {
+	h.markSpam(c, true)
+}
+
+// MarkNotSpam moves an email back to the inbox and trains the caller's
+// spam filter
+func (h *EmailHandler) MarkNotSpam(c *gin.Context) {
+	h.markSpam(c, false)
+}
+
+func (h *EmailHandler) markSpam(c *gin.Context, isSpam bool) {
+	e, err := h.emailService.MarkSpam(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"), isSpam)
+	if err != nil {
+		h.respondError(c, err, "failed to mark email")
+		return
+	}
+
+	c.JSON(http.StatusOK, e)
+}
+
+// readMultipart decodes the "message" field into req and stages every file
+// field, returning the keys of the staged files
+func (h *EmailHandler) readMultipart(c *gin.Context, req *SendEmailRequest) ([]string, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+
+	var staged []string
+	var message bool
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return staged, err
+		}
+
+		switch {
+		case part.FileName() != "":
+			attachment, err := h.attachmentService.Stage(c.Request.Context(), c.GetString(middleware.ContextUserID),
+				part.FileName(), part.Header.Get("Content-Type"), part)
+			if err != nil {
+				part.Close()
+				return staged, err
+			}
+			staged = append(staged, attachment.R2Key)
+		case part.FormName() == "message":
+			data, err := io.ReadAll(io.LimitReader(part, maxMessagePartSize+1))
+			if err != nil {
+				part.Close()
+				return staged, err
+			}
+			if len(data) > maxMessagePartSize {
+				part.Close()
+				return staged, fmt.Errorf("%w: message field is too large", errInvalidRequest)
+			}
+			if err := json.Unmarshal(data, req); err != nil {
+				part.Close()
+				return staged, fmt.Errorf("%w: %v", errInvalidRequest, err)
+			}
+			message = true
+		}
+		part.Close()
+	}
+
+	if !message {
+		return staged, fmt.Errorf("%w: message field is required", errInvalidRequest)
+	}
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return staged, fmt.Errorf("%w: %v", errInvalidRequest, err)
+	}
+	return staged, nil
+}
+
+func (h *EmailHandler) respondError(c *gin.Context, err error, msg string) {
+	if status, ok := attachmentErrorStatus(err); ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrEmailNotFound), errors.Is(err, services.ErrAttachmentNotFound),
+		errors.Is(err, services.ErrDraftNotFound), errors.Is(err, services.ErrTemplateNotFound),
+		errors.Is(err, services.ErrSuppressionNotFound), errors.Is(err, services.ErrUnknownTransport),
+		errors.Is(err, services.ErrInboundDisabled), errors.Is(err, services.ErrLabelNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, transport.ErrInvalidSignature), errors.Is(err, services.ErrInvalidEnvelope):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailAccessDenied), errors.Is(err, services.ErrTemplateAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDraftConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSuppressionExists), errors.Is(err, services.ErrLabelExists),
+		errors.Is(err, services.ErrLabelSystem), errors.Is(err, services.ErrEnvelopeReplayed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSearchUnavailable):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRecipientSuppressed):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidEmail), errors.Is(err, services.ErrInvalidTemplate),
+		errors.Is(err, errInvalidRequest), errors.Is(err, storage.ErrInvalidCursor),
+		errors.Is(err, transport.ErrInvalidEvents), errors.Is(err, services.ErrInvalidLabel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error(msg, zap.String("user_id", c.GetString(middleware.ContextUserID)), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
 }
@@ -8,34 +8,57 @@ import (
 
 type Handlers struct {
     Email     *EmailHandler
+    Upload    *UploadHandler
     Staff     *StaffHandler
     Thread    *ThreadHandler
     Auth      *AuthHandler
     Realtime  *RealtimeHandler
+    Template  *TemplateHandler
+    Campaign  *CampaignHandler
 }
 
 func NewHandlers(services *services.Services, logger *zap.Logger, metrics *metrics.Metrics) *Handlers {
     return &Handlers{
-        Email:    NewEmailHandler(services.Email, logger, metrics),
+        Email:    NewEmailHandler(services.Email, services.Attachments, logger, metrics),
+        Upload:   NewUploadHandler(services.Attachments, logger, metrics),
         Staff:    NewStaffHandler(services.Staff, logger, metrics),
         Thread:   NewThreadHandler(services.Email, logger, metrics),
         Auth:     NewAuthHandler(services.Auth, logger, metrics),
         Realtime: NewRealtimeHandler(services.Email, logger, metrics),
+        Template: NewTemplateHandler(services.Templates, logger, metrics),
+        Campaign: NewCampaignHandler(services.Campaigns, logger, metrics),
     }
 }
 
 // internal/api/handlers/email_handler.go
 type EmailHandler struct {
-    emailService *services.EmailService
-    logger       *zap.Logger
-    metrics      *metrics.Metrics
+    emailService      *services.EmailService
+    attachmentService *services.AttachmentService
+    logger            *zap.Logger
+    metrics           *metrics.Metrics
 }
 
-func NewEmailHandler(emailService *services.EmailService, logger *zap.Logger, metrics *metrics.Metrics) *EmailHandler {
+func NewEmailHandler(emailService *services.EmailService, attachmentService *services.AttachmentService, logger *zap.Logger, metrics *metrics.Metrics) *EmailHandler {
     return &EmailHandler{
-        emailService: emailService,
-        logger:      logger,
-        metrics:     metrics,
+        emailService:      emailService,
+        attachmentService: attachmentService,
+        logger:            logger,
+        metrics:           metrics,
+    }
+}
+
+// internal/api/handlers/upload_handler.go
+type UploadHandler struct {
+    attachmentService *services.AttachmentService
+    logger            *zap.Logger
+    metrics           *metrics.Metrics
+}
+
+func NewUploadHandler(attachmentService *services.AttachmentService, logger *zap.Logger, metrics *metrics.Metrics) *UploadHandler {
+    return &UploadHandler{
+        attachmentService: attachmentService,
+        logger:            logger,
+        metrics:           metrics,
     }
 }
 
@@ -97,4 +120,34 @@ func NewRealtimeHandler(emailService *services.EmailService, logger *zap.Logger,
         logger:      logger,
         metrics:     metrics,
     }
-}
\ No newline at end of file
+}
+
+// internal/api/handlers/template_handler.go
+type TemplateHandler struct {
+    templateService *services.TemplateService
+    logger          *zap.Logger
+    metrics         *metrics.Metrics
+}
+
+func NewTemplateHandler(templateService *services.TemplateService, logger *zap.Logger, metrics *metrics.Metrics) *TemplateHandler {
+    return &TemplateHandler{
+        templateService: templateService,
+        logger:          logger,
+        metrics:         metrics,
+    }
+}
+
+// internal/api/handlers/campaign_handler.go
+type CampaignHandler struct {
+    campaignService *services.CampaignService
+    logger          *zap.Logger
+    metrics         *metrics.Metrics
+}
+
+func NewCampaignHandler(campaignService *services.CampaignService, logger *zap.Logger, metrics *metrics.Metrics) *CampaignHandler {
+    return &CampaignHandler{
+        campaignService: campaignService,
+        logger:          logger,
+        metrics:         metrics,
+    }
+}
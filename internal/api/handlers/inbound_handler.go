@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CloudflareInboundRequest carries a message forwarded by an Email Worker,
+// sealed as described by services.CloudflareEnvelope
+type CloudflareInboundRequest struct {
+	Envelope string `json:"envelope" binding:"required"`
+}
+
+// ReceiveCloudflare receives a message forwarded by a Cloudflare Email
+// Worker. The response tells the Worker what became of each recipient;
+// it is 503 when any recipient was deferred, so the Worker refuses the
+// message temporarily and the sender retries. Recipients already
+// delivered keep their single copy when the message comes again. A
+// replayed envelope is refused with 409.
+func (h *EmailHandler) ReceiveCloudflare(c *gin.Context) {
+	var req CloudflareInboundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	result, err := h.emailService.ReceiveFromCloudflare(c.Request.Context(), req.Envelope)
+	if err != nil {
+		h.respondError(c, err, "failed to receive email from Cloudflare")
+		return
+	}
+
+	delivered := make([]string, 0, len(result.Delivered))
+	for rcpt := range result.Delivered {
+		delivered = append(delivered, rcpt)
+	}
+	deferred := make([]string, 0, len(result.Deferred))
+	for rcpt := range result.Deferred {
+		deferred = append(deferred, rcpt)
+	}
+
+	status := http.StatusOK
+	if len(deferred) > 0 {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"delivered": delivered,
+		"discarded": result.Discarded,
+		"rejected":  result.Rejected,
+		"deferred":  deferred,
+		"bounces":   result.Bounces,
+	})
+}
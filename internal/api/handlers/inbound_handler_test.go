@@ -0,0 +1,269 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/api/handlers"
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/security"
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/storage/memory"
+	"github.com/bezata/blockchainml-email/internal/storage/r2"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	workerKey = "0123456789abcdef0123456789abcdef"
+	otherKey  = "fedcba9876543210fedcba9876543210"
+)
+
+// testMetrics is shared by every test, as metrics register globally
+var testMetrics = metrics.NewMetrics("handlers_test")
+
+// failingStaff fails to look up the addresses in fail, as when the
+// database is unavailable
+type failingStaff struct {
+	storage.StaffRepository
+	fail map[string]bool
+}
+
+func (r *failingStaff) GetByEmail(ctx context.Context, address string) (*staff.Staff, error) {
+	if r.fail[address] {
+		return nil, errors.New("connection refused")
+	}
+	return r.StaffRepository.GetByEmail(ctx, address)
+}
+
+type inboundEnv struct {
+	router *gin.Engine
+	repos  *storage.Repositories
+	staff  *failingStaff
+}
+
+func newInboundEnv(t *testing.T, members ...string) *inboundEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.Spam.DNSBLZones = nil
+	client, err := r2.NewClient(r2.Config{
+		AccessKey:  "key",
+		SecretKey:  "secret",
+		BucketName: "test",
+		Endpoint:   "http://127.0.0.1:1",
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := memory.NewRepositories()
+	env := &inboundEnv{repos: repos, staff: &failingStaff{StaffRepository: repos.Staff, fail: make(map[string]bool)}}
+	repos.Staff = env.staff
+
+	now := time.Now()
+	for _, address := range members {
+		err := repos.Staff.Create(context.Background(), &staff.Staff{
+			ID:        primitive.NewObjectID(),
+			Email:     address,
+			FullName:  address,
+			Role:      staff.RoleMember,
+			Status:    staff.StatusActive,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := services.New(services.Config{
+		Repositories: repos,
+		Cloudflare:   security.NewService(&config.SecurityConfig{EncryptionKey: workerKey}, zap.NewNop()),
+		R2:           client,
+		Config:       cfg,
+		Logger:       zap.NewNop(),
+		Metrics:      testMetrics,
+	})
+	env.router = gin.New()
+	env.router.POST("/inbound/cloudflare", handlers.NewEmailHandler(svc.Email, svc.Attachments, zap.NewNop(), testMetrics).ReceiveCloudflare)
+	return env
+}
+
+// seal seals an envelope of a message to recipients the way an Email
+// Worker does, with key
+func seal(t *testing.T, key string, timestamp time.Time, to ...string) string {
+	t.Helper()
+
+	raw := "From: Mallory <mallory@example.org>\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: Invoice\r\n" +
+		"Message-ID: <" + primitive.NewObjectID().Hex() + "@example.org>\r\n" +
+		"Date: " + timestamp.Format(time.RFC1123Z) + "\r\n" +
+		"\r\n" +
+		"Please find the invoice attached.\r\n"
+	data, err := json.Marshal(services.CloudflareEnvelope{
+		From:      "mallory@example.org",
+		To:        to,
+		ClientIP:  "192.0.2.1",
+		Raw:       base64.StdEncoding.EncodeToString([]byte(raw)),
+		Timestamp: timestamp.Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := security.NewService(&config.SecurityConfig{EncryptionKey: key}, zap.NewNop()).EncryptForCloudflare(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+type inboundResponse struct {
+	Delivered []string          `json:"delivered"`
+	Rejected  map[string]string `json:"rejected"`
+	Deferred  []string          `json:"deferred"`
+	Error     string            `json:"error"`
+}
+
+func (env *inboundEnv) post(t *testing.T, sealed string) (int, inboundResponse) {
+	t.Helper()
+
+	body, _ := json.Marshal(handlers.CloudflareInboundRequest{Envelope: sealed})
+	req := httptest.NewRequest(http.MethodPost, "/inbound/cloudflare", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	env.router.ServeHTTP(rec, req)
+
+	var resp inboundResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+	sort.Strings(resp.Delivered)
+	return rec.Code, resp
+}
+
+// copies returns how many copies of received mail the mailbox of address
+// holds
+func (env *inboundEnv) copies(t *testing.T, address string) int {
+	t.Helper()
+
+	member, err := env.staff.StaffRepository.GetByEmail(context.Background(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emails, err := env.repos.Email.List(context.Background(), &email.ListQuery{Mailbox: member.ID.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(emails)
+}
+
+func TestReceiveCloudflare(t *testing.T) {
+	env := newInboundEnv(t, "bob@example.com")
+
+	code, resp := env.post(t, seal(t, workerKey, time.Now(), "bob@example.com", "nobody@example.com"))
+	if code != http.StatusOK {
+		t.Fatalf("status = %d (%s), want 200", code, resp.Error)
+	}
+	if len(resp.Delivered) != 1 || resp.Delivered[0] != "bob@example.com" || resp.Rejected["nobody@example.com"] == "" {
+		t.Fatalf("response = %+v, want bob delivered and nobody rejected", resp)
+	}
+	if n := env.copies(t, "bob@example.com"); n != 1 {
+		t.Fatalf("bob has %d copies, want 1", n)
+	}
+}
+
+func TestReceiveCloudflareRefusesBadEnvelopes(t *testing.T) {
+	env := newInboundEnv(t, "bob@example.com")
+
+	tampered := []byte(seal(t, workerKey, time.Now(), "bob@example.com"))
+	tampered[len(tampered)/2] ^= 1
+
+	for _, tc := range []struct {
+		name   string
+		sealed string
+	}{
+		{"WrongKey", seal(t, otherKey, time.Now(), "bob@example.com")},
+		{"Tampered", string(tampered)},
+		{"NotSealed", base64.StdEncoding.EncodeToString([]byte(`{"to": ["bob@example.com"]}`))},
+		{"Stale", seal(t, workerKey, time.Now().Add(-6*time.Minute), "bob@example.com")},
+		{"Future", seal(t, workerKey, time.Now().Add(6*time.Minute), "bob@example.com")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, resp := env.post(t, tc.sealed); code != http.StatusUnauthorized {
+				t.Fatalf("status = %d (%+v), want 401", code, resp)
+			}
+		})
+	}
+	if n := env.copies(t, "bob@example.com"); n != 0 {
+		t.Fatalf("bob has %d copies, want none", n)
+	}
+}
+
+func TestReceiveCloudflareRefusesReplay(t *testing.T) {
+	env := newInboundEnv(t, "bob@example.com")
+	sealed := seal(t, workerKey, time.Now(), "bob@example.com")
+
+	if code, resp := env.post(t, sealed); code != http.StatusOK {
+		t.Fatalf("status = %d (%s), want 200", code, resp.Error)
+	}
+	if code, resp := env.post(t, sealed); code != http.StatusConflict {
+		t.Fatalf("replay status = %d (%+v), want 409", code, resp)
+	}
+	if n := env.copies(t, "bob@example.com"); n != 1 {
+		t.Fatalf("bob has %d copies, want 1", n)
+	}
+}
+
+func TestReceiveCloudflarePartialDeferral(t *testing.T) {
+	env := newInboundEnv(t, "bob@example.com", "carol@example.com")
+	env.staff.fail["carol@example.com"] = true
+	sealed := seal(t, workerKey, time.Now(), "bob@example.com", "carol@example.com")
+
+	// Any deferred recipient makes the Worker refuse the message
+	// temporarily
+	code, resp := env.post(t, sealed)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d (%+v), want 503", code, resp)
+	}
+	if len(resp.Delivered) != 1 || resp.Delivered[0] != "bob@example.com" ||
+		len(resp.Deferred) != 1 || resp.Deferred[0] != "carol@example.com" {
+		t.Fatalf("response = %+v, want bob delivered and carol deferred", resp)
+	}
+
+	// The deferred envelope was not taken as accepted, so it may come
+	// again; bob keeps a single copy
+	delete(env.staff.fail, "carol@example.com")
+	code, resp = env.post(t, sealed)
+	if code != http.StatusOK {
+		t.Fatalf("retry status = %d (%+v), want 200", code, resp)
+	}
+	if len(resp.Delivered) != 2 || len(resp.Deferred) != 0 {
+		t.Fatalf("retry response = %+v, want both delivered", resp)
+	}
+	for _, address := range []string{"bob@example.com", "carol@example.com"} {
+		if n := env.copies(t, address); n != 1 {
+			t.Fatalf("%s has %d copies, want 1", address, n)
+		}
+	}
+
+	if code, _ := env.post(t, sealed); code != http.StatusConflict {
+		t.Fatalf("replay after acceptance status = %d, want 409", code)
+	}
+}
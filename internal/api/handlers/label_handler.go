@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/bezata/blockchainml-email/internal/api/middleware"
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// LabelRequest creates or changes a label. Names nest with "/", as in
+// "Projects/Alpha"; omitted fields are left as they are.
+type LabelRequest struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+// ModifyEmailRequest changes the flags and labels of an email
+type ModifyEmailRequest struct {
+	IsRead       *bool    `json:"isRead"`
+	IsStarred    *bool    `json:"isStarred"`
+	AddLabels    []string `json:"addLabels"`
+	RemoveLabels []string `json:"removeLabels"`
+}
+
+// ListLabels lists the caller's labels with their unread and total counts
+func (h *EmailHandler) ListLabels(c *gin.Context) {
+	labels, err := h.emailService.ListLabels(c.Request.Context(), c.GetString(middleware.ContextUserID))
+	if err != nil {
+		h.respondError(c, err, "failed to list labels")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"labels": labels})
+}
+
+func (h *EmailHandler) CreateLabel(c *gin.Context) {
+	var req LabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	l, err := h.emailService.CreateLabel(c.Request.Context(), c.GetString(middleware.ContextUserID), services.LabelParams{
+		Name:  req.Name,
+		Color: req.Color,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to create label")
+		return
+	}
+
+	c.JSON(http.StatusCreated, l)
+}
+
+// UpdateLabel renames, moves or recolors a label
+func (h *EmailHandler) UpdateLabel(c *gin.Context) {
+	var req LabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	l, err := h.emailService.UpdateLabel(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"), services.LabelParams{
+		Name:  req.Name,
+		Color: req.Color,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to update label")
+		return
+	}
+
+	c.JSON(http.StatusOK, l)
+}
+
+func (h *EmailHandler) DeleteLabel(c *gin.Context) {
+	if err := h.emailService.DeleteLabel(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to delete label")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ModifyEmail marks an email read or starred and adds or removes labels
+func (h *EmailHandler) ModifyEmail(c *gin.Context) {
+	var req ModifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	e, err := h.emailService.ModifyEmail(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"), services.EmailChanges{
+		IsRead:       req.IsRead,
+		IsStarred:    req.IsStarred,
+		AddLabels:    req.AddLabels,
+		RemoveLabels: req.RemoveLabels,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to modify email")
+		return
+	}
+
+	c.JSON(http.StatusOK, e)
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/api/middleware"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type CreateStaffRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	FullName   string `json:"fullName" binding:"required"`
+	Role       string `json:"role"`
+	Department string `json:"department"`
+	Title      string `json:"title"`
+	ManagerID  string `json:"managerId"`
+}
+
+type UpdateStaffRequest struct {
+	FullName   *string `json:"fullName"`
+	Role       *string `json:"role"`
+	Department *string `json:"department"`
+	Title      *string `json:"title"`
+	ManagerID  *string `json:"managerId"`
+	Status     *string `json:"status"`
+}
+
+type SieveScriptRequest struct {
+	Script string `json:"script" binding:"required"`
+}
+
+type OutOfOfficeRequest struct {
+	Start           time.Time `json:"start" binding:"required"`
+	End             time.Time `json:"end" binding:"required"`
+	Subject         string    `json:"subject"`
+	InternalMessage string    `json:"internalMessage" binding:"required"`
+	ExternalMessage string    `json:"externalMessage"`
+	Days            int       `json:"days"`
+}
+
+type OffboardStaffRequest struct {
+	ManagerID string `json:"managerId"`
+	ForwardTo string `json:"forwardTo"`
+}
+
+func (h *StaffHandler) CreateStaff(c *gin.Context) {
+	var req CreateStaffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	member, err := h.staffService.CreateStaff(c.Request.Context(), staff.CreateStaffParams{
+		Email:      req.Email,
+		FullName:   req.FullName,
+		Role:       req.Role,
+		Department: req.Department,
+		Title:      req.Title,
+		ManagerID:  req.ManagerID,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to create staff member")
+		return
+	}
+
+	c.JSON(http.StatusCreated, member)
+}
+
+func (h *StaffHandler) GetStaff(c *gin.Context) {
+	member, err := h.staffService.GetStaff(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get staff member")
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+// ListStaff searches the directory with the q, department, role and status
+// query parameters
+func (h *StaffHandler) ListStaff(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	members, err := h.staffService.ListStaff(c.Request.Context(), staff.ListQuery{
+		Search:     c.Query("q"),
+		Department: c.Query("department"),
+		Role:       c.Query("role"),
+		Status:     c.Query("status"),
+		ManagerID:  c.Query("managerId"),
+		Cursor:     c.Query("cursor"),
+		Limit:      limit,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to list staff")
+		return
+	}
+
+	response := gin.H{"staff": members}
+	if len(members) > 0 && len(members) == storage.PageSize(limit) {
+		response["nextCursor"] = storage.StaffCursor(members[len(members)-1])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *StaffHandler) UpdateStaff(c *gin.Context) {
+	var req UpdateStaffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	member, err := h.staffService.UpdateStaff(c.Request.Context(), c.Param("id"), staff.UpdateStaffParams{
+		FullName:   req.FullName,
+		Role:       req.Role,
+		Department: req.Department,
+		Title:      req.Title,
+		ManagerID:  req.ManagerID,
+		Status:     req.Status,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to update staff member")
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+func (h *StaffHandler) DeleteStaff(c *gin.Context) {
+	if err := h.staffService.DeleteStaff(c.Request.Context(), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to delete staff member")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UploadPhoto accepts the image either as the "photo" form file or as the
+// raw request body. Staff may change their own photo, admins anyone's.
+func (h *StaffHandler) UploadPhoto(c *gin.Context) {
+	id := c.Param("id")
+	if !h.canManage(c, id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	body := c.Request.Body
+	if file, _, err := c.Request.FormFile("photo"); err == nil {
+		defer file.Close()
+		body = file
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, services.MaxProfilePhotoSize+1))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Photo is too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if len(data) > services.MaxProfilePhotoSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Photo is too large"})
+		return
+	}
+
+	member, err := h.staffService.UploadProfilePhoto(c.Request.Context(), id, data)
+	if err != nil {
+		h.respondError(c, err, "failed to upload profile photo")
+		return
+	}
+
+	c.JSON(http.StatusOK, member.ProfilePhoto)
+}
+
+// GetPhoto redirects to a signed URL for the profile photo
+func (h *StaffHandler) GetPhoto(c *gin.Context) {
+	member, err := h.staffService.GetStaff(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get profile photo")
+		return
+	}
+	if member.ProfilePhoto.URL == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No profile photo"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, member.ProfilePhoto.URL)
+}
+
+// GetSieveScript returns the member's Sieve script. Staff may read their
+// own script, admins anyone's.
+func (h *StaffHandler) GetSieveScript(c *gin.Context) {
+	id := c.Param("id")
+	if !h.canManage(c, id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	script, err := h.staffService.GetSieveScript(c.Request.Context(), id)
+	if err != nil {
+		h.respondError(c, err, "failed to get sieve script")
+		return
+	}
+
+	c.JSON(http.StatusOK, script)
+}
+
+// PutSieveScript validates and stores the member's Sieve script; scripts
+// that fail to compile are refused with the line of the first error
+func (h *StaffHandler) PutSieveScript(c *gin.Context) {
+	id := c.Param("id")
+	if !h.canManage(c, id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var req SieveScriptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	script, err := h.staffService.SetSieveScript(c.Request.Context(), id, req.Script)
+	if err != nil {
+		h.respondError(c, err, "failed to store sieve script")
+		return
+	}
+
+	c.JSON(http.StatusOK, script)
+}
+
+func (h *StaffHandler) DeleteSieveScript(c *gin.Context) {
+	id := c.Param("id")
+	if !h.canManage(c, id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	if err := h.staffService.DeleteSieveScript(c.Request.Context(), id); err != nil {
+		h.respondError(c, err, "failed to delete sieve script")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetOutOfOffice returns the member's out-of-office reply. Staff may read
+// their own, admins anyone's.
+func (h *StaffHandler) GetOutOfOffice(c *gin.Context) {
+	id := c.Param("id")
+	if !h.canManage(c, id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	ooo, err := h.staffService.GetOutOfOffice(c.Request.Context(), id)
+	if err != nil {
+		h.respondError(c, err, "failed to get out-of-office reply")
+		return
+	}
+
+	c.JSON(http.StatusOK, ooo)
+}
+
+func (h *StaffHandler) PutOutOfOffice(c *gin.Context) {
+	id := c.Param("id")
+	if !h.canManage(c, id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	var req OutOfOfficeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ooo, err := h.staffService.SetOutOfOffice(c.Request.Context(), id, staff.OutOfOffice{
+		Start:           req.Start,
+		End:             req.End,
+		Subject:         req.Subject,
+		InternalMessage: req.InternalMessage,
+		ExternalMessage: req.ExternalMessage,
+		Days:            req.Days,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to store out-of-office reply")
+		return
+	}
+
+	c.JSON(http.StatusOK, ooo)
+}
+
+func (h *StaffHandler) DeleteOutOfOffice(c *gin.Context) {
+	id := c.Param("id")
+	if !h.canManage(c, id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	if err := h.staffService.DeleteOutOfOffice(c.Request.Context(), id); err != nil {
+		h.respondError(c, err, "failed to delete out-of-office reply")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *StaffHandler) OffboardStaff(c *gin.Context) {
+	var req OffboardStaffRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	member, err := h.staffService.OffboardStaff(c.Request.Context(), c.Param("id"), staff.OffboardParams{
+		ManagerID:    req.ManagerID,
+		ForwardTo:    req.ForwardTo,
+		OffboardedBy: c.GetString(middleware.ContextUserID),
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to offboard staff member")
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+func (h *StaffHandler) canManage(c *gin.Context, id string) bool {
+	return c.GetString(middleware.ContextRole) == staff.RoleAdmin || c.GetString(middleware.ContextUserID) == id
+}
+
+// respondError maps service errors to status codes and logs unexpected ones
+func (h *StaffHandler) respondError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, services.ErrStaffNotFound), errors.Is(err, services.ErrNoSieveScript), errors.Is(err, services.ErrNoOOO):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrStaffExists), errors.Is(err, services.ErrAlreadyOffboard):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidStaff), errors.Is(err, services.ErrInvalidPhoto), errors.Is(err, services.ErrNoManager),
+		errors.Is(err, services.ErrInvalidSieve), errors.Is(err, services.ErrInvalidOOO), errors.Is(err, storage.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error(msg, zap.String("staff_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bezata/blockchainml-email/internal/api/middleware"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// SuppressionRequest adds an address to the suppression list
+type SuppressionRequest struct {
+	Email string `json:"email" binding:"required"`
+	Note  string `json:"note"`
+}
+
+// ListSuppressions lists the suppressed addresses, optionally of one reason
+func (h *EmailHandler) ListSuppressions(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	suppressions, err := h.emailService.ListSuppressions(c.Request.Context(), c.Query("reason"), c.Query("cursor"), limit)
+	if err != nil {
+		h.respondError(c, err, "failed to list suppressions")
+		return
+	}
+
+	response := gin.H{"suppressions": suppressions}
+	if len(suppressions) > 0 && len(suppressions) == storage.PageSize(limit) {
+		response["nextCursor"] = storage.SuppressionCursor(suppressions[len(suppressions)-1])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *EmailHandler) AddSuppression(c *gin.Context) {
+	var req SuppressionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	suppression, err := h.emailService.AddSuppression(c.Request.Context(), c.GetString(middleware.ContextUserID), req.Email, req.Note)
+	if err != nil {
+		h.respondError(c, err, "failed to add suppression")
+		return
+	}
+
+	c.JSON(http.StatusCreated, suppression)
+}
+
+func (h *EmailHandler) DeleteSuppression(c *gin.Context) {
+	if err := h.emailService.DeleteSuppression(c.Request.Context(), c.Param("address")); err != nil {
+		h.respondError(c, err, "failed to delete suppression")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bezata/blockchainml-email/internal/api/middleware"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// TemplateRequest creates or replaces a template. Without department or
+// global the template is the caller's own; shared ones are for admins.
+type TemplateRequest struct {
+	Kind        string `json:"kind" binding:"omitempty,oneof=message signature"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Department  string `json:"department"`
+	Global      bool   `json:"global"`
+	Subject     string `json:"subject"`
+	Text        string `json:"text" binding:"required_without=HTML"`
+	HTML        string `json:"html"`
+}
+
+// RenderTemplateRequest previews a template for a recipient
+type RenderTemplateRequest struct {
+	Recipient     string            `json:"recipient" binding:"omitempty,email"`
+	RecipientName string            `json:"recipientName"`
+	Variables     map[string]string `json:"variables"`
+}
+
+func (r *TemplateRequest) params() services.TemplateParams {
+	return services.TemplateParams{
+		Kind:        r.Kind,
+		Name:        r.Name,
+		Description: r.Description,
+		Department:  r.Department,
+		Global:      r.Global,
+		Subject:     r.Subject,
+		Text:        r.Text,
+		HTML:        r.HTML,
+	}
+}
+
+func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
+	var req TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	t, err := h.templateService.CreateTemplate(c.Request.Context(), c.GetString(middleware.ContextUserID), req.params())
+	if err != nil {
+		h.respondError(c, err, "failed to create template")
+		return
+	}
+
+	c.JSON(http.StatusCreated, t)
+}
+
+// ListTemplates lists the caller's templates with their department's and
+// global ones, optionally of one kind
+func (h *TemplateHandler) ListTemplates(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	templates, err := h.templateService.ListTemplates(c.Request.Context(), c.GetString(middleware.ContextUserID),
+		c.Query("kind"), c.Query("cursor"), limit)
+	if err != nil {
+		h.respondError(c, err, "failed to list templates")
+		return
+	}
+
+	response := gin.H{"templates": templates}
+	if len(templates) > 0 && len(templates) == storage.PageSize(limit) {
+		response["nextCursor"] = storage.TemplateCursor(templates[len(templates)-1])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *TemplateHandler) GetTemplate(c *gin.Context) {
+	t, err := h.templateService.GetTemplate(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get template")
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+func (h *TemplateHandler) UpdateTemplate(c *gin.Context) {
+	var req TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	t, err := h.templateService.UpdateTemplate(c.Request.Context(), c.GetString(middleware.ContextUserID),
+		c.Param("id"), req.params())
+	if err != nil {
+		h.respondError(c, err, "failed to update template")
+		return
+	}
+
+	c.JSON(http.StatusOK, t)
+}
+
+func (h *TemplateHandler) DeleteTemplate(c *gin.Context) {
+	if err := h.templateService.DeleteTemplate(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to delete template")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RenderTemplate returns the subject and content a message template renders
+// to with the given variables, without sending anything
+func (h *TemplateHandler) RenderTemplate(c *gin.Context) {
+	var req RenderTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	rendered, err := h.templateService.RenderTemplate(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"),
+		email.Participant{Email: req.Recipient, FullName: req.RecipientName}, req.Variables)
+	if err != nil {
+		h.respondError(c, err, "failed to render template")
+		return
+	}
+
+	c.JSON(http.StatusOK, rendered)
+}
+
+// respondError maps service errors to status codes and logs unexpected ones
+func (h *TemplateHandler) respondError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, services.ErrTemplateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTemplateAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSignatureExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidTemplate), errors.Is(err, storage.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error(msg, zap.String("template_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
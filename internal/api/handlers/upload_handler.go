@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bezata/blockchainml-email/internal/api/middleware"
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type StartUploadRequest struct {
+	Filename    string `json:"filename" binding:"required"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size" binding:"required,gt=0"`
+}
+
+// StartUpload begins a resumable upload. The client then PUTs each chunk of
+// ChunkSize bytes and completes the upload to get the staged attachment.
+func (h *UploadHandler) StartUpload(c *gin.Context) {
+	var req StartUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := h.attachmentService.StartUpload(c.Request.Context(), c.GetString(middleware.ContextUserID), services.StartUploadParams{
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to start upload")
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// GetUpload returns the chunks received so far so a client can resume
+func (h *UploadHandler) GetUpload(c *gin.Context) {
+	session, err := h.attachmentService.GetUpload(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get upload")
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// UploadChunk stores the raw request body as one chunk
+func (h *UploadHandler) UploadChunk(c *gin.Context) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chunk index"})
+		return
+	}
+
+	err = h.attachmentService.UploadChunk(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"), index, c.Request.Body)
+	if err != nil {
+		h.respondError(c, err, "failed to upload chunk")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// CompleteUpload assembles the chunks and returns the staged attachment,
+// whose key is then passed in the uploads of a send request
+func (h *UploadHandler) CompleteUpload(c *gin.Context) {
+	attachment, err := h.attachmentService.CompleteUpload(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to complete upload")
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+func (h *UploadHandler) AbortUpload(c *gin.Context) {
+	if err := h.attachmentService.AbortUpload(c.Request.Context(), c.GetString(middleware.ContextUserID), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to abort upload")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// PresignUpload issues a URL for the browser to PUT a file straight to
+// storage. The upload is confirmed afterwards to get the staged attachment.
+func (h *UploadHandler) PresignUpload(c *gin.Context) {
+	var req StartUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upload, err := h.attachmentService.PresignUpload(c.Request.Context(), c.GetString(middleware.ContextUserID), services.StartUploadParams{
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+	})
+	if err != nil {
+		h.respondError(c, err, "failed to presign upload")
+		return
+	}
+
+	c.JSON(http.StatusCreated, upload)
+}
+
+type ConfirmUploadRequest struct {
+	Key string `json:"key" binding:"required"`
+}
+
+// ConfirmUpload checks a presigned upload arrived and returns the staged
+// attachment, whose key is then passed in the uploads of a send request
+func (h *UploadHandler) ConfirmUpload(c *gin.Context) {
+	var req ConfirmUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attachment, err := h.attachmentService.ConfirmUpload(c.Request.Context(), c.GetString(middleware.ContextUserID), req.Key)
+	if err != nil {
+		h.respondError(c, err, "failed to confirm upload")
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// DedupReport shows how much storage attachment deduplication saves
+func (h *UploadHandler) DedupReport(c *gin.Context) {
+	report, err := h.attachmentService.DedupReport(c.Request.Context())
+	if err != nil {
+		h.respondError(c, err, "failed to get deduplication report")
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *UploadHandler) respondError(c *gin.Context, err error, msg string) {
+	if status, ok := attachmentErrorStatus(err); ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.logger.Error(msg, zap.String("upload_id", c.Param("id")), zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+}
+
+// attachmentErrorStatus maps attachment and request size errors to their
+// HTTP status
+func attachmentErrorStatus(err error) (int, bool) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrUploadTooLarge), errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge, true
+	case errors.Is(err, services.ErrUploadNotFound):
+		return http.StatusNotFound, true
+	case errors.Is(err, services.ErrUploadIncomplete):
+		return http.StatusConflict, true
+	case errors.Is(err, services.ErrInvalidUpload):
+		return http.StatusBadRequest, true
+	case errors.Is(err, services.ErrQuarantined):
+		return http.StatusForbidden, true
+	case errors.Is(err, services.ErrScanFailed):
+		return http.StatusServiceUnavailable, true
+	}
+	return 0, false
+}
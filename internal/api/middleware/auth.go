@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/bezata/blockchainml-email/internal/services"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Context keys set by the auth middleware for authenticated requests
+const (
+	ContextUserID = "userId"
+	ContextRole   = "role"
+)
+
+// Authenticator resolves the bearer token of a request to the staff
+// member it was issued to
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*services.Principal, error)
+}
+
+// Handle rejects requests without a valid bearer access token and sets
+// the user ID and role of the others in the context
+func (m *AuthMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			return
+		}
+
+		principal, err := m.auth.Authenticate(c.Request.Context(), token)
+		if err != nil {
+			m.logger.Debug("rejected token", zap.String("path", c.FullPath()), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+
+		c.Set(ContextUserID, principal.UserID)
+		c.Set(ContextRole, principal.Role)
+		c.Next()
+	}
+}
+
+// RequireRole rejects requests whose authenticated role is not one of roles.
+// It must run after the auth middleware.
+func (m *AuthMiddleware) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString(ContextRole)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		m.logger.Warn("forbidden request",
+			zap.String("user_id", c.GetString(ContextUserID)),
+			zap.String("role", role),
+			zap.String("path", c.FullPath()),
+		)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	}
+}
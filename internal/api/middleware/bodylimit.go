@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// UpdateLimit replaces the maximum request body size. It is safe to call
+// while serving requests, which is how configuration reloads apply it.
+func (m *BodyLimitMiddleware) UpdateLimit(limit int64) {
+	m.limit.Store(limit)
+}
+
+// Handle rejects requests whose declared Content-Length is over the limit and
+// caps the body of the rest, so streamed and chunked bodies fail with
+// *http.MaxBytesError once they pass it. A zero limit disables the check.
+func (m *BodyLimitMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := m.limit.Load()
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > limit {
+			m.logger.Debug("request body too large",
+				zap.Int64("content_length", c.Request.ContentLength),
+				zap.Int64("limit", limit),
+			)
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
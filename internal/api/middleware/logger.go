@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Handle logs every request once it has been served, server errors at the
+// error level
+func (m *LoggerMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now()
+		c.Next()
+
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", c.Writer.Status()),
+			zap.Duration("latency", time.Since(startTime)),
+			zap.String("client_ip", c.ClientIP()),
+		}
+		if userID := c.GetString(ContextUserID); userID != "" {
+			fields = append(fields, zap.String("user_id", userID))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+
+		if c.Writer.Status() >= 500 {
+			m.logger.Error("request failed", fields...)
+			return
+		}
+		m.logger.Info("request served", fields...)
+	}
+}
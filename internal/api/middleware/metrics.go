@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handle records the requests in flight and the latency and status of
+// each route. Unmatched paths share one label so that they cannot blow up
+// the label cardinality.
+func (m *MetricsMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m.metrics.ActiveConnections.Inc()
+		defer m.metrics.ActiveConnections.Dec()
+
+		startTime := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		route = c.Request.Method + " " + route
+		m.metrics.EmailLatency.WithLabelValues(route).Observe(time.Since(startTime).Seconds())
+		m.metrics.EmailRequests.WithLabelValues(route, strconv.Itoa(c.Writer.Status())).Inc()
+	}
+}
@@ -8,14 +8,16 @@ import (
 type Middleware struct {
 	Auth      *AuthMiddleware
 	RateLimit *RateLimitMiddleware
+	BodyLimit *BodyLimitMiddleware
 	Logger    *LoggerMiddleware
 	Metrics   *MetricsMiddleware
 }
 
-func NewMiddleware(logger *zap.Logger, metrics *metrics.Metrics) *Middleware {
+func NewMiddleware(auth Authenticator, logger *zap.Logger, metrics *metrics.Metrics) *Middleware {
 	return &Middleware{
-		Auth:      NewAuthMiddleware(logger),
+		Auth:      NewAuthMiddleware(auth, logger),
 		RateLimit: NewRateLimitMiddleware(logger, metrics),
+		BodyLimit: NewBodyLimitMiddleware(logger),
 		Logger:    NewLoggerMiddleware(logger),
 		Metrics:   NewMetricsMiddleware(metrics),
 	}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// idleBucketTTL is how long an unused client bucket is kept around
+const idleBucketTTL = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// UpdateLimits replaces the rate limits. It is safe to call while serving
+// requests, which is how configuration reloads apply new limits.
+func (m *RateLimitMiddleware) UpdateLimits(limits config.RateLimitConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limits = limits
+}
+
+// Handle limits requests per client IP with a token bucket. A zero
+// RequestsPerMinute disables limiting.
+func (m *RateLimitMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.allow(c.ClientIP(), time.Now()) {
+			m.metrics.EmailRequests.WithLabelValues("rate_limit", "rejected").Inc()
+			m.logger.Debug("rate limit exceeded", zap.String("client_ip", c.ClientIP()))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (m *RateLimitMiddleware) allow(key string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.limits.RequestsPerMinute <= 0 {
+		return true
+	}
+	burst := float64(m.limits.BurstSize)
+	if burst < 1 {
+		burst = 1
+	}
+	rate := float64(m.limits.RequestsPerMinute) / 60
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, lastSeen: now}
+		m.buckets[key] = bucket
+		m.evictIdle(now)
+	}
+
+	bucket.tokens += now.Sub(bucket.lastSeen).Seconds() * rate
+	if bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// evictIdle drops buckets of clients that have not been seen for a while so
+// the map does not grow without bound. Called with m.mu held.
+func (m *RateLimitMiddleware) evictIdle(now time.Time) {
+	if now.Sub(m.evicted) < time.Minute {
+		return
+	}
+	m.evicted = now
+
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.lastSeen) > idleBucketTTL {
+			delete(m.buckets, key)
+		}
+	}
+}
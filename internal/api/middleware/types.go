@@ -1,17 +1,33 @@
 package middleware
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
 	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
 	"go.uber.org/zap"
 )
 
 type AuthMiddleware struct {
+	auth   Authenticator
 	logger *zap.Logger
 }
 
 type RateLimitMiddleware struct {
 	logger  *zap.Logger
 	metrics *metrics.Metrics
+	mu      sync.Mutex
+	limits  config.RateLimitConfig
+	buckets map[string]*tokenBucket
+	evicted time.Time
+}
+
+// BodyLimitMiddleware caps request body size at ServerConfig.MaxRequestSize
+type BodyLimitMiddleware struct {
+	logger *zap.Logger
+	limit  atomic.Int64
 }
 
 type LoggerMiddleware struct {
@@ -22,12 +38,20 @@ type MetricsMiddleware struct {
 	metrics *metrics.Metrics
 }
 
-func NewAuthMiddleware(logger *zap.Logger) *AuthMiddleware {
-	return &AuthMiddleware{logger: logger}
+func NewAuthMiddleware(auth Authenticator, logger *zap.Logger) *AuthMiddleware {
+	return &AuthMiddleware{auth: auth, logger: logger}
 }
 
 func NewRateLimitMiddleware(logger *zap.Logger, metrics *metrics.Metrics) *RateLimitMiddleware {
-	return &RateLimitMiddleware{logger: logger, metrics: metrics}
+	return &RateLimitMiddleware{
+		logger:  logger,
+		metrics: metrics,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func NewBodyLimitMiddleware(logger *zap.Logger) *BodyLimitMiddleware {
+	return &BodyLimitMiddleware{logger: logger}
 }
 
 func NewLoggerMiddleware(logger *zap.Logger) *LoggerMiddleware {
@@ -4,6 +4,7 @@ import (
     "github.com/gin-gonic/gin"
     "github.com/bezata/blockchainml-email/internal/api/handlers"
     "github.com/bezata/blockchainml-email/internal/api/middleware"
+    "github.com/bezata/blockchainml-email/internal/domain/staff"
 )
 
 func NewRouter(handlers *handlers.Handlers, mw *middleware.Middleware) *gin.Engine {
@@ -13,6 +14,7 @@ func NewRouter(handlers *handlers.Handlers, mw *middleware.Middleware) *gin.Engi
     router.Use(mw.Logger.Handle())
     router.Use(mw.Metrics.Handle())
     router.Use(mw.RateLimit.Handle())
+    router.Use(mw.BodyLimit.Handle())
 
     // API routes
     api := router.Group("/api/v1")
@@ -20,6 +22,10 @@ func NewRouter(handlers *handlers.Handlers, mw *middleware.Middleware) *gin.Engi
         // Public routes
         api.POST("/auth/login", handlers.Auth.Login)
         api.POST("/auth/refresh", handlers.Auth.RefreshToken)
+        api.GET("/unsubscribe/:token", handlers.Campaign.Unsubscribe)
+        api.POST("/unsubscribe/:token", handlers.Campaign.Unsubscribe)
+        api.POST("/webhooks/delivery/:transport", handlers.Email.DeliveryEvents)
+        api.POST("/inbound/cloudflare", handlers.Email.ReceiveCloudflare)
 
         // Protected routes
         protected := api.Group("")
@@ -27,7 +33,89 @@ func NewRouter(handlers *handlers.Handlers, mw *middleware.Middleware) *gin.Engi
         {
             // Email routes
             protected.POST("/emails", handlers.Email.SendEmail)
+            protected.GET("/emails", handlers.Email.ListEmails)
             protected.GET("/emails/search", handlers.Email.SearchEmails)
+            protected.GET("/emails/:id", handlers.Email.GetEmail)
+            protected.GET("/emails/:id/attachments/:filename", handlers.Email.GetAttachmentURL)
+            protected.GET("/emails/:id/delivery", handlers.Email.GetDelivery)
+            protected.POST("/emails/:id/reply", handlers.Email.Reply)
+            protected.POST("/emails/:id/reply-all", handlers.Email.ReplyAll)
+            protected.POST("/emails/:id/forward", handlers.Email.Forward)
+            protected.POST("/emails/:id/spam", handlers.Email.MarkSpam)
+            protected.POST("/emails/:id/not-spam", handlers.Email.MarkNotSpam)
+            protected.PATCH("/emails/:id", handlers.Email.ModifyEmail)
+
+            // Labels, nested by name as in "Projects/Alpha"
+            protected.GET("/labels", handlers.Email.ListLabels)
+            protected.POST("/labels", handlers.Email.CreateLabel)
+            protected.PATCH("/labels/:id", handlers.Email.UpdateLabel)
+            protected.DELETE("/labels/:id", handlers.Email.DeleteLabel)
+
+            // Drafts, saved with If-Match against their ETag version
+            protected.POST("/drafts", handlers.Email.CreateDraft)
+            protected.GET("/drafts", handlers.Email.ListDrafts)
+            protected.GET("/drafts/:id", handlers.Email.GetDraft)
+            protected.PUT("/drafts/:id", handlers.Email.UpdateDraft)
+            protected.DELETE("/drafts/:id", handlers.Email.DeleteDraft)
+            protected.POST("/drafts/:id/attachments", handlers.Email.AddDraftAttachments)
+            protected.DELETE("/drafts/:id/attachments/:filename", handlers.Email.RemoveDraftAttachment)
+            protected.POST("/drafts/:id/send", handlers.Email.SendDraft)
+
+            // Templates and signatures
+            protected.POST("/templates", handlers.Template.CreateTemplate)
+            protected.GET("/templates", handlers.Template.ListTemplates)
+            protected.GET("/templates/:id", handlers.Template.GetTemplate)
+            protected.PUT("/templates/:id", handlers.Template.UpdateTemplate)
+            protected.DELETE("/templates/:id", handlers.Template.DeleteTemplate)
+            protected.POST("/templates/:id/render", handlers.Template.RenderTemplate)
+
+            // Bulk campaigns
+            protected.POST("/campaigns", handlers.Campaign.CreateCampaign)
+            protected.GET("/campaigns", handlers.Campaign.ListCampaigns)
+            protected.GET("/campaigns/:id", handlers.Campaign.GetCampaign)
+            protected.DELETE("/campaigns/:id", handlers.Campaign.DeleteCampaign)
+            protected.POST("/campaigns/:id/recipients", handlers.Campaign.AddRecipients)
+            protected.GET("/campaigns/:id/recipients", handlers.Campaign.ListRecipients)
+            protected.POST("/campaigns/:id/start", handlers.Campaign.StartCampaign)
+            protected.POST("/campaigns/:id/pause", handlers.Campaign.PauseCampaign)
+            protected.POST("/campaigns/:id/cancel", handlers.Campaign.CancelCampaign)
+            protected.GET("/campaigns/:id/progress", handlers.Campaign.Progress)
+
+            // Resumable attachment uploads
+            protected.POST("/uploads", handlers.Upload.StartUpload)
+            protected.GET("/uploads/:id", handlers.Upload.GetUpload)
+            protected.PUT("/uploads/:id/chunks/:index", handlers.Upload.UploadChunk)
+            protected.POST("/uploads/:id/complete", handlers.Upload.CompleteUpload)
+            protected.DELETE("/uploads/:id", handlers.Upload.AbortUpload)
+
+            // Direct browser uploads
+            protected.POST("/uploads/presign", handlers.Upload.PresignUpload)
+            protected.POST("/uploads/confirm", handlers.Upload.ConfirmUpload)
+
+            // Staff directory
+            protected.GET("/staff", handlers.Staff.ListStaff)
+            protected.GET("/staff/:id", handlers.Staff.GetStaff)
+            protected.GET("/staff/:id/photo", handlers.Staff.GetPhoto)
+            protected.POST("/staff/:id/photo", handlers.Staff.UploadPhoto)
+            protected.GET("/staff/:id/sieve", handlers.Staff.GetSieveScript)
+            protected.PUT("/staff/:id/sieve", handlers.Staff.PutSieveScript)
+            protected.DELETE("/staff/:id/sieve", handlers.Staff.DeleteSieveScript)
+            protected.GET("/staff/:id/out-of-office", handlers.Staff.GetOutOfOffice)
+            protected.PUT("/staff/:id/out-of-office", handlers.Staff.PutOutOfOffice)
+            protected.DELETE("/staff/:id/out-of-office", handlers.Staff.DeleteOutOfOffice)
+
+            admin := protected.Group("")
+            admin.Use(mw.Auth.RequireRole(staff.RoleAdmin))
+            {
+                admin.POST("/staff", handlers.Staff.CreateStaff)
+                admin.PATCH("/staff/:id", handlers.Staff.UpdateStaff)
+                admin.DELETE("/staff/:id", handlers.Staff.DeleteStaff)
+                admin.POST("/staff/:id/offboard", handlers.Staff.OffboardStaff)
+                admin.GET("/attachments/dedup", handlers.Upload.DedupReport)
+                admin.GET("/suppressions", handlers.Email.ListSuppressions)
+                admin.POST("/suppressions", handlers.Email.AddSuppression)
+                admin.DELETE("/suppressions/:address", handlers.Email.DeleteSuppression)
+            }
             // Add other routes...
         }
     }
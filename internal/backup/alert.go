@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Alert struct {
+	Job      string    `json:"job"`
+	BackupID string    `json:"backupId,omitempty"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// Alerter notifies operators about failed backup jobs
+type Alerter interface {
+	Alert(ctx context.Context, alert Alert) error
+}
+
+// LogAlerter reports alerts through the logger only
+type LogAlerter struct {
+	logger *zap.Logger
+}
+
+func NewLogAlerter(logger *zap.Logger) *LogAlerter {
+	return &LogAlerter{logger: logger}
+}
+
+func (a *LogAlerter) Alert(ctx context.Context, alert Alert) error {
+	a.logger.Error("backup alert",
+		zap.String("job", alert.Job),
+		zap.String("backup_id", alert.BackupID),
+		zap.String("message", alert.Message),
+	)
+	return nil
+}
+
+// WebhookAlerter posts alerts as JSON to a webhook, e.g. a chat integration
+type WebhookAlerter struct {
+	url    string
+	client *http.Client
+	logger *zap.Logger
+}
+
+func NewWebhookAlerter(url string, logger *zap.Logger) *WebhookAlerter {
+	return &WebhookAlerter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+	}
+}
+
+func (a *WebhookAlerter) Alert(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		a.logger.Error("failed to send backup alert", zap.Error(err))
+		return fmt.Errorf("failed to send backup alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("backup alert webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/storage/mongodb"
+	"github.com/bezata/blockchainml-email/internal/storage/r2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// fakeR2 is an S3-compatible bucket in memory, serving the path-style
+// requests of an r2.Client whose endpoint points at it
+type fakeR2 struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeR2(t *testing.T) *fakeR2 {
+	t.Helper()
+
+	f := &fakeR2{objects: make(map[string][]byte)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// client returns an r2.Client for the bucket
+func (f *fakeR2) client(t *testing.T) *r2.Client {
+	t.Helper()
+
+	client, err := r2.NewClient(r2.Config{
+		AccessKey:  "key",
+		SecretKey:  "secret",
+		BucketName: "test",
+		Endpoint:   f.URL,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// keys returns the stored object keys in order
+func (f *fakeR2) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeR2) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = data
+}
+
+func (f *fakeR2) get(key string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key]
+}
+
+func (f *fakeR2) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		prefix := r.URL.Query().Get("prefix")
+		var b strings.Builder
+		b.WriteString(`<ListBucketResult><IsTruncated>false</IsTruncated>`)
+		for k := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				fmt.Fprintf(&b, `<Contents><Key>%s</Key></Contents>`, k)
+			}
+		}
+		b.WriteString(`</ListBucketResult>`)
+		io.WriteString(w, b.String())
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		_, srcKey, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+		data, ok := f.objects[srcKey]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		f.objects[key] = append([]byte(nil), data...)
+		io.WriteString(w, `<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+// catalog is a storage.BackupRepository in memory
+type catalog struct {
+	mu      sync.Mutex
+	backups map[string]*backup.Backup
+}
+
+func newCatalog(backups ...*backup.Backup) *catalog {
+	c := &catalog{backups: make(map[string]*backup.Backup)}
+	for _, bk := range backups {
+		c.backups[bk.ID] = bk
+	}
+	return c
+}
+
+func (c *catalog) CreateBackup(_ context.Context, bk *backup.Backup) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	copied := *bk
+	c.backups[bk.ID] = &copied
+	return nil
+}
+
+func (c *catalog) GetBackup(_ context.Context, id string) (*backup.Backup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bk, ok := c.backups[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	copied := *bk
+	return &copied, nil
+}
+
+func (c *catalog) UpdateBackup(ctx context.Context, bk *backup.Backup) error {
+	return c.CreateBackup(ctx, bk)
+}
+
+func (c *catalog) DeleteBackup(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.backups, id)
+	return nil
+}
+
+// ListBackups returns backups matching the query, oldest first
+func (c *catalog) ListBackups(_ context.Context, query *backup.ListQuery) ([]*backup.Backup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var backups []*backup.Backup
+	for _, bk := range c.backups {
+		if query != nil && (query.Type != "" && bk.Type != query.Type || query.Status != "" && bk.Status != query.Status) {
+			continue
+		}
+		copied := *bk
+		backups = append(backups, &copied)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Timestamp.Before(backups[j].Timestamp) })
+	return backups, nil
+}
+
+// newTestManager returns a manager over db, which may be nil for tests
+// that don't touch MongoDB, a catalog and a fake bucket
+func newTestManager(t *testing.T, db *mongo.Database, backups ...*backup.Backup) (*BackupManager, *catalog, *fakeR2) {
+	t.Helper()
+
+	bucket := newFakeR2(t)
+	repo := newCatalog(backups...)
+	return NewBackupManager(db, repo, bucket.client(t), zap.NewNop(), config.Default()), repo, bucket
+}
+
+// testDB returns a fresh database on the MongoDB deployment of
+// MONGODB_TEST_URI, dropped when the test ends
+func testDB(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx := context.Background()
+	db, err := mongodb.Connect(ctx, config.MongoDBConfig{URI: uri, Database: "backuptest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = db.Client().Database(fmt.Sprintf("backuptest_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		db.Client().Disconnect(ctx)
+	})
+	return db
+}
+
+// writeSegment stores docs as a segment of collection under key
+func writeSegment(t *testing.T, b *BackupManager, collection, key string, docs ...interface{}) backup.Segment {
+	t.Helper()
+
+	w := b.newSegmentWriter(context.Background(), collection, key)
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			w.Abort()
+			t.Fatal(err)
+		}
+		if err := w.Write(raw); err != nil {
+			w.Abort()
+			t.Fatal(err)
+		}
+	}
+	segment, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segment
+}
@@ -0,0 +1,195 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"*/15 * * * *",
+		"0 9-17/2 * * 1-5",
+		"0,30 8,20 1,15 * *",
+		" 30 2 * * 7 ",
+		"5/20 * * * *",
+		"@hourly", "@daily", "@midnight", "@weekly", "@monthly", "@yearly",
+	}
+	for _, spec := range valid {
+		if _, err := ParseSchedule(spec); err != nil {
+			t.Errorf("ParseSchedule(%q): %v", spec, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+		"a * * * *",
+		"-1 * * * *",
+		"@every 5m",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestScheduleFields(t *testing.T) {
+	s, err := ParseSchedule("5/20 9-17/4 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A single value with a step runs from the value to the end of the range
+	if want := uint64(1<<5 | 1<<25 | 1<<45); s.minute != want {
+		t.Errorf("minutes = %b, want %b", s.minute, want)
+	}
+	if want := uint64(1<<9 | 1<<13 | 1<<17); s.hour != want {
+		t.Errorf("hours = %b, want %b", s.hour, want)
+	}
+	// 7 is Sunday, like 0
+	if s.dow&1 == 0 {
+		t.Errorf("days of week = %b, want Sunday as 0", s.dow)
+	}
+	if !s.domAny || s.dowAny {
+		t.Errorf("domAny = %v, dowAny = %v", s.domAny, s.dowAny)
+	}
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s unavailable: %v", name, err)
+	}
+	return loc
+}
+
+func TestScheduleNext(t *testing.T) {
+	kolkata := mustLoad(t, "Asia/Kolkata")
+	kathmandu := mustLoad(t, "Asia/Kathmandu")
+	newYork := mustLoad(t, "America/New_York")
+
+	date := func(loc *time.Location, y int, m time.Month, d, h, min, sec int) time.Time {
+		return time.Date(y, m, d, h, min, sec, 0, loc)
+	}
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"next quarter hour", "*/15 * * * *", date(time.UTC, 2026, 10, 19, 10, 7, 30), date(time.UTC, 2026, 10, 19, 10, 15, 0)},
+		{"strictly after", "0 * * * *", date(time.UTC, 2026, 10, 19, 10, 0, 0), date(time.UTC, 2026, 10, 19, 11, 0, 0)},
+		{"seconds past the minute", "30 2 * * *", date(time.UTC, 2026, 10, 19, 2, 30, 1), date(time.UTC, 2026, 10, 20, 2, 30, 0)},
+		{"weekdays skip the weekend", "0 9 * * 1-5", date(time.UTC, 2026, 10, 16, 10, 0, 0), date(time.UTC, 2026, 10, 19, 9, 0, 0)},
+		{"sunday as 7", "0 0 * * 7", date(time.UTC, 2026, 10, 19, 0, 0, 0), date(time.UTC, 2026, 10, 25, 0, 0, 0)},
+		{"day of month or week", "0 0 13 * 5", date(time.UTC, 2026, 11, 1, 0, 0, 0), date(time.UTC, 2026, 11, 6, 0, 0, 0)},
+		{"day of month and any week day", "0 0 13 * *", date(time.UTC, 2026, 11, 1, 0, 0, 0), date(time.UTC, 2026, 11, 13, 0, 0, 0)},
+		{"short months are skipped", "0 0 31 * *", date(time.UTC, 2026, 4, 1, 0, 0, 0), date(time.UTC, 2026, 5, 31, 0, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(time.UTC, 2026, 3, 1, 0, 0, 0), date(time.UTC, 2028, 2, 29, 0, 0, 0)},
+		{"end of year", "@yearly", date(time.UTC, 2026, 12, 31, 23, 59, 0), date(time.UTC, 2027, 1, 1, 0, 0, 0)},
+		// Whole hours of a half hour zone are whole hours of its wall clock
+		{"half hour offset", "0 3 * * *", date(kolkata, 2026, 10, 19, 1, 10, 0), date(kolkata, 2026, 10, 19, 3, 0, 0)},
+		{"half hour offset hourly", "0 * * * *", date(kolkata, 2026, 10, 19, 10, 10, 0), date(kolkata, 2026, 10, 19, 11, 0, 0)},
+		{"quarter hour offset", "15 9 * * *", date(kathmandu, 2026, 10, 19, 9, 20, 0), date(kathmandu, 2026, 10, 20, 9, 15, 0)},
+		{"across a day in another zone", "@daily", date(kolkata, 2026, 10, 19, 23, 59, 0), date(kolkata, 2026, 10, 20, 0, 0, 0)},
+		// 02:00 to 03:00 does not exist on 2026-03-08 in New York
+		{"skipped by DST", "30 2 * * *", date(newYork, 2026, 3, 8, 0, 0, 0), date(newYork, 2026, 3, 9, 2, 30, 0)},
+		{"hourly across DST", "0 * * * *", date(newYork, 2026, 3, 8, 1, 30, 0), date(newYork, 2026, 3, 8, 3, 0, 0)},
+		{"impossible date", "0 0 30 2 *", date(time.UTC, 2026, 1, 1, 0, 0, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Fatalf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+// The hour 01:00 to 02:00 repeats on 2026-11-01 in New York; a schedule in
+// it fires once
+func TestScheduleNextRepeatedHour(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+	s, err := ParseSchedule("30 1 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := s.Next(time.Date(2026, 11, 1, 0, 0, 0, 0, newYork))
+	if first.Day() != 1 || first.Hour() != 1 || first.Minute() != 30 {
+		t.Fatalf("first run at %v, want 01:30 on November 1", first)
+	}
+	next := s.Next(first)
+	if want := time.Date(2026, 11, 2, 1, 30, 0, 0, newYork); !next.Equal(want) {
+		t.Fatalf("run after %v at %v, want %v", first, next, want)
+	}
+
+	// Times in the repeated hour run in its first occurrence only
+	s, err = ParseSchedule("*/20 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []string
+	for at := s.Next(time.Date(2026, 11, 1, 0, 50, 0, 0, newYork)); len(runs) < 7; at = s.Next(at) {
+		runs = append(runs, at.Format("15:04 MST"))
+	}
+	want := []string{"01:00 EDT", "01:20 EDT", "01:40 EDT", "02:00 EST", "02:20 EST", "02:40 EST", "03:00 EST"}
+	if !reflect.DeepEqual(runs, want) {
+		t.Fatalf("runs = %q, want %q", runs, want)
+	}
+}
+
+// Every time Next returns is later than the previous one and matches the
+// schedule on the wall clock of its zone
+func TestScheduleNextMatches(t *testing.T) {
+	specs := []string{"*/7 * * * *", "0 */5 * * *", "30 2 * * *", "0 0 1,15 * 1", "45 23 * 2,8 *"}
+	zones := []*time.Location{
+		time.UTC,
+		mustLoad(t, "Asia/Kolkata"),
+		mustLoad(t, "America/New_York"),
+		mustLoad(t, "Australia/Lord_Howe"),
+	}
+	for _, spec := range specs {
+		s, err := ParseSchedule(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, loc := range zones {
+			at := time.Date(2026, 1, 1, 0, 0, 0, 0, loc)
+			for i := 0; i < 300; i++ {
+				next := s.Next(at)
+				if !next.After(at) {
+					t.Fatalf("%s in %s: Next(%v) = %v", spec, loc, at, next)
+				}
+				if next.Second() != 0 ||
+					s.minute&(1<<uint(next.Minute())) == 0 ||
+					s.hour&(1<<uint(next.Hour())) == 0 ||
+					s.month&(1<<uint(next.Month())) == 0 ||
+					!s.dayMatches(next) {
+					t.Fatalf("%s in %s: Next(%v) = %v does not match", spec, loc, at, next)
+				}
+				at = next
+			}
+		}
+	}
+}
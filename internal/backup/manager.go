@@ -1,54 +1,204 @@
-package internal
+package backup
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/bezata/blockchainml-email/internal/backup"
 	"github.com/bezata/blockchainml-email/internal/config"
-	"github.com/bezata/blockchainml-email/internal/r2"
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
 	"github.com/bezata/blockchainml-email/internal/storage"
-	"github.com/bezata/blockchainml-email/internal/zap"
+	"github.com/bezata/blockchainml-email/internal/storage/r2"
 	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
 )
 
+// Collections are the MongoDB collections captured by backups
+var Collections = []string{"emails", "staff", "threads", "blobs"}
+
+var ErrNoBaseBackup = errors.New("no completed backup to continue the chain from")
+
 type BackupManager struct {
+	db       *mongo.Database
 	storage  storage.BackupRepository
 	r2Client *r2.Client
 	logger   *zap.Logger
 	config   *config.Config
 }
 
-func (b *BackupManager) CreateBackup(ctx context.Context) error {
+func NewBackupManager(
+	db *mongo.Database,
+	repo storage.BackupRepository,
+	r2Client *r2.Client,
+	logger *zap.Logger,
+	cfg *config.Config,
+) *BackupManager {
+	return &BackupManager{
+		db:       db,
+		storage:  repo,
+		r2Client: r2Client,
+		logger:   logger,
+		config:   cfg,
+	}
+}
+
+// CreateBackup takes a full backup that starts a new backup chain
+func (b *BackupManager) CreateBackup(ctx context.Context) (*backup.Backup, error) {
 	backup := &backup.Backup{
 		ID:        uuid.New().String(),
+		Type:      backup.TypeFull,
 		Timestamp: time.Now(),
-		Status:    "in_progress",
+		Status:    backup.StatusInProgress,
 	}
 
 	// Create backup directory
 	backupDir := fmt.Sprintf("backups/%s", backup.ID)
+	backup.R2Path = backupDir
 
-	// Backup MongoDB
-	mongoBackup, err := b.backupMongoDB(ctx)
+	if err := b.storage.CreateBackup(ctx, backup); err != nil {
+		return nil, fmt.Errorf("failed to record backup: %w", err)
+	}
+
+	// Remember the change stream position before dumping so the next
+	// incremental backup replays anything written while the dump runs.
+	resumeToken, err := b.currentResumeToken(ctx)
 	if err != nil {
-		return fmt.Errorf("mongodb backup failed: %w", err)
+		b.logger.Warn("change streams unavailable, incremental backups will not be possible",
+			zap.String("backup_id", backup.ID),
+			zap.Error(err),
+		)
 	}
+	backup.ResumeToken = resumeToken
 
-	// Upload MongoDB backup to R2
-	mongoKey := fmt.Sprintf("%s/mongodb.gz", backupDir)
-	if err := b.r2Client.Upload(ctx, mongoKey, mongoBackup, "application/gzip"); err != nil {
-		return fmt.Errorf("failed to upload mongodb backup: %w", err)
+	// Backup MongoDB
+	segments, err := b.backupMongoDB(ctx, backupDir)
+	if err != nil {
+		return nil, b.fail(ctx, backup, fmt.Errorf("mongodb backup failed: %w", err))
 	}
+	backup.Manifest.Segments = segments
 
 	// Backup attachments
-	if err := b.backupAttachments(ctx, backupDir); err != nil {
-		return fmt.Errorf("attachments backup failed: %w", err)
+	attachments, err := b.backupAttachments(ctx, backupDir)
+	if err != nil {
+		return nil, b.fail(ctx, backup, fmt.Errorf("attachments backup failed: %w", err))
+	}
+	backup.Manifest.Attachments = attachments
+
+	if err := b.complete(ctx, backup); err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// backupMongoDB dumps every backed up collection into its own segment
+func (b *BackupManager) backupMongoDB(ctx context.Context, backupDir string) ([]backup.Segment, error) {
+	segments := make([]backup.Segment, 0, len(Collections))
+	for _, name := range Collections {
+		segment, err := b.dumpCollection(ctx, name, fmt.Sprintf("%s/mongodb/%s.bson.gz", backupDir, name))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+// dumpCollection streams every document of a collection to a segment at key
+func (b *BackupManager) dumpCollection(ctx context.Context, name, key string) (backup.Segment, error) {
+	cursor, err := b.db.Collection(name).Find(ctx, bson.D{})
+	if err != nil {
+		return backup.Segment{}, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer cursor.Close(ctx)
+
+	w := b.newSegmentWriter(ctx, name, key)
+	defer w.Abort()
+	for cursor.Next(ctx) {
+		if err := w.Write(cursor.Current); err != nil {
+			return backup.Segment{}, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return backup.Segment{}, fmt.Errorf("failed to read %s: %w", name, err)
 	}
 
+	return w.Close()
+}
+
+// attachmentPrefixes hold stored attachments: content-addressed blobs,
+// quarantined attachments and attachments stored per email before
+// deduplication
+var attachmentPrefixes = []string{r2.BlobPrefix, r2.QuarantinePrefix, "attachments/"}
+
+// backupAttachments copies all stored attachments under the backup directory
+func (b *BackupManager) backupAttachments(ctx context.Context, backupDir string) ([]string, error) {
+	var keys []string
+	for _, prefix := range attachmentPrefixes {
+		prefixKeys, err := b.r2Client.ListObjects(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, prefixKeys...)
+	}
+
+	for _, key := range keys {
+		if err := b.r2Client.Copy(ctx, key, fmt.Sprintf("%s/%s", backupDir, key)); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// currentResumeToken opens a change stream only to read its current position
+func (b *BackupManager) currentResumeToken(ctx context.Context) (bson.Raw, error) {
+	stream, err := b.db.Watch(ctx, collectionsPipeline())
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close(ctx)
+
+	// A first TryNext is needed for the server to hand out a resume token.
+	// An event returned here happened before the dump starts, so the dump
+	// already contains it and it is safe to resume after it.
+	stream.TryNext(ctx)
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+
+	return stream.ResumeToken(), nil
+}
+
+func (b *BackupManager) complete(ctx context.Context, backup *backup.Backup) error {
+	var total int64
+	for _, segment := range backup.Manifest.Segments {
+		total += segment.Size
+	}
+	backup.Manifest.TotalSize = total
 	backup.Status = "completed"
-	backup.R2Path = backupDir
-	
+	backup.CompletedAt = time.Now()
+
 	return b.storage.UpdateBackup(ctx, backup)
 }
+
+func (b *BackupManager) fail(ctx context.Context, backup *backup.Backup, cause error) error {
+	backup.Status = "failed"
+	backup.Error = cause.Error()
+	if err := b.storage.UpdateBackup(ctx, backup); err != nil {
+		b.logger.Error("failed to record backup failure",
+			zap.String("backup_id", backup.ID),
+			zap.Error(err),
+		)
+	}
+	return cause
+}
+
+func collectionsPipeline() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"ns.coll": bson.M{"$in": Collections}}}},
+	}
+}
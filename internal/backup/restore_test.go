@@ -0,0 +1,427 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestSegmentRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	b, _, bucket := newTestManager(t, nil)
+
+	// Enough documents for the compressor to flush several times
+	var docs []interface{}
+	for i := 0; i < 5000; i++ {
+		docs = append(docs, bson.D{{Key: "_id", Value: i}, {Key: "subject", Value: fmt.Sprintf("message %d", i)}})
+	}
+	segment := writeSegment(t, b, "emails", "backups/b1/mongodb/emails.bson.gz", docs...)
+
+	stored := bucket.get(segment.R2Key)
+	sum := sha256.Sum256(stored)
+	if segment.Collection != "emails" || segment.Documents != 5000 || segment.Size != int64(len(stored)) || segment.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("segment = %+v, stored %d bytes", segment, len(stored))
+	}
+
+	var read []int32
+	err := b.streamSegment(ctx, segment, func(doc bson.Raw) error {
+		read = append(read, doc.Lookup("_id").Int32())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 5000 {
+		t.Fatalf("read %d documents, want 5000", len(read))
+	}
+	for i, id := range read {
+		if int(id) != i {
+			t.Fatalf("document %d has _id %d, documents are out of order", i, id)
+		}
+	}
+
+	// An empty segment is valid
+	empty := writeSegment(t, b, "threads", "backups/b1/mongodb/threads.bson.gz")
+	if err := b.streamSegment(ctx, empty, func(bson.Raw) error { return errors.New("no documents expected") }); err != nil || empty.Documents != 0 {
+		t.Fatalf("empty segment %+v: %v", empty, err)
+	}
+}
+
+func TestSegmentAbort(t *testing.T) {
+	b, _, bucket := newTestManager(t, nil)
+
+	w := b.newSegmentWriter(context.Background(), "emails", "backups/b1/mongodb/emails.bson.gz")
+	raw, _ := bson.Marshal(bson.M{"_id": 1})
+	if err := w.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	w.Abort()
+	// Aborting twice, or after closing, does nothing
+	w.Abort()
+	if keys := bucket.keys(); len(keys) != 0 {
+		t.Fatalf("objects = %v, want none for an aborted segment", keys)
+	}
+}
+
+func TestStreamSegmentChecksum(t *testing.T) {
+	ctx := context.Background()
+	b, _, bucket := newTestManager(t, nil)
+	segment := writeSegment(t, b, "emails", "backups/b1/mongodb/emails.bson.gz", bson.M{"_id": 1}, bson.M{"_id": 2})
+
+	other := writeSegment(t, b, "emails", "backups/b2/mongodb/emails.bson.gz", bson.M{"_id": 3})
+	bucket.put(segment.R2Key, bucket.get(other.R2Key))
+
+	err := b.streamSegment(ctx, segment, func(bson.Raw) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("err = %v, want a checksum mismatch", err)
+	}
+
+	// The checksum is checked even when reading stops early
+	errStop := errors.New("stop")
+	err = b.streamSegment(ctx, segment, func(bson.Raw) error { return errStop })
+	if !errors.Is(err, errStop) {
+		t.Fatalf("err = %v, want the callback's error", err)
+	}
+	bucket.put(segment.R2Key, nil)
+	if err := b.streamSegment(ctx, segment, func(bson.Raw) error { return nil }); err == nil {
+		t.Fatal("read an empty object as the segment")
+	}
+}
+
+func TestReadSegmentMalformed(t *testing.T) {
+	compress := func(data []byte) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(data)
+		gz.Close()
+		return buf.Bytes()
+	}
+	doc, _ := bson.Marshal(bson.M{"_id": 1})
+	compressed := compress(doc)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"not gzip", []byte("plain text"), "failed to open segment"},
+		{"truncated document", compress(doc[:len(doc)-2]), "failed to read segment"},
+		{"truncated length", compress(doc[:2]), "failed to read segment"},
+		{"invalid length", compress([]byte{1, 0, 0, 0}), "invalid document length 1"},
+		{"truncated stream", compressed[:len(compressed)-6], "failed to read segment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readSegment(bytes.NewReader(tt.data), func(bson.Raw) error { return nil })
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreChain(t *testing.T) {
+	ctx := context.Background()
+	at := func(hour int) time.Time { return time.Date(2026, 10, 19, hour, 0, 0, 0, time.UTC) }
+	full := func(id string, hour int, status backup.Status) *backup.Backup {
+		return &backup.Backup{ID: id, Type: backup.TypeFull, Timestamp: at(hour), CompletedAt: at(hour), Status: status}
+	}
+	inc := func(id, base, parent string, hour int) *backup.Backup {
+		return &backup.Backup{
+			ID: id, Type: backup.TypeIncremental, BaseID: base, ParentID: parent,
+			Timestamp: at(hour), CompletedAt: at(hour), FirstChangeAt: at(hour - 1).Add(time.Minute),
+			Status: backup.StatusCompleted,
+		}
+	}
+	b, _, _ := newTestManager(t, nil,
+		full("f1", 1, backup.StatusCompleted),
+		inc("f1-1", "f1", "f1", 2),
+		inc("f1-2", "f1", "f1-1", 3),
+		full("f2", 5, backup.StatusCompleted),
+		inc("f2-1", "f2", "f2", 6),
+		full("f3", 8, backup.StatusFailed),
+		inc("f2-2", "f2", "f2-1", 9),
+	)
+
+	tests := []struct {
+		target time.Time
+		want   []string
+	}{
+		{at(1), []string{"f1"}},
+		{at(2), []string{"f1", "f1-1"}},
+		{at(2).Add(30 * time.Minute), []string{"f1", "f1-1", "f1-2"}},
+		{at(4), []string{"f1", "f1-1", "f1-2"}},
+		{at(5), []string{"f2"}},
+		// Failed full backups are no restore point, and incremental backups
+		// whose changes start after the target are left out
+		{at(8), []string{"f2", "f2-1"}},
+		{at(12), []string{"f2", "f2-1", "f2-2"}},
+	}
+	for _, tt := range tests {
+		chain, err := b.restoreChain(ctx, tt.target)
+		if err != nil {
+			t.Fatalf("restoreChain(%v): %v", tt.target, err)
+		}
+		var ids []string
+		for _, bk := range chain {
+			ids = append(ids, bk.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("restoreChain(%v) = %q, want %q", tt.target, ids, tt.want)
+		}
+	}
+
+	if _, err := b.restoreChain(ctx, at(0)); !errors.Is(err, ErrNoRestorePoint) {
+		t.Fatalf("before the first backup: err = %v, want ErrNoRestorePoint", err)
+	}
+}
+
+func TestReplaySegmentOrder(t *testing.T) {
+	ctx := context.Background()
+	b, _, _ := newTestManager(t, nil)
+	now := time.Now().Truncate(time.Second)
+
+	segment := writeSegment(t, b, "changes", "backups/inc/changes.bson.gz",
+		changeEvent(t, "delete", "emails", "e1", nil, now, 1),
+	)
+
+	// An event before the position already applied is refused before it
+	// touches the database
+	last := primitive.Timestamp{T: uint32(now.Unix()), I: 2}
+	if _, _, err := b.replaySegment(ctx, nil, segment, now, &last); err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Fatalf("err = %v, want the event out of order", err)
+	}
+
+	// Events after the target are left out
+	last = primitive.Timestamp{}
+	applied, lastAt, err := b.replaySegment(ctx, nil, segment, now.Add(-time.Second), &last)
+	if err != nil || applied != 0 || !lastAt.IsZero() || !last.IsZero() {
+		t.Fatalf("replayed %d changes up to %v (position %v, err %v), want none", applied, lastAt, last, err)
+	}
+}
+
+func TestUpdatedAttachments(t *testing.T) {
+	marshal := func(v interface{}) bson.Raw {
+		raw, err := bson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	tests := []struct {
+		name   string
+		fields bson.Raw
+		want   []string
+	}{
+		{"whole list", marshal(bson.D{{Key: "attachments", Value: bson.A{bson.M{"r2Key": "blobs/a"}, bson.M{"r2Key": "blobs/b"}}}}), []string{"blobs/a", "blobs/b"}},
+		{"one attachment", marshal(bson.D{{Key: "attachments.2", Value: bson.M{"r2Key": "blobs/c", "size": 3}}}), []string{"blobs/c"}},
+		{"key only", marshal(bson.D{{Key: "attachments.0.r2Key", Value: "quarantine/d"}}), []string{"quarantine/d"}},
+		{"other fields", marshal(bson.D{{Key: "subject", Value: "x"}, {Key: "attachments.0.scan", Value: bson.M{"status": "clean"}}}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachments, err := updatedAttachments(tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, a := range attachments {
+				keys = append(keys, a.R2Key)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Fatalf("keys = %q, want %q", keys, tt.want)
+			}
+		})
+	}
+}
+
+// dump returns every document of the backed up collections of db by
+// collection, sorted by _id
+func dump(t *testing.T, db *mongo.Database) map[string][]bson.M {
+	t.Helper()
+
+	ctx := context.Background()
+	docs := make(map[string][]bson.M)
+	for _, name := range Collections {
+		cursor, err := db.Collection(name).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var all []bson.M
+		if err := cursor.All(ctx, &all); err != nil {
+			t.Fatal(err)
+		}
+		if len(all) > 0 {
+			docs[name] = all
+		}
+	}
+	return docs
+}
+
+func TestApplyUpdate(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	coll := db.Collection("emails")
+	if _, err := coll.InsertOne(ctx, bson.M{
+		"_id":     "e1",
+		"subject": "Quarterly report",
+		"labels":  bson.A{"inbox", "work", "urgent", "q3"},
+		"flags":   bson.M{"isRead": false, "isStarred": true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	marshal := func(v interface{}) bson.Raw {
+		raw, err := bson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	// The update a server reports for shortening labels to two elements
+	// and replacing the second, reading the email and unstarring it
+	err := applyUpdate(ctx, coll, backup.ChangeEvent{
+		Operation:       "update",
+		Collection:      "emails",
+		DocumentKey:     marshal(bson.M{"_id": "e1"}),
+		UpdatedFields:   marshal(bson.D{{Key: "labels.1", Value: "archive"}, {Key: "flags.isRead", Value: true}}),
+		RemovedFields:   []string{"flags.isStarred"},
+		TruncatedArrays: []backup.TruncatedArray{{Field: "labels", NewSize: 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got bson.M
+	if err := coll.FindOne(ctx, bson.M{"_id": "e1"}).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{
+		"_id":     "e1",
+		"subject": "Quarterly report",
+		"labels":  bson.A{"inbox", "archive"},
+		"flags":   bson.M{"isRead": true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("email = %v, want %v", got, want)
+	}
+}
+
+// TestBackupRoundTrip takes a full and an incremental backup of a live
+// database and restores them, to the latest state and to a point between
+// the changes. Change streams need a replica set.
+func TestBackupRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := testDB(t)
+	target := testDB(t)
+	b, repo, bucket := newTestManager(t, source)
+	bucket.put("blobs/aa/aaaa", []byte("first attachment"))
+	bucket.put("blobs/bb/bbbb", []byte("second attachment"))
+
+	emails := source.Collection("emails")
+	if _, err := emails.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "e1", "subject": "Quarterly report", "labels": bson.A{"inbox", "work", "q3"}, "flags": bson.M{"isRead": false}},
+		bson.M{"_id": "e2", "subject": "Lunch", "labels": bson.A{"inbox"}},
+		bson.M{"_id": "e3", "subject": "Minutes", "attachments": bson.A{bson.M{"r2Key": "blobs/aa/aaaa"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Collection("staff").InsertOne(ctx, bson.M{"_id": "s1", "email": "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := b.CreateBackup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.ResumeToken == nil {
+		t.Skip("the deployment has no change streams")
+	}
+	afterFull := dump(t, source)
+
+	// Changes of every kind, in an order that only replays correctly in it
+	if _, err := emails.InsertOne(ctx, bson.M{"_id": "e4", "subject": "Draft", "attachments": bson.A{bson.M{"r2Key": "blobs/bb/bbbb"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := emails.UpdateOne(ctx, bson.M{"_id": "e1"}, bson.M{"$set": bson.M{"flags.isRead": true, "labels.1": "archive"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := emails.UpdateOne(ctx, bson.M{"_id": "e1"}, bson.M{"$pop": bson.M{"labels": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := emails.UpdateOne(ctx, bson.M{"_id": "e4"}, bson.M{"$set": bson.M{"subject": "Sent"}, "$unset": bson.M{"draft": ""}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := emails.ReplaceOne(ctx, bson.M{"_id": "e3"}, bson.M{"subject": "Minutes (revised)"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := emails.DeleteOne(ctx, bson.M{"_id": "e2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := emails.InsertOne(ctx, bson.M{"_id": "e2", "subject": "Lunch, again"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Collection("staff").UpdateOne(ctx, bson.M{"_id": "s1"}, bson.M{"$set": bson.M{"name": "Alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Cluster times have a resolution of a second
+	time.Sleep(1100 * time.Millisecond)
+
+	inc, err := b.CreateIncrementalBackup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inc.Manifest.Segments[0].Documents != 8 {
+		t.Fatalf("incremental backup captured %d changes, want 8", inc.Manifest.Segments[0].Documents)
+	}
+	if !reflect.DeepEqual(inc.Manifest.Attachments, []string{"blobs/bb/bbbb"}) || bucket.get(inc.R2Path+"/blobs/bb/bbbb") == nil {
+		t.Fatalf("incremental backup copied %q", inc.Manifest.Attachments)
+	}
+	latest := dump(t, source)
+
+	// Changes made after the incremental backup are not restored
+	if _, err := emails.DeleteOne(ctx, bson.M{"_id": "e1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := b.Restore(ctx, RestoreOptions{Database: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BaseID != full.ID || !reflect.DeepEqual(result.Applied, []string{full.ID, inc.ID}) || result.Documents != 4 || result.ChangesApplied != 8 {
+		t.Fatalf("restore = %+v", result)
+	}
+	if got := dump(t, target); !reflect.DeepEqual(got, latest) {
+		t.Fatalf("restored %v, want %v", got, latest)
+	}
+
+	// Restoring to the full backup leaves the changes out
+	stored, err := repo.GetBackup(ctx, inc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = b.Restore(ctx, RestoreOptions{Database: target, Target: stored.FirstChangeAt.Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ChangesApplied != 0 {
+		t.Fatalf("restore before the changes replayed %d of them", result.ChangesApplied)
+	}
+	if got := dump(t, target); !reflect.DeepEqual(got, afterFull) {
+		t.Fatalf("restored %v, want %v", got, afterFull)
+	}
+}
@@ -0,0 +1,207 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+	"go.uber.org/zap"
+)
+
+// RetentionPolicy decides which backup chains are kept. A chain is a full
+// backup together with all incremental backups built on top of it.
+type RetentionPolicy struct {
+	// KeepChains is the number of most recent chains that are always kept.
+	KeepChains int
+	// Daily, Weekly and Monthly keep the newest chain of each of the last
+	// that many days, ISO weeks and months (grandfather-father-son).
+	Daily   int
+	Weekly  int
+	Monthly int
+	// MaxAge prunes older chains only once their newest backup is older
+	// than this. Zero prunes them immediately.
+	MaxAge time.Duration
+}
+
+// RetentionPolicyFromConfig builds a policy from the backup configuration
+func RetentionPolicyFromConfig(cfg config.BackupRetentionConfig) RetentionPolicy {
+	return RetentionPolicy{
+		KeepChains: cfg.KeepChains,
+		Daily:      cfg.Daily,
+		Weekly:     cfg.Weekly,
+		Monthly:    cfg.Monthly,
+		MaxAge:     time.Duration(cfg.MaxAge),
+	}
+}
+
+// retained returns the chains the policy keeps. chains must be sorted newest
+// first.
+func (p RetentionPolicy) retained(chains []*chain, now time.Time) map[*chain]bool {
+	keep := p.KeepChains
+	if keep < 1 {
+		// Never prune the chain new backups are appended to
+		keep = 1
+	}
+
+	kept := make(map[*chain]bool)
+	for i := 0; i < keep && i < len(chains); i++ {
+		kept[chains[i]] = true
+	}
+	// The newest chains may have failed or still be running; the newest
+	// completed one is the last restorable backup and stays too
+	for _, c := range chains {
+		if c.base.Status == backup.StatusCompleted {
+			kept[c] = true
+			break
+		}
+	}
+
+	p.keepPerPeriod(chains, kept, p.Daily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	}, now.AddDate(0, 0, -p.Daily))
+	p.keepPerPeriod(chains, kept, p.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}, now.AddDate(0, 0, -7*p.Weekly))
+	p.keepPerPeriod(chains, kept, p.Monthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	}, now.AddDate(0, -p.Monthly, 0))
+
+	return kept
+}
+
+// keepPerPeriod keeps the newest chain of each of the last count periods
+func (p RetentionPolicy) keepPerPeriod(chains []*chain, kept map[*chain]bool, count int, period func(time.Time) string, since time.Time) {
+	if count <= 0 {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range chains {
+		if len(seen) >= count || c.base.Timestamp.Before(since) {
+			return
+		}
+		if c.base.Status != backup.StatusCompleted {
+			continue
+		}
+		key := period(c.base.Timestamp.UTC())
+		if !seen[key] {
+			seen[key] = true
+			kept[c] = true
+		}
+	}
+}
+
+type chain struct {
+	base   *backup.Backup
+	links  []*backup.Backup
+	newest time.Time
+}
+
+// Prune deletes the R2 objects and catalog entries of expired chains and
+// returns the IDs of the removed backups.
+func (b *BackupManager) Prune(ctx context.Context, policy RetentionPolicy) ([]string, error) {
+	chains, err := b.chains(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := policy.retained(chains, time.Now())
+
+	var removed []string
+	for _, c := range chains {
+		if kept[c] {
+			continue
+		}
+		if policy.MaxAge > 0 && time.Since(c.newest) < policy.MaxAge {
+			continue
+		}
+
+		ids, err := b.deleteChain(ctx, c)
+		removed = append(removed, ids...)
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// chains groups all backups by chain, newest chain first
+func (b *BackupManager) chains(ctx context.Context) ([]*chain, error) {
+	backups, err := b.storage.ListBackups(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	byBase := make(map[string]*chain)
+	for _, bk := range backups {
+		if bk.Type == backup.TypeFull {
+			byBase[bk.ID] = &chain{base: bk, newest: bk.Timestamp}
+		}
+	}
+	for _, bk := range backups {
+		if bk.Type != backup.TypeIncremental {
+			continue
+		}
+		c, ok := byBase[bk.BaseID]
+		if !ok {
+			continue
+		}
+		c.links = append(c.links, bk)
+		if bk.Timestamp.After(c.newest) {
+			c.newest = bk.Timestamp
+		}
+	}
+
+	chains := make([]*chain, 0, len(byBase))
+	for _, c := range byBase {
+		chains = append(chains, c)
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		return chains[i].base.Timestamp.After(chains[j].base.Timestamp)
+	})
+
+	return chains, nil
+}
+
+// deleteChain removes incremental backups first so a partially pruned chain
+// still starts with its full backup.
+func (b *BackupManager) deleteChain(ctx context.Context, c *chain) ([]string, error) {
+	var removed []string
+	for i := len(c.links) - 1; i >= 0; i-- {
+		if err := b.deleteBackup(ctx, c.links[i]); err != nil {
+			return removed, err
+		}
+		removed = append(removed, c.links[i].ID)
+	}
+
+	if err := b.deleteBackup(ctx, c.base); err != nil {
+		return removed, err
+	}
+	removed = append(removed, c.base.ID)
+
+	b.logger.Info("pruned backup chain",
+		zap.String("base_id", c.base.ID),
+		zap.Int("backups", len(removed)),
+	)
+
+	return removed, nil
+}
+
+func (b *BackupManager) deleteBackup(ctx context.Context, bk *backup.Backup) error {
+	keys, err := b.r2Client.ListObjects(ctx, bk.R2Path+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list objects of backup %s: %w", bk.ID, err)
+	}
+	for _, key := range keys {
+		if err := b.r2Client.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete objects of backup %s: %w", bk.ID, err)
+		}
+	}
+
+	return b.storage.DeleteBackup(ctx, bk.ID)
+}
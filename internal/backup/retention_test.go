@@ -0,0 +1,219 @@
+package backup
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+)
+
+func TestRetentionPolicyFromConfig(t *testing.T) {
+	got := RetentionPolicyFromConfig(config.BackupRetentionConfig{
+		KeepChains: 2,
+		Daily:      7,
+		Weekly:     4,
+		Monthly:    6,
+		MaxAge:     config.Duration(72 * time.Hour),
+	})
+	want := RetentionPolicy{KeepChains: 2, Daily: 7, Weekly: 4, Monthly: 6, MaxAge: 72 * time.Hour}
+	if got != want {
+		t.Fatalf("policy = %+v, want %+v", got, want)
+	}
+}
+
+// testChains builds chains from the base backup times, given newest first
+// as "2006-01-02 15:04"; a trailing "!" marks a failed backup
+func testChains(t *testing.T, specs ...string) []*chain {
+	t.Helper()
+
+	var chains []*chain
+	for _, spec := range specs {
+		status := backup.StatusCompleted
+		if spec[len(spec)-1] == '!' {
+			status = backup.StatusFailed
+			spec = spec[:len(spec)-1]
+		}
+		ts, err := time.Parse("2006-01-02 15:04", spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chains = append(chains, &chain{
+			base:   &backup.Backup{ID: spec, Type: backup.TypeFull, Timestamp: ts, Status: status},
+			newest: ts,
+		})
+	}
+	return chains
+}
+
+func keptIDs(kept map[*chain]bool) []string {
+	var ids []string
+	for c := range kept {
+		ids = append(ids, c.base.ID)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids
+}
+
+func TestRetained(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	twiceDaily := []string{
+		"2026-10-19 01:00",
+		"2026-10-18 13:00", "2026-10-18 01:00",
+		"2026-10-17 13:00", "2026-10-17 01:00",
+		"2026-10-16 13:00", "2026-10-16 01:00",
+		"2026-10-11 13:00", "2026-10-10 13:00",
+	}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		chains []string
+		want   []string
+	}{
+		{
+			name:   "newest chain without a policy",
+			chains: twiceDaily,
+			want:   []string{"2026-10-19 01:00"},
+		},
+		{
+			name:   "keep chains",
+			policy: RetentionPolicy{KeepChains: 3},
+			chains: twiceDaily,
+			want:   []string{"2026-10-19 01:00", "2026-10-18 13:00", "2026-10-18 01:00"},
+		},
+		{
+			name:   "newest chain per day",
+			policy: RetentionPolicy{KeepChains: 1, Daily: 3},
+			chains: twiceDaily,
+			want:   []string{"2026-10-19 01:00", "2026-10-18 13:00", "2026-10-17 13:00"},
+		},
+		{
+			name:   "days outside the window",
+			policy: RetentionPolicy{Daily: 8},
+			chains: twiceDaily,
+			// The 10th is more than eight days back
+			want: []string{"2026-10-19 01:00", "2026-10-18 13:00", "2026-10-17 13:00", "2026-10-16 13:00", "2026-10-11 13:00"},
+		},
+		{
+			name:   "newest chain per ISO week",
+			policy: RetentionPolicy{Weekly: 3},
+			chains: twiceDaily,
+			// The 19th starts week 43, the 11th ends week 41
+			want: []string{"2026-10-19 01:00", "2026-10-18 13:00", "2026-10-11 13:00"},
+		},
+		{
+			name:   "newest chain per month",
+			policy: RetentionPolicy{Monthly: 3},
+			chains: []string{"2026-10-01 00:00", "2026-09-15 00:00", "2026-09-01 00:00", "2026-08-20 00:00", "2026-06-01 00:00"},
+			want:   []string{"2026-10-01 00:00", "2026-09-15 00:00", "2026-08-20 00:00"},
+		},
+		{
+			name:   "grandfather-father-son",
+			policy: RetentionPolicy{KeepChains: 1, Daily: 2, Weekly: 2, Monthly: 2},
+			chains: append(append([]string(nil), twiceDaily...), "2026-09-30 00:00", "2026-09-01 00:00"),
+			want:   []string{"2026-10-19 01:00", "2026-10-18 13:00", "2026-09-30 00:00"},
+		},
+		{
+			name:   "failed chains fill no period",
+			policy: RetentionPolicy{Daily: 2},
+			chains: []string{"2026-10-19 01:00", "2026-10-18 13:00!", "2026-10-18 01:00"},
+			want:   []string{"2026-10-19 01:00", "2026-10-18 01:00"},
+		},
+		{
+			name:   "newest completed chain behind failed ones",
+			policy: RetentionPolicy{KeepChains: 1},
+			chains: []string{"2026-10-19 01:00!", "2026-10-18 13:00!", "2026-10-18 01:00", "2026-10-17 13:00"},
+			want:   []string{"2026-10-19 01:00", "2026-10-18 01:00"},
+		},
+		{
+			name:   "newest completed chain beyond the periods",
+			policy: RetentionPolicy{KeepChains: 1, Daily: 3},
+			chains: []string{"2026-10-19 01:00!", "2026-10-01 00:00", "2026-09-01 00:00"},
+			want:   []string{"2026-10-19 01:00", "2026-10-01 00:00"},
+		},
+		{
+			name: "no chains",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keptIDs(tt.policy.retained(testChains(t, tt.chains...), now))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	completed := func(id string, age time.Duration) *backup.Backup {
+		return &backup.Backup{ID: id, Type: backup.TypeFull, Timestamp: now.Add(-age), Status: backup.StatusCompleted, R2Path: "backups/" + id}
+	}
+	link := func(id, base string, age time.Duration) *backup.Backup {
+		return &backup.Backup{ID: id, Type: backup.TypeIncremental, BaseID: base, ParentID: base, Timestamp: now.Add(-age), Status: backup.StatusCompleted, R2Path: "backups/" + id}
+	}
+
+	running := completed("running", time.Minute)
+	running.Status = backup.StatusInProgress
+	backups := []*backup.Backup{
+		running,
+		// The newest completed chain, kept whatever its age
+		completed("newest", 30*24*time.Hour),
+		// Expired, but its newest incremental backup is within MaxAge
+		completed("recent", 40*24*time.Hour),
+		link("recent-1", "recent", time.Hour),
+		// Expired
+		completed("old", 50*24*time.Hour),
+		link("old-1", "old", 49*24*time.Hour),
+		link("old-2", "old", 48*24*time.Hour),
+	}
+	b, repo, bucket := newTestManager(t, nil, backups...)
+	for _, bk := range backups {
+		bucket.put(bk.R2Path+"/emails.bson.gz", []byte(bk.ID))
+	}
+
+	removed, err := b.Prune(ctx, RetentionPolicy{KeepChains: 1, MaxAge: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Incremental backups go before the full backup they build on
+	if want := []string{"old-2", "old-1", "old"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed %q, want %q", removed, want)
+	}
+
+	left, err := repo.ListBackups(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, bk := range left {
+		ids = append(ids, bk.ID)
+	}
+	if want := []string{"recent", "newest", "recent-1", "running"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("catalog holds %q, want %q", ids, want)
+	}
+	want := []string{
+		"backups/newest/emails.bson.gz",
+		"backups/recent-1/emails.bson.gz",
+		"backups/recent/emails.bson.gz",
+		"backups/running/emails.bson.gz",
+	}
+	if keys := bucket.keys(); !reflect.DeepEqual(keys, want) {
+		t.Fatalf("objects = %q, want %q", keys, want)
+	}
+
+	// Once past MaxAge the expired chain goes too
+	removed, err = b.Prune(ctx, RetentionPolicy{KeepChains: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"recent-1", "recent"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed %q, want %q", removed, want)
+	}
+}
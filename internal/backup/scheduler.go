@@ -0,0 +1,218 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	JobFull        = "full"
+	JobIncremental = "incremental"
+	JobVerify      = "verify"
+)
+
+type scheduledJob struct {
+	name     string
+	schedule *Schedule
+	run      func(ctx context.Context) (*backup.Backup, error)
+}
+
+// Scheduler runs full, incremental and verification jobs on their cron
+// schedules. Retention is applied after every successful full backup.
+type Scheduler struct {
+	manager *BackupManager
+	cfg     config.BackupConfig
+	scratch *mongo.Database
+	alerter Alerter
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+	jobs    []scheduledJob
+	mu      sync.Mutex // serializes jobs, they share the backup chain
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewScheduler validates the configured schedules. Empty schedules disable
+// the corresponding job.
+func NewScheduler(
+	manager *BackupManager,
+	cfg config.BackupConfig,
+	scratch *mongo.Database,
+	alerter Alerter,
+	logger *zap.Logger,
+	metrics *metrics.Metrics,
+) (*Scheduler, error) {
+	s := &Scheduler{
+		manager: manager,
+		cfg:     cfg,
+		scratch: scratch,
+		alerter: alerter,
+		logger:  logger,
+		metrics: metrics,
+	}
+
+	specs := []struct {
+		name string
+		spec string
+		run  func(ctx context.Context) (*backup.Backup, error)
+	}{
+		{JobFull, cfg.FullSchedule, s.runFull},
+		{JobIncremental, cfg.IncrementalSchedule, manager.CreateIncrementalBackup},
+		{JobVerify, cfg.VerifySchedule, s.runVerify},
+	}
+	for _, spec := range specs {
+		if spec.spec == "" {
+			continue
+		}
+		schedule, err := ParseSchedule(spec.spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s backup schedule: %w", spec.name, err)
+		}
+		s.jobs = append(s.jobs, scheduledJob{name: spec.name, schedule: schedule, run: spec.run})
+	}
+
+	if scratch == nil {
+		for _, job := range s.jobs {
+			if job.name == JobVerify {
+				return nil, errors.New("backup verification requires a scratch database")
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Start launches one goroutine per scheduled job
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+}
+
+// Stop cancels pending jobs and waits for running ones to return
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job scheduledJob) {
+	defer s.wg.Done()
+
+	for {
+		next := job.schedule.Next(time.Now())
+		if next.IsZero() {
+			s.logger.Error("backup schedule never fires", zap.String("job", job.name))
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.Run(ctx, job.name)
+	}
+}
+
+// Run executes a job immediately, recording metrics and raising an alert on
+// failure.
+func (s *Scheduler) Run(ctx context.Context, name string) error {
+	var job *scheduledJob
+	for i := range s.jobs {
+		if s.jobs[i].name == name {
+			job = &s.jobs[i]
+		}
+	}
+	if job == nil {
+		return fmt.Errorf("backup job %s is not scheduled", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := time.Now()
+	bk, err := job.run(ctx)
+	s.metrics.BackupDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		s.metrics.BackupRuns.WithLabelValues(name, "failed").Inc()
+		s.logger.Error("backup job failed", zap.String("job", name), zap.Error(err))
+
+		alert := Alert{Job: name, Message: err.Error(), Time: time.Now()}
+		if bk != nil {
+			alert.BackupID = bk.ID
+		}
+		if alertErr := s.alerter.Alert(ctx, alert); alertErr != nil {
+			s.logger.Error("failed to raise backup alert", zap.Error(alertErr))
+		}
+		return err
+	}
+
+	s.metrics.BackupRuns.WithLabelValues(name, "succeeded").Inc()
+	s.metrics.BackupLastSuccess.WithLabelValues(name).SetToCurrentTime()
+	if bk != nil && name != JobVerify {
+		s.metrics.BackupSize.WithLabelValues(string(bk.Type)).Set(float64(bk.Manifest.TotalSize))
+	}
+
+	return nil
+}
+
+func (s *Scheduler) runFull(ctx context.Context) (*backup.Backup, error) {
+	bk, err := s.manager.CreateBackup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := RetentionPolicyFromConfig(s.cfg.Retention)
+	removed, err := s.manager.Prune(ctx, policy)
+	if err != nil {
+		return bk, fmt.Errorf("backup %s succeeded but pruning failed: %w", bk.ID, err)
+	}
+	if len(removed) > 0 {
+		s.logger.Info("pruned expired backups", zap.Strings("backup_ids", removed))
+	}
+
+	return bk, nil
+}
+
+// runVerify verifies the newest backup that has not been verified yet
+func (s *Scheduler) runVerify(ctx context.Context) (*backup.Backup, error) {
+	bk, err := s.manager.LatestUnverified(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if bk == nil {
+		return nil, nil
+	}
+
+	verification, err := s.manager.Verify(ctx, bk.ID, VerifyOptions{
+		Scratch:           s.scratch,
+		SampleAttachments: s.cfg.Verification.SampleAttachments,
+	})
+	if err != nil {
+		return bk, err
+	}
+
+	if !verification.OK {
+		s.metrics.BackupVerifications.WithLabelValues("failed").Inc()
+		return bk, fmt.Errorf("backup %s failed verification: %s", bk.ID, verification.Error)
+	}
+	s.metrics.BackupVerifications.WithLabelValues("passed").Inc()
+	return bk, nil
+}
@@ -0,0 +1,164 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var errSegmentAborted = errors.New("segment aborted")
+
+// segmentWriter streams BSON documents in the mongodump format
+// (concatenated documents), gzipped, to a segment object in R2 as they
+// are written, so that a segment never has to fit in memory.
+type segmentWriter struct {
+	collection string
+	key        string
+	pipe       *io.PipeWriter
+	gz         *gzip.Writer
+	hash       hash.Hash
+	size       byteCounter
+	docs       int64
+	// done receives the outcome of the upload once the pipe is closed
+	done   chan error
+	closed bool
+}
+
+// newSegmentWriter starts uploading the segment of collection to key
+func (b *BackupManager) newSegmentWriter(ctx context.Context, collection, key string) *segmentWriter {
+	reader, pipe := io.Pipe()
+	w := &segmentWriter{
+		collection: collection,
+		key:        key,
+		pipe:       pipe,
+		hash:       sha256.New(),
+		done:       make(chan error, 1),
+	}
+	w.gz = gzip.NewWriter(io.MultiWriter(pipe, w.hash, &w.size))
+
+	go func() {
+		err := b.r2Client.Upload(ctx, key, reader, "application/gzip")
+		// Unblock the writer when the upload stopped reading early
+		reader.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *segmentWriter) Write(doc bson.Raw) error {
+	if _, err := w.gz.Write(doc); err != nil {
+		return fmt.Errorf("failed to write %s segment: %w", w.collection, err)
+	}
+	w.docs++
+	return nil
+}
+
+func (w *segmentWriter) Documents() int64 {
+	return w.docs
+}
+
+// Close flushes the compressor, waits for the upload to finish and
+// describes the stored segment
+func (w *segmentWriter) Close() (backup.Segment, error) {
+	w.closed = true
+	err := w.gz.Close()
+	if err != nil {
+		w.pipe.CloseWithError(err)
+	} else {
+		w.pipe.Close()
+	}
+	if uploadErr := <-w.done; uploadErr != nil {
+		return backup.Segment{}, fmt.Errorf("failed to upload %s segment: %w", w.collection, uploadErr)
+	}
+	if err != nil {
+		return backup.Segment{}, fmt.Errorf("failed to compress %s segment: %w", w.collection, err)
+	}
+
+	return backup.Segment{
+		Collection: w.collection,
+		R2Key:      w.key,
+		Documents:  w.docs,
+		Size:       int64(w.size),
+		SHA256:     hex.EncodeToString(w.hash.Sum(nil)),
+	}, nil
+}
+
+// Abort stops an unfinished upload, leaving no segment behind. It does
+// nothing once the writer is closed.
+func (w *segmentWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.pipe.CloseWithError(errSegmentAborted)
+	<-w.done
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// readSegment calls fn for every document of a segment streamed from r
+func readSegment(r io.Reader, fn func(doc bson.Raw) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer gz.Close()
+
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(gz, size[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read segment: %w", err)
+		}
+
+		length := binary.LittleEndian.Uint32(size[:])
+		if length < 5 {
+			return fmt.Errorf("invalid document length %d in segment", length)
+		}
+
+		doc := make([]byte, length)
+		copy(doc, size[:])
+		if _, err := io.ReadFull(gz, doc[4:]); err != nil {
+			return fmt.Errorf("failed to read segment: %w", err)
+		}
+
+		if err := fn(bson.Raw(doc)); err != nil {
+			return err
+		}
+	}
+}
+
+// verifyingReader hashes a segment as it is read and fails the read that
+// reaches its end when it does not match the manifest
+type verifyingReader struct {
+	io.Reader
+	segment backup.Segment
+	hash    hash.Hash
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if sum := hex.EncodeToString(r.hash.Sum(nil)); sum != r.segment.SHA256 {
+			return n, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", r.segment.R2Key, r.segment.SHA256, sum)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,244 @@
+package backup
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// unreachableDB is a database handle for verifications that fail before
+// restoring anything; dropping it fails quickly
+func unreachableDB(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client.Database("scratch")
+}
+
+// testChain stores a completed full backup of two emails and a staff
+// member, with an attachment copy, and an incremental backup on top of it
+// that inserts, updates and deletes emails
+func testChain(t *testing.T, b *BackupManager, repo *catalog, bucket *fakeR2) (full, inc *backup.Backup) {
+	t.Helper()
+
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Millisecond)
+	full = &backup.Backup{
+		ID:          "full",
+		Type:        backup.TypeFull,
+		Timestamp:   now.Add(-2 * time.Hour),
+		CompletedAt: now.Add(-2 * time.Hour),
+		Status:      backup.StatusCompleted,
+		R2Path:      "backups/full",
+		Manifest: backup.Manifest{
+			Segments: []backup.Segment{
+				writeSegment(t, b, "emails", "backups/full/emails.bson.gz",
+					bson.M{"_id": "e1", "subject": "Quarterly report", "labels": bson.A{"inbox"}},
+					bson.M{"_id": "e2", "subject": "Lunch"},
+				),
+				writeSegment(t, b, "staff", "backups/full/staff.bson.gz",
+					bson.M{"_id": "s1", "email": "alice@example.com"},
+				),
+			},
+			Attachments: []string{"blobs/ab/abcdef"},
+		},
+	}
+	bucket.put("backups/full/blobs/ab/abcdef", []byte("attachment"))
+
+	inc = &backup.Backup{
+		ID:            "inc",
+		Type:          backup.TypeIncremental,
+		BaseID:        full.ID,
+		ParentID:      full.ID,
+		Timestamp:     now.Add(-time.Hour),
+		CompletedAt:   now.Add(-time.Hour),
+		FirstChangeAt: now.Add(-90 * time.Minute),
+		Status:        backup.StatusCompleted,
+		R2Path:        "backups/inc",
+		Manifest: backup.Manifest{
+			Segments: []backup.Segment{
+				writeSegment(t, b, "changes", "backups/inc/changes.bson.gz",
+					changeEvent(t, "insert", "emails", "e3", bson.M{"_id": "e3", "subject": "Minutes"}, now.Add(-90*time.Minute), 1),
+					changeEvent(t, "update", "emails", "e1", nil, now.Add(-80*time.Minute), 2),
+					changeEvent(t, "delete", "emails", "e2", nil, now.Add(-70*time.Minute), 3),
+				),
+			},
+		},
+	}
+
+	for _, bk := range []*backup.Backup{full, inc} {
+		if err := repo.CreateBackup(ctx, bk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return full, inc
+}
+
+// changeEvent builds a stored change event; updates set the subject and
+// unset the labels of the document
+func changeEvent(t *testing.T, op, collection, id string, doc bson.M, at time.Time, position uint32) backup.ChangeEvent {
+	t.Helper()
+
+	marshal := func(v interface{}) bson.Raw {
+		raw, err := bson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	event := backup.ChangeEvent{
+		Operation:   op,
+		Collection:  collection,
+		DocumentKey: marshal(bson.M{"_id": id}),
+		ClusterTime: at,
+		Position:    primitive.Timestamp{T: uint32(at.Unix()), I: position},
+	}
+	if doc != nil {
+		event.FullDocument = marshal(doc)
+	}
+	if op == "update" {
+		event.UpdatedFields = marshal(bson.M{"subject": "Quarterly report (final)"})
+		event.RemovedFields = []string{"labels"}
+	}
+	return event
+}
+
+func TestVerifyRefusesUnverifiableBackups(t *testing.T) {
+	ctx := context.Background()
+	running := &backup.Backup{ID: "running", Type: backup.TypeFull, Status: backup.StatusInProgress}
+	b, _, _ := newTestManager(t, nil, running)
+
+	if _, err := b.Verify(ctx, "running", VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "scratch database") {
+		t.Fatalf("err = %v, want the scratch database required", err)
+	}
+	if _, err := b.Verify(ctx, "running", VerifyOptions{Scratch: unreachableDB(t)}); err == nil || !strings.Contains(err.Error(), "only completed backups") {
+		t.Fatalf("err = %v, want only completed backups verified", err)
+	}
+	if _, err := b.Verify(ctx, "missing", VerifyOptions{Scratch: unreachableDB(t)}); err == nil {
+		t.Fatal("verified a missing backup")
+	}
+}
+
+func TestVerifyRecordsBrokenChains(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("MissingAttachment", func(t *testing.T) {
+		b, repo, bucket := newTestManager(t, nil)
+		full, _ := testChain(t, b, repo, bucket)
+		bucket.mu.Lock()
+		delete(bucket.objects, "backups/full/blobs/ab/abcdef")
+		bucket.mu.Unlock()
+
+		v, err := b.Verify(ctx, full.ID, VerifyOptions{Scratch: unreachableDB(t)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.OK || !strings.Contains(v.Error, "attachment copy backups/full/blobs/ab/abcdef") {
+			t.Fatalf("verification = %+v, want the missing attachment", v)
+		}
+		stored, err := repo.GetBackup(ctx, full.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Verification == nil || stored.Verification.OK || stored.Verification.Error != v.Error {
+			t.Fatalf("recorded verification = %+v, want %+v", stored.Verification, v)
+		}
+	})
+
+	t.Run("MissingParent", func(t *testing.T) {
+		b, repo, bucket := newTestManager(t, nil)
+		_, inc := testChain(t, b, repo, bucket)
+		repo.DeleteBackup(ctx, "full")
+
+		v, err := b.Verify(ctx, inc.ID, VerifyOptions{Scratch: unreachableDB(t)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.OK || !strings.Contains(v.Error, "missing parent full") {
+			t.Fatalf("verification = %+v, want the missing parent", v)
+		}
+	})
+
+	t.Run("FailedParent", func(t *testing.T) {
+		b, repo, bucket := newTestManager(t, nil)
+		full, inc := testChain(t, b, repo, bucket)
+		full.Status = backup.StatusFailed
+		repo.UpdateBackup(ctx, full)
+
+		v, err := b.Verify(ctx, inc.ID, VerifyOptions{Scratch: unreachableDB(t)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.OK || !strings.Contains(v.Error, "depends on failed backup full") {
+			t.Fatalf("verification = %+v, want the failed parent", v)
+		}
+	})
+}
+
+func TestVerifyRestoresChain(t *testing.T) {
+	ctx := context.Background()
+	scratch := testDB(t)
+	b, repo, bucket := newTestManager(t, nil)
+	full, inc := testChain(t, b, repo, bucket)
+
+	v, err := b.Verify(ctx, inc.ID, VerifyOptions{Scratch: scratch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.OK || v.Documents != 3 || v.Changes != 3 {
+		t.Fatalf("verification = %+v, want 3 documents and 3 changes restored", v)
+	}
+	stored, err := repo.GetBackup(ctx, inc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Verification == nil || !stored.Verification.OK {
+		t.Fatalf("recorded verification = %+v", stored.Verification)
+	}
+	// The scratch database is dropped afterwards
+	names, err := scratch.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("scratch database holds %v after verification", names)
+	}
+
+	// A segment that changed in R2 fails its checksum
+	key := full.Manifest.Segments[1].R2Key
+	original := bucket.get(key)
+	other := writeSegment(t, b, "staff", "backups/other.bson.gz", bson.M{"_id": "s2"})
+	bucket.put(key, bucket.get(other.R2Key))
+	v, err = b.Verify(ctx, inc.ID, VerifyOptions{Scratch: scratch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.OK || !strings.Contains(v.Error, "checksum mismatch for "+key) {
+		t.Fatalf("verification = %+v, want a checksum mismatch", v)
+	}
+	bucket.put(key, original)
+
+	// So does a manifest that lists more documents than were stored
+	full.Manifest.Segments[0].Documents++
+	repo.UpdateBackup(ctx, full)
+	v, err = b.Verify(ctx, inc.ID, VerifyOptions{Scratch: scratch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.OK || !strings.Contains(v.Error, "restored 3 documents, manifest lists 4") {
+		t.Fatalf("verification = %+v, want a document count mismatch", v)
+	}
+}
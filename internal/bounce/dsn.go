@@ -0,0 +1,162 @@
+// Package bounce reads delivery status notifications (RFC 3464) and the
+// VERP return paths that tie asynchronous bounces to the email and
+// recipient they are about.
+package bounce
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// Content types of delivery status reports (RFC 3464, RFC 6533) and of the
+// returned message or its headers
+const (
+	ContentTypeDeliveryStatus       = "message/delivery-status"
+	ContentTypeGlobalDeliveryStatus = "message/global-delivery-status"
+	ContentTypeMessage              = "message/rfc822"
+	ContentTypeHeaders              = "text/rfc822-headers"
+)
+
+// Actions of a recipient's delivery status
+const (
+	ActionFailed    = "failed"
+	ActionDelayed   = "delayed"
+	ActionDelivered = "delivered"
+	ActionRelayed   = "relayed"
+	ActionExpanded  = "expanded"
+)
+
+// maxReportSize bounds the delivery status fields read from a report
+const maxReportSize = 1 << 20
+
+var ErrInvalidReport = errors.New("invalid delivery status report")
+
+// Report is a delivery status notification
+type Report struct {
+	ReportingMTA string
+	// EnvelopeID is the ENVID the message was sent with (RFC 3461)
+	EnvelopeID string
+	Recipients []Status
+}
+
+// Status is the delivery status of one recipient
+type Status struct {
+	// Recipient is the final recipient address; OriginalRecipient is the
+	// address given when sending, when the reporting MTA kept it
+	Recipient         string
+	OriginalRecipient string
+	Action            string
+	// Code is the RFC 3463 enhanced status code, such as 5.1.1
+	Code       string
+	RemoteMTA  string
+	Diagnostic string
+}
+
+// Permanent reports whether delivery failed for good
+func (s Status) Permanent() bool {
+	return s.Action == ActionFailed && !strings.HasPrefix(s.Code, "4.")
+}
+
+// HardBounce reports whether delivery failed for good because of the
+// address itself: it does not exist or its mailbox is disabled. Other
+// permanent failures, such as policy rejections or a full mailbox, say
+// nothing about later mail to the address.
+func (s Status) HardBounce() bool {
+	if !s.Permanent() {
+		return false
+	}
+	return s.Code == "" || strings.HasPrefix(s.Code, "5.1.") || s.Code == "5.2.1" || s.Code == "5.0.0"
+}
+
+// ParseReport reads the fields of a message/delivery-status part: a group
+// of per-message fields followed by a group of fields per recipient.
+// Recipients without a final recipient or action are skipped.
+func ParseReport(r io.Reader) (*Report, error) {
+	reader := textproto.NewReader(bufio.NewReader(io.LimitReader(r, maxReportSize)))
+
+	message, err := readGroup(reader)
+	if err != nil {
+		return nil, ErrInvalidReport
+	}
+	report := &Report{
+		ReportingMTA: typedValue(message.Get("Reporting-MTA")),
+		EnvelopeID:   strings.TrimSpace(message.Get("Original-Envelope-Id")),
+	}
+
+	for {
+		fields, err := readGroup(reader)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, ErrInvalidReport
+		}
+
+		status := Status{
+			Recipient:         address(fields.Get("Final-Recipient")),
+			OriginalRecipient: address(fields.Get("Original-Recipient")),
+			Action:            strings.ToLower(firstWord(fields.Get("Action"))),
+			Code:              firstWord(fields.Get("Status")),
+			RemoteMTA:         typedValue(fields.Get("Remote-MTA")),
+			Diagnostic:        typedValue(fields.Get("Diagnostic-Code")),
+		}
+		if status.Recipient == "" || status.Action == "" {
+			continue
+		}
+		report.Recipients = append(report.Recipients, status)
+	}
+
+	if len(report.Recipients) == 0 {
+		return nil, ErrInvalidReport
+	}
+	return report, nil
+}
+
+// MessageID returns the Message-ID of a message/rfc822 or
+// text/rfc822-headers part returned with a report, or "" when it has none
+func MessageID(r io.Reader) string {
+	// Headers returned without a body may lack the blank line ending them
+	msg, err := mail.ReadMessage(io.MultiReader(io.LimitReader(r, maxReportSize), strings.NewReader("\r\n\r\n")))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(msg.Header.Get("Message-Id"))
+}
+
+// readGroup reads the fields up to the next blank line, skipping blank
+// lines before them. It returns io.EOF when no fields are left.
+func readGroup(reader *textproto.Reader) (textproto.MIMEHeader, error) {
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if len(fields) > 0 {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// typedValue strips the type of a "type; value" field such as
+// "smtp; 550 5.1.1 User unknown"
+func typedValue(field string) string {
+	if _, value, ok := strings.Cut(field, ";"); ok {
+		field = value
+	}
+	return strings.TrimSpace(field)
+}
+
+func address(field string) string {
+	return strings.ToLower(strings.Trim(typedValue(field), "<>"))
+}
+
+func firstWord(field string) string {
+	if fields := strings.Fields(field); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
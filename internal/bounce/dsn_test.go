@@ -0,0 +1,288 @@
+package bounce
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Delivery status parts as sent by common MTAs, with their line endings
+const (
+	postfixReport = "Reporting-MTA: dns; mail.example.com\r\n" +
+		"X-Postfix-Queue-ID: 4Xk2Lw1Q7Yz9\r\n" +
+		"X-Postfix-Sender: rfc822; bounces+652f1c0a9d3e4b5a6c7d8e9f-1a2b3c4d-nobody=example.org@bounce.example.com\r\n" +
+		"Arrival-Date: Mon, 19 Oct 2026 09:12:44 +0000 (UTC)\r\n" +
+		"\r\n" +
+		"Final-Recipient: rfc822; nobody@example.org\r\n" +
+		"Original-Recipient: rfc822;Nobody@Example.org\r\n" +
+		"Action: failed\r\n" +
+		"Status: 5.1.1\r\n" +
+		"Remote-MTA: dns; mx1.example.org\r\n" +
+		"Diagnostic-Code: smtp; 550 5.1.1 <nobody@example.org>: Recipient address\r\n" +
+		"    rejected: User unknown in virtual mailbox table\r\n" +
+		"\r\n" +
+		"Final-Recipient: rfc822; slow@example.net\r\n" +
+		"Action: delayed\r\n" +
+		"Status: 4.4.1\r\n" +
+		"Diagnostic-Code: X-Postfix; connect to mx.example.net[192.0.2.25]:25: Connection timed out\r\n" +
+		"Will-Retry-Until: Mon, 24 Oct 2026 09:12:44 +0000 (UTC)\r\n"
+
+	eximReport = "Reporting-MTA: dns; mx.example.com\n" +
+		"\n" +
+		"Action: failed\n" +
+		"Final-Recipient: rfc822;full@example.org\n" +
+		"Status: 5.0.0\n" +
+		"Remote-MTA: dns; mx.example.org\n" +
+		"Diagnostic-Code: smtp; 552 5.2.2 Mailbox full\n"
+
+	gmailReport = "Reporting-MTA: dns; googlemail.com\r\n" +
+		"Received-From-MTA: dns; alice@example.com\r\n" +
+		"Arrival-Date: Mon, 19 Oct 2026 02:12:44 -0700 (PDT)\r\n" +
+		"X-Original-Message-ID: <report@example.com>\r\n" +
+		"\r\n" +
+		"Final-Recipient: rfc822; someone@example.org\r\n" +
+		"Action: failed\r\n" +
+		"Status: 5.7.26\r\n" +
+		"Remote-MTA: dns; gmail-smtp-in.l.google.com. (2001:db8::1b, the server for the domain example.org.)\r\n" +
+		"Diagnostic-Code: smtp; 550-5.7.26 Unauthenticated email from example.com is not accepted due to\r\n" +
+		" domain's DMARC policy.\r\n" +
+		"Last-Attempt-Date: Mon, 19 Oct 2026 02:12:45 -0700 (PDT)\r\n"
+
+	exchangeReport = "Reporting-MTA: dns;AM0PR01MB1234.eurprd01.prod.outlook.com\r\n" +
+		"Received-From-MTA: dns;mail.example.com\r\n" +
+		"Arrival-Date: Mon, 19 Oct 2026 09:12:44 +0000\r\n" +
+		"Original-Envelope-Id: 652f1c0a9d3e4b5a6c7d8e9f\r\n" +
+		"\r\n" +
+		"Final-Recipient: rfc822;gone@contoso.example\r\n" +
+		"Action: Failed\r\n" +
+		"Status: 5.1.10\r\n" +
+		"Diagnostic-Code: smtp;550 5.1.10 RESOLVER.ADR.RecipientNotFound; Recipient not found by SMTP address lookup\r\n"
+
+	// A global report for an internationalized address (RFC 6533)
+	globalReport = "Reporting-MTA: dns; mx.example.com\r\n" +
+		"\r\n" +
+		"Final-Recipient: utf-8; Ünïcode@Example.org\r\n" +
+		"Action: failed\r\n" +
+		"Status: 5.1.1 (bad destination mailbox address)\r\n"
+)
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+		want   *Report
+	}{
+		{
+			name:   "postfix",
+			report: postfixReport,
+			want: &Report{
+				ReportingMTA: "mail.example.com",
+				Recipients: []Status{
+					{
+						Recipient:         "nobody@example.org",
+						OriginalRecipient: "nobody@example.org",
+						Action:            ActionFailed,
+						Code:              "5.1.1",
+						RemoteMTA:         "mx1.example.org",
+						Diagnostic:        "550 5.1.1 <nobody@example.org>: Recipient address rejected: User unknown in virtual mailbox table",
+					},
+					{
+						Recipient:  "slow@example.net",
+						Action:     ActionDelayed,
+						Code:       "4.4.1",
+						Diagnostic: "connect to mx.example.net[192.0.2.25]:25: Connection timed out",
+					},
+				},
+			},
+		},
+		{
+			name:   "exim",
+			report: eximReport,
+			want: &Report{
+				ReportingMTA: "mx.example.com",
+				Recipients: []Status{{
+					Recipient:  "full@example.org",
+					Action:     ActionFailed,
+					Code:       "5.0.0",
+					RemoteMTA:  "mx.example.org",
+					Diagnostic: "552 5.2.2 Mailbox full",
+				}},
+			},
+		},
+		{
+			name:   "gmail",
+			report: gmailReport,
+			want: &Report{
+				ReportingMTA: "googlemail.com",
+				Recipients: []Status{{
+					Recipient:  "someone@example.org",
+					Action:     ActionFailed,
+					Code:       "5.7.26",
+					RemoteMTA:  "gmail-smtp-in.l.google.com. (2001:db8::1b, the server for the domain example.org.)",
+					Diagnostic: "550-5.7.26 Unauthenticated email from example.com is not accepted due to domain's DMARC policy.",
+				}},
+			},
+		},
+		{
+			name:   "exchange",
+			report: exchangeReport,
+			want: &Report{
+				ReportingMTA: "AM0PR01MB1234.eurprd01.prod.outlook.com",
+				EnvelopeID:   "652f1c0a9d3e4b5a6c7d8e9f",
+				Recipients: []Status{{
+					Recipient:  "gone@contoso.example",
+					Action:     ActionFailed,
+					Code:       "5.1.10",
+					Diagnostic: "550 5.1.10 RESOLVER.ADR.RecipientNotFound; Recipient not found by SMTP address lookup",
+				}},
+			},
+		},
+		{
+			name:   "global",
+			report: globalReport,
+			want: &Report{
+				ReportingMTA: "mx.example.com",
+				Recipients:   []Status{{Recipient: "ünïcode@example.org", Action: ActionFailed, Code: "5.1.1"}},
+			},
+		},
+		{
+			name: "blank lines around groups",
+			report: "\r\n\r\nReporting-MTA: dns; mx.example.com\r\n\r\n\r\n" +
+				"Final-Recipient: rfc822; <a@example.org>\r\nAction: failed\r\nStatus: 5.1.1\r\n\r\n\r\n",
+			want: &Report{
+				ReportingMTA: "mx.example.com",
+				Recipients:   []Status{{Recipient: "a@example.org", Action: ActionFailed, Code: "5.1.1"}},
+			},
+		},
+		{
+			name: "incomplete recipients skipped",
+			report: "Reporting-MTA: dns; mx.example.com\n\n" +
+				"Action: failed\nStatus: 5.1.1\n\n" +
+				"Final-Recipient: rfc822; b@example.org\nStatus: 5.1.1\n\n" +
+				"Final-Recipient: rfc822; c@example.org\nAction: failed\n",
+			want: &Report{
+				ReportingMTA: "mx.example.com",
+				Recipients:   []Status{{Recipient: "c@example.org", Action: ActionFailed}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReport(strings.NewReader(tt.report))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("report = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReportMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+	}{
+		{"empty", ""},
+		{"blank lines only", "\r\n\r\n\r\n"},
+		{"per-message fields only", "Reporting-MTA: dns; mx.example.com\r\n"},
+		// Without the blank line the recipient fields are per-message fields
+		{"no group separator", "Reporting-MTA: dns; mx.example.com\r\nFinal-Recipient: rfc822; a@example.org\r\nAction: failed\r\n"},
+		{"no recipient with an action", "Reporting-MTA: dns; mx.example.com\r\n\r\nFinal-Recipient: rfc822; a@example.org\r\n"},
+		{"not header fields", "This is the mail system at host mail.example.com.\r\n\r\nI'm sorry to have to inform you...\r\n"},
+		{"malformed recipient group", "Reporting-MTA: dns; mx.example.com\r\n\r\nFinal-Recipient rfc822 a@example.org\r\n"},
+		{"binary", "\x00\x01\x02\xff\xfe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if report, err := ParseReport(strings.NewReader(tt.report)); !errors.Is(err, ErrInvalidReport) {
+				t.Fatalf("ParseReport = %+v, %v, want ErrInvalidReport", report, err)
+			}
+		})
+	}
+
+	// Fields past the size limit are not read
+	huge := "Reporting-MTA: dns; mx.example.com\r\nX-Padding: " + strings.Repeat("x", maxReportSize) + "\r\n\r\n" +
+		"Final-Recipient: rfc822; a@example.org\r\nAction: failed\r\n"
+	if _, err := ParseReport(strings.NewReader(huge)); !errors.Is(err, ErrInvalidReport) {
+		t.Fatalf("err = %v, want a report past the size limit refused", err)
+	}
+}
+
+func TestStatusClassification(t *testing.T) {
+	tests := []struct {
+		action, code    string
+		permanent, hard bool
+	}{
+		{ActionFailed, "5.1.1", true, true},
+		{ActionFailed, "5.1.10", true, true},
+		{ActionFailed, "5.2.1", true, true},
+		{ActionFailed, "5.0.0", true, true},
+		// Without a code a failure is taken as permanent
+		{ActionFailed, "", true, true},
+		{ActionFailed, "5.2.2", true, false},
+		{ActionFailed, "5.7.1", true, false},
+		{ActionFailed, "5.7.26", true, false},
+		{ActionFailed, "4.4.7", false, false},
+		{ActionDelayed, "4.4.1", false, false},
+		{ActionDelayed, "5.1.1", false, false},
+		{ActionDelivered, "2.0.0", false, false},
+		{ActionRelayed, "", false, false},
+		{ActionExpanded, "2.0.0", false, false},
+	}
+	for _, tt := range tests {
+		s := Status{Recipient: "a@example.org", Action: tt.action, Code: tt.code}
+		if s.Permanent() != tt.permanent || s.HardBounce() != tt.hard {
+			t.Errorf("%s %s: permanent = %v, hard = %v, want %v, %v", tt.action, tt.code, s.Permanent(), s.HardBounce(), tt.permanent, tt.hard)
+		}
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	tests := []struct {
+		name string
+		part string
+		want string
+	}{
+		{
+			name: "returned message",
+			part: "Return-Path: <alice@example.com>\r\nMessage-ID: <report@example.com>\r\nSubject: Quarterly report\r\n\r\nFigures attached\r\n",
+			want: "<report@example.com>",
+		},
+		{
+			name: "headers with their blank line",
+			part: "From: alice@example.com\r\nMessage-Id:  <report@example.com> \r\n\r\n",
+			want: "<report@example.com>",
+		},
+		{
+			name: "headers without a blank line",
+			part: "From: alice@example.com\r\nMessage-Id: <report@example.com>",
+			want: "<report@example.com>",
+		},
+		{
+			name: "folded header",
+			part: "From: alice@example.com\nMessage-ID:\n <report@example.com>\n",
+			want: "<report@example.com>",
+		},
+		{
+			name: "no message ID",
+			part: "From: alice@example.com\r\nSubject: Quarterly report\r\n\r\n",
+		},
+		{
+			name: "not a message",
+			part: "\x00\x01 not a header",
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MessageID(strings.NewReader(tt.part)); got != tt.want {
+				t.Fatalf("MessageID = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
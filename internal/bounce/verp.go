@@ -0,0 +1,72 @@
+package bounce
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// idLength is the length of an email ID, an ObjectID in hex
+	idLength = 24
+	// tagLength is the length of the signature of a return path, in hex
+	tagLength = 8
+)
+
+// VERP encodes the email and recipient a message was sent for in its
+// return path, so that bounces arriving later tell them even without a
+// readable report. Return paths look like
+//
+//	bounces+<email ID>-<tag>-<local part>=<domain>@<bounce domain>
+//
+// where the tag signs the email ID and recipient, so that forged bounces
+// cannot suppress arbitrary addresses.
+type VERP struct {
+	Prefix string
+	Domain string
+	Secret []byte
+}
+
+// ReturnPath returns the return path of the email with emailID for
+// recipient
+func (v *VERP) ReturnPath(emailID, recipient string) string {
+	recipient = strings.ToLower(recipient)
+	local, domain, _ := strings.Cut(recipient, "@")
+	return v.Prefix + "+" + emailID + "-" + v.tag(emailID, recipient) + "-" + local + "=" + domain + "@" + v.Domain
+}
+
+// Decode returns the email ID and recipient of a return path, and false
+// when address is not a return path signed by v
+func (v *VERP) Decode(address string) (emailID, recipient string, ok bool) {
+	address = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+	at := strings.LastIndex(address, "@")
+	if at < 0 || address[at+1:] != strings.ToLower(v.Domain) {
+		return "", "", false
+	}
+
+	rest, ok := strings.CutPrefix(address[:at], strings.ToLower(v.Prefix)+"+")
+	if !ok || len(rest) < idLength+tagLength+3 || rest[idLength] != '-' || rest[idLength+tagLength+1] != '-' {
+		return "", "", false
+	}
+	emailID = rest[:idLength]
+	tag := rest[idLength+1 : idLength+tagLength+1]
+
+	encoded := rest[idLength+tagLength+2:]
+	eq := strings.LastIndex(encoded, "=")
+	if eq <= 0 || eq == len(encoded)-1 {
+		return "", "", false
+	}
+	recipient = encoded[:eq] + "@" + encoded[eq+1:]
+
+	if !hmac.Equal([]byte(tag), []byte(v.tag(emailID, recipient))) {
+		return "", "", false
+	}
+	return emailID, recipient, true
+}
+
+func (v *VERP) tag(emailID, recipient string) string {
+	mac := hmac.New(sha256.New, v.Secret)
+	mac.Write([]byte(emailID + "\x00" + recipient))
+	return hex.EncodeToString(mac.Sum(nil))[:tagLength]
+}
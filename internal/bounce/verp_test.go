@@ -0,0 +1,101 @@
+package bounce
+
+import (
+	"strings"
+	"testing"
+)
+
+const testEmailID = "652f1c0a9d3e4b5a6c7d8e9f"
+
+func testVERP() *VERP {
+	return &VERP{Prefix: "bounces", Domain: "bounce.example.com", Secret: []byte("secret")}
+}
+
+func TestVERPRoundTrip(t *testing.T) {
+	v := testVERP()
+	recipients := []string{
+		"alice@example.org",
+		"Alice.Smith@Example.org",
+		"first-last@example.org",
+		"a+tag@example.org",
+		"a=b@example.org",
+		"bounces+x@example.org",
+		"o'neil@sub.example.co.uk",
+	}
+	for _, recipient := range recipients {
+		returnPath := v.ReturnPath(testEmailID, recipient)
+		if !strings.HasPrefix(returnPath, "bounces+"+testEmailID+"-") || !strings.HasSuffix(returnPath, "@bounce.example.com") {
+			t.Fatalf("ReturnPath(%q) = %q", recipient, returnPath)
+		}
+		emailID, got, ok := v.Decode(returnPath)
+		if !ok || emailID != testEmailID || got != strings.ToLower(recipient) {
+			t.Fatalf("Decode(%q) = %q, %q, %v, want %q", returnPath, emailID, got, ok, strings.ToLower(recipient))
+		}
+	}
+}
+
+func TestVERPDecodeAsReceived(t *testing.T) {
+	v := testVERP()
+	returnPath := v.ReturnPath(testEmailID, "alice@example.org")
+
+	// Bounces come back to the return path however the MTA wrote it
+	for _, address := range []string{
+		"<" + returnPath + ">",
+		"  " + returnPath + "\r\n",
+		strings.ToUpper(returnPath),
+	} {
+		if emailID, recipient, ok := v.Decode(address); !ok || emailID != testEmailID || recipient != "alice@example.org" {
+			t.Errorf("Decode(%q) = %q, %q, %v", address, emailID, recipient, ok)
+		}
+	}
+
+	// A configuration written in another case decodes the same
+	mixed := &VERP{Prefix: "Bounces", Domain: "Bounce.Example.com", Secret: v.Secret}
+	if _, _, ok := mixed.Decode(returnPath); !ok {
+		t.Errorf("Decode(%q) with a mixed case configuration failed", returnPath)
+	}
+}
+
+func TestVERPDecodeRejects(t *testing.T) {
+	v := testVERP()
+	returnPath := v.ReturnPath(testEmailID, "alice@example.org")
+	local, _, _ := strings.Cut(returnPath, "@")
+	tag := returnPath[len("bounces+")+idLength+1 : len("bounces+")+idLength+tagLength+1]
+	forgedTag := strings.Repeat("0", tagLength)
+	if tag == forgedTag {
+		forgedTag = strings.Repeat("1", tagLength)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+	}{
+		{"empty", ""},
+		{"no domain", local},
+		{"other domain", local + "@example.com"},
+		{"other prefix", strings.Replace(returnPath, "bounces+", "returns+", 1)},
+		{"no prefix separator", strings.Replace(returnPath, "bounces+", "bounces-", 1)},
+		{"plain address", "bounces@bounce.example.com"},
+		{"forged tag", strings.Replace(returnPath, tag, forgedTag, 1)},
+		{"other recipient", strings.Replace(returnPath, "-alice=", "-mallory=", 1)},
+		{"other email", strings.Replace(returnPath, testEmailID, "652f1c0a9d3e4b5a6c7d8e90", 1)},
+		{"short email ID", "bounces+652f1c0a-" + tag + "-alice=example.org@bounce.example.com"},
+		{"truncated", returnPath[:len("bounces+")+idLength+tagLength+2] + "@bounce.example.com"},
+		{"no recipient domain", strings.Replace(returnPath, "=example.org@", "=@", 1)},
+		{"no recipient local part", strings.Replace(returnPath, "-alice=", "-=", 1)},
+		{"no encoded at", strings.Replace(returnPath, "alice=example.org", "alice.example.org", 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if emailID, recipient, ok := v.Decode(tt.address); ok {
+				t.Fatalf("Decode(%q) = %q, %q, want it refused", tt.address, emailID, recipient)
+			}
+		})
+	}
+
+	// A return path signed with another secret is refused
+	other := &VERP{Prefix: v.Prefix, Domain: v.Domain, Secret: []byte("other")}
+	if _, _, ok := other.Decode(returnPath); ok {
+		t.Fatal("decoded a return path signed with another secret")
+	}
+}
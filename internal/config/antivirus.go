@@ -0,0 +1,14 @@
+package config
+
+// AntivirusConfig connects attachment scanning to a clamd daemon
+type AntivirusConfig struct {
+	Enabled bool `json:"enabled"`
+	// Network is tcp, with Address as host:port, or unix, with Address as
+	// the socket path
+	Network string   `json:"network"`
+	Address string   `json:"address"`
+	Timeout Duration `json:"timeout"`
+	// FailOpen accepts attachments that could not be scanned, recording the
+	// failure on the attachment, instead of rejecting them
+	FailOpen bool `json:"failOpen"`
+}
@@ -0,0 +1,30 @@
+package config
+
+type BackupConfig struct {
+	Enabled             bool                     `json:"enabled"`
+	FullSchedule        string                   `json:"fullSchedule"`
+	IncrementalSchedule string                   `json:"incrementalSchedule"`
+	VerifySchedule      string                   `json:"verifySchedule"`
+	Retention           BackupRetentionConfig    `json:"retention"`
+	Verification        BackupVerificationConfig `json:"verification"`
+	AlertWebhookURL     string                   `json:"alertWebhookUrl"`
+}
+
+// BackupRetentionConfig is a grandfather-father-son policy: the newest chain
+// of each of the last Daily days, Weekly weeks and Monthly months is kept.
+type BackupRetentionConfig struct {
+	KeepChains int `json:"keepChains"`
+	Daily      int `json:"daily"`
+	Weekly     int `json:"weekly"`
+	Monthly    int `json:"monthly"`
+	// MaxAge keeps chains the policy expires until their newest backup is
+	// this old
+	MaxAge Duration `json:"maxAge"`
+}
+
+type BackupVerificationConfig struct {
+	ScratchDatabase string `json:"scratchDatabase"`
+	// SampleAttachments limits how many attachment copies are checked per
+	// backup; zero checks all of them.
+	SampleAttachments int `json:"sampleAttachments"`
+}
@@ -0,0 +1,14 @@
+package config
+
+// BounceConfig sets the VERP return paths of outbound mail. Bounces sent to
+// them are tied to the email and recipient they are about. Return paths
+// are not used while Domain is empty.
+type BounceConfig struct {
+	// Domain receives the bounces, such as bounces.example.com; its MX
+	// must deliver to this server
+	Domain string `json:"domain"`
+	// Prefix is the local part before the encoded email and recipient
+	Prefix string `json:"prefix"`
+	// Secret signs return paths so that forged bounces are ignored
+	Secret string `json:"secret"`
+}
@@ -0,0 +1,15 @@
+package config
+
+// CampaignConfig tunes bulk campaign sending
+type CampaignConfig struct {
+	// DefaultRate is the number of emails per minute of campaigns that set
+	// none; MaxRate caps the rate campaigns may set
+	DefaultRate int `json:"defaultRate"`
+	MaxRate     int `json:"maxRate"`
+	// MaxRecipients bounds the recipient list of one campaign
+	MaxRecipients int `json:"maxRecipients"`
+	// UnsubscribeURL is the public address of the unsubscribe endpoint,
+	// such as https://mail.example.com/api/v1/unsubscribe. Recipient tokens
+	// are appended to it in links and List-Unsubscribe headers.
+	UnsubscribeURL string `json:"unsubscribeUrl"`
+}
@@ -2,21 +2,32 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"time"
 )
 
 type Config struct {
-	Server ServerConfig `json:"server"`
+	Server     ServerConfig     `json:"server"`
+	Storage    StorageConfig    `json:"storage"`
 	MongoDB    MongoDBConfig    `json:"mongodb"`
-	Redis      interface{}      `json:"redis"`
+	Postgres   PostgresConfig   `json:"postgres"`
+	Redis      RedisConfig      `json:"redis"`
 	R2         R2Config         `json:"r2"`
 	JWT        JWTConfig        `json:"jwt"`
 	Monitoring MonitoringConfig `json:"monitoring"`
-	Search     interface{}     `json:"search"`
-	Cache      interface{}     `json:"cache"`
+	Search     SearchConfig     `json:"search"`
+	Cache      CacheConfig      `json:"cache"`
 	Realtime   RealtimeConfig   `json:"realtime"`
 	Cloudflare CloudflareConfig `json:"cloudflare"`
+	Security   SecurityConfig   `json:"security"`
+	Backup     BackupConfig     `json:"backup"`
+	Antivirus  AntivirusConfig  `json:"antivirus"`
+	Spam       SpamConfig       `json:"spam"`
+	Jobs       JobsConfig       `json:"jobs"`
+	Campaign   CampaignConfig   `json:"campaign"`
+	Bounce     BounceConfig     `json:"bounce"`
+	Delivery   DeliveryConfig   `json:"delivery"`
 }
 
 type ServerConfig struct {
@@ -27,12 +38,42 @@ type ServerConfig struct {
 	MaxRequestSize  int64  `json:"maxRequestSize"`
 }
 
+// Storage backends selectable with StorageConfig.Backend
+const (
+	StorageMongoDB  = "mongodb"
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+type StorageConfig struct {
+	// Backend holds the repositories: mongodb, postgres, or memory for
+	// local development where nothing is persisted
+	Backend string `json:"backend"`
+}
+
+// MongoDBConfig configures the mongodb storage backend. The deployment
+// must be a replica set, possibly of a single member, or a sharded
+// cluster: writes use transactions, and a standalone mongod is refused at
+// startup.
 type MongoDBConfig struct {
 	URI             string `json:"uri"`
 	Database        string `json:"database"`
 	MaxPoolSize     uint64 `json:"maxPoolSize"`
 	MinPoolSize     uint64 `json:"minPoolSize"`
 	MaxConnIdleTime int    `json:"maxConnIdleTime"`
+	// MigrateOnStart applies pending schema migrations before serving
+	MigrateOnStart  bool   `json:"migrateOnStart"`
+}
+
+type PostgresConfig struct {
+	// DSN is a lib/pq connection string, either a postgres:// URL or
+	// space separated key=value pairs
+	DSN             string   `json:"dsn"`
+	MaxOpenConns    int      `json:"maxOpenConns"`
+	MaxIdleConns    int      `json:"maxIdleConns"`
+	ConnMaxLifetime Duration `json:"connMaxLifetime"`
+	// MigrateOnStart applies pending schema migrations before serving
+	MigrateOnStart bool `json:"migrateOnStart"`
 }
 
 type RedisConfig struct {
@@ -83,7 +124,7 @@ type SearchConfig struct {
 }
 
 type CacheConfig struct {
-	DefaultTTL  time.Duration `json:"defaultTTL"`
+	DefaultTTL  Duration      `json:"defaultTTL"`
 	MaxEntries  int64         `json:"maxEntries"`
 	MaxMemory   string        `json:"maxMemory"`
 }
@@ -104,36 +145,146 @@ type CloudflareConfig struct {
 }
 
 
-// LoadConfig loads config from file and environment variables
-func LoadConfig(path string) (*Config, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+// Duration is a time.Duration that reads from JSON and YAML either as a
+// Go duration string such as "5m" or, like time.Duration, as a number of
+// nanoseconds.
+type Duration time.Duration
 
-	var config Config
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return nil, err
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
 	}
 
-	// Override with environment variables
-	if mongoURI := os.Getenv("MONGODB_URI"); mongoURI != "" {
-		config.MongoDB.URI = mongoURI
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(time.Duration(v))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", data)
 	}
+	return nil
+}
 
-	return &config, nil
+// Default returns the configuration used for fields that are neither set in
+// the configuration file nor in the environment.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:           "8080",
+			Host:           "0.0.0.0",
+			ReadTimeout:    30,
+			WriteTimeout:   30,
+			MaxRequestSize: 25 << 20,
+		},
+		Storage: StorageConfig{
+			Backend: StorageMongoDB,
+		},
+		MongoDB: MongoDBConfig{
+			URI:             "mongodb://localhost:27017",
+			Database:        "email",
+			MaxPoolSize:     100,
+			MaxConnIdleTime: 300,
+		},
+		Postgres: PostgresConfig{
+			MaxOpenConns:    50,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: Duration(30 * time.Minute),
+		},
+		Redis: RedisConfig{
+			Addr: "localhost:6379",
+		},
+		R2: R2Config{
+			Region: "auto",
+		},
+		JWT: JWTConfig{
+			ExpiresIn: 60,
+		},
+		Monitoring: MonitoringConfig{
+			Prometheus: PrometheusConfig{Port: 9090, Path: "/metrics"},
+			Logging:    LoggingConfig{Level: "info", Format: "json"},
+		},
+		Search: SearchConfig{
+			IndexPrefix: "emails",
+		},
+		Cache: CacheConfig{
+			DefaultTTL: Duration(5 * time.Minute),
+			MaxEntries: 10000,
+		},
+		Realtime: RealtimeConfig{
+			EnableWebSocket: true,
+			PingInterval:    30,
+			WriteTimeout:    10,
+			ReadTimeout:     60,
+		},
+		Security: SecurityConfig{
+			RateLimit: RateLimitConfig{RequestsPerMinute: 600, BurstSize: 100},
+		},
+		Backup: BackupConfig{
+			Retention:    BackupRetentionConfig{KeepChains: 1, Daily: 7, Weekly: 4, Monthly: 6},
+			Verification: BackupVerificationConfig{ScratchDatabase: "email_backup_verify"},
+		},
+		Antivirus: AntivirusConfig{
+			Network: "tcp",
+			Address: "localhost:3310",
+			Timeout: Duration(2 * time.Minute),
+		},
+		Spam: SpamConfig{
+			Enabled:            true,
+			Threshold:          5,
+			BayesWeight:        8,
+			DNSBLScore:         3,
+			DNSBLTimeout:       Duration(5 * time.Second),
+			MinTrainedMessages: 20,
+		},
+		Jobs: JobsConfig{
+			Enabled:      true,
+			Workers:      2,
+			PollInterval: Duration(5 * time.Second),
+			Lease:        Duration(5 * time.Minute),
+		},
+		Campaign: CampaignConfig{
+			DefaultRate:   60,
+			MaxRate:       600,
+			MaxRecipients: 10000,
+		},
+		Bounce: BounceConfig{
+			Prefix: "bounces",
+		},
+		Delivery: DeliveryConfig{
+			MaxAttempts: 8,
+		},
+	}
 }
 
-func Load() (*Config, error) {
-    // Implementation of configuration loading
-    // This could be from environment variables, file, etc.
-    return &Config{
-        Server: ServerConfig{
-            Port:         "8080",
-            ReadTimeout:  30,
-            WriteTimeout: 30,
-        },
-        // ... other config initializations
-    }, nil
+// Load builds the configuration from defaults, the optional file at path
+// (JSON, or YAML for .yaml/.yml files) and environment variables, then
+// validates it. See applyEnv for the environment variable mapping.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
 }
@@ -0,0 +1,76 @@
+package config
+
+// Transport types of TransportConfig
+const (
+	TransportSMTP = "smtp"
+	// TransportHTTP posts messages to a JSON webhook
+	TransportHTTP = "http"
+	// TransportCloudflare posts messages to a Cloudflare Email Worker,
+	// by default at https://<cloudflare.workersDomain>/send with
+	// cloudflare.apiToken
+	TransportCloudflare = "cloudflare"
+)
+
+// DeliveryConfig sets how outbound mail leaves the server. Each recipient
+// is sent through the first transport whose routing rules match; mail is
+// not delivered while no transport is configured.
+type DeliveryConfig struct {
+	Transports []TransportConfig `json:"transports"`
+	// MaxAttempts is how often delivery to a recipient is tried before a
+	// temporary failure is given up as a bounce
+	MaxAttempts int `json:"maxAttempts"`
+}
+
+// TransportConfig is an outbound transport with its routing rules. Empty
+// domain lists match every domain; "*.example.com" also matches the
+// subdomains of example.com.
+type TransportConfig struct {
+	Name             string              `json:"name"`
+	Type             string              `json:"type"`
+	SenderDomains    []string            `json:"senderDomains"`
+	RecipientDomains []string            `json:"recipientDomains"`
+	SMTP             SMTPTransportConfig `json:"smtp"`
+	HTTP             HTTPTransportConfig `json:"http"`
+}
+
+// SMTPTransportConfig relays mail through a smarthost such as a corporate
+// relay or a transactional provider's SMTP endpoint
+type SMTPTransportConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// Security is tls for implicit TLS, starttls or none
+	Security string `json:"security"`
+	// Auth is the SMTP AUTH mechanism, plain, login or xoauth2, or empty
+	// to send without logging in
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	// Password is the password, or the access token for xoauth2.
+	// PasswordFile is read at every login instead, for rotated secrets
+	// and refreshed tokens.
+	Password       string   `json:"password"`
+	PasswordFile   string   `json:"passwordFile"`
+	HeloName       string   `json:"heloName"`
+	MaxConnections int      `json:"maxConnections"`
+	IdleTimeout    Duration `json:"idleTimeout"`
+	Timeout        Duration `json:"timeout"`
+}
+
+// HTTPTransportConfig sends mail through an HTTP email API, see
+// transport.HTTPConfig for the request format
+type HTTPTransportConfig struct {
+	URL string `json:"url"`
+	// Token is sent as a bearer token; TokenFile is read at every request
+	// instead when set
+	Token     string            `json:"token"`
+	TokenFile string            `json:"tokenFile"`
+	Headers   map[string]string `json:"headers"`
+	Timeout   Duration          `json:"timeout"`
+	// Retries is how often a request failing temporarily is repeated
+	// within one delivery attempt, RetryDelay apart and doubling
+	Retries    int      `json:"retries"`
+	RetryDelay Duration `json:"retryDelay"`
+	// WebhookSecret verifies the delivery events the provider posts to
+	// /api/v1/webhooks/delivery/<transport name>; events are refused
+	// while it is empty
+	WebhookSecret string `json:"webhookSecret"`
+}
@@ -0,0 +1,14 @@
+package config
+
+// JobsConfig tunes the background job worker
+type JobsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Workers is the number of jobs run concurrently
+	Workers int `json:"workers"`
+	// PollInterval is how long an idle worker waits before looking for
+	// due jobs again
+	PollInterval Duration `json:"pollInterval"`
+	// Lease is how long a job is held by its worker; a job still running
+	// after it is taken over by another worker
+	Lease Duration `json:"lease"`
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile decodes a JSON or YAML file over cfg. Unknown keys are rejected so
+// typos do not silently fall back to defaults.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// YAML is converted to JSON so both formats share the json tags
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if doc == nil {
+			return nil
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("failed to convert config file %s: %w", path, err)
+		}
+	case ".json", "":
+	default:
+		return fmt.Errorf("unsupported config file format %q", filepath.Ext(path))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv overrides every configuration field from the environment. The
+// variable name is the field's JSON path in upper snake case, e.g.
+// mongodb.uri is MONGODB_URI and server.maxRequestSize is
+// SERVER_MAX_REQUEST_SIZE. Appending _FILE to a name reads the value from a
+// file instead, which is how secrets are mounted. Slices are comma separated.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	var errs []error
+	walkFields(reflect.ValueOf(cfg).Elem(), "", "", func(field reflect.Value, path, env string) {
+		value, ok, err := envValue(env, lookup)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			return
+		}
+		if !ok {
+			return
+		}
+		if err := setField(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s (from %s): %w", path, env, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// EnvVars lists the environment variable for every configuration field
+func EnvVars() []string {
+	var names []string
+	walkFields(reflect.ValueOf(Default()).Elem(), "", "", func(_ reflect.Value, _ string, env string) {
+		names = append(names, env)
+	})
+	return names
+}
+
+func envValue(name string, lookup func(string) (string, bool)) (string, bool, error) {
+	if value, ok := lookup(name); ok {
+		return value, true, nil
+	}
+
+	file, ok := lookup(name + "_FILE")
+	if !ok || file == "" {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read secret file for %s: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// walkFields calls fn for every leaf field of a config struct together
+// with its JSON path and environment variable name.
+func walkFields(v reflect.Value, path, env string, fn func(field reflect.Value, path, env string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fieldPath, fieldEnv := name, envName(name)
+		if path != "" {
+			fieldPath = path + "." + name
+			fieldEnv = env + "_" + fieldEnv
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Time{}) {
+			walkFields(field, fieldPath, fieldEnv, fn)
+			continue
+		}
+		fn(field, fieldPath, fieldEnv)
+	}
+}
+
+// envName converts a camelCase JSON name to UPPER_SNAKE_CASE, keeping
+// acronyms together: accessKeyId is ACCESS_KEY_ID, defaultTTL is DEFAULT_TTL.
+func envName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", value)
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
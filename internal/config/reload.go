@@ -0,0 +1,100 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// Reloader re-reads the configuration on SIGHUP. Only fields that are safe to
+// change at runtime are applied: the log level, the rate limits and the
+// maximum request size. Changes to any other field are reported and take
+// effect on the next restart.
+type Reloader struct {
+	path     string
+	logger   *zap.Logger
+	mu       sync.RWMutex
+	current  *Config
+	handlers []func(*Config)
+}
+
+func NewReloader(path string, cfg *Config, logger *zap.Logger) *Reloader {
+	return &Reloader{
+		path:    path,
+		logger:  logger,
+		current: cfg,
+	}
+}
+
+// Current returns the live configuration. Callers must not modify it.
+func (r *Reloader) Current() *Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// OnReload registers fn to be called with the new configuration after every
+// successful reload.
+func (r *Reloader) OnReload(fn func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers = append(r.handlers, fn)
+}
+
+// Watch reloads the configuration on every SIGHUP until ctx is done
+func (r *Reloader) Watch(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := r.Reload(); err != nil {
+				r.logger.Error("configuration reload failed, keeping current configuration", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Reload loads and validates the configuration and applies its safe fields
+func (r *Reloader) Reload() error {
+	loaded, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	next := *r.current
+	next.Monitoring.Logging.Level = loaded.Monitoring.Logging.Level
+	next.Security.RateLimit = loaded.Security.RateLimit
+	next.Server.MaxRequestSize = loaded.Server.MaxRequestSize
+
+	// Anything else that differs needs a restart
+	if !reflect.DeepEqual(&next, loaded) {
+		r.logger.Warn("configuration changes other than log level, rate limits and maximum request size require a restart")
+	}
+
+	r.current = &next
+	handlers := append([]func(*Config){}, r.handlers...)
+	r.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(&next)
+	}
+
+	r.logger.Info("configuration reloaded",
+		zap.String("log_level", next.Monitoring.Logging.Level),
+		zap.Int("rate_limit_rpm", next.Security.RateLimit.RequestsPerMinute),
+		zap.Int("rate_limit_burst", next.Security.RateLimit.BurstSize),
+		zap.Int64("max_request_size", next.Server.MaxRequestSize),
+	)
+	return nil
+}
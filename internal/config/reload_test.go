@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestReloadAppliesSafeFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(maxRequestSize int64, port string) {
+		t.Helper()
+		data := `{
+			"server": {"port": "` + port + `", "maxRequestSize": ` + strconv.FormatInt(maxRequestSize, 10) + `},
+			"jwt": {"secret": "0123456789abcdef0123456789abcdef"},
+			"security": {"rateLimit": {"requestsPerMinute": 60, "burstSize": 10}}
+		}`
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(1<<20, "8080")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader := NewReloader(path, cfg, zap.NewNop())
+
+	var applied *Config
+	reloader.OnReload(func(next *Config) { applied = next })
+
+	write(2<<20, "9090")
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied == nil {
+		t.Fatal("reload handler was not called")
+	}
+	if got := reloader.Current().Server.MaxRequestSize; got != 2<<20 {
+		t.Errorf("max request size = %d, want %d", got, 2<<20)
+	}
+	if got := applied.Server.MaxRequestSize; got != 2<<20 {
+		t.Errorf("handler got max request size %d, want %d", got, 2<<20)
+	}
+	// The port needs a restart
+	if got := reloader.Current().Server.Port; got != "8080" {
+		t.Errorf("port = %q, want the original 8080", got)
+	}
+}
@@ -0,0 +1,17 @@
+package config
+
+// SpamConfig tunes the spam filter applied to inbound mail
+type SpamConfig struct {
+	Enabled bool `json:"enabled"`
+	// Threshold is the score from which mail is labeled spam
+	Threshold   float64 `json:"threshold"`
+	BayesWeight float64 `json:"bayesWeight"`
+	// DNSBLZones are queried with the delivering server's IP, e.g.
+	// zen.spamhaus.org; each listing adds DNSBLScore
+	DNSBLZones   []string `json:"dnsblZones"`
+	DNSBLScore   float64  `json:"dnsblScore"`
+	DNSBLTimeout Duration `json:"dnsblTimeout"`
+	// MinTrainedMessages is how many spam and ham messages a user must
+	// mark before their own model is used instead of the global one
+	MinTrainedMessages int64 `json:"minTrainedMessages"`
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ValidationError lists every invalid field found by Validate
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, field, format string, args ...interface{}) {
+	if !ok {
+		v.problems = append(v.problems, field+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// Validate checks the whole configuration and reports all problems at once
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	v.check(err == nil && port > 0 && port < 65536, "server.port", "must be a port number, got %q", c.Server.Port)
+	v.check(c.Server.ReadTimeout > 0, "server.readTimeout", "must be positive")
+	v.check(c.Server.WriteTimeout > 0, "server.writeTimeout", "must be positive")
+	v.check(c.Server.MaxRequestSize > 0, "server.maxRequestSize", "must be positive")
+
+	v.check(c.Storage.Backend == StorageMongoDB || c.Storage.Backend == StoragePostgres || c.Storage.Backend == StorageMemory,
+		"storage.backend", "must be mongodb, postgres or memory, got %q", c.Storage.Backend)
+
+	v.check(strings.HasPrefix(c.MongoDB.URI, "mongodb://") || strings.HasPrefix(c.MongoDB.URI, "mongodb+srv://"),
+		"mongodb.uri", "must start with mongodb:// or mongodb+srv://")
+	v.check(c.MongoDB.Database != "", "mongodb.database", "is required")
+	v.check(c.MongoDB.MaxPoolSize == 0 || c.MongoDB.MinPoolSize <= c.MongoDB.MaxPoolSize,
+		"mongodb.minPoolSize", "must not exceed maxPoolSize")
+
+	if c.Storage.Backend == StoragePostgres {
+		v.check(c.Postgres.DSN != "", "postgres.dsn", "is required for the postgres storage backend")
+		v.check(c.Postgres.MaxOpenConns >= 0, "postgres.maxOpenConns", "must not be negative")
+		v.check(c.Postgres.MaxIdleConns <= c.Postgres.MaxOpenConns || c.Postgres.MaxOpenConns == 0,
+			"postgres.maxIdleConns", "must not exceed maxOpenConns")
+	}
+
+	_, _, err = net.SplitHostPort(c.Redis.Addr)
+	v.check(err == nil, "redis.addr", "must be host:port, got %q", c.Redis.Addr)
+	v.check(c.Redis.DB >= 0, "redis.db", "must not be negative")
+
+	if c.R2.Bucket != "" || c.R2.AccessKeyID != "" {
+		v.check(c.R2.Bucket != "", "r2.bucket", "is required when R2 is configured")
+		v.check(c.R2.AccessKeyID != "", "r2.accessKeyId", "is required when R2 is configured")
+		v.check(c.R2.SecretAccessKey != "", "r2.secretAccessKey", "is required when R2 is configured")
+		v.check(c.R2.Endpoint == "" || isURL(c.R2.Endpoint), "r2.endpoint", "must be an absolute URL")
+	}
+
+	v.check(len(c.JWT.Secret) >= 32, "jwt.secret", "must be at least 32 characters")
+	v.check(c.JWT.ExpiresIn > 0, "jwt.expiresIn", "must be positive")
+
+	v.check(validLogLevel(c.Monitoring.Logging.Level), "monitoring.logging.level",
+		"must be one of debug, info, warn, error, got %q", c.Monitoring.Logging.Level)
+	v.check(c.Monitoring.Logging.Format == "json" || c.Monitoring.Logging.Format == "console",
+		"monitoring.logging.format", "must be json or console")
+	v.check(c.Monitoring.Prometheus.Port >= 0 && c.Monitoring.Prometheus.Port < 65536,
+		"monitoring.prometheus.port", "must be a port number")
+	v.check(!c.Monitoring.Tracing.Enabled || isURL(c.Monitoring.Tracing.Endpoint),
+		"monitoring.tracing.endpoint", "is required when tracing is enabled")
+
+	for i, u := range c.Search.ElasticsearchURLs {
+		v.check(isURL(u), fmt.Sprintf("search.elasticsearchUrls[%d]", i), "must be an absolute URL")
+	}
+
+	v.check(c.Cache.DefaultTTL >= 0, "cache.defaultTTL", "must not be negative")
+	v.check(c.Cache.MaxEntries >= 0, "cache.maxEntries", "must not be negative")
+
+	if c.Realtime.EnableWebSocket {
+		v.check(c.Realtime.PingInterval > 0, "realtime.pingInterval", "must be positive")
+	}
+
+	v.check(c.Security.RateLimit.RequestsPerMinute >= 0, "security.rateLimit.requestsPerMinute", "must not be negative")
+	v.check(c.Security.RateLimit.BurstSize >= 0, "security.rateLimit.burstSize", "must not be negative")
+	v.check(c.Security.EncryptionKey == "" || len(c.Security.EncryptionKey) >= 32, "security.encryptionKey",
+		"must be at least 32 characters")
+	for i, cidr := range c.Security.Cloudflare.AllowedIPs {
+		v.check(validIPOrCIDR(cidr), fmt.Sprintf("security.cloudflare.allowedIps[%d]", i), "must be an IP or CIDR")
+	}
+
+	if c.Backup.Enabled {
+		v.check(c.Storage.Backend == StorageMongoDB, "backup.enabled", "requires the mongodb storage backend")
+		v.check(c.Backup.FullSchedule != "", "backup.fullSchedule", "is required when backups are enabled")
+		v.check(c.Backup.VerifySchedule == "" || c.Backup.Verification.ScratchDatabase != "",
+			"backup.verification.scratchDatabase", "is required when verification is scheduled")
+		v.check(c.Backup.Verification.ScratchDatabase != c.MongoDB.Database,
+			"backup.verification.scratchDatabase", "must differ from mongodb.database")
+		v.check(c.Backup.AlertWebhookURL == "" || isURL(c.Backup.AlertWebhookURL),
+			"backup.alertWebhookUrl", "must be an absolute URL")
+	}
+
+	if c.Antivirus.Enabled {
+		v.check(c.Antivirus.Network == "tcp" || c.Antivirus.Network == "unix",
+			"antivirus.network", "must be tcp or unix, got %q", c.Antivirus.Network)
+		if c.Antivirus.Network == "tcp" {
+			_, _, err := net.SplitHostPort(c.Antivirus.Address)
+			v.check(err == nil, "antivirus.address", "must be host:port, got %q", c.Antivirus.Address)
+		} else {
+			v.check(c.Antivirus.Address != "", "antivirus.address", "is required when antivirus is enabled")
+		}
+		v.check(c.Antivirus.Timeout > 0, "antivirus.timeout", "must be positive")
+	}
+
+	if c.Spam.Enabled {
+		v.check(c.Spam.Threshold > 0, "spam.threshold", "must be positive")
+		v.check(c.Spam.BayesWeight >= 0, "spam.bayesWeight", "must not be negative")
+		v.check(c.Spam.DNSBLScore >= 0, "spam.dnsblScore", "must not be negative")
+		v.check(len(c.Spam.DNSBLZones) == 0 || c.Spam.DNSBLTimeout > 0, "spam.dnsblTimeout",
+			"must be positive when DNSBL zones are configured")
+		v.check(c.Spam.MinTrainedMessages >= 0, "spam.minTrainedMessages", "must not be negative")
+	}
+
+	if c.Jobs.Enabled {
+		v.check(c.Jobs.Workers > 0, "jobs.workers", "must be positive")
+		v.check(c.Jobs.PollInterval > 0, "jobs.pollInterval", "must be positive")
+		v.check(c.Jobs.Lease > 0, "jobs.lease", "must be positive")
+	}
+
+	v.check(c.Campaign.DefaultRate > 0, "campaign.defaultRate", "must be positive")
+	v.check(c.Campaign.MaxRate >= c.Campaign.DefaultRate, "campaign.maxRate", "must be at least campaign.defaultRate")
+	v.check(c.Campaign.MaxRecipients > 0, "campaign.maxRecipients", "must be positive")
+	v.check(c.Campaign.UnsubscribeURL == "" || isURL(c.Campaign.UnsubscribeURL),
+		"campaign.unsubscribeUrl", "must be an absolute URL")
+
+	if c.Bounce.Domain != "" {
+		v.check(c.Bounce.Prefix != "" && !strings.ContainsAny(c.Bounce.Prefix, "+@ "),
+			"bounce.prefix", "must be a local part without +, @ or spaces, got %q", c.Bounce.Prefix)
+		v.check(len(c.Bounce.Secret) >= 16, "bounce.secret", "must be at least 16 characters")
+	}
+
+	v.check(c.Delivery.MaxAttempts > 0, "delivery.maxAttempts", "must be positive")
+	v.check(len(c.Delivery.Transports) == 0 || c.Jobs.Enabled, "delivery.transports", "require jobs.enabled")
+	names := make(map[string]bool)
+	for i, transport := range c.Delivery.Transports {
+		field := fmt.Sprintf("delivery.transports[%d]", i)
+		v.check(transport.Name != "" && !names[transport.Name], field+".name", "must be set and unique, got %q", transport.Name)
+		names[transport.Name] = true
+		switch transport.Type {
+		case TransportSMTP:
+			validateSMTPTransport(v, field+".smtp", transport.SMTP)
+		case TransportHTTP:
+			v.check(isURL(transport.HTTP.URL), field+".http.url", "must be an absolute URL")
+			validateHTTPTransport(v, field+".http", transport.HTTP)
+		case TransportCloudflare:
+			v.check(transport.HTTP.URL == "" || isURL(transport.HTTP.URL), field+".http.url", "must be an absolute URL")
+			v.check(transport.HTTP.URL != "" || c.Cloudflare.WorkersDomain != "", field+".http.url",
+				"or cloudflare.workersDomain is required for the cloudflare transport")
+			validateHTTPTransport(v, field+".http", transport.HTTP)
+		default:
+			v.check(false, field+".type", "must be smtp, http or cloudflare, got %q", transport.Type)
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func validateSMTPTransport(v *validator, field string, c SMTPTransportConfig) {
+	v.check(c.Host != "", field+".host", "is required")
+	v.check(c.Port > 0 && c.Port < 65536, field+".port", "must be a port number")
+	v.check(c.Security == "tls" || c.Security == "starttls" || c.Security == "none",
+		field+".security", "must be tls, starttls or none, got %q", c.Security)
+	v.check(c.Auth == "" || c.Auth == "plain" || c.Auth == "login" || c.Auth == "xoauth2",
+		field+".auth", "must be plain, login or xoauth2, got %q", c.Auth)
+	if c.Auth != "" {
+		v.check(c.Username != "", field+".username", "is required with auth")
+		v.check(c.Password != "" || c.PasswordFile != "", field+".password", "or passwordFile is required with auth")
+		v.check(c.Security != "none" || c.Host == "localhost" || c.Host == "127.0.0.1" || c.Host == "::1",
+			field+".security", "must be tls or starttls to send credentials to a remote relay")
+	}
+	v.check(c.MaxConnections >= 0, field+".maxConnections", "must not be negative")
+	v.check(c.IdleTimeout >= 0, field+".idleTimeout", "must not be negative")
+	v.check(c.Timeout >= 0, field+".timeout", "must not be negative")
+}
+
+func validateHTTPTransport(v *validator, field string, c HTTPTransportConfig) {
+	v.check(c.Timeout >= 0, field+".timeout", "must not be negative")
+	v.check(c.Retries >= 0, field+".retries", "must not be negative")
+	v.check(c.RetryDelay >= 0, field+".retryDelay", "must not be negative")
+	v.check(c.WebhookSecret == "" || len(c.WebhookSecret) >= 16, field+".webhookSecret", "must be at least 16 characters")
+}
+
+func validLogLevel(level string) bool {
+	switch level {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func validIPOrCIDR(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
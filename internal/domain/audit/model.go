@@ -0,0 +1,36 @@
+package audit
+
+import "time"
+
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+// Event records a security relevant action
+type Event struct {
+	ID         string            `bson:"_id" json:"id"`
+	Timestamp  time.Time         `bson:"timestamp" json:"timestamp"`
+	ActorID    string            `bson:"actorId" json:"actorId"`
+	Action     string            `bson:"action" json:"action"`
+	Resource   string            `bson:"resource" json:"resource"`
+	ResourceID string            `bson:"resourceId,omitempty" json:"resourceId,omitempty"`
+	IP         string            `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent  string            `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	Risk       string            `bson:"risk" json:"risk"`
+	Details    map[string]string `bson:"details,omitempty" json:"details,omitempty"`
+}
+
+// ListQuery filters audit events. Events are returned newest first.
+type ListQuery struct {
+	ActorID    string
+	Action     string
+	Resource   string
+	ResourceID string
+	Risk       string
+	Since      *time.Time
+	Until      *time.Time
+	Cursor     string
+	Limit      int
+}
@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Type string
+
+const (
+	TypeFull        Type = "full"
+	TypeIncremental Type = "incremental"
+)
+
+type Status string
+
+const (
+	StatusInProgress Status = "in_progress"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+)
+
+// Backup describes one link of a backup chain. A chain starts with a full
+// backup and continues with incremental backups that each reference the
+// previous link through ParentID and the chain's full backup through BaseID.
+type Backup struct {
+	ID          string    `bson:"_id" json:"id"`
+	Type        Type      `bson:"type" json:"type"`
+	BaseID      string    `bson:"baseId,omitempty" json:"baseId,omitempty"`
+	ParentID    string    `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	Timestamp   time.Time `bson:"timestamp" json:"timestamp"`
+	CompletedAt time.Time `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	Status      Status    `bson:"status" json:"status"`
+	R2Path      string    `bson:"r2Path" json:"r2Path"`
+	Error       string    `bson:"error,omitempty" json:"error,omitempty"`
+
+	// ResumeToken is the change stream position up to which this backup
+	// captured changes. The next incremental backup resumes from it.
+	ResumeToken bson.Raw `bson:"resumeToken,omitempty" json:"-"`
+
+	// FirstChangeAt and LastChangeAt bound the cluster times of the change
+	// events stored in an incremental backup.
+	FirstChangeAt time.Time `bson:"firstChangeAt,omitempty" json:"firstChangeAt,omitempty"`
+	LastChangeAt  time.Time `bson:"lastChangeAt,omitempty" json:"lastChangeAt,omitempty"`
+
+	Manifest Manifest `bson:"manifest" json:"manifest"`
+
+	Verification *Verification `bson:"verification,omitempty" json:"verification,omitempty"`
+}
+
+// Manifest lists every object written for a backup together with its
+// checksum so the backup can be verified without trusting R2 metadata.
+type Manifest struct {
+	Segments    []Segment `bson:"segments" json:"segments"`
+	Attachments []string  `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	TotalSize   int64     `bson:"totalSize" json:"totalSize"`
+}
+
+type Segment struct {
+	Collection string `bson:"collection" json:"collection"`
+	R2Key      string `bson:"r2Key" json:"r2Key"`
+	Documents  int64  `bson:"documents" json:"documents"`
+	Size       int64  `bson:"size" json:"size"`
+	SHA256     string `bson:"sha256" json:"sha256"`
+}
+
+// Verification records the outcome of the latest integrity check of a
+// backup: checksums, attachment copies and a test restore.
+type Verification struct {
+	VerifiedAt time.Time `bson:"verifiedAt" json:"verifiedAt"`
+	OK         bool      `bson:"ok" json:"ok"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	Documents  int64     `bson:"documents" json:"documents"`
+	Changes    int64     `bson:"changes" json:"changes"`
+}
+
+// ChangeEvent is the compact form of a change stream event stored in
+// incremental backup segments. Inserts and replacements carry the document
+// written; updates carry what they changed, as of the event.
+type ChangeEvent struct {
+	Operation    string   `bson:"op"`
+	Collection   string   `bson:"coll"`
+	DocumentKey  bson.Raw `bson:"key"`
+	FullDocument bson.Raw `bson:"doc,omitempty"`
+	// UpdatedFields maps the dotted paths an update set to their values,
+	// RemovedFields lists the paths it unset and TruncatedArrays the
+	// arrays it shortened
+	UpdatedFields   bson.Raw         `bson:"set,omitempty"`
+	RemovedFields   []string         `bson:"unset,omitempty"`
+	TruncatedArrays []TruncatedArray `bson:"truncated,omitempty"`
+	ClusterTime     time.Time        `bson:"ts"`
+	// Position is the exact cluster time of the event, which orders the
+	// events within a second. Events captured before it was recorded
+	// have none.
+	Position primitive.Timestamp `bson:"pos,omitempty"`
+}
+
+// TruncatedArray is an array an update shortened to NewSize elements
+type TruncatedArray struct {
+	Field   string `bson:"field"`
+	NewSize int32  `bson:"newSize"`
+}
+
+// ListQuery filters backups returned by a BackupRepository.
+type ListQuery struct {
+	Type   Type
+	Status Status
+	BaseID string
+	Before *time.Time
+}
@@ -0,0 +1,28 @@
+package blob
+
+import "time"
+
+// DeleteTimeout is how long a blob may stay marked for deletion. A mark
+// older than this was left by a collector that stopped half way and may be
+// taken over.
+const DeleteTimeout = time.Minute
+
+// Blob counts the references to a content-addressed attachment object.
+// Hash is the hex encoded SHA-256 of the content.
+type Blob struct {
+	Hash      string    `bson:"_id" json:"hash"`
+	Size      int64     `bson:"size" json:"size"`
+	RefCount  int64     `bson:"refCount" json:"refCount"`
+	Deleting  bool      `bson:"deleting" json:"deleting"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Stats sums up the referenced blobs. LogicalBytes is what storing every
+// reference separately would take.
+type Stats struct {
+	Blobs        int64 `bson:"blobs" json:"blobs"`
+	References   int64 `bson:"references" json:"references"`
+	StoredBytes  int64 `bson:"storedBytes" json:"storedBytes"`
+	LogicalBytes int64 `bson:"logicalBytes" json:"logicalBytes"`
+}
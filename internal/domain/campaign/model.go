@@ -0,0 +1,96 @@
+package campaign
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Campaign statuses. A draft collects recipients; once started it is sent
+// by background jobs at its rate until every recipient is done.
+const (
+	StatusDraft     = "draft"
+	StatusRunning   = "running"
+	StatusPaused    = "paused"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+// Recipient statuses. Recipients who bounced or unsubscribed are skipped
+// by later campaigns. A recipient is sending while their email is handed
+// to SendEmail, so that no other batch sends it too.
+const (
+	RecipientPending      = "pending"
+	RecipientSending      = "sending"
+	RecipientSent         = "sent"
+	RecipientFailed       = "failed"
+	RecipientBounced      = "bounced"
+	RecipientUnsubscribed = "unsubscribed"
+	RecipientSkipped      = "skipped"
+)
+
+// Campaign is one templated email sent to a list of recipients, each with
+// variables of their own
+type Campaign struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	TemplateID string             `bson:"templateId" json:"templateId"`
+	// Subject overrides the subject of the template
+	Subject   string `bson:"subject,omitempty" json:"subject,omitempty"`
+	Signature bool   `bson:"signature" json:"signature"`
+	// Variables are the defaults of every recipient's variables
+	Variables map[string]string `bson:"variables,omitempty" json:"variables,omitempty"`
+	// Rate is the number of emails sent per minute
+	Rate   int    `bson:"rate" json:"rate"`
+	Status string `bson:"status" json:"status"`
+	// Run counts the starts of the campaign. Batch jobs of an earlier run,
+	// scheduled before a pause, stop when they find a later one.
+	Run         int        `bson:"run" json:"-"`
+	CreatedBy   string     `bson:"createdBy" json:"createdBy"`
+	CreatedAt   time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time  `bson:"updatedAt" json:"updatedAt"`
+	StartedAt   *time.Time `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	CompletedAt *time.Time `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+// Recipient is an addressee of a campaign and what became of their email
+type Recipient struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CampaignID string             `bson:"campaignId" json:"campaignId"`
+	// Email is stored lowercase; a campaign lists an address once
+	Email     string            `bson:"email" json:"email"`
+	Name      string            `bson:"name,omitempty" json:"name,omitempty"`
+	Variables map[string]string `bson:"variables,omitempty" json:"variables,omitempty"`
+	Status    string            `bson:"status" json:"status"`
+	// Token identifies the recipient in unsubscribe links
+	Token string `bson:"token" json:"-"`
+	// EmailID and MessageID identify the sent email
+	EmailID   string     `bson:"emailId,omitempty" json:"emailId,omitempty"`
+	MessageID string     `bson:"messageId,omitempty" json:"messageId,omitempty"`
+	Error     string     `bson:"error,omitempty" json:"error,omitempty"`
+	Attempts  int        `bson:"attempts" json:"attempts"`
+	SentAt    *time.Time `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time  `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ListQuery filters campaigns. Campaigns are returned newest first.
+type ListQuery struct {
+	CreatedBy string
+	Status    string
+	Cursor    string
+	Limit     int
+}
+
+// RecipientQuery filters recipients. Recipients are returned in the order
+// they were added.
+type RecipientQuery struct {
+	CampaignID string
+	Email      string
+	// Statuses matches recipients in any of the statuses
+	Statuses  []string
+	Token     string
+	MessageID string
+	Cursor    string
+	Limit     int
+}
@@ -1,6 +1,7 @@
 package email
 
 import (
+	"io"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -20,14 +21,40 @@ type EmailContent struct {
 type Attachment struct {
 	Filename    string    `bson:"filename" json:"filename"`
 	R2Key       string    `bson:"r2Key" json:"r2Key"`
+	SHA256      string    `bson:"sha256,omitempty" json:"sha256,omitempty"`
 	ContentType string    `bson:"contentType" json:"contentType"`
 	Size        int64     `bson:"size" json:"size"`
 	UploadedAt  time.Time `bson:"uploadedAt" json:"uploadedAt"`
+	// Scan is the antivirus verdict, absent when scanning is disabled
+	Scan *ScanResult `bson:"scan,omitempty" json:"scan,omitempty"`
+}
+
+// Antivirus verdicts of ScanResult.Status
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	// ScanFailed attachments were accepted without a verdict because the
+	// scanner failed and scanning is configured to fail open
+	ScanFailed = "failed"
+)
+
+type ScanResult struct {
+	Status    string    `bson:"status" json:"status"`
+	Signature string    `bson:"signature,omitempty" json:"signature,omitempty"`
+	Error     string    `bson:"error,omitempty" json:"error,omitempty"`
+	ScannedAt time.Time `bson:"scannedAt" json:"scannedAt"`
+}
+
+// Quarantined reports whether the attachment was found infected. Its
+// content is kept under the quarantine prefix and cannot be downloaded.
+func (a *Attachment) Quarantined() bool {
+	return a.Scan != nil && a.Scan.Status == ScanInfected
 }
 
 type AttachmentInput struct {
-	Filename    string
-	Content     []byte
+	Filename string
+	// Content is streamed to storage and read once
+	Content     io.Reader
 	ContentType string
 }
 
@@ -36,6 +63,8 @@ type EmailFlags struct {
 	IsStarred   bool `bson:"isStarred" json:"isStarred"`
 	IsScheduled bool `bson:"isScheduled" json:"isScheduled"`
 	IsDraft     bool `bson:"isDraft" json:"isDraft"`
+	// IsQuarantined marks emails with at least one infected attachment
+	IsQuarantined bool `bson:"isQuarantined" json:"isQuarantined"`
 }
 
 type ThreadInfo struct {
@@ -48,6 +77,51 @@ type EmailMetadata struct {
 	ScheduledFor *time.Time `bson:"scheduledFor,omitempty" json:"scheduledFor,omitempty"`
 	ClientIP     string     `bson:"clientIp" json:"clientIp"`
 	UserAgent    string     `bson:"userAgent" json:"userAgent"`
+	// Spam is the verdict of the spam filter on inbound mail
+	Spam *SpamResult `bson:"spam,omitempty" json:"spam,omitempty"`
+	// SpamFeedback maps the IDs of staff who marked the email to the class
+	// they trained it as, SpamClassSpam or SpamClassHam
+	SpamFeedback map[string]string `bson:"spamFeedback,omitempty" json:"spamFeedback,omitempty"`
+	// AutoSubmitted is the RFC 3834 Auto-Submitted value of mail sent
+	// automatically, such as AutoReplied
+	AutoSubmitted string `bson:"autoSubmitted,omitempty" json:"autoSubmitted,omitempty"`
+	// Loop lists the addresses that redirected the email, sent as X-Loop
+	// headers
+	Loop []string `bson:"loop,omitempty" json:"loop,omitempty"`
+	// CampaignID is the bulk campaign the email was sent for
+	CampaignID string `bson:"campaignId,omitempty" json:"campaignId,omitempty"`
+	// ListUnsubscribe is the RFC 8058 one-click unsubscribe URL of bulk
+	// mail, sent as the List-Unsubscribe header
+	ListUnsubscribe string `bson:"listUnsubscribe,omitempty" json:"listUnsubscribe,omitempty"`
+}
+
+// RFC 3834 Auto-Submitted values of automatic mail: AutoReplied for
+// replies, and AutoGenerated for other mail, such as messages redirected by
+// a Sieve script or forwarding address
+const (
+	AutoReplied   = "auto-replied"
+	AutoGenerated = "auto-generated"
+)
+
+
+// Spam classes of SpamFeedback
+const (
+	SpamClassSpam = "spam"
+	SpamClassHam  = "ham"
+)
+
+type SpamResult struct {
+	// Score adds up the Bayesian, heuristic and DNSBL scores; mail scoring
+	// at least the configured threshold is spam
+	Score  float64 `bson:"score" json:"score"`
+	IsSpam bool    `bson:"isSpam" json:"isSpam"`
+	// Probability is the Bayesian spam probability of the content
+	Probability float64 `bson:"probability" json:"probability"`
+	// Rules names the header heuristics that matched
+	Rules []string `bson:"rules,omitempty" json:"rules,omitempty"`
+	// Listed names the DNSBL zones listing the client IP
+	Listed       []string  `bson:"listed,omitempty" json:"listed,omitempty"`
+	ClassifiedAt time.Time `bson:"classifiedAt" json:"classifiedAt"`
 }
 
 type Email struct {
@@ -58,13 +132,122 @@ type Email struct {
 	To          []Participant     `bson:"to" json:"to"`
 	CC          []Participant     `bson:"cc,omitempty" json:"cc,omitempty"`
 	BCC         []Participant     `bson:"bcc,omitempty" json:"bcc,omitempty"`
+	// ReplyTo are the addresses replies go to instead of From
+	ReplyTo []Participant `bson:"replyTo,omitempty" json:"replyTo,omitempty"`
+	// InReplyTo and References are the Message-IDs of the parent and of
+	// the ancestors of a reply, oldest first
+	InReplyTo  string   `bson:"inReplyTo,omitempty" json:"inReplyTo,omitempty"`
+	References []string `bson:"references,omitempty" json:"references,omitempty"`
 	Subject     string            `bson:"subject" json:"subject"`
 	Content     EmailContent      `bson:"content" json:"content"`
 	Attachments []Attachment      `bson:"attachments" json:"attachments"`
+	// Mailbox is the staff ID of the mailbox the email is filed in: the
+	// sender's for sent mail and drafts, the recipient's for received
+	// copies. Its labels are that mailbox's labels.
+	Mailbox     string            `bson:"mailbox,omitempty" json:"mailbox,omitempty"`
 	Labels      []string          `bson:"labels" json:"labels"`
 	Flags       EmailFlags        `bson:"flags" json:"flags"`
 	ThreadInfo  ThreadInfo        `bson:"threadInfo" json:"threadInfo"`
 	Metadata    EmailMetadata     `bson:"metadata" json:"metadata"`
 	CreatedAt   time.Time         `bson:"createdAt" json:"createdAt"`
 	UpdatedAt   time.Time         `bson:"updatedAt" json:"updatedAt"`
+	// Draft is set while the email is a draft and cleared when it is sent
+	Draft *Draft `bson:"draft,omitempty" json:"draft,omitempty"`
+	// Delivery is the delivery state of a sent email by recipient, from
+	// the transport's attempts and delivery status notifications
+	Delivery []RecipientDelivery `bson:"delivery,omitempty" json:"delivery,omitempty"`
+	// DeliveryVersion counts the changes to Delivery, which are only
+	// stored over the version they were made to
+	DeliveryVersion int64 `bson:"deliveryVersion,omitempty" json:"-"`
+	// Version counts the writes to the rest of the email. Every write
+	// advances it, and ReplaceVersion only stores an email over the
+	// version it was read at.
+	Version int64 `bson:"version,omitempty" json:"-"`
+}
+
+// Delivery states of RecipientDelivery. Sent means the receiving mail
+// server accepted the message; delivered is only known from a delivery
+// status notification.
+const (
+	DeliveryQueued    = "queued"
+	DeliverySent      = "sent"
+	DeliveryDelivered = "delivered"
+	DeliveryDeferred  = "deferred"
+	DeliveryBounced   = "bounced"
+)
+
+// RecipientDelivery is the delivery state of a sent email for one
+// recipient, with the latest attempt's details
+type RecipientDelivery struct {
+	Recipient string `bson:"recipient" json:"recipient"`
+	Status    string `bson:"status" json:"status"`
+	// Code is the RFC 3463 enhanced status code, such as 5.1.1
+	Code       string    `bson:"code,omitempty" json:"code,omitempty"`
+	Diagnostic string    `bson:"diagnostic,omitempty" json:"diagnostic,omitempty"`
+	RemoteMTA  string    `bson:"remoteMta,omitempty" json:"remoteMta,omitempty"`
+	UpdatedAt  time.Time `bson:"updatedAt" json:"updatedAt"`
+	// Attempts are the delivery attempts and reports, oldest first
+	Attempts []DeliveryAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
+}
+
+// DeliveryAttempt is one attempt to deliver to a recipient, or a delivery
+// status notification about it
+type DeliveryAttempt struct {
+	// Status is the delivery state the attempt resulted in
+	Status string `bson:"status" json:"status"`
+	// Reply is the SMTP reply code, such as 250 or 451
+	Reply int `bson:"reply,omitempty" json:"reply,omitempty"`
+	// Code is the RFC 3463 enhanced status code
+	Code string `bson:"code,omitempty" json:"code,omitempty"`
+	// Response is the remote server's reply text or the reported
+	// diagnostic
+	Response string `bson:"response,omitempty" json:"response,omitempty"`
+	RemoteMX string `bson:"remoteMx,omitempty" json:"remoteMx,omitempty"`
+	// TLS is the TLS version the connection used, as named by
+	// tls.VersionName, and empty for plaintext
+	TLS       string    `bson:"tls,omitempty" json:"tls,omitempty"`
+	StartedAt time.Time `bson:"startedAt" json:"startedAt"`
+	EndedAt   time.Time `bson:"endedAt" json:"endedAt"`
+}
+
+// Draft tracks the edits of an unsent email. Version is bumped on every
+// save, and a save based on an older version is refused.
+type Draft struct {
+	Version int64 `bson:"version" json:"version"`
+	// Revisions are the previous versions, oldest first
+	Revisions []DraftRevision `bson:"revisions,omitempty" json:"revisions,omitempty"`
+}
+
+// DraftRevision is a saved version of a draft
+type DraftRevision struct {
+	Version int64         `bson:"version" json:"version"`
+	To      []Participant `bson:"to" json:"to"`
+	CC      []Participant `bson:"cc,omitempty" json:"cc,omitempty"`
+	BCC     []Participant `bson:"bcc,omitempty" json:"bcc,omitempty"`
+	Subject string        `bson:"subject" json:"subject"`
+	Content EmailContent  `bson:"content" json:"content"`
+	// Attachments are the filenames attached at the time
+	Attachments []string  `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	SavedAt     time.Time `bson:"savedAt" json:"savedAt"`
+}
+
+// ListQuery filters emails. Emails are returned newest first; Cursor continues
+// after the last email of the previous page.
+type ListQuery struct {
+	// Participant matches the sender or any recipient address
+	Participant string
+	From        string
+	MessageID   string
+	ThreadID    string
+	// Mailbox matches the emails filed in a staff member's mailbox
+	Mailbox string
+	// Labels must all be present on the email
+	Labels    []string
+	IsRead    *bool
+	IsStarred *bool
+	IsDraft   *bool
+	Since     *time.Time
+	Until     *time.Time
+	Cursor    string
+	Limit     int
 }
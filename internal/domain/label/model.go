@@ -0,0 +1,130 @@
+package label
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// System labels every mailbox has. They are the label names emails are
+// filed under by the services and cannot be renamed or deleted.
+const (
+	Inbox   = "inbox"
+	Sent    = "sent"
+	Drafts  = "drafts"
+	Trash   = "trash"
+	Spam    = "spam"
+	Archive = "archive"
+)
+
+// System lists the system labels in display order
+var System = []string{Inbox, Sent, Drafts, Trash, Spam, Archive}
+
+// Separator nests labels: "Projects/Alpha" is nested in "Projects"
+const Separator = "/"
+
+// Label is a folder of a staff member's mailbox. Emails carry the names of
+// their labels, so renaming a label renames it on its emails too.
+type Label struct {
+	ID primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	// Owner is the staff ID of the mailbox
+	Owner string `bson:"owner" json:"owner"`
+	// Name is the path of the label, its parents' names first
+	Name string `bson:"name" json:"name"`
+	// Color is a CSS hex color such as #1a73e8, empty for the default
+	Color string `bson:"color,omitempty" json:"color,omitempty"`
+	// Total and Unread count the emails of the mailbox carrying the label.
+	// They are kept by the email repository as emails change.
+	Total     int64     `bson:"total" json:"total"`
+	Unread    int64     `bson:"unread" json:"unread"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// IsSystem reports whether name is a system label
+func IsSystem(name string) bool {
+	for _, system := range System {
+		if name == system {
+			return true
+		}
+	}
+	return false
+}
+
+// Parent returns the name of the label name is nested in, or "" for top
+// level labels
+func Parent(name string) string {
+	if i := strings.LastIndex(name, Separator); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// Within reports whether name is the label ancestor or nested in it
+func Within(name, ancestor string) bool {
+	return name == ancestor || strings.HasPrefix(name, ancestor+Separator)
+}
+
+// Renamed returns name with the ancestor label renamed from from to to;
+// names outside from are returned as is
+func Renamed(name, from, to string) string {
+	if !Within(name, from) {
+		return name
+	}
+	return to + name[len(from):]
+}
+
+// ListQuery filters labels. Labels are returned by name.
+type ListQuery struct {
+	Owner string
+}
+
+// Delta is a change to the counters of a label
+type Delta struct {
+	Owner  string
+	Name   string
+	Total  int64
+	Unread int64
+}
+
+// Deltas returns the changes to label counters when an email changes from
+// before to after. Either is nil when the email is created or deleted.
+// Emails outside any mailbox are not counted.
+func Deltas(before, after *email.Email) []Delta {
+	var deltas []Delta
+	add := func(e *email.Email, sign int64) {
+		if e == nil || e.Mailbox == "" {
+			return
+		}
+		unread := int64(0)
+		if !e.Flags.IsRead {
+			unread = sign
+		}
+		for _, name := range e.Labels {
+			found := false
+			for i := range deltas {
+				if deltas[i].Owner == e.Mailbox && deltas[i].Name == name {
+					deltas[i].Total += sign
+					deltas[i].Unread += unread
+					found = true
+					break
+				}
+			}
+			if !found {
+				deltas = append(deltas, Delta{Owner: e.Mailbox, Name: name, Total: sign, Unread: unread})
+			}
+		}
+	}
+	add(before, -1)
+	add(after, 1)
+
+	changed := deltas[:0]
+	for _, delta := range deltas {
+		if delta.Total != 0 || delta.Unread != 0 {
+			changed = append(changed, delta)
+		}
+	}
+	return changed
+}
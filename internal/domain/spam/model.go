@@ -0,0 +1,20 @@
+package spam
+
+// GlobalModel is the owner of the model trained by every user's feedback.
+// Other models are owned by the staff member who trained them.
+const GlobalModel = "global"
+
+// Counts are the number of spam and ham messages a token was seen in, or
+// for Model.Messages the number of messages trained
+type Counts struct {
+	Spam int64 `bson:"spam" json:"spam"`
+	Ham  int64 `bson:"ham" json:"ham"`
+}
+
+// Model is the part of a Bayesian model needed to classify one message:
+// the trained message counts and the counts of the message's tokens.
+// Tokens never trained are absent.
+type Model struct {
+	Messages Counts
+	Tokens   map[string]Counts
+}
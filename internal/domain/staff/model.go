@@ -5,14 +5,29 @@ import (
     "go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+    StatusActive     = "active"
+    StatusDisabled   = "disabled"
+    StatusOffboarded = "offboarded"
+)
+
+const (
+    RoleAdmin  = "admin"
+    RoleMember = "member"
+)
+
 type Staff struct {
     ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
     Email         string            `bson:"email" json:"email"`
     FullName      string            `bson:"fullName" json:"fullName"`
     Role          string            `bson:"role" json:"role"`
     Department    string            `bson:"department" json:"department"`
+    Title         string            `bson:"title,omitempty" json:"title,omitempty"`
+    ManagerID     string            `bson:"managerId,omitempty" json:"managerId,omitempty"`
     ProfilePhoto  ProfilePhoto      `bson:"profilePhoto" json:"profilePhoto"`
     Status        string            `bson:"status" json:"status"`
+    Mailbox       Mailbox           `bson:"mailbox" json:"mailbox"`
+    Offboarding   *Offboarding      `bson:"offboarding,omitempty" json:"offboarding,omitempty"`
     LastActive    time.Time         `bson:"lastActive" json:"lastActive"`
     CreatedAt     time.Time         `bson:"createdAt" json:"createdAt"`
     UpdatedAt     time.Time         `bson:"updatedAt" json:"updatedAt"`
@@ -23,3 +38,104 @@ type ProfilePhoto struct {
     R2Key       string    `bson:"r2Key" json:"r2Key"`
     LastUpdated time.Time `bson:"lastUpdated" json:"lastUpdated"`
 }
+
+// Mailbox holds delivery settings of a staff member's mailbox
+type Mailbox struct {
+    // ForwardTo receives a copy of every inbound message when set
+    ForwardTo string `bson:"forwardTo,omitempty" json:"forwardTo,omitempty"`
+    // Delegates are staff IDs allowed to read and send from this mailbox
+    Delegates []string `bson:"delegates,omitempty" json:"delegates,omitempty"`
+    // Sieve filters inbound mail before it is stored
+    Sieve *SieveScript `bson:"sieve,omitempty" json:"sieve,omitempty"`
+    // OutOfOffice answers inbound mail automatically while the member is away
+    OutOfOffice *OutOfOffice `bson:"outOfOffice,omitempty" json:"outOfOffice,omitempty"`
+}
+
+// OutOfOffice is an automatic reply sent between Start and End, with a
+// message for colleagues and one for everyone else. An empty
+// ExternalMessage sends no reply to outside senders.
+type OutOfOffice struct {
+    Start           time.Time `bson:"start" json:"start"`
+    End             time.Time `bson:"end" json:"end"`
+    Subject         string    `bson:"subject,omitempty" json:"subject,omitempty"`
+    InternalMessage string    `bson:"internalMessage" json:"internalMessage"`
+    ExternalMessage string    `bson:"externalMessage,omitempty" json:"externalMessage,omitempty"`
+    // Days is how long a sender who got a reply gets no further one
+    Days      int       `bson:"days" json:"days"`
+    UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Active reports whether replies are to be sent at t
+func (o *OutOfOffice) Active(t time.Time) bool {
+    return !t.Before(o.Start) && t.Before(o.End)
+}
+
+// SieveScript is an RFC 5228 script, validated when it was stored
+type SieveScript struct {
+    Script    string    `bson:"script" json:"script"`
+    UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Offboarding records who took over the mailbox of an offboarded member
+type Offboarding struct {
+    ManagerID    string    `bson:"managerId" json:"managerId"`
+    ForwardTo    string    `bson:"forwardTo" json:"forwardTo"`
+    OffboardedBy string    `bson:"offboardedBy" json:"offboardedBy"`
+    OffboardedAt time.Time `bson:"offboardedAt" json:"offboardedAt"`
+}
+
+// CanLogin reports whether the member may authenticate
+func (s *Staff) CanLogin() bool {
+    return s.Status == StatusActive
+}
+
+// HasMailboxAccess reports whether staffID may access this member's mailbox
+func (s *Staff) HasMailboxAccess(staffID string) bool {
+    if s.ID.Hex() == staffID {
+        return true
+    }
+    for _, delegate := range s.Mailbox.Delegates {
+        if delegate == staffID {
+            return true
+        }
+    }
+    return false
+}
+
+// ListQuery filters the staff directory. Search matches name and email.
+type ListQuery struct {
+    Search     string
+    Department string
+    Role       string
+    Status     string
+    ManagerID  string
+    Cursor     string
+    Limit      int
+}
+
+type CreateStaffParams struct {
+    Email      string
+    FullName   string
+    Role       string
+    Department string
+    Title      string
+    ManagerID  string
+}
+
+// UpdateStaffParams holds the fields to change; nil fields are left as is
+type UpdateStaffParams struct {
+    FullName   *string
+    Role       *string
+    Department *string
+    Title      *string
+    ManagerID  *string
+    Status     *string
+}
+
+type OffboardParams struct {
+    // ManagerID takes over the mailbox; defaults to the member's manager
+    ManagerID string
+    // ForwardTo receives new inbound mail; defaults to the manager's address
+    ForwardTo    string
+    OffboardedBy string
+}
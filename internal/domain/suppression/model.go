@@ -0,0 +1,35 @@
+package suppression
+
+import "time"
+
+// Reasons an address is suppressed
+const (
+	// ReasonBounce addresses hard bounced: they do not exist or their
+	// mailbox is disabled
+	ReasonBounce = "bounce"
+	// ReasonManual addresses were added by an admin
+	ReasonManual = "manual"
+)
+
+// Suppression is an address no email is sent to
+type Suppression struct {
+	// Email is the lowercase address
+	Email  string `bson:"_id" json:"email"`
+	Reason string `bson:"reason" json:"reason"`
+	// Diagnostic is the remote server's explanation of a bounce, or the
+	// note of an admin
+	Diagnostic string `bson:"diagnostic,omitempty" json:"diagnostic,omitempty"`
+	// EmailID is the sent email that bounced
+	EmailID string `bson:"emailId,omitempty" json:"emailId,omitempty"`
+	// CreatedBy is the admin who added the address
+	CreatedBy string    `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// ListQuery filters suppressions. Suppressions are listed alphabetically by
+// address.
+type ListQuery struct {
+	Reason string
+	Cursor string
+	Limit  int
+}
@@ -0,0 +1,60 @@
+package template
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of templates
+const (
+	// KindMessage templates are the subject and content of an email
+	KindMessage = "message"
+	// KindSignature templates are appended to the content of emails. A
+	// staff member's own signature takes precedence over their
+	// department's, which takes precedence over the global one.
+	KindSignature = "signature"
+)
+
+// Template is reusable email content with Go template placeholders, such
+// as {{.Recipient.Name}} or {{.customer}} for a variable. A template
+// belongs to a staff member when OwnerID is set, to a department when
+// Department is set and to everyone otherwise.
+type Template struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind        string             `bson:"kind" json:"kind"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	OwnerID     string             `bson:"ownerId,omitempty" json:"ownerId,omitempty"`
+	Department  string             `bson:"department,omitempty" json:"department,omitempty"`
+	Subject     string             `bson:"subject,omitempty" json:"subject,omitempty"`
+	// Text and HTML are the content parts. A missing text part is
+	// generated from the rendered HTML.
+	Text      string    `bson:"text,omitempty" json:"text,omitempty"`
+	HTML      string    `bson:"html,omitempty" json:"html,omitempty"`
+	CreatedBy string    `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Global reports whether the template is shared with everyone
+func (t *Template) Global() bool {
+	return t.OwnerID == "" && t.Department == ""
+}
+
+// ListQuery filters templates. Templates are returned by name; Cursor
+// continues after the last template of the previous page.
+type ListQuery struct {
+	Kind string
+	// VisibleTo restricts the results to the templates of one staff
+	// member, of their department and global ones
+	VisibleTo *Scope
+	Cursor    string
+	Limit     int
+}
+
+// Scope is the staff member and department a template can belong to
+type Scope struct {
+	OwnerID    string
+	Department string
+}
@@ -29,3 +29,11 @@ type LastMessage struct {
     Snippet   string    `bson:"snippet" json:"snippet"`
     SentAt    time.Time `bson:"sentAt" json:"sentAt"`
 }
+
+// ListQuery filters threads. Threads are returned by most recent message
+// first; Cursor continues after the last thread of the previous page.
+type ListQuery struct {
+    Participant string
+    Cursor      string
+    Limit       int
+}
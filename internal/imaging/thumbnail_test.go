@@ -0,0 +1,118 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		w, h, size   int
+		wantW, wantH int
+	}{
+		{1024, 512, 256, 256, 128},
+		{512, 1024, 256, 128, 256},
+		{300, 300, 256, 256, 256},
+		{100, 50, 256, 100, 50},
+		{256, 256, 256, 256, 256},
+		// Very thin images keep a pixel
+		{10000, 2, 256, 256, 1},
+		{2, 10000, 256, 1, 256},
+	}
+	for _, tt := range tests {
+		if w, h := fit(tt.w, tt.h, tt.size); w != tt.wantW || h != tt.wantH {
+			t.Errorf("fit(%d, %d, %d) = %d, %d, want %d, %d", tt.w, tt.h, tt.size, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestDownscaleAverages(t *testing.T) {
+	// Alternating black and white columns average to grey
+	src := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	dst := downscale(src, 2, 2)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			if c := dst.RGBAAt(x, y); c.R != 127 || c.A != 255 {
+				t.Fatalf("pixel %d,%d = %v, want grey", x, y, c)
+			}
+		}
+	}
+}
+
+func TestThumbnailFormats(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 600, 400), color.Palette{color.White, color.Black})
+	var gifData, pngData, jpegData bytes.Buffer
+	if err := gif.Encode(&gifData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"gif": gifData.Bytes(), "png": pngData.Bytes(), "jpeg": jpegData.Bytes()} {
+		thumbnail, err := Thumbnail(data, 150)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(thumbnail))
+		if err != nil || format != "jpeg" || cfg.Width != 150 || cfg.Height != 100 {
+			t.Fatalf("%s: thumbnail is a %dx%d %s (%v), want a 150x100 jpeg", name, cfg.Width, cfg.Height, format, err)
+		}
+	}
+}
+
+// pngHeader returns a PNG that declares width x height pixels but ends
+// after its header
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 2 // truecolor
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestThumbnailRejects(t *testing.T) {
+	if _, err := Thumbnail([]byte("%PDF-1.7"), 256); !errors.Is(err, ErrUnsupportedImage) {
+		t.Fatalf("err = %v, want ErrUnsupportedImage", err)
+	}
+
+	// The size is checked before the pixels are decoded
+	_, err := Thumbnail(pngHeader(20000, 20000), 256)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("err = %v, want the image refused as too large", err)
+	}
+
+	// A header without its pixels is no image
+	if _, err := Thumbnail(pngHeader(100, 100), 256); !errors.Is(err, ErrUnsupportedImage) {
+		t.Fatalf("err = %v, want ErrUnsupportedImage", err)
+	}
+}
@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Job is a unit of background work. A worker claims a due job for a lease;
+// a running job whose lease expired is claimed again by the next worker.
+type Job struct {
+	ID          string          `bson:"_id" json:"id"`
+	Type        string          `bson:"type" json:"type"`
+	Payload     json.RawMessage `bson:"payload" json:"payload"`
+	Status      Status          `bson:"status" json:"status"`
+	Attempts    int             `bson:"attempts" json:"attempts"`
+	MaxAttempts int             `bson:"maxAttempts" json:"maxAttempts"`
+	RunAt       time.Time       `bson:"runAt" json:"runAt"`
+	LockedBy    string          `bson:"lockedBy,omitempty" json:"lockedBy,omitempty"`
+	LockedUntil *time.Time      `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+	LastError   string          `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt   time.Time       `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time       `bson:"updatedAt" json:"updatedAt"`
+	CompletedAt *time.Time      `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+// ListQuery filters jobs. Jobs are returned newest first.
+type ListQuery struct {
+	Type   string
+	Status Status
+	Cursor string
+	Limit  int
+}
@@ -1,12 +1,17 @@
 package jobs
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	TaskSendScheduledEmail     = "send_scheduled_email"
 	TaskProcessAttachments     = "process_attachments"
 	TaskUpdateSearchIndex      = "update_search_index"
 	TaskGenerateEmailAnalytics = "generate_email_analytics"
+	TaskSendCampaign           = "send_campaign"
+	TaskDeliverEmail           = "deliver_email"
 )
 
 type ScheduledEmailPayload struct {
@@ -29,3 +34,23 @@ type EmailAnalyticsPayload struct {
 	StartDate time.Time `json:"startDate"`
 	EndDate   time.Time `json:"endDate"`
 }
+
+// CampaignPayload sends the next batch of a campaign's recipients. Run and
+// Batch name the batch, see CampaignJobID.
+type CampaignPayload struct {
+	CampaignID string `json:"campaignId"`
+	Run        int    `json:"run,omitempty"`
+	Batch      int    `json:"batch,omitempty"`
+}
+
+// CampaignJobID is the ID of the job of one batch of a campaign run, so
+// that a batch is enqueued once however often it is scheduled
+func CampaignJobID(p CampaignPayload) string {
+	return fmt.Sprintf("campaign-%s-%d-%d", p.CampaignID, p.Run, p.Batch)
+}
+
+// DeliveryPayload delivers the queued and deferred recipients of a sent
+// email
+type DeliveryPayload struct {
+	EmailID string `json:"emailId"`
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Staff created before offboarding support have no status; they are active.
+func init() {
+	register(Migration{
+		Version:     1,
+		Description: "backfill staff status",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("staff").UpdateMany(ctx,
+				bson.M{"status": bson.M{"$in": bson.A{nil, ""}}},
+				bson.M{"$set": bson.M{"status": staff.StatusActive}},
+			)
+			return err
+		},
+	})
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Every staff document gets a mailbox subdocument so forwarding and
+// delegation can be updated with $set and $addToSet on nested fields.
+func init() {
+	register(Migration{
+		Version:     2,
+		Description: "add mailbox settings to staff",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("staff").UpdateMany(ctx,
+				bson.M{"mailbox": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"mailbox": bson.M{}}},
+			)
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("staff").UpdateMany(ctx,
+				bson.M{"mailbox": bson.M{}},
+				bson.M{"$unset": bson.M{"mailbox": ""}},
+			)
+			return err
+		},
+	})
+}
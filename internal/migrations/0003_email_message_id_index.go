@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Message IDs were unique across mailboxes before every recipient got a
+// copy of their own. The unique index gives way to the per-mailbox one
+// EnsureIndexes creates; it cannot come back once copies share an ID.
+func init() {
+	register(Migration{
+		Version:     3,
+		Description: "drop the unique message ID index on emails",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			indexes := db.Collection("emails").Indexes()
+			specs, err := indexes.ListSpecifications(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, spec := range specs {
+				if spec.Name != "messageId_1" || spec.Unique == nil || !*spec.Unique {
+					continue
+				}
+				if _, err := indexes.DropOne(ctx, spec.Name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,90 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const lockID = "migrations"
+
+// ErrLocked is returned when another process holds the migration lock
+var ErrLocked = errors.New("migrations are locked by another process")
+
+// lock is a lease stored in MongoDB. The holder refreshes it while
+// migrating; a crashed holder's lease expires after ttl.
+type lock struct {
+	collection *mongo.Collection
+	owner      string
+	ttl        time.Duration
+}
+
+// tryAcquire takes the lease if it is free, expired or already ours
+func (l *lock) tryAcquire(ctx context.Context) error {
+	now := time.Now()
+	filter := bson.M{
+		"_id": lockID,
+		"$or": bson.A{
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+			bson.M{"owner": l.owner},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": l.owner, "acquiredAt": now, "expiresAt": now.Add(l.ttl)}}
+
+	_, err := l.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The upsert collided with a live lease held by someone else
+		return ErrLocked
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	return nil
+}
+
+// acquire waits up to wait for the lease
+func (l *lock) acquire(ctx context.Context, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		err := l.tryAcquire(ctx)
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// keepAlive refreshes the lease until ctx is cancelled
+func (l *lock) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.collection.UpdateOne(ctx,
+				bson.M{"_id": lockID, "owner": l.owner},
+				bson.M{"$set": bson.M{"expiresAt": time.Now().Add(l.ttl)}},
+			)
+		}
+	}
+}
+
+func (l *lock) release(ctx context.Context) error {
+	if _, err := l.collection.DeleteOne(ctx, bson.M{"_id": lockID, "owner": l.owner}); err != nil {
+		return fmt.Errorf("failed to release migration lock: %w", err)
+	}
+	return nil
+}
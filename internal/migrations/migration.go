@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrIrreversible is returned when rolling back a migration without a Down step
+var ErrIrreversible = errors.New("migration cannot be rolled back")
+
+// Migration is one versioned schema change. Steps should be idempotent:
+// MongoDB cannot apply a step and record it atomically, so a step may run
+// again if the process dies between the two.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	// Down reverts Up; nil marks the migration as irreversible
+	Down func(ctx context.Context, db *mongo.Database) error
+}
+
+// Record is the document stored in the migrations collection for every
+// applied migration
+type Record struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"appliedAt" json:"appliedAt"`
+	AppliedBy   string    `bson:"appliedBy" json:"appliedBy"`
+	DurationMs  int64     `bson:"durationMs" json:"durationMs"`
+}
+
+var registry = map[int]Migration{}
+
+// register adds a migration to the set every Runner uses. It is called from
+// init functions, so a duplicate version is a programming error.
+func register(m Migration) {
+	if m.Version <= 0 {
+		panic(fmt.Sprintf("migrations: invalid version %d", m.Version))
+	}
+	if m.Up == nil {
+		panic(fmt.Sprintf("migrations: version %d has no up step", m.Version))
+	}
+	if _, ok := registry[m.Version]; ok {
+		panic(fmt.Sprintf("migrations: duplicate version %d", m.Version))
+	}
+	registry[m.Version] = m
+}
+
+// All returns the registered migrations in version order
+func All() []Migration {
+	all := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
@@ -0,0 +1,91 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// testDB returns a fresh database on the MongoDB deployment of
+// MONGODB_TEST_URI, dropped when the test ends
+func testDB(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx := context.Background()
+	db, err := mongodb.Connect(ctx, config.MongoDBConfig{URI: uri, Database: "migrationstest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = db.Client().Database(fmt.Sprintf("migrationstest_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		db.Client().Disconnect(ctx)
+	})
+	return db
+}
+
+func TestDropUniqueMessageIDIndex(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	emails := db.Collection("emails")
+
+	// The index databases created before per-recipient copies have
+	_, err := emails.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "messageId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := mongodb.NewRepository(db, zap.NewNop(), metrics.NewMetrics("migrationstest"))
+	if err := repos.EnsureIndexes(ctx); err == nil {
+		t.Fatal("EnsureIndexes succeeded over the unique message ID index")
+	}
+
+	runner := NewRunner(db, zap.NewNop())
+	if _, err := runner.Up(ctx, RunOptions{Target: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copies in two mailboxes share the message ID, one mailbox holds it once
+	for _, mailbox := range []string{"a", "b"} {
+		if _, err := emails.InsertOne(ctx, bson.M{"mailbox": mailbox, "messageId": "<m1@example.org>"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := emails.InsertOne(ctx, bson.M{"mailbox": "a", "messageId": "<m1@example.org>"}); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("second copy in one mailbox: err = %v, want a duplicate key error", err)
+	}
+
+	// The step is idempotent, and irreversible
+	for _, m := range All() {
+		if m.Version != 3 {
+			continue
+		}
+		if err := m.Up(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+		if m.Down != nil {
+			t.Fatal("migration 3 has a down step")
+		}
+	}
+}
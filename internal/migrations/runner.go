@@ -0,0 +1,289 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLockTTL  = time.Minute
+	defaultLockWait = 2 * time.Minute
+)
+
+// Status describes one migration known to this binary or recorded in the
+// database. Unknown is set for records without a matching migration, which
+// happens after rolling back to an older release.
+type Status struct {
+	Version     int       `json:"version"`
+	Description string    `json:"description"`
+	Applied     bool      `json:"applied"`
+	AppliedAt   time.Time `json:"appliedAt,omitempty"`
+	Reversible  bool      `json:"reversible"`
+	Unknown     bool      `json:"unknown,omitempty"`
+}
+
+// RunOptions selects which migrations Up and Down run
+type RunOptions struct {
+	// Target is the last version to apply for Up, or the version to roll
+	// back to for Down. Zero means all pending migrations for Up.
+	Target int
+	// Steps limits Down to the most recent applied migrations when Target
+	// is zero. It defaults to one.
+	Steps int
+	// DryRun returns the plan without taking the lock or changing anything
+	DryRun bool
+}
+
+type Runner struct {
+	db         *mongo.Database
+	records    *mongo.Collection
+	migrations []Migration
+	lock       *lock
+	lockWait   time.Duration
+	logger     *zap.Logger
+}
+
+// NewRunner creates a runner for the registered migrations
+func NewRunner(db *mongo.Database, logger *zap.Logger) *Runner {
+	return newRunner(db, All(), logger)
+}
+
+// newRunner creates a runner for migrations, which are in version order.
+// Every runner owns the lock under a name of its own, so two runners in
+// one process exclude each other like runners on different hosts.
+func newRunner(db *mongo.Database, migrations []Migration, logger *zap.Logger) *Runner {
+	host, _ := os.Hostname()
+	return &Runner{
+		db:         db,
+		records:    db.Collection("migrations"),
+		migrations: migrations,
+		lock: &lock{
+			collection: db.Collection("migrations_lock"),
+			owner:      fmt.Sprintf("%s:%d:%s", host, os.Getpid(), primitive.NewObjectID().Hex()),
+			ttl:        defaultLockTTL,
+		},
+		lockWait: defaultLockWait,
+		logger:   logger,
+	}
+}
+
+// Status lists every migration with whether it has been applied
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	known := make(map[int]bool, len(r.migrations))
+	for _, m := range r.migrations {
+		known[m.Version] = true
+		status := Status{Version: m.Version, Description: m.Description, Reversible: m.Down != nil}
+		if record, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	for version, record := range applied {
+		if !known[version] {
+			statuses = append(statuses, Status{
+				Version:     version,
+				Description: record.Description,
+				Applied:     true,
+				AppliedAt:   record.AppliedAt,
+				Unknown:     true,
+			})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies pending migrations in version order and returns the ones it
+// applied, or would apply for a dry run.
+func (r *Runner) Up(ctx context.Context, opts RunOptions) ([]Migration, error) {
+	if opts.DryRun {
+		return r.pending(ctx, opts.Target)
+	}
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Read the plan under the lock, another replica may have just migrated
+	plan, err := r.pending(ctx, opts.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range plan {
+		r.logger.Info("applying migration", zap.Int("version", m.Version), zap.String("description", m.Description))
+
+		start := time.Now()
+		if err := m.Up(ctx, r.db); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+
+		record := Record{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   time.Now(),
+			AppliedBy:   r.lock.owner,
+			DurationMs:  time.Since(start).Milliseconds(),
+		}
+		upsert := options.Replace().SetUpsert(true)
+		if _, err := r.records.ReplaceOne(ctx, bson.M{"_id": m.Version}, record, upsert); err != nil {
+			return done, fmt.Errorf("migration %d applied but could not be recorded: %w", m.Version, err)
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// Down rolls back applied migrations newest first and returns the ones it
+// rolled back, or would roll back for a dry run. Nothing is rolled back if
+// any migration in the plan is irreversible or unknown.
+func (r *Runner) Down(ctx context.Context, opts RunOptions) ([]Migration, error) {
+	if opts.DryRun {
+		return r.rollbackPlan(ctx, opts)
+	}
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	plan, err := r.rollbackPlan(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range plan {
+		r.logger.Info("rolling back migration", zap.Int("version", m.Version), zap.String("description", m.Description))
+
+		if err := m.Down(ctx, r.db); err != nil {
+			return done, fmt.Errorf("rollback of migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		if _, err := r.records.DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return done, fmt.Errorf("migration %d rolled back but its record could not be removed: %w", m.Version, err)
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+func (r *Runner) pending(ctx context.Context, target int) ([]Migration, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []Migration
+	for _, m := range r.migrations {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			plan = append(plan, m)
+		}
+	}
+	return plan, nil
+}
+
+func (r *Runner) rollbackPlan(ctx context.Context, opts RunOptions) ([]Migration, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		if opts.Target == 0 || version > opts.Target {
+			versions = append(versions, version)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	if opts.Target == 0 {
+		steps := opts.Steps
+		if steps <= 0 {
+			steps = 1
+		}
+		if steps < len(versions) {
+			versions = versions[:steps]
+		}
+	}
+
+	byVersion := make(map[int]Migration, len(r.migrations))
+	for _, m := range r.migrations {
+		byVersion[m.Version] = m
+	}
+
+	plan := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d is applied but unknown to this release", version)
+		}
+		if m.Down == nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, ErrIrreversible)
+		}
+		plan = append(plan, m)
+	}
+	return plan, nil
+}
+
+func (r *Runner) applied(ctx context.Context) (map[int]Record, error) {
+	cursor, err := r.records.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode applied migrations: %w", err)
+	}
+
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// acquire takes the distributed lock and keeps it alive until the returned
+// function is called
+func (r *Runner) acquire(ctx context.Context) (func(), error) {
+	if err := r.lock.acquire(ctx, r.lockWait); err != nil {
+		return nil, err
+	}
+
+	keepAliveCtx, cancel := context.WithCancel(ctx)
+	go r.lock.keepAlive(keepAliveCtx)
+
+	return func() {
+		cancel()
+		if err := r.lock.release(context.Background()); err != nil {
+			r.logger.Error("failed to release migration lock", zap.Error(err))
+		}
+	}, nil
+}
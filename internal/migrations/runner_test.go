@@ -0,0 +1,268 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// journal records the steps migrations run, in order
+type journal struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+func (j *journal) add(step string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.steps = append(j.steps, step)
+}
+
+func (j *journal) get() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string(nil), j.steps...)
+}
+
+// testMigration journals its steps; irreversible ones have no Down
+func testMigration(j *journal, version int, name string, reversible bool) Migration {
+	m := Migration{
+		Version:     version,
+		Description: name,
+		Up: func(context.Context, *mongo.Database) error {
+			j.add("up " + name)
+			return nil
+		},
+	}
+	if reversible {
+		m.Down = func(context.Context, *mongo.Database) error {
+			j.add("down " + name)
+			return nil
+		}
+	}
+	return m
+}
+
+func versions(ms []Migration) []int {
+	var v []int
+	for _, m := range ms {
+		v = append(v, m.Version)
+	}
+	return v
+}
+
+func TestRunnerOrder(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	j := &journal{}
+	runner := newRunner(db, []Migration{
+		testMigration(j, 1, "one", true),
+		testMigration(j, 2, "two", false),
+		testMigration(j, 3, "three", true),
+		testMigration(j, 4, "four", true),
+	}, zap.NewNop())
+
+	planned, err := runner.Up(ctx, RunOptions{DryRun: true})
+	if err != nil || !reflect.DeepEqual(versions(planned), []int{1, 2, 3, 4}) || len(j.get()) != 0 {
+		t.Fatalf("dry run planned %v (err %v) and ran %q", versions(planned), err, j.get())
+	}
+
+	if done, err := runner.Up(ctx, RunOptions{Target: 2}); err != nil || !reflect.DeepEqual(versions(done), []int{1, 2}) {
+		t.Fatalf("Up to 2 applied %v, err %v", versions(done), err)
+	}
+	if done, err := runner.Up(ctx, RunOptions{}); err != nil || !reflect.DeepEqual(versions(done), []int{3, 4}) {
+		t.Fatalf("Up applied %v, err %v", versions(done), err)
+	}
+	if done, err := runner.Up(ctx, RunOptions{}); err != nil || len(done) != 0 {
+		t.Fatalf("second Up applied %v, err %v", versions(done), err)
+	}
+
+	// Down rolls back newest first, one step by default
+	if done, err := runner.Down(ctx, RunOptions{}); err != nil || !reflect.DeepEqual(versions(done), []int{4}) {
+		t.Fatalf("Down rolled back %v, err %v", versions(done), err)
+	}
+	if done, err := runner.Down(ctx, RunOptions{Target: 2}); err != nil || !reflect.DeepEqual(versions(done), []int{3}) {
+		t.Fatalf("Down to 2 rolled back %v, err %v", versions(done), err)
+	}
+	// Rolling back past an irreversible migration rolls back nothing
+	if done, err := runner.Down(ctx, RunOptions{Steps: 2}); !errors.Is(err, ErrIrreversible) || len(done) != 0 {
+		t.Fatalf("Down over an irreversible migration rolled back %v, err %v", versions(done), err)
+	}
+
+	want := []string{"up one", "up two", "up three", "up four", "down four", "down three"}
+	if got := j.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("steps = %q, want %q", got, want)
+	}
+
+	// Records left by a newer release show up as unknown
+	if _, err := db.Collection("migrations").InsertOne(ctx, Record{Version: 9, Description: "from the future", AppliedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := []Status{
+		{Version: 1, Description: "one", Applied: true, Reversible: true},
+		{Version: 2, Description: "two", Applied: true},
+		{Version: 3, Description: "three", Reversible: true},
+		{Version: 4, Description: "four", Reversible: true},
+		{Version: 9, Description: "from the future", Applied: true, Unknown: true},
+	}
+	for i := range statuses {
+		statuses[i].AppliedAt = time.Time{}
+	}
+	if !reflect.DeepEqual(statuses, wantStatus) {
+		t.Fatalf("status = %+v, want %+v", statuses, wantStatus)
+	}
+	if _, err := runner.Down(ctx, RunOptions{}); err == nil {
+		t.Fatal("rolled back a migration unknown to this release")
+	}
+}
+
+func TestRunnerStopsAtFailure(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	j := &journal{}
+	failing := testMigration(j, 2, "two", true)
+	failing.Up = func(context.Context, *mongo.Database) error { return errors.New("boom") }
+	runner := newRunner(db, []Migration{
+		testMigration(j, 1, "one", true),
+		failing,
+		testMigration(j, 3, "three", true),
+	}, zap.NewNop())
+
+	done, err := runner.Up(ctx, RunOptions{})
+	if err == nil || !reflect.DeepEqual(versions(done), []int{1}) {
+		t.Fatalf("Up applied %v, err %v; want 1 applied and the failure", versions(done), err)
+	}
+	if got := j.get(); !reflect.DeepEqual(got, []string{"up one"}) {
+		t.Fatalf("steps = %q, want only the first", got)
+	}
+	// The failed migration is still pending
+	if planned, err := runner.Up(ctx, RunOptions{DryRun: true}); err != nil || !reflect.DeepEqual(versions(planned), []int{2, 3}) {
+		t.Fatalf("pending %v, err %v", versions(planned), err)
+	}
+	// The lock was released
+	if n, err := db.Collection("migrations_lock").CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+		t.Fatalf("%d locks left (err %v)", n, err)
+	}
+}
+
+func TestConcurrentRunners(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+
+	var (
+		running int32
+		overlap atomic.Bool
+		mu      sync.Mutex
+		applied = map[int]int{}
+		order   []int
+	)
+	step := func(version int) func(context.Context, *mongo.Database) error {
+		return func(context.Context, *mongo.Database) error {
+			if atomic.AddInt32(&running, 1) > 1 {
+				overlap.Store(true)
+			}
+			defer atomic.AddInt32(&running, -1)
+			// Give other runners time to collide
+			time.Sleep(50 * time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			applied[version]++
+			order = append(order, version)
+			return nil
+		}
+	}
+	ms := []Migration{
+		{Version: 1, Description: "one", Up: step(1)},
+		{Version: 2, Description: "two", Up: step(2)},
+		{Version: 3, Description: "three", Up: step(3)},
+	}
+
+	const runners = 4
+	var wg sync.WaitGroup
+	errs := make([]error, runners)
+	done := make([][]Migration, runners)
+	for i := 0; i < runners; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// As replicas starting together do, every runner has its own owner
+			done[i], errs[i] = newRunner(db, ms, zap.NewNop()).Up(ctx, RunOptions{})
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := 0; i < runners; i++ {
+		if errs[i] != nil {
+			t.Fatalf("runner %d: %v", i, errs[i])
+		}
+		total += len(done[i])
+	}
+	if overlap.Load() {
+		t.Fatal("migrations ran concurrently")
+	}
+	if total != len(ms) || !reflect.DeepEqual(applied, map[int]int{1: 1, 2: 1, 3: 1}) {
+		t.Fatalf("runners applied %d migrations, counts %v; want each once", total, applied)
+	}
+	if !reflect.DeepEqual(order, []int{1, 2, 3}) {
+		t.Fatalf("applied in order %v", order)
+	}
+}
+
+func TestLockExcludesOtherOwners(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	collection := db.Collection("migrations_lock")
+	first := &lock{collection: collection, owner: "first", ttl: time.Minute}
+	second := &lock{collection: collection, owner: "second", ttl: time.Minute}
+
+	if err := first.tryAcquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Acquiring again extends our own lease
+	if err := first.tryAcquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.tryAcquire(ctx); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second owner: err = %v, want ErrLocked", err)
+	}
+	if err := second.acquire(ctx, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second owner without waiting: err = %v, want ErrLocked", err)
+	}
+
+	// Releasing someone else's lease leaves it in place
+	if err := second.release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.tryAcquire(ctx); !errors.Is(err, ErrLocked) {
+		t.Fatalf("after a foreign release: err = %v, want ErrLocked", err)
+	}
+
+	if err := first.release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.tryAcquire(ctx); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+
+	// A crashed holder's lease expires
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": lockID}, bson.M{"$set": bson.M{"expiresAt": time.Now().Add(-time.Second)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.tryAcquire(ctx); err != nil {
+		t.Fatalf("after expiry: %v", err)
+	}
+}
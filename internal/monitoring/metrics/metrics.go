@@ -18,6 +18,12 @@ type Metrics struct {
 	CacheLatency       *prometheus.HistogramVec
 	NotificationsSent  *prometheus.CounterVec
 	WebSocketConns     prometheus.Gauge
+	BackupRuns          *prometheus.CounterVec
+	BackupDuration      *prometheus.HistogramVec
+	BackupSize          *prometheus.GaugeVec
+	BackupLastSuccess   *prometheus.GaugeVec
+	BackupVerifications *prometheus.CounterVec
+	AttachmentScans     *prometheus.CounterVec
 }
 
 func NewMetrics(namespace string) *Metrics {
@@ -94,5 +100,54 @@ func NewMetrics(namespace string) *Metrics {
 				Help:     "Number of WebSocket connections",
 			},
 		),
+		BackupRuns: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:     "backup_runs_total",
+				Help:     "Total number of backup job runs",
+			},
+			[]string{"job", "status"},
+		),
+		BackupDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:     "backup_duration_seconds",
+				Help:     "Backup job duration in seconds",
+				Buckets:  prometheus.ExponentialBuckets(1, 2, 14),
+			},
+			[]string{"job"},
+		),
+		BackupSize: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:     "backup_size_bytes",
+				Help:     "Compressed size of the latest backup",
+			},
+			[]string{"type"},
+		),
+		BackupLastSuccess: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:     "backup_last_success_timestamp_seconds",
+				Help:     "Unix time of the last successful backup job run",
+			},
+			[]string{"job"},
+		),
+		BackupVerifications: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:     "backup_verifications_total",
+				Help:     "Total number of backup verifications",
+			},
+			[]string{"status"},
+		),
+		AttachmentScans: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "attachment_scans_total",
+				Help:      "Total number of attachment antivirus scans by verdict",
+			},
+			[]string{"status"},
+		),
 	}
 }
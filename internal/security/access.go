@@ -0,0 +1,207 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+)
+
+// accessKeysTTL is how long the signing keys of Cloudflare Access are
+// reused before they are fetched again. An unknown key ID fetches them
+// early, as Access rotates its keys.
+const accessKeysTTL = time.Hour
+
+var ErrAccessNotConfigured = errors.New("cloudflare access is not configured")
+
+// AccessIdentity is the user a Cloudflare Access token was issued to
+type AccessIdentity struct {
+	Email   string
+	Subject string
+}
+
+// AccessVerifier checks the application tokens Cloudflare Access adds to
+// the requests it lets through, against the signing keys of the team
+// domain and the audience tag of the application
+type AccessVerifier struct {
+	issuer   string
+	audience string
+	client   *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func NewAccessVerifier(cfg config.CloudflareSecurityConfig) *AccessVerifier {
+	issuer := strings.TrimSuffix(cfg.TeamDomain, "/")
+	if issuer != "" && !strings.Contains(issuer, "://") {
+		issuer = "https://" + issuer
+	}
+
+	return &AccessVerifier{
+		issuer:   issuer,
+		audience: cfg.AccessAUD,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type accessClaims struct {
+	Audience  audience `json:"aud"`
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Email     string   `json:"email"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// audience is the aud claim, a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// Verify checks the signature, issuer, audience and lifetime of token and
+// returns who it was issued to
+func (v *AccessVerifier) Verify(ctx context.Context, token string) (*AccessIdentity, error) {
+	if v.issuer == "" || v.audience == "" {
+		return nil, ErrAccessNotConfigured
+	}
+
+	header, signingInput, signature, err := splitToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unexpected algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims accessClaims
+	if err := decodeClaims(signingInput, &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	switch {
+	case claims.Issuer != v.issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	case !claims.Audience.contains(v.audience):
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	case claims.ExpiresAt <= now:
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.NotBefore > now:
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case claims.Email == "":
+		return nil, fmt.Errorf("%w: no email", ErrInvalidToken)
+	}
+
+	return &AccessIdentity{Email: claims.Email, Subject: claims.Subject}, nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, candidate := range a {
+		if candidate == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the signing key kid, fetching the keys when they are stale
+// or kid is unknown
+func (v *AccessVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok && time.Since(v.fetched) < accessKeysTTL {
+		return key, nil
+	}
+	// Fetch at most once a minute, so that forged key IDs cannot make us
+	// hammer Cloudflare
+	if time.Since(v.fetched) >= time.Minute {
+		keys, err := v.fetchKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+		v.fetched = time.Now()
+	}
+
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key", ErrInvalidToken)
+	}
+	return key, nil
+}
+
+func (v *AccessVerifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.issuer+"/cdn-cgi/access/certs", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch access keys: %w", err)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch access keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch access keys: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode access keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode access key %s: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode access key %s: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
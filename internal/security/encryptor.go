@@ -0,0 +1,63 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrDecrypt = errors.New("failed to decrypt")
+
+// Encryptor seals data with AES-256-GCM under the SHA-256 of a secret.
+// Sealed data is the standard base64 of the 12 byte nonce followed by the
+// ciphertext and tag, as the Web Crypto API of Cloudflare Workers seals
+// it, so that Workers sharing the secret can exchange data with the
+// server.
+type Encryptor struct {
+	aead cipher.AEAD
+}
+
+func NewEncryptor(secret []byte) (*Encryptor, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("encryption key is empty")
+	}
+
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &Encryptor{aead: aead}, nil
+}
+
+func (e *Encryptor) Encrypt(data []byte) (string, error) {
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(data)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(e.aead.Seal(nonce, nonce, data, nil)), nil
+}
+
+// Decrypt opens data sealed by Encrypt. Data that was not sealed under the
+// same secret, or was altered, fails with ErrDecrypt.
+func (e *Encryptor) Decrypt(data string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < e.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	nonce, ciphertext := sealed[:e.aead.NonceSize()], sealed[e.aead.NonceSize():]
+	plaintext, err := e.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -2,6 +2,7 @@ package security
 
 import (
 	"context"
+	"errors"
 
 	"github.com/bezata/blockchainml-email/internal/config"
 	"go.uber.org/zap"
@@ -11,8 +12,7 @@ type Service struct {
     config     *config.SecurityConfig
     logger     *zap.Logger
     encryptor  *Encryptor
-    audit      *AuditLogger
-    rateLimit  *RateLimiter
+    access     *AccessVerifier
 }
 
 func NewService(cfg *config.SecurityConfig, logger *zap.Logger) *Service {
@@ -25,14 +25,18 @@ func NewService(cfg *config.SecurityConfig, logger *zap.Logger) *Service {
         config:    cfg,
         logger:    logger,
         encryptor: encryptor,
-        audit:     NewAuditLogger(logger),
-        rateLimit: NewRateLimiter(cfg.RateLimit),
+        access:    NewAccessVerifier(cfg.Cloudflare),
     }
 }
 
-// ValidateCloudflareToken validates the Cloudflare Workers token
+// ValidateCloudflareToken validates a Cloudflare Access application token
 func (s *Service) ValidateCloudflareToken(ctx context.Context, token string) (bool, error) {
-    // Implement Cloudflare Workers token validation
+    if _, err := s.access.Verify(ctx, token); err != nil {
+        if errors.Is(err, ErrInvalidToken) {
+            return false, nil
+        }
+        return false, err
+    }
     return true, nil
 }
 
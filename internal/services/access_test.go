@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+)
+
+func TestGetAttachmentURLChecksMailboxOwner(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	assistant := env.addStaff(t, "assistant@example.com")
+	alice := env.addStaff(t, "alice@example.com", assistant)
+	bob := env.addStaff(t, "bob@example.com")
+
+	// Alice's copy of a message to both of them
+	e := env.addEmail(t, alice, "partner@example.org", alice.Email, bob.Email)
+	e.Attachments = []email.Attachment{{
+		Filename:    "report.pdf",
+		R2Key:       "attachments/report.pdf",
+		ContentType: "application/pdf",
+		Size:        1024,
+		UploadedAt:  time.Now(),
+	}}
+	if err := env.repos.Email.Update(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, member := range []string{alice.ID.Hex(), assistant.ID.Hex()} {
+		if _, err := env.Email.GetAttachmentURL(ctx, member, e.ID.Hex(), "report.pdf"); err != nil {
+			t.Errorf("mailbox owner or delegate denied: %v", err)
+		}
+	}
+
+	// Bob is a recipient, but this is not his copy
+	_, err := env.Email.GetAttachmentURL(ctx, bob.ID.Hex(), e.ID.Hex(), "report.pdf")
+	if !errors.Is(err, ErrEmailAccessDenied) {
+		t.Errorf("another recipient got %v, want ErrEmailAccessDenied", err)
+	}
+}
+
+func TestMarkSpamChecksMailboxOwner(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	bob := env.addStaff(t, "bob@example.com")
+	e := env.addEmail(t, alice, "partner@example.org", alice.Email, bob.Email)
+
+	// Bob is a recipient, but he cannot relabel Alice's copy
+	_, err := env.Email.MarkSpam(ctx, bob.ID.Hex(), e.ID.Hex(), true)
+	if !errors.Is(err, ErrEmailAccessDenied) {
+		t.Fatalf("another recipient got %v, want ErrEmailAccessDenied", err)
+	}
+
+	marked, err := env.Email.MarkSpam(ctx, alice.ID.Hex(), e.ID.Hex(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasLabel(marked.Labels, LabelSpam) || hasLabel(marked.Labels, LabelInbox) {
+		t.Errorf("labels = %v, want spam instead of inbox", marked.Labels)
+	}
+}
+
+func TestReplyChecksMailboxOwner(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	bob := env.addStaff(t, "bob@example.com")
+	e := env.addEmail(t, alice, "partner@example.org", alice.Email, bob.Email)
+
+	// Bob is a recipient, but he cannot respond from Alice's copy
+	params := ReplyParams{From: bob.ID.Hex(), Content: email.EmailContent{Text: "Thanks"}, To: []string{"x@example.org"}}
+	if _, err := env.Email.Reply(ctx, e.ID.Hex(), true, params); !errors.Is(err, ErrEmailAccessDenied) {
+		t.Errorf("reply from another recipient got %v, want ErrEmailAccessDenied", err)
+	}
+	if _, err := env.Email.Forward(ctx, e.ID.Hex(), params); !errors.Is(err, ErrEmailAccessDenied) {
+		t.Errorf("forward from another recipient got %v, want ErrEmailAccessDenied", err)
+	}
+
+	params.From = alice.ID.Hex()
+	reply, err := env.Email.Reply(ctx, e.ID.Hex(), false, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Mailbox != alice.ID.Hex() {
+		t.Errorf("reply filed in mailbox %s, want the replier's", reply.Mailbox)
+	}
+}
@@ -0,0 +1,697 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/bezata/blockchainml-email/internal/antivirus"
+	"github.com/bezata/blockchainml-email/internal/domain/blob"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/storage/r2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// UploadChunkSize is the size of every chunk of a resumable upload but
+	// the last. Each chunk is stored as one part of an R2 multipart upload.
+	UploadChunkSize = r2.PartSize
+
+	maxFilenameLength = 255
+
+	presignUploadTTL   = 15 * time.Minute
+	presignDownloadTTL = 5 * time.Minute
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrInvalidUpload    = errors.New("invalid upload")
+	ErrUploadIncomplete = errors.New("upload is missing chunks")
+	ErrUploadTooLarge   = errors.New("attachments exceed the maximum size")
+	ErrScanFailed       = errors.New("attachment could not be scanned for viruses")
+	ErrQuarantined      = errors.New("attachment is quarantined")
+)
+
+// AttachmentServiceConfig configures the attachment service. MaxSize is the
+// largest single attachment and the largest total per email, normally
+// ServerConfig.MaxRequestSize.
+type AttachmentServiceConfig struct {
+	R2    *r2.Client
+	Blobs storage.BlobRepository
+	// Scanner checks every stored attachment for viruses; nil disables
+	// scanning
+	Scanner *antivirus.Client
+	// ScanFailOpen accepts attachments the scanner failed on
+	ScanFailOpen bool
+	MaxSize      int64
+	Logger       *zap.Logger
+	Metrics      *metrics.Metrics
+}
+
+// AttachmentService streams attachments to R2. Files uploaded ahead of
+// sending are staged under uploads/{ownerID}/ and moved into attachment
+// storage with Claim when the email is sent. Infected attachments are moved
+// to the quarantine prefix.
+type AttachmentService struct {
+	r2           *r2.Client
+	storage      *r2.Storage
+	scanner      *antivirus.Client
+	scanFailOpen bool
+	maxSize      atomic.Int64
+	logger       *zap.Logger
+	metrics      *metrics.Metrics
+}
+
+func NewAttachmentService(cfg AttachmentServiceConfig) *AttachmentService {
+	s := &AttachmentService{
+		r2:           cfg.R2,
+		storage:      r2.NewStorage(cfg.R2, cfg.Blobs, cfg.Logger),
+		scanner:      cfg.Scanner,
+		scanFailOpen: cfg.ScanFailOpen,
+		logger:       cfg.Logger,
+		metrics:      cfg.Metrics,
+	}
+	s.maxSize.Store(cfg.MaxSize)
+	return s
+}
+
+// StartUploadParams describes a file to upload in chunks
+type StartUploadParams struct {
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// UploadSession is the state of a resumable upload. The ID carries
+// everything needed to resume it, so no session is stored server side; R2
+// keeps the chunks received so far until the upload is completed or aborted.
+type UploadSession struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	ChunkSize   int64  `json:"chunkSize"`
+	Chunks      int    `json:"chunks"`
+	Received    []int  `json:"received"`
+}
+
+// PresignedUpload lets a browser PUT a file straight to R2 before it is
+// confirmed. The request must carry Headers unchanged and exactly the
+// declared number of bytes.
+type PresignedUpload struct {
+	Key       string            `json:"key"`
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// PresignedURL is a short lived link to download an attachment
+type PresignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// uploadToken is encoded into UploadSession.ID
+type uploadToken struct {
+	Key         string `json:"k"`
+	UploadID    string `json:"u"`
+	Size        int64  `json:"s"`
+	ContentType string `json:"t"`
+}
+
+// StartUpload begins a resumable upload owned by ownerID
+func (s *AttachmentService) StartUpload(ctx context.Context, ownerID string, params StartUploadParams) (*UploadSession, error) {
+	if params.Size <= 0 {
+		return nil, fmt.Errorf("%w: size must be positive", ErrInvalidUpload)
+	}
+	if params.Size > s.MaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+	if params.Size > UploadChunkSize*r2.MaxParts {
+		return nil, fmt.Errorf("%w: file has too many chunks", ErrInvalidUpload)
+	}
+
+	contentType := contentTypeOrDefault(params.ContentType)
+	key := stagingKey(ownerID, params.Filename)
+	uploadID, err := s.r2.CreateMultipartUpload(ctx, key, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.session(&uploadToken{
+		Key:         key,
+		UploadID:    uploadID,
+		Size:        params.Size,
+		ContentType: contentType,
+	}, nil)
+}
+
+// GetUpload returns an upload with the chunks received so far, for clients
+// resuming after a dropped connection
+func (s *AttachmentService) GetUpload(ctx context.Context, ownerID, id string) (*UploadSession, error) {
+	token, err := decodeUploadToken(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := s.r2.ListParts(ctx, token.Key, token.UploadID)
+	if err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	return s.session(token, parts)
+}
+
+// UploadChunk stores chunk index (zero based) of an upload. Every chunk but
+// the last must be exactly UploadChunkSize bytes. Sending a chunk again
+// replaces it.
+func (s *AttachmentService) UploadChunk(ctx context.Context, ownerID, id string, index int, body io.Reader) error {
+	token, err := decodeUploadToken(ownerID, id)
+	if err != nil {
+		return err
+	}
+
+	chunks := chunkCount(token.Size)
+	if index < 0 || index >= chunks {
+		return fmt.Errorf("%w: chunk %d out of range", ErrInvalidUpload, index)
+	}
+	want := chunkSize(token.Size, index)
+
+	data, err := io.ReadAll(io.LimitReader(body, want+1))
+	if err != nil {
+		return fmt.Errorf("failed to read chunk: %w", err)
+	}
+	if int64(len(data)) != want {
+		return fmt.Errorf("%w: chunk %d must be %d bytes", ErrInvalidUpload, index, want)
+	}
+
+	if _, err := s.r2.UploadPart(ctx, token.Key, token.UploadID, int32(index+1), data); err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return ErrUploadNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// CompleteUpload assembles the chunks into the staged attachment. It fails
+// with ErrUploadIncomplete until every chunk has been received.
+func (s *AttachmentService) CompleteUpload(ctx context.Context, ownerID, id string) (*email.Attachment, error) {
+	token, err := decodeUploadToken(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := s.r2.ListParts(ctx, token.Key, token.UploadID)
+	if err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	if len(parts) != chunkCount(token.Size) {
+		return nil, ErrUploadIncomplete
+	}
+	for i, part := range parts {
+		if int(part.Number) != i+1 || part.Size != chunkSize(token.Size, i) {
+			return nil, ErrUploadIncomplete
+		}
+	}
+
+	if err := s.r2.CompleteMultipartUpload(ctx, token.Key, token.UploadID, parts); err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	return &email.Attachment{
+		Filename:    path.Base(token.Key),
+		R2Key:       token.Key,
+		ContentType: token.ContentType,
+		Size:        token.Size,
+		UploadedAt:  time.Now(),
+	}, nil
+}
+
+// AbortUpload discards an upload and the chunks received so far
+func (s *AttachmentService) AbortUpload(ctx context.Context, ownerID, id string) error {
+	token, err := decodeUploadToken(ownerID, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.r2.AbortMultipartUpload(ctx, token.Key, token.UploadID); err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return ErrUploadNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// PresignUpload issues a URL for uploading one file directly to the staging
+// area of ownerID. R2 does not accept browser form POSTs, so this is a
+// signed PUT; the signature covers the size, which keeps uploads within
+// MaxSize without the bytes passing through the API.
+func (s *AttachmentService) PresignUpload(ctx context.Context, ownerID string, params StartUploadParams) (*PresignedUpload, error) {
+	if params.Size <= 0 {
+		return nil, fmt.Errorf("%w: size must be positive", ErrInvalidUpload)
+	}
+	if params.Size > s.MaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+
+	contentType := contentTypeOrDefault(params.ContentType)
+	key := stagingKey(ownerID, params.Filename)
+	expiresAt := time.Now().Add(presignUploadTTL)
+	url, err := s.r2.PresignPut(ctx, key, contentType, params.Size, presignUploadTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedUpload{
+		Key:       key,
+		URL:       url,
+		Method:    http.MethodPut,
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ConfirmUpload checks that a presigned upload of ownerID arrived and
+// returns it as a staged attachment
+func (s *AttachmentService) ConfirmUpload(ctx context.Context, ownerID, key string) (*email.Attachment, error) {
+	if !ownsKey(ownerID, key) {
+		return nil, ErrUploadNotFound
+	}
+
+	info, err := s.r2.Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	if info.Size > s.MaxSize() {
+		s.Discard(ctx, ownerID, []string{key})
+		return nil, ErrUploadTooLarge
+	}
+
+	return &email.Attachment{
+		Filename:    path.Base(key),
+		R2Key:       key,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		UploadedAt:  time.Now(),
+	}, nil
+}
+
+// DownloadURL issues a short lived URL that downloads attachment under its
+// original name. Callers check access to the email first. Quarantined
+// attachments cannot be downloaded.
+func (s *AttachmentService) DownloadURL(ctx context.Context, attachment *email.Attachment) (*PresignedURL, error) {
+	if attachment.Quarantined() {
+		return nil, ErrQuarantined
+	}
+
+	expiresAt := time.Now().Add(presignDownloadTTL)
+	url, err := s.r2.PresignDownload(ctx, attachment.R2Key, attachment.Filename, attachment.ContentType, presignDownloadTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedURL{URL: url, ExpiresAt: expiresAt}, nil
+}
+
+// Open streams the content of an attachment, such as to send it. The
+// caller closes the returned body. Quarantined attachments cannot be
+// opened.
+func (s *AttachmentService) Open(ctx context.Context, attachment *email.Attachment) (io.ReadCloser, error) {
+	if attachment.Quarantined() {
+		return nil, ErrQuarantined
+	}
+	return s.r2.Open(ctx, attachment.R2Key)
+}
+
+// Stage streams a single file to the staging area without holding it in
+// memory. Files larger than the maximum size are rejected and removed.
+func (s *AttachmentService) Stage(ctx context.Context, ownerID, filename, contentType string, body io.Reader) (*email.Attachment, error) {
+	key := stagingKey(ownerID, filename)
+	contentType = contentTypeOrDefault(contentType)
+	maxSize := s.MaxSize()
+	content := &limitedReader{r: body, remaining: maxSize}
+
+	if err := s.r2.Upload(ctx, key, content, contentType); err != nil {
+		if errors.Is(err, ErrUploadTooLarge) {
+			return nil, ErrUploadTooLarge
+		}
+		return nil, err
+	}
+
+	return &email.Attachment{
+		Filename:    path.Base(key),
+		R2Key:       key,
+		ContentType: contentType,
+		Size:        maxSize - content.remaining,
+		UploadedAt:  time.Now(),
+	}, nil
+}
+
+// Store streams an attachment into attachment storage and scans it
+func (s *AttachmentService) Store(ctx context.Context, input email.AttachmentInput) (*email.Attachment, error) {
+	input.Filename = sanitizeFilename(input.Filename)
+	input.ContentType = contentTypeOrDefault(input.ContentType)
+	input.Content = &limitedReader{r: input.Content, remaining: s.MaxSize()}
+
+	attachment, err := s.storage.StoreAttachment(ctx, input)
+	if err != nil {
+		if errors.Is(err, ErrUploadTooLarge) {
+			return nil, ErrUploadTooLarge
+		}
+		return nil, err
+	}
+
+	scan, err := s.scan(ctx, attachment.R2Key)
+	if err != nil {
+		if deleteErr := s.storage.DeleteAttachments(context.WithoutCancel(ctx), []email.Attachment{*attachment}); deleteErr != nil {
+			s.logger.Error("failed to delete unscanned attachment", zap.String("key", attachment.R2Key), zap.Error(deleteErr))
+		}
+		return nil, err
+	}
+	if err := s.applyScan(ctx, attachment, scan); err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// Claim moves a staged upload owned by ownerID into attachment storage, so
+// it is kept, backed up and deleted with the email it is attached to. The
+// upload is scanned first and stays staged when scanning fails.
+func (s *AttachmentService) Claim(ctx context.Context, ownerID, key string) (*email.Attachment, error) {
+	if !ownsKey(ownerID, key) {
+		return nil, ErrUploadNotFound
+	}
+
+	scan, err := s.scan(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := s.storage.StoreObject(ctx, key, path.Base(key))
+	if err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	if err := s.applyScan(ctx, attachment, scan); err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// scan streams the object at key to the scanner. It returns nil without a
+// scanner, and a failed result instead of ErrScanFailed when failing open.
+func (s *AttachmentService) scan(ctx context.Context, key string) (*email.ScanResult, error) {
+	if s.scanner == nil {
+		return nil, nil
+	}
+
+	result, err := s.scanObject(ctx, key)
+	if err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		s.metrics.AttachmentScans.WithLabelValues(email.ScanFailed).Inc()
+		s.logger.Error("failed to scan attachment", zap.String("key", key), zap.Error(err))
+		if !s.scanFailOpen {
+			return nil, ErrScanFailed
+		}
+		return &email.ScanResult{Status: email.ScanFailed, Error: err.Error(), ScannedAt: time.Now()}, nil
+	}
+
+	scan := &email.ScanResult{Status: email.ScanClean, ScannedAt: time.Now()}
+	if result.Infected {
+		scan.Status = email.ScanInfected
+		scan.Signature = result.Signature
+	}
+	s.metrics.AttachmentScans.WithLabelValues(scan.Status).Inc()
+	return scan, nil
+}
+
+func (s *AttachmentService) scanObject(ctx context.Context, key string) (*antivirus.Result, error) {
+	body, err := s.r2.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return s.scanner.Scan(ctx, body)
+}
+
+// applyScan records the verdict on a stored attachment and quarantines it
+// when infected
+func (s *AttachmentService) applyScan(ctx context.Context, attachment *email.Attachment, scan *email.ScanResult) error {
+	attachment.Scan = scan
+	if !attachment.Quarantined() {
+		return nil
+	}
+
+	s.logger.Warn("quarantining infected attachment",
+		zap.String("filename", attachment.Filename),
+		zap.String("sha256", attachment.SHA256),
+		zap.String("signature", scan.Signature),
+	)
+	if err := s.storage.Quarantine(ctx, attachment); err != nil {
+		if deleteErr := s.storage.DeleteAttachments(context.WithoutCancel(ctx), []email.Attachment{*attachment}); deleteErr != nil {
+			s.logger.Error("failed to delete infected attachment", zap.String("key", attachment.R2Key), zap.Error(deleteErr))
+		}
+		return fmt.Errorf("failed to quarantine attachment: %w", err)
+	}
+	return nil
+}
+
+// Discard deletes staged uploads, used when the email they were staged for
+// could not be sent
+func (s *AttachmentService) Discard(ctx context.Context, ownerID string, keys []string) {
+	for _, key := range keys {
+		if !ownsKey(ownerID, key) {
+			continue
+		}
+		if err := s.r2.Delete(ctx, key); err != nil {
+			s.logger.Warn("failed to delete staged upload", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// Size returns the size of a staged upload owned by ownerID
+func (s *AttachmentService) Size(ctx context.Context, ownerID, key string) (int64, error) {
+	if !ownsKey(ownerID, key) {
+		return 0, ErrUploadNotFound
+	}
+
+	info, err := s.r2.Stat(ctx, key)
+	if err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return 0, ErrUploadNotFound
+		}
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+// Copy attaches an attachment of one email to another, sharing its content
+func (s *AttachmentService) Copy(ctx context.Context, attachment email.Attachment) (*email.Attachment, error) {
+	copied, err := s.storage.CopyAttachment(ctx, attachment)
+	if err != nil {
+		if errors.Is(err, r2.ErrNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return copied, nil
+}
+
+// DeleteAttachments removes the attachments of an email. Content still
+// attached to other emails is kept.
+func (s *AttachmentService) DeleteAttachments(ctx context.Context, attachments []email.Attachment) error {
+	return s.storage.DeleteAttachments(ctx, attachments)
+}
+
+// DedupReport shows how much storage content-addressed attachments save
+type DedupReport struct {
+	blob.Stats
+	SavedBytes int64 `json:"savedBytes"`
+	// SavedRatio is the fraction of LogicalBytes that is not stored
+	SavedRatio float64 `json:"savedRatio"`
+}
+
+func (s *AttachmentService) DedupReport(ctx context.Context) (*DedupReport, error) {
+	stats, err := s.storage.DedupStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob stats: %w", err)
+	}
+
+	report := &DedupReport{
+		Stats:      *stats,
+		SavedBytes: stats.LogicalBytes - stats.StoredBytes,
+	}
+	if stats.LogicalBytes > 0 {
+		report.SavedRatio = float64(report.SavedBytes) / float64(stats.LogicalBytes)
+	}
+	return report, nil
+}
+
+// MaxSize returns the largest accepted attachment
+func (s *AttachmentService) MaxSize() int64 {
+	return s.maxSize.Load()
+}
+
+// UpdateMaxSize replaces the largest accepted attachment. It is safe to
+// call while serving requests, which is how configuration reloads apply a
+// new maximum request size.
+func (s *AttachmentService) UpdateMaxSize(size int64) {
+	s.maxSize.Store(size)
+}
+
+func (s *AttachmentService) session(token *uploadToken, parts []r2.Part) (*UploadSession, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode upload: %w", err)
+	}
+
+	received := make([]int, 0, len(parts))
+	for _, part := range parts {
+		received = append(received, int(part.Number)-1)
+	}
+
+	return &UploadSession{
+		ID:          base64.RawURLEncoding.EncodeToString(data),
+		Filename:    path.Base(token.Key),
+		ContentType: token.ContentType,
+		Size:        token.Size,
+		ChunkSize:   UploadChunkSize,
+		Chunks:      chunkCount(token.Size),
+		Received:    received,
+	}, nil
+}
+
+// decodeUploadToken parses an upload ID and checks it belongs to ownerID.
+// Uploads of other users are reported as not found.
+func decodeUploadToken(ownerID, id string) (*uploadToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	var token uploadToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, ErrUploadNotFound
+	}
+	if token.UploadID == "" || token.Size <= 0 || !ownsKey(ownerID, token.Key) {
+		return nil, ErrUploadNotFound
+	}
+
+	return &token, nil
+}
+
+func stagingKey(ownerID, filename string) string {
+	return fmt.Sprintf("uploads/%s/%s/%s", ownerID, uuid.New().String(), sanitizeFilename(filename))
+}
+
+// ownsKey reports whether key is a staged upload of ownerID
+func ownsKey(ownerID, key string) bool {
+	rest, ok := strings.CutPrefix(key, "uploads/"+ownerID+"/")
+	if !ok || ownerID == "" || strings.Contains(ownerID, "/") {
+		return false
+	}
+	dir, name, ok := strings.Cut(rest, "/")
+	return ok && dir != "" && name != "" && !strings.Contains(name, "/")
+}
+
+func chunkCount(size int64) int {
+	return int((size + UploadChunkSize - 1) / UploadChunkSize)
+}
+
+func chunkSize(size int64, index int) int64 {
+	if index == chunkCount(size)-1 {
+		return size - int64(index)*UploadChunkSize
+	}
+	return UploadChunkSize
+}
+
+// sanitizeFilename reduces a client supplied name to a single safe path
+// segment
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "attachment"
+	}
+	if len(name) > maxFilenameLength {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:maxFilenameLength-len(ext)], "") + ext
+	}
+	return name
+}
+
+func contentTypeOrDefault(contentType string) string {
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// limitedReader fails with ErrUploadTooLarge instead of stopping quietly
+// when more than remaining bytes are read
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrUploadTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrUploadTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/antivirus/clamdtest"
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/storage/r2"
+)
+
+// withScanner points the services at a fake R2 bucket and scans with the
+// clamd at address
+func withScanner(bucket *fakeR2, address string, failOpen bool) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.R2.Endpoint = bucket.URL
+		cfg.Antivirus.Enabled = true
+		cfg.Antivirus.Network = "tcp"
+		cfg.Antivirus.Address = address
+		cfg.Antivirus.FailOpen = failOpen
+	}
+}
+
+// closedAddress returns a local address nothing listens on
+func closedAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func storeAttachment(env *testEnv, content string) (*email.Attachment, error) {
+	return env.Attachments.Store(context.Background(), email.AttachmentInput{
+		Filename:    "report.txt",
+		ContentType: "text/plain",
+		Content:     strings.NewReader(content),
+	})
+}
+
+func TestStoreScansAttachment(t *testing.T) {
+	bucket := newFakeR2(t)
+	clamd := clamdtest.NewServer(t)
+	env := newTestEnv(t, withScanner(bucket, clamd.Addr(), false))
+
+	attachment, err := storeAttachment(env, "quarterly figures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.Scan == nil || attachment.Scan.Status != email.ScanClean {
+		t.Fatalf("scan = %+v, want clean", attachment.Scan)
+	}
+	if attachment.R2Key != r2.BlobKey(attachment.SHA256) {
+		t.Fatalf("key = %q, want the blob key", attachment.R2Key)
+	}
+	if keys := bucket.keys(); len(keys) != 1 || keys[0] != attachment.R2Key {
+		t.Fatalf("objects = %v, want only %s", keys, attachment.R2Key)
+	}
+	if scanned := clamd.Scanned(); len(scanned) != 1 || string(scanned[0]) != "quarterly figures" {
+		t.Fatalf("scanned = %q", scanned)
+	}
+}
+
+func TestStoreQuarantinesInfectedAttachment(t *testing.T) {
+	bucket := newFakeR2(t)
+	clamd := clamdtest.NewServer(t)
+	env := newTestEnv(t, withScanner(bucket, clamd.Addr(), false))
+
+	attachment, err := storeAttachment(env, "payload "+clamdtest.Marker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attachment.Quarantined() || attachment.Scan.Signature != clamdtest.Signature {
+		t.Fatalf("scan = %+v, want infected with %s", attachment.Scan, clamdtest.Signature)
+	}
+	if !strings.HasPrefix(attachment.R2Key, r2.QuarantinePrefix) {
+		t.Fatalf("key = %q, want it under %s", attachment.R2Key, r2.QuarantinePrefix)
+	}
+	// The shared blob is released, only the quarantined copy is left
+	if keys := bucket.keys(); len(keys) != 1 || keys[0] != attachment.R2Key {
+		t.Fatalf("objects = %v, want only %s", keys, attachment.R2Key)
+	}
+	stats, err := env.repos.Blobs.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blobs != 0 {
+		t.Fatalf("blobs = %d, want 0", stats.Blobs)
+	}
+}
+
+func TestStoreScanFailure(t *testing.T) {
+	t.Run("FailClosed", func(t *testing.T) {
+		bucket := newFakeR2(t)
+		env := newTestEnv(t, withScanner(bucket, closedAddress(t), false))
+
+		if _, err := storeAttachment(env, "quarterly figures"); !errors.Is(err, ErrScanFailed) {
+			t.Fatalf("err = %v, want ErrScanFailed", err)
+		}
+		if keys := bucket.keys(); len(keys) != 0 {
+			t.Fatalf("objects = %v, want the unscanned attachment deleted", keys)
+		}
+	})
+
+	t.Run("FailOpen", func(t *testing.T) {
+		bucket := newFakeR2(t)
+		env := newTestEnv(t, withScanner(bucket, closedAddress(t), true))
+
+		attachment, err := storeAttachment(env, "quarterly figures")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attachment.Scan == nil || attachment.Scan.Status != email.ScanFailed || attachment.Scan.Error == "" {
+			t.Fatalf("scan = %+v, want failed with the error", attachment.Scan)
+		}
+		if keys := bucket.keys(); len(keys) != 1 || keys[0] != attachment.R2Key {
+			t.Fatalf("objects = %v, want only %s", keys, attachment.R2Key)
+		}
+	})
+}
+
+func TestSharedBlobOutlivesEmail(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeR2(t)
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.R2.Endpoint = bucket.URL })
+	alice := env.addStaff(t, "alice@example.com")
+	const content = "quarterly figures"
+
+	// draft attaches the same content as an upload of its own
+	draft := func() *email.Email {
+		t.Helper()
+		staged, err := env.Attachments.Stage(ctx, alice.ID.Hex(), "report.txt", "text/plain", strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := env.Email.CreateDraft(ctx, alice.ID.Hex(), DraftParams{To: []string{"bob@example.org"}}, []string{staged.R2Key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	first, second := draft(), draft()
+
+	key := r2.BlobKey(first.Attachments[0].SHA256)
+	if first.Attachments[0].R2Key != key || second.Attachments[0].R2Key != key {
+		t.Fatalf("keys = %q and %q, want both %s", first.Attachments[0].R2Key, second.Attachments[0].R2Key, key)
+	}
+	if keys := bucket.keys(); len(keys) != 1 || keys[0] != key {
+		t.Fatalf("objects = %v, want only %s", keys, key)
+	}
+	report, err := env.Attachments.DedupReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blobs != 1 || report.References != 2 || report.SavedBytes != int64(len(content)) {
+		t.Fatalf("report = %+v, want one blob referenced twice", report)
+	}
+
+	// The other email still reads the content
+	if err := env.Email.DeleteDraft(ctx, alice.ID.Hex(), first.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	r, err := env.Attachments.Open(ctx, &second.Attachments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != content {
+		t.Fatalf("read %q, %v after the first email was deleted", data, err)
+	}
+	if report, err = env.Attachments.DedupReport(ctx); err != nil || report.Blobs != 1 || report.References != 1 {
+		t.Fatalf("report = %+v, %v, want one reference left", report, err)
+	}
+
+	// The last reference takes the blob with it
+	if err := env.Email.DeleteDraft(ctx, alice.ID.Hex(), second.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if keys := bucket.keys(); len(keys) != 0 {
+		t.Fatalf("objects = %v, want none", keys)
+	}
+	if report, err = env.Attachments.DedupReport(ctx); err != nil || report.Blobs != 0 || report.References != 0 {
+		t.Fatalf("report = %+v, %v, want no blobs", report, err)
+	}
+
+	// The same content is stored again once collected
+	third := draft()
+	if keys := bucket.keys(); len(keys) != 1 || keys[0] != key || third.Attachments[0].R2Key != key {
+		t.Fatalf("objects = %v, want %s stored again", keys, key)
+	}
+}
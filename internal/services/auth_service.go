@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/security"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.uber.org/zap"
+)
+
+// refreshTokenTTL is how long a session lasts without logging in again
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// memberCacheTTL is how long Authenticate trusts what it last read of a
+// member, so offboarding or a role change applies to sessions within it
+const memberCacheTTL = 30 * time.Second
+
+// maxCachedMembers bounds the members Authenticate keeps in memory
+const maxCachedMembers = 4096
+
+const (
+	tokenAccess  = "access"
+	tokenRefresh = "refresh"
+)
+
+var (
+	ErrUnauthenticated = errors.New("invalid or expired credentials")
+	ErrLoginDisabled   = errors.New("login is disabled for this staff member")
+	ErrLoginNotEnabled = errors.New("login is not configured")
+)
+
+// AccessVerifier checks the Cloudflare Access token of a login request
+type AccessVerifier interface {
+	Verify(ctx context.Context, token string) (*security.AccessIdentity, error)
+}
+
+type AuthServiceConfig struct {
+	Repo   storage.StaffRepository
+	Config config.JWTConfig
+	// Access verifies the identity staff log in with, the Cloudflare
+	// Access application fronting the API
+	Access  AccessVerifier
+	Logger  *zap.Logger
+	Metrics *metrics.Metrics
+}
+
+// AuthService logs staff in with their Cloudflare Access identity and
+// issues the API tokens of their session, HS256 JWTs signed with the
+// configured secret
+type AuthService struct {
+	repo      storage.StaffRepository
+	secret    []byte
+	accessTTL time.Duration
+	access    AccessVerifier
+	members   *memberCache
+	logger    *zap.Logger
+	metrics   *metrics.Metrics
+}
+
+func NewAuthService(cfg AuthServiceConfig) *AuthService {
+	return &AuthService{
+		repo:      cfg.Repo,
+		secret:    []byte(cfg.Config.Secret),
+		accessTTL: time.Duration(cfg.Config.ExpiresIn) * time.Minute,
+		access:    cfg.Access,
+		members:   newMemberCache(memberCacheTTL),
+		logger:    cfg.Logger,
+		metrics:   cfg.Metrics,
+	}
+}
+
+// Tokens are the API tokens of a session. The access token authenticates
+// requests; the refresh token gets new tokens once it expires.
+type Tokens struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken"`
+	TokenType    string    `json:"tokenType"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// Principal is the staff member a request is authenticated as
+type Principal struct {
+	UserID string
+	Role   string
+}
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role,omitempty"`
+	Type      string `json:"typ"`
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Login starts a session for the staff member a Cloudflare Access token
+// was issued to
+func (s *AuthService) Login(ctx context.Context, accessToken string) (*Tokens, error) {
+	if s.access == nil {
+		return nil, ErrLoginNotEnabled
+	}
+
+	identity, err := s.access.Verify(ctx, accessToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, security.ErrAccessNotConfigured):
+			return nil, ErrLoginNotEnabled
+		case errors.Is(err, security.ErrInvalidToken):
+			s.logger.Warn("rejected login", zap.Error(err))
+			return nil, ErrUnauthenticated
+		}
+		return nil, fmt.Errorf("failed to verify access token: %w", err)
+	}
+
+	member, err := s.repo.GetByEmail(ctx, strings.ToLower(identity.Email))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.logger.Warn("login of unknown staff member", zap.String("email", identity.Email))
+			return nil, ErrUnauthenticated
+		}
+		return nil, fmt.Errorf("failed to get staff member: %w", err)
+	}
+
+	return s.issue(member)
+}
+
+// Refresh exchanges a refresh token for new tokens, if the member may
+// still log in
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	claims, err := s.parse(refreshToken, tokenRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.repo.Get(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, fmt.Errorf("failed to get staff member: %w", err)
+	}
+
+	return s.issue(member)
+}
+
+// Authenticate returns who an access token was issued to, with their
+// current role. Tokens of members who may no longer log in are refused.
+func (s *AuthService) Authenticate(ctx context.Context, accessToken string) (*Principal, error) {
+	claims, err := s.parse(accessToken, tokenAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	login, ok := s.members.get(claims.Subject)
+	if !ok {
+		member, err := s.repo.Get(ctx, claims.Subject)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			login = memberLogin{}
+		case err != nil:
+			return nil, fmt.Errorf("failed to get staff member: %w", err)
+		default:
+			login = memberLogin{allowed: member.CanLogin(), role: member.Role}
+		}
+		s.members.put(claims.Subject, login)
+	}
+	if !login.allowed {
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{UserID: claims.Subject, Role: login.role}, nil
+}
+
+func (s *AuthService) issue(member *staff.Staff) (*Tokens, error) {
+	if !member.CanLogin() {
+		return nil, ErrLoginDisabled
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.accessTTL)
+	accessToken, err := s.sign(member, tokenAccess, now, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := s.sign(member, tokenRefresh, now, now.Add(refreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+func (s *AuthService) sign(member *staff.Staff, typ string, issuedAt, expiresAt time.Time) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	token, err := security.SignToken(s.secret, tokenClaims{
+		Subject:   member.ID.Hex(),
+		Role:      member.Role,
+		Type:      typ,
+		ID:        hex.EncodeToString(id),
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return token, nil
+}
+
+// parse checks the signature, type and expiry of a token
+func (s *AuthService) parse(token, typ string) (*tokenClaims, error) {
+	var claims tokenClaims
+	if err := security.ParseToken(s.secret, token, &claims); err != nil {
+		return nil, ErrUnauthenticated
+	}
+	if claims.Type != typ || claims.Subject == "" || claims.ExpiresAt <= time.Now().Unix() {
+		return nil, ErrUnauthenticated
+	}
+	return &claims, nil
+}
+
+// memberLogin is whether a member may log in, and as what role
+type memberLogin struct {
+	allowed bool
+	role    string
+	expires time.Time
+}
+
+// memberCache keeps what Authenticate read of members for a short while,
+// so every request does not read the directory
+type memberCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	members map[string]memberLogin
+}
+
+func newMemberCache(ttl time.Duration) *memberCache {
+	return &memberCache{ttl: ttl, members: make(map[string]memberLogin)}
+}
+
+func (c *memberCache) get(id string) (memberLogin, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	login, ok := c.members[id]
+	if !ok || !time.Now().Before(login.expires) {
+		return memberLogin{}, false
+	}
+	return login, true
+}
+
+func (c *memberCache) put(id string, login memberLogin) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.members) >= maxCachedMembers {
+		for k, cached := range c.members {
+			if !now.Before(cached.expires) {
+				delete(c.members, k)
+			}
+		}
+		// Evict an arbitrary entry if none expired
+		for k := range c.members {
+			if len(c.members) < maxCachedMembers {
+				break
+			}
+			delete(c.members, k)
+		}
+	}
+	login.expires = now.Add(c.ttl)
+	c.members[id] = login
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ReplyTracker remembers automatic replies, so a sender gets at most one
+// per period for the same key
+type ReplyTracker interface {
+	// Allow reports whether a reply for key may be sent now and, if so,
+	// records it for period
+	Allow(ctx context.Context, key string, period time.Duration) (bool, error)
+}
+
+// RedisReplyTracker tracks replies in Redis, shared by all replicas
+type RedisReplyTracker struct {
+	client *redis.Client
+}
+
+func NewRedisReplyTracker(client *redis.Client) *RedisReplyTracker {
+	return &RedisReplyTracker{client: client}
+}
+
+func (t *RedisReplyTracker) Allow(ctx context.Context, key string, period time.Duration) (bool, error) {
+	ok, err := t.client.SetNX(ctx, "autoreply:"+key, time.Now().Unix(), period).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record automatic reply: %w", err)
+	}
+	return ok, nil
+}
+
+// MemoryReplyTracker tracks replies in process memory. Restarts forget the
+// replies sent, and replicas don't share them.
+type MemoryReplyTracker struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	// sweepAt is the size at which expired entries are dropped next
+	sweepAt int
+}
+
+func NewMemoryReplyTracker() *MemoryReplyTracker {
+	return &MemoryReplyTracker{expires: make(map[string]time.Time), sweepAt: 1024}
+}
+
+func (t *MemoryReplyTracker) Allow(_ context.Context, key string, period time.Duration) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if expires, ok := t.expires[key]; ok && now.Before(expires) {
+		return false, nil
+	}
+
+	if len(t.expires) >= t.sweepAt {
+		for k, expires := range t.expires {
+			if !now.Before(expires) {
+				delete(t.expires, k)
+			}
+		}
+		t.sweepAt = max(1024, 2*len(t.expires))
+	}
+
+	t.expires[key] = now.Add(period)
+	return true, nil
+}
+
+// autoReply is an automatic reply to a received message
+type autoReply struct {
+	// key tells replies apart; a sender gets one reply per key and days
+	key     string
+	days    int
+	subject string
+	content email.EmailContent
+	// addresses are the member's addresses besides their own the message
+	// may be addressed to
+	addresses []string
+}
+
+// sendAutoReply answers a message received for member unless RFC 3834
+// says not to: mail from the null sender or mailer daemons, list and other
+// automatic mail, mail not addressed to member directly, and senders
+// replied to within the reply's days. The reply goes out like any other
+// email from member and is filed as sent.
+func (s *EmailService) sendAutoReply(ctx context.Context, member *staff.Staff, msg *InboundMessage, e *email.Email, reply autoReply) {
+	sender := strings.ToLower(msg.MailFrom)
+	own := append([]string{member.Email}, reply.addresses...)
+	if sender == "" || containsAddress(own, sender) || automaticSender(sender) || automaticMessage(msg.Header) || !addressedTo(e, own) {
+		return
+	}
+
+	key := strings.Join([]string{reply.key, member.ID.Hex(), sender}, ":")
+	ok, err := s.replies.Allow(ctx, key, time.Duration(reply.days)*24*time.Hour)
+	if err != nil {
+		s.logger.Error("failed to check automatic replies", zap.String("staff_id", member.ID.Hex()), zap.Error(err))
+		return
+	}
+	if !ok {
+		return
+	}
+
+	subject := reply.subject
+	if subject == "" {
+		subject = "Auto: " + e.Subject
+	}
+	_, err = s.SendEmail(ctx, SendEmailParams{
+		From:          member.ID.Hex(),
+		To:            []string{sender},
+		Subject:       subject,
+		Content:       reply.content,
+		ThreadID:      e.ThreadID,
+		AutoSubmitted: email.AutoReplied,
+	})
+	if err != nil {
+		s.logger.Error("failed to send automatic reply",
+			zap.String("staff_id", member.ID.Hex()),
+			zap.String("to", sender),
+			zap.Error(err),
+		)
+		return
+	}
+	s.metrics.EmailRequests.WithLabelValues("auto_reply", "success").Inc()
+}
+
+// outOfOffice answers a received message with member's out-of-office
+// reply, the internal message for colleagues and the external one for
+// everyone else. Changing the reply lets senders get the new one.
+func (s *EmailService) outOfOffice(ctx context.Context, member *staff.Staff, msg *InboundMessage, e *email.Email, ooo *staff.OutOfOffice) {
+	internal, err := s.isInternal(ctx, member, msg.MailFrom)
+	if err != nil {
+		s.logger.Error("failed to look up sender", zap.String("from", msg.MailFrom), zap.Error(err))
+		return
+	}
+
+	message := ooo.ExternalMessage
+	if internal {
+		message = ooo.InternalMessage
+	}
+	if message == "" {
+		return
+	}
+
+	s.sendAutoReply(ctx, member, msg, e, autoReply{
+		key:     fmt.Sprintf("ooo:%d", ooo.UpdatedAt.Unix()),
+		days:    ooo.Days,
+		subject: ooo.Subject,
+		content: email.EmailContent{Text: message},
+	})
+}
+
+// isInternal reports whether address belongs to a colleague of member: a
+// staff member or an address in member's domain
+func (s *EmailService) isInternal(ctx context.Context, member *staff.Staff, address string) (bool, error) {
+	if address == "" {
+		return false, nil
+	}
+	if strings.EqualFold(senderDomain(address), senderDomain(member.Email)) {
+		return true, nil
+	}
+
+	_, err := s.staff.GetByEmail(ctx, strings.ToLower(address))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// automaticSender reports addresses of mailing lists and mailer daemons
+func automaticSender(address string) bool {
+	local, _, _ := strings.Cut(address, "@")
+	return local == "mailer-daemon" || local == "postmaster" || local == "listserv" ||
+		strings.HasPrefix(local, "owner-") || strings.HasSuffix(local, "-request") ||
+		strings.HasPrefix(local, "noreply") || strings.HasPrefix(local, "no-reply")
+}
+
+// automaticMessage reports automatic, bulk and list mail, which must not
+// be answered automatically
+func automaticMessage(header mail.Header) bool {
+	if submitted := header.Get("Auto-Submitted"); submitted != "" && !strings.EqualFold(strings.TrimSpace(submitted), "no") {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(header.Get("Precedence"))) {
+	case "bulk", "list", "junk":
+		return true
+	}
+	if suppress := strings.ToLower(header.Get("X-Auto-Response-Suppress")); strings.Contains(suppress, "oof") || strings.Contains(suppress, "all") {
+		return true
+	}
+	return header.Get("List-Id") != "" || header.Get("List-Unsubscribe") != "" || header.Get("List-Post") != ""
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+)
+
+// vacationEnv returns an env whose alice@example.com is away by script
+func vacationEnv(t *testing.T, script string) (*testEnv, *staff.Staff) {
+	t.Helper()
+
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	if _, err := env.Staff.SetSieveScript(context.Background(), alice.ID.Hex(), script); err != nil {
+		t.Fatal(err)
+	}
+	return env, alice
+}
+
+func TestVacationSuppression(t *testing.T) {
+	const script = `require "vacation"; vacation :days 3 :subject "Away" :addresses ["alice@alias.example"] "Back on Monday.";`
+
+	tests := []struct {
+		name  string
+		fn    func(msg *InboundMessage)
+		reply bool
+	}{
+		{"personal mail", nil, true},
+		{"auto-submitted no", func(msg *InboundMessage) { msg.Header["Auto-Submitted"] = []string{" No "} }, true},
+		{"auto-generated", func(msg *InboundMessage) { msg.Header["Auto-Submitted"] = []string{"auto-generated"} }, false},
+		{"auto-replied", func(msg *InboundMessage) {
+			msg.Header["Auto-Submitted"] = []string{"auto-replied; owner-email=\"x@example.org\""}
+		}, false},
+		{"list id", func(msg *InboundMessage) { msg.Header["List-Id"] = []string{"<team.lists.example.org>"} }, false},
+		{"list unsubscribe", func(msg *InboundMessage) { msg.Header["List-Unsubscribe"] = []string{"<mailto:leave@example.org>"} }, false},
+		{"list post", func(msg *InboundMessage) { msg.Header["List-Post"] = []string{"<mailto:team@example.org>"} }, false},
+		{"precedence bulk", func(msg *InboundMessage) { msg.Header["Precedence"] = []string{"bulk"} }, false},
+		{"precedence list", func(msg *InboundMessage) { msg.Header["Precedence"] = []string{"List"} }, false},
+		{"precedence junk", func(msg *InboundMessage) { msg.Header["Precedence"] = []string{" junk"} }, false},
+		{"precedence first class", func(msg *InboundMessage) { msg.Header["Precedence"] = []string{"first-class"} }, true},
+		{"response suppressed", func(msg *InboundMessage) { msg.Header["X-Auto-Response-Suppress"] = []string{"DR, OOF"} }, false},
+		{"null sender", func(msg *InboundMessage) { msg.MailFrom = "" }, false},
+		{"mailer daemon", func(msg *InboundMessage) { msg.MailFrom = "MAILER-DAEMON@example.org" }, false},
+		{"no-reply sender", func(msg *InboundMessage) { msg.MailFrom = "no-reply@example.org" }, false},
+		{"list owner", func(msg *InboundMessage) { msg.MailFrom = "owner-team@example.org" }, false},
+		// Replies to oneself would answer one's own mail
+		{"own address", func(msg *InboundMessage) { msg.MailFrom = "Alice@Example.com" }, false},
+		{"own other address", func(msg *InboundMessage) { msg.MailFrom = "alice@alias.example" }, false},
+		// Blind copies may be list mail the sender did not address to alice
+		{"blind copy", func(msg *InboundMessage) { msg.Email.To = []email.Participant{{Email: "team@example.org"}} }, false},
+		{"other address", func(msg *InboundMessage) { msg.Email.To = []email.Participant{{Email: "ALICE@alias.example"}} }, true},
+		{"copied", func(msg *InboundMessage) {
+			msg.Email.CC = msg.Email.To
+			msg.Email.To = []email.Participant{{Email: "bob@example.org"}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env, alice := vacationEnv(t, script)
+
+			msg := inboundMessage("<m1@example.org>", []string{"alice@example.com"}, "alice@example.com")
+			if tt.fn != nil {
+				tt.fn(msg)
+			}
+			result, err := env.Email.Receive(ctx, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Delivered["alice@example.com"] == nil {
+				t.Fatalf("result = %+v, want the message kept", result)
+			}
+
+			sent := env.sentBy(t, alice)
+			if !tt.reply {
+				if len(sent) != 0 {
+					t.Fatalf("replied to %v", sent[0].To)
+				}
+				return
+			}
+			if len(sent) != 1 {
+				t.Fatalf("sent %d replies, want 1", len(sent))
+			}
+			reply := sent[0]
+			if len(reply.To) != 1 || reply.To[0].Email != msg.MailFrom || reply.Subject != "Away" ||
+				reply.Content.Text != "Back on Monday." || reply.Metadata.AutoSubmitted != email.AutoReplied {
+				t.Fatalf("reply = %+v", reply)
+			}
+		})
+	}
+}
+
+func TestVacationDays(t *testing.T) {
+	ctx := context.Background()
+	env, alice := vacationEnv(t, `require "vacation"; vacation :days 3 "Back on Monday.";`)
+
+	receive := func(messageID, from string) {
+		t.Helper()
+		msg := inboundMessage(messageID, []string{"alice@example.com"}, "alice@example.com")
+		msg.MailFrom = from
+		if _, err := env.Email.Receive(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replies := func() int {
+		t.Helper()
+		return len(env.sentBy(t, alice))
+	}
+
+	receive("<m1@example.org>", "sender@example.org")
+	if n := replies(); n != 1 {
+		t.Fatalf("sent %d replies, want 1", n)
+	}
+	// Within the days, the sender is not answered again, whatever the case
+	// of their address; other senders are
+	receive("<m2@example.org>", "Sender@Example.org")
+	if n := replies(); n != 1 {
+		t.Fatalf("sent %d replies within the days, want 1", n)
+	}
+	receive("<m3@example.org>", "other@example.org")
+	if n := replies(); n != 2 {
+		t.Fatalf("sent %d replies, want another for a new sender", n)
+	}
+
+	// The reply is remembered for the script's days
+	tracker := env.Email.replies.(*MemoryReplyTracker)
+	tracker.mu.Lock()
+	if len(tracker.expires) != 2 {
+		t.Fatalf("tracking %d replies, want 2", len(tracker.expires))
+	}
+	for key, expires := range tracker.expires {
+		if left := time.Until(expires); left < 3*24*time.Hour-time.Minute || left > 3*24*time.Hour {
+			t.Fatalf("reply %s remembered for %v, want 3 days", key, left)
+		}
+		// Once they have passed, the sender is answered again
+		tracker.expires[key] = time.Now().Add(-time.Second)
+	}
+	tracker.mu.Unlock()
+
+	receive("<m4@example.org>", "sender@example.org")
+	if n := replies(); n != 3 {
+		t.Fatalf("sent %d replies after the days, want 3", n)
+	}
+
+	// A new vacation answers senders replied to by the old one
+	if _, err := env.Staff.SetSieveScript(ctx, alice.ID.Hex(), `require "vacation"; vacation :days 3 "Away until Friday.";`); err != nil {
+		t.Fatal(err)
+	}
+	receive("<m5@example.org>", "sender@example.org")
+	if n := replies(); n != 4 {
+		t.Fatalf("sent %d replies for the new vacation, want 4", n)
+	}
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/bounce"
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/suppression"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// maxReportPartSize bounds the report parts of a bounce read into memory
+const maxReportPartSize = 1 << 20
+
+var (
+	ErrRecipientSuppressed = errors.New("recipient is on the suppression list")
+	ErrSuppressionNotFound = errors.New("address is not suppressed")
+	ErrSuppressionExists   = errors.New("address is already suppressed")
+)
+
+// ReturnPath returns the envelope sender to send e to recipient with: a
+// VERP return path when bounce return paths are configured, the sender's
+// address otherwise
+func (s *EmailService) ReturnPath(e *email.Email, recipient string) string {
+	if s.verp == nil {
+		return e.From.Email
+	}
+	return s.verp.ReturnPath(e.ID.Hex(), recipient)
+}
+
+// checkSuppressed refuses to send to suppressed addresses
+func (s *EmailService) checkSuppressed(ctx context.Context, recipients []email.Participant) error {
+	addresses := make([]string, len(recipients))
+	for i, p := range recipients {
+		addresses[i] = p.Email
+	}
+
+	suppressed, err := s.suppressions.Find(ctx, addresses)
+	if err != nil {
+		return fmt.Errorf("failed to check suppression list: %w", err)
+	}
+	if len(suppressed) == 0 {
+		return nil
+	}
+
+	listed := make([]string, len(suppressed))
+	for i, entry := range suppressed {
+		listed[i] = entry.Email
+	}
+	return fmt.Errorf("%w: %s", ErrRecipientSuppressed, strings.Join(listed, ", "))
+}
+
+// ListSuppressions returns one page of the suppression list, optionally of
+// one reason
+func (s *EmailService) ListSuppressions(ctx context.Context, reason, cursor string, limit int) ([]*suppression.Suppression, error) {
+	list, err := s.suppressions.List(ctx, &suppression.ListQuery{Reason: reason, Cursor: cursor, Limit: limit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list suppressions: %w", err)
+	}
+	return list, nil
+}
+
+// AddSuppression stops all email to an address
+func (s *EmailService) AddSuppression(ctx context.Context, userID, address, note string) (*suppression.Suppression, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrInvalidEmail, address)
+	}
+
+	entry := &suppression.Suppression{
+		Email:      strings.ToLower(parsed.Address),
+		Reason:     suppression.ReasonManual,
+		Diagnostic: note,
+		CreatedBy:  userID,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.suppressions.Add(ctx, entry); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			return nil, ErrSuppressionExists
+		}
+		return nil, fmt.Errorf("failed to add suppression: %w", err)
+	}
+	return entry, nil
+}
+
+// DeleteSuppression allows email to an address again, such as once a
+// bounced mailbox is known to work
+func (s *EmailService) DeleteSuppression(ctx context.Context, address string) error {
+	if err := s.suppressions.Delete(ctx, strings.ToLower(strings.TrimSpace(address))); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrSuppressionNotFound
+		}
+		return fmt.Errorf("failed to delete suppression: %w", err)
+	}
+	return nil
+}
+
+// returnPath is the email and recipient a VERP return path stands for
+type returnPath struct {
+	address   string
+	emailID   string
+	recipient string
+}
+
+// receiveBounces processes the bounces of sent mail among an inbound
+// message: it was sent to VERP return paths, or it is a delivery status
+// notification from the null sender. Return paths are not mailboxes and
+// are listed in result.Bounces; the other envelope recipients are
+// returned, lowercase and without repeats, for delivery.
+func (s *EmailService) receiveBounces(ctx context.Context, msg *InboundMessage, result *InboundResult) []string {
+	var (
+		paths     []returnPath
+		mailboxes []string
+		seen      = make(map[string]bool)
+	)
+	for _, raw := range msg.Recipients {
+		rcpt := strings.ToLower(strings.Trim(strings.TrimSpace(raw), "<>"))
+		if seen[rcpt] {
+			continue
+		}
+		seen[rcpt] = true
+
+		if s.verp != nil {
+			if emailID, recipient, ok := s.verp.Decode(rcpt); ok {
+				paths = append(paths, returnPath{address: rcpt, emailID: emailID, recipient: recipient})
+				result.Bounces = append(result.Bounces, rcpt)
+				continue
+			}
+		}
+		mailboxes = append(mailboxes, rcpt)
+	}
+
+	// Bounces come from the null sender; anything else sent to a return
+	// path, such as a human reply, is dropped
+	if msg.MailFrom != "" {
+		return mailboxes
+	}
+
+	report, messageID := readReport(msg)
+	for _, path := range paths {
+		if report == nil && automaticMessage(msg.Header) {
+			// An automatic reply sent to the return path, not a bounce
+			continue
+		}
+		s.recordBounce(ctx, path.emailID, path.recipient, report, messageID)
+	}
+	if len(paths) == 0 && report != nil {
+		s.recordBounce(ctx, "", "", report, messageID)
+	}
+	return mailboxes
+}
+
+// readReport parses the delivery status report of a bounce and the
+// Message-ID of the returned message. The parts read are replaced with
+// buffered copies so that they are stored as received.
+func readReport(msg *InboundMessage) (*bounce.Report, string) {
+	var (
+		report    *bounce.Report
+		messageID string
+	)
+	for i, attachment := range msg.Attachments {
+		mediaType, _, err := mime.ParseMediaType(attachment.ContentType)
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case bounce.ContentTypeDeliveryStatus, bounce.ContentTypeGlobalDeliveryStatus,
+			bounce.ContentTypeMessage, bounce.ContentTypeHeaders:
+		default:
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(attachment.Content, maxReportPartSize))
+		if err != nil {
+			continue
+		}
+		msg.Attachments[i].Content = io.MultiReader(bytes.NewReader(data), attachment.Content)
+
+		switch mediaType {
+		case bounce.ContentTypeDeliveryStatus, bounce.ContentTypeGlobalDeliveryStatus:
+			if parsed, err := bounce.ParseReport(bytes.NewReader(data)); err == nil && report == nil {
+				report = parsed
+			}
+		default:
+			if messageID == "" {
+				messageID = bounce.MessageID(bytes.NewReader(data))
+			}
+		}
+	}
+	return report, messageID
+}
+
+// recordBounce updates the delivery state of the sent email a bounce is
+// about, found by its return path, the envelope ID of the report or the
+// Message-ID of the returned message. Hard bounced addresses are added to
+// the suppression list. Without a report, a bounce to the return path of
+// recipient is taken as a permanent failure.
+func (s *EmailService) recordBounce(ctx context.Context, emailID, recipient string, report *bounce.Report, messageID string) {
+	e, err := s.bouncedEmail(ctx, emailID, report, messageID)
+	if err != nil {
+		s.logger.Error("failed to find bounced email", zap.String("email_id", emailID), zap.Error(err))
+		return
+	}
+	if e == nil {
+		s.logger.Info("bounce for unknown email", zap.String("email_id", emailID), zap.String("message_id", messageID))
+		return
+	}
+
+	var statuses []bounce.Status
+	if report != nil {
+		statuses = report.Recipients
+	} else {
+		statuses = []bounce.Status{{Recipient: recipient, Action: bounce.ActionFailed}}
+	}
+
+	var hard []email.RecipientDelivery
+	updated, changes, err := s.updateDelivery(ctx, e, func(e *email.Email, now time.Time) []email.RecipientDelivery {
+		hard = nil
+		var changes []email.RecipientDelivery
+		for _, status := range statuses {
+			rcpt := bouncedRecipient(e, status, recipient, len(statuses) == 1)
+			if rcpt == "" {
+				continue
+			}
+			state := deliveryState(status.Action)
+			if state == "" {
+				continue
+			}
+
+			delivery := addAttempt(e, rcpt, email.DeliveryAttempt{
+				Status:   state,
+				Code:     status.Code,
+				Response: status.Diagnostic,
+				RemoteMX: status.RemoteMTA,
+			}, now)
+			changes = append(changes, delivery)
+			if status.HardBounce() {
+				hard = append(hard, delivery)
+			}
+		}
+		return changes
+	})
+	if err != nil {
+		s.logger.Error("failed to update delivery state", zap.String("email_id", e.ID.Hex()), zap.Error(err))
+		return
+	}
+
+	for _, delivery := range hard {
+		s.suppress(ctx, updated, delivery.Recipient, delivery.Diagnostic)
+		s.bounceCampaignRecipient(ctx, updated, delivery.Recipient, delivery.Diagnostic)
+	}
+	for _, delivery := range changes {
+		s.metrics.EmailRequests.WithLabelValues("bounce_"+delivery.Status, "success").Inc()
+	}
+	s.notifyDelivery(ctx, updated, changes)
+}
+
+// bouncedEmail finds the sent email a bounce is about, or returns nil
+func (s *EmailService) bouncedEmail(ctx context.Context, emailID string, report *bounce.Report, messageID string) (*email.Email, error) {
+	if emailID == "" && report != nil && primitive.IsValidObjectID(report.EnvelopeID) {
+		emailID = report.EnvelopeID
+	}
+
+	var e *email.Email
+	switch {
+	case emailID != "":
+		found, err := s.repo.Get(ctx, emailID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		e = found
+	case messageID != "":
+		found, err := s.repo.List(ctx, &email.ListQuery{MessageID: messageID, Labels: []string{LabelSent}, Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, nil
+		}
+		e = found[0]
+	default:
+		return nil, nil
+	}
+
+	if !hasLabel(e.Labels, LabelSent) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// bouncedRecipient returns the recipient of e a delivery status is about.
+// The final recipient may differ from the address sent to when mail was
+// forwarded; the original recipient or, for a report about one recipient,
+// the recipient of the return path tell it then.
+func bouncedRecipient(e *email.Email, status bounce.Status, returnPath string, only bool) string {
+	recipients := deliveryRecipients(e)
+	for _, candidate := range []string{status.Recipient, status.OriginalRecipient} {
+		if candidate != "" && containsAddress(recipients, candidate) {
+			return strings.ToLower(candidate)
+		}
+	}
+	if only && returnPath != "" && containsAddress(recipients, returnPath) {
+		return strings.ToLower(returnPath)
+	}
+	return ""
+}
+
+// deliveryState maps a DSN action to the delivery state it reports
+func deliveryState(action string) string {
+	switch action {
+	case bounce.ActionFailed:
+		return email.DeliveryBounced
+	case bounce.ActionDelayed:
+		return email.DeliveryDeferred
+	case bounce.ActionDelivered, bounce.ActionRelayed, bounce.ActionExpanded:
+		return email.DeliveryDelivered
+	}
+	return ""
+}
+
+// suppress adds a hard bounced address to the suppression list
+func (s *EmailService) suppress(ctx context.Context, e *email.Email, address, diagnostic string) {
+	err := s.suppressions.Add(ctx, &suppression.Suppression{
+		Email:      address,
+		Reason:     suppression.ReasonBounce,
+		Diagnostic: diagnostic,
+		EmailID:    e.ID.Hex(),
+		CreatedAt:  time.Now(),
+	})
+	if err != nil && !errors.Is(err, storage.ErrDuplicate) {
+		s.logger.Error("failed to suppress bounced address", zap.String("address", address), zap.Error(err))
+	}
+}
+
+// bounceCampaignRecipient marks the campaign recipient of a bounced
+// campaign email, so that later campaigns skip the address too
+func (s *EmailService) bounceCampaignRecipient(ctx context.Context, e *email.Email, address, diagnostic string) {
+	if e.Metadata.CampaignID == "" {
+		return
+	}
+
+	recipients, err := s.campaigns.ListRecipients(ctx, &campaign.RecipientQuery{
+		CampaignID: e.Metadata.CampaignID,
+		Email:      address,
+		MessageID:  e.MessageID,
+		Limit:      1,
+	})
+	if err != nil || len(recipients) == 0 {
+		if err != nil {
+			s.logger.Error("failed to get campaign recipient", zap.String("campaign_id", e.Metadata.CampaignID), zap.Error(err))
+		}
+		return
+	}
+
+	recipient := recipients[0]
+	recipient.Status = campaign.RecipientBounced
+	recipient.Error = diagnostic
+	recipient.UpdatedAt = time.Now()
+	if err := s.campaigns.UpdateRecipient(ctx, recipient); err != nil {
+		s.logger.Error("failed to update campaign recipient", zap.String("campaign_id", e.Metadata.CampaignID), zap.Error(err))
+	}
+}
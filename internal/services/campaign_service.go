@@ -0,0 +1,750 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+	"github.com/bezata/blockchainml-email/internal/jobs"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// campaignBatches is how many batches a campaign sends per minute, so
+	// that its rate is spread over the minute
+	campaignBatches = 6
+	// maxRecipientAttempts bounds the sends of a recipient failing with
+	// transient errors
+	maxRecipientAttempts = 3
+	// campaignJobAttempts bounds the retries of a batch failing on storage
+	campaignJobAttempts = 5
+	// UnsubscribeVariable holds the recipient's unsubscribe link in
+	// campaign templates, empty when no unsubscribe URL is configured
+	UnsubscribeVariable = "unsubscribeUrl"
+)
+
+var (
+	ErrCampaignNotFound     = errors.New("campaign not found")
+	ErrCampaignAccessDenied = errors.New("no access to this campaign")
+	ErrInvalidCampaign      = errors.New("invalid campaign")
+	ErrCampaignStatus       = errors.New("not allowed in the campaign's status")
+	ErrTooManyRecipients    = errors.New("too many campaign recipients")
+	ErrUnknownUnsubscribe   = errors.New("unknown unsubscribe link")
+)
+
+type CampaignServiceConfig struct {
+	Repo      storage.CampaignRepository
+	Jobs      storage.JobRepository
+	Staff     storage.StaffRepository
+	Email     *EmailService
+	Templates *TemplateService
+	Config    config.CampaignConfig
+	Logger    *zap.Logger
+	Metrics   *metrics.Metrics
+}
+
+// CampaignService sends a message template to a list of recipients, each
+// with variables of their own. Campaigns are sent in batches by background
+// jobs at the campaign's rate through EmailService.SendEmail. Recipients
+// who bounced or unsubscribed from any campaign, or whose address is
+// suppressed, are skipped. Bounces are recorded by EmailService.
+type CampaignService struct {
+	repo      storage.CampaignRepository
+	jobs      storage.JobRepository
+	staff     storage.StaffRepository
+	email     *EmailService
+	templates *TemplateService
+	cfg       config.CampaignConfig
+	logger    *zap.Logger
+	metrics   *metrics.Metrics
+}
+
+func NewCampaignService(cfg CampaignServiceConfig) *CampaignService {
+	return &CampaignService{
+		repo:      cfg.Repo,
+		jobs:      cfg.Jobs,
+		staff:     cfg.Staff,
+		email:     cfg.Email,
+		templates: cfg.Templates,
+		cfg:       cfg.Config,
+		logger:    cfg.Logger,
+		metrics:   cfg.Metrics,
+	}
+}
+
+// CampaignParams describes a new campaign
+type CampaignParams struct {
+	Name       string
+	TemplateID string
+	// Subject overrides the subject of the template
+	Subject   string
+	Signature bool
+	// Variables are the defaults of every recipient's variables
+	Variables map[string]string
+	// Rate is the number of emails sent per minute, the configured default
+	// when zero
+	Rate int
+}
+
+// RecipientInput is a recipient added to a campaign
+type RecipientInput struct {
+	Email     string
+	Name      string
+	Variables map[string]string
+}
+
+// CampaignProgress is a campaign with the number of its recipients by
+// status
+type CampaignProgress struct {
+	Campaign *campaign.Campaign `json:"campaign"`
+	Total    int                `json:"total"`
+	Counts   map[string]int     `json:"counts"`
+}
+
+func (s *CampaignService) CreateCampaign(ctx context.Context, userID string, params CampaignParams) (*campaign.Campaign, error) {
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidCampaign)
+	}
+	rate, err := s.rate(params.Rate)
+	if err != nil {
+		return nil, err
+	}
+
+	// The template must be one the sender may use
+	t, err := s.templates.GetTemplate(ctx, userID, params.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind != template.KindMessage {
+		return nil, fmt.Errorf("%w: %s is not a message template", ErrInvalidCampaign, t.Name)
+	}
+
+	now := time.Now()
+	c := &campaign.Campaign{
+		Name:       params.Name,
+		TemplateID: params.TemplateID,
+		Subject:    strings.TrimSpace(params.Subject),
+		Signature:  params.Signature,
+		Variables:  params.Variables,
+		Rate:       rate,
+		Status:     campaign.StatusDraft,
+		CreatedBy:  userID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.repo.Create(ctx, c); err != nil {
+		return nil, fmt.Errorf("failed to create campaign: %w", err)
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("campaign_create", "success").Inc()
+	return c, nil
+}
+
+func (s *CampaignService) GetCampaign(ctx context.Context, userID, id string) (*campaign.Campaign, error) {
+	return s.campaign(ctx, userID, id)
+}
+
+// ListCampaigns returns one page of the campaigns of userID, or of every
+// campaign for admins
+func (s *CampaignService) ListCampaigns(ctx context.Context, userID, status, cursor string, limit int) ([]*campaign.Campaign, error) {
+	query := &campaign.ListQuery{Status: status, Cursor: cursor, Limit: limit}
+	admin, err := s.isAdmin(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		query.CreatedBy = userID
+	}
+
+	campaigns, err := s.repo.List(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaigns: %w", err)
+	}
+	return campaigns, nil
+}
+
+// DeleteCampaign deletes a campaign with its recipients. Running campaigns
+// must be paused or cancelled first.
+func (s *CampaignService) DeleteCampaign(ctx context.Context, userID, id string) error {
+	c, err := s.campaign(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if c.Status == campaign.StatusRunning {
+		return fmt.Errorf("%w: %s", ErrCampaignStatus, c.Status)
+	}
+
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrCampaignNotFound
+		}
+		return fmt.Errorf("failed to delete campaign: %w", err)
+	}
+	return nil
+}
+
+// AddRecipients adds recipients to a campaign that has not ended. Addresses
+// the campaign already lists are skipped; the number added is returned.
+func (s *CampaignService) AddRecipients(ctx context.Context, userID, id string, inputs []RecipientInput) (int, error) {
+	c, err := s.campaign(ctx, userID, id)
+	if err != nil {
+		return 0, err
+	}
+	if c.Status == campaign.StatusCompleted || c.Status == campaign.StatusCancelled {
+		return 0, fmt.Errorf("%w: %s", ErrCampaignStatus, c.Status)
+	}
+
+	counts, err := s.repo.CountRecipients(ctx, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recipients: %w", err)
+	}
+	if total(counts)+len(inputs) > s.cfg.MaxRecipients {
+		return 0, fmt.Errorf("%w: a campaign has at most %d", ErrTooManyRecipients, s.cfg.MaxRecipients)
+	}
+
+	now := time.Now()
+	recipients := make([]*campaign.Recipient, 0, len(inputs))
+	seen := make(map[string]bool, len(inputs))
+	for i, input := range inputs {
+		address, err := mail.ParseAddress(strings.TrimSpace(input.Email))
+		if err != nil {
+			return 0, fmt.Errorf("%w: recipient %d: invalid address %q", ErrInvalidCampaign, i+1, input.Email)
+		}
+		email := strings.ToLower(address.Address)
+		if seen[email] {
+			continue
+		}
+		seen[email] = true
+
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			name = address.Name
+		}
+		recipients = append(recipients, &campaign.Recipient{
+			CampaignID: id,
+			Email:      email,
+			Name:       name,
+			Variables:  input.Variables,
+			Status:     campaign.RecipientPending,
+			Token:      uuid.New().String(),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		})
+	}
+
+	added, err := s.repo.AddRecipients(ctx, recipients)
+	if err != nil {
+		return added, fmt.Errorf("failed to add recipients: %w", err)
+	}
+	return added, nil
+}
+
+// ImportRecipients adds the recipients of a CSV file to a campaign. The
+// first row names the columns: "email" is required, "name" is optional and
+// every other column is a template variable.
+func (s *CampaignService) ImportRecipients(ctx context.Context, userID, id string, r io.Reader) (int, error) {
+	inputs, err := parseRecipientCSV(r, s.cfg.MaxRecipients)
+	if err != nil {
+		return 0, err
+	}
+	return s.AddRecipients(ctx, userID, id, inputs)
+}
+
+// ListRecipients returns one page of a campaign's recipients, optionally
+// of one status
+func (s *CampaignService) ListRecipients(ctx context.Context, userID, id, status, cursor string, limit int) ([]*campaign.Recipient, error) {
+	if _, err := s.campaign(ctx, userID, id); err != nil {
+		return nil, err
+	}
+
+	query := &campaign.RecipientQuery{CampaignID: id, Cursor: cursor, Limit: limit}
+	if status != "" {
+		query.Statuses = []string{status}
+	}
+	recipients, err := s.repo.ListRecipients(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipients: %w", err)
+	}
+	return recipients, nil
+}
+
+// Progress returns a campaign with the number of its recipients by status
+func (s *CampaignService) Progress(ctx context.Context, userID, id string) (*CampaignProgress, error) {
+	c, err := s.campaign(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := s.repo.CountRecipients(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recipients: %w", err)
+	}
+	return &CampaignProgress{Campaign: c, Total: total(counts), Counts: counts}, nil
+}
+
+// StartCampaign starts sending a draft campaign or resumes a paused one.
+// Every start is a new run; batches of an earlier run still scheduled stop
+// when they find it, so a campaign sends in one chain of batches.
+func (s *CampaignService) StartCampaign(ctx context.Context, userID, id string) (*campaign.Campaign, error) {
+	c, err := s.campaign(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != campaign.StatusDraft && c.Status != campaign.StatusPaused {
+		return nil, fmt.Errorf("%w: %s", ErrCampaignStatus, c.Status)
+	}
+
+	counts, err := s.repo.CountRecipients(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recipients: %w", err)
+	}
+	if counts[campaign.RecipientPending] == 0 {
+		return nil, fmt.Errorf("%w: the campaign has no pending recipients", ErrInvalidCampaign)
+	}
+
+	now := time.Now()
+	c.Status = campaign.StatusRunning
+	c.Run++
+	c.UpdatedAt = now
+	if c.StartedAt == nil {
+		c.StartedAt = &now
+	}
+	if err := s.repo.Update(ctx, c); err != nil {
+		return nil, fmt.Errorf("failed to update campaign: %w", err)
+	}
+
+	if err := s.enqueue(ctx, jobs.CampaignPayload{CampaignID: id, Run: c.Run}, now); err != nil {
+		return nil, err
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("campaign_start", "success").Inc()
+	return c, nil
+}
+
+// PauseCampaign stops sending after the current batch until the campaign
+// is started again
+func (s *CampaignService) PauseCampaign(ctx context.Context, userID, id string) (*campaign.Campaign, error) {
+	return s.transition(ctx, userID, id, campaign.StatusPaused, campaign.StatusRunning)
+}
+
+// CancelCampaign stops a campaign for good; its pending recipients are
+// not sent to
+func (s *CampaignService) CancelCampaign(ctx context.Context, userID, id string) (*campaign.Campaign, error) {
+	return s.transition(ctx, userID, id, campaign.StatusCancelled,
+		campaign.StatusDraft, campaign.StatusRunning, campaign.StatusPaused)
+}
+
+// Unsubscribe records that the recipient of an unsubscribe link wants no
+// more campaigns. Later campaigns skip their address.
+func (s *CampaignService) Unsubscribe(ctx context.Context, token string) error {
+	recipient, err := s.recipientBy(ctx, &campaign.RecipientQuery{Token: token, Limit: 1})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrUnknownUnsubscribe
+		}
+		return err
+	}
+	if recipient.Status == campaign.RecipientUnsubscribed {
+		return nil
+	}
+
+	recipient.Status = campaign.RecipientUnsubscribed
+	recipient.UpdatedAt = time.Now()
+	if err := s.repo.UpdateRecipient(ctx, recipient); err != nil {
+		return fmt.Errorf("failed to update recipient: %w", err)
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("campaign_unsubscribe", "success").Inc()
+	return nil
+}
+
+// SendBatch is the job handler of jobs.TaskSendCampaign. It sends the next
+// batch of pending recipients, then schedules the following batch so that
+// the campaign keeps to its rate, or completes the campaign.
+func (s *CampaignService) SendBatch(ctx context.Context, job *jobs.Job) error {
+	var payload jobs.CampaignPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid campaign job payload: %w", err)
+	}
+
+	c, err := s.repo.Get(ctx, payload.CampaignID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get campaign: %w", err)
+	}
+	// Paused and cancelled campaigns stop here, and so do batches of an
+	// earlier run; starting again enqueues a new batch
+	if c.Status != campaign.StatusRunning || payload.Run != c.Run {
+		return nil
+	}
+
+	started := time.Now()
+	size, interval := batchSize(c.Rate)
+	recipients, err := s.repo.ListRecipients(ctx, &campaign.RecipientQuery{
+		CampaignID: c.ID.Hex(),
+		Statuses:   []string{campaign.RecipientPending},
+		Limit:      size,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list recipients: %w", err)
+	}
+
+	if len(recipients) == 0 {
+		if err := s.failInterrupted(ctx, c); err != nil {
+			return err
+		}
+		c.Status = campaign.StatusCompleted
+		c.CompletedAt = &started
+		c.UpdatedAt = started
+		if err := s.repo.Update(ctx, c); err != nil {
+			return fmt.Errorf("failed to update campaign: %w", err)
+		}
+		s.logger.Info("campaign completed", zap.String("campaign_id", c.ID.Hex()))
+		return nil
+	}
+
+	for _, recipient := range recipients {
+		if err := s.send(ctx, c, recipient); err != nil {
+			return err
+		}
+	}
+
+	payload.Batch++
+	return s.enqueue(ctx, payload, started.Add(interval))
+}
+
+// send sends the campaign to one recipient and records the outcome. The
+// recipient is claimed first by moving them from pending to sending, so a
+// batch running concurrently skips them.
+func (s *CampaignService) send(ctx context.Context, c *campaign.Campaign, recipient *campaign.Recipient) error {
+	now := time.Now()
+	recipient.UpdatedAt = now
+
+	suppressed, err := s.suppressed(ctx, recipient)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		recipient.Status = campaign.RecipientSkipped
+		recipient.Error = "the address bounced or unsubscribed before"
+		return s.replaceRecipient(ctx, recipient, campaign.RecipientPending)
+	}
+
+	recipient.Status = campaign.RecipientSending
+	recipient.Attempts++
+	if err := s.repo.ReplaceRecipient(ctx, recipient, campaign.RecipientPending); err != nil {
+		if errors.Is(err, storage.ErrConflict) || errors.Is(err, storage.ErrNotFound) {
+			// Claimed by another batch, or no longer pending
+			return nil
+		}
+		return fmt.Errorf("failed to claim recipient: %w", err)
+	}
+
+	variables := make(map[string]string, len(c.Variables)+len(recipient.Variables)+1)
+	for name, value := range c.Variables {
+		variables[name] = value
+	}
+	for name, value := range recipient.Variables {
+		variables[name] = value
+	}
+	unsubscribe := s.unsubscribeURL(recipient)
+	variables[UnsubscribeVariable] = unsubscribe
+
+	e, err := s.email.SendEmail(ctx, SendEmailParams{
+		From:            c.CreatedBy,
+		To:              []string{(&mail.Address{Name: recipient.Name, Address: recipient.Email}).String()},
+		Subject:         c.Subject,
+		TemplateID:      c.TemplateID,
+		Variables:       variables,
+		Signature:       c.Signature,
+		CampaignID:      c.ID.Hex(),
+		ListUnsubscribe: unsubscribe,
+	})
+	switch {
+	case err == nil:
+		recipient.Status = campaign.RecipientSent
+		recipient.EmailID = e.ID.Hex()
+		recipient.MessageID = e.MessageID
+		recipient.Error = ""
+		recipient.SentAt = &now
+		s.metrics.EmailRequests.WithLabelValues("campaign_send", "success").Inc()
+	case errors.Is(err, ErrRecipientSuppressed):
+		recipient.Status = campaign.RecipientSkipped
+		recipient.Error = err.Error()
+	case permanentSendError(err) || recipient.Attempts >= maxRecipientAttempts:
+		recipient.Status = campaign.RecipientFailed
+		recipient.Error = err.Error()
+		s.metrics.EmailRequests.WithLabelValues("campaign_send", "error").Inc()
+	default:
+		// Left pending for the next batch
+		recipient.Status = campaign.RecipientPending
+		recipient.Error = err.Error()
+		s.logger.Warn("failed to send campaign email", zap.String("campaign_id", c.ID.Hex()),
+			zap.String("recipient", recipient.Email), zap.Error(err))
+	}
+	return s.replaceRecipient(ctx, recipient, campaign.RecipientSending)
+}
+
+// failInterrupted fails the recipients a batch claimed but did not record
+// the outcome of, for instance when the worker stopped while sending.
+// Whether their email went out is unknown, so they are not sent to again.
+func (s *CampaignService) failInterrupted(ctx context.Context, c *campaign.Campaign) error {
+	for {
+		recipients, err := s.repo.ListRecipients(ctx, &campaign.RecipientQuery{
+			CampaignID: c.ID.Hex(),
+			Statuses:   []string{campaign.RecipientSending},
+			Limit:      storage.MaxPageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list recipients: %w", err)
+		}
+		if len(recipients) == 0 {
+			return nil
+		}
+
+		for _, recipient := range recipients {
+			recipient.Status = campaign.RecipientFailed
+			recipient.Error = "sending was interrupted; the email may have been sent"
+			recipient.UpdatedAt = time.Now()
+			if err := s.replaceRecipient(ctx, recipient, campaign.RecipientSending); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// suppressed reports whether the recipient's address bounced or
+// unsubscribed in any campaign
+func (s *CampaignService) suppressed(ctx context.Context, recipient *campaign.Recipient) (bool, error) {
+	previous, err := s.repo.ListRecipients(ctx, &campaign.RecipientQuery{
+		Email:    recipient.Email,
+		Statuses: []string{campaign.RecipientBounced, campaign.RecipientUnsubscribed},
+		Limit:    1,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check suppressed recipients: %w", err)
+	}
+	return len(previous) > 0, nil
+}
+
+// replaceRecipient records the outcome of a recipient still in status. A
+// recipient changed meanwhile, such as one who unsubscribed, is kept as is.
+func (s *CampaignService) replaceRecipient(ctx context.Context, recipient *campaign.Recipient, status string) error {
+	err := s.repo.ReplaceRecipient(ctx, recipient, status)
+	if errors.Is(err, storage.ErrConflict) || errors.Is(err, storage.ErrNotFound) {
+		s.logger.Warn("campaign recipient changed while sending",
+			zap.String("campaign_id", recipient.CampaignID), zap.String("recipient", recipient.Email))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update recipient: %w", err)
+	}
+	return nil
+}
+
+func (s *CampaignService) unsubscribeURL(recipient *campaign.Recipient) string {
+	if s.cfg.UnsubscribeURL == "" {
+		return ""
+	}
+	return strings.TrimRight(s.cfg.UnsubscribeURL, "/") + "/" + url.PathEscape(recipient.Token)
+}
+
+// enqueue schedules a batch of a campaign. A batch already enqueued, by a
+// retried job or a concurrent start, is not enqueued again.
+func (s *CampaignService) enqueue(ctx context.Context, batch jobs.CampaignPayload, runAt time.Time) error {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	err = s.jobs.Enqueue(ctx, &jobs.Job{
+		ID:          jobs.CampaignJobID(batch),
+		Type:        jobs.TaskSendCampaign,
+		Payload:     payload,
+		MaxAttempts: campaignJobAttempts,
+		RunAt:       runAt,
+	})
+	if err != nil && !errors.Is(err, storage.ErrDuplicate) {
+		return fmt.Errorf("failed to enqueue campaign batch: %w", err)
+	}
+	return nil
+}
+
+// transition moves a campaign to status from one of the from statuses
+func (s *CampaignService) transition(ctx context.Context, userID, id, status string, from ...string) (*campaign.Campaign, error) {
+	c, err := s.campaign(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := false
+	for _, f := range from {
+		allowed = allowed || c.Status == f
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: %s", ErrCampaignStatus, c.Status)
+	}
+
+	c.Status = status
+	c.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, c); err != nil {
+		return nil, fmt.Errorf("failed to update campaign: %w", err)
+	}
+	return c, nil
+}
+
+// campaign returns a campaign userID created, or any campaign for admins
+func (s *CampaignService) campaign(ctx context.Context, userID, id string) (*campaign.Campaign, error) {
+	c, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrCampaignNotFound
+		}
+		return nil, fmt.Errorf("failed to get campaign: %w", err)
+	}
+	if c.CreatedBy == userID {
+		return c, nil
+	}
+
+	admin, err := s.isAdmin(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return nil, ErrCampaignAccessDenied
+	}
+	return c, nil
+}
+
+func (s *CampaignService) isAdmin(ctx context.Context, userID string) (bool, error) {
+	member, err := s.staff.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, ErrCampaignAccessDenied
+		}
+		return false, fmt.Errorf("failed to get staff: %w", err)
+	}
+	return member.Role == staff.RoleAdmin, nil
+}
+
+func (s *CampaignService) recipientBy(ctx context.Context, query *campaign.RecipientQuery) (*campaign.Recipient, error) {
+	recipients, err := s.repo.ListRecipients(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recipient: %w", err)
+	}
+	if len(recipients) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return recipients[0], nil
+}
+
+func (s *CampaignService) rate(rate int) (int, error) {
+	switch {
+	case rate == 0:
+		return s.cfg.DefaultRate, nil
+	case rate < 0 || rate > s.cfg.MaxRate:
+		return 0, fmt.Errorf("%w: rate must be between 1 and %d emails per minute", ErrInvalidCampaign, s.cfg.MaxRate)
+	}
+	return rate, nil
+}
+
+// batchSize spreads a rate of emails per minute over campaignBatches
+// batches, returning the size of a batch and the interval between batches
+func batchSize(rate int) (int, time.Duration) {
+	size := (rate + campaignBatches - 1) / campaignBatches
+	if size > storage.MaxPageSize {
+		size = storage.MaxPageSize
+	}
+	return size, time.Minute * time.Duration(size) / time.Duration(rate)
+}
+
+// permanentSendError reports whether sending failed for a reason retrying
+// cannot fix, such as an invalid address or a missing template variable
+func permanentSendError(err error) bool {
+	return errors.Is(err, ErrInvalidEmail) || errors.Is(err, ErrInvalidTemplate) ||
+		errors.Is(err, ErrTemplateNotFound) || errors.Is(err, ErrTemplateAccessDenied)
+}
+
+func total(counts map[string]int) int {
+	n := 0
+	for _, count := range counts {
+		n += count
+	}
+	return n
+}
+
+// parseRecipientCSV reads recipients from CSV with a header row, up to max
+// of them
+func parseRecipientCSV(r io.Reader, max int) ([]RecipientInput, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: the CSV file is empty", ErrInvalidCampaign)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCampaign, err)
+	}
+
+	emailColumn, nameColumn := -1, -1
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		header[i] = column
+		switch strings.ToLower(column) {
+		case "email":
+			emailColumn = i
+		case "name":
+			nameColumn = i
+		}
+	}
+	if emailColumn < 0 {
+		return nil, fmt.Errorf("%w: the CSV file has no email column", ErrInvalidCampaign)
+	}
+
+	var inputs []RecipientInput
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCampaign, err)
+		}
+		if len(inputs) == max {
+			return nil, fmt.Errorf("%w: a campaign has at most %d", ErrTooManyRecipients, max)
+		}
+
+		input := RecipientInput{Email: record[emailColumn], Variables: make(map[string]string)}
+		for i, value := range record {
+			switch i {
+			case emailColumn:
+			case nameColumn:
+				input.Name = value
+			default:
+				if header[i] != "" {
+					input.Variables[header[i]] = value
+				}
+			}
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
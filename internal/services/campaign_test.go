@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+	"github.com/bezata/blockchainml-email/internal/jobs"
+)
+
+// startCampaign creates and starts a campaign to recipients, returning it
+// with the ID of its sender
+func startCampaign(t *testing.T, env *testEnv, recipients ...string) (*campaign.Campaign, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	sender := env.addStaff(t, "news@example.com").ID.Hex()
+	tmpl, err := env.Templates.CreateTemplate(ctx, sender, TemplateParams{
+		Kind:    template.KindMessage,
+		Name:    "Newsletter",
+		Subject: "News",
+		Text:    "Hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := env.Campaigns.CreateCampaign(ctx, sender, CampaignParams{Name: "October", TemplateID: tmpl.ID.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := make([]RecipientInput, len(recipients))
+	for i, address := range recipients {
+		inputs[i] = RecipientInput{Email: address}
+	}
+	if _, err := env.Campaigns.AddRecipients(ctx, sender, c.ID.Hex(), inputs); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = env.Campaigns.StartCampaign(ctx, sender, c.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	return c, sender
+}
+
+func campaignJobs(t *testing.T, env *testEnv) []*jobs.Job {
+	t.Helper()
+
+	list, err := env.repos.Jobs.List(context.Background(), &jobs.ListQuery{Type: jobs.TaskSendCampaign})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func sentCount(t *testing.T, env *testEnv, c *campaign.Campaign) int {
+	t.Helper()
+
+	sent, err := env.repos.Email.List(context.Background(), &email.ListQuery{Labels: []string{LabelSent}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, e := range sent {
+		if e.Metadata.CampaignID == c.ID.Hex() {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCampaignRestartKeepsOneChain(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	c, sender := startCampaign(t, env, "a@example.org", "b@example.org")
+
+	// Pausing and starting again before the first batch ran schedules a
+	// batch of the second run
+	if _, err := env.Campaigns.PauseCampaign(ctx, sender, c.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Campaigns.StartCampaign(ctx, sender, c.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	scheduled := campaignJobs(t, env)
+	if len(scheduled) != 2 {
+		t.Fatalf("%d batches scheduled, want 2", len(scheduled))
+	}
+
+	for _, job := range scheduled {
+		if err := env.Campaigns.SendBatch(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The batch of the first run stopped; the second sent and scheduled
+	// its next batch
+	if n := sentCount(t, env, c); n != 2 {
+		t.Fatalf("sent %d emails, want 2", n)
+	}
+	after := campaignJobs(t, env)
+	if len(after) != 3 {
+		t.Fatalf("%d batches scheduled, want 3", len(after))
+	}
+	next := jobs.CampaignJobID(jobs.CampaignPayload{CampaignID: c.ID.Hex(), Run: 2, Batch: 1})
+	if _, err := env.repos.Jobs.Get(ctx, next); err != nil {
+		t.Fatalf("next batch %s: %v", next, err)
+	}
+
+	// A batch run again, as after a lost lease, schedules nothing more
+	for _, job := range scheduled {
+		if err := env.Campaigns.SendBatch(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(campaignJobs(t, env)); n != 3 {
+		t.Fatalf("%d batches scheduled after a rerun, want 3", n)
+	}
+	if n := sentCount(t, env, c); n != 2 {
+		t.Fatalf("sent %d emails after a rerun, want 2", n)
+	}
+}
+
+func TestCampaignSendClaimsRecipient(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	c, _ := startCampaign(t, env, "a@example.org")
+
+	recipients, err := env.repos.Campaigns.ListRecipients(ctx, &campaign.RecipientQuery{CampaignID: c.ID.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A batch listed the recipient as pending, but another one claimed
+	// them meanwhile
+	stale := *recipients[0]
+	claimed := *recipients[0]
+	claimed.Status = campaign.RecipientSending
+	if err := env.repos.Campaigns.UpdateRecipient(ctx, &claimed); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.Campaigns.send(ctx, c, &stale); err != nil {
+		t.Fatal(err)
+	}
+	if n := sentCount(t, env, c); n != 0 {
+		t.Fatalf("sent %d emails to a claimed recipient", n)
+	}
+
+	// Finishing the campaign fails recipients whose batch never recorded
+	// an outcome, without sending to them
+	job := campaignJobs(t, env)[0]
+	if err := env.Campaigns.SendBatch(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	got, err := env.repos.Campaigns.ListRecipients(ctx, &campaign.RecipientQuery{CampaignID: c.ID.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Status != campaign.RecipientFailed {
+		t.Fatalf("status = %s, want %s", got[0].Status, campaign.RecipientFailed)
+	}
+	if stored, err := env.repos.Campaigns.Get(ctx, c.ID.Hex()); err != nil || stored.Status != campaign.StatusCompleted {
+		t.Fatalf("campaign = %+v, %v, want it completed", stored, err)
+	}
+	if n := sentCount(t, env, c); n != 0 {
+		t.Fatalf("sent %d emails to an interrupted recipient", n)
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// cloudflareTolerance is how far the timestamp of a forwarded message may
+// be from now, which bounds replays
+const cloudflareTolerance = 5 * time.Minute
+
+var (
+	ErrInboundDisabled  = errors.New("inbound mail from Cloudflare is not configured")
+	ErrInvalidEnvelope  = errors.New("invalid Cloudflare envelope")
+	ErrEnvelopeReplayed = errors.New("envelope was already accepted")
+)
+
+// CloudflareDecrypter opens data sealed by Cloudflare Email Workers with
+// the shared encryption key, such as security.Service does
+type CloudflareDecrypter interface {
+	DecryptFromCloudflare(data string) ([]byte, error)
+}
+
+// CloudflareEnvelope is what an Email Worker seals for every message it
+// forwards: the SMTP envelope of the message and its raw content
+type CloudflareEnvelope struct {
+	// From is the envelope sender, empty or "<>" for bounces
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	ClientIP string   `json:"clientIp"`
+	// Raw is the base64 of the RFC 822 message
+	Raw string `json:"raw"`
+	// Timestamp is when the Worker sealed the envelope, in Unix seconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// EnvelopeTracker remembers the Cloudflare envelopes accepted, so that an
+// envelope replayed while its timestamp is in range is refused
+type EnvelopeTracker interface {
+	// Accept reports whether key was not accepted within period and, if
+	// so, records it for period
+	Accept(ctx context.Context, key string, period time.Duration) (bool, error)
+	// Forget drops key, so that the envelope may be sent again
+	Forget(ctx context.Context, key string) error
+}
+
+// RedisEnvelopeTracker tracks envelopes in Redis, shared by all replicas
+type RedisEnvelopeTracker struct {
+	client *redis.Client
+}
+
+func NewRedisEnvelopeTracker(client *redis.Client) *RedisEnvelopeTracker {
+	return &RedisEnvelopeTracker{client: client}
+}
+
+func (t *RedisEnvelopeTracker) Accept(ctx context.Context, key string, period time.Duration) (bool, error) {
+	ok, err := t.client.SetNX(ctx, "cloudflare-envelope:"+key, time.Now().Unix(), period).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record envelope: %w", err)
+	}
+	return ok, nil
+}
+
+func (t *RedisEnvelopeTracker) Forget(ctx context.Context, key string) error {
+	if err := t.client.Del(ctx, "cloudflare-envelope:"+key).Err(); err != nil {
+		return fmt.Errorf("failed to forget envelope: %w", err)
+	}
+	return nil
+}
+
+// MemoryEnvelopeTracker tracks envelopes in process memory, which
+// replicas don't share
+type MemoryEnvelopeTracker struct {
+	accepted *MemoryReplyTracker
+}
+
+func NewMemoryEnvelopeTracker() *MemoryEnvelopeTracker {
+	return &MemoryEnvelopeTracker{accepted: NewMemoryReplyTracker()}
+}
+
+func (t *MemoryEnvelopeTracker) Accept(ctx context.Context, key string, period time.Duration) (bool, error) {
+	return t.accepted.Allow(ctx, key, period)
+}
+
+func (t *MemoryEnvelopeTracker) Forget(_ context.Context, key string) error {
+	t.accepted.mu.Lock()
+	defer t.accepted.mu.Unlock()
+	delete(t.accepted.expires, key)
+	return nil
+}
+
+// ReceiveFromCloudflare delivers a message forwarded by an Email Worker
+// as an envelope sealed with the shared key, through the same pipeline as
+// mail received over SMTP. Envelopes that fail to open, were sealed too
+// long ago or were accepted already are refused. An envelope whose
+// delivery failed or was deferred for any recipient is not taken as
+// accepted, so the Worker may send it again.
+func (s *EmailService) ReceiveFromCloudflare(ctx context.Context, sealed string) (*InboundResult, error) {
+	if s.cloudflare == nil {
+		return nil, ErrInboundDisabled
+	}
+
+	data, err := s.cloudflare.DecryptFromCloudflare(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+	var envelope CloudflareEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+	if age := time.Since(time.Unix(envelope.Timestamp, 0)); age > cloudflareTolerance || age < -cloudflareTolerance {
+		return nil, fmt.Errorf("%w: timestamp out of range", ErrInvalidEnvelope)
+	}
+	if len(envelope.To) == 0 {
+		return nil, fmt.Errorf("%w: no recipients", ErrInvalidEnvelope)
+	}
+	raw, err := base64.StdEncoding.DecodeString(envelope.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+
+	msg, err := ParseMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	msg.MailFrom = strings.Trim(strings.TrimSpace(envelope.From), "<>")
+	msg.Recipients = envelope.To
+	msg.ClientIP = envelope.ClientIP
+
+	// Timestamps are accepted on either side of now, so an envelope stays
+	// in range for twice the tolerance
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	if s.envelopes != nil {
+		accepted, err := s.envelopes.Accept(ctx, key, 2*cloudflareTolerance)
+		if err != nil {
+			return nil, err
+		}
+		if !accepted {
+			s.metrics.EmailRequests.WithLabelValues("receive_cloudflare", "replayed").Inc()
+			return nil, ErrEnvelopeReplayed
+		}
+	}
+
+	result, err := s.Receive(ctx, msg)
+	if s.envelopes != nil && (err != nil || len(result.Deferred) > 0) {
+		if err := s.envelopes.Forget(ctx, key); err != nil {
+			s.logger.Warn("failed to forget deferred envelope", zap.Error(err))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("receive_cloudflare", "success").Inc()
+	return result, nil
+}
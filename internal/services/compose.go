@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+)
+
+// maxHeaderLine is the length header lines are folded at (RFC 5322)
+const maxHeaderLine = 78
+
+// entity is a MIME entity: its content headers and a writer of its body
+type entity struct {
+	header textproto.MIMEHeader
+	body   func(w io.Writer) error
+}
+
+// composeMessage renders e as an RFC 5322 message for the transports, with
+// its attachments except quarantined ones. Bcc recipients are left out.
+func (s *EmailService) composeMessage(ctx context.Context, e *email.Email) ([]byte, error) {
+	var buf bytes.Buffer
+	h := &headerWriter{w: &buf}
+
+	h.add("From", formatAddress(e.From))
+	h.addList("To", e.To)
+	h.addList("Cc", e.CC)
+	h.addList("Reply-To", e.ReplyTo)
+	h.add("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	h.add("Date", e.CreatedAt.Format(time.RFC1123Z))
+	h.add("Message-ID", e.MessageID)
+	if e.InReplyTo != "" {
+		h.add("In-Reply-To", e.InReplyTo)
+	}
+	if len(e.References) > 0 {
+		h.add("References", strings.Join(e.References, " "))
+	}
+	if e.Metadata.AutoSubmitted != "" {
+		h.add("Auto-Submitted", e.Metadata.AutoSubmitted)
+	}
+	for _, loop := range e.Metadata.Loop {
+		h.add("X-Loop", loop)
+	}
+	if e.Metadata.ListUnsubscribe != "" {
+		h.add("List-Unsubscribe", "<"+e.Metadata.ListUnsubscribe+">")
+		h.add("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	h.add("MIME-Version", "1.0")
+
+	body := contentEntity(e.Content)
+	var parts []entity
+	for i := range e.Attachments {
+		if !e.Attachments[i].Quarantined() {
+			parts = append(parts, s.attachmentEntity(ctx, &e.Attachments[i]))
+		}
+	}
+	if len(parts) > 0 {
+		body = multipartEntity("mixed", append([]entity{body}, parts...))
+	}
+
+	keys := make([]string, 0, len(body.header))
+	for key := range body.header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		h.add(key, body.header.Get(key))
+	}
+	buf.WriteString("\r\n")
+
+	if err := body.body(&buf); err != nil {
+		return nil, fmt.Errorf("failed to compose message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// contentEntity is the text and HTML body, as alternatives when the email
+// has both
+func contentEntity(content email.EmailContent) entity {
+	switch {
+	case content.HTML == "":
+		return textEntity("text/plain", content.Text)
+	case content.Text == "":
+		return textEntity("text/html", content.HTML)
+	}
+	return multipartEntity("alternative", []entity{
+		textEntity("text/plain", content.Text),
+		textEntity("text/html", content.HTML),
+	})
+}
+
+func textEntity(mediaType, text string) entity {
+	return entity{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {mediaType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: func(w io.Writer) error {
+			qp := quotedprintable.NewWriter(w)
+			if _, err := io.WriteString(qp, text); err != nil {
+				return err
+			}
+			return qp.Close()
+		},
+	}
+}
+
+func multipartEntity(subtype string, parts []entity) entity {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	return entity{
+		header: textproto.MIMEHeader{
+			"Content-Type": {mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": boundary})},
+		},
+		body: func(w io.Writer) error {
+			mw := multipart.NewWriter(w)
+			if err := mw.SetBoundary(boundary); err != nil {
+				return err
+			}
+			for _, part := range parts {
+				pw, err := mw.CreatePart(part.header)
+				if err != nil {
+					return err
+				}
+				if err := part.body(pw); err != nil {
+					return err
+				}
+			}
+			return mw.Close()
+		},
+	}
+}
+
+// attachmentEntity streams an attachment from storage in base64
+func (s *EmailService) attachmentEntity(ctx context.Context, attachment *email.Attachment) entity {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return entity{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		},
+		body: func(w io.Writer) error {
+			content, err := s.attachments.Open(ctx, attachment)
+			if err != nil {
+				return fmt.Errorf("failed to open attachment %s: %w", attachment.Filename, err)
+			}
+			defer content.Close()
+
+			lines := &lineWriter{w: w}
+			encoder := base64.NewEncoder(base64.StdEncoding, lines)
+			if _, err := io.Copy(encoder, content); err != nil {
+				return fmt.Errorf("failed to read attachment %s: %w", attachment.Filename, err)
+			}
+			if err := encoder.Close(); err != nil {
+				return err
+			}
+			return lines.end()
+		},
+	}
+}
+
+func formatAddress(p email.Participant) string {
+	return (&mail.Address{Name: p.FullName, Address: p.Email}).String()
+}
+
+// headerWriter writes header fields, folding long ones. Line breaks in
+// values are dropped so that they cannot inject fields.
+type headerWriter struct {
+	w *bytes.Buffer
+}
+
+func (h *headerWriter) add(name, value string) {
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	if len(name)+2+len(value) <= maxHeaderLine {
+		h.w.WriteString(name + ": " + value + "\r\n")
+		return
+	}
+
+	line := name + ":"
+	for _, word := range strings.Fields(value) {
+		if len(line)+1+len(word) > maxHeaderLine && line != name+":" {
+			h.w.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	h.w.WriteString(line + "\r\n")
+}
+
+func (h *headerWriter) addList(name string, participants []email.Participant) {
+	if len(participants) == 0 {
+		return
+	}
+	addresses := make([]string, len(participants))
+	for i, p := range participants {
+		addresses[i] = formatAddress(p)
+	}
+	h.add(name, strings.Join(addresses, ", "))
+}
+
+// lineWriter breaks base64 output into lines of 76 characters (RFC 2045)
+type lineWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := 76 - l.n
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		if _, err := l.w.Write(p[:chunk]); err != nil {
+			return written, err
+		}
+		written += chunk
+		l.n += chunk
+		p = p[chunk:]
+		if l.n == 76 {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.n = 0
+		}
+	}
+	return written, nil
+}
+
+// end finishes the last line
+func (l *lineWriter) end() error {
+	if l.n == 0 {
+		return nil
+	}
+	_, err := io.WriteString(l.w, "\r\n")
+	return err
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/transport"
+	"github.com/bezata/blockchainml-email/pkg/realtime"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// maxDeliveryAttempts bounds the attempt history kept per recipient; the
+// oldest attempts are dropped first
+const maxDeliveryAttempts = 50
+
+// deliveryWriteAttempts bounds how often a delivery state change is
+// reapplied after losing the race to another writer
+const deliveryWriteAttempts = 5
+
+var ErrUnknownTransport = errors.New("unknown transport")
+
+// DeliveryUpdate is the realtime event sent when the delivery state of a
+// recipient changes
+type DeliveryUpdate struct {
+	EmailID  string                  `json:"emailId"`
+	Delivery email.RecipientDelivery `json:"delivery"`
+}
+
+// GetDelivery returns the delivery state of every recipient of a sent
+// email. Only the sender's mailbox sees it, as it lists Bcc recipients.
+func (s *EmailService) GetDelivery(ctx context.Context, userID, emailID string) ([]email.RecipientDelivery, error) {
+	e, err := s.repo.Get(ctx, emailID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrEmailNotFound
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	member, err := s.staff.GetByEmail(ctx, e.From.Email)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrEmailAccessDenied
+		}
+		return nil, fmt.Errorf("failed to get mailbox owner: %w", err)
+	}
+	if !member.HasMailboxAccess(userID) {
+		return nil, ErrEmailAccessDenied
+	}
+	if !hasLabel(e.Labels, LabelSent) {
+		return nil, fmt.Errorf("%w: email was not sent", ErrInvalidEmail)
+	}
+
+	if e.Delivery == nil {
+		return []email.RecipientDelivery{}, nil
+	}
+	return e.Delivery, nil
+}
+
+// RecordDelivery adds an attempt to deliver a sent email to one of its
+// recipients and moves the recipient to the state it resulted in. The
+// sender's sessions are notified of the change.
+func (s *EmailService) RecordDelivery(ctx context.Context, emailID, recipient string, attempt email.DeliveryAttempt) error {
+	e, err := s.repo.Get(ctx, emailID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrEmailNotFound
+		}
+		return fmt.Errorf("failed to get email: %w", err)
+	}
+	if !containsAddress(deliveryRecipients(e), recipient) {
+		return fmt.Errorf("%w: %s is not a recipient", ErrInvalidEmail, recipient)
+	}
+
+	e, changes, err := s.updateDelivery(ctx, e, func(e *email.Email, now time.Time) []email.RecipientDelivery {
+		return []email.RecipientDelivery{addAttempt(e, strings.ToLower(recipient), attempt, now)}
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrEmailNotFound
+		}
+		return err
+	}
+
+	s.notifyDelivery(ctx, e, changes)
+	s.metrics.EmailRequests.WithLabelValues("delivery_"+attempt.Status, "success").Inc()
+	return nil
+}
+
+// ReceiveDeliveryEvents records the delivery events a provider posted to
+// the webhook of the transport named transportName, once their signature
+// is verified, and returns how many were recorded. Events about unknown
+// emails or recipients are skipped, as are deferrals arriving after a
+// recipient was delivered or bounced.
+func (s *EmailService) ReceiveDeliveryEvents(ctx context.Context, transportName string, header http.Header, body []byte) (int, error) {
+	var source transport.EventSource
+	if s.transports != nil {
+		source, _ = s.transports.Transport(transportName).(transport.EventSource)
+	}
+	if source == nil {
+		return 0, ErrUnknownTransport
+	}
+
+	events, err := source.ParseEvents(header, body, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	var order []string
+	byEmail := make(map[string][]transport.Event)
+	for _, event := range events {
+		if _, ok := byEmail[event.ID]; !ok {
+			order = append(order, event.ID)
+		}
+		byEmail[event.ID] = append(byEmail[event.ID], event)
+	}
+
+	recorded := 0
+	for _, emailID := range order {
+		n, err := s.applyEvents(ctx, emailID, byEmail[emailID])
+		if err != nil {
+			return recorded, err
+		}
+		recorded += n
+	}
+	return recorded, nil
+}
+
+// applyEvents records the provider events about one email
+func (s *EmailService) applyEvents(ctx context.Context, emailID string, events []transport.Event) (int, error) {
+	if !primitive.IsValidObjectID(emailID) {
+		return 0, nil
+	}
+	e, err := s.repo.Get(ctx, emailID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	var bounced []transport.Event
+	e, changes, err := s.updateDelivery(ctx, e, func(e *email.Email, now time.Time) []email.RecipientDelivery {
+		bounced = nil
+		var changes []email.RecipientDelivery
+		for _, event := range events {
+			recipient := strings.ToLower(event.Recipient)
+			state := eventState(event.Type)
+			if state == "" || !containsAddress(deliveryRecipients(e), recipient) {
+				continue
+			}
+			if state == email.DeliveryDeferred && finalDelivery(e, recipient) {
+				continue
+			}
+
+			changes = append(changes, addAttempt(e, recipient, email.DeliveryAttempt{
+				Status:    state,
+				Reply:     event.Reply,
+				Code:      event.Code,
+				Response:  event.Response,
+				RemoteMX:  event.Server,
+				StartedAt: event.Timestamp,
+				EndedAt:   event.Timestamp,
+			}, now))
+			if state == email.DeliveryBounced {
+				bounced = append(bounced, event)
+			}
+		}
+		return changes
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	for _, delivery := range changes {
+		s.metrics.EmailRequests.WithLabelValues("delivery_event_"+delivery.Status, "success").Inc()
+	}
+	for _, event := range bounced {
+		s.hardBounce(ctx, e, strings.ToLower(event.Recipient), event.Code, event.Response)
+	}
+	s.notifyDelivery(ctx, e, changes)
+	return len(changes), nil
+}
+
+// eventState maps a provider event type to the delivery state it reports
+func eventState(eventType string) string {
+	switch eventType {
+	case transport.EventDelivered:
+		return email.DeliveryDelivered
+	case transport.EventDeferred:
+		return email.DeliveryDeferred
+	case transport.EventBounced:
+		return email.DeliveryBounced
+	}
+	return ""
+}
+
+// finalDelivery reports whether recipient of e was delivered or bounced
+func finalDelivery(e *email.Email, recipient string) bool {
+	for _, delivery := range e.Delivery {
+		if delivery.Recipient == recipient {
+			return delivery.Status == email.DeliveryDelivered || delivery.Status == email.DeliveryBounced
+		}
+	}
+	return false
+}
+
+// queueDelivery starts the delivery state of a new outgoing email: every
+// recipient is queued
+func queueDelivery(e *email.Email, now time.Time) {
+	recipients := deliveryRecipients(e)
+	e.Delivery = make([]email.RecipientDelivery, len(recipients))
+	for i, recipient := range recipients {
+		e.Delivery[i] = email.RecipientDelivery{
+			Recipient: strings.ToLower(recipient),
+			Status:    email.DeliveryQueued,
+			UpdatedAt: now,
+		}
+	}
+}
+
+// deliveryRecipients returns the addresses e is delivered to
+func deliveryRecipients(e *email.Email) []string {
+	recipients := make([]string, 0, len(e.To)+len(e.CC)+len(e.BCC))
+	for _, list := range [][]email.Participant{e.To, e.CC, e.BCC} {
+		for _, p := range list {
+			recipients = append(recipients, p.Email)
+		}
+	}
+	return recipients
+}
+
+// addAttempt appends attempt to the history of recipient and takes its
+// outcome as the recipient's state, returning the updated state
+func addAttempt(e *email.Email, recipient string, attempt email.DeliveryAttempt, now time.Time) email.RecipientDelivery {
+	if attempt.EndedAt.IsZero() {
+		attempt.EndedAt = now
+	}
+	if attempt.StartedAt.IsZero() {
+		attempt.StartedAt = attempt.EndedAt
+	}
+
+	i := 0
+	for i < len(e.Delivery) && e.Delivery[i].Recipient != recipient {
+		i++
+	}
+	if i == len(e.Delivery) {
+		e.Delivery = append(e.Delivery, email.RecipientDelivery{Recipient: recipient})
+	}
+
+	delivery := &e.Delivery[i]
+	delivery.Status = attempt.Status
+	delivery.Code = attempt.Code
+	delivery.Diagnostic = attempt.Response
+	delivery.RemoteMTA = attempt.RemoteMX
+	delivery.UpdatedAt = now
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if excess := len(delivery.Attempts) - maxDeliveryAttempts; excess > 0 {
+		delivery.Attempts = append([]email.DeliveryAttempt(nil), delivery.Attempts[excess:]...)
+	}
+	return *delivery
+}
+
+// updateDelivery applies change to the delivery state of e and stores it
+// conditionally on the version it was applied to. When another writer
+// stored a newer state meanwhile, the email is read again and change is
+// reapplied to it, so change must not have side effects. It returns the
+// stored email and the states change returned; nothing is stored when
+// there are none.
+func (s *EmailService) updateDelivery(ctx context.Context, e *email.Email, change func(e *email.Email, now time.Time) []email.RecipientDelivery) (*email.Email, []email.RecipientDelivery, error) {
+	for attempt := 1; ; attempt++ {
+		now := time.Now()
+		changes := change(e, now)
+		if len(changes) == 0 {
+			return e, nil, nil
+		}
+
+		version := e.DeliveryVersion
+		e.DeliveryVersion++
+		e.UpdatedAt = now
+		err := s.repo.ReplaceDelivery(ctx, e, version)
+		if err == nil {
+			return e, changes, nil
+		}
+		if !errors.Is(err, storage.ErrConflict) || attempt == deliveryWriteAttempts {
+			return nil, nil, fmt.Errorf("failed to update delivery state: %w", err)
+		}
+
+		if e, err = s.repo.Get(ctx, e.ID.Hex()); err != nil {
+			return nil, nil, fmt.Errorf("failed to get email: %w", err)
+		}
+	}
+}
+
+// notifyDelivery pushes delivery state changes to the sessions of the
+// sender's mailbox owner and delegates. Failures are logged; the state is
+// stored already.
+func (s *EmailService) notifyDelivery(ctx context.Context, e *email.Email, changes []email.RecipientDelivery) {
+	if s.notifier == nil || len(changes) == 0 {
+		return
+	}
+
+	member, err := s.staff.GetByEmail(ctx, e.From.Email)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			s.logger.Error("failed to get sender for delivery notification", zap.String("email_id", e.ID.Hex()), zap.Error(err))
+		}
+		return
+	}
+
+	users := append([]string{member.ID.Hex()}, member.Mailbox.Delegates...)
+	for _, delivery := range changes {
+		event := realtime.Event{
+			Type:      realtime.EventDelivery,
+			Data:      DeliveryUpdate{EmailID: e.ID.Hex(), Delivery: delivery},
+			Timestamp: delivery.UpdatedAt,
+		}
+		for _, userID := range users {
+			if err := s.notifier.Notify(ctx, userID, event); err != nil {
+				s.logger.Warn("failed to push delivery state", zap.String("email_id", e.ID.Hex()), zap.Error(err))
+			}
+		}
+	}
+}
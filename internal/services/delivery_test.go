@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/storage"
+)
+
+// racingEmails runs race once, right after the first email is read, as
+// if another writer changed the email meanwhile
+type racingEmails struct {
+	storage.EmailRepository
+	race func()
+}
+
+func (r *racingEmails) Get(ctx context.Context, id string) (*email.Email, error) {
+	e, err := r.EmailRepository.Get(ctx, id)
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+	return e, err
+}
+
+func TestRecordDeliveryKeepsConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	e := env.addEmail(t, alice, "alice@example.com", "bob@example.org", "carol@example.org")
+
+	env.Email.repo = &racingEmails{
+		EmailRepository: env.repos.Email,
+		race: func() {
+			err := env.Email.RecordDelivery(ctx, e.ID.Hex(), "carol@example.org", email.DeliveryAttempt{Status: email.DeliveryDelivered})
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	err := env.Email.RecordDelivery(ctx, e.ID.Hex(), "bob@example.org", email.DeliveryAttempt{Status: email.DeliveryBounced, Code: "5.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := env.repos.Email.Get(ctx, e.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, delivery := range stored.Delivery {
+		if len(delivery.Attempts) != 1 {
+			t.Fatalf("%s has attempts %+v, want one", delivery.Recipient, delivery.Attempts)
+		}
+		got[delivery.Recipient] = delivery.Status
+	}
+	if len(got) != 2 || got["bob@example.org"] != email.DeliveryBounced || got["carol@example.org"] != email.DeliveryDelivered {
+		t.Fatalf("delivery = %v, want bob bounced and carol delivered", got)
+	}
+	if stored.DeliveryVersion != 2 {
+		t.Fatalf("delivery version = %d, want 2", stored.DeliveryVersion)
+	}
+
+	// Other changes to the email keep the delivery state
+	stale := *e
+	stale.Flags.IsRead = true
+	if err := env.repos.Email.Update(ctx, &stale); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err = env.repos.Email.Get(ctx, e.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Delivery) != 2 {
+		t.Fatalf("Update left delivery %+v", stored.Delivery)
+	}
+}
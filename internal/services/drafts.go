@@ -0,0 +1,423 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LabelDrafts files unsent emails
+const LabelDrafts = label.Drafts
+
+// maxDraftRevisions is the number of previous versions kept per draft
+const maxDraftRevisions = 10
+
+var (
+	ErrDraftNotFound = errors.New("draft not found")
+	// ErrDraftConflict is returned when a draft was saved elsewhere since
+	// the version the change is based on
+	ErrDraftConflict = errors.New("draft was changed since it was loaded")
+)
+
+// DraftParams is the editable content of a draft. Recipients may be
+// incomplete while editing and are only validated when the draft is sent.
+type DraftParams struct {
+	To       []string
+	CC       []string
+	BCC      []string
+	Subject  string
+	Content  email.EmailContent
+	ThreadID *string
+}
+
+// AnyVersion skips the version check of draft changes that cannot clobber
+// edits, such as attaching a file
+const AnyVersion int64 = -1
+
+// CreateDraft stores a new draft in userID's mailbox, at version 1, with
+// any staged uploads attached
+func (s *EmailService) CreateDraft(ctx context.Context, userID string, params DraftParams, uploads []string) (*email.Email, error) {
+	sender, err := s.staff.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: unknown sender", ErrInvalidEmail)
+		}
+		return nil, fmt.Errorf("failed to get sender: %w", err)
+	}
+
+	now := time.Now()
+	e := &email.Email{
+		ID:        primitive.NewObjectID(),
+		MessageID: fmt.Sprintf("<%s@%s>", uuid.New().String(), senderDomain(sender.Email)),
+		From: email.Participant{
+			Email:    sender.Email,
+			FullName: sender.FullName,
+		},
+		Attachments: []email.Attachment{},
+		Mailbox:     sender.ID.Hex(),
+		Labels:      []string{LabelDrafts},
+		Flags:       email.EmailFlags{IsRead: true, IsDraft: true},
+		Draft:       &email.Draft{Version: 1},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	applyDraft(e, params)
+
+	if err := s.attachUploads(ctx, userID, e, uploads); err != nil {
+		s.removeAttachments(ctx, e)
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, e); err != nil {
+		s.removeAttachments(ctx, e)
+		return nil, fmt.Errorf("failed to create draft: %w", err)
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("draft_create", "success").Inc()
+	return e, nil
+}
+
+// GetDraft returns a draft of a mailbox userID has access to
+func (s *EmailService) GetDraft(ctx context.Context, userID, id string) (*email.Email, error) {
+	e, _, err := s.draft(ctx, userID, id)
+	return e, err
+}
+
+// ListDrafts returns one page of the drafts in userID's mailbox, most
+// recently created first
+func (s *EmailService) ListDrafts(ctx context.Context, userID, cursor string, limit int) ([]*email.Email, error) {
+	member, err := s.staff.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrEmailAccessDenied
+		}
+		return nil, fmt.Errorf("failed to get staff: %w", err)
+	}
+
+	isDraft := true
+	drafts, err := s.repo.List(ctx, &email.ListQuery{
+		From:    member.Email,
+		IsDraft: &isDraft,
+		Cursor:  cursor,
+		Limit:   limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list drafts: %w", err)
+	}
+	return drafts, nil
+}
+
+// UpdateDraft saves new content for a draft loaded at version. The
+// content it replaces is kept as a revision. A draft saved elsewhere in
+// the meantime is left alone and ErrDraftConflict returned, so the caller
+// can reload and merge rather than overwrite the other edits.
+func (s *EmailService) UpdateDraft(ctx context.Context, userID, id string, version int64, params DraftParams) (*email.Email, error) {
+	e, _, err := s.draft(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Draft.Version != version {
+		return nil, ErrDraftConflict
+	}
+
+	previous := addRevision(e)
+	applyDraft(e, params)
+	if err := s.replaceDraft(ctx, e, previous); err != nil {
+		return nil, err
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("draft_update", "success").Inc()
+	return e, nil
+}
+
+// AddDraftAttachments attaches staged uploads to a draft. Attachments
+// change the draft version, so it is checked unless version is AnyVersion.
+func (s *EmailService) AddDraftAttachments(ctx context.Context, userID, id string, version int64, uploads []string) (*email.Email, error) {
+	e, _, err := s.draft(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != AnyVersion && e.Draft.Version != version {
+		return nil, ErrDraftConflict
+	}
+
+	attached := len(e.Attachments)
+	previous := addRevision(e)
+	e.UpdatedAt = time.Now()
+	if err := s.attachUploads(ctx, userID, e, uploads); err != nil {
+		s.removeAttachments(ctx, &email.Email{ID: e.ID, Attachments: e.Attachments[attached:]})
+		return nil, err
+	}
+
+	if err := s.replaceDraft(ctx, e, previous); err != nil {
+		s.removeAttachments(ctx, &email.Email{ID: e.ID, Attachments: e.Attachments[attached:]})
+		return nil, err
+	}
+	return e, nil
+}
+
+// RemoveDraftAttachment detaches an attachment from a draft and deletes
+// it. The version is checked unless it is AnyVersion.
+func (s *EmailService) RemoveDraftAttachment(ctx context.Context, userID, id string, version int64, filename string) (*email.Email, error) {
+	e, _, err := s.draft(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != AnyVersion && e.Draft.Version != version {
+		return nil, ErrDraftConflict
+	}
+
+	var removed []email.Attachment
+	kept := make([]email.Attachment, 0, len(e.Attachments))
+	for _, attachment := range e.Attachments {
+		if attachment.Filename == filename {
+			removed = append(removed, attachment)
+			continue
+		}
+		kept = append(kept, attachment)
+	}
+	if len(removed) == 0 {
+		return nil, ErrAttachmentNotFound
+	}
+
+	previous := addRevision(e)
+	e.Attachments = kept
+	e.UpdatedAt = time.Now()
+	if err := s.replaceDraft(ctx, e, previous); err != nil {
+		return nil, err
+	}
+
+	s.removeAttachments(ctx, &email.Email{ID: e.ID, Attachments: removed})
+	return e, nil
+}
+
+// DeleteDraft deletes a draft and its attachments
+func (s *EmailService) DeleteDraft(ctx context.Context, userID, id string) error {
+	e, _, err := s.draft(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrDraftNotFound
+		}
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+
+	s.removeAttachments(ctx, e)
+	return nil
+}
+
+// SendDraft turns a draft into a sent email. The draft is replaced in a
+// single conditional write, so it is sent exactly once and never with
+// content older than what was last saved. The version is checked unless it
+// is AnyVersion.
+func (s *EmailService) SendDraft(ctx context.Context, userID, id string, version int64) (*email.Email, error) {
+	e, sender, err := s.draft(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != AnyVersion && e.Draft.Version != version {
+		return nil, ErrDraftConflict
+	}
+
+	if len(e.To) == 0 {
+		return nil, fmt.Errorf("%w: at least one recipient is required", ErrInvalidEmail)
+	}
+	var recipients []email.Participant
+	for _, list := range [][]email.Participant{e.To, e.CC, e.BCC} {
+		for i, p := range list {
+			address, err := mail.ParseAddress(p.Email)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid recipient %q", ErrInvalidEmail, p.Email)
+			}
+			list[i].Email = strings.ToLower(address.Address)
+		}
+		recipients = append(recipients, list...)
+	}
+	if err := s.checkSuppressed(ctx, recipients); err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, attachment := range e.Attachments {
+		total += attachment.Size
+	}
+	if total > s.attachments.MaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+
+	previous := e.Draft.Version
+	now := time.Now()
+	e.From = email.Participant{Email: sender.Email, FullName: sender.FullName}
+	e.Labels = withoutLabel(e.Labels, LabelDrafts)
+	e.Labels = withLabel(e.Labels, LabelSent)
+	e.Flags.IsDraft = false
+	e.Flags.IsRead = true
+	e.Draft = nil
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	queueDelivery(e, now)
+	quarantined := markQuarantined(e)
+
+	if err := s.replaceDraft(ctx, e, previous); err != nil {
+		return nil, err
+	}
+
+	if quarantined {
+		s.notifyQuarantine(ctx, e, userID)
+	}
+	s.enqueueDelivery(ctx, e)
+
+	s.metrics.EmailRequests.WithLabelValues("send", "success").Inc()
+	return e, nil
+}
+
+// draft loads a draft and checks that userID has access to the mailbox it
+// is drafted in. Drafts are private to the sender's mailbox until sent.
+func (s *EmailService) draft(ctx context.Context, userID, id string) (*email.Email, *staff.Staff, error) {
+	e, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, ErrDraftNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get draft: %w", err)
+	}
+	if e.Draft == nil {
+		return nil, nil, ErrDraftNotFound
+	}
+
+	sender, err := s.staff.GetByEmail(ctx, e.From.Email)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, ErrEmailAccessDenied
+		}
+		return nil, nil, fmt.Errorf("failed to get mailbox owner: %w", err)
+	}
+	if !sender.HasMailboxAccess(userID) {
+		return nil, nil, ErrEmailAccessDenied
+	}
+	return e, sender, nil
+}
+
+// replaceDraft stores e if the stored draft is still at version
+func (s *EmailService) replaceDraft(ctx context.Context, e *email.Email, version int64) error {
+	err := s.repo.ReplaceDraft(ctx, e, version)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, storage.ErrConflict):
+		s.metrics.EmailRequests.WithLabelValues("draft_update", "conflict").Inc()
+		return ErrDraftConflict
+	case errors.Is(err, storage.ErrNotFound):
+		return ErrDraftNotFound
+	}
+	return fmt.Errorf("failed to save draft: %w", err)
+}
+
+// attachUploads claims staged uploads of userID as attachments of e,
+// keeping the total within the attachment service's maximum size
+func (s *EmailService) attachUploads(ctx context.Context, userID string, e *email.Email, uploads []string) error {
+	names := make(map[string]bool, len(e.Attachments))
+	var total int64
+	for _, attachment := range e.Attachments {
+		names[attachment.Filename] = true
+		total += attachment.Size
+	}
+	for _, key := range uploads {
+		size, err := s.attachments.Size(ctx, userID, key)
+		if err != nil {
+			return err
+		}
+		total += size
+	}
+	if total > s.attachments.MaxSize() {
+		return ErrUploadTooLarge
+	}
+
+	for _, key := range uploads {
+		attachment, err := s.attachments.Claim(ctx, userID, key)
+		if err != nil {
+			return err
+		}
+		attachment.Filename = uniqueFilename(attachment.Filename, names)
+		e.Attachments = append(e.Attachments, *attachment)
+	}
+	markQuarantined(e)
+	return nil
+}
+
+// applyDraft sets the editable content of a draft
+func applyDraft(e *email.Email, params DraftParams) {
+	e.To = draftRecipients(params.To)
+	e.CC = draftRecipients(params.CC)
+	e.BCC = draftRecipients(params.BCC)
+	e.Subject = params.Subject
+	e.Content = params.Content
+	e.ThreadID = params.ThreadID
+	e.UpdatedAt = time.Now()
+}
+
+// draftRecipients parses the recipients of a draft. Addresses are kept as
+// typed when they don't parse yet.
+func draftRecipients(list []string) []email.Participant {
+	recipients := make([]email.Participant, 0, len(list))
+	for _, raw := range list {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if address, err := mail.ParseAddress(raw); err == nil {
+			recipients = append(recipients, email.Participant{Email: strings.ToLower(address.Address), FullName: address.Name})
+			continue
+		}
+		recipients = append(recipients, email.Participant{Email: raw})
+	}
+	return recipients
+}
+
+// draftRevisionInterval coalesces autosaves: content saved within it of
+// the last revision replaces it without adding a revision
+const draftRevisionInterval = time.Minute
+
+// addRevision records the current content of a draft as a revision and
+// bumps its version, returning the version replaced
+func addRevision(e *email.Email) int64 {
+	d := e.Draft
+	previous := d.Version
+	d.Version++
+
+	if n := len(d.Revisions); n > 0 && e.UpdatedAt.Sub(d.Revisions[n-1].SavedAt) < draftRevisionInterval {
+		return previous
+	}
+
+	filenames := make([]string, len(e.Attachments))
+	for i, attachment := range e.Attachments {
+		filenames[i] = attachment.Filename
+	}
+	d.Revisions = append(d.Revisions, email.DraftRevision{
+		Version:     previous,
+		To:          append([]email.Participant(nil), e.To...),
+		CC:          append([]email.Participant(nil), e.CC...),
+		BCC:         append([]email.Participant(nil), e.BCC...),
+		Subject:     e.Subject,
+		Content:     e.Content,
+		Attachments: filenames,
+		SavedAt:     e.UpdatedAt,
+	})
+	if len(d.Revisions) > maxDraftRevisions {
+		d.Revisions = append([]email.DraftRevision(nil), d.Revisions[len(d.Revisions)-maxDraftRevisions:]...)
+	}
+	return previous
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+)
+
+func TestSendDraftChecksEveryRecipient(t *testing.T) {
+	ctx := context.Background()
+
+	send := func(t *testing.T, env *testEnv, params DraftParams) (*email.Email, error) {
+		t.Helper()
+
+		sender := env.addStaff(t, "alice@example.com")
+		draft, err := env.Email.CreateDraft(ctx, sender.ID.Hex(), params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return env.Email.SendDraft(ctx, sender.ID.Hex(), draft.ID.Hex(), draft.Draft.Version)
+	}
+
+	t.Run("Normalized", func(t *testing.T) {
+		env := newTestEnv(t)
+		sent, err := send(t, env, DraftParams{
+			To:  []string{"Bob <Bob@Example.org>"},
+			CC:  []string{"Carol@Example.org"},
+			BCC: []string{"DAVE@example.org"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range [][]email.Participant{sent.To, sent.CC, sent.BCC} {
+			if len(p) != 1 {
+				t.Fatalf("recipients = %v", p)
+			}
+		}
+		if sent.To[0].Email != "bob@example.org" || sent.CC[0].Email != "carol@example.org" || sent.BCC[0].Email != "dave@example.org" {
+			t.Fatalf("to = %v, cc = %v, bcc = %v, want lower-cased addresses", sent.To, sent.CC, sent.BCC)
+		}
+	})
+
+	for _, tc := range []struct {
+		name   string
+		params DraftParams
+	}{
+		{"InvalidCC", DraftParams{To: []string{"bob@example.org"}, CC: []string{"not an address"}}},
+		{"InvalidBCC", DraftParams{To: []string{"bob@example.org"}, BCC: []string{"not an address"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := send(t, newTestEnv(t), tc.params); !errors.Is(err, ErrInvalidEmail) {
+				t.Fatalf("err = %v, want ErrInvalidEmail", err)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name   string
+		params DraftParams
+	}{
+		{"SuppressedCC", DraftParams{To: []string{"bob@example.org"}, CC: []string{"Bounced@Example.org"}}},
+		{"SuppressedBCC", DraftParams{To: []string{"bob@example.org"}, BCC: []string{"Bounced@Example.org"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			if _, err := env.Email.AddSuppression(ctx, "admin", "bounced@example.org", ""); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := send(t, env, tc.params); !errors.Is(err, ErrRecipientSuppressed) {
+				t.Fatalf("err = %v, want ErrRecipientSuppressed", err)
+			}
+		})
+	}
+}
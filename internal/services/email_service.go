@@ -2,46 +2,497 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/bounce"
 	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
 	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/spamfilter"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/transport"
 	"github.com/bezata/blockchainml-email/pkg/cache"
+	"github.com/bezata/blockchainml-email/pkg/realtime"
 	"github.com/bezata/blockchainml-email/pkg/search"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
+// Labels the service files emails under
+const (
+	LabelInbox = label.Inbox
+	LabelSent  = label.Sent
+	LabelSpam  = label.Spam
+)
+
+var (
+	ErrEmailNotFound      = errors.New("email not found")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrInvalidEmail       = errors.New("invalid email")
+	ErrEmailAccessDenied  = errors.New("no access to this email")
+)
+
+type EmailServiceConfig struct {
+	Repo        storage.EmailRepository
+	Staff       storage.StaffRepository
+	Audit       storage.AuditRepository
+	Attachments *AttachmentService
+	// Templates renders templated content and signatures
+	Templates *TemplateService
+	// Spam trains on user feedback and, when SpamEnabled, classifies
+	// inbound mail
+	Spam        *spamfilter.Filter
+	SpamEnabled bool
+	// Replies limits Sieve vacation replies to one per sender and period
+	Replies ReplyTracker
+	// Suppressions are the addresses no email is sent to; hard bounced
+	// addresses are added to them
+	Suppressions storage.SuppressionRepository
+	// Campaigns records bounced campaign recipients
+	Campaigns storage.CampaignRepository
+	// Labels are the labels of the mailboxes emails are filed in
+	Labels storage.LabelRepository
+	// VERP signs the return paths of sent mail, nil when bounces are not
+	// tracked by return path
+	VERP *bounce.VERP
+	// Transports deliver sent mail through delivery jobs on Jobs; mail is
+	// not delivered while Transports is nil. DeliveryAttempts bounds the
+	// attempts of a delivery job.
+	Transports       *transport.Router
+	Jobs             storage.JobRepository
+	DeliveryAttempts int
+	// Cloudflare opens the messages Email Workers forward, nil when no
+	// encryption key is shared with them
+	Cloudflare CloudflareDecrypter
+	// Envelopes remembers the envelopes Email Workers forwarded, to refuse
+	// replays; they are not checked when nil
+	Envelopes EnvelopeTracker
+	Cache     *cache.Cache
+	Search    *search.SearchEngine
+	Notifier  *realtime.Notifier
+	Logger    *zap.Logger
+	Metrics   *metrics.Metrics
+}
+
 type EmailService struct {
-	repo      email.Repository
-	cache     *cache.Cache
-	search    *search.SearchEngine
-	logger    *zap.Logger
-	metrics   *metrics.Metrics
-}
-
-func NewEmailService(
-	repo email.Repository,
-	cache *cache.Cache,
-	search *search.SearchEngine,
-	logger *zap.Logger,
-	metrics *metrics.Metrics,
-) *EmailService {
+	repo             storage.EmailRepository
+	staff            storage.StaffRepository
+	audit            storage.AuditRepository
+	attachments      *AttachmentService
+	templates        *TemplateService
+	spam             *spamfilter.Filter
+	spamEnabled      bool
+	replies          ReplyTracker
+	suppressions     storage.SuppressionRepository
+	campaigns        storage.CampaignRepository
+	labels           storage.LabelRepository
+	verp             *bounce.VERP
+	transports       *transport.Router
+	jobs             storage.JobRepository
+	deliveryAttempts int
+	cloudflare       CloudflareDecrypter
+	envelopes        EnvelopeTracker
+	cache            *cache.Cache
+	search           *search.SearchEngine
+	notifier         *realtime.Notifier
+	scripts          *scriptCache
+	logger           *zap.Logger
+	metrics          *metrics.Metrics
+}
+
+func NewEmailService(cfg EmailServiceConfig) *EmailService {
 	return &EmailService{
-		repo:    repo,
-		cache:   cache,
-		search:  search,
-		logger:  logger,
-		metrics: metrics,
+		repo:             cfg.Repo,
+		staff:            cfg.Staff,
+		audit:            cfg.Audit,
+		attachments:      cfg.Attachments,
+		templates:        cfg.Templates,
+		spam:             cfg.Spam,
+		spamEnabled:      cfg.SpamEnabled,
+		replies:          cfg.Replies,
+		suppressions:     cfg.Suppressions,
+		campaigns:        cfg.Campaigns,
+		labels:           cfg.Labels,
+		verp:             cfg.VERP,
+		transports:       cfg.Transports,
+		jobs:             cfg.Jobs,
+		deliveryAttempts: cfg.DeliveryAttempts,
+		cloudflare:       cfg.Cloudflare,
+		envelopes:        cfg.Envelopes,
+		cache:            cfg.Cache,
+		search:           cfg.Search,
+		notifier:         cfg.Notifier,
+		scripts:          newScriptCache(),
+		logger:           cfg.Logger,
+		metrics:          cfg.Metrics,
 	}
 }
 
 type SendEmailParams struct {
-	From        string
-	To          []string
-	Subject     string
-	Content     email.EmailContent
+	// From is the ID of the sending staff member
+	From    string
+	To      []string
+	CC      []string
+	Subject string
+	Content email.EmailContent
+	// TemplateID renders the content, and the subject unless Subject is
+	// set, from a template with Variables instead of Content
+	TemplateID string
+	Variables  map[string]string
+	// Signature appends the sender's signature to the content
+	Signature bool
+	// Attachments are streamed to storage while sending
 	Attachments []email.AttachmentInput
-	ThreadID    *string
+	// Uploads are R2 keys of attachments staged by the sender beforehand
+	Uploads []string
+	// Forward are attachments of another email to include, such as the one
+	// being redirected. Their content is shared; quarantined ones are left
+	// out.
+	Forward  []email.Attachment
+	ThreadID *string
+	// Parent is the email replied to. The reply joins its thread and
+	// references it in In-Reply-To and References.
+	Parent *email.Email
+	// AutoSubmitted marks automatic mail, see email.AutoReplied
+	AutoSubmitted string
+	// Loop lists the addresses that redirected the email, sent as X-Loop
+	// headers
+	Loop []string
+	// CampaignID and ListUnsubscribe mark bulk mail, see
+	// email.EmailMetadata
+	CampaignID      string
+	ListUnsubscribe string
 }
 
+// SendEmail stores a new outgoing email with its attachments and queues
+// its delivery to every recipient. Suppressed recipients are refused. Inline
+// attachments are streamed into attachment storage and staged uploads are
+// moved there; if anything fails the stored attachments are removed again.
+// An email with infected attachments is stored flagged, with the
+// attachments quarantined, and the sender and admins are notified.
 func (s *EmailService) SendEmail(ctx context.Context, params SendEmailParams) (*email.Email, error) {
-	// implementation of SendEmail method
+	sender, err := s.staff.Get(ctx, params.From)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: unknown sender", ErrInvalidEmail)
+		}
+		return nil, fmt.Errorf("failed to get sender: %w", err)
+	}
+
+	if len(params.To) == 0 {
+		return nil, fmt.Errorf("%w: at least one recipient is required", ErrInvalidEmail)
+	}
+	to, err := parseRecipients(params.To)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := parseRecipients(params.CC)
+	if err != nil {
+		return nil, err
+	}
+	recipients := append(append([]email.Participant(nil), to...), cc...)
+	if err := s.checkSuppressed(ctx, recipients); err != nil {
+		return nil, err
+	}
+
+	if params.TemplateID != "" || params.Signature {
+		if err := s.renderContent(ctx, sender, recipients, &params); err != nil {
+			return nil, err
+		}
+	}
+
+	var staged int64
+	for _, attachment := range params.Forward {
+		if !attachment.Quarantined() {
+			staged += attachment.Size
+		}
+	}
+	for _, key := range params.Uploads {
+		size, err := s.attachments.Size(ctx, params.From, key)
+		if err != nil {
+			return nil, err
+		}
+		staged += size
+	}
+	if staged > s.attachments.MaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+
+	now := time.Now()
+	e := &email.Email{
+		ID:        primitive.NewObjectID(),
+		MessageID: fmt.Sprintf("<%s@%s>", uuid.New().String(), senderDomain(sender.Email)),
+		ThreadID:  params.ThreadID,
+		From: email.Participant{
+			Email:    sender.Email,
+			FullName: sender.FullName,
+		},
+		To:          to,
+		CC:          cc,
+		Subject:     params.Subject,
+		Content:     params.Content,
+		Attachments: []email.Attachment{},
+		Mailbox:     sender.ID.Hex(),
+		Labels:      []string{LabelSent},
+		Flags:       email.EmailFlags{IsRead: true},
+		Metadata: email.EmailMetadata{
+			AutoSubmitted:   params.AutoSubmitted,
+			Loop:            params.Loop,
+			CampaignID:      params.CampaignID,
+			ListUnsubscribe: params.ListUnsubscribe,
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if params.Parent != nil {
+		setParent(e, params.Parent)
+	}
+	queueDelivery(e, now)
+
+	if err := s.storeAttachments(ctx, e, params, staged); err != nil {
+		s.removeAttachments(ctx, e)
+		return nil, err
+	}
+
+	quarantined := markQuarantined(e)
+
+	if err := s.repo.Create(ctx, e); err != nil {
+		s.removeAttachments(ctx, e)
+		return nil, fmt.Errorf("failed to create email: %w", err)
+	}
+
+	if quarantined {
+		s.notifyQuarantine(ctx, e, params.From)
+	}
+	s.enqueueDelivery(ctx, e)
+
+	s.metrics.EmailRequests.WithLabelValues("send", "success").Inc()
+	return e, nil
+}
+
+// ListEmailsParams selects a page of the emails of a mailbox
+type ListEmailsParams struct {
+	// Mailbox is the staff ID of the mailbox listed, the caller's own when
+	// empty
+	Mailbox string
+	// Labels must all be present on the emails listed
+	Labels []string
+	Cursor string
+	Limit  int
+}
+
+// ListEmails returns one page of the emails filed in a mailbox userID has
+// access to, most recently created first
+func (s *EmailService) ListEmails(ctx context.Context, userID string, params ListEmailsParams) ([]*email.Email, error) {
+	mailbox := params.Mailbox
+	if mailbox == "" {
+		mailbox = userID
+	}
+	if err := s.checkMailboxAccess(ctx, userID, mailbox); err != nil {
+		return nil, err
+	}
+
+	emails, err := s.repo.List(ctx, &email.ListQuery{
+		Mailbox: mailbox,
+		Labels:  params.Labels,
+		Cursor:  params.Cursor,
+		Limit:   params.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list emails: %w", err)
+	}
+	return emails, nil
+}
+
+// GetEmail returns an email filed in a mailbox userID has access to
+func (s *EmailService) GetEmail(ctx context.Context, userID, id string) (*email.Email, error) {
+	e, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrEmailNotFound
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	if err := s.checkEmailAccess(ctx, userID, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// GetAttachmentURL returns a download URL for an attachment of an email
+// userID has access to
+func (s *EmailService) GetAttachmentURL(ctx context.Context, userID, emailID, filename string) (*PresignedURL, error) {
+	e, err := s.repo.Get(ctx, emailID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrEmailNotFound
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	if err := s.checkEmailAccess(ctx, userID, e); err != nil {
+		return nil, err
+	}
+
+	for i := range e.Attachments {
+		if e.Attachments[i].Filename == filename {
+			return s.attachments.DownloadURL(ctx, &e.Attachments[i])
+		}
+	}
+	return nil, ErrAttachmentNotFound
+}
+
+// checkEmailAccess fails with ErrEmailAccessDenied unless userID has
+// access to the mailbox e is filed in. Each recipient has their own copy of
+// an email, so being a participant gives no access to the copies of
+// others. Emails stored before emails were filed in mailboxes fall back to
+// canAccess.
+func (s *EmailService) checkEmailAccess(ctx context.Context, userID string, e *email.Email) error {
+	if e.Mailbox != "" {
+		return s.checkMailboxAccess(ctx, userID, e.Mailbox)
+	}
+
+	ok, err := s.canAccess(ctx, userID, e)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrEmailAccessDenied
+	}
+	return nil
+}
+
+// canAccess reports whether userID may read e. The mailboxes of the sender
+// and every recipient give access to their owners and delegates; drafts are
+// only in the sender's mailbox.
+func (s *EmailService) canAccess(ctx context.Context, userID string, e *email.Email) (bool, error) {
+	participants := []email.Participant{e.From}
+	if e.Draft == nil {
+		participants = append(participants, e.To...)
+		participants = append(participants, e.CC...)
+		participants = append(participants, e.BCC...)
+	}
+
+	for _, p := range participants {
+		member, err := s.staff.GetByEmail(ctx, p.Email)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return false, fmt.Errorf("failed to get mailbox owner: %w", err)
+		}
+		if member.HasMailboxAccess(userID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkMailboxAccess fails with ErrEmailAccessDenied unless userID owns
+// the mailbox of the staff member mailbox or is one of its delegates
+func (s *EmailService) checkMailboxAccess(ctx context.Context, userID, mailbox string) error {
+	owner, err := s.staff.Get(ctx, mailbox)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrEmailAccessDenied
+		}
+		return fmt.Errorf("failed to get mailbox owner: %w", err)
+	}
+	if !owner.HasMailboxAccess(userID) {
+		return ErrEmailAccessDenied
+	}
+	return nil
+}
+
+// storeAttachments adds every attachment of params to e, keeping the
+// total within the attachment service's maximum size. Repeated filenames
+// get a numbered suffix so each attachment can be addressed by name.
+func (s *EmailService) storeAttachments(ctx context.Context, e *email.Email, params SendEmailParams, total int64) error {
+	names := make(map[string]bool)
+	add := func(attachment *email.Attachment) {
+		attachment.Filename = uniqueFilename(attachment.Filename, names)
+		e.Attachments = append(e.Attachments, *attachment)
+	}
+
+	for _, input := range params.Attachments {
+		attachment, err := s.attachments.Store(ctx, input)
+		if err != nil {
+			return err
+		}
+		add(attachment)
+		total += attachment.Size
+		if total > s.attachments.MaxSize() {
+			return ErrUploadTooLarge
+		}
+	}
+
+	for _, key := range params.Uploads {
+		attachment, err := s.attachments.Claim(ctx, params.From, key)
+		if err != nil {
+			return err
+		}
+		add(attachment)
+	}
+
+	for _, forwarded := range params.Forward {
+		if forwarded.Quarantined() {
+			continue
+		}
+		attachment, err := s.attachments.Copy(ctx, forwarded)
+		if err != nil {
+			return err
+		}
+		add(attachment)
+	}
+
+	return nil
+}
+
+func (s *EmailService) removeAttachments(ctx context.Context, e *email.Email) {
+	if err := s.attachments.DeleteAttachments(context.WithoutCancel(ctx), e.Attachments); err != nil {
+		s.logger.Error("failed to delete attachments", zap.String("email_id", e.ID.Hex()), zap.Error(err))
+	}
+}
+
+// uniqueFilename returns name, or name with " (n)" before its extension
+// when taken already, and records the result in taken
+func uniqueFilename(name string, taken map[string]bool) string {
+	unique := name
+	ext := path.Ext(name)
+	for n := 2; taken[unique]; n++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// parseRecipients parses recipient addresses, lower-casing them
+func parseRecipients(list []string) ([]email.Participant, error) {
+	recipients := make([]email.Participant, 0, len(list))
+	for _, raw := range list {
+		address, err := mail.ParseAddress(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid recipient %q", ErrInvalidEmail, raw)
+		}
+		recipients = append(recipients, email.Participant{
+			Email:    strings.ToLower(address.Address),
+			FullName: address.Name,
+		})
+	}
+	return recipients, nil
+}
+
+func senderDomain(address string) string {
+	if _, domain, ok := strings.Cut(address, "@"); ok && domain != "" {
+		return domain
+	}
+	return "localhost"
 }
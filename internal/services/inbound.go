@@ -0,0 +1,456 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/sieve"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// InboundMessage is a message received for local mailboxes, parsed by the
+// transport it arrived on
+type InboundMessage struct {
+	// Email holds the parsed headers and content. Every recipient gets a
+	// copy with an ID, labels and flags of its own.
+	Email       *email.Email
+	Header      mail.Header
+	Attachments []email.AttachmentInput
+	// MailFrom is the envelope sender, empty for the null sender of bounces
+	MailFrom string
+	// Recipients are the envelope recipients
+	Recipients []string
+	ClientIP   string
+	// Size is the size of the raw message
+	Size int64
+	// Local marks mail sent by staff to staff, which the spam filter skips
+	Local bool
+}
+
+// InboundResult tells what became of a message for each recipient
+type InboundResult struct {
+	// Delivered maps recipients to the copy stored in their mailbox
+	Delivered map[string]*email.Email
+	// Discarded lists recipients whose Sieve script dropped the message
+	Discarded []string
+	// Rejected maps recipients to the reason the message was refused for
+	// them, to be returned to the sender
+	Rejected map[string]string
+	// Deferred maps recipients to the error that kept the message from
+	// being delivered; the sender should retry
+	Deferred map[string]error
+	// Bounces lists recipients that are return paths of sent mail. The
+	// message was taken as a bounce and filed in no mailbox.
+	Bounces []string
+}
+
+// Receive delivers an inbound message to the mailboxes of its envelope
+// recipients. Bounces of sent mail update its delivery state first, see
+// receiveBounces. Attachments are stored and scanned once. For every recipient
+// the message is then classified by the spam filter and run through their
+// Sieve script before their copy is stored, and answered when they are
+// out of office. Unknown recipients are rejected.
+func (s *EmailService) Receive(ctx context.Context, msg *InboundMessage) (*InboundResult, error) {
+	result := &InboundResult{
+		Delivered: make(map[string]*email.Email),
+		Rejected:  make(map[string]string),
+		Deferred:  make(map[string]error),
+	}
+	recipients := s.receiveBounces(ctx, msg, result)
+	if len(recipients) == 0 {
+		s.metrics.EmailRequests.WithLabelValues("receive", "success").Inc()
+		return result, nil
+	}
+
+	base := *msg.Email
+	base.Attachments = []email.Attachment{}
+	base.Metadata.ClientIP = msg.ClientIP
+	if err := s.storeAttachments(ctx, &base, SendEmailParams{Attachments: msg.Attachments}, 0); err != nil {
+		s.removeAttachments(ctx, &base)
+		return nil, err
+	}
+	// Every copy references the attachments on its own
+	defer s.removeAttachments(ctx, &base)
+	markQuarantined(&base)
+
+	for _, rcpt := range recipients {
+		member, err := s.staff.GetByEmail(ctx, rcpt)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				result.Rejected[rcpt] = "no such mailbox"
+				continue
+			}
+			result.Deferred[rcpt] = fmt.Errorf("failed to get mailbox owner: %w", err)
+			continue
+		}
+
+		e, actions, err := s.deliver(ctx, msg, &base, member, rcpt)
+		switch {
+		case err != nil:
+			s.logger.Error("failed to deliver email", zap.String("to", rcpt), zap.Error(err))
+			result.Deferred[rcpt] = err
+		case actions.Rejected:
+			result.Rejected[rcpt] = actions.RejectReason
+		case e == nil:
+			result.Discarded = append(result.Discarded, rcpt)
+		default:
+			result.Delivered[rcpt] = e
+		}
+	}
+
+	for _, e := range result.Delivered {
+		if e.Flags.IsQuarantined {
+			s.notifyQuarantine(ctx, e, "")
+			break
+		}
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("receive", "success").Inc()
+	return result, nil
+}
+
+// deliver files a copy of a received message in member's mailbox as their
+// Sieve script says. The copy is nil when the script discarded or rejected
+// the message.
+func (s *EmailService) deliver(ctx context.Context, msg *InboundMessage, base *email.Email, member *staff.Staff, rcpt string) (*email.Email, *sieve.Result, error) {
+	// A sender retrying a message deferred for other recipients delivers
+	// it again to every one of them
+	if existing, err := s.delivered(ctx, member.ID.Hex(), base.MessageID); err != nil || existing != nil {
+		return existing, &sieve.Result{Keep: true}, err
+	}
+
+	now := time.Now()
+	e := *base
+	e.ID = primitive.NewObjectID()
+	e.Mailbox = member.ID.Hex()
+	e.Labels = []string{LabelInbox}
+	e.Flags = email.EmailFlags{IsQuarantined: base.Flags.IsQuarantined}
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	if !addressedTo(&e, []string{rcpt}) {
+		// Recipients missing from the headers were sent a blind copy
+		e.BCC = append(append([]email.Participant(nil), e.BCC...), email.Participant{Email: rcpt})
+	}
+
+	if s.spamEnabled && !msg.Local {
+		s.filterSpam(ctx, member.ID.Hex(), &e, msg.Header, msg.ClientIP)
+	}
+	isSpam := e.Metadata.Spam != nil && e.Metadata.Spam.IsSpam
+
+	actions := s.runSieve(member, msg, &e, rcpt)
+	if actions.Rejected {
+		return nil, actions, nil
+	}
+
+	var labels, flags []string
+	if actions.Keep {
+		// The spam filter may have moved the message from the inbox already
+		labels = e.Labels
+		flags = actions.Flags
+	}
+	for _, fileinto := range actions.FileInto {
+		label := fileinto.Mailbox
+		if strings.EqualFold(label, "inbox") {
+			label = LabelInbox
+		}
+		labels = withLabel(labels, label)
+		flags = append(flags, fileinto.Flags...)
+	}
+	if len(labels) == 0 {
+		s.respond(ctx, member, msg, &e, actions, isSpam, now)
+		return nil, actions, nil
+	}
+	e.Labels = labels
+	applyFlags(&e.Flags, flags)
+
+	e.Attachments = make([]email.Attachment, 0, len(base.Attachments))
+	for _, attachment := range base.Attachments {
+		copied, err := s.attachments.Copy(ctx, attachment)
+		if err != nil {
+			s.removeAttachments(ctx, &e)
+			return nil, actions, err
+		}
+		e.Attachments = append(e.Attachments, *copied)
+	}
+
+	if err := s.repo.Create(ctx, &e); err != nil {
+		s.removeAttachments(ctx, &e)
+		if errors.Is(err, storage.ErrDuplicate) {
+			// Delivered concurrently by a retry
+			existing, err := s.delivered(ctx, e.Mailbox, e.MessageID)
+			return existing, actions, err
+		}
+		return nil, actions, fmt.Errorf("failed to create email: %w", err)
+	}
+	s.respond(ctx, member, msg, &e, actions, isSpam, now)
+	return &e, actions, nil
+}
+
+// respond carries out what a delivered message asks of member's mailbox
+// besides filing it: redirects by their Sieve script and forwarding
+// address, and their vacation or out-of-office reply. It runs once the
+// copy is stored, or the message discarded, so a delivery that fails and
+// is retried by the sender sends nothing twice. Spam is neither forwarded
+// nor answered, and messages that were redirected by member before are not
+// redirected again.
+func (s *EmailService) respond(ctx context.Context, member *staff.Staff, msg *InboundMessage, e *email.Email, actions *sieve.Result, isSpam bool, now time.Time) {
+	redirects := actions.Redirect
+	if forward := member.Mailbox.ForwardTo; forward != "" && !isSpam {
+		redirects = append(redirects, forward)
+	}
+	if len(redirects) > 0 && loopsThrough(msg.Header, member.Email) {
+		s.logger.Warn("not redirecting email that looped back",
+			zap.String("staff_id", member.ID.Hex()),
+			zap.String("message_id", e.MessageID),
+		)
+		redirects = nil
+	}
+	for _, to := range redirects {
+		s.redirect(ctx, member, msg, e, to)
+	}
+
+	if isSpam {
+		return
+	}
+	if actions.Vacation != nil {
+		s.vacation(ctx, member, msg, e, actions.Vacation)
+	} else if ooo := member.Mailbox.OutOfOffice; ooo != nil && ooo.Active(now) {
+		s.outOfOffice(ctx, member, msg, e, ooo)
+	}
+}
+
+// loopsThrough reports whether a message carries the X-Loop header of
+// address, added when address redirected it before
+func loopsThrough(header mail.Header, address string) bool {
+	for _, loop := range header["X-Loop"] {
+		if strings.EqualFold(strings.Trim(strings.TrimSpace(loop), "<>"), address) {
+			return true
+		}
+	}
+	return false
+}
+
+// delivered returns the copy of a message already filed in mailbox, or nil
+func (s *EmailService) delivered(ctx context.Context, mailbox, messageID string) (*email.Email, error) {
+	emails, err := s.repo.List(ctx, &email.ListQuery{Mailbox: mailbox, MessageID: messageID, Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up delivered email: %w", err)
+	}
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	return emails[0], nil
+}
+
+// runSieve runs member's Sieve script against a received message. Without
+// a script, and when the script fails, the message is kept.
+func (s *EmailService) runSieve(member *staff.Staff, msg *InboundMessage, e *email.Email, rcpt string) *sieve.Result {
+	keep := &sieve.Result{Keep: true}
+	if member.Mailbox.Sieve == nil {
+		return keep
+	}
+
+	script, err := s.scripts.compile(member.ID, member.Mailbox.Sieve)
+	if err != nil {
+		s.logger.Error("failed to compile sieve script", zap.String("staff_id", member.ID.Hex()), zap.Error(err))
+		return keep
+	}
+
+	actions, err := script.Run(&sieve.Message{
+		Header: sieveHeader(msg.Header, e.Metadata.Spam),
+		From:   msg.MailFrom,
+		To:     rcpt,
+		Size:   msg.Size,
+		Text:   e.Content.Text,
+		HTML:   e.Content.HTML,
+	})
+	if err != nil {
+		s.logger.Warn("sieve script failed", zap.String("staff_id", member.ID.Hex()), zap.Error(err))
+	}
+	s.metrics.EmailRequests.WithLabelValues("sieve", sieveStatus(actions)).Inc()
+	return actions
+}
+
+// maxCachedScripts bounds the compiled Sieve scripts kept in memory
+const maxCachedScripts = 1024
+
+// scriptCache keeps members' compiled Sieve scripts, so a script is
+// compiled once per version rather than once per message
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[primitive.ObjectID]*cachedScript
+}
+
+// cachedScript is a compiled script, or its compile error, and the version
+// it was compiled from
+type cachedScript struct {
+	updatedAt time.Time
+	source    string
+	script    *sieve.Script
+	err       error
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{scripts: make(map[primitive.ObjectID]*cachedScript)}
+}
+
+// compile returns the compiled form of id's script. Entries are keyed by
+// the script's UpdatedAt and checked against its source, so a replaced
+// script is compiled afresh.
+func (c *scriptCache) compile(id primitive.ObjectID, src *staff.SieveScript) (*sieve.Script, error) {
+	c.mu.Lock()
+	cached, ok := c.scripts[id]
+	c.mu.Unlock()
+	if ok && cached.updatedAt.Equal(src.UpdatedAt) && cached.source == src.Script {
+		return cached.script, cached.err
+	}
+
+	script, err := sieve.Compile(src.Script)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.scripts[id]; !ok && len(c.scripts) >= maxCachedScripts {
+		// Evict an arbitrary entry, its owner's next message recompiles it
+		for k := range c.scripts {
+			delete(c.scripts, k)
+			break
+		}
+	}
+	c.scripts[id] = &cachedScript{updatedAt: src.UpdatedAt, source: src.Script, script: script, err: err}
+	return script, err
+}
+
+// sieveHeader adds the spam filter's verdict to the header, so scripts can
+// test X-Spam-Flag and X-Spam-Score
+func sieveHeader(header mail.Header, verdict *email.SpamResult) mail.Header {
+	if verdict == nil {
+		return header
+	}
+
+	result := make(mail.Header, len(header)+2)
+	for name, values := range header {
+		result[name] = values
+	}
+	flag := "NO"
+	if verdict.IsSpam {
+		flag = "YES"
+	}
+	result["X-Spam-Flag"] = []string{flag}
+	result["X-Spam-Score"] = []string{fmt.Sprintf("%.1f", verdict.Score)}
+	return result
+}
+
+func sieveStatus(actions *sieve.Result) string {
+	switch {
+	case actions.Rejected:
+		return "rejected"
+	case actions.Discarded():
+		return "discarded"
+	case len(actions.FileInto) > 0:
+		return "filed"
+	}
+	return "kept"
+}
+
+// applyFlags sets the email flags matching IMAP system flags. Keywords
+// have no counterpart and are ignored.
+func applyFlags(flags *email.EmailFlags, imapFlags []string) {
+	for _, flag := range imapFlags {
+		switch strings.ToLower(flag) {
+		case `\seen`:
+			flags.IsRead = true
+		case `\flagged`:
+			flags.IsStarred = true
+		}
+	}
+}
+
+// redirect forwards a received message to another address, sent from
+// member with the original subject, content and attachments. It is marked
+// Auto-Submitted and carries the X-Loop headers of the message and of
+// member, so that no mailbox redirects it in a loop.
+func (s *EmailService) redirect(ctx context.Context, member *staff.Staff, msg *InboundMessage, e *email.Email, to string) {
+	if strings.EqualFold(to, member.Email) {
+		return
+	}
+
+	_, err := s.SendEmail(ctx, SendEmailParams{
+		From:          member.ID.Hex(),
+		To:            []string{to},
+		Subject:       e.Subject,
+		Content:       e.Content,
+		Forward:       e.Attachments,
+		AutoSubmitted: email.AutoGenerated,
+		Loop:          append(append([]string(nil), msg.Header["X-Loop"]...), member.Email),
+	})
+	if err != nil {
+		s.logger.Error("failed to redirect email",
+			zap.String("staff_id", member.ID.Hex()),
+			zap.String("to", to),
+			zap.Error(err),
+		)
+	}
+}
+
+// vacation sends the reply of a Sieve vacation action. Replies come from
+// member's own address, so :from is not honored.
+func (s *EmailService) vacation(ctx context.Context, member *staff.Staff, msg *InboundMessage, e *email.Email, v *sieve.Vacation) {
+	s.sendAutoReply(ctx, member, msg, e, autoReply{
+		key:       "vacation:" + v.Handle,
+		days:      v.Days,
+		subject:   v.Subject,
+		content:   vacationContent(v),
+		addresses: v.Addresses,
+	})
+}
+
+// vacationContent returns the reply body. A :mime reason is a MIME entity
+// whose headers say whether the body is HTML.
+func vacationContent(v *sieve.Vacation) email.EmailContent {
+	if !v.Mime {
+		return email.EmailContent{Text: v.Reason}
+	}
+
+	entity, err := mail.ReadMessage(strings.NewReader(v.Reason))
+	if err != nil {
+		return email.EmailContent{Text: v.Reason}
+	}
+	body, err := io.ReadAll(entity.Body)
+	if err != nil {
+		return email.EmailContent{Text: v.Reason}
+	}
+	if mediaType, _, _ := mime.ParseMediaType(entity.Header.Get("Content-Type")); mediaType == "text/html" {
+		return email.EmailContent{HTML: string(body)}
+	}
+	return email.EmailContent{Text: string(body)}
+}
+
+// addressedTo reports whether any of addresses is in the To or Cc of e
+func addressedTo(e *email.Email, addresses []string) bool {
+	for _, p := range append(append([]email.Participant(nil), e.To...), e.CC...) {
+		if containsAddress(addresses, p.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if strings.EqualFold(a, address) {
+			return true
+		}
+	}
+	return false
+}
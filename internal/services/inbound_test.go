@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func inboundMessage(messageID string, to []string, recipients ...string) *InboundMessage {
+	e := &email.Email{
+		MessageID: messageID,
+		From:      email.Participant{Email: "sender@example.org"},
+		Subject:   "Quarterly report",
+		Content:   email.EmailContent{Text: "Figures attached"},
+	}
+	for _, address := range to {
+		e.To = append(e.To, email.Participant{Email: address})
+	}
+	return &InboundMessage{
+		Email:      e,
+		Header:     mail.Header{"Message-Id": {messageID}, "Subject": {e.Subject}},
+		MailFrom:   "sender@example.org",
+		Recipients: recipients,
+		ClientIP:   "192.0.2.1",
+		Size:       512,
+	}
+}
+
+func TestReceiveDeliversCopyPerRecipient(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	alice := env.addStaff(t, "alice@example.com")
+	bob := env.addStaff(t, "bob@example.com")
+	carol := env.addStaff(t, "carol@example.com")
+
+	msg := inboundMessage("<report@example.org>",
+		[]string{"alice@example.com", "bob@example.com"},
+		"alice@example.com", "bob@example.com", "carol@example.com", "nobody@example.com",
+	)
+	result, err := env.Email.Receive(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Deferred) != 0 {
+		t.Fatalf("deferred = %v", result.Deferred)
+	}
+	if _, ok := result.Rejected["nobody@example.com"]; !ok || len(result.Rejected) != 1 {
+		t.Fatalf("rejected = %v, want the unknown recipient", result.Rejected)
+	}
+
+	owners := map[string]string{
+		"alice@example.com": alice.ID.Hex(),
+		"bob@example.com":   bob.ID.Hex(),
+		"carol@example.com": carol.ID.Hex(),
+	}
+	if len(result.Delivered) != len(owners) {
+		t.Fatalf("delivered to %d recipients, want %d", len(result.Delivered), len(owners))
+	}
+	ids := make(map[string]bool)
+	for rcpt, mailbox := range owners {
+		e := result.Delivered[rcpt]
+		if e == nil {
+			t.Fatalf("no copy delivered to %s", rcpt)
+		}
+		if e.Mailbox != mailbox || e.MessageID != "<report@example.org>" {
+			t.Fatalf("copy of %s filed in %q as %q", rcpt, e.Mailbox, e.MessageID)
+		}
+		ids[e.ID.Hex()] = true
+	}
+	if len(ids) != len(owners) {
+		t.Fatalf("copies share IDs: %v", ids)
+	}
+	// Carol was not in the headers, so she got a blind copy
+	if bcc := result.Delivered["carol@example.com"].BCC; len(bcc) != 1 || bcc[0].Email != "carol@example.com" {
+		t.Fatalf("bcc of carol's copy = %v", bcc)
+	}
+
+	// A retry by the sender delivers the copies already filed
+	retry, err := env.Email.Receive(ctx, inboundMessage("<report@example.org>",
+		[]string{"alice@example.com", "bob@example.com"},
+		"alice@example.com", "bob@example.com",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rcpt := range []string{"alice@example.com", "bob@example.com"} {
+		if e := retry.Delivered[rcpt]; e == nil || e.ID != result.Delivered[rcpt].ID {
+			t.Fatalf("retry delivered %v to %s, want the first copy", e, rcpt)
+		}
+	}
+	stored, err := env.repos.Email.List(ctx, &email.ListQuery{MessageID: "<report@example.org>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(owners) {
+		t.Fatalf("stored %d copies, want %d", len(stored), len(owners))
+	}
+}
+
+// failingCreate fails to store emails, as a database outage would
+type failingCreate struct {
+	storage.EmailRepository
+}
+
+func (failingCreate) Create(context.Context, *email.Email) error {
+	return errors.New("database unavailable")
+}
+
+// sentBy returns the emails sent from member's mailbox
+func (env *testEnv) sentBy(t *testing.T, member *staff.Staff) []*email.Email {
+	t.Helper()
+
+	sent, err := env.repos.Email.List(context.Background(), &email.ListQuery{Mailbox: member.ID.Hex(), Labels: []string{LabelSent}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sent
+}
+
+func TestReceiveRedirectsOnlyStoredMessages(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	alice.Mailbox.Sieve = &staff.SieveScript{Script: `redirect "alice@elsewhere.example"; keep;`}
+	alice.Mailbox.ForwardTo = "alice@home.example"
+	if err := env.repos.Staff.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	// A copy that fails to be stored sends nothing; the sender retries
+	env.Email.repo = failingCreate{env.repos.Email}
+	result, err := env.Email.Receive(ctx, inboundMessage("<m1@example.org>", []string{"alice@example.com"}, "alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Deferred["alice@example.com"]; !ok {
+		t.Fatalf("result = %+v, want alice deferred", result)
+	}
+	if sent := env.sentBy(t, alice); len(sent) != 0 {
+		t.Fatalf("redirected %d emails before the copy was stored", len(sent))
+	}
+
+	env.Email.repo = env.repos.Email
+	result, err = env.Email.Receive(ctx, inboundMessage("<m1@example.org>", []string{"alice@example.com"}, "alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Delivered["alice@example.com"] == nil {
+		t.Fatalf("result = %+v, want alice delivered", result)
+	}
+	sent := env.sentBy(t, alice)
+	if len(sent) != 2 {
+		t.Fatalf("redirected %d emails, want 2", len(sent))
+	}
+	for _, e := range sent {
+		if e.Metadata.AutoSubmitted != email.AutoGenerated || len(e.Metadata.Loop) != 1 || e.Metadata.Loop[0] != "alice@example.com" {
+			t.Fatalf("redirect to %v marked %q, loop %v", e.To, e.Metadata.AutoSubmitted, e.Metadata.Loop)
+		}
+		message, err := env.Email.composeMessage(ctx, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(message), "\r\nX-Loop: alice@example.com\r\n") ||
+			!strings.Contains(string(message), "\r\nAuto-Submitted: auto-generated\r\n") {
+			t.Fatalf("redirect headers missing from\n%s", message)
+		}
+	}
+
+	// A retry finds the stored copy and redirects nothing again
+	if _, err := env.Email.Receive(ctx, inboundMessage("<m1@example.org>", []string{"alice@example.com"}, "alice@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if sent := env.sentBy(t, alice); len(sent) != 2 {
+		t.Fatalf("redirected %d emails after a retry, want 2", len(sent))
+	}
+}
+
+func TestReceiveStopsRedirectLoops(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	bob := env.addStaff(t, "bob@example.com")
+	alice.Mailbox.ForwardTo = "bob@example.com"
+	bob.Mailbox.ForwardTo = "alice@example.com"
+	for _, member := range []*staff.Staff{alice, bob} {
+		if err := env.repos.Staff.Update(ctx, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := env.Email.Receive(ctx, inboundMessage("<m1@example.org>", []string{"alice@example.com"}, "alice@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	// Follow the forwards through local delivery until they stop
+	for hop := 0; hop < 5; hop++ {
+		pending := append(env.sentBy(t, alice), env.sentBy(t, bob)...)
+		for _, e := range pending {
+			if err := env.Email.DeliverEmail(ctx, deliveryJob(t, e)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if sent := env.sentBy(t, alice); len(sent) != 1 {
+		t.Fatalf("alice forwarded %d emails, want 1", len(sent))
+	}
+	bobSent := env.sentBy(t, bob)
+	if len(bobSent) != 1 {
+		t.Fatalf("bob forwarded %d emails, want 1", len(bobSent))
+	}
+	if loop := bobSent[0].Metadata.Loop; len(loop) != 2 || loop[0] != "alice@example.com" || loop[1] != "bob@example.com" {
+		t.Fatalf("bob's forward loops through %v", loop)
+	}
+	// The forward back to alice was filed, but not forwarded again
+	inbox, err := env.Email.ListEmails(ctx, alice.ID.Hex(), ListEmailsParams{Labels: []string{LabelInbox}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 2 {
+		t.Fatalf("alice's inbox has %d emails, want the original and bob's forward", len(inbox))
+	}
+}
+
+func TestScriptCacheRecompilesReplacedScripts(t *testing.T) {
+	cache := newScriptCache()
+	id := primitive.NewObjectID()
+	updated := time.Now()
+
+	first, err := cache.compile(id, &staff.SieveScript{Script: "keep;", UpdatedAt: updated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := cache.compile(id, &staff.SieveScript{Script: "keep;", UpdatedAt: updated})
+	if err != nil || again != first {
+		t.Fatalf("same version compiled again (err %v)", err)
+	}
+
+	replaced, err := cache.compile(id, &staff.SieveScript{Script: "discard;", UpdatedAt: updated.Add(time.Second)})
+	if err != nil || replaced == first {
+		t.Fatalf("replaced script served from the cache (err %v)", err)
+	}
+	// Scripts written without a new UpdatedAt are told apart by their source
+	if same, _ := cache.compile(id, &staff.SieveScript{Script: "keep;", UpdatedAt: updated.Add(time.Second)}); same == replaced {
+		t.Fatal("script with a different source served from the cache")
+	}
+
+	// Compile errors are cached with the version that caused them
+	if _, err := cache.compile(id, &staff.SieveScript{Script: "bogus;"}); err == nil {
+		t.Fatal("invalid script compiled")
+	}
+	if _, err := cache.compile(id, &staff.SieveScript{Script: "bogus;"}); err == nil {
+		t.Fatal("cached invalid script compiled")
+	}
+
+	for i := 0; i < 2*maxCachedScripts; i++ {
+		if _, err := cache.compile(primitive.NewObjectID(), &staff.SieveScript{Script: "keep;"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cache.scripts) > maxCachedScripts {
+		t.Fatalf("cache holds %d scripts, want at most %d", len(cache.scripts), maxCachedScripts)
+	}
+}
+
+func TestReceiveAppliesReplacedSieveScript(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+
+	if _, err := env.Staff.SetSieveScript(ctx, alice.ID.Hex(), `require "fileinto"; fileinto "Old";`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Email.Receive(ctx, inboundMessage("<m1@example.org>", []string{"alice@example.com"}, "alice@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Staff.SetSieveScript(ctx, alice.ID.Hex(), `discard;`); err != nil {
+		t.Fatal(err)
+	}
+	result, err := env.Email.Receive(ctx, inboundMessage("<m2@example.org>", []string{"alice@example.com"}, "alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Discarded) != 1 {
+		t.Fatalf("result = %+v, want the second message discarded by the new script", result)
+	}
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/storage"
+)
+
+// maxLabelName bounds the length of a label name, its parents' included
+const maxLabelName = 255
+
+var (
+	ErrLabelNotFound = errors.New("label not found")
+	ErrLabelExists   = errors.New("label already exists")
+	ErrInvalidLabel  = errors.New("invalid label")
+	ErrLabelSystem   = errors.New("system labels cannot be renamed, moved or deleted")
+)
+
+var labelColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// LabelParams creates a label or changes one; nil fields are left as they
+// are
+type LabelParams struct {
+	// Name is the path of the label. Renaming a label moves the labels
+	// nested in it along; changing its parent moves it.
+	Name *string
+	// Color is a hex color such as #1a73e8, or empty for the default
+	Color *string
+}
+
+// EmailChanges changes the flags and labels of an email in a mailbox; nil
+// flags are left as they are
+type EmailChanges struct {
+	IsRead       *bool
+	IsStarred    *bool
+	AddLabels    []string
+	RemoveLabels []string
+}
+
+// ListLabels returns the labels of userID's mailbox with their counters,
+// the system labels first. System labels missing are created.
+func (s *EmailService) ListLabels(ctx context.Context, userID string) ([]*label.Label, error) {
+	labels, err := s.labels.List(ctx, &label.ListQuery{Owner: userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+
+	created := false
+	now := time.Now()
+	for _, name := range label.System {
+		if findLabel(labels, name) != nil {
+			continue
+		}
+		// A concurrent request may have created it already
+		l := &label.Label{Owner: userID, Name: name, CreatedAt: now, UpdatedAt: now}
+		if err := s.labels.Create(ctx, l); err != nil && !errors.Is(err, storage.ErrDuplicate) {
+			return nil, fmt.Errorf("failed to create system label: %w", err)
+		}
+		created = true
+	}
+	if created {
+		if labels, err = s.labels.List(ctx, &label.ListQuery{Owner: userID}); err != nil {
+			return nil, fmt.Errorf("failed to list labels: %w", err)
+		}
+	}
+
+	rank := func(l *label.Label) int {
+		for i, name := range label.System {
+			if l.Name == name {
+				return i
+			}
+		}
+		return len(label.System)
+	}
+	sort.SliceStable(labels, func(i, j int) bool { return rank(labels[i]) < rank(labels[j]) })
+	return labels, nil
+}
+
+// CreateLabel adds a label to userID's mailbox. A nested label needs its
+// parent to exist.
+func (s *EmailService) CreateLabel(ctx context.Context, userID string, params LabelParams) (*label.Label, error) {
+	if params.Name == nil {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidLabel)
+	}
+	name, err := labelName(*params.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, system := range label.System {
+		if strings.EqualFold(name, system) {
+			return nil, ErrLabelExists
+		}
+	}
+	if err := s.checkParent(ctx, userID, name); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	l := &label.Label{Owner: userID, Name: name, CreatedAt: now, UpdatedAt: now}
+	if params.Color != nil {
+		if l.Color, err = colorValue(*params.Color); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.labels.Create(ctx, l); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			return nil, ErrLabelExists
+		}
+		return nil, fmt.Errorf("failed to create label: %w", err)
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("create_label", "success").Inc()
+	return l, nil
+}
+
+// UpdateLabel renames, moves or recolors a label of userID's mailbox.
+// Renames cascade to the nested labels and to the emails carrying them.
+// System labels can only be recolored.
+func (s *EmailService) UpdateLabel(ctx context.Context, userID, id string, params LabelParams) (*label.Label, error) {
+	l, err := s.getLabel(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Name != nil {
+		name, err := labelName(*params.Name)
+		if err != nil {
+			return nil, err
+		}
+		if name != l.Name {
+			if label.IsSystem(l.Name) {
+				return nil, ErrLabelSystem
+			}
+			if label.Within(name, l.Name) {
+				return nil, fmt.Errorf("%w: a label cannot be moved into itself", ErrInvalidLabel)
+			}
+			for _, system := range label.System {
+				if strings.EqualFold(name, system) {
+					return nil, ErrLabelExists
+				}
+			}
+			if err := s.checkParent(ctx, userID, name); err != nil {
+				return nil, err
+			}
+			if err := s.labels.Rename(ctx, userID, l.Name, name); err != nil {
+				switch {
+				case errors.Is(err, storage.ErrNotFound):
+					return nil, ErrLabelNotFound
+				case errors.Is(err, storage.ErrDuplicate):
+					return nil, ErrLabelExists
+				}
+				return nil, fmt.Errorf("failed to rename label: %w", err)
+			}
+		}
+	}
+
+	if params.Color != nil {
+		color, err := colorValue(*params.Color)
+		if err != nil {
+			return nil, err
+		}
+		l.Color = color
+		l.UpdatedAt = time.Now()
+		if err := s.labels.Update(ctx, l); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil, ErrLabelNotFound
+			}
+			return nil, fmt.Errorf("failed to update label: %w", err)
+		}
+	}
+
+	return s.getLabel(ctx, userID, id)
+}
+
+// DeleteLabel removes a label of userID's mailbox and the labels nested in
+// it, and takes them off their emails. The emails stay in the mailbox.
+func (s *EmailService) DeleteLabel(ctx context.Context, userID, id string) error {
+	l, err := s.getLabel(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if label.IsSystem(l.Name) {
+		return ErrLabelSystem
+	}
+
+	if err := s.labels.Delete(ctx, userID, l.Name); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrLabelNotFound
+		}
+		return fmt.Errorf("failed to delete label: %w", err)
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("delete_label", "success").Inc()
+	return nil
+}
+
+// modifyWriteAttempts bounds how often the changes of ModifyEmail are
+// reapplied after losing the race to another writer
+const modifyWriteAttempts = 5
+
+// ModifyEmail changes the flags and labels of an email in a mailbox userID
+// has access to. Added labels must exist in the mailbox; the drafts and
+// sent labels follow the email and cannot be changed by hand. The label
+// counters change with the email. The changes are stored over the version
+// of the email they were applied to, and reapplied to the email as stored
+// when another write came first.
+func (s *EmailService) ModifyEmail(ctx context.Context, userID, emailID string, changes EmailChanges) (*email.Email, error) {
+	e, err := s.repo.Get(ctx, emailID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrEmailNotFound
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	if err := s.checkEmailAccess(ctx, userID, e); err != nil {
+		return nil, err
+	}
+	// Emails stored before emails were filed in mailboxes have no labels
+	if e.Mailbox == "" && (len(changes.AddLabels) > 0 || len(changes.RemoveLabels) > 0) {
+		return nil, fmt.Errorf("%w: email is not in a mailbox", ErrInvalidLabel)
+	}
+
+	if len(changes.AddLabels) > 0 || len(changes.RemoveLabels) > 0 {
+		existing, err := s.labels.List(ctx, &label.ListQuery{Owner: e.Mailbox})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list labels: %w", err)
+		}
+		for _, name := range append(append([]string(nil), changes.AddLabels...), changes.RemoveLabels...) {
+			if name == label.Drafts || name == label.Sent {
+				return nil, fmt.Errorf("%w: the %s label cannot be changed", ErrInvalidLabel, name)
+			}
+		}
+		for _, name := range changes.AddLabels {
+			if findLabel(existing, name) == nil && !label.IsSystem(name) {
+				return nil, fmt.Errorf("%w: unknown label %s", ErrInvalidLabel, name)
+			}
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		changes.apply(e)
+		e.UpdatedAt = time.Now()
+		err := s.repo.ReplaceVersion(ctx, e, e.Version)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrEmailNotFound
+		}
+		if !errors.Is(err, storage.ErrConflict) || attempt == modifyWriteAttempts {
+			return nil, fmt.Errorf("failed to update email: %w", err)
+		}
+
+		if e, err = s.repo.Get(ctx, emailID); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil, ErrEmailNotFound
+			}
+			return nil, fmt.Errorf("failed to get email: %w", err)
+		}
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("modify", "success").Inc()
+	return e, nil
+}
+
+// apply makes the changes to e
+func (changes EmailChanges) apply(e *email.Email) {
+	for _, name := range changes.AddLabels {
+		e.Labels = withLabel(e.Labels, name)
+	}
+	for _, name := range changes.RemoveLabels {
+		e.Labels = withoutLabel(e.Labels, name)
+	}
+	if changes.IsRead != nil {
+		e.Flags.IsRead = *changes.IsRead
+	}
+	if changes.IsStarred != nil {
+		e.Flags.IsStarred = *changes.IsStarred
+	}
+}
+
+// getLabel returns a label of userID's mailbox
+func (s *EmailService) getLabel(ctx context.Context, userID, id string) (*label.Label, error) {
+	l, err := s.labels.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrLabelNotFound
+		}
+		return nil, fmt.Errorf("failed to get label: %w", err)
+	}
+	if l.Owner != userID {
+		return nil, ErrLabelNotFound
+	}
+	return l, nil
+}
+
+// checkParent fails unless the label name is nested in exists in userID's
+// mailbox
+func (s *EmailService) checkParent(ctx context.Context, userID, name string) error {
+	parent := label.Parent(name)
+	if parent == "" {
+		return nil
+	}
+
+	labels, err := s.labels.List(ctx, &label.ListQuery{Owner: userID})
+	if err != nil {
+		return fmt.Errorf("failed to list labels: %w", err)
+	}
+	if findLabel(labels, parent) == nil {
+		return fmt.Errorf("%w: parent label %s does not exist", ErrInvalidLabel, parent)
+	}
+	return nil
+}
+
+func findLabel(labels []*label.Label, name string) *label.Label {
+	for _, l := range labels {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// labelName trims the levels of a label name and checks none is empty
+func labelName(name string) (string, error) {
+	levels := strings.Split(name, label.Separator)
+	for i, level := range levels {
+		levels[i] = strings.TrimSpace(level)
+		if levels[i] == "" {
+			return "", fmt.Errorf("%w: empty label name", ErrInvalidLabel)
+		}
+		if strings.IndexFunc(levels[i], unicode.IsControl) >= 0 {
+			return "", fmt.Errorf("%w: control characters in label name", ErrInvalidLabel)
+		}
+	}
+
+	name = strings.Join(levels, label.Separator)
+	if len(name) > maxLabelName {
+		return "", fmt.Errorf("%w: label name longer than %d bytes", ErrInvalidLabel, maxLabelName)
+	}
+	return name, nil
+}
+
+func colorValue(color string) (string, error) {
+	if color == "" {
+		return "", nil
+	}
+	if !labelColor.MatchString(color) {
+		return "", fmt.Errorf("%w: color must be like #1a73e8", ErrInvalidLabel)
+	}
+	return strings.ToLower(color), nil
+}
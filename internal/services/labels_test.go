@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+)
+
+func TestModifyEmailKeepsConcurrentChanges(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	e := env.addEmail(t, alice, "bob@example.org", "alice@example.com")
+
+	// Another session stars the email after ModifyEmail read it
+	env.Email.repo = &racingEmails{
+		EmailRepository: env.repos.Email,
+		race: func() {
+			starred := true
+			if _, err := env.Email.ModifyEmail(ctx, alice.ID.Hex(), e.ID.Hex(), EmailChanges{IsStarred: &starred}); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	read := true
+	modified, err := env.Email.ModifyEmail(ctx, alice.ID.Hex(), e.ID.Hex(), EmailChanges{
+		IsRead:       &read,
+		AddLabels:    []string{label.Trash},
+		RemoveLabels: []string{LabelInbox},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := env.repos.Email.Get(ctx, e.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range []*email.Email{modified, stored} {
+		if !got.Flags.IsRead || !got.Flags.IsStarred {
+			t.Fatalf("flags = %+v, want read and starred", got.Flags)
+		}
+		if len(got.Labels) != 1 || got.Labels[0] != label.Trash {
+			t.Fatalf("labels = %v, want trash only", got.Labels)
+		}
+	}
+	if stored.Version != 2 {
+		t.Fatalf("version = %d, want 2", stored.Version)
+	}
+}
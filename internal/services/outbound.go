@@ -0,0 +1,271 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/bounce"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/jobs"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/transport"
+	"go.uber.org/zap"
+)
+
+// enqueueDelivery queues the delivery of a new outgoing email when
+// transports are configured. Failures are logged; the email is stored
+// already and its recipients stay queued.
+func (s *EmailService) enqueueDelivery(ctx context.Context, e *email.Email) {
+	if s.transports == nil || s.jobs == nil {
+		return
+	}
+
+	payload, err := json.Marshal(jobs.DeliveryPayload{EmailID: e.ID.Hex()})
+	if err == nil {
+		err = s.jobs.Enqueue(context.WithoutCancel(ctx), &jobs.Job{
+			Type:        jobs.TaskDeliverEmail,
+			Payload:     payload,
+			MaxAttempts: s.deliveryAttempts,
+			RunAt:       time.Now(),
+		})
+	}
+	if err != nil {
+		s.logger.Error("failed to enqueue delivery", zap.String("email_id", e.ID.Hex()), zap.Error(err))
+	}
+}
+
+// recipientAttempt is an attempt to deliver to a recipient, recorded once
+// every envelope was sent
+type recipientAttempt struct {
+	recipient string
+	attempt   email.DeliveryAttempt
+}
+
+// outbound is one SMTP envelope to send through a transport
+type outbound struct {
+	transport transport.Transport
+	envelope  transport.Envelope
+}
+
+// DeliverEmail is the job handler delivering the queued and deferred
+// recipients of a sent email. Recipients on staff get a copy filed in
+// their own mailbox, see deliverLocal; the others are sent through the
+// transport their route picks, one envelope per return path. The job
+// fails while recipients are deferred so that it is retried with backoff;
+// at the last attempt they are given up as bounced.
+func (s *EmailService) DeliverEmail(ctx context.Context, job *jobs.Job) error {
+	var payload jobs.DeliveryPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid delivery payload: %w", err)
+	}
+
+	e, err := s.repo.Get(ctx, payload.EmailID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get email: %w", err)
+	}
+
+	var pending []string
+	for _, delivery := range e.Delivery {
+		if delivery.Status == email.DeliveryQueued || delivery.Status == email.DeliveryDeferred {
+			pending = append(pending, delivery.Recipient)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	message, err := s.composeMessage(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	final := job.Attempts >= job.MaxAttempts
+	deferred := 0
+	var attempts []recipientAttempt
+	// record adds an attempt, given up as bounced at the last attempt when
+	// deferred, and returns the status recorded
+	record := func(recipient string, attempt email.DeliveryAttempt) string {
+		if attempt.Status == email.DeliveryDeferred {
+			if final {
+				attempt.Status = email.DeliveryBounced
+				attempt.Response = strings.TrimSpace(fmt.Sprintf("%s (gave up after %d attempts)", attempt.Response, job.Attempts))
+			} else {
+				deferred++
+			}
+		}
+		attempts = append(attempts, recipientAttempt{recipient: recipient, attempt: attempt})
+		s.metrics.EmailRequests.WithLabelValues("delivery_"+attempt.Status, "success").Inc()
+		return attempt.Status
+	}
+
+	var header mail.Header
+	var sends []*outbound
+	for _, recipient := range pending {
+		member, err := s.localRecipient(ctx, recipient)
+		if err != nil {
+			return err
+		}
+		if member != nil {
+			if header == nil {
+				parsed, err := mail.ReadMessage(bytes.NewReader(message))
+				if err != nil {
+					return fmt.Errorf("failed to parse composed message: %w", err)
+				}
+				header = parsed.Header
+			}
+			record(recipient, s.deliverLocal(ctx, e, header, int64(len(message)), member, recipient))
+			continue
+		}
+
+		t := s.transports.Route(e.From.Email, recipient)
+		if t == nil {
+			record(recipient, email.DeliveryAttempt{Status: email.DeliveryBounced, Response: "no transport routes mail to this recipient"})
+			continue
+		}
+		sends = addOutbound(sends, t, e.ID.Hex(), s.ReturnPath(e, recipient), recipient)
+	}
+
+	var bounced []transport.Result
+	for _, send := range sends {
+		for _, result := range send.transport.Send(ctx, send.envelope, message) {
+			if record(result.Recipient, deliveryAttempt(result)) == email.DeliveryBounced {
+				bounced = append(bounced, result)
+			}
+		}
+	}
+
+	e, changes, err := s.updateDelivery(ctx, e, func(e *email.Email, now time.Time) []email.RecipientDelivery {
+		changes := make([]email.RecipientDelivery, 0, len(attempts))
+		for _, a := range attempts {
+			changes = append(changes, addAttempt(e, a.recipient, a.attempt, now))
+		}
+		return changes
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	for _, result := range bounced {
+		s.hardBounce(ctx, e, strings.ToLower(result.Recipient), result.Code, result.Response)
+	}
+	s.notifyDelivery(ctx, e, changes)
+
+	if deferred > 0 {
+		return fmt.Errorf("delivery deferred for %d recipients", deferred)
+	}
+	return nil
+}
+
+// addOutbound adds recipient to the envelope of its transport and return
+// path, starting a new one if needed
+func addOutbound(sends []*outbound, t transport.Transport, emailID, returnPath, recipient string) []*outbound {
+	for _, send := range sends {
+		if send.transport == t && send.envelope.From == returnPath {
+			send.envelope.To = append(send.envelope.To, recipient)
+			return sends
+		}
+	}
+	return append(sends, &outbound{
+		transport: t,
+		envelope:  transport.Envelope{ID: emailID, From: returnPath, To: []string{recipient}},
+	})
+}
+
+// hardBounce suppresses a recipient rejected for good because of the
+// address itself, as its enhanced status code tells, and marks it bounced
+// in its campaign. Rejections without a code say too little to suppress.
+func (s *EmailService) hardBounce(ctx context.Context, e *email.Email, recipient, code, diagnostic string) {
+	status := bounce.Status{Action: bounce.ActionFailed, Code: code}
+	if code == "" || !status.HardBounce() {
+		return
+	}
+	s.suppress(ctx, e, recipient, diagnostic)
+	s.bounceCampaignRecipient(ctx, e, recipient, diagnostic)
+}
+
+// deliveryAttempt records the result of a transport
+func deliveryAttempt(result transport.Result) email.DeliveryAttempt {
+	status := email.DeliverySent
+	switch {
+	case result.Accepted:
+	case result.Permanent:
+		status = email.DeliveryBounced
+	default:
+		status = email.DeliveryDeferred
+	}
+	return email.DeliveryAttempt{
+		Status:    status,
+		Reply:     result.Reply,
+		Code:      result.Code,
+		Response:  result.Response,
+		RemoteMX:  result.Server,
+		TLS:       result.TLS,
+		StartedAt: result.StartedAt,
+		EndedAt:   result.EndedAt,
+	}
+}
+
+// localRecipient returns the staff member whose mailbox address is, or
+// nil when it is not a staff mailbox
+func (s *EmailService) localRecipient(ctx context.Context, address string) (*staff.Staff, error) {
+	member, err := s.staff.GetByEmail(ctx, address)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to look up recipient: %w", err)
+	}
+	return member, nil
+}
+
+// deliverLocal files a copy of a sent email in the mailbox of a recipient
+// on staff. The copy goes through the inbound path, so the recipient's
+// Sieve script, forwarding and out-of-office reply apply as they do to mail
+// from outside; only the spam filter is skipped. A copy filed already, as
+// when the sender is among the recipients, counts as delivered.
+func (s *EmailService) deliverLocal(ctx context.Context, e *email.Email, header mail.Header, size int64, member *staff.Staff, recipient string) email.DeliveryAttempt {
+	base := *e
+	base.ThreadID = nil
+	base.ThreadInfo = email.ThreadInfo{}
+	base.Draft = nil
+	base.Delivery = nil
+	base.DeliveryVersion = 0
+	base.Metadata = email.EmailMetadata{
+		AutoSubmitted:   e.Metadata.AutoSubmitted,
+		ListUnsubscribe: e.Metadata.ListUnsubscribe,
+		Loop:            e.Metadata.Loop,
+	}
+
+	msg := &InboundMessage{
+		Email:      &base,
+		Header:     header,
+		MailFrom:   e.From.Email,
+		Recipients: []string{recipient},
+		Size:       size,
+		Local:      true,
+	}
+	now := time.Now()
+	copied, actions, err := s.deliver(ctx, msg, &base, member, recipient)
+	switch {
+	case err != nil:
+		s.logger.Error("failed to deliver email locally", zap.String("to", recipient), zap.Error(err))
+		return email.DeliveryAttempt{Status: email.DeliveryDeferred, Response: err.Error(), StartedAt: now, EndedAt: time.Now()}
+	case actions.Rejected:
+		return email.DeliveryAttempt{Status: email.DeliveryBounced, Response: actions.RejectReason, StartedAt: now, EndedAt: time.Now()}
+	case copied == nil:
+		return email.DeliveryAttempt{Status: email.DeliveryDelivered, Response: "discarded by the recipient's Sieve script", StartedAt: now, EndedAt: time.Now()}
+	}
+	return email.DeliveryAttempt{Status: email.DeliveryDelivered, Response: "delivered to local mailbox", StartedAt: now, EndedAt: time.Now()}
+}
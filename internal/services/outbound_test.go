@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/jobs"
+)
+
+func deliveryJob(t *testing.T, e *email.Email) *jobs.Job {
+	t.Helper()
+
+	payload, err := json.Marshal(jobs.DeliveryPayload{EmailID: e.ID.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jobs.Job{Type: jobs.TaskDeliverEmail, Payload: payload, Attempts: 1, MaxAttempts: 3}
+}
+
+func TestDeliverEmailFilesCopyForLocalRecipient(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	bob := env.addStaff(t, "bob@example.com")
+
+	sent, err := env.Email.SendEmail(ctx, SendEmailParams{
+		From:    alice.ID.Hex(),
+		To:      []string{"Bob@example.com"},
+		Subject: "Lunch",
+		Content: email.EmailContent{Text: "Noon at the usual place?"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Email.DeliverEmail(ctx, deliveryJob(t, sent)); err != nil {
+		t.Fatal(err)
+	}
+
+	inbox, err := env.Email.ListEmails(ctx, bob.ID.Hex(), ListEmailsParams{Labels: []string{LabelInbox}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 {
+		t.Fatalf("bob's inbox has %d emails, want 1", len(inbox))
+	}
+	received := inbox[0]
+	if received.ID == sent.ID || received.Mailbox != bob.ID.Hex() {
+		t.Fatalf("received copy %s filed in %q, want a copy of its own in bob's mailbox", received.ID.Hex(), received.Mailbox)
+	}
+	if received.MessageID != sent.MessageID || received.From.Email != "alice@example.com" || received.Subject != "Lunch" {
+		t.Fatalf("received %q from %q: %q", received.MessageID, received.From.Email, received.Subject)
+	}
+	if received.Flags.IsRead || len(received.Delivery) != 0 {
+		t.Fatalf("received copy has flags %+v and delivery %+v of the sent copy", received.Flags, received.Delivery)
+	}
+
+	read, err := env.Email.GetEmail(ctx, bob.ID.Hex(), received.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Content.Text != "Noon at the usual place?" {
+		t.Fatalf("bob read %q", read.Content.Text)
+	}
+
+	// Each copy belongs to its own mailbox
+	if _, err := env.Email.GetEmail(ctx, alice.ID.Hex(), received.ID.Hex()); !errors.Is(err, ErrEmailAccessDenied) {
+		t.Fatalf("alice reading bob's copy: err = %v, want ErrEmailAccessDenied", err)
+	}
+	if _, err := env.Email.GetEmail(ctx, bob.ID.Hex(), sent.ID.Hex()); !errors.Is(err, ErrEmailAccessDenied) {
+		t.Fatalf("bob reading alice's copy: err = %v, want ErrEmailAccessDenied", err)
+	}
+	sentBox, err := env.Email.ListEmails(ctx, alice.ID.Hex(), ListEmailsParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sentBox) != 1 || sentBox[0].ID != sent.ID {
+		t.Fatalf("alice's mailbox = %v, want only the sent copy", sentBox)
+	}
+
+	delivery, err := env.Email.GetDelivery(ctx, alice.ID.Hex(), sent.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivery) != 1 || delivery[0].Status != email.DeliveryDelivered {
+		t.Fatalf("delivery = %+v, want bob delivered", delivery)
+	}
+
+	// Delivering again files no second copy
+	if err := env.Email.DeliverEmail(ctx, deliveryJob(t, sent)); err != nil {
+		t.Fatal(err)
+	}
+	if inbox, err = env.Email.ListEmails(ctx, bob.ID.Hex(), ListEmailsParams{}); err != nil || len(inbox) != 1 {
+		t.Fatalf("bob's mailbox has %d emails after a second delivery (err %v), want 1", len(inbox), err)
+	}
+}
+
+func TestDeliverEmailBouncesLocalSieveReject(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	bob := env.addStaff(t, "bob@example.com")
+	bob.Mailbox.Sieve = &staff.SieveScript{Script: `require "reject"; reject "Not accepting mail";`}
+	if err := env.repos.Staff.Update(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err := env.Email.SendEmail(ctx, SendEmailParams{
+		From:    alice.ID.Hex(),
+		To:      []string{"bob@example.com"},
+		Subject: "Lunch",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Email.DeliverEmail(ctx, deliveryJob(t, sent)); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery, err := env.Email.GetDelivery(ctx, alice.ID.Hex(), sent.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivery) != 1 || delivery[0].Status != email.DeliveryBounced || delivery[0].Diagnostic != "Not accepting mail" {
+		t.Fatalf("delivery = %+v, want bob bounced with the reject reason", delivery)
+	}
+	if inbox, _ := env.Email.ListEmails(ctx, bob.ID.Hex(), ListEmailsParams{}); len(inbox) != 0 {
+		t.Fatalf("bob's mailbox has %d emails, want none", len(inbox))
+	}
+}
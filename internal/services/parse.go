@@ -0,0 +1,228 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/google/uuid"
+)
+
+// maxMIMEDepth bounds the nesting of multipart entities parsed
+const maxMIMEDepth = 10
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// ParseMessage parses a raw RFC 822 message into an inbound message for
+// Receive; the transport it arrived on fills in the envelope. The first
+// text/plain and text/html parts outside attachments are the content;
+// every other leaf part, such as the reports of a bounce, is an
+// attachment. A missing Message-ID is made up so that replies can
+// reference the message.
+func ParseMessage(raw []byte) (*InboundMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEmail, err)
+	}
+
+	e := &email.Email{
+		MessageID:  strings.TrimSpace(msg.Header.Get("Message-Id")),
+		Subject:    decodeHeader(msg.Header.Get("Subject")),
+		InReplyTo:  strings.TrimSpace(msg.Header.Get("In-Reply-To")),
+		References: strings.Fields(msg.Header.Get("References")),
+		To:         participants(msg.Header, "To"),
+		CC:         participants(msg.Header, "Cc"),
+		ReplyTo:    participants(msg.Header, "Reply-To"),
+	}
+	if from := participants(msg.Header, "From"); len(from) > 0 {
+		e.From = from[0]
+	}
+	if e.MessageID == "" {
+		e.MessageID = fmt.Sprintf("<%s@%s>", uuid.New().String(), senderDomain(e.From.Email))
+	}
+
+	parsed := &InboundMessage{Email: e, Header: msg.Header, Size: int64(len(raw))}
+	if err := parseEntity(parsed, mimeHeader(msg.Header), msg.Body, 0); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEmail, err)
+	}
+	return parsed, nil
+}
+
+// parseEntity adds the content or attachments of a MIME entity to msg
+func parseEntity(msg *InboundMessage, header mimeHeader, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.get("Content-Disposition"))
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMIMEDepth {
+			return fmt.Errorf("MIME entities nested deeper than %d", maxMIMEDepth)
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read MIME part: %w", err)
+			}
+			if err := parseEntity(msg, mimeHeader(part.Header), part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(body, header.get("Content-Transfer-Encoding")))
+	if err != nil {
+		return fmt.Errorf("failed to decode MIME part: %w", err)
+	}
+
+	if disposition != "attachment" {
+		content := &msg.Email.Content
+		switch {
+		case mediaType == "text/plain" && content.Text == "":
+			content.Text = decodeCharset(data, params["charset"])
+			return nil
+		case mediaType == "text/html" && content.HTML == "":
+			content.HTML = decodeCharset(data, params["charset"])
+			return nil
+		}
+	}
+
+	filename := decodeHeader(dispositionParams["filename"])
+	if filename == "" {
+		filename = decodeHeader(params["name"])
+	}
+	if filename == "" {
+		filename = fmt.Sprintf("part-%d", len(msg.Attachments)+1)
+		if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+			filename += extensions[0]
+		}
+	}
+	msg.Attachments = append(msg.Attachments, email.AttachmentInput{
+		Filename:    filename,
+		Content:     bytes.NewReader(data),
+		ContentType: mime.FormatMediaType(mediaType, params),
+	})
+	return nil
+}
+
+// mimeHeader is the header of a MIME entity, with canonical keys
+type mimeHeader map[string][]string
+
+func (h mimeHeader) get(key string) string {
+	if values := h[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func decodeTransfer(body io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// Line breaks are not part of the encoding and are skipped
+		return base64.NewDecoder(base64.StdEncoding, &lineSkipper{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// lineSkipper drops CR and LF from what it reads
+type lineSkipper struct {
+	r io.Reader
+}
+
+func (l *lineSkipper) Read(p []byte) (int, error) {
+	for {
+		n, err := l.r.Read(p)
+		kept := 0
+		for _, b := range p[:n] {
+			if b != '\r' && b != '\n' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// participants parses an address list header. Malformed lists, which
+// are common in spam, yield the addresses that parse one by one.
+func participants(header mail.Header, key string) []email.Participant {
+	value := header.Get(key)
+	if value == "" {
+		return nil
+	}
+
+	parser := &mail.AddressParser{WordDecoder: headerDecoder}
+	addresses, err := parser.ParseList(value)
+	if err != nil {
+		addresses = addresses[:0]
+		for _, field := range strings.Split(value, ",") {
+			if address, err := parser.Parse(field); err == nil {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	result := make([]email.Participant, len(addresses))
+	for i, address := range addresses {
+		result[i] = email.Participant{Email: strings.ToLower(address.Address), FullName: address.Name}
+	}
+	return result
+}
+
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// decodeCharset converts text in charset to UTF-8. Only UTF-8 and Latin-1
+// are converted; text in other charsets is kept as is.
+func decodeCharset(data []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		return latin1(data)
+	}
+	if !utf8.Valid(data) {
+		return strings.ToValidUTF8(string(data), "�")
+	}
+	return string(data)
+}
+
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(latin1(data)), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/audit"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// LabelSecurity marks notices about quarantined attachments
+	LabelSecurity = "security"
+
+	auditActionQuarantine = "attachment.quarantine"
+)
+
+// markQuarantined flags e when any of its attachments is infected
+func markQuarantined(e *email.Email) bool {
+	for i := range e.Attachments {
+		if e.Attachments[i].Quarantined() {
+			e.Flags.IsQuarantined = true
+		}
+	}
+	return e.Flags.IsQuarantined
+}
+
+// notifyQuarantine records a quarantined email in the audit trail and
+// delivers a notice to the sender, when they have a mailbox here, and to
+// every active admin. Failures are logged; the email is stored already.
+func (s *EmailService) notifyQuarantine(ctx context.Context, e *email.Email, actorID string) {
+	ctx = context.WithoutCancel(ctx)
+
+	var infected []string
+	for _, attachment := range e.Attachments {
+		if attachment.Quarantined() {
+			infected = append(infected, fmt.Sprintf("%s (%s)", attachment.Filename, attachment.Scan.Signature))
+		}
+	}
+
+	s.logger.Warn("email quarantined",
+		zap.String("email_id", e.ID.Hex()),
+		zap.String("from", e.From.Email),
+		zap.Strings("attachments", infected),
+	)
+
+	if s.audit != nil {
+		event := &audit.Event{
+			ID:         uuid.New().String(),
+			Timestamp:  time.Now(),
+			ActorID:    actorID,
+			Action:     auditActionQuarantine,
+			Resource:   "email",
+			ResourceID: e.ID.Hex(),
+			Risk:       audit.RiskHigh,
+			Details: map[string]string{
+				"from":        e.From.Email,
+				"attachments": strings.Join(infected, ", "),
+			},
+		}
+		if err := s.audit.StoreAuditEvent(ctx, event); err != nil {
+			s.logger.Error("failed to store quarantine audit event", zap.Error(err))
+		}
+	}
+
+	recipients, err := s.quarantineRecipients(ctx, e)
+	if err != nil {
+		s.logger.Error("failed to list quarantine notice recipients", zap.Error(err))
+	}
+
+	for _, recipient := range recipients {
+		notice := quarantineNotice(e, recipient, infected)
+		if err := s.repo.Create(ctx, notice); err != nil {
+			s.logger.Error("failed to deliver quarantine notice",
+				zap.String("to", recipient.Email),
+				zap.Error(err),
+			)
+			continue
+		}
+		s.metrics.NotificationsSent.WithLabelValues("quarantine").Inc()
+	}
+}
+
+// quarantineRecipients returns the sender, if on staff, and all active
+// admins, each once
+func (s *EmailService) quarantineRecipients(ctx context.Context, e *email.Email) ([]*staff.Staff, error) {
+	seen := make(map[string]bool)
+	var recipients []*staff.Staff
+	add := func(member *staff.Staff) {
+		if member.Status != staff.StatusActive || seen[member.Email] {
+			return
+		}
+		seen[member.Email] = true
+		recipients = append(recipients, member)
+	}
+
+	sender, err := s.staff.GetByEmail(ctx, e.From.Email)
+	if err == nil {
+		add(sender)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return recipients, err
+	}
+
+	query := &staff.ListQuery{Role: staff.RoleAdmin, Status: staff.StatusActive, Limit: 100}
+	for {
+		admins, err := s.staff.List(ctx, query)
+		if err != nil {
+			return recipients, err
+		}
+		for _, admin := range admins {
+			add(admin)
+		}
+		if len(admins) < query.Limit {
+			return recipients, nil
+		}
+		query.Cursor = storage.StaffCursor(admins[len(admins)-1])
+	}
+}
+
+// quarantineNotice returns the notice about e filed in to's mailbox
+func quarantineNotice(e *email.Email, to *staff.Staff, infected []string) *email.Email {
+	now := time.Now()
+	domain := senderDomain(to.Email)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Attachments of the email %q from %s were found infected and quarantined:\n\n", e.Subject, e.From.Email)
+	for _, attachment := range infected {
+		fmt.Fprintf(&text, "  - %s\n", attachment)
+	}
+	fmt.Fprintf(&text, "\nThe email is kept with the flag isQuarantined. Quarantined attachments cannot be downloaded.\n\nEmail ID: %s\nMessage ID: %s\n", e.ID.Hex(), e.MessageID)
+
+	return &email.Email{
+		ID:        primitive.NewObjectID(),
+		MessageID: fmt.Sprintf("<%s@%s>", uuid.New().String(), domain),
+		From: email.Participant{
+			Email:    "postmaster@" + domain,
+			FullName: "Mail Security",
+		},
+		To:          []email.Participant{{Email: to.Email, FullName: to.FullName}},
+		Subject:     "Attachment quarantined: " + e.Subject,
+		Content:     email.EmailContent{Text: text.String()},
+		Attachments: []email.Attachment{},
+		Mailbox:     to.ID.Hex(),
+		Labels:      []string{LabelInbox, LabelSecurity},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/storage"
+)
+
+// maxReferences bounds the References of a reply. The oldest one is kept,
+// as it identifies the start of the thread, then the most recent ones.
+const maxReferences = 20
+
+// quoteDateLayout is the date format of attribution lines
+const quoteDateLayout = "Mon, Jan 2, 2006 at 3:04 PM"
+
+// ReplyParams is the response to an email
+type ReplyParams struct {
+	// From is the ID of the replying staff member
+	From    string
+	Content email.EmailContent
+	// To and CC are recipients added to the derived ones. Forwards have
+	// none derived, so To is required.
+	To []string
+	CC []string
+	// Uploads are R2 keys of attachments staged by the sender beforehand
+	Uploads []string
+}
+
+// Reply answers an email the sender has access to. The reply goes to the
+// Reply-To addresses of the email, or its sender; to the original
+// recipients when replying to one's own email. With all, the other To and
+// Cc recipients are copied. The original is quoted below the new content.
+func (s *EmailService) Reply(ctx context.Context, emailID string, all bool, params ReplyParams) (*email.Email, error) {
+	source, sender, err := s.responseSource(ctx, emailID, params.From)
+	if err != nil {
+		return nil, err
+	}
+
+	to, cc := replyRecipients(source, sender.Email, all)
+	to = append(to, params.To...)
+	cc = append(cc, params.CC...)
+	if len(to) == 0 {
+		if len(cc) == 0 {
+			return nil, fmt.Errorf("%w: the email has no one to reply to", ErrInvalidEmail)
+		}
+		to, cc = cc, nil
+	}
+
+	e, err := s.SendEmail(ctx, SendEmailParams{
+		From:    params.From,
+		To:      to,
+		CC:      cc,
+		Subject: prefixSubject("Re: ", source.Subject, replyPrefix),
+		Content: quoteReply(params.Content, source),
+		Uploads: params.Uploads,
+		Parent:  source,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	operation := "reply"
+	if all {
+		operation = "reply_all"
+	}
+	s.metrics.EmailRequests.WithLabelValues(operation, "success").Inc()
+	return e, nil
+}
+
+// Forward sends an email the sender has access to on to new recipients,
+// with its attachments and the original below the new content
+func (s *EmailService) Forward(ctx context.Context, emailID string, params ReplyParams) (*email.Email, error) {
+	source, _, err := s.responseSource(ctx, emailID, params.From)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := s.SendEmail(ctx, SendEmailParams{
+		From:    params.From,
+		To:      params.To,
+		CC:      params.CC,
+		Subject: prefixSubject("Fwd: ", source.Subject, forwardPrefix),
+		Content: quoteForward(params.Content, source),
+		Uploads: params.Uploads,
+		Forward: source.Attachments,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("forward", "success").Inc()
+	return e, nil
+}
+
+// responseSource loads the email replied to or forwarded and its responder
+func (s *EmailService) responseSource(ctx context.Context, emailID, userID string) (*email.Email, *staff.Staff, error) {
+	source, err := s.repo.Get(ctx, emailID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, ErrEmailNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get email: %w", err)
+	}
+	if source.Draft != nil {
+		return nil, nil, ErrEmailNotFound
+	}
+
+	if err := s.checkEmailAccess(ctx, userID, source); err != nil {
+		return nil, nil, err
+	}
+
+	sender, err := s.staff.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, fmt.Errorf("%w: unknown sender", ErrInvalidEmail)
+		}
+		return nil, nil, fmt.Errorf("failed to get sender: %w", err)
+	}
+	return source, sender, nil
+}
+
+// replyRecipients derives the recipients of a reply to e by self, as RFC
+// 5322 section 3.6.3 describes. Self is never a recipient and no one is
+// listed twice.
+func replyRecipients(e *email.Email, self string, all bool) (to, cc []string) {
+	seen := map[string]bool{strings.ToLower(self): true}
+	add := func(list []string, participants []email.Participant) []string {
+		for _, p := range participants {
+			address := strings.ToLower(p.Email)
+			if address == "" || seen[address] {
+				continue
+			}
+			seen[address] = true
+			list = append(list, (&mail.Address{Name: p.FullName, Address: address}).String())
+		}
+		return list
+	}
+
+	switch {
+	case len(e.ReplyTo) > 0:
+		to = add(to, e.ReplyTo)
+	case strings.EqualFold(e.From.Email, self):
+		// A reply to one's own email follows up with its recipients
+		to = add(to, e.To)
+	default:
+		to = add(to, []email.Participant{e.From})
+	}
+
+	if all {
+		to = add(to, e.To)
+		cc = add(cc, e.CC)
+	}
+	return to, cc
+}
+
+// setParent makes e a reply to parent, in its thread
+func setParent(e, parent *email.Email) {
+	e.InReplyTo = parent.MessageID
+
+	references := append([]string(nil), parent.References...)
+	if len(references) == 0 && parent.InReplyTo != "" {
+		references = []string{parent.InReplyTo}
+	}
+	references = append(references, parent.MessageID)
+	if len(references) > maxReferences {
+		references = append(references[:1:1], references[len(references)-maxReferences+1:]...)
+	}
+	e.References = references
+
+	if e.ThreadID == nil {
+		e.ThreadID = parent.ThreadID
+	}
+	root := parent.ThreadInfo.RootID
+	if root == "" {
+		root = parent.MessageID
+	}
+	e.ThreadInfo = email.ThreadInfo{
+		Depth:  parent.ThreadInfo.Depth + 1,
+		RootID: root,
+		Path:   append(append([]string(nil), parent.ThreadInfo.Path...), parent.MessageID),
+	}
+}
+
+// Subject prefixes of replies and forwards, including common localized
+// ones, with an optional counter such as "Re[2]:"
+var (
+	replyPrefix   = regexp.MustCompile(`(?i)^\s*(re|aw|sv|antw)(\[\d+\])?\s*:`)
+	forwardPrefix = regexp.MustCompile(`(?i)^\s*(fwd?|wg|tr)(\[\d+\])?\s*:`)
+)
+
+// prefixSubject adds prefix to subject unless it has one already
+func prefixSubject(prefix, subject string, existing *regexp.Regexp) string {
+	if existing.MatchString(subject) {
+		return subject
+	}
+	return prefix + subject
+}
+
+// quoteReply appends the quoted original to the content of a reply
+func quoteReply(content email.EmailContent, e *email.Email) email.EmailContent {
+	attribution := fmt.Sprintf("On %s, %s wrote:", e.CreatedAt.Format(quoteDateLayout), formatParticipant(e.From))
+
+	var text strings.Builder
+	text.WriteString(content.Text)
+	text.WriteString("\n\n")
+	text.WriteString(attribution)
+	text.WriteString("\n")
+	for _, line := range strings.Split(strings.TrimRight(plainText(e.Content), "\n"), "\n") {
+		// Quoted lines are not padded, so that nested quotes stay ">>"
+		if strings.HasPrefix(line, ">") || line == "" {
+			text.WriteString(">" + line + "\n")
+		} else {
+			text.WriteString("> " + line + "\n")
+		}
+	}
+
+	result := email.EmailContent{Text: text.String()}
+	if content.HTML != "" || e.Content.HTML != "" {
+		result.HTML = fmt.Sprintf(`%s<br><div class="quote"><div>%s</div><blockquote type="cite" style="margin:0 0 0 .8ex;border-left:1px solid #ccc;padding-left:1ex">%s</blockquote></div>`,
+			htmlContent(content), html.EscapeString(attribution), htmlContent(e.Content))
+	}
+	return result
+}
+
+// quoteForward appends the original with its headers to the content of a
+// forward
+func quoteForward(content email.EmailContent, e *email.Email) email.EmailContent {
+	headers := [][2]string{
+		{"From", formatParticipant(e.From)},
+		{"Date", e.CreatedAt.Format(quoteDateLayout)},
+		{"Subject", e.Subject},
+		{"To", formatParticipants(e.To)},
+	}
+	if len(e.CC) > 0 {
+		headers = append(headers, [2]string{"Cc", formatParticipants(e.CC)})
+	}
+
+	var text, htmlHeaders strings.Builder
+	text.WriteString(content.Text)
+	text.WriteString("\n\n---------- Forwarded message ---------\n")
+	for _, h := range headers {
+		fmt.Fprintf(&text, "%s: %s\n", h[0], h[1])
+		fmt.Fprintf(&htmlHeaders, "%s: %s<br>", h[0], html.EscapeString(h[1]))
+	}
+	text.WriteString("\n")
+	text.WriteString(plainText(e.Content))
+
+	result := email.EmailContent{Text: text.String()}
+	if content.HTML != "" || e.Content.HTML != "" {
+		result.HTML = fmt.Sprintf(`%s<br><div class="forward">---------- Forwarded message ---------<br>%s<br>%s</div>`,
+			htmlContent(content), htmlHeaders.String(), htmlContent(e.Content))
+	}
+	return result
+}
+
+var (
+	htmlBody  = regexp.MustCompile(`(?is)<body[^>]*>(.*)</body>`)
+	htmlBreak = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlTag   = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlDrop  = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+	blankRuns = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlContent returns content as an HTML fragment: the body of its HTML
+// part, or its text escaped
+func htmlContent(content email.EmailContent) string {
+	if content.HTML == "" {
+		return strings.ReplaceAll(html.EscapeString(content.Text), "\n", "<br>")
+	}
+	if m := htmlBody.FindStringSubmatch(content.HTML); m != nil {
+		return m[1]
+	}
+	return content.HTML
+}
+
+// plainText returns the text part of content, or its HTML part as text
+func plainText(content email.EmailContent) string {
+	if content.Text != "" || content.HTML == "" {
+		return content.Text
+	}
+	text := htmlDrop.ReplaceAllString(content.HTML, "")
+	text = htmlBreak.ReplaceAllString(text, "\n")
+	text = html.UnescapeString(htmlTag.ReplaceAllString(text, ""))
+	return strings.TrimSpace(blankRuns.ReplaceAllString(text, "\n\n"))
+}
+
+// formatParticipant formats a participant for display in quotes
+func formatParticipant(p email.Participant) string {
+	if p.FullName == "" {
+		return p.Email
+	}
+	return fmt.Sprintf("%s <%s>", p.FullName, p.Email)
+}
+
+func formatParticipants(participants []email.Participant) string {
+	formatted := make([]string, len(participants))
+	for i, p := range participants {
+		formatted[i] = formatParticipant(p)
+	}
+	return strings.Join(formatted, ", ")
+}
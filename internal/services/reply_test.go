@@ -0,0 +1,275 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+)
+
+func TestReplyRecipients(t *testing.T) {
+	const self = "alice@example.com"
+	bob := email.Participant{Email: "bob@example.org", FullName: "Bob"}
+	carol := email.Participant{Email: "carol@example.org"}
+	dave := email.Participant{Email: "Dave@Example.org", FullName: "Dave"}
+	alice := email.Participant{Email: "Alice@Example.com", FullName: "Alice"}
+	list := email.Participant{Email: "team@lists.example.org"}
+
+	tests := []struct {
+		name   string
+		e      *email.Email
+		all    bool
+		to, cc []string
+	}{
+		{
+			name: "sender",
+			e:    &email.Email{From: bob, To: []email.Participant{alice, carol}, CC: []email.Participant{dave}},
+			to:   []string{`"Bob" <bob@example.org>`},
+		},
+		{
+			name: "all",
+			e:    &email.Email{From: bob, To: []email.Participant{alice, carol}, CC: []email.Participant{dave}},
+			all:  true,
+			to:   []string{`"Bob" <bob@example.org>`, "<carol@example.org>"},
+			cc:   []string{`"Dave" <dave@example.org>`},
+		},
+		{
+			name: "reply-to over sender",
+			e:    &email.Email{From: bob, ReplyTo: []email.Participant{list}, To: []email.Participant{alice}},
+			to:   []string{"<team@lists.example.org>"},
+		},
+		{
+			// The sender is not copied when they asked for replies elsewhere
+			name: "reply-to with all",
+			e:    &email.Email{From: bob, ReplyTo: []email.Participant{list}, To: []email.Participant{alice, list, carol}},
+			all:  true,
+			to:   []string{"<team@lists.example.org>", "<carol@example.org>"},
+		},
+		{
+			name: "own email",
+			e:    &email.Email{From: alice, To: []email.Participant{bob, carol}, CC: []email.Participant{dave}},
+			to:   []string{`"Bob" <bob@example.org>`, "<carol@example.org>"},
+		},
+		{
+			name: "own email with all",
+			e:    &email.Email{From: alice, To: []email.Participant{bob, carol}, CC: []email.Participant{dave}},
+			all:  true,
+			to:   []string{`"Bob" <bob@example.org>`, "<carol@example.org>"},
+			cc:   []string{`"Dave" <dave@example.org>`},
+		},
+		{
+			name: "own email with reply-to",
+			e:    &email.Email{From: alice, ReplyTo: []email.Participant{list}, To: []email.Participant{bob}},
+			to:   []string{"<team@lists.example.org>"},
+		},
+		{
+			name: "own email to oneself",
+			e:    &email.Email{From: alice, To: []email.Participant{alice}},
+		},
+		{
+			// Addresses listed twice, in any case, get one copy
+			name: "duplicates",
+			e: &email.Email{
+				From: bob,
+				To:   []email.Participant{{Email: "BOB@example.org"}, carol, alice, {Email: "Carol@Example.org", FullName: "Carol"}},
+				CC:   []email.Participant{carol, bob, dave, {Email: "dave@example.org"}, {Email: "ALICE@example.com"}},
+			},
+			all: true,
+			to:  []string{`"Bob" <bob@example.org>`, "<carol@example.org>"},
+			cc:  []string{`"Dave" <dave@example.org>`},
+		},
+		{
+			name: "empty addresses",
+			e:    &email.Email{From: email.Participant{FullName: "Unknown"}, To: []email.Participant{{}, alice}},
+			all:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to, cc := replyRecipients(tt.e, self, tt.all)
+			if !reflect.DeepEqual(to, tt.to) || !reflect.DeepEqual(cc, tt.cc) {
+				t.Fatalf("to %q, cc %q, want to %q, cc %q", to, cc, tt.to, tt.cc)
+			}
+		})
+	}
+}
+
+func TestSetParent(t *testing.T) {
+	thread := "thread"
+	ids := func(prefix string, n int) []string {
+		list := make([]string, n)
+		for i := range list {
+			list[i] = fmt.Sprintf("<%s%d@example.org>", prefix, i)
+		}
+		return list
+	}
+	long := ids("m", maxReferences+5)
+
+	tests := []struct {
+		name       string
+		parent     *email.Email
+		references []string
+		info       email.ThreadInfo
+	}{
+		{
+			name:       "first reply",
+			parent:     &email.Email{MessageID: "<root@example.org>", ThreadID: &thread},
+			references: []string{"<root@example.org>"},
+			info:       email.ThreadInfo{Depth: 1, RootID: "<root@example.org>", Path: []string{"<root@example.org>"}},
+		},
+		{
+			// Some clients only set In-Reply-To
+			name:       "parent without references",
+			parent:     &email.Email{MessageID: "<m1@example.org>", InReplyTo: "<root@example.org>"},
+			references: []string{"<root@example.org>", "<m1@example.org>"},
+			info:       email.ThreadInfo{Depth: 1, RootID: "<m1@example.org>", Path: []string{"<m1@example.org>"}},
+		},
+		{
+			name: "reply to reply",
+			parent: &email.Email{
+				MessageID:  "<m2@example.org>",
+				InReplyTo:  "<m1@example.org>",
+				References: []string{"<root@example.org>", "<m1@example.org>"},
+				ThreadInfo: email.ThreadInfo{Depth: 2, RootID: "<root@example.org>", Path: []string{"<root@example.org>", "<m1@example.org>"}},
+			},
+			references: []string{"<root@example.org>", "<m1@example.org>", "<m2@example.org>"},
+			info: email.ThreadInfo{Depth: 3, RootID: "<root@example.org>",
+				Path: []string{"<root@example.org>", "<m1@example.org>", "<m2@example.org>"}},
+		},
+		{
+			// The first reference and the latest ones are kept
+			name: "long thread",
+			parent: &email.Email{
+				MessageID:  "<last@example.org>",
+				References: long,
+				ThreadInfo: email.ThreadInfo{Depth: len(long), RootID: long[0], Path: long},
+			},
+			references: append(append([]string{long[0]}, long[len(long)-maxReferences+2:]...), "<last@example.org>"),
+			info:       email.ThreadInfo{Depth: len(long) + 1, RootID: long[0], Path: append(append([]string(nil), long...), "<last@example.org>")},
+		},
+		{
+			name: "exactly the limit",
+			parent: &email.Email{
+				MessageID:  "<last@example.org>",
+				References: long[:maxReferences-1],
+				ThreadInfo: email.ThreadInfo{Depth: 1, RootID: long[0], Path: long[:1]},
+			},
+			references: append(append([]string(nil), long[:maxReferences-1]...), "<last@example.org>"),
+			info:       email.ThreadInfo{Depth: 2, RootID: long[0], Path: []string{long[0], "<last@example.org>"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			references := append([]string(nil), tt.parent.References...)
+			path := append([]string(nil), tt.parent.ThreadInfo.Path...)
+
+			var e email.Email
+			setParent(&e, tt.parent)
+			if e.InReplyTo != tt.parent.MessageID {
+				t.Fatalf("in reply to %q, want %q", e.InReplyTo, tt.parent.MessageID)
+			}
+			if !reflect.DeepEqual(e.References, tt.references) {
+				t.Fatalf("references = %q, want %q", e.References, tt.references)
+			}
+			if len(e.References) > maxReferences {
+				t.Fatalf("%d references, want at most %d", len(e.References), maxReferences)
+			}
+			if !reflect.DeepEqual(e.ThreadInfo, tt.info) {
+				t.Fatalf("thread info = %+v, want %+v", e.ThreadInfo, tt.info)
+			}
+			if e.ThreadID != tt.parent.ThreadID {
+				t.Fatalf("thread ID = %v, want the parent's", e.ThreadID)
+			}
+			// The parent is left as it was
+			if !reflect.DeepEqual(tt.parent.References, references) || !reflect.DeepEqual(tt.parent.ThreadInfo.Path, path) {
+				t.Fatalf("parent changed to %q, path %q", tt.parent.References, tt.parent.ThreadInfo.Path)
+			}
+		})
+	}
+
+	// A reply already in a thread stays in it
+	own := "own"
+	e := email.Email{ThreadID: &own}
+	setParent(&e, &email.Email{MessageID: "<root@example.org>", ThreadID: &thread})
+	if e.ThreadID != &own {
+		t.Fatalf("thread ID = %q, want the reply's own", *e.ThreadID)
+	}
+}
+
+func TestQuoteReply(t *testing.T) {
+	created := time.Date(2026, 10, 16, 14, 5, 0, 0, time.UTC)
+	const attribution = "On Fri, Oct 16, 2026 at 2:05 PM, Bob <bob@example.org> wrote:"
+	original := func(content email.EmailContent) *email.Email {
+		return &email.Email{
+			From:      email.Participant{Email: "bob@example.org", FullName: "Bob"},
+			CreatedAt: created,
+			Content:   content,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		content  email.EmailContent
+		original email.EmailContent
+		text     string
+		html     []string
+	}{
+		{
+			name:     "text",
+			content:  email.EmailContent{Text: "Thanks"},
+			original: email.EmailContent{Text: "Figures attached\n\nBob\n\n"},
+			text:     "Thanks\n\n" + attribution + "\n> Figures attached\n>\n> Bob\n",
+		},
+		{
+			// Quotes in the original nest without padding
+			name:     "nested",
+			content:  email.EmailContent{Text: "Agreed"},
+			original: email.EmailContent{Text: "Sure\n\n> Can we meet?\n>> Is Monday fine?\n>\n> Carol"},
+			text:     "Agreed\n\n" + attribution + "\n> Sure\n>\n>> Can we meet?\n>>> Is Monday fine?\n>>\n>> Carol\n",
+		},
+		{
+			name:     "html original",
+			content:  email.EmailContent{Text: "Thanks"},
+			original: email.EmailContent{HTML: "<html><head><style>p{}</style></head><body><p>Figures &amp; notes</p><p>Bob</p></body></html>"},
+			text:     "Thanks\n\n" + attribution + "\n> Figures & notes\n> Bob\n",
+			html: []string{
+				"Thanks<br>",
+				"<div>On Fri, Oct 16, 2026 at 2:05 PM, Bob &lt;bob@example.org&gt; wrote:</div>",
+				`<blockquote type="cite" style="margin:0 0 0 .8ex;border-left:1px solid #ccc;padding-left:1ex"><p>Figures &amp; notes</p><p>Bob</p></blockquote>`,
+			},
+		},
+		{
+			name:     "html reply",
+			content:  email.EmailContent{Text: "Thanks", HTML: "<p>Thanks</p>"},
+			original: email.EmailContent{Text: "a < b\nc"},
+			text:     "Thanks\n\n" + attribution + "\n> a < b\n> c\n",
+			html:     []string{"<p>Thanks</p><br>", "a &lt; b<br>c</blockquote>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quoted := quoteReply(tt.content, original(tt.original))
+			if quoted.Text != tt.text {
+				t.Fatalf("text = %q, want %q", quoted.Text, tt.text)
+			}
+			if tt.html == nil && quoted.HTML != "" {
+				t.Fatalf("html = %q, want none", quoted.HTML)
+			}
+			for _, want := range tt.html {
+				if !strings.Contains(quoted.HTML, want) {
+					t.Fatalf("html = %q, want it to contain %q", quoted.HTML, want)
+				}
+			}
+		})
+	}
+
+	// Replying to a reply quotes the whole conversation once more
+	first := quoteReply(email.EmailContent{Text: "Sure"}, original(email.EmailContent{Text: "Can we meet?"}))
+	second := quoteReply(email.EmailContent{Text: "Agreed"}, original(first))
+	if !strings.Contains(second.Text, "\n> Sure\n>\n> "+attribution+"\n>> Can we meet?\n") {
+		t.Fatalf("text = %q", second.Text)
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/storage"
+)
+
+// ErrSearchUnavailable is returned by SearchEmails when the storage
+// backend has no full-text search
+var ErrSearchUnavailable = errors.New("email search is not available with this storage backend")
+
+// SearchParams narrows an email search to a mailbox and its labels
+type SearchParams struct {
+	Text string
+	// Mailbox is the staff ID of the mailbox searched, the caller's own
+	// when empty
+	Mailbox string
+	// Labels must all be present on the emails found
+	Labels []string
+	Limit  int
+}
+
+// SearchEmails returns the emails of a mailbox userID has access to that
+// match the search text, best match first. It searches with the storage
+// backend's native full-text search.
+func (s *EmailService) SearchEmails(ctx context.Context, userID string, params SearchParams) ([]*email.Email, error) {
+	text := strings.TrimSpace(params.Text)
+	if text == "" {
+		return nil, fmt.Errorf("%w: search text is required", ErrInvalidEmail)
+	}
+
+	searcher, ok := s.repo.(storage.EmailSearcher)
+	if !ok {
+		return nil, ErrSearchUnavailable
+	}
+
+	mailbox := params.Mailbox
+	if mailbox == "" {
+		mailbox = userID
+	}
+	if err := s.checkMailboxAccess(ctx, userID, mailbox); err != nil {
+		return nil, err
+	}
+
+	results, err := searcher.Search(ctx, text, &email.ListQuery{
+		Mailbox: mailbox,
+		Labels:  params.Labels,
+		Limit:   params.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search emails: %w", err)
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("search", "success").Inc()
+	return results, nil
+}
@@ -1,32 +1,231 @@
 package services
 
+import (
+    "net"
+    "time"
+
+    "github.com/bezata/blockchainml-email/internal/antivirus"
+    "github.com/bezata/blockchainml-email/internal/bounce"
+    "github.com/bezata/blockchainml-email/internal/config"
+    "github.com/bezata/blockchainml-email/internal/jobs"
+    "github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+    "github.com/bezata/blockchainml-email/internal/security"
+    "github.com/bezata/blockchainml-email/internal/spamfilter"
+    "github.com/bezata/blockchainml-email/internal/storage"
+    "github.com/bezata/blockchainml-email/internal/storage/r2"
+    "github.com/bezata/blockchainml-email/internal/transport"
+    "github.com/bezata/blockchainml-email/pkg/cache"
+    "github.com/bezata/blockchainml-email/pkg/realtime"
+    "github.com/bezata/blockchainml-email/pkg/search"
+    "github.com/redis/go-redis/v9"
+    "go.uber.org/zap"
+)
+
+// Config holds the dependencies shared by all services
+type Config struct {
+    Repositories *storage.Repositories
+    // Redis holds state shared by replicas, such as automatic replies sent
+    Redis        *redis.Client
+    Cache        *cache.Cache
+    Search       *search.SearchEngine
+    Notifier     *realtime.Notifier
+    // Cloudflare opens the messages Email Workers forward, nil when no
+    // encryption key is configured
+    Cloudflare   CloudflareDecrypter
+    R2           *r2.Client
+    Config       *config.Config
+    Logger       *zap.Logger
+    Metrics      *metrics.Metrics
+}
+
 type Services struct {
-    Email *EmailService
-    Staff *StaffService
-    Auth  *AuthService
+    Email       *EmailService
+    Attachments *AttachmentService
+    Staff       *StaffService
+    Auth        *AuthService
+    Templates   *TemplateService
+    Campaigns   *CampaignService
+    // Worker runs background jobs such as campaign batches
+    Worker      *Worker
+    // Transports deliver outbound mail, nil when none is configured
+    Transports  *transport.Router
 }
 
 func New(cfg Config) *Services {
+    var scanner *antivirus.Client
+    if cfg.Config.Antivirus.Enabled {
+        scanner = antivirus.NewClient(antivirus.Config{
+            Network: cfg.Config.Antivirus.Network,
+            Address: cfg.Config.Antivirus.Address,
+            Timeout: time.Duration(cfg.Config.Antivirus.Timeout),
+        })
+    }
+
+    var replies ReplyTracker = NewMemoryReplyTracker()
+    var envelopes EnvelopeTracker = NewMemoryEnvelopeTracker()
+    if cfg.Redis != nil {
+        replies = NewRedisReplyTracker(cfg.Redis)
+        envelopes = NewRedisEnvelopeTracker(cfg.Redis)
+    }
+
+    var verp *bounce.VERP
+    if cfg.Config.Bounce.Domain != "" {
+        verp = &bounce.VERP{
+            Prefix: cfg.Config.Bounce.Prefix,
+            Domain: cfg.Config.Bounce.Domain,
+            Secret: []byte(cfg.Config.Bounce.Secret),
+        }
+    }
+
+    var transports *transport.Router
+    if len(cfg.Config.Delivery.Transports) > 0 {
+        transports = newTransports(cfg.Config)
+    }
+
+    attachments := NewAttachmentService(AttachmentServiceConfig{
+        R2:           cfg.R2,
+        Blobs:        cfg.Repositories.Blobs,
+        Scanner:      scanner,
+        ScanFailOpen: cfg.Config.Antivirus.FailOpen,
+        MaxSize:      cfg.Config.Server.MaxRequestSize,
+        Logger:       cfg.Logger,
+        Metrics:      cfg.Metrics,
+    })
+
+    templates := NewTemplateService(TemplateServiceConfig{
+        Repo:    cfg.Repositories.Templates,
+        Staff:   cfg.Repositories.Staff,
+        Logger:  cfg.Logger,
+        Metrics: cfg.Metrics,
+    })
+
+    emails := NewEmailService(EmailServiceConfig{
+        Repo:        cfg.Repositories.Email,
+        Staff:       cfg.Repositories.Staff,
+        Audit:       cfg.Repositories.Audit,
+        Attachments: attachments,
+        Templates:   templates,
+        Spam: spamfilter.NewFilter(cfg.Repositories.Spam, net.DefaultResolver, spamfilter.Config{
+            Threshold:    cfg.Config.Spam.Threshold,
+            BayesWeight:  cfg.Config.Spam.BayesWeight,
+            DNSBLScore:   cfg.Config.Spam.DNSBLScore,
+            DNSBLZones:   cfg.Config.Spam.DNSBLZones,
+            DNSBLTimeout: time.Duration(cfg.Config.Spam.DNSBLTimeout),
+            MinMessages:  cfg.Config.Spam.MinTrainedMessages,
+        }, cfg.Logger),
+        SpamEnabled: cfg.Config.Spam.Enabled,
+        Replies:     replies,
+        Suppressions: cfg.Repositories.Suppressions,
+        Campaigns:   cfg.Repositories.Campaigns,
+        Labels:      cfg.Repositories.Labels,
+        VERP:        verp,
+        Transports:  transports,
+        Jobs:        cfg.Repositories.Jobs,
+        DeliveryAttempts: cfg.Config.Delivery.MaxAttempts,
+        Cloudflare:  cfg.Cloudflare,
+        Envelopes:   envelopes,
+        Cache:       cfg.Cache,
+        Search:      cfg.Search,
+        Notifier:    cfg.Notifier,
+        Logger:      cfg.Logger,
+        Metrics:     cfg.Metrics,
+    })
+
+    campaigns := NewCampaignService(CampaignServiceConfig{
+        Repo:      cfg.Repositories.Campaigns,
+        Jobs:      cfg.Repositories.Jobs,
+        Staff:     cfg.Repositories.Staff,
+        Email:     emails,
+        Templates: templates,
+        Config:    cfg.Config.Campaign,
+        Logger:    cfg.Logger,
+        Metrics:   cfg.Metrics,
+    })
+
+    worker := NewWorker(WorkerConfig{
+        Queue:        cfg.Repositories.Jobs,
+        Workers:      cfg.Config.Jobs.Workers,
+        PollInterval: time.Duration(cfg.Config.Jobs.PollInterval),
+        Lease:        time.Duration(cfg.Config.Jobs.Lease),
+        Logger:       cfg.Logger,
+        Metrics:      cfg.Metrics,
+    })
+    worker.Handle(jobs.TaskSendCampaign, campaigns.SendBatch)
+    worker.Handle(jobs.TaskDeliverEmail, emails.DeliverEmail)
+
     return &Services{
-        Email: NewEmailService(EmailServiceConfig{
-            Repo:     cfg.Repositories.Email,
-            Cache:    cfg.Cache,
-            Search:   cfg.Search,
-            Notifier: cfg.Notifier,
-            Logger:   cfg.Logger,
-            Metrics:  cfg.Metrics,
-        }),
+        Email:       emails,
+        Attachments: attachments,
         Staff: NewStaffService(StaffServiceConfig{
             Repo:    cfg.Repositories.Staff,
             Cache:   cfg.Cache,
+            R2:      cfg.R2,
             Logger:  cfg.Logger,
             Metrics: cfg.Metrics,
         }),
         Auth: NewAuthService(AuthServiceConfig{
             Repo:    cfg.Repositories.Staff,
             Config:  cfg.Config.JWT,
+            Access:  security.NewAccessVerifier(cfg.Config.Security.Cloudflare),
             Logger:  cfg.Logger,
             Metrics: cfg.Metrics,
         }),
+        Templates: templates,
+        Campaigns: campaigns,
+        Worker:    worker,
+        Transports: transports,
+    }
+}
+
+// newTransports builds the outbound transports, routed in the configured
+// order
+func newTransports(cfg *config.Config) *transport.Router {
+    routes := make([]transport.Route, 0, len(cfg.Delivery.Transports))
+    for _, c := range cfg.Delivery.Transports {
+        var t transport.Transport
+        switch c.Type {
+        case config.TransportSMTP:
+            t = transport.NewSMTP(c.Name, transport.SMTPConfig{
+                Host:           c.SMTP.Host,
+                Port:           c.SMTP.Port,
+                Security:       c.SMTP.Security,
+                Auth:           c.SMTP.Auth,
+                Username:       c.SMTP.Username,
+                Password:       c.SMTP.Password,
+                PasswordFile:   c.SMTP.PasswordFile,
+                HeloName:       c.SMTP.HeloName,
+                MaxConnections: c.SMTP.MaxConnections,
+                IdleTimeout:    time.Duration(c.SMTP.IdleTimeout),
+                Timeout:        time.Duration(c.SMTP.Timeout),
+            })
+        case config.TransportHTTP, config.TransportCloudflare:
+            httpConfig := transport.HTTPConfig{
+                URL:           c.HTTP.URL,
+                Token:         c.HTTP.Token,
+                TokenFile:     c.HTTP.TokenFile,
+                Headers:       c.HTTP.Headers,
+                Timeout:       time.Duration(c.HTTP.Timeout),
+                Retries:       c.HTTP.Retries,
+                RetryDelay:    time.Duration(c.HTTP.RetryDelay),
+                WebhookSecret: c.HTTP.WebhookSecret,
+            }
+            if c.Type == config.TransportCloudflare {
+                if httpConfig.URL == "" {
+                    httpConfig.URL = "https://" + cfg.Cloudflare.WorkersDomain + "/send"
+                }
+                if httpConfig.Token == "" && httpConfig.TokenFile == "" {
+                    httpConfig.Token = cfg.Cloudflare.APIToken
+                }
+            }
+            t = transport.NewHTTP(c.Name, httpConfig)
+        default:
+            continue
+        }
+        routes = append(routes, transport.Route{
+            Transport:        t,
+            SenderDomains:    c.SenderDomains,
+            RecipientDomains: c.RecipientDomains,
+        })
     }
+    return transport.NewRouter(routes...)
 }
\ No newline at end of file
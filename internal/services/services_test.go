@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/storage/memory"
+	"github.com/bezata/blockchainml-email/internal/storage/r2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// testMetrics is shared by every test, as metrics register globally
+var testMetrics = metrics.NewMetrics("test")
+
+// testEnv is the services over in-memory storage. R2 points at
+// cfg.R2.Endpoint, which tests needing objects serve with newFakeR2.
+type testEnv struct {
+	*Services
+	repos *storage.Repositories
+}
+
+func newTestEnv(t *testing.T, configure ...func(cfg *config.Config)) *testEnv {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.Spam.DNSBLZones = nil
+	cfg.R2 = config.R2Config{Bucket: "test", AccessKeyID: "key", SecretAccessKey: "secret", Endpoint: "http://127.0.0.1:1"}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	client, err := r2.NewClient(r2.Config{
+		AccountID:  cfg.Cloudflare.AccountID,
+		AccessKey:  cfg.R2.AccessKeyID,
+		SecretKey:  cfg.R2.SecretAccessKey,
+		BucketName: cfg.R2.Bucket,
+		Endpoint:   cfg.R2.Endpoint,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := memory.NewRepositories()
+	return &testEnv{
+		Services: New(Config{
+			Repositories: repos,
+			R2:           client,
+			Config:       cfg,
+			Logger:       zap.NewNop(),
+			Metrics:      testMetrics,
+		}),
+		repos: repos,
+	}
+}
+
+// addStaff onboards an active member whose mailbox delegates may access
+func (env *testEnv) addStaff(t *testing.T, address string, delegates ...*staff.Staff) *staff.Staff {
+	t.Helper()
+
+	now := time.Now()
+	member := &staff.Staff{
+		ID:        primitive.NewObjectID(),
+		Email:     address,
+		FullName:  address,
+		Role:      staff.RoleMember,
+		Status:    staff.StatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, delegate := range delegates {
+		member.Mailbox.Delegates = append(member.Mailbox.Delegates, delegate.ID.Hex())
+	}
+	if err := env.repos.Staff.Create(context.Background(), member); err != nil {
+		t.Fatal(err)
+	}
+	return member
+}
+
+// addEmail stores a copy of an email from one address to others in the
+// mailbox of owner
+func (env *testEnv) addEmail(t *testing.T, owner *staff.Staff, from string, to ...string) *email.Email {
+	t.Helper()
+
+	now := time.Now()
+	e := &email.Email{
+		ID:        primitive.NewObjectID(),
+		MessageID: "<" + primitive.NewObjectID().Hex() + "@example.com>",
+		From:      email.Participant{Email: from},
+		Subject:   "Quarterly report",
+		Content:   email.EmailContent{Text: "See attached"},
+		Mailbox:   owner.ID.Hex(),
+		Labels:    []string{LabelInbox},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, address := range to {
+		e.To = append(e.To, email.Participant{Email: address})
+	}
+	if err := env.repos.Email.Create(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// fakeR2 is an in-memory S3 bucket serving the path-style object requests
+// of the R2 client: PUT, copy, GET, HEAD and DELETE
+type fakeR2 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeR2(t *testing.T) *fakeR2 {
+	t.Helper()
+
+	f := &fakeR2{objects: make(map[string][]byte)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// keys returns the stored object keys in order
+func (f *fakeR2) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeR2) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch r.Method {
+	case http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			src, _ = url.PathUnescape(src)
+			_, srcKey, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+			data, ok := f.objects[srcKey]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			f.objects[key] = append([]byte(nil), data...)
+			io.WriteString(w, `<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
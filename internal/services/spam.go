@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+	"github.com/bezata/blockchainml-email/internal/spamfilter"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.uber.org/zap"
+)
+
+// FilterSpam classifies an inbound email before it is stored, recording
+// the verdict in its metadata and moving spam from the inbox to the spam
+// label. The model of the first recipient on staff is used, or the global
+// one. A failing filter lets the email through unlabeled.
+func (s *EmailService) FilterSpam(ctx context.Context, e *email.Email, header mail.Header, clientIP string) {
+	if !s.spamEnabled {
+		return
+	}
+
+	owner, err := s.spamOwner(ctx, e)
+	if err != nil {
+		s.logger.Error("failed to find spam model owner", zap.String("message_id", e.MessageID), zap.Error(err))
+	}
+	s.filterSpam(ctx, owner, e, header, clientIP)
+}
+
+// filterSpam classifies e with owner's model
+func (s *EmailService) filterSpam(ctx context.Context, owner string, e *email.Email, header mail.Header, clientIP string) {
+	result, err := s.spam.Classify(ctx, owner, &spamfilter.Message{Email: e, Header: header, ClientIP: clientIP})
+	if err != nil {
+		s.logger.Error("failed to classify email", zap.String("message_id", e.MessageID), zap.Error(err))
+		return
+	}
+
+	e.Metadata.Spam = result
+	if result.IsSpam {
+		e.Labels = withoutLabel(e.Labels, LabelInbox)
+		e.Labels = withLabel(e.Labels, LabelSpam)
+	}
+	s.metrics.EmailRequests.WithLabelValues("spam_filter", spamStatus(result.IsSpam)).Inc()
+}
+
+// MarkSpam records userID's verdict on an email, labels it accordingly and
+// trains userID's and the global model. Changing a verdict forgets the
+// previous training first, so every user counts once per email.
+func (s *EmailService) MarkSpam(ctx context.Context, userID, emailID string, isSpam bool) (*email.Email, error) {
+	e, err := s.repo.Get(ctx, emailID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrEmailNotFound
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	if err := s.checkEmailAccess(ctx, userID, e); err != nil {
+		return nil, err
+	}
+
+	class := email.SpamClassHam
+	if isSpam {
+		class = email.SpamClassSpam
+	}
+
+	previous := e.Metadata.SpamFeedback[userID]
+	if previous != class {
+		if previous != "" {
+			if err := s.spam.Untrain(ctx, userID, e, previous == email.SpamClassSpam); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.spam.Train(ctx, userID, e, isSpam); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.Metadata.SpamFeedback == nil {
+		e.Metadata.SpamFeedback = make(map[string]string)
+	}
+	e.Metadata.SpamFeedback[userID] = class
+	if isSpam {
+		e.Labels = withoutLabel(e.Labels, LabelInbox)
+		e.Labels = withLabel(e.Labels, LabelSpam)
+	} else {
+		e.Labels = withoutLabel(e.Labels, LabelSpam)
+		if !hasLabel(e.Labels, LabelSent) {
+			e.Labels = withLabel(e.Labels, LabelInbox)
+		}
+	}
+
+	if err := s.repo.Update(ctx, e); err != nil {
+		return nil, fmt.Errorf("failed to update email: %w", err)
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("mark_"+class, "success").Inc()
+	return e, nil
+}
+
+// spamOwner returns the staff ID whose model classifies e
+func (s *EmailService) spamOwner(ctx context.Context, e *email.Email) (string, error) {
+	for _, recipient := range e.To {
+		member, err := s.staff.GetByEmail(ctx, recipient.Email)
+		if err == nil {
+			return member.ID.Hex(), nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return spam.GlobalModel, err
+		}
+	}
+	return spam.GlobalModel, nil
+}
+
+func spamStatus(isSpam bool) string {
+	if isSpam {
+		return email.SpamClassSpam
+	}
+	return email.SpamClassHam
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func withLabel(labels []string, label string) []string {
+	if hasLabel(labels, label) {
+		return labels
+	}
+	return append(labels, label)
+}
+
+func withoutLabel(labels []string, label string) []string {
+	result := labels[:0]
+	for _, l := range labels {
+		if l != label {
+			result = append(result, l)
+		}
+	}
+	return result
+}
@@ -0,0 +1,478 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/imaging"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/sieve"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/storage/r2"
+	"github.com/bezata/blockchainml-email/pkg/cache"
+	"go.uber.org/zap"
+)
+
+const (
+	// MaxProfilePhotoSize is the largest accepted upload before resizing
+	MaxProfilePhotoSize = 10 << 20
+	profilePhotoSize    = 256
+	profilePhotoURLTTL  = 15 * time.Minute
+
+	// defaultReplyDays is the interval between out-of-office replies to
+	// one sender that RFC 3834 recommends
+	defaultReplyDays = 7
+	maxReplyDays     = 30
+)
+
+var (
+	ErrStaffNotFound   = errors.New("staff member not found")
+	ErrStaffExists     = errors.New("a staff member with this email already exists")
+	ErrInvalidStaff    = errors.New("invalid staff member")
+	ErrInvalidPhoto    = errors.New("profile photo must be a JPEG, PNG or GIF image")
+	ErrNoManager       = errors.New("a manager is required to take over the mailbox")
+	ErrAlreadyOffboard = errors.New("staff member is already offboarded")
+	ErrInvalidSieve    = errors.New("invalid sieve script")
+	ErrNoSieveScript   = errors.New("no sieve script")
+	ErrInvalidOOO      = errors.New("invalid out-of-office reply")
+	ErrNoOOO           = errors.New("no out-of-office reply")
+)
+
+type StaffServiceConfig struct {
+	Repo    storage.StaffRepository
+	Cache   *cache.Cache
+	R2      *r2.Client
+	Logger  *zap.Logger
+	Metrics *metrics.Metrics
+}
+
+type StaffService struct {
+	repo    storage.StaffRepository
+	cache   *cache.Cache
+	r2      *r2.Client
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewStaffService(cfg StaffServiceConfig) *StaffService {
+	return &StaffService{
+		repo:    cfg.Repo,
+		cache:   cfg.Cache,
+		r2:      cfg.R2,
+		logger:  cfg.Logger,
+		metrics: cfg.Metrics,
+	}
+}
+
+// CreateStaff onboards a new staff member
+func (s *StaffService) CreateStaff(ctx context.Context, params staff.CreateStaffParams) (*staff.Staff, error) {
+	address, err := mail.ParseAddress(params.Email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid email address", ErrInvalidStaff)
+	}
+	if strings.TrimSpace(params.FullName) == "" {
+		return nil, fmt.Errorf("%w: full name is required", ErrInvalidStaff)
+	}
+
+	role := params.Role
+	if role == "" {
+		role = staff.RoleMember
+	}
+	if !validRole(role) {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidStaff, role)
+	}
+
+	email := strings.ToLower(address.Address)
+	if _, err := s.repo.GetByEmail(ctx, email); err == nil {
+		return nil, ErrStaffExists
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	if params.ManagerID != "" {
+		if _, err := s.GetStaff(ctx, params.ManagerID); err != nil {
+			return nil, fmt.Errorf("manager: %w", err)
+		}
+	}
+
+	now := time.Now()
+	member := &staff.Staff{
+		Email:      email,
+		FullName:   strings.TrimSpace(params.FullName),
+		Role:       role,
+		Department: params.Department,
+		Title:      params.Title,
+		ManagerID:  params.ManagerID,
+		Status:     staff.StatusActive,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.repo.Create(ctx, member); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			return nil, ErrStaffExists
+		}
+		return nil, err
+	}
+
+	s.logger.Info("staff member onboarded",
+		zap.String("staff_id", member.ID.Hex()),
+		zap.String("department", member.Department),
+	)
+	return member, nil
+}
+
+func (s *StaffService) GetStaff(ctx context.Context, id string) (*staff.Staff, error) {
+	member, err := s.repo.Get(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrStaffNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.withPhotoURL(ctx, member), nil
+}
+
+// ListStaff searches the directory by name or email, department, role and
+// status
+func (s *StaffService) ListStaff(ctx context.Context, query staff.ListQuery) ([]*staff.Staff, error) {
+	query.Limit = storage.PageSize(query.Limit)
+	members, err := s.repo.List(ctx, &query)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		s.withPhotoURL(ctx, member)
+	}
+	return members, nil
+}
+
+func (s *StaffService) UpdateStaff(ctx context.Context, id string, params staff.UpdateStaffParams) (*staff.Staff, error) {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.FullName != nil {
+		if strings.TrimSpace(*params.FullName) == "" {
+			return nil, fmt.Errorf("%w: full name is required", ErrInvalidStaff)
+		}
+		member.FullName = strings.TrimSpace(*params.FullName)
+	}
+	if params.Role != nil {
+		if !validRole(*params.Role) {
+			return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidStaff, *params.Role)
+		}
+		member.Role = *params.Role
+	}
+	if params.Department != nil {
+		member.Department = *params.Department
+	}
+	if params.Title != nil {
+		member.Title = *params.Title
+	}
+	if params.ManagerID != nil {
+		if *params.ManagerID == id {
+			return nil, fmt.Errorf("%w: a staff member cannot manage themselves", ErrInvalidStaff)
+		}
+		if *params.ManagerID != "" {
+			if _, err := s.GetStaff(ctx, *params.ManagerID); err != nil {
+				return nil, fmt.Errorf("manager: %w", err)
+			}
+		}
+		member.ManagerID = *params.ManagerID
+	}
+	if params.Status != nil {
+		switch *params.Status {
+		case staff.StatusActive, staff.StatusDisabled:
+			if member.Status == staff.StatusOffboarded {
+				return nil, ErrAlreadyOffboard
+			}
+			member.Status = *params.Status
+		default:
+			return nil, fmt.Errorf("%w: status must be active or disabled, use offboarding to remove access", ErrInvalidStaff)
+		}
+	}
+
+	member.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// DeleteStaff removes a staff member record and their profile photo
+func (s *StaffService) DeleteStaff(ctx context.Context, id string) error {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrStaffNotFound
+		}
+		return err
+	}
+
+	if key := member.ProfilePhoto.R2Key; key != "" {
+		if err := s.r2.Delete(ctx, key); err != nil {
+			s.logger.Warn("failed to delete profile photo", zap.String("key", key), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// UploadProfilePhoto resizes the image to a square thumbnail, stores it in
+// R2 and replaces the previous photo.
+func (s *StaffService) UploadProfilePhoto(ctx context.Context, id string, data []byte) (*staff.Staff, error) {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := imaging.Thumbnail(data, profilePhotoSize)
+	if err != nil {
+		if errors.Is(err, imaging.ErrUnsupportedImage) {
+			return nil, ErrInvalidPhoto
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	key := fmt.Sprintf("staff/%s/photo-%d.jpg", id, now.Unix())
+	if err := s.r2.Upload(ctx, key, bytes.NewReader(thumbnail), "image/jpeg"); err != nil {
+		return nil, err
+	}
+
+	previous := member.ProfilePhoto.R2Key
+	member.ProfilePhoto = staff.ProfilePhoto{R2Key: key, LastUpdated: now}
+	member.UpdatedAt = now
+	if err := s.repo.Update(ctx, member); err != nil {
+		return nil, err
+	}
+
+	if previous != "" {
+		if err := s.r2.Delete(ctx, previous); err != nil {
+			s.logger.Warn("failed to delete previous profile photo", zap.String("key", previous), zap.Error(err))
+		}
+	}
+
+	return s.withPhotoURL(ctx, member), nil
+}
+
+// GetSieveScript returns the Sieve script filtering id's inbound mail
+func (s *StaffService) GetSieveScript(ctx context.Context, id string) (*staff.SieveScript, error) {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if member.Mailbox.Sieve == nil {
+		return nil, ErrNoSieveScript
+	}
+	return member.Mailbox.Sieve, nil
+}
+
+// SetSieveScript validates script and makes it filter id's inbound mail,
+// replacing any previous script
+func (s *StaffService) SetSieveScript(ctx context.Context, id, script string) (*staff.SieveScript, error) {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := sieve.Compile(script); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSieve, err)
+	}
+
+	now := time.Now()
+	member.Mailbox.Sieve = &staff.SieveScript{Script: script, UpdatedAt: now}
+	member.UpdatedAt = now
+	if err := s.repo.Update(ctx, member); err != nil {
+		return nil, err
+	}
+	return member.Mailbox.Sieve, nil
+}
+
+// DeleteSieveScript stops filtering id's inbound mail
+func (s *StaffService) DeleteSieveScript(ctx context.Context, id string) error {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return err
+	}
+	if member.Mailbox.Sieve == nil {
+		return ErrNoSieveScript
+	}
+
+	member.Mailbox.Sieve = nil
+	member.UpdatedAt = time.Now()
+	return s.repo.Update(ctx, member)
+}
+
+// GetOutOfOffice returns id's out-of-office reply
+func (s *StaffService) GetOutOfOffice(ctx context.Context, id string) (*staff.OutOfOffice, error) {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if member.Mailbox.OutOfOffice == nil {
+		return nil, ErrNoOOO
+	}
+	return member.Mailbox.OutOfOffice, nil
+}
+
+// SetOutOfOffice validates and stores id's out-of-office reply. Days
+// defaults to seven.
+func (s *StaffService) SetOutOfOffice(ctx context.Context, id string, ooo staff.OutOfOffice) (*staff.OutOfOffice, error) {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if ooo.Start.IsZero() || !ooo.End.After(ooo.Start) {
+		return nil, fmt.Errorf("%w: end must be after start", ErrInvalidOOO)
+	}
+	if strings.TrimSpace(ooo.InternalMessage) == "" {
+		return nil, fmt.Errorf("%w: internal message is required", ErrInvalidOOO)
+	}
+	if ooo.Days == 0 {
+		ooo.Days = defaultReplyDays
+	}
+	if ooo.Days < 1 || ooo.Days > maxReplyDays {
+		return nil, fmt.Errorf("%w: days must be between 1 and %d", ErrInvalidOOO, maxReplyDays)
+	}
+
+	now := time.Now()
+	ooo.UpdatedAt = now
+	member.Mailbox.OutOfOffice = &ooo
+	member.UpdatedAt = now
+	if err := s.repo.Update(ctx, member); err != nil {
+		return nil, err
+	}
+	return member.Mailbox.OutOfOffice, nil
+}
+
+// DeleteOutOfOffice stops id's out-of-office replies
+func (s *StaffService) DeleteOutOfOffice(ctx context.Context, id string) error {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return err
+	}
+	if member.Mailbox.OutOfOffice == nil {
+		return ErrNoOOO
+	}
+
+	member.Mailbox.OutOfOffice = nil
+	member.UpdatedAt = time.Now()
+	return s.repo.Update(ctx, member)
+}
+
+// OffboardStaff disables login, forwards new mail and hands the mailbox and
+// direct reports over to a manager.
+func (s *StaffService) OffboardStaff(ctx context.Context, id string, params staff.OffboardParams) (*staff.Staff, error) {
+	member, err := s.GetStaff(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if member.Status == staff.StatusOffboarded {
+		return nil, ErrAlreadyOffboard
+	}
+
+	managerID := params.ManagerID
+	if managerID == "" {
+		managerID = member.ManagerID
+	}
+	if managerID == "" || managerID == id {
+		return nil, ErrNoManager
+	}
+	manager, err := s.GetStaff(ctx, managerID)
+	if err != nil {
+		return nil, fmt.Errorf("manager: %w", err)
+	}
+	if !manager.CanLogin() {
+		return nil, fmt.Errorf("%w: manager %s is not active", ErrInvalidStaff, managerID)
+	}
+
+	forwardTo := params.ForwardTo
+	if forwardTo == "" {
+		forwardTo = manager.Email
+	}
+	if _, err := mail.ParseAddress(forwardTo); err != nil {
+		return nil, fmt.Errorf("%w: invalid forwarding address", ErrInvalidStaff)
+	}
+
+	now := time.Now()
+	member.Status = staff.StatusOffboarded
+	member.Mailbox.ForwardTo = forwardTo
+	if !member.HasMailboxAccess(managerID) {
+		member.Mailbox.Delegates = append(member.Mailbox.Delegates, managerID)
+	}
+	member.Offboarding = &staff.Offboarding{
+		ManagerID:    managerID,
+		ForwardTo:    forwardTo,
+		OffboardedBy: params.OffboardedBy,
+		OffboardedAt: now,
+	}
+	member.UpdatedAt = now
+	if err := s.repo.Update(ctx, member); err != nil {
+		return nil, err
+	}
+
+	if err := s.reassignReports(ctx, id, managerID); err != nil {
+		s.logger.Error("failed to reassign direct reports",
+			zap.String("staff_id", id),
+			zap.String("manager_id", managerID),
+			zap.Error(err),
+		)
+	}
+
+	s.logger.Info("staff member offboarded",
+		zap.String("staff_id", id),
+		zap.String("manager_id", managerID),
+		zap.String("offboarded_by", params.OffboardedBy),
+	)
+	return member, nil
+}
+
+func (s *StaffService) reassignReports(ctx context.Context, fromID, toID string) error {
+	for {
+		reports, err := s.repo.List(ctx, &staff.ListQuery{ManagerID: fromID, Limit: storage.MaxPageSize})
+		if err != nil {
+			return err
+		}
+		if len(reports) == 0 {
+			return nil
+		}
+		for _, report := range reports {
+			report.ManagerID = toID
+			report.UpdatedAt = time.Now()
+			if err := s.repo.Update(ctx, report); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// withPhotoURL fills in a short lived signed URL for the profile photo
+func (s *StaffService) withPhotoURL(ctx context.Context, member *staff.Staff) *staff.Staff {
+	if member.ProfilePhoto.R2Key == "" {
+		return member
+	}
+
+	url, err := s.r2.PresignGet(ctx, member.ProfilePhoto.R2Key, profilePhotoURLTTL)
+	if err != nil {
+		s.logger.Warn("failed to sign profile photo URL", zap.String("staff_id", member.ID.Hex()), zap.Error(err))
+		return member
+	}
+	member.ProfilePhoto.URL = url
+	return member
+}
+
+func validRole(role string) bool {
+	return role == staff.RoleAdmin || role == staff.RoleMember
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+)
+
+func TestOffboardStaff(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	manager := env.addStaff(t, "manager@example.com")
+	alice := env.addStaff(t, "alice@example.com")
+	report := env.addStaff(t, "report@example.com")
+	alice.ManagerID = manager.ID.Hex()
+	report.ManagerID = alice.ID.Hex()
+	for _, member := range []*staff.Staff{alice, report} {
+		if err := env.repos.Staff.Update(ctx, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	offboarded, err := env.Staff.OffboardStaff(ctx, alice.ID.Hex(), staff.OffboardParams{OffboardedBy: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The mailbox goes to alice's manager, who also gets her new mail
+	if offboarded.Status != staff.StatusOffboarded || offboarded.CanLogin() {
+		t.Fatalf("status = %q, want offboarded", offboarded.Status)
+	}
+	if offboarded.Mailbox.ForwardTo != "manager@example.com" {
+		t.Fatalf("forwarding to %q, want the manager", offboarded.Mailbox.ForwardTo)
+	}
+	if !offboarded.HasMailboxAccess(manager.ID.Hex()) {
+		t.Fatalf("delegates = %v, want the manager", offboarded.Mailbox.Delegates)
+	}
+	if o := offboarded.Offboarding; o == nil || o.ManagerID != manager.ID.Hex() || o.ForwardTo != "manager@example.com" || o.OffboardedBy != "admin" {
+		t.Fatalf("offboarding = %+v", o)
+	}
+	reassigned, err := env.repos.Staff.Get(ctx, report.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reassigned.ManagerID != manager.ID.Hex() {
+		t.Fatalf("report's manager = %q, want alice's manager", reassigned.ManagerID)
+	}
+
+	if _, err := env.Staff.OffboardStaff(ctx, alice.ID.Hex(), staff.OffboardParams{}); !errors.Is(err, ErrAlreadyOffboard) {
+		t.Fatalf("err = %v, want ErrAlreadyOffboard", err)
+	}
+
+	// Mail to alice is filed in her mailbox and forwarded to the manager
+	result, err := env.Email.Receive(ctx, inboundMessage("<m1@example.org>", []string{"alice@example.com"}, "alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Delivered["alice@example.com"] == nil {
+		t.Fatalf("result = %+v, want alice's copy filed", result)
+	}
+	sent := env.sentBy(t, offboarded)
+	if len(sent) != 1 || len(sent[0].To) != 1 || sent[0].To[0].Email != "manager@example.com" {
+		t.Fatalf("forwarded %v, want one email to the manager", sent)
+	}
+	if err := env.Email.DeliverEmail(ctx, deliveryJob(t, sent[0])); err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := env.Email.ListEmails(ctx, manager.ID.Hex(), ListEmailsParams{Labels: []string{LabelInbox}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 {
+		t.Fatalf("manager's inbox has %d emails, want the forward", len(inbox))
+	}
+}
+
+func TestOffboardStaffNeedsActiveManager(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+	bob := env.addStaff(t, "bob@example.com")
+
+	// Alice has no manager of her own
+	if _, err := env.Staff.OffboardStaff(ctx, alice.ID.Hex(), staff.OffboardParams{}); !errors.Is(err, ErrNoManager) {
+		t.Fatalf("err = %v, want ErrNoManager", err)
+	}
+	if _, err := env.Staff.OffboardStaff(ctx, alice.ID.Hex(), staff.OffboardParams{ManagerID: alice.ID.Hex()}); !errors.Is(err, ErrNoManager) {
+		t.Fatalf("err = %v, want ErrNoManager for handing over to herself", err)
+	}
+
+	bob.Status = staff.StatusDisabled
+	if err := env.repos.Staff.Update(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Staff.OffboardStaff(ctx, alice.ID.Hex(), staff.OffboardParams{ManagerID: bob.ID.Hex()}); !errors.Is(err, ErrInvalidStaff) {
+		t.Fatalf("err = %v, want a disabled manager refused", err)
+	}
+
+	bob.Status = staff.StatusActive
+	if err := env.repos.Staff.Update(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Staff.OffboardStaff(ctx, alice.ID.Hex(), staff.OffboardParams{ManagerID: bob.ID.Hex(), ForwardTo: "not an address"}); !errors.Is(err, ErrInvalidStaff) {
+		t.Fatalf("err = %v, want an invalid forwarding address refused", err)
+	}
+
+	// Nothing was changed by the refused attempts
+	stored, err := env.repos.Staff.Get(ctx, alice.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != staff.StatusActive || stored.Mailbox.ForwardTo != "" || len(stored.Mailbox.Delegates) != 0 {
+		t.Fatalf("alice = %+v after refused offboarding", stored)
+	}
+
+	offboarded, err := env.Staff.OffboardStaff(ctx, alice.ID.Hex(), staff.OffboardParams{ManagerID: bob.ID.Hex(), ForwardTo: "archive@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offboarded.Mailbox.ForwardTo != "archive@example.com" || !offboarded.HasMailboxAccess(bob.ID.Hex()) {
+		t.Fatalf("mailbox = %+v, want forwarding to the archive and bob delegated", offboarded.Mailbox)
+	}
+}
+
+func TestAuthenticateChecksCurrentMember(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.JWT.Secret = "secret" })
+	manager := env.addStaff(t, "manager@example.com")
+	alice := env.addStaff(t, "alice@example.com")
+	alice.ManagerID = manager.ID.Hex()
+	if err := env.repos.Staff.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := env.Auth.issue(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := env.Auth.Authenticate(ctx, tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.UserID != alice.ID.Hex() || principal.Role != staff.RoleMember {
+		t.Fatalf("principal = %+v", principal)
+	}
+
+	// Within the cache lifetime the member is not read again
+	alice.Role = staff.RoleAdmin
+	if err := env.repos.Staff.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if principal, err := env.Auth.Authenticate(ctx, tokens.AccessToken); err != nil || principal.Role != staff.RoleMember {
+		t.Fatalf("principal = %+v, err = %v, want the cached role", principal, err)
+	}
+
+	// Once it expires, the current role applies to the old token
+	env.Auth.members = newMemberCache(0)
+	if principal, err := env.Auth.Authenticate(ctx, tokens.AccessToken); err != nil || principal.Role != staff.RoleAdmin {
+		t.Fatalf("principal = %+v, err = %v, want the current role", principal, err)
+	}
+
+	// Offboarding ends the session and refreshing it
+	if _, err := env.Staff.OffboardStaff(ctx, alice.ID.Hex(), staff.OffboardParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Auth.Authenticate(ctx, tokens.AccessToken); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("err = %v, want an offboarded member's token refused", err)
+	}
+	if _, err := env.Auth.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrLoginDisabled) {
+		t.Fatalf("err = %v, want refreshing refused", err)
+	}
+
+	// So does deleting the member
+	bob := env.addStaff(t, "bob@example.com")
+	tokens, err = env.Auth.issue(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.repos.Staff.Delete(ctx, bob.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Auth.Authenticate(ctx, tokens.AccessToken); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("err = %v, want a deleted member's token refused", err)
+	}
+}
+
+func TestMemberCacheExpires(t *testing.T) {
+	cache := newMemberCache(time.Hour)
+	cache.put("a", memberLogin{allowed: true, role: staff.RoleAdmin})
+	if login, ok := cache.get("a"); !ok || !login.allowed || login.role != staff.RoleAdmin {
+		t.Fatalf("get = %+v, %v", login, ok)
+	}
+	if _, ok := cache.get("b"); ok {
+		t.Fatal("found a member never put")
+	}
+
+	cache.members["a"] = memberLogin{allowed: true, expires: time.Now().Add(-time.Second)}
+	if _, ok := cache.get("a"); ok {
+		t.Fatal("found an expired member")
+	}
+
+	// A full cache drops expired entries first
+	for i := 0; i < maxCachedMembers; i++ {
+		cache.members[string(rune(i))] = memberLogin{expires: time.Now().Add(time.Hour)}
+	}
+	cache.members["a"] = memberLogin{expires: time.Now().Add(-time.Second)}
+	cache.put("new", memberLogin{allowed: true})
+	if _, ok := cache.members["a"]; ok || len(cache.members) > maxCachedMembers {
+		t.Fatalf("cache holds %d members, expired entry kept: %v", len(cache.members), ok)
+	}
+	if _, ok := cache.get("new"); !ok {
+		t.Fatal("new entry not stored")
+	}
+}
+
+// testImage encodes a width x height PNG, opaque red on the left half and
+// transparent on the right
+func testImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadProfilePhoto(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeR2(t)
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.R2.Endpoint = bucket.URL })
+	alice := env.addStaff(t, "alice@example.com")
+
+	member, err := env.Staff.UploadProfilePhoto(ctx, alice.ID.Hex(), testImage(t, 1024, 512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := member.ProfilePhoto.R2Key
+	if first == "" || member.ProfilePhoto.URL == "" {
+		t.Fatalf("profile photo = %+v", member.ProfilePhoto)
+	}
+
+	bucket.mu.Lock()
+	stored := bucket.objects[first]
+	bucket.mu.Unlock()
+	thumbnail, err := jpeg.Decode(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatalf("stored photo is no JPEG: %v", err)
+	}
+	// Scaled to fit the square, keeping the aspect ratio
+	if b := thumbnail.Bounds(); b.Dx() != profilePhotoSize || b.Dy() != profilePhotoSize/2 {
+		t.Fatalf("thumbnail is %dx%d, want %dx%d", b.Dx(), b.Dy(), profilePhotoSize, profilePhotoSize/2)
+	}
+	// The transparent half is flattened onto white
+	if r, g, b, _ := thumbnail.At(profilePhotoSize-10, 10).RGBA(); r>>8 < 240 || g>>8 < 240 || b>>8 < 240 {
+		t.Fatalf("transparent area is %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+	if r, g, _, _ := thumbnail.At(10, 10).RGBA(); r>>8 < 200 || g>>8 > 60 {
+		t.Fatalf("red area is %d,%d, want red", r>>8, g>>8)
+	}
+
+	// A new photo replaces the previous one. Keys carry the upload second.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	member, err = env.Staff.UploadProfilePhoto(ctx, alice.ID.Hex(), testImage(t, 64, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := bucket.keys(); len(keys) != 1 || keys[0] != member.ProfilePhoto.R2Key || keys[0] == first {
+		t.Fatalf("objects = %q, want only the new photo", keys)
+	}
+	bucket.mu.Lock()
+	stored = bucket.objects[member.ProfilePhoto.R2Key]
+	bucket.mu.Unlock()
+	// Small photos are not enlarged
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(stored)); err != nil || cfg.Width != 64 || cfg.Height != 64 {
+		t.Fatalf("thumbnail is %dx%d (%v), want 64x64", cfg.Width, cfg.Height, err)
+	}
+
+	if _, err := env.Staff.UploadProfilePhoto(ctx, alice.ID.Hex(), []byte("%PDF-1.7")); !errors.Is(err, ErrInvalidPhoto) {
+		t.Fatalf("err = %v, want ErrInvalidPhoto", err)
+	}
+	if keys := bucket.keys(); len(keys) != 1 {
+		t.Fatalf("objects = %q after a refused upload", keys)
+	}
+}
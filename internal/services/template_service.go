@@ -0,0 +1,708 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxTemplateSize bounds each part of a template
+	maxTemplateSize = 256 << 10
+	// maxRenderedSize bounds all parts of a rendered template together
+	maxRenderedSize = 1 << 20
+	// renderTimeout bounds rendering one template
+	renderTimeout = 2 * time.Second
+)
+
+var (
+	ErrTemplateNotFound     = errors.New("template not found")
+	ErrInvalidTemplate      = errors.New("invalid template")
+	ErrTemplateAccessDenied = errors.New("no access to this template")
+	ErrSignatureExists      = errors.New("a signature already exists for this owner")
+)
+
+// errRenderedTooLarge stops rendering past maxRenderedSize, and
+// errRenderTimeout past renderTimeout
+var (
+	errRenderedTooLarge = errors.New("rendered template is too large")
+	errRenderTimeout    = errors.New("rendering the template took too long")
+)
+
+type TemplateServiceConfig struct {
+	Repo    storage.TemplateRepository
+	Staff   storage.StaffRepository
+	Logger  *zap.Logger
+	Metrics *metrics.Metrics
+}
+
+// TemplateService manages email templates and signatures and renders them.
+// Staff manage their own templates; department and global ones are
+// managed by admins.
+type TemplateService struct {
+	repo    storage.TemplateRepository
+	staff   storage.StaffRepository
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewTemplateService(cfg TemplateServiceConfig) *TemplateService {
+	return &TemplateService{
+		repo:    cfg.Repo,
+		staff:   cfg.Staff,
+		logger:  cfg.Logger,
+		metrics: cfg.Metrics,
+	}
+}
+
+// TemplateParams is the content and scope of a template. Without
+// Department or Global the template belongs to the staff member saving it.
+type TemplateParams struct {
+	Kind        string
+	Name        string
+	Description string
+	Department  string
+	Global      bool
+	Subject     string
+	Text        string
+	HTML        string
+}
+
+// TemplateData is what templates are rendered with. Variables are
+// available by name, as in {{.customer}}; Sender and Recipient are
+// reserved.
+type TemplateData struct {
+	Sender    *staff.Staff
+	Recipient email.Participant
+	Variables map[string]string
+}
+
+type templateSender struct {
+	FullName   string
+	Email      string
+	Title      string
+	Department string
+}
+
+type templateRecipient struct {
+	Name  string
+	Email string
+}
+
+// Rendered is the result of rendering a template
+type Rendered struct {
+	Subject string             `json:"subject"`
+	Content email.EmailContent `json:"content"`
+	// personal is set when the template addresses the recipient, so its
+	// content suits no other recipient
+	personal bool
+}
+
+func (s *TemplateService) CreateTemplate(ctx context.Context, userID string, params TemplateParams) (*template.Template, error) {
+	member, err := s.member(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	t := &template.Template{
+		CreatedBy: userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.apply(ctx, member, t, params); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, t); err != nil {
+		return nil, fmt.Errorf("failed to create template: %w", err)
+	}
+
+	s.metrics.EmailRequests.WithLabelValues("template_create", "success").Inc()
+	return t, nil
+}
+
+// GetTemplate returns a template visible to userID: their own, their
+// department's or a global one
+func (s *TemplateService) GetTemplate(ctx context.Context, userID, id string) (*template.Template, error) {
+	member, err := s.member(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.visible(ctx, member, id)
+}
+
+// ListTemplates returns one page of the templates visible to userID of
+// kind, or of every kind when empty
+func (s *TemplateService) ListTemplates(ctx context.Context, userID, kind, cursor string, limit int) ([]*template.Template, error) {
+	member, err := s.member(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := s.repo.List(ctx, &template.ListQuery{
+		Kind:      kind,
+		VisibleTo: scopeOf(member),
+		Cursor:    cursor,
+		Limit:     limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	return templates, nil
+}
+
+// UpdateTemplate replaces a template userID manages
+func (s *TemplateService) UpdateTemplate(ctx context.Context, userID, id string, params TemplateParams) (*template.Template, error) {
+	member, err := s.member(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.managed(ctx, member, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.apply(ctx, member, t, params); err != nil {
+		return nil, err
+	}
+	t.UpdatedAt = time.Now()
+
+	if err := s.repo.Update(ctx, t); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, fmt.Errorf("failed to update template: %w", err)
+	}
+	return t, nil
+}
+
+// DeleteTemplate deletes a template userID manages
+func (s *TemplateService) DeleteTemplate(ctx context.Context, userID, id string) error {
+	member, err := s.member(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.managed(ctx, member, id); err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrTemplateNotFound
+		}
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	return nil
+}
+
+// RenderTemplate renders a template visible to userID, as a preview
+func (s *TemplateService) RenderTemplate(ctx context.Context, userID, id string, recipient email.Participant, variables map[string]string) (*Rendered, error) {
+	member, err := s.member(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Render(ctx, id, TemplateData{Sender: member, Recipient: recipient, Variables: variables})
+}
+
+// Render renders a message template visible to data.Sender. The text part
+// is generated from the HTML part when the template has none.
+func (s *TemplateService) Render(ctx context.Context, id string, data TemplateData) (*Rendered, error) {
+	t, err := s.visible(ctx, data.Sender, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind != template.KindMessage {
+		return nil, fmt.Errorf("%w: %s is not a message template", ErrInvalidTemplate, t.Name)
+	}
+	return render(ctx, t, data)
+}
+
+// Signature renders the signature of data.Sender: their own, else their
+// department's, else the global one. It returns nil when there is none.
+func (s *TemplateService) Signature(ctx context.Context, data TemplateData) (*Rendered, error) {
+	signatures, err := s.repo.List(ctx, &template.ListQuery{
+		Kind:      template.KindSignature,
+		VisibleTo: scopeOf(data.Sender),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signatures: %w", err)
+	}
+
+	var best *template.Template
+	for _, t := range signatures {
+		if best == nil || signaturePrecedence(t) > signaturePrecedence(best) {
+			best = t
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return render(ctx, best, data)
+}
+
+// renderContent sets the subject and content of params from its template
+// and appends the sender's signature, as requested. All recipients get the
+// same content, so templates addressing the recipient are refused for
+// emails to several.
+func (s *EmailService) renderContent(ctx context.Context, sender *staff.Staff, recipients []email.Participant, params *SendEmailParams) error {
+	data := TemplateData{Sender: sender, Recipient: recipients[0], Variables: params.Variables}
+	shared := len(recipients) > 1
+
+	if params.TemplateID != "" {
+		if params.Content.Text != "" || params.Content.HTML != "" {
+			return fmt.Errorf("%w: content and template are exclusive", ErrInvalidEmail)
+		}
+		rendered, err := s.templates.Render(ctx, params.TemplateID, data)
+		if err != nil {
+			return err
+		}
+		if shared && rendered.personal {
+			return fmt.Errorf("%w: the template addresses the recipient, send it to one recipient at a time", ErrInvalidEmail)
+		}
+		if params.Subject == "" {
+			params.Subject = rendered.Subject
+		}
+		params.Content = rendered.Content
+	}
+	if params.Subject == "" {
+		return fmt.Errorf("%w: subject is required", ErrInvalidEmail)
+	}
+
+	if params.Signature {
+		signature, err := s.templates.Signature(ctx, data)
+		if err != nil {
+			return err
+		}
+		if signature != nil && shared && signature.personal {
+			return fmt.Errorf("%w: the signature addresses the recipient, send the email to one recipient at a time", ErrInvalidEmail)
+		}
+		if signature != nil {
+			params.Content = appendSignature(params.Content, signature)
+		}
+	}
+	return nil
+}
+
+// member returns the staff member acting
+func (s *TemplateService) member(ctx context.Context, userID string) (*staff.Staff, error) {
+	member, err := s.staff.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrTemplateAccessDenied
+		}
+		return nil, fmt.Errorf("failed to get staff: %w", err)
+	}
+	return member, nil
+}
+
+func (s *TemplateService) get(ctx context.Context, id string) (*template.Template, error) {
+	t, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	return t, nil
+}
+
+// visible returns a template member may use. Others' personal templates
+// and other departments' templates are reported as not found.
+func (s *TemplateService) visible(ctx context.Context, member *staff.Staff, id string) (*template.Template, error) {
+	t, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case t.OwnerID != "" && t.OwnerID != member.ID.Hex(),
+		t.OwnerID == "" && t.Department != "" && t.Department != member.Department:
+		return nil, ErrTemplateNotFound
+	}
+	return t, nil
+}
+
+// managed returns a template member may change
+func (s *TemplateService) managed(ctx context.Context, member *staff.Staff, id string) (*template.Template, error) {
+	t, err := s.visible(ctx, member, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.OwnerID == "" && member.Role != staff.RoleAdmin {
+		return nil, ErrTemplateAccessDenied
+	}
+	return t, nil
+}
+
+// apply validates params and sets them on t, checking that member may
+// save a template in the requested scope
+func (s *TemplateService) apply(ctx context.Context, member *staff.Staff, t *template.Template, params TemplateParams) error {
+	params.Name = strings.TrimSpace(params.Name)
+	params.Department = strings.TrimSpace(params.Department)
+	if params.Kind == "" {
+		params.Kind = template.KindMessage
+	}
+
+	switch {
+	case params.Kind != template.KindMessage && params.Kind != template.KindSignature:
+		return fmt.Errorf("%w: kind must be %q or %q", ErrInvalidTemplate, template.KindMessage, template.KindSignature)
+	case params.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidTemplate)
+	case params.Text == "" && params.HTML == "":
+		return fmt.Errorf("%w: text or HTML content is required", ErrInvalidTemplate)
+	case params.Global && params.Department != "":
+		return fmt.Errorf("%w: a template is either global or of a department", ErrInvalidTemplate)
+	case (params.Global || params.Department != "") && member.Role != staff.RoleAdmin:
+		return ErrTemplateAccessDenied
+	}
+	if params.Kind == template.KindSignature {
+		params.Subject = ""
+	}
+	if err := parseTemplate(params.Subject, params.Text, params.HTML); err != nil {
+		return err
+	}
+
+	owner := ""
+	if !params.Global && params.Department == "" {
+		owner = member.ID.Hex()
+	}
+	if params.Kind == template.KindSignature {
+		if err := s.checkSignature(ctx, t.ID.Hex(), owner, params.Department); err != nil {
+			return err
+		}
+	}
+
+	t.Kind = params.Kind
+	t.Name = params.Name
+	t.Description = params.Description
+	t.OwnerID = owner
+	t.Department = params.Department
+	t.Subject = params.Subject
+	t.Text = params.Text
+	t.HTML = params.HTML
+	return nil
+}
+
+// checkSignature allows one signature per staff member, per department
+// and globally
+func (s *TemplateService) checkSignature(ctx context.Context, id, owner, department string) error {
+	signatures, err := s.repo.List(ctx, &template.ListQuery{
+		Kind:      template.KindSignature,
+		VisibleTo: &template.Scope{OwnerID: owner, Department: department},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list signatures: %w", err)
+	}
+	for _, t := range signatures {
+		if t.ID.Hex() != id && t.OwnerID == owner && t.Department == department {
+			return ErrSignatureExists
+		}
+	}
+	return nil
+}
+
+func scopeOf(member *staff.Staff) *template.Scope {
+	return &template.Scope{OwnerID: member.ID.Hex(), Department: member.Department}
+}
+
+func signaturePrecedence(t *template.Template) int {
+	switch {
+	case t.OwnerID != "":
+		return 2
+	case t.Department != "":
+		return 1
+	}
+	return 0
+}
+
+// parseTemplate checks the syntax of each part of a template
+func parseTemplate(subject, text, htmlBody string) error {
+	for _, part := range []string{subject, text, htmlBody} {
+		if len(part) > maxTemplateSize {
+			return fmt.Errorf("%w: content is too large", ErrInvalidTemplate)
+		}
+	}
+	if _, err := parseText("subject", subject); err != nil {
+		return err
+	}
+	if _, err := parseText("text", text); err != nil {
+		return err
+	}
+	_, err := parseHTML(htmlBody)
+	return err
+}
+
+// parseText parses the subject or text part of a template
+func parseText(name, src string) (*texttemplate.Template, error) {
+	tmpl, err := texttemplate.New(name).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, name, err)
+	}
+	var trees []*parse.Tree
+	for _, t := range tmpl.Templates() {
+		trees = append(trees, t.Tree)
+	}
+	if err := checkTemplate(trees); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTemplate, name, err)
+	}
+	return tmpl, nil
+}
+
+// parseHTML parses the HTML part of a template
+func parseHTML(src string) (*htmltemplate.Template, error) {
+	tmpl, err := htmltemplate.New("html").Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: html: %v", ErrInvalidTemplate, err)
+	}
+	var trees []*parse.Tree
+	for _, t := range tmpl.Templates() {
+		trees = append(trees, t.Tree)
+	}
+	if err := checkTemplate(trees); err != nil {
+		return nil, fmt.Errorf("%w: html: %v", ErrInvalidTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// checkTemplate refuses what could keep rendering busy without output:
+// ranges, as templates have nothing to iterate over but numbers, and
+// nested templates, which can recurse. Without them rendering takes time
+// linear in the size of the template.
+func checkTemplate(trees []*parse.Tree) error {
+	if len(trees) > 1 {
+		return errors.New("defining templates is not supported")
+	}
+	for _, tree := range trees {
+		if tree == nil {
+			continue
+		}
+		err := walkTemplate(tree.Root, func(node parse.Node) error {
+			switch node.(type) {
+			case *parse.RangeNode:
+				return errors.New("range is not supported")
+			case *parse.TemplateNode:
+				return errors.New("nested templates are not supported")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addressesRecipient reports whether a template refers to the recipient.
+// Templates passing on the whole data, as with {{.}}, count as doing so.
+func addressesRecipient(tree *parse.Tree) bool {
+	if tree == nil {
+		return false
+	}
+	errFound := errors.New("found")
+	return walkTemplate(tree.Root, func(node parse.Node) error {
+		switch n := node.(type) {
+		case *parse.FieldNode:
+			if n.Ident[0] == "Recipient" {
+				return errFound
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[1] == "Recipient" {
+				return errFound
+			}
+		case *parse.DotNode:
+			return errFound
+		}
+		return nil
+	}) != nil
+}
+
+// walkTemplate calls fn for node and the nodes below it, stopping at the
+// first error fn returns
+func walkTemplate(node parse.Node, fn func(parse.Node) error) error {
+	if node == nil {
+		return nil
+	}
+	if err := fn(node); err != nil {
+		return err
+	}
+
+	var children []parse.Node
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			children = n.Nodes
+		}
+	case *parse.ActionNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, decl := range n.Decl {
+			children = append(children, decl)
+		}
+		for _, cmd := range n.Cmds {
+			children = append(children, cmd)
+		}
+	case *parse.CommandNode:
+		children = n.Args
+	case *parse.ChainNode:
+		children = []parse.Node{n.Node}
+	case *parse.IfNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.WithNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.RangeNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.TemplateNode:
+		children = []parse.Node{n.Pipe}
+	}
+	for _, child := range children {
+		if err := walkTemplate(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render executes a template, within renderTimeout and maxRenderedSize.
+// The HTML part escapes values for their context, so variables cannot
+// inject markup.
+func render(ctx context.Context, t *template.Template, data TemplateData) (*Rendered, error) {
+	values := make(map[string]any, len(data.Variables)+2)
+	for name, value := range data.Variables {
+		values[name] = value
+	}
+	// Templates see only what belongs in an email about the sender
+	values["Sender"] = templateSender{
+		FullName:   data.Sender.FullName,
+		Email:      data.Sender.Email,
+		Title:      data.Sender.Title,
+		Department: data.Sender.Department,
+	}
+	values["Recipient"] = templateRecipient{Name: data.Recipient.FullName, Email: data.Recipient.Email}
+
+	ctx, cancel := context.WithTimeout(ctx, renderTimeout)
+	defer cancel()
+	budget := &renderBudget{ctx: ctx, n: maxRenderedSize}
+	rendered := &Rendered{}
+
+	subject, err := executeText(budget, "subject", t.Subject, values, &rendered.personal)
+	if err != nil {
+		return nil, err
+	}
+	text, err := executeText(budget, "text", t.Text, values, &rendered.personal)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBody string
+	if t.HTML != "" {
+		tmpl, err := parseHTML(t.HTML)
+		if err != nil {
+			return nil, err
+		}
+		rendered.personal = rendered.personal || addressesRecipient(tmpl.Tree)
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&budgetWriter{w: &buf, budget: budget}, values); err != nil {
+			return nil, renderError(err)
+		}
+		htmlBody = buf.String()
+	}
+
+	rendered.Content = email.EmailContent{Text: text, HTML: htmlBody}
+	if rendered.Content.Text == "" {
+		rendered.Content.Text = plainText(email.EmailContent{HTML: htmlBody})
+	}
+	// Headers cannot span lines
+	rendered.Subject = strings.Join(strings.Fields(subject), " ")
+	return rendered, nil
+}
+
+func executeText(budget *renderBudget, name, src string, values map[string]any, personal *bool) (string, error) {
+	if src == "" {
+		return "", nil
+	}
+	tmpl, err := parseText(name, src)
+	if err != nil {
+		return "", err
+	}
+	*personal = *personal || addressesRecipient(tmpl.Tree)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&budgetWriter{w: &buf, budget: budget}, values); err != nil {
+		return "", renderError(err)
+	}
+	return buf.String(), nil
+}
+
+func renderError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	for _, limit := range []error{errRenderedTooLarge, errRenderTimeout} {
+		if errors.Is(err, limit) {
+			return fmt.Errorf("%w: %v", ErrInvalidTemplate, limit)
+		}
+	}
+	// Missing variables and values of the wrong type
+	return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+}
+
+// appendSignature adds a signature below content, after the "-- "
+// delimiter of RFC 3676
+func appendSignature(content email.EmailContent, signature *Rendered) email.EmailContent {
+	content.Text = strings.TrimRight(content.Text, "\n") + "\n\n-- \n" + signature.Content.Text
+	if content.HTML != "" {
+		sig := signature.Content.HTML
+		if sig == "" {
+			sig = strings.ReplaceAll(html.EscapeString(signature.Content.Text), "\n", "<br>")
+		}
+		content.HTML += `<br><div class="signature">-- <br>` + sig + `</div>`
+	}
+	return content
+}
+
+// renderBudget is what is left of the output and time one template may
+// take to render, shared by its parts
+type renderBudget struct {
+	ctx context.Context
+	n   int
+}
+
+// budgetWriter fails writes past the budget. Templates without ranges or
+// nested templates write as they go, so checking the deadline on writes
+// stops slow ones.
+type budgetWriter struct {
+	w      io.Writer
+	budget *renderBudget
+}
+
+func (b *budgetWriter) Write(p []byte) (int, error) {
+	if err := b.budget.ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return 0, errRenderTimeout
+		}
+		return 0, err
+	}
+	if len(p) > b.budget.n {
+		return 0, errRenderedTooLarge
+	}
+	b.budget.n -= len(p)
+	return b.w.Write(p)
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+)
+
+func TestRender(t *testing.T) {
+	ctx := context.Background()
+	sender := &staff.Staff{FullName: "Alice Smith", Email: "alice@example.com", Title: "Accountant"}
+	data := TemplateData{
+		Sender:    sender,
+		Recipient: email.Participant{FullName: "Bob", Email: "bob@example.org"},
+		Variables: map[string]string{"customer": `<b>Acme</b> & "Co"`},
+	}
+
+	rendered, err := render(ctx, &template.Template{
+		Subject: "Invoice for {{.customer}}\r\nBcc: everyone@example.com",
+		HTML:    `<p>Dear {{.Recipient.Name}},</p><p>{{.customer}}</p><p>{{.Sender.FullName}}, {{.Sender.Title}}</p>`,
+	}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Subjects stay on one line
+	if want := `Invoice for <b>Acme</b> & "Co" Bcc: everyone@example.com`; rendered.Subject != want {
+		t.Fatalf("subject = %q, want %q", rendered.Subject, want)
+	}
+	// Variables cannot inject markup
+	if want := `<p>Dear Bob,</p><p>&lt;b&gt;Acme&lt;/b&gt; &amp; &#34;Co&#34;</p><p>Alice Smith, Accountant</p>`; rendered.Content.HTML != want {
+		t.Fatalf("html = %q, want %q", rendered.Content.HTML, want)
+	}
+	// The text part is generated from the HTML
+	if !strings.Contains(rendered.Content.Text, "Dear Bob,") || strings.Contains(rendered.Content.Text, "<p>") {
+		t.Fatalf("text = %q", rendered.Content.Text)
+	}
+	if !rendered.personal {
+		t.Fatal("a template addressing the recipient is not personal")
+	}
+
+	rendered, err = render(ctx, &template.Template{Subject: "Invoice", Text: "For {{.customer}}"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Content.Text != `For <b>Acme</b> & "Co"` || rendered.personal {
+		t.Fatalf("rendered = %+v", rendered)
+	}
+
+	// Missing variables are errors rather than "<no value>"
+	if _, err := render(ctx, &template.Template{Text: "Dear {{.name}}"}, data); !errors.Is(err, ErrInvalidTemplate) {
+		t.Fatalf("err = %v, want a missing variable refused", err)
+	}
+}
+
+func TestRenderLimits(t *testing.T) {
+	data := TemplateData{
+		Sender:    &staff.Staff{},
+		Variables: map[string]string{"x": strings.Repeat("x", 400<<10)},
+	}
+
+	// Each part fits, all of them together do not
+	tmpl := &template.Template{Text: "{{.x}}{{.x}}", HTML: "<p>{{.x}}</p>"}
+	if _, err := render(context.Background(), tmpl, data); !errors.Is(err, ErrInvalidTemplate) || !strings.Contains(err.Error(), errRenderedTooLarge.Error()) {
+		t.Fatalf("err = %v, want the output refused as too large", err)
+	}
+	if _, err := render(context.Background(), &template.Template{Text: "{{.x}}{{.x}}"}, data); err != nil {
+		t.Fatalf("output within the limit: %v", err)
+	}
+
+	// Rendering stops at the deadline
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := render(ctx, &template.Template{Text: "Hello"}, data); !errors.Is(err, ErrInvalidTemplate) || !strings.Contains(err.Error(), errRenderTimeout.Error()) {
+		t.Fatalf("err = %v, want rendering timed out", err)
+	}
+	// A cancelled request is not the template's fault
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := render(ctx, &template.Template{Text: "Hello"}, data); !errors.Is(err, context.Canceled) || errors.Is(err, ErrInvalidTemplate) {
+		t.Fatalf("err = %v, want the cancellation", err)
+	}
+}
+
+func TestParseTemplateRefusesLoops(t *testing.T) {
+	refused := []struct {
+		name          string
+		subject, text string
+		html          string
+	}{
+		{name: "range", text: "{{range 1000000000}}{{end}}"},
+		{name: "nested range", html: "{{range 1000}}{{range 1000}}x{{end}}{{end}}"},
+		{name: "define", text: `{{define "a"}}{{template "a" .}}{{end}}{{template "a" .}}`},
+		{name: "template", subject: `{{template "other"}}`},
+		{name: "block", html: `{{block "a" .}}x{{end}}`},
+		{name: "range in with", text: "{{with .x}}{{range 10}}{{end}}{{end}}"},
+		{name: "too large", text: strings.Repeat("x", maxTemplateSize+1)},
+		{name: "syntax", html: "{{.x"},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := parseTemplate(tt.subject, tt.text, tt.html); !errors.Is(err, ErrInvalidTemplate) {
+				t.Fatalf("err = %v, want ErrInvalidTemplate", err)
+			}
+		})
+	}
+
+	if err := parseTemplate("Invoice {{.number}}", "{{if .note}}{{.note}}{{else}}-{{end}}", "{{with .Sender}}<p>{{.FullName}}</p>{{end}}"); err != nil {
+		t.Fatalf("err = %v, want the template accepted", err)
+	}
+
+	// Templates stored before are refused when rendered
+	_, err := render(context.Background(), &template.Template{Text: "{{range 1000000000}}{{end}}"}, TemplateData{Sender: &staff.Staff{}})
+	if !errors.Is(err, ErrInvalidTemplate) {
+		t.Fatalf("err = %v, want a stored range refused", err)
+	}
+}
+
+func TestAddressesRecipient(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"Hello", false},
+		{"{{.customer}} {{.Sender.FullName}}", false},
+		{"{{with .Sender}}{{.Email}}{{end}}", false},
+		{"Dear {{.Recipient.Name}}", true},
+		{"{{if .Recipient.Name}}Dear {{.Recipient.Name}}{{else}}Hello{{end}}", true},
+		{"{{with .Recipient}}{{.Email}}{{end}}", true},
+		{"{{$.Recipient.Email}}", true},
+		{"{{$r := .Recipient}}{{$r.Name}}", true},
+		{"{{printf \"%s\" (.Recipient).Name}}", true},
+		{"{{if .x}}{{else}}{{.Recipient.Email}}{{end}}", true},
+		// The whole data includes the recipient
+		{"{{.}}", true},
+		{"{{index . \"Recipient\"}}", true},
+	}
+	for _, tt := range tests {
+		tmpl, err := texttemplate.New("t").Parse(tt.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+		if got := addressesRecipient(tmpl.Tree); got != tt.want {
+			t.Errorf("addressesRecipient(%s) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestSendEmailRendersTemplateForEveryRecipient(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	alice := env.addStaff(t, "alice@example.com")
+
+	create := func(kind, text string) string {
+		t.Helper()
+		tmpl, err := env.Templates.CreateTemplate(ctx, alice.ID.Hex(), TemplateParams{Kind: kind, Name: text, Subject: "Invoice", Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl.ID.Hex()
+	}
+	personal := create(template.KindMessage, "Dear {{.Recipient.Name}}, your invoice for {{.customer}}")
+	shared := create(template.KindMessage, "Invoice for {{.customer}}")
+
+	send := func(templateID string, signature bool, to ...string) (*email.Email, error) {
+		return env.Email.SendEmail(ctx, SendEmailParams{
+			From:       alice.ID.Hex(),
+			To:         to[:1],
+			CC:         to[1:],
+			TemplateID: templateID,
+			Variables:  map[string]string{"customer": "Acme"},
+			Signature:  signature,
+		})
+	}
+
+	sent, err := send(personal, false, "Bob <bob@example.org>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.Content.Text != "Dear Bob, your invoice for Acme" {
+		t.Fatalf("text = %q", sent.Content.Text)
+	}
+	// Carol would read Bob's greeting
+	if _, err := send(personal, false, "Bob <bob@example.org>", "Carol <carol@example.org>"); !errors.Is(err, ErrInvalidEmail) {
+		t.Fatalf("err = %v, want a personal template refused for two recipients", err)
+	}
+	if sent, err = send(shared, false, "bob@example.org", "carol@example.org"); err != nil || sent.Content.Text != "Invoice for Acme" {
+		t.Fatalf("sent %+v, err = %v", sent, err)
+	}
+
+	// So is a personal signature
+	create(template.KindSignature, "Alice, writing to {{.Recipient.Email}}")
+	if _, err := send(shared, true, "bob@example.org", "carol@example.org"); !errors.Is(err, ErrInvalidEmail) {
+		t.Fatalf("err = %v, want a personal signature refused for two recipients", err)
+	}
+	if sent, err = send(shared, true, "bob@example.org"); err != nil || !strings.HasSuffix(sent.Content.Text, "-- \nAlice, writing to bob@example.org") {
+		t.Fatalf("sent %+v, err = %v", sent, err)
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/jobs"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// maxRetryDelay caps the backoff between attempts of a failing job
+const maxRetryDelay = time.Hour
+
+// JobHandler runs one job. A returned error fails the attempt, which is
+// retried with backoff until the job has used its attempts.
+type JobHandler func(ctx context.Context, job *jobs.Job) error
+
+type WorkerConfig struct {
+	Queue storage.JobRepository
+	// Workers is the number of jobs run concurrently
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	Logger       *zap.Logger
+	Metrics      *metrics.Metrics
+}
+
+// Worker claims due jobs from the queue and runs the handler registered
+// for their type. Every replica runs one; the queue lease keeps a job on
+// a single worker at a time.
+type Worker struct {
+	queue    storage.JobRepository
+	workers  int
+	poll     time.Duration
+	lease    time.Duration
+	id       string
+	handlers map[string]JobHandler
+	logger   *zap.Logger
+	metrics  *metrics.Metrics
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewWorker(cfg WorkerConfig) *Worker {
+	host, _ := os.Hostname()
+	return &Worker{
+		queue:    cfg.Queue,
+		workers:  cfg.Workers,
+		poll:     cfg.PollInterval,
+		lease:    cfg.Lease,
+		id:       fmt.Sprintf("%s-%s", host, uuid.New().String()[:8]),
+		handlers: make(map[string]JobHandler),
+		logger:   cfg.Logger,
+		metrics:  cfg.Metrics,
+	}
+}
+
+// Handle registers the handler of a job type. Handlers must be registered
+// before Start.
+func (w *Worker) Handle(jobType string, handler JobHandler) {
+	w.handlers[jobType] = handler
+}
+
+// Start runs the workers until Stop
+func (w *Worker) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	for i := 0; i < w.workers; i++ {
+		w.wg.Add(1)
+		go w.loop(ctx, fmt.Sprintf("%s-%d", w.id, i))
+	}
+}
+
+// Stop cancels running jobs and waits for the workers to return. Cancelled
+// jobs are retried once their lease expires.
+func (w *Worker) Stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context, worker string) {
+	defer w.wg.Done()
+
+	for {
+		ran, err := w.RunNext(ctx, worker)
+		if err != nil && ctx.Err() == nil {
+			w.logger.Error("failed to claim job", zap.String("worker", worker), zap.Error(err))
+		}
+		if ran {
+			continue
+		}
+
+		timer := time.NewTimer(w.poll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// RunNext claims and runs one due job, reporting whether there was one
+func (w *Worker) RunNext(ctx context.Context, worker string) (bool, error) {
+	job, err := w.queue.Claim(ctx, worker, w.lease)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	handler, ok := w.handlers[job.Type]
+	if !ok {
+		w.logger.Error("no handler for job", zap.String("job_id", job.ID), zap.String("type", job.Type))
+		w.finish(ctx, job, worker, fmt.Errorf("no handler for job type %q", job.Type), false)
+		return true, nil
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, w.lease)
+	err = handler(jobCtx, job)
+	cancel()
+	w.finish(ctx, job, worker, err, true)
+	return true, nil
+}
+
+// finish records the outcome of a job, scheduling a retry of a failed one
+// while it has attempts left
+func (w *Worker) finish(ctx context.Context, job *jobs.Job, worker string, runErr error, retry bool) {
+	if runErr == nil {
+		if err := w.queue.Complete(ctx, job.ID, worker); err != nil {
+			w.logger.Error("failed to complete job", zap.String("job_id", job.ID), zap.Error(err))
+		}
+		w.metrics.EmailRequests.WithLabelValues("job_"+job.Type, "success").Inc()
+		return
+	}
+
+	var retryAt *time.Time
+	if retry && job.Attempts < job.MaxAttempts {
+		delay := time.Duration(job.Attempts*job.Attempts) * 30 * time.Second
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		next := time.Now().Add(delay)
+		retryAt = &next
+	}
+
+	w.logger.Warn("job failed", zap.String("job_id", job.ID), zap.String("type", job.Type),
+		zap.Int("attempt", job.Attempts), zap.Bool("retry", retryAt != nil), zap.Error(runErr))
+	if err := w.queue.Fail(ctx, job.ID, worker, runErr.Error(), retryAt); err != nil {
+		w.logger.Error("failed to record job failure", zap.String("job_id", job.ID), zap.Error(err))
+	}
+	w.metrics.EmailRequests.WithLabelValues("job_"+job.Type, "error").Inc()
+}
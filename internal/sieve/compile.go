@@ -0,0 +1,605 @@
+package sieve
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// extensionOf names the extension a command or test needs
+var extensionOf = map[string]string{
+	"fileinto":   "fileinto",
+	"reject":     "reject",
+	"vacation":   "vacation",
+	"envelope":   "envelope",
+	"body":       "body",
+	"setflag":    "imap4flags",
+	"addflag":    "imap4flags",
+	"removeflag": "imap4flags",
+	"hasflag":    "imap4flags",
+}
+
+// compiler validates the syntax tree and turns it into commands and tests
+type compiler struct {
+	requires map[string]bool
+}
+
+func errorAt(line int, format string, args ...interface{}) error {
+	return &Error{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (c *compiler) script(nodes []*node) ([]command, error) {
+	i := 0
+	for ; i < len(nodes) && nodes[i].name == "require"; i++ {
+		if err := c.require(nodes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return c.block(nodes[i:])
+}
+
+func (c *compiler) require(n *node) error {
+	a, err := c.args(n, nil)
+	if err != nil {
+		return err
+	}
+	if err := a.positional(argStrings); err != nil {
+		return err
+	}
+	for _, ext := range a.pos[0].strings {
+		if !supported(ext) {
+			return errorAt(n.line, "unsupported extension %q", ext)
+		}
+		c.requires[ext] = true
+	}
+	return nil
+}
+
+func supported(ext string) bool {
+	for _, e := range Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiler) need(n *node, ext string) error {
+	if !c.requires[ext] {
+		return errorAt(n.line, "%s requires %q", n.name, ext)
+	}
+	return nil
+}
+
+func (c *compiler) block(nodes []*node) ([]command, error) {
+	var commands []command
+	var last *ifCommand
+	for _, n := range nodes {
+		if n.name != "elsif" && n.name != "else" {
+			last = nil
+		}
+		if n.name == "if" || n.name == "elsif" || n.name == "else" {
+			if err := c.control(n, &last); err != nil {
+				return nil, err
+			}
+			if n.name == "if" {
+				commands = append(commands, last)
+			}
+			continue
+		}
+
+		if n.hasBlock {
+			return nil, errorAt(n.line, "%s does not take a block", n.name)
+		}
+		if len(n.tests) > 0 {
+			return nil, errorAt(n.line, "%s does not take a test", n.name)
+		}
+		cmd, err := c.command(n)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+// control compiles if, elsif and else. An if starts a new chain in last;
+// elsif and else extend it.
+func (c *compiler) control(n *node, last **ifCommand) error {
+	if len(n.args) > 0 {
+		return errorAt(n.line, "%s does not take arguments", n.name)
+	}
+	if !n.hasBlock {
+		return errorAt(n.line, "%s requires a block", n.name)
+	}
+
+	switch n.name {
+	case "if":
+		*last = &ifCommand{}
+	case "elsif", "else":
+		if *last == nil || (*last).otherwise != nil {
+			return errorAt(n.line, "%s without a preceding if", n.name)
+		}
+	}
+
+	block, err := c.block(n.block)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		block = []command{}
+	}
+
+	if n.name == "else" {
+		if len(n.tests) > 0 {
+			return errorAt(n.line, "else does not take a test")
+		}
+		(*last).otherwise = block
+		return nil
+	}
+
+	if len(n.tests) != 1 {
+		return errorAt(n.line, "%s requires exactly one test", n.name)
+	}
+	t, err := c.test(n.tests[0])
+	if err != nil {
+		return err
+	}
+	(*last).branches = append((*last).branches, branch{test: t, block: block})
+	return nil
+}
+
+func (c *compiler) command(n *node) (command, error) {
+	if n.name == "require" {
+		return nil, errorAt(n.line, "require is only allowed at the start of the script")
+	}
+	if ext, ok := extensionOf[n.name]; ok {
+		if err := c.need(n, ext); err != nil {
+			return nil, err
+		}
+	}
+
+	switch n.name {
+	case "stop":
+		return stopCommand{}, c.noArgs(n)
+	case "discard":
+		return discardCommand{}, c.noArgs(n)
+	case "keep":
+		a, err := c.args(n, map[string]tagKind{"flags": tagStrings})
+		if err != nil {
+			return nil, err
+		}
+		cmd := keepCommand{}
+		cmd.flags, cmd.hasFlags, err = c.flagsTag(n, a)
+		if err != nil {
+			return nil, err
+		}
+		return cmd, a.positional()
+	case "fileinto":
+		a, err := c.args(n, map[string]tagKind{"flags": tagStrings})
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argString); err != nil {
+			return nil, err
+		}
+		cmd := fileintoCommand{mailbox: a.pos[0].strings[0]}
+		if cmd.mailbox == "" {
+			return nil, errorAt(n.line, "fileinto requires a mailbox name")
+		}
+		cmd.flags, cmd.hasFlags, err = c.flagsTag(n, a)
+		return cmd, err
+	case "redirect":
+		a, err := c.args(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argString); err != nil {
+			return nil, err
+		}
+		address, err := mail.ParseAddress(a.pos[0].strings[0])
+		if err != nil {
+			return nil, errorAt(n.line, "invalid redirect address %q", a.pos[0].strings[0])
+		}
+		return redirectCommand{address: strings.ToLower(address.Address)}, nil
+	case "reject":
+		a, err := c.args(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argString); err != nil {
+			return nil, err
+		}
+		return rejectCommand{reason: a.pos[0].strings[0]}, nil
+	case "vacation":
+		return c.vacation(n)
+	case "setflag", "addflag", "removeflag":
+		a, err := c.args(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argStrings); err != nil {
+			return nil, err
+		}
+		return flagCommand{op: n.name, flags: splitFlags(a.pos[0].strings)}, nil
+	}
+	return nil, errorAt(n.line, "unknown command %q", n.name)
+}
+
+func (c *compiler) noArgs(n *node) error {
+	if len(n.args) > 0 {
+		return errorAt(n.line, "%s does not take arguments", n.name)
+	}
+	return nil
+}
+
+// flagsTag returns the :flags of keep or fileinto
+func (c *compiler) flagsTag(n *node, a *args) ([]string, bool, error) {
+	value, ok := a.tags["flags"]
+	if !ok {
+		return nil, false, nil
+	}
+	if err := c.need(n, "imap4flags"); err != nil {
+		return nil, false, errorAt(n.line, ":flags requires \"imap4flags\"")
+	}
+	return splitFlags(value.strings), true, nil
+}
+
+func (c *compiler) vacation(n *node) (command, error) {
+	a, err := c.args(n, map[string]tagKind{
+		"days":      tagNumber,
+		"subject":   tagString,
+		"from":      tagString,
+		"addresses": tagStrings,
+		"mime":      tagFlag,
+		"handle":    tagString,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.positional(argString); err != nil {
+		return nil, err
+	}
+
+	v := Vacation{Reason: a.pos[0].strings[0], Days: 7}
+	if days, ok := a.tags["days"]; ok {
+		// RFC 5230 has values below the minimum of one day raised to it
+		v.Days = int(max(days.number, 1))
+	}
+	if subject, ok := a.tags["subject"]; ok {
+		v.Subject = subject.strings[0]
+	}
+	if from, ok := a.tags["from"]; ok {
+		address, err := mail.ParseAddress(from.strings[0])
+		if err != nil {
+			return nil, errorAt(from.line, "invalid :from address %q", from.strings[0])
+		}
+		v.From = strings.ToLower(address.Address)
+	}
+	if addresses, ok := a.tags["addresses"]; ok {
+		for _, raw := range addresses.strings {
+			address, err := mail.ParseAddress(raw)
+			if err != nil {
+				return nil, errorAt(addresses.line, "invalid address %q in :addresses", raw)
+			}
+			v.Addresses = append(v.Addresses, strings.ToLower(address.Address))
+		}
+	}
+	_, v.Mime = a.tags["mime"]
+	if handle, ok := a.tags["handle"]; ok {
+		v.Handle = handle.strings[0]
+	} else {
+		// Without :handle, replies differing in content are distinct
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%t", v.Reason, v.Subject, v.From, v.Mime)))
+		v.Handle = hex.EncodeToString(sum[:8])
+	}
+	return vacationCommand{vacation: v}, nil
+}
+
+func (c *compiler) test(n *node) (test, error) {
+	if n.hasBlock {
+		return nil, errorAt(n.line, "unexpected block")
+	}
+	if ext, ok := extensionOf[n.name]; ok {
+		if err := c.need(n, ext); err != nil {
+			return nil, err
+		}
+	}
+
+	switch n.name {
+	case "true", "false":
+		if len(n.args) > 0 || len(n.tests) > 0 {
+			return nil, errorAt(n.line, "%s does not take arguments", n.name)
+		}
+		return boolTest(n.name == "true"), nil
+	case "not":
+		if len(n.args) > 0 || len(n.tests) != 1 {
+			return nil, errorAt(n.line, "not requires exactly one test")
+		}
+		t, err := c.test(n.tests[0])
+		return notTest{t}, err
+	case "allof", "anyof":
+		if len(n.args) > 0 || len(n.tests) == 0 {
+			return nil, errorAt(n.line, "%s requires a list of tests", n.name)
+		}
+		tests := make([]test, 0, len(n.tests))
+		for _, child := range n.tests {
+			t, err := c.test(child)
+			if err != nil {
+				return nil, err
+			}
+			tests = append(tests, t)
+		}
+		return listTest{all: n.name == "allof", tests: tests}, nil
+	}
+
+	if len(n.tests) > 0 {
+		return nil, errorAt(n.line, "%s does not take a test", n.name)
+	}
+
+	switch n.name {
+	case "address", "envelope":
+		a, err := c.args(n, matchTags(true))
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argStrings, argStrings); err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(n, a)
+		if err != nil {
+			return nil, err
+		}
+		part, err := c.addressPart(n, a)
+		if err != nil {
+			return nil, err
+		}
+		t := addressTest{matcher: m, part: part, headers: a.pos[0].strings, keys: a.pos[1].strings}
+		if n.name == "envelope" {
+			t.envelope = true
+			for i, name := range t.headers {
+				name = strings.ToLower(name)
+				if name != "from" && name != "to" {
+					return nil, errorAt(n.line, "unsupported envelope part %q", name)
+				}
+				t.headers[i] = name
+			}
+		}
+		return t, nil
+	case "header":
+		a, err := c.args(n, matchTags(false))
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argStrings, argStrings); err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(n, a)
+		return headerTest{matcher: m, headers: a.pos[0].strings, keys: a.pos[1].strings}, err
+	case "exists":
+		a, err := c.args(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argStrings); err != nil {
+			return nil, err
+		}
+		return existsTest{headers: a.pos[0].strings}, nil
+	case "size":
+		a, err := c.args(n, map[string]tagKind{"over": tagNumber, "under": tagNumber})
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(); err != nil {
+			return nil, err
+		}
+		over, isOver := a.tags["over"]
+		under, isUnder := a.tags["under"]
+		switch {
+		case isOver == isUnder:
+			return nil, errorAt(n.line, "size requires either :over or :under")
+		case isOver:
+			return sizeTest{over: true, limit: over.number}, nil
+		}
+		return sizeTest{limit: under.number}, nil
+	case "body":
+		tags := matchTags(false)
+		tags["raw"] = tagFlag
+		tags["content"] = tagStrings
+		tags["text"] = tagFlag
+		a, err := c.args(n, tags)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argStrings); err != nil {
+			return nil, err
+		}
+		if err := a.exclusive("raw", "content", "text"); err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(n, a)
+		if err != nil {
+			return nil, err
+		}
+		t := bodyTest{matcher: m, keys: a.pos[0].strings}
+		if _, ok := a.tags["raw"]; ok {
+			t.raw = true
+		}
+		if content, ok := a.tags["content"]; ok {
+			t.contentTypes = content.strings
+		}
+		return t, nil
+	case "hasflag":
+		a, err := c.args(n, matchTags(false))
+		if err != nil {
+			return nil, err
+		}
+		if err := a.positional(argStrings); err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(n, a)
+		return hasflagTest{matcher: m, keys: splitFlags(a.pos[0].strings)}, err
+	}
+	return nil, errorAt(n.line, "unknown test %q", n.name)
+}
+
+func matchTags(address bool) map[string]tagKind {
+	tags := map[string]tagKind{
+		"comparator": tagString,
+		"is":         tagFlag,
+		"contains":   tagFlag,
+		"matches":    tagFlag,
+	}
+	if address {
+		tags["all"] = tagFlag
+		tags["localpart"] = tagFlag
+		tags["domain"] = tagFlag
+	}
+	return tags
+}
+
+func (c *compiler) matcher(n *node, a *args) (matcher, error) {
+	if err := a.exclusive("is", "contains", "matches"); err != nil {
+		return matcher{}, err
+	}
+
+	m := matcher{match: matchIs, comparator: comparatorCasemap}
+	if _, ok := a.tags["contains"]; ok {
+		m.match = matchContains
+	}
+	if _, ok := a.tags["matches"]; ok {
+		m.match = matchMatches
+	}
+
+	if value, ok := a.tags["comparator"]; ok {
+		switch name := value.strings[0]; name {
+		case "i;ascii-casemap":
+		case "i;octet":
+			m.comparator = comparatorOctet
+		default:
+			return m, errorAt(value.line, "unsupported comparator %q", name)
+		}
+	}
+	return m, nil
+}
+
+func (c *compiler) addressPart(n *node, a *args) (addressPart, error) {
+	if err := a.exclusive("all", "localpart", "domain"); err != nil {
+		return partAll, err
+	}
+	if _, ok := a.tags["localpart"]; ok {
+		return partLocal, nil
+	}
+	if _, ok := a.tags["domain"]; ok {
+		return partDomain, nil
+	}
+	return partAll, nil
+}
+
+type tagKind int
+
+const (
+	tagFlag tagKind = iota
+	tagString
+	tagStrings
+	tagNumber
+)
+
+// Positional argument kinds; argString is a single string
+const argString argKind = -1
+
+// args holds the tagged arguments of a command or test by name, with the
+// value following them where they take one, and the positional arguments
+type args struct {
+	n    *node
+	tags map[string]arg
+	pos  []arg
+}
+
+// args sorts the arguments of n into tagged ones, which must be known and
+// come first, and positional ones
+func (c *compiler) args(n *node, tags map[string]tagKind) (*args, error) {
+	a := &args{n: n, tags: make(map[string]arg)}
+	for i := 0; i < len(n.args); i++ {
+		current := n.args[i]
+		if current.kind != argTag {
+			a.pos = append(a.pos, current)
+			continue
+		}
+		if len(a.pos) > 0 {
+			return nil, errorAt(current.line, "tagged argument :%s must come before positional arguments", current.tag)
+		}
+
+		kind, ok := tags[current.tag]
+		if !ok {
+			return nil, errorAt(current.line, "%s does not take :%s", n.name, current.tag)
+		}
+		if _, dup := a.tags[current.tag]; dup {
+			return nil, errorAt(current.line, "duplicate :%s", current.tag)
+		}
+		if kind == tagFlag {
+			a.tags[current.tag] = current
+			continue
+		}
+
+		if i+1 >= len(n.args) {
+			return nil, errorAt(current.line, ":%s requires a value", current.tag)
+		}
+		i++
+		value := n.args[i]
+		switch {
+		case kind == tagNumber && value.kind != argNumber,
+			kind == tagString && (value.kind != argStrings || value.list),
+			kind == tagStrings && value.kind != argStrings:
+			return nil, errorAt(value.line, ":%s requires a %s, found %s", current.tag, kindName(kind), value.describe())
+		}
+		a.tags[current.tag] = value
+	}
+	return a, nil
+}
+
+func kindName(kind tagKind) string {
+	switch kind {
+	case tagString:
+		return "string"
+	case tagStrings:
+		return "string list"
+	case tagNumber:
+		return "number"
+	}
+	return "flag"
+}
+
+// positional checks the kinds of the positional arguments
+func (a *args) positional(kinds ...argKind) error {
+	if len(a.pos) != len(kinds) {
+		return errorAt(a.n.line, "%s takes %d positional arguments, found %d", a.n.name, len(kinds), len(a.pos))
+	}
+	for i, kind := range kinds {
+		got := a.pos[i]
+		switch {
+		case kind == argString && (got.kind != argStrings || got.list),
+			kind == argStrings && got.kind != argStrings,
+			kind == argNumber && got.kind != argNumber:
+			want := map[argKind]string{argString: "string", argStrings: "string list", argNumber: "number"}[kind]
+			return errorAt(got.line, "%s requires a %s, found %s", a.n.name, want, got.describe())
+		}
+	}
+	return nil
+}
+
+// exclusive fails when more than one of the tags was given
+func (a *args) exclusive(tags ...string) error {
+	var found []string
+	for _, tag := range tags {
+		if _, ok := a.tags[tag]; ok {
+			found = append(found, ":"+tag)
+		}
+	}
+	if len(found) > 1 {
+		return errorAt(a.n.line, "%s cannot be combined", strings.Join(found, " and "))
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package sieve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenTag
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	num   int64
+	line  int
+	punct byte
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of script"
+	case tokenString:
+		return "string"
+	case tokenPunct:
+		return fmt.Sprintf("%q", string(t.punct))
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lexer splits a script into tokens as described in RFC 5228 section 8.1
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return &Error{Line: l.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpace(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, line: l.line}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("()[]{},;", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunct, punct: c, line: l.line}, nil
+	case c == '"':
+		return l.quoted()
+	case c == ':':
+		l.pos++
+		name := l.identifier()
+		if name == "" {
+			return token{}, l.errorf("expected a tag name after ':'")
+		}
+		return token{kind: tokenTag, text: strings.ToLower(name), line: l.line}, nil
+	case isDigit(c):
+		return l.number()
+	case isIdentStart(c):
+		line := l.line
+		name := strings.ToLower(l.identifier())
+		if name == "text" && l.pos < len(l.src) && l.src[l.pos] == ':' {
+			l.pos++
+			return l.multiline(line)
+		}
+		return token{kind: tokenIdentifier, text: name, line: line}, nil
+	}
+	return token{}, l.errorf("unexpected character %q", c)
+}
+
+func (l *lexer) skipSpace() error {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) identifier() string {
+	start := l.pos
+	for l.pos < len(l.src) && (isIdentStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+// number reads digits with an optional K, M or G quantifier
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	n, err := strconv.ParseInt(l.src[start:l.pos], 10, 64)
+	if err != nil {
+		return token{}, l.errorf("number %s is out of range", l.src[start:l.pos])
+	}
+
+	if l.pos < len(l.src) {
+		shift := 0
+		switch l.src[l.pos] {
+		case 'K', 'k':
+			shift = 10
+		case 'M', 'm':
+			shift = 20
+		case 'G', 'g':
+			shift = 30
+		}
+		if shift > 0 {
+			l.pos++
+			if n > (1<<63-1)>>shift {
+				return token{}, l.errorf("number %s is out of range", l.src[start:l.pos])
+			}
+			n <<= shift
+		}
+	}
+	return token{kind: tokenNumber, num: n, line: l.line}, nil
+}
+
+// quoted reads a quoted string. A backslash escapes the next character.
+func (l *lexer) quoted() (token, error) {
+	line := l.line
+	l.pos++
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return token{kind: tokenString, text: b.String(), line: line}, nil
+		case '\\':
+			l.pos++
+			if l.pos >= len(l.src) {
+				break
+			}
+			c = l.src[l.pos]
+		case '\n':
+			l.line++
+		}
+		b.WriteByte(c)
+		l.pos++
+	}
+	return token{}, &Error{Line: line, Msg: "unterminated string"}
+}
+
+// multiline reads a text: string up to a line holding a single dot. Lines
+// starting with two dots lose the first one.
+func (l *lexer) multiline(line int) (token, error) {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+		l.pos++
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '#' {
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos >= len(l.src) || l.src[l.pos] != '\n' {
+		return token{}, l.errorf("expected a line break after text:")
+	}
+	l.pos++
+	l.line++
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		end := strings.IndexByte(l.src[l.pos:], '\n')
+		if end < 0 {
+			end = len(l.src) - l.pos
+		}
+		text := strings.TrimSuffix(l.src[l.pos:l.pos+end], "\r")
+		l.pos += end
+		if l.pos < len(l.src) {
+			l.pos++
+		}
+		l.line++
+
+		if text == "." {
+			return token{kind: tokenString, text: b.String(), line: line}, nil
+		}
+		if strings.HasPrefix(text, "..") {
+			text = text[1:]
+		}
+		b.WriteString(text)
+		b.WriteString("\r\n")
+	}
+	return token{}, &Error{Line: line, Msg: "unterminated text: string"}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
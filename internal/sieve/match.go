@@ -0,0 +1,110 @@
+package sieve
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+type comparator int
+
+const (
+	// comparatorCasemap is i;ascii-casemap, the default
+	comparatorCasemap comparator = iota
+	comparatorOctet
+)
+
+type matchType int
+
+const (
+	matchIs matchType = iota
+	matchContains
+	matchMatches
+)
+
+// matcher compares values with keys by a match type and comparator
+type matcher struct {
+	match      matchType
+	comparator comparator
+}
+
+// matches reports whether value matches any of keys
+func (m matcher) matches(value string, keys []string) bool {
+	if m.comparator == comparatorCasemap {
+		value = asciiLower(value)
+	}
+	for _, key := range keys {
+		if m.comparator == comparatorCasemap {
+			key = asciiLower(key)
+		}
+		switch m.match {
+		case matchIs:
+			if value == key {
+				return true
+			}
+		case matchContains:
+			if strings.Contains(value, key) {
+				return true
+			}
+		case matchMatches:
+			if glob(key, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// asciiLower folds only ASCII letters, as i;ascii-casemap does
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// glob matches value against a :matches pattern, where "*" matches any
+// sequence, "?" any single character and a backslash escapes the next one
+func glob(pattern, value string) bool {
+	// Position after the last "*" in pattern and value to backtrack to
+	starPattern, starValue := -1, 0
+	p, v := 0, 0
+	for v < len(value) {
+		if p < len(pattern) {
+			c, size := utf8.DecodeRuneInString(pattern[p:])
+			switch c {
+			case '*':
+				p += size
+				starPattern, starValue = p, v
+				continue
+			case '?':
+				_, vsize := utf8.DecodeRuneInString(value[v:])
+				p += size
+				v += vsize
+				continue
+			case '\\':
+				if p+size < len(pattern) {
+					p += size
+					c, size = utf8.DecodeRuneInString(pattern[p:])
+				}
+			}
+			if vc, vsize := utf8.DecodeRuneInString(value[v:]); vc == c {
+				p += size
+				v += vsize
+				continue
+			}
+		}
+		if starPattern < 0 {
+			return false
+		}
+		_, vsize := utf8.DecodeRuneInString(value[starValue:])
+		starValue += vsize
+		p, v = starPattern, starValue
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
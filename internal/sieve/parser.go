@@ -0,0 +1,225 @@
+package sieve
+
+import "fmt"
+
+type argKind int
+
+const (
+	argTag argKind = iota
+	argNumber
+	argStrings
+)
+
+// arg is one argument of a command or test: a tag, a number or a string
+// list. A single string is a list of one.
+type arg struct {
+	kind    argKind
+	tag     string
+	number  int64
+	strings []string
+	// list records whether the strings were written in brackets
+	list bool
+	line int
+}
+
+func (a arg) describe() string {
+	switch a.kind {
+	case argTag:
+		return ":" + a.tag
+	case argNumber:
+		return "number"
+	}
+	if a.list {
+		return "string list"
+	}
+	return "string"
+}
+
+// node is a parsed command or test
+type node struct {
+	name  string
+	args  []arg
+	tests []*node
+	// block holds the commands of a control command's block
+	block    []*node
+	hasBlock bool
+	line     int
+}
+
+// parser builds the syntax tree of RFC 5228 section 8.2
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func parse(src string) ([]*node, error) {
+	p := &parser{lex: newLexer(src)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	commands, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return commands, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Line: p.tok.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) isPunct(c byte) bool {
+	return p.tok.kind == tokenPunct && p.tok.punct == c
+}
+
+func (p *parser) expect(c byte) error {
+	if !p.isPunct(c) {
+		return p.errorf("expected %q, found %s", string(c), p.tok)
+	}
+	return p.advance()
+}
+
+// commands reads commands up to the end of the script or of a block
+func (p *parser) commands() ([]*node, error) {
+	var commands []*node
+	for p.tok.kind == tokenIdentifier {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+func (p *parser) command() (*node, error) {
+	cmd := &node{name: p.tok.text, line: p.tok.line}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if err := p.arguments(cmd); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.isPunct(';'):
+		return cmd, p.advance()
+	case p.isPunct('{'):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		block, err := p.commands()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('}'); err != nil {
+			return nil, err
+		}
+		cmd.block = block
+		cmd.hasBlock = true
+		return cmd, nil
+	}
+	return nil, p.errorf("expected \";\" or a block after %s, found %s", cmd.name, p.tok)
+}
+
+// arguments reads the arguments of a command or test, followed by an
+// optional test or parenthesized test list
+func (p *parser) arguments(n *node) error {
+	for {
+		switch {
+		case p.tok.kind == tokenTag:
+			n.args = append(n.args, arg{kind: argTag, tag: p.tok.text, line: p.tok.line})
+		case p.tok.kind == tokenNumber:
+			n.args = append(n.args, arg{kind: argNumber, number: p.tok.num, line: p.tok.line})
+		case p.tok.kind == tokenString:
+			n.args = append(n.args, arg{kind: argStrings, strings: []string{p.tok.text}, line: p.tok.line})
+		case p.isPunct('['):
+			list, err := p.stringList()
+			if err != nil {
+				return err
+			}
+			n.args = append(n.args, list)
+			continue
+		default:
+			return p.testArguments(n)
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) stringList() (arg, error) {
+	list := arg{kind: argStrings, list: true, line: p.tok.line}
+	if err := p.advance(); err != nil {
+		return list, err
+	}
+	for {
+		if p.tok.kind != tokenString {
+			return list, p.errorf("expected a string in string list, found %s", p.tok)
+		}
+		list.strings = append(list.strings, p.tok.text)
+		if err := p.advance(); err != nil {
+			return list, err
+		}
+		if p.isPunct(']') {
+			return list, p.advance()
+		}
+		if err := p.expect(','); err != nil {
+			return list, err
+		}
+	}
+}
+
+func (p *parser) testArguments(n *node) error {
+	switch {
+	case p.tok.kind == tokenIdentifier:
+		test, err := p.test()
+		if err != nil {
+			return err
+		}
+		n.tests = []*node{test}
+	case p.isPunct('('):
+		if err := p.advance(); err != nil {
+			return err
+		}
+		for {
+			test, err := p.test()
+			if err != nil {
+				return err
+			}
+			n.tests = append(n.tests, test)
+			if p.isPunct(')') {
+				return p.advance()
+			}
+			if err := p.expect(','); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) test() (*node, error) {
+	if p.tok.kind != tokenIdentifier {
+		return nil, p.errorf("expected a test, found %s", p.tok)
+	}
+	test := &node{name: p.tok.text, line: p.tok.line}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return test, p.arguments(test)
+}
@@ -0,0 +1,222 @@
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxRedirects bounds the redirects of one run, so a script cannot turn a
+// message into a flood
+const maxRedirects = 5
+
+var errStop = errors.New("stop")
+
+// runner is the state of one script run
+type runner struct {
+	msg    *Message
+	result *Result
+	// flags is the internal variable of imap4flags
+	flags        []string
+	implicitKeep bool
+}
+
+type command interface {
+	exec(r *runner) error
+}
+
+type test interface {
+	eval(r *runner) bool
+}
+
+func execBlock(r *runner, block []command) error {
+	for _, cmd := range block {
+		if err := cmd.exec(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type branch struct {
+	test  test
+	block []command
+}
+
+// ifCommand is an if with its elsif branches and else block
+type ifCommand struct {
+	branches  []branch
+	otherwise []command
+}
+
+func (c *ifCommand) exec(r *runner) error {
+	for _, b := range c.branches {
+		if b.test.eval(r) {
+			return execBlock(r, b.block)
+		}
+	}
+	return execBlock(r, c.otherwise)
+}
+
+type stopCommand struct{}
+
+func (stopCommand) exec(*runner) error { return errStop }
+
+type discardCommand struct{}
+
+func (discardCommand) exec(r *runner) error {
+	r.implicitKeep = false
+	return nil
+}
+
+type keepCommand struct {
+	flags    []string
+	hasFlags bool
+}
+
+func (c keepCommand) exec(r *runner) error {
+	if r.result.Rejected {
+		return r.conflict("keep")
+	}
+	flags := r.flags
+	if c.hasFlags {
+		flags = c.flags
+	}
+	r.result.Keep = true
+	r.result.Flags = addFlags(r.result.Flags, flags)
+	r.implicitKeep = false
+	return nil
+}
+
+type fileintoCommand struct {
+	mailbox  string
+	flags    []string
+	hasFlags bool
+}
+
+func (c fileintoCommand) exec(r *runner) error {
+	if r.result.Rejected {
+		return r.conflict("fileinto")
+	}
+	flags := r.flags
+	if c.hasFlags {
+		flags = c.flags
+	}
+	r.implicitKeep = false
+
+	for i := range r.result.FileInto {
+		if r.result.FileInto[i].Mailbox == c.mailbox {
+			r.result.FileInto[i].Flags = addFlags(r.result.FileInto[i].Flags, flags)
+			return nil
+		}
+	}
+	r.result.FileInto = append(r.result.FileInto, FileInto{Mailbox: c.mailbox, Flags: addFlags(nil, flags)})
+	return nil
+}
+
+type redirectCommand struct {
+	address string
+}
+
+func (c redirectCommand) exec(r *runner) error {
+	r.implicitKeep = false
+	for _, address := range r.result.Redirect {
+		if address == c.address {
+			return nil
+		}
+	}
+	if len(r.result.Redirect) == maxRedirects {
+		return fmt.Errorf("sieve: more than %d redirects", maxRedirects)
+	}
+	r.result.Redirect = append(r.result.Redirect, c.address)
+	return nil
+}
+
+type rejectCommand struct {
+	reason string
+}
+
+func (c rejectCommand) exec(r *runner) error {
+	if r.result.Rejected || r.result.Keep || len(r.result.FileInto) > 0 || r.result.Vacation != nil {
+		return r.conflict("reject")
+	}
+	r.implicitKeep = false
+	r.result.Rejected = true
+	r.result.RejectReason = c.reason
+	return nil
+}
+
+type vacationCommand struct {
+	vacation Vacation
+}
+
+func (c vacationCommand) exec(r *runner) error {
+	if r.result.Rejected || r.result.Vacation != nil {
+		return r.conflict("vacation")
+	}
+	v := c.vacation
+	r.result.Vacation = &v
+	return nil
+}
+
+// conflict reports an action incompatible with the earlier ones
+func (r *runner) conflict(action string) error {
+	return fmt.Errorf("sieve: %s cannot be combined with the actions taken before", action)
+}
+
+type flagCommand struct {
+	op    string
+	flags []string
+}
+
+func (c flagCommand) exec(r *runner) error {
+	switch c.op {
+	case "setflag":
+		r.flags = addFlags(nil, c.flags)
+	case "addflag":
+		r.flags = addFlags(r.flags, c.flags)
+	case "removeflag":
+		kept := make([]string, 0, len(r.flags))
+		for _, flag := range r.flags {
+			if !containsFold(c.flags, flag) {
+				kept = append(kept, flag)
+			}
+		}
+		r.flags = kept
+	}
+	return nil
+}
+
+// splitFlags splits a flag list whose strings may hold several flags
+// separated by spaces
+func splitFlags(list []string) []string {
+	var flags []string
+	for _, s := range list {
+		for _, flag := range strings.Fields(s) {
+			if !containsFold(flags, flag) {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	return flags
+}
+
+// addFlags returns a copy of flags extended by the ones of add it lacks
+func addFlags(flags, add []string) []string {
+	result := append([]string(nil), flags...)
+	for _, flag := range add {
+		if !containsFold(result, flag) {
+			result = append(result, flag)
+		}
+	}
+	return result
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
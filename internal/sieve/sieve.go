@@ -0,0 +1,141 @@
+// Package sieve implements the Sieve mail filtering language of RFC 5228
+// with the envelope, fileinto and reject (RFC 5429), vacation (RFC 5230),
+// imap4flags (RFC 5232) and body (RFC 5173) extensions.
+//
+// A script is compiled once, which validates it completely, and then run
+// against every message. Running a script never delivers anything; it
+// returns the actions for the caller to carry out.
+package sieve
+
+import (
+	"fmt"
+	"net/mail"
+)
+
+// MaxScriptSize is the largest script Compile accepts
+const MaxScriptSize = 64 << 10
+
+// Extensions lists the capabilities scripts can require
+var Extensions = []string{
+	"body",
+	"comparator-i;ascii-casemap",
+	"comparator-i;octet",
+	"envelope",
+	"fileinto",
+	"imap4flags",
+	"reject",
+	"vacation",
+}
+
+// Error is a syntax or validation error, or a runtime error of a script
+type Error struct {
+	// Line is the script line the error was found on
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Script is a compiled script. It is safe for concurrent use.
+type Script struct {
+	commands []command
+}
+
+// Compile parses and validates src
+func Compile(src string) (*Script, error) {
+	if len(src) > MaxScriptSize {
+		return nil, &Error{Line: 1, Msg: fmt.Sprintf("script exceeds %d bytes", MaxScriptSize)}
+	}
+
+	nodes, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{requires: make(map[string]bool)}
+	commands, err := c.script(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{commands: commands}, nil
+}
+
+// Message is what a script runs against
+type Message struct {
+	Header mail.Header
+	// From and To are the envelope sender, empty for the null sender, and
+	// the recipient the script runs for
+	From string
+	To   string
+	Size int64
+	// Text and HTML are the decoded body parts tested by body
+	Text string
+	HTML string
+}
+
+// Result holds the actions of a script run
+type Result struct {
+	// Keep files the message into the inbox, by an explicit keep or the
+	// implicit keep, with Flags set
+	Keep  bool
+	Flags []string
+	// FileInto lists the other mailboxes to file the message into
+	FileInto []FileInto
+	// Redirect lists the addresses to forward the message to
+	Redirect []string
+	// Rejected refuses the message, with RejectReason sent back to the
+	// sender
+	Rejected     bool
+	RejectReason string
+	Vacation     *Vacation
+}
+
+// Discarded reports whether the message is to be dropped silently
+func (r *Result) Discarded() bool {
+	return !r.Keep && !r.Rejected && len(r.FileInto) == 0 && len(r.Redirect) == 0
+}
+
+// FileInto files a message into a mailbox
+type FileInto struct {
+	Mailbox string
+	Flags   []string
+}
+
+// Vacation is an automatic reply. The caller decides whether to send it,
+// at most once per Days to a sender for the same Handle.
+type Vacation struct {
+	Reason  string
+	Subject string
+	// From overrides the reply's sender address
+	From string
+	// Addresses are further addresses of the recipient; replies are only
+	// sent for messages addressed to the recipient directly
+	Addresses []string
+	// Mime means Reason is a MIME entity with its own headers
+	Mime   bool
+	Days   int
+	Handle string
+}
+
+// Run executes the script against msg. A runtime error cancels all actions
+// but the implicit keep, which is returned along with the error.
+func (s *Script) Run(msg *Message) (*Result, error) {
+	r := &runner{msg: msg, result: &Result{}, implicitKeep: true}
+	for _, cmd := range s.commands {
+		err := cmd.exec(r)
+		if err == errStop {
+			break
+		}
+		if err != nil {
+			return &Result{Keep: true}, err
+		}
+	}
+
+	if r.implicitKeep && !r.result.Keep {
+		r.result.Keep = true
+		r.result.Flags = r.flags
+	}
+	return r.result, nil
+}
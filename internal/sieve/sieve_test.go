@@ -0,0 +1,344 @@
+package sieve_test
+
+import (
+	"errors"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/sieve"
+)
+
+func testMessage() *sieve.Message {
+	return &sieve.Message{
+		Header: mail.Header{
+			"From":         {"Alice Example <Alice@Example.com>"},
+			"To":           {"bob@example.org, Carol <carol@sub.example.net>"},
+			"Subject":      {"=?utf-8?q?Quarterly_R=C3=A9port?="},
+			"List-Id":      {"<announce.example.com>"},
+			"X-Spam-Score": {"5.0"},
+		},
+		From: "bounces+123@example.com",
+		To:   "bob@example.org",
+		Size: 2048,
+		Text: "Hello world,\r\nthe figures are attached.",
+	}
+}
+
+func run(t *testing.T, src string) *sieve.Result {
+	t.Helper()
+
+	script, err := sieve.Compile(src)
+	if err != nil {
+		t.Fatalf("Compile(%q): %v", src, err)
+	}
+	result, err := script.Run(testMessage())
+	if err != nil {
+		t.Fatalf("Run(%q): %v", src, err)
+	}
+	return result
+}
+
+// filed returns the mailboxes a result files into, with "INBOX" for keep
+func filed(r *sieve.Result) []string {
+	var mailboxes []string
+	if r.Keep {
+		mailboxes = append(mailboxes, "INBOX")
+	}
+	for _, f := range r.FileInto {
+		mailboxes = append(mailboxes, f.Mailbox)
+	}
+	return mailboxes
+}
+
+func TestGrammar(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"empty script keeps", ``, []string{"INBOX"}},
+		{"hash comment", "# nothing to do\nkeep;", []string{"INBOX"}},
+		{"bracketed comment across lines", "/* a\n * b\n */ discard;", nil},
+		{"identifiers are case-insensitive", `REQUIRE "fileinto"; IF TRUE { FileInto "a"; }`, []string{"a"}},
+		{"string list", `require ["fileinto", "envelope"]; fileinto "a";`, []string{"a"}},
+		{"escaped quote", `require "fileinto"; fileinto "say \"hi\"";`, []string{`say "hi"`}},
+		{"multi-line string with dot-stuffing", "require \"fileinto\";\nfileinto text:\n..dotted\n.\n;", []string{".dotted\r\n"}},
+		{"number quantifier", `require "fileinto"; if size :over 1K { fileinto "big"; }`, []string{"big"}},
+		{"quantifier not reached", `require "fileinto"; if size :over 1M { fileinto "big"; }`, []string{"INBOX"}},
+		{"size under", `require "fileinto"; if size :under 4k { fileinto "small"; }`, []string{"small"}},
+		{"elsif chain", `require "fileinto";
+			if false { fileinto "a"; }
+			elsif false { fileinto "b"; }
+			elsif true { fileinto "c"; }
+			else { fileinto "d"; }`, []string{"c"}},
+		{"else branch", `require "fileinto"; if false { fileinto "a"; } else { fileinto "d"; }`, []string{"d"}},
+		{"nested blocks", `require "fileinto"; if true { if not false { fileinto "inner"; } }`, []string{"inner"}},
+		{"empty block", `if true { }`, []string{"INBOX"}},
+		{"allof", `require "fileinto"; if allof (true, not false) { fileinto "a"; }`, []string{"a"}},
+		{"allof fails", `require "fileinto"; if allof (true, false) { fileinto "a"; }`, []string{"INBOX"}},
+		{"anyof", `require "fileinto"; if anyof (false, true) { fileinto "a"; }`, []string{"a"}},
+		{"exists", `require "fileinto"; if exists ["From", "list-id"] { fileinto "a"; }`, []string{"a"}},
+		{"exists needs all", `require "fileinto"; if exists ["From", "Cc"] { fileinto "a"; }`, []string{"INBOX"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filed(run(t, tt.src)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("filed into %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTypes(t *testing.T) {
+	tests := []struct {
+		test string
+		want bool
+	}{
+		{`header :is "subject" "Quarterly Réport"`, true},
+		{`header :is "subject" "QUARTERLY RéPORT"`, true},
+		{`header :is "subject" "Quarterly"`, false},
+		// i;ascii-casemap folds ASCII letters only
+		{`header :is "subject" "quarterly RÉport"`, false},
+		{`header :is :comparator "i;octet" "subject" "quarterly réport"`, false},
+		{`header :is :comparator "i;octet" "subject" "Quarterly Réport"`, true},
+		{`header :contains "subject" "terly r"`, true},
+		{`header :contains "subject" ["nothing", "RÉPORT", "Réport"]`, true},
+		{`header :contains "subject" "invoice"`, false},
+		{`header :contains "subject" ""`, true},
+		{`header :matches "subject" "Quarterly*"`, true},
+		{`header :matches "subject" "*réport"`, true},
+		{`header :matches "subject" "Quarterly R?port"`, true},
+		{`header :matches "subject" "Quarterly R??port"`, false},
+		{`header :matches "subject" "*"`, true},
+		{`header :matches "subject" "*ly*po*"`, true},
+		{`header :matches "subject" "Quarterly"`, false},
+		{`header :matches "x-spam-score" "5\\.?"`, true},
+		{`header :matches "x-spam-score" "5\\*"`, false},
+		{`header :is "x-missing" ""`, false},
+		{`header :contains ["x-missing", "list-id"] "announce"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.test, func(t *testing.T) {
+			got := run(t, `require "fileinto"; if `+tt.test+` { fileinto "hit"; }`)
+			if hit := len(got.FileInto) == 1; hit != tt.want {
+				t.Fatalf("matched = %v, want %v", hit, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressTests(t *testing.T) {
+	tests := []struct {
+		test string
+		want bool
+	}{
+		{`address "from" "alice@example.com"`, true},
+		{`address :all :is "from" "alice@example.com"`, true},
+		{`address :localpart "from" "alice"`, true},
+		{`address :domain "from" "example.com"`, true},
+		{`address :domain "to" "sub.example.net"`, true},
+		{`address :domain :matches "to" "*.example.net"`, true},
+		{`address :localpart "to" "Carol"`, true},
+		// Display names are not part of the address
+		{`address :contains "from" "Example <"`, false},
+		{`header :contains "from" "Alice Example"`, true},
+		{`address :domain :is ["to", "cc"] "example.com"`, false},
+		{`envelope :is "from" "bounces+123@example.com"`, true},
+		{`envelope :localpart :matches "from" "bounces+*"`, true},
+		{`envelope :domain "to" "example.org"`, true},
+		{`envelope :is "to" "carol@sub.example.net"`, false},
+		{`envelope :all :comparator "i;octet" "to" "BOB@example.org"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.test, func(t *testing.T) {
+			got := run(t, `require ["fileinto", "envelope"]; if `+tt.test+` { fileinto "hit"; }`)
+			if hit := len(got.FileInto) == 1; hit != tt.want {
+				t.Fatalf("matched = %v, want %v", hit, tt.want)
+			}
+		})
+	}
+}
+
+func TestNullSender(t *testing.T) {
+	script, err := sieve.Compile(`require ["envelope", "fileinto"];
+		if envelope :is "from" "" { fileinto "bounces"; }
+		if envelope :domain :matches "from" "*" { fileinto "has-domain"; }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := testMessage()
+	msg.From = ""
+	result, err := script.Run(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filed(result); !reflect.DeepEqual(got, []string{"bounces"}) {
+		t.Fatalf("filed into %q, want only bounces", got)
+	}
+}
+
+func TestActions(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want sieve.Result
+	}{
+		{"implicit keep", `if false { discard; }`, sieve.Result{Keep: true}},
+		{"discard cancels implicit keep", `discard;`, sieve.Result{}},
+		{"fileinto cancels implicit keep", `require "fileinto"; fileinto "a";`,
+			sieve.Result{FileInto: []sieve.FileInto{{Mailbox: "a"}}}},
+		{"keep and fileinto", `require "fileinto"; fileinto "a"; keep;`,
+			sieve.Result{Keep: true, FileInto: []sieve.FileInto{{Mailbox: "a"}}}},
+		{"fileinto twice files once", `require "fileinto"; fileinto "a"; fileinto "a";`,
+			sieve.Result{FileInto: []sieve.FileInto{{Mailbox: "a"}}}},
+		{"redirect cancels implicit keep", `redirect "Someone <Else@Example.org>";`,
+			sieve.Result{Redirect: []string{"else@example.org"}}},
+		{"redirect and keep", `redirect "else@example.org"; redirect "else@example.org"; keep;`,
+			sieve.Result{Keep: true, Redirect: []string{"else@example.org"}}},
+		{"stop keeps implicitly", `stop; discard;`, sieve.Result{Keep: true}},
+		{"stop after discard", `discard; stop; keep;`, sieve.Result{}},
+		{"stop inside block", `require "fileinto"; if true { fileinto "a"; stop; } keep;`,
+			sieve.Result{FileInto: []sieve.FileInto{{Mailbox: "a"}}}},
+		{"reject", `require "reject"; reject "go away";`,
+			sieve.Result{Rejected: true, RejectReason: "go away"}},
+		{"flags on implicit keep", `require "imap4flags"; addflag ["\\Seen", "$Work \\Flagged"];`,
+			sieve.Result{Keep: true, Flags: []string{`\Seen`, "$Work", `\Flagged`}}},
+		{"removeflag", `require "imap4flags"; setflag "\\Seen \\Flagged"; removeflag "\\seen";`,
+			sieve.Result{Keep: true, Flags: []string{`\Flagged`}}},
+		{"fileinto with flags", `require ["fileinto", "imap4flags"]; fileinto :flags "\\Seen" "a";`,
+			sieve.Result{FileInto: []sieve.FileInto{{Mailbox: "a", Flags: []string{`\Seen`}}}}},
+		{"hasflag", `require ["fileinto", "imap4flags"]; addflag "\\Seen"; if hasflag "\\seen" { fileinto "seen"; }`,
+			sieve.Result{FileInto: []sieve.FileInto{{Mailbox: "seen", Flags: []string{`\Seen`}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := run(t, tt.src)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("result = %+v, want %+v", *got, tt.want)
+			}
+			if got.Discarded() != (tt.name == "discard cancels implicit keep" || tt.name == "stop after discard") {
+				t.Fatalf("Discarded() = %v", got.Discarded())
+			}
+		})
+	}
+}
+
+func TestVacation(t *testing.T) {
+	got := run(t, `require "vacation";
+		vacation :days 0 :subject "Away" :from "Me <ME@example.org>" :addresses ["Alias <alias@example.org>"] :handle "h1" "Back Monday";`)
+	want := &sieve.Vacation{
+		Reason:    "Back Monday",
+		Subject:   "Away",
+		From:      "me@example.org",
+		Addresses: []string{"alias@example.org"},
+		Days:      1,
+		Handle:    "h1",
+	}
+	if !got.Keep || !reflect.DeepEqual(got.Vacation, want) {
+		t.Fatalf("vacation = %+v, keep %v, want %+v", got.Vacation, got.Keep, want)
+	}
+
+	// Without :handle, replies with different reasons are distinct
+	a := run(t, `require "vacation"; vacation "one";`).Vacation
+	b := run(t, `require "vacation"; vacation "two";`).Vacation
+	if a.Days != 7 || a.Handle == "" || a.Handle == b.Handle {
+		t.Fatalf("default vacation %+v and %+v", a, b)
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"keep after reject", `require "reject"; reject "no"; keep;`},
+		{"reject after fileinto", `require ["reject", "fileinto"]; fileinto "a"; reject "no";`},
+		{"two vacations", `require "vacation"; vacation "a"; vacation "b";`},
+		{"too many redirects", `redirect "a@x.org"; redirect "b@x.org"; redirect "c@x.org";
+			redirect "d@x.org"; redirect "e@x.org"; redirect "f@x.org";`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := sieve.Compile(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := script.Run(testMessage())
+			if err == nil {
+				t.Fatal("Run succeeded, want an error")
+			}
+			// Only the implicit keep survives a failed run
+			if !reflect.DeepEqual(*result, sieve.Result{Keep: true}) {
+				t.Fatalf("result = %+v, want the implicit keep only", *result)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+		msg  string
+	}{
+		{"unexpected character", "keep;\n  @", 2, "unexpected character"},
+		{"unterminated string", "keep;\nfileinto \"a;\n\n", 2, "unterminated string"},
+		{"unterminated comment", "keep;\n/* never\nends", 2, "unterminated comment"},
+		{"unterminated text", "require \"vacation\";\nvacation text:\nline\n", 2, "unterminated text: string"},
+		{"text without line break", `vacation text: "x";`, 1, "line break after text:"},
+		{"missing semicolon", "keep\n}", 2, `expected ";" or a block after keep`},
+		{"missing closing brace", "if true {\n keep;\n", 3, `expected "}"`},
+		{"bad string list", "require [\"fileinto\",\n 5];", 2, "expected a string in string list"},
+		{"number out of range", "if size :over 99999999999G { keep; }", 1, "out of range"},
+		{"tag without name", "if header : \"a\" \"b\" { keep; }", 1, "tag name"},
+		{"unknown command", "keep;\n\nforward \"x\";", 3, `unknown command "forward"`},
+		{"unknown test", "if\n  spam { keep; }", 2, `unknown test "spam"`},
+		{"unsupported extension", `require "notify";`, 1, `unsupported extension "notify"`},
+		{"extension not required", "require \"reject\";\nfileinto \"a\";", 2, `fileinto requires "fileinto"`},
+		{"require after commands", "keep;\nrequire \"fileinto\";", 2, "only allowed at the start"},
+		{"elsif without if", "keep;\nelsif true { keep; }", 2, "without a preceding if"},
+		{"else after else", "if true { } else { } else { }", 1, "without a preceding if"},
+		{"if without test", "if { keep; }", 1, "requires exactly one test"},
+		{"if without block", "if true;", 1, "requires a block"},
+		{"block on command", "keep { }", 1, "does not take a block"},
+		{"test on command", "keep true;", 1, "does not take a test"},
+		{"unknown tag", "if header :regex \"a\" \"b\" { }", 1, "header does not take :regex"},
+		{"duplicate tag", "if header :is :is \"a\" \"b\" { }", 1, "duplicate :is"},
+		{"conflicting match types", "if header\n:is :contains \"a\" \"b\" { }", 1, ":is and :contains cannot be combined"},
+		{"tag after positional", "if header \"a\" :is \"b\" { }", 1, "must come before positional"},
+		{"missing positional", "if header \"a\" { }", 1, "header takes 2 positional arguments, found 1"},
+		{"string for string list is fine but list for string is not", "require \"fileinto\"; fileinto [\"a\", \"b\"];", 1, "fileinto requires a string, found string list"},
+		{"tag value kind", "if size :over \"1\" { }", 1, ":over requires a number, found string"},
+		{"size needs a bound", "if size { }", 1, "either :over or :under"},
+		{"unsupported comparator", "if header :comparator \"i;unicode-casemap\" \"a\" \"b\" { }", 1, "unsupported comparator"},
+		{"bad envelope part", "require \"envelope\";\nif envelope \"cc\" \"a\" { }", 2, `unsupported envelope part "cc"`},
+		{"bad redirect address", "redirect \"not an address\";", 1, "invalid redirect address"},
+		{"empty mailbox", "require \"fileinto\"; fileinto \"\";", 1, "requires a mailbox name"},
+		{"flags without imap4flags", "require \"fileinto\"; fileinto :flags \"\\\\Seen\" \"a\";", 1, `requires "imap4flags"`},
+		{"line counted through multi-line string", "require \"vacation\";\nvacation text:\na\nb\n.\n;\nbogus;", 7, `unknown command "bogus"`},
+		{"line counted through comment", "/* one\ntwo\nthree */ bogus;", 3, `unknown command "bogus"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sieve.Compile(tt.src)
+			var serr *sieve.Error
+			if !errors.As(err, &serr) {
+				t.Fatalf("Compile error = %v, want a *sieve.Error", err)
+			}
+			if serr.Line != tt.line || !strings.Contains(serr.Msg, tt.msg) {
+				t.Fatalf("error = %q on line %d, want %q on line %d", serr.Msg, serr.Line, tt.msg, tt.line)
+			}
+		})
+	}
+}
+
+func TestScriptTooLarge(t *testing.T) {
+	_, err := sieve.Compile("keep;" + strings.Repeat(" ", sieve.MaxScriptSize))
+	var serr *sieve.Error
+	if !errors.As(err, &serr) || !strings.Contains(serr.Msg, "exceeds") {
+		t.Fatalf("Compile error = %v, want the size limit", err)
+	}
+}
@@ -0,0 +1,226 @@
+package sieve
+
+import (
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+type boolTest bool
+
+func (t boolTest) eval(*runner) bool { return bool(t) }
+
+type notTest struct {
+	test test
+}
+
+func (t notTest) eval(r *runner) bool { return !t.test.eval(r) }
+
+// listTest is allof when all is set and anyof otherwise. Both stop at the
+// first test deciding the result.
+type listTest struct {
+	all   bool
+	tests []test
+}
+
+func (t listTest) eval(r *runner) bool {
+	for _, child := range t.tests {
+		if child.eval(r) != t.all {
+			return !t.all
+		}
+	}
+	return t.all
+}
+
+type addressPart int
+
+const (
+	partAll addressPart = iota
+	partLocal
+	partDomain
+)
+
+// addressTest is the address test, or the envelope test when envelope is
+// set, in which case headers names envelope parts
+type addressTest struct {
+	matcher
+	part     addressPart
+	envelope bool
+	headers  []string
+	keys     []string
+}
+
+func (t addressTest) eval(r *runner) bool {
+	for _, name := range t.headers {
+		for _, address := range t.addresses(r, name) {
+			value, ok := t.part.of(address)
+			if ok && t.matches(value, t.keys) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t addressTest) addresses(r *runner, name string) []string {
+	if t.envelope {
+		if name == "from" {
+			return []string{r.msg.From}
+		}
+		return []string{r.msg.To}
+	}
+
+	var addresses []string
+	for _, value := range headerValues(r.msg, name) {
+		list, err := mail.ParseAddressList(value)
+		if err != nil {
+			// Test what is there rather than nothing
+			addresses = append(addresses, strings.TrimSpace(value))
+			continue
+		}
+		for _, address := range list {
+			addresses = append(addresses, address.Address)
+		}
+	}
+	return addresses
+}
+
+// of returns the part of address. The null sender has no local part or
+// domain.
+func (p addressPart) of(address string) (string, bool) {
+	if p == partAll {
+		return address, true
+	}
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return "", false
+	}
+	if p == partLocal {
+		return address[:at], true
+	}
+	return address[at+1:], true
+}
+
+type headerTest struct {
+	matcher
+	headers []string
+	keys    []string
+}
+
+func (t headerTest) eval(r *runner) bool {
+	for _, name := range t.headers {
+		for _, value := range headerValues(r.msg, name) {
+			if t.matches(value, t.keys) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type existsTest struct {
+	headers []string
+}
+
+func (t existsTest) eval(r *runner) bool {
+	for _, name := range t.headers {
+		if len(r.msg.Header[textproto.CanonicalMIMEHeaderKey(name)]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type sizeTest struct {
+	over  bool
+	limit int64
+}
+
+func (t sizeTest) eval(r *runner) bool {
+	if t.over {
+		return r.msg.Size > t.limit
+	}
+	return r.msg.Size < t.limit
+}
+
+// bodyTest tests the text of the message by default, both body parts with
+// :raw and the parts of the listed content types with :content
+type bodyTest struct {
+	matcher
+	raw          bool
+	contentTypes []string
+	keys         []string
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+func (t bodyTest) eval(r *runner) bool {
+	for _, part := range t.parts(r.msg) {
+		if part != "" && t.matches(part, t.keys) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t bodyTest) parts(msg *Message) []string {
+	switch {
+	case t.raw:
+		return []string{msg.Text, msg.HTML}
+	case t.contentTypes != nil:
+		var parts []string
+		if matchesContentType(t.contentTypes, "text/plain") {
+			parts = append(parts, msg.Text)
+		}
+		if matchesContentType(t.contentTypes, "text/html") {
+			parts = append(parts, msg.HTML)
+		}
+		return parts
+	case msg.Text != "":
+		return []string{msg.Text}
+	}
+	return []string{htmlTag.ReplaceAllString(msg.HTML, " ")}
+}
+
+// matchesContentType matches "", a type or a type/subtype as RFC 5173 has it
+func matchesContentType(types []string, contentType string) bool {
+	main, _, _ := strings.Cut(contentType, "/")
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if t == "" || t == main || t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+type hasflagTest struct {
+	matcher
+	keys []string
+}
+
+func (t hasflagTest) eval(r *runner) bool {
+	for _, flag := range r.flags {
+		if t.matches(flag, t.keys) {
+			return true
+		}
+	}
+	return false
+}
+
+var wordDecoder = new(mime.WordDecoder)
+
+// headerValues returns the values of a header with encoded words decoded
+func headerValues(msg *Message, name string) []string {
+	raw := msg.Header[textproto.CanonicalMIMEHeaderKey(name)]
+	values := make([]string, 0, len(raw))
+	for _, value := range raw {
+		if decoded, err := wordDecoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,90 @@
+package spamfilter
+
+import (
+	"math"
+	"sort"
+
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+)
+
+const (
+	// strength and assumed are Robinson's s and x: how much weight the
+	// assumed probability of a rarely seen token gets
+	strength = 1.0
+	assumed  = 0.5
+	// maxClues is the number of most telling tokens combined
+	maxClues = 150
+	// minDeviation ignores tokens too close to neutral to tell anything
+	minDeviation = 0.1
+)
+
+// Probability combines the token probabilities of model with Fisher's
+// method as proposed by Gary Robinson. It returns 0.5 when the model has
+// not seen both spam and ham yet.
+func Probability(model *spam.Model, tokens []string) float64 {
+	if model.Messages.Spam <= 0 || model.Messages.Ham <= 0 {
+		return assumed
+	}
+
+	clues := make([]float64, 0, len(tokens))
+	for _, token := range tokens {
+		counts, ok := model.Tokens[token]
+		if !ok {
+			continue
+		}
+		p := tokenProbability(counts, model.Messages)
+		if math.Abs(p-0.5) >= minDeviation {
+			clues = append(clues, p)
+		}
+	}
+	if len(clues) == 0 {
+		return assumed
+	}
+
+	sort.Slice(clues, func(i, j int) bool {
+		return math.Abs(clues[i]-0.5) > math.Abs(clues[j]-0.5)
+	})
+	if len(clues) > maxClues {
+		clues = clues[:maxClues]
+	}
+
+	var spamLog, hamLog float64
+	for _, p := range clues {
+		spamLog += math.Log(1 - p)
+		hamLog += math.Log(p)
+	}
+	n := len(clues)
+	// S is near 1 for spam, H near 1 for ham
+	s := 1 - chi2Q(-2*spamLog, 2*n)
+	h := 1 - chi2Q(-2*hamLog, 2*n)
+	return (1 + s - h) / 2
+}
+
+// tokenProbability is Robinson's f(w): the spam probability of a token
+// pulled towards assumed when the token was seen rarely
+func tokenProbability(counts, messages spam.Counts) float64 {
+	spamCount := math.Max(float64(counts.Spam), 0)
+	hamCount := math.Max(float64(counts.Ham), 0)
+	n := spamCount + hamCount
+	if n == 0 {
+		return assumed
+	}
+
+	spamRatio := math.Min(spamCount/float64(messages.Spam), 1)
+	hamRatio := math.Min(hamCount/float64(messages.Ham), 1)
+	p := spamRatio / (spamRatio + hamRatio)
+	return (strength*assumed + n*p) / (strength + n)
+}
+
+// chi2Q is the probability that a chi-squared distributed value with an
+// even number of degrees of freedom df is at least x2
+func chi2Q(x2 float64, df int) float64 {
+	m := x2 / 2
+	sum := math.Exp(-m)
+	term := sum
+	for i := 1; i < df/2; i++ {
+		term *= m / float64(i)
+		sum += term
+	}
+	return math.Min(sum, 1)
+}
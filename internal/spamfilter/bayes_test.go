@@ -0,0 +1,110 @@
+package spamfilter
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+)
+
+func TestChi2Q(t *testing.T) {
+	tests := []struct {
+		x2   float64
+		df   int
+		want float64
+	}{
+		{0, 2, 1},
+		{0, 10, 1},
+		{2, 2, math.Exp(-1)},
+		// Q(x2 | 4) = e^(-m) (1 + m) with m = x2/2
+		{4, 4, 3 * math.Exp(-2)},
+		{1000, 2, 0},
+	}
+	for _, tt := range tests {
+		if got := chi2Q(tt.x2, tt.df); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("chi2Q(%v, %d) = %v, want %v", tt.x2, tt.df, got, tt.want)
+		}
+	}
+}
+
+func TestTokenProbability(t *testing.T) {
+	messages := spam.Counts{Spam: 100, Ham: 100}
+	tests := []struct {
+		name     string
+		counts   spam.Counts
+		min, max float64
+	}{
+		{"never seen", spam.Counts{}, 0.5, 0.5},
+		{"even", spam.Counts{Spam: 10, Ham: 10}, 0.5, 0.5},
+		{"spam only", spam.Counts{Spam: 50}, 0.98, 0.995},
+		{"ham only", spam.Counts{Ham: 50}, 0.005, 0.02},
+		// A token seen once is pulled towards neutral
+		{"spam once", spam.Counts{Spam: 1}, 0.75, 0.75},
+		// Negative counts left by untraining count as unseen
+		{"negative", spam.Counts{Spam: -3, Ham: -1}, 0.5, 0.5},
+	}
+	for _, tt := range tests {
+		if got := tokenProbability(tt.counts, messages); got < tt.min || got > tt.max {
+			t.Errorf("%s: tokenProbability = %v, want between %v and %v", tt.name, got, tt.min, tt.max)
+		}
+	}
+
+	// Ratios are relative to the messages of each class
+	skewed := spam.Counts{Spam: 10, Ham: 1000}
+	if got := tokenProbability(spam.Counts{Spam: 10, Ham: 10}, skewed); got < 0.95 {
+		t.Errorf("token in every spam and 1%% of ham = %v, want near 1", got)
+	}
+}
+
+func TestProbability(t *testing.T) {
+	model := &spam.Model{
+		Messages: spam.Counts{Spam: 100, Ham: 100},
+		Tokens: map[string]spam.Counts{
+			"viagra":  {Spam: 80},
+			"winner":  {Spam: 60, Ham: 1},
+			"invoice": {Spam: 5, Ham: 70},
+			"meeting": {Ham: 90},
+			"the":     {Spam: 100, Ham: 100},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		model    *spam.Model
+		tokens   []string
+		min, max float64
+	}{
+		{"spam tokens", model, []string{"viagra", "winner", "the"}, 0.9, 1},
+		{"ham tokens", model, []string{"meeting", "invoice", "the"}, 0, 0.1},
+		{"mixed", model, []string{"viagra", "meeting"}, 0.4, 0.6},
+		{"neutral only", model, []string{"the"}, 0.5, 0.5},
+		{"unknown only", model, []string{"quarterly", "report"}, 0.5, 0.5},
+		{"no tokens", model, nil, 0.5, 0.5},
+		{"untrained", &spam.Model{Messages: spam.Counts{Spam: 10}, Tokens: model.Tokens}, []string{"viagra"}, 0.5, 0.5},
+	}
+	for _, tt := range tests {
+		if got := Probability(tt.model, tt.tokens); got < tt.min || got > tt.max {
+			t.Errorf("%s: Probability = %v, want between %v and %v", tt.name, got, tt.min, tt.max)
+		}
+	}
+
+	// Only the maxClues most telling clues count; weaker ones beyond them
+	// change nothing
+	clues := &spam.Model{Messages: model.Messages, Tokens: make(map[string]spam.Counts)}
+	var strong, all []string
+	for i := 0; i < maxClues; i++ {
+		token := fmt.Sprintf("strong%d", i)
+		clues.Tokens[token] = spam.Counts{Spam: 80, Ham: 1}
+		strong = append(strong, token)
+	}
+	all = append(all, strong...)
+	for i := 0; i < maxClues; i++ {
+		token := fmt.Sprintf("weak%d", i)
+		clues.Tokens[token] = spam.Counts{Spam: 2, Ham: 5}
+		all = append(all, token)
+	}
+	if got, want := Probability(clues, all), Probability(clues, strong); got != want {
+		t.Errorf("Probability with weaker clues = %v, want %v", got, want)
+	}
+}
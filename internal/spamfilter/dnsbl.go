@@ -0,0 +1,97 @@
+package spamfilter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Resolver looks up DNSBL entries. *net.Resolver implements it; tests
+// inject a fake.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// LookupDNSBL returns the zones that list ip. Zones are queried at once;
+// a zone that fails to answer counts as not listing ip and its error is
+// returned alongside the result.
+func LookupDNSBL(ctx context.Context, resolver Resolver, ip string, zones []string) ([]string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || len(zones) == 0 {
+		return nil, nil
+	}
+	reversed := reverseIP(parsed)
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		listed = make([]bool, len(zones))
+		errs   []error
+	)
+	for i, zone := range zones {
+		wg.Add(1)
+		go func(i int, zone string) {
+			defer wg.Done()
+			ok, err := queryZone(ctx, resolver, reversed+"."+zone)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", zone, err))
+				return
+			}
+			listed[i] = ok
+		}(i, zone)
+	}
+	wg.Wait()
+
+	var result []string
+	for i, zone := range zones {
+		if listed[i] {
+			result = append(result, zone)
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// queryZone reports whether host resolves to a listing. Lists answer
+// 127.0.0.0/8; 127.255.255.0/24 signals a refused query rather than a
+// listing.
+func queryZone(ctx context.Context, resolver Resolver, host string) (bool, error) {
+	addrs, err := resolver.LookupHost(ctx, host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "127.255.255.") {
+			return false, fmt.Errorf("query refused with %s", addr)
+		}
+		if strings.HasPrefix(addr, "127.") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// reverseIP returns the DNSBL query name of ip: its octets, or for IPv6
+// its nibbles, in reverse order
+func reverseIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d", v4[3], v4[2], v4[1], v4[0])
+	}
+
+	const hex = "0123456789abcdef"
+	ip = ip.To16()
+	nibbles := make([]string, 0, 32)
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, string(hex[ip[i]&0xf]), string(hex[ip[i]>>4]))
+	}
+	return strings.Join(nibbles, ".")
+}
@@ -0,0 +1,115 @@
+package spamfilter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers DNSBL queries from a table. Names absent from it
+// are not found, and names answered with nil never answer.
+type fakeResolver struct {
+	mu      sync.Mutex
+	answers map[string][]string
+	errs    map[string]error
+	queries []string
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	r.queries = append(r.queries, host)
+	addrs, ok := r.answers[host]
+	err := r.errs[host]
+	r.mu.Unlock()
+
+	switch {
+	case err != nil:
+		return nil, err
+	case ok && addrs == nil:
+		<-ctx.Done()
+		return nil, &net.DNSError{Err: ctx.Err().Error(), Name: host, IsTimeout: true}
+	case !ok:
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func TestReverseIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.1", "1.2.0.192"},
+		{"::ffff:192.0.2.1", "1.2.0.192"},
+		{"2001:db8::567:89ab", "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2"},
+	}
+	for _, tt := range tests {
+		if got := reverseIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("reverseIP(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestLookupDNSBL(t *testing.T) {
+	resolver := &fakeResolver{
+		answers: map[string][]string{
+			"1.2.0.192.zen.example":     {"127.0.0.2", "127.0.0.4"},
+			"1.2.0.192.bl.example":      {"127.0.0.2"},
+			"1.2.0.192.refused.example": {"127.255.255.254"},
+			// An answer outside 127.0.0.0/8 is no listing
+			"1.2.0.192.wildcard.example": {"198.51.100.7"},
+		},
+		errs: map[string]error{
+			"1.2.0.192.broken.example": &net.DNSError{Err: "server misbehaving", Name: "1.2.0.192.broken.example", IsTemporary: true},
+		},
+	}
+	zones := []string{"zen.example", "clean.example", "refused.example", "wildcard.example", "broken.example", "bl.example"}
+
+	listed, err := LookupDNSBL(context.Background(), resolver, "192.0.2.1", zones)
+	// Listings keep the order of the zones
+	if want := []string{"zen.example", "bl.example"}; !reflect.DeepEqual(listed, want) {
+		t.Fatalf("listed = %q, want %q", listed, want)
+	}
+	// Not being listed is no error; refused and failed queries are
+	if err == nil || !strings.Contains(err.Error(), "refused.example: query refused with 127.255.255.254") ||
+		!strings.Contains(err.Error(), "broken.example") || strings.Contains(err.Error(), "clean.example") {
+		t.Fatalf("err = %v, want the refused and broken zones", err)
+	}
+	if len(resolver.queries) != len(zones) {
+		t.Fatalf("queried %q", resolver.queries)
+	}
+
+	for _, ip := range []string{"", "not an ip", "192.0.2"} {
+		if listed, err := LookupDNSBL(context.Background(), resolver, ip, zones); listed != nil || err != nil {
+			t.Fatalf("LookupDNSBL(%q) = %q, %v, want nothing", ip, listed, err)
+		}
+	}
+}
+
+func TestLookupDNSBLTimeout(t *testing.T) {
+	resolver := &fakeResolver{answers: map[string][]string{
+		"1.2.0.192.slow.example": nil,
+		"1.2.0.192.fast.example": {"127.0.0.2"},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	listed, err := LookupDNSBL(ctx, resolver, "192.0.2.1", []string{"slow.example", "fast.example"})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("lookup took %v past its deadline", elapsed)
+	}
+	// The zone that answered in time still counts
+	if !reflect.DeepEqual(listed, []string{"fast.example"}) {
+		t.Fatalf("listed = %q, want the zone that answered", listed)
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsTimeout {
+		t.Fatalf("err = %v, want the slow zone timed out", err)
+	}
+}
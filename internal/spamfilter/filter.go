@@ -0,0 +1,141 @@
+package spamfilter
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Message is an inbound email together with what the filter needs beyond
+// the stored email
+type Message struct {
+	Email *email.Email
+	// Header is the raw message header; nil skips the header rules
+	Header mail.Header
+	// ClientIP is the address of the server that delivered the message
+	ClientIP string
+}
+
+type Config struct {
+	// Threshold is the score from which a message is spam
+	Threshold float64
+	// BayesWeight scales the Bayesian probability to a score between
+	// -BayesWeight for certain ham and BayesWeight for certain spam
+	BayesWeight float64
+	// DNSBLScore is added for every zone listing the client IP
+	DNSBLScore float64
+	DNSBLZones []string
+	// DNSBLTimeout bounds all DNSBL queries of one message
+	DNSBLTimeout time.Duration
+	// MinMessages is how many spam and ham messages a user must have
+	// trained before their own model replaces the global one
+	MinMessages int64
+}
+
+// Filter classifies messages by Bayesian content probability, header
+// heuristics and DNSBL listings, and trains per-user and global models
+type Filter struct {
+	repo     storage.SpamRepository
+	resolver Resolver
+	rules    []Rule
+	cfg      Config
+	logger   *zap.Logger
+}
+
+func NewFilter(repo storage.SpamRepository, resolver Resolver, cfg Config, logger *zap.Logger) *Filter {
+	return &Filter{
+		repo:     repo,
+		resolver: resolver,
+		rules:    Rules,
+		cfg:      cfg,
+		logger:   logger,
+	}
+}
+
+// Classify scores msg with the model of owner, a staff ID, falling back to
+// the global model while owner's model is not trained enough
+func (f *Filter) Classify(ctx context.Context, owner string, msg *Message) (*email.SpamResult, error) {
+	tokens := Tokenize(msg.Email)
+
+	model, err := f.model(ctx, owner, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := &email.SpamResult{
+		Probability:  Probability(model, tokens),
+		ClassifiedAt: now,
+	}
+	result.Score = f.cfg.BayesWeight * (result.Probability - 0.5) * 2
+
+	for _, rule := range f.rules {
+		if rule.Match(msg, now) {
+			result.Rules = append(result.Rules, rule.Name)
+			result.Score += rule.Score
+		}
+	}
+
+	if len(f.cfg.DNSBLZones) > 0 && msg.ClientIP != "" {
+		lookupCtx, cancel := context.WithTimeout(ctx, f.cfg.DNSBLTimeout)
+		listed, err := LookupDNSBL(lookupCtx, f.resolver, msg.ClientIP, f.cfg.DNSBLZones)
+		cancel()
+		if err != nil {
+			f.logger.Warn("DNSBL lookup failed", zap.String("ip", msg.ClientIP), zap.Error(err))
+		}
+		result.Listed = listed
+		result.Score += f.cfg.DNSBLScore * float64(len(listed))
+	}
+
+	result.IsSpam = result.Score >= f.cfg.Threshold
+	return result, nil
+}
+
+// Train teaches e as spam or ham to the model of owner and to the global
+// model. Untrain reverses it, e.g. before retraining a message as the
+// other class.
+func (f *Filter) Train(ctx context.Context, owner string, e *email.Email, isSpam bool) error {
+	return f.train(ctx, owner, e, isSpam, 1)
+}
+
+func (f *Filter) Untrain(ctx context.Context, owner string, e *email.Email, isSpam bool) error {
+	return f.train(ctx, owner, e, isSpam, -1)
+}
+
+func (f *Filter) train(ctx context.Context, owner string, e *email.Email, isSpam bool, delta int64) error {
+	tokens := Tokenize(e)
+	models := []string{spam.GlobalModel}
+	if owner != spam.GlobalModel {
+		models = append(models, owner)
+	}
+	for _, model := range models {
+		if err := f.repo.Train(ctx, model, isSpam, tokens, delta); err != nil {
+			return fmt.Errorf("failed to train %s spam model: %w", model, err)
+		}
+	}
+	return nil
+}
+
+func (f *Filter) model(ctx context.Context, owner string, tokens []string) (*spam.Model, error) {
+	if owner != "" && owner != spam.GlobalModel {
+		model, err := f.repo.Model(ctx, owner, tokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get spam model: %w", err)
+		}
+		if model.Messages.Spam >= f.cfg.MinMessages && model.Messages.Ham >= f.cfg.MinMessages {
+			return model, nil
+		}
+	}
+
+	model, err := f.repo.Model(ctx, spam.GlobalModel, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get global spam model: %w", err)
+	}
+	return model, nil
+}
@@ -0,0 +1,313 @@
+package spamfilter
+
+import (
+	"context"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+	"github.com/bezata/blockchainml-email/internal/storage/memory"
+	"go.uber.org/zap"
+)
+
+// corpus is a small set of messages to train on, each a sender, subject
+// and body
+var corpus = struct {
+	spam, ham [][3]string
+}{
+	spam: [][3]string{
+		{"promo@deals.example", "Cheap meds online", "Buy cheap viagra online now, no prescription needed. Click http://pills.example/buy"},
+		{"winner@lottery.example", "You are a winner", "Congratulations winner! Claim your $1000000 prize now at http://claim.example"},
+		{"offers@deals.example", "Limited offer", "Cheap replica watches, limited offer, buy now and save 90%"},
+		{"bank@secure-login.example", "Verify your account", "Your account is suspended. Verify your password now at http://secure-login.example"},
+		{"crypto@profit.example", "Double your money", "Guaranteed profit, double your money in crypto today. Click now"},
+		{"promo@deals.example", "Free gift", "Claim your free gift card now, click http://claim.example before it expires"},
+	},
+	ham: [][3]string{
+		{"alice@example.com", "Quarterly report", "Hi team, the quarterly report is attached. Let's review the figures in Thursday's meeting."},
+		{"bob@example.com", "Meeting notes", "Notes from today's planning meeting: budget review moved to next week, Alice owns the report."},
+		{"carol@example.com", "Lunch on Friday", "Anyone up for lunch on Friday after the team meeting?"},
+		{"alice@example.com", "Budget review", "Please send your budget figures before the review on Monday."},
+		{"dave@partner.example", "Contract draft", "Attached is the contract draft we discussed, comments welcome before the meeting."},
+		{"bob@example.com", "Release schedule", "The release is scheduled for next week, please review the notes and the schedule."},
+	},
+}
+
+func corpusEmail(sample [3]string) *email.Email {
+	return &email.Email{
+		MessageID: "<" + sample[1] + "@example>",
+		From:      email.Participant{Email: sample[0]},
+		Subject:   sample[1],
+		Content:   email.EmailContent{Text: sample[2]},
+	}
+}
+
+// corpusMessage wraps e with a well formed header, so that only the
+// model decides
+func corpusMessage(e *email.Email) *Message {
+	return &Message{
+		Email: e,
+		Header: mail.Header{
+			"Message-Id": {e.MessageID},
+			"Date":       {time.Now().Format(time.RFC1123Z)},
+		},
+	}
+}
+
+func testFilter(t *testing.T, resolver Resolver, configure ...func(cfg *Config)) (*Filter, *memory.SpamRepository) {
+	t.Helper()
+
+	cfg := Config{
+		Threshold:    5,
+		BayesWeight:  8,
+		DNSBLScore:   3,
+		DNSBLTimeout: time.Second,
+		MinMessages:  3,
+	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+	repo := memory.NewSpamRepository()
+	return NewFilter(repo, resolver, cfg, zap.NewNop()), repo
+}
+
+func train(t *testing.T, f *Filter, owner string) {
+	t.Helper()
+
+	for _, sample := range corpus.spam {
+		if err := f.Train(context.Background(), owner, corpusEmail(sample), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sample := range corpus.ham {
+		if err := f.Train(context.Background(), owner, corpusEmail(sample), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClassifyTrainedCorpus(t *testing.T) {
+	ctx := context.Background()
+	f, _ := testFilter(t, &fakeResolver{})
+
+	// Untrained, content tells nothing
+	untrained, err := f.Classify(ctx, "alice", corpusMessage(corpusEmail(corpus.spam[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if untrained.Probability != 0.5 || untrained.Score != 0 || untrained.IsSpam {
+		t.Fatalf("untrained result = %+v", untrained)
+	}
+
+	train(t, f, spam.GlobalModel)
+
+	unseen := []struct {
+		sample [3]string
+		isSpam bool
+	}{
+		{[3]string{"promo@deals.example", "Cheap watches", "Buy cheap watches now, click http://claim.example to claim your gift"}, true},
+		{[3]string{"someone@unknown.example", "Claim your prize", "Winner! Verify your account now to claim the prize"}, true},
+		{[3]string{"alice@example.com", "Report figures", "The figures for the quarterly report are ready for review."}, false},
+		{[3]string{"erin@example.com", "Meeting moved", "The planning meeting moved to Friday, notes to follow."}, false},
+	}
+	for _, tt := range unseen {
+		result, err := f.Classify(ctx, "alice", corpusMessage(corpusEmail(tt.sample)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.IsSpam != tt.isSpam {
+			t.Errorf("%q: result = %+v, want spam %v", tt.sample[1], result, tt.isSpam)
+		}
+		if tt.isSpam && result.Probability < 0.9 || !tt.isSpam && result.Probability > 0.1 {
+			t.Errorf("%q: probability = %v", tt.sample[1], result.Probability)
+		}
+	}
+}
+
+func TestClassifyAddsRulesAndListings(t *testing.T) {
+	ctx := context.Background()
+	resolver := &fakeResolver{answers: map[string][]string{
+		"1.2.0.192.zen.example": {"127.0.0.2"},
+		"1.2.0.192.bl.example":  {"127.0.0.3"},
+	}}
+	f, _ := testFilter(t, resolver, func(cfg *Config) { cfg.DNSBLZones = []string{"zen.example", "bl.example", "clean.example"} })
+
+	// A neutral message from a listed server with a shouting subject
+	msg := corpusMessage(&email.Email{
+		MessageID: "<m1@example>",
+		From:      email.Participant{Email: "sender@example.org"},
+		Subject:   "URGENT BUSINESS PROPOSAL",
+		Content:   email.EmailContent{Text: "Please reply"},
+	})
+	msg.ClientIP = "192.0.2.1"
+	result, err := f.Classify(ctx, "alice", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Rules, []string{"SUBJECT_ALL_CAPS"}) || !reflect.DeepEqual(result.Listed, []string{"zen.example", "bl.example"}) {
+		t.Fatalf("rules = %q, listed = %q", result.Rules, result.Listed)
+	}
+	if want := 1.5 + 2*3.0; result.Score != want || !result.IsSpam {
+		t.Fatalf("score = %v, spam %v, want %v and spam", result.Score, result.IsSpam, want)
+	}
+
+	// Without a client IP no zone is queried
+	resolver.queries = nil
+	msg.ClientIP = ""
+	result, err = f.Classify(ctx, "alice", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resolver.queries) != 0 || result.Listed != nil || result.IsSpam {
+		t.Fatalf("queried %q, result = %+v", resolver.queries, result)
+	}
+
+	// A failing list counts as not listing, and does not fail the message
+	resolver.answers = nil
+	resolver.errs = map[string]error{"1.2.0.192.zen.example": context.DeadlineExceeded}
+	msg.ClientIP = "192.0.2.1"
+	if result, err = f.Classify(ctx, "alice", msg); err != nil || result.Listed != nil {
+		t.Fatalf("result = %+v, err = %v", result, err)
+	}
+}
+
+func TestClassifyUsesOwnModelOnceTrained(t *testing.T) {
+	ctx := context.Background()
+	f, repo := testFilter(t, &fakeResolver{})
+	train(t, f, spam.GlobalModel)
+
+	// Alice wants the newsletter everyone else reports as spam
+	newsletter := corpusEmail([3]string{"news@deals.example", "Weekly deals", "This week's deals: cheap flights, buy now, click http://deals.example"})
+	if result, err := f.Classify(ctx, "alice", corpusMessage(newsletter)); err != nil || !result.IsSpam {
+		t.Fatalf("result = %+v, err = %v, want spam by the global model", result, err)
+	}
+
+	// Until alice trained MinMessages of both classes, the global model
+	// still decides
+	for i := 0; i < 3; i++ {
+		if err := repo.Train(ctx, "alice", false, Tokenize(newsletter), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := repo.Train(ctx, "alice", true, Tokenize(corpusEmail(corpus.spam[i])), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result, err := f.Classify(ctx, "alice", corpusMessage(newsletter)); err != nil || !result.IsSpam {
+		t.Fatalf("result = %+v, err = %v, want the global model's verdict", result, err)
+	}
+
+	if err := repo.Train(ctx, "alice", true, Tokenize(corpusEmail(corpus.spam[2])), 1); err != nil {
+		t.Fatal(err)
+	}
+	result, err := f.Classify(ctx, "alice", corpusMessage(newsletter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.IsSpam || result.Probability > 0.5 {
+		t.Fatalf("result = %+v, want ham by alice's model", result)
+	}
+	// Bob still gets the global verdict
+	if result, err := f.Classify(ctx, "bob", corpusMessage(newsletter)); err != nil || !result.IsSpam {
+		t.Fatalf("bob's result = %+v, err = %v, want spam", result, err)
+	}
+
+	// Training reaches the member's model and the global one
+	if err := f.Train(ctx, "bob", newsletter, false); err != nil {
+		t.Fatal(err)
+	}
+	own, err := repo.Model(ctx, "bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, err := repo.Model(ctx, spam.GlobalModel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own.Messages != (spam.Counts{Ham: 1}) || global.Messages != (spam.Counts{Spam: 6, Ham: 7}) {
+		t.Fatalf("bob's model has %+v, the global one %+v", own.Messages, global.Messages)
+	}
+}
+
+func TestUntrainReverses(t *testing.T) {
+	ctx := context.Background()
+	f, repo := testFilter(t, &fakeResolver{})
+	e := corpusEmail(corpus.ham[0])
+
+	// A message misfiled as spam is moved to ham
+	if err := f.Train(ctx, "alice", e, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Untrain(ctx, "alice", e, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Train(ctx, "alice", e, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := Tokenize(e)
+	for _, owner := range []string{"alice", spam.GlobalModel} {
+		model, err := repo.Model(ctx, owner, tokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if model.Messages != (spam.Counts{Ham: 1}) {
+			t.Fatalf("%s messages = %+v", owner, model.Messages)
+		}
+		for _, token := range tokens {
+			if counts := model.Tokens[token]; counts != (spam.Counts{Ham: 1}) {
+				t.Fatalf("%s token %q = %+v", owner, token, counts)
+			}
+		}
+	}
+
+	// The global model is trained once when it is the owner
+	if err := f.Train(ctx, spam.GlobalModel, e, false); err != nil {
+		t.Fatal(err)
+	}
+	if model, _ := repo.Model(ctx, spam.GlobalModel, nil); model.Messages.Ham != 2 {
+		t.Fatalf("global ham = %d, want 2", model.Messages.Ham)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize(&email.Email{
+		From:    email.Participant{Email: "Promo@Deals.Example"},
+		Subject: "FREE gift -- act now!!",
+		Content: email.EmailContent{
+			Text: "Only $99 at http://Shop.Example/buy, don't wait. Free!",
+			HTML: `<p style="color:red">Only&nbsp;<b>€99</b> at <a href="https://cdn.example/x">cdn</a></p>`,
+		},
+	})
+	want := []string{
+		"from:promo@deals.example", "from-domain:deals.example",
+		"subject:free", "subject:gift", "subject:act", "subject:now!!",
+		"url:shop.example", "url:cdn.example",
+		"only", "$99", "http", "shop", "example", "buy", "don't", "wait", "free!",
+		"€99", "cdn",
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Fatalf("tokens = %q\nwant %q", tokens, want)
+	}
+
+	// Words too short or too long are left out, and tokens are capped
+	long := strings.Repeat("x", maxTokenLength+1)
+	var words []string
+	for i := 0; i < 2*maxTokens; i++ {
+		words = append(words, "word"+strings.Repeat("a", i%30)+string(rune('a'+i%26))+string(rune('a'+i/26%26)))
+	}
+	tokens = Tokenize(&email.Email{Content: email.EmailContent{Text: "a to " + long + " " + strings.Join(words, " ")}})
+	if len(tokens) != maxTokens {
+		t.Fatalf("%d tokens, want %d", len(tokens), maxTokens)
+	}
+	for _, token := range tokens {
+		if len(token) < minTokenLength || len(token) > maxTokenLength {
+			t.Fatalf("token %q kept", token)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package spamfilter
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxClockSkew is how far in the future a Date header may be
+const maxClockSkew = 12 * time.Hour
+
+// Rule is a header or content heuristic adding Score when it matches
+type Rule struct {
+	Name  string
+	Score float64
+	Match func(msg *Message, now time.Time) bool
+}
+
+// Rules are the heuristics Filter applies by default
+var Rules = []Rule{
+	{Name: "MISSING_MESSAGE_ID", Score: 1.5, Match: missingMessageID},
+	{Name: "MISSING_DATE", Score: 1.0, Match: missingDate},
+	{Name: "DATE_IN_FUTURE", Score: 1.5, Match: dateInFuture},
+	{Name: "EMPTY_SUBJECT", Score: 0.5, Match: emptySubject},
+	{Name: "SUBJECT_ALL_CAPS", Score: 1.5, Match: subjectAllCaps},
+	{Name: "SUBJECT_EXCLAMATIONS", Score: 1.0, Match: subjectExclamations},
+	{Name: "HTML_ONLY", Score: 1.0, Match: htmlOnly},
+	{Name: "FROM_NAME_SPOOFS_ADDRESS", Score: 2.5, Match: fromNameSpoofsAddress},
+	{Name: "REPLY_TO_OTHER_DOMAIN", Score: 1.0, Match: replyToOtherDomain},
+}
+
+func missingMessageID(msg *Message, now time.Time) bool {
+	return msg.Header.Get("Message-Id") == "" && msg.Email.MessageID == ""
+}
+
+func missingDate(msg *Message, now time.Time) bool {
+	return msg.Header != nil && msg.Header.Get("Date") == ""
+}
+
+func dateInFuture(msg *Message, now time.Time) bool {
+	date, err := msg.Header.Date()
+	return err == nil && date.After(now.Add(maxClockSkew))
+}
+
+func emptySubject(msg *Message, now time.Time) bool {
+	return strings.TrimSpace(msg.Email.Subject) == ""
+}
+
+func subjectAllCaps(msg *Message, now time.Time) bool {
+	var letters int
+	for _, r := range msg.Email.Subject {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsUpper(r) {
+			letters++
+		}
+	}
+	return letters >= 10
+}
+
+func subjectExclamations(msg *Message, now time.Time) bool {
+	return strings.Count(msg.Email.Subject, "!") >= 3
+}
+
+func htmlOnly(msg *Message, now time.Time) bool {
+	return msg.Email.Content.HTML != "" && strings.TrimSpace(msg.Email.Content.Text) == ""
+}
+
+// fromNameSpoofsAddress matches display names like "support@bank.com"
+// sent from another address
+func fromNameSpoofsAddress(msg *Message, now time.Time) bool {
+	name := msg.Email.From.FullName
+	if !strings.Contains(name, "@") {
+		return false
+	}
+	address, err := mail.ParseAddress(strings.Trim(name, `"' `))
+	return err == nil && !strings.EqualFold(address.Address, msg.Email.From.Email)
+}
+
+func replyToOtherDomain(msg *Message, now time.Time) bool {
+	replyTo, err := mail.ParseAddress(msg.Header.Get("Reply-To"))
+	if err != nil {
+		return false
+	}
+	return !strings.EqualFold(domainOf(replyTo.Address), domainOf(msg.Email.From.Email))
+}
+
+func domainOf(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	return domain
+}
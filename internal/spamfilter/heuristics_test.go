@@ -0,0 +1,78 @@
+package spamfilter
+
+import (
+	"net/mail"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+)
+
+func TestRules(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	// message returns a well formed message, changed by fn
+	message := func(fn func(msg *Message)) *Message {
+		msg := &Message{
+			Email: &email.Email{
+				MessageID: "<report@example.com>",
+				From:      email.Participant{Email: "alice@example.com", FullName: "Alice"},
+				Subject:   "Quarterly report",
+				Content:   email.EmailContent{Text: "Figures attached", HTML: "<p>Figures attached</p>"},
+			},
+			Header: mail.Header{
+				"Message-Id": {"<report@example.com>"},
+				"Date":       {"Mon, 19 Oct 2026 11:58:00 +0000"},
+			},
+		}
+		if fn != nil {
+			fn(msg)
+		}
+		return msg
+	}
+
+	tests := []struct {
+		name string
+		msg  *Message
+		want []string
+	}{
+		{"well formed", message(nil), nil},
+		{"without header", message(func(msg *Message) { msg.Header = nil }), nil},
+		{"message ID in the email only", message(func(msg *Message) { delete(msg.Header, "Message-Id") }), nil},
+		{"missing message ID", message(func(msg *Message) {
+			delete(msg.Header, "Message-Id")
+			msg.Email.MessageID = ""
+		}), []string{"MISSING_MESSAGE_ID"}},
+		{"missing date", message(func(msg *Message) { delete(msg.Header, "Date") }), []string{"MISSING_DATE"}},
+		{"date within clock skew", message(func(msg *Message) { msg.Header["Date"] = []string{"Mon, 19 Oct 2026 23:00:00 +0000"} }), nil},
+		{"date in future", message(func(msg *Message) { msg.Header["Date"] = []string{"Tue, 20 Oct 2026 01:00:00 +0000"} }), []string{"DATE_IN_FUTURE"}},
+		{"unparsable date", message(func(msg *Message) { msg.Header["Date"] = []string{"yesterday"} }), nil},
+		{"empty subject", message(func(msg *Message) { msg.Email.Subject = "  " }), []string{"EMPTY_SUBJECT"}},
+		{"all caps subject", message(func(msg *Message) { msg.Email.Subject = "FREE MONEY INSIDE" }), []string{"SUBJECT_ALL_CAPS"}},
+		{"short caps subject", message(func(msg *Message) { msg.Email.Subject = "RE: Q3 KPI" }), nil},
+		{"caps with digits", message(func(msg *Message) { msg.Email.Subject = "WIN 1000 EUROS NOW" }), []string{"SUBJECT_ALL_CAPS"}},
+		{"exclamations", message(func(msg *Message) { msg.Email.Subject = "Act now!!!" }), []string{"SUBJECT_EXCLAMATIONS"}},
+		{"caps and exclamations", message(func(msg *Message) { msg.Email.Subject = "ACT NOW, WINNER!!!" }), []string{"SUBJECT_ALL_CAPS", "SUBJECT_EXCLAMATIONS"}},
+		{"html only", message(func(msg *Message) { msg.Email.Content.Text = "\n" }), []string{"HTML_ONLY"}},
+		{"text only", message(func(msg *Message) { msg.Email.Content.HTML = "" }), nil},
+		{"name spoofs address", message(func(msg *Message) { msg.Email.From.FullName = `"support@bank.example"` }), []string{"FROM_NAME_SPOOFS_ADDRESS"}},
+		{"name is the address", message(func(msg *Message) { msg.Email.From.FullName = "Alice@Example.com" }), nil},
+		{"name with an at sign", message(func(msg *Message) { msg.Email.From.FullName = "Alice @ Example" }), nil},
+		{"reply to other domain", message(func(msg *Message) { msg.Header["Reply-To"] = []string{"Billing <billing@other.example>"} }), []string{"REPLY_TO_OTHER_DOMAIN"}},
+		{"reply to same domain", message(func(msg *Message) { msg.Header["Reply-To"] = []string{"team@EXAMPLE.com"} }), nil},
+		{"invalid reply to", message(func(msg *Message) { msg.Header["Reply-To"] = []string{"not an address"} }), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, rule := range Rules {
+				if rule.Match(tt.msg, now) {
+					got = append(got, rule.Name)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("matched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package spamfilter
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+)
+
+const (
+	minTokenLength = 3
+	maxTokenLength = 40
+	// maxTokens bounds the tokens of one message, and so the size of a
+	// training update
+	maxTokens = 1000
+)
+
+var (
+	htmlTag = regexp.MustCompile(`(?s)<[^>]*>`)
+	urlHost = regexp.MustCompile(`(?i)https?://([a-z0-9.-]+)`)
+)
+
+// Tokenize returns the distinct tokens of an email's sender, subject and
+// content. Subject words and link hosts are prefixed so that they are
+// weighed apart from body words.
+func Tokenize(e *email.Email) []string {
+	t := tokenizer{seen: make(map[string]bool)}
+
+	if address := strings.ToLower(e.From.Email); address != "" {
+		t.add("from:" + address)
+		if _, domain, ok := strings.Cut(address, "@"); ok {
+			t.add("from-domain:" + domain)
+		}
+	}
+
+	t.words("subject:", e.Subject)
+
+	body := e.Content.Text
+	if e.Content.HTML != "" {
+		body += " " + html.UnescapeString(htmlTag.ReplaceAllString(e.Content.HTML, " "))
+	}
+	for _, match := range urlHost.FindAllStringSubmatch(e.Content.Text+" "+e.Content.HTML, -1) {
+		t.add("url:" + strings.ToLower(match[1]))
+	}
+	t.words("", body)
+
+	return t.tokens
+}
+
+type tokenizer struct {
+	seen   map[string]bool
+	tokens []string
+}
+
+func (t *tokenizer) words(prefix, text string) {
+	for _, word := range strings.FieldsFunc(text, isSeparator) {
+		word = strings.ToLower(strings.Trim(word, "-'"))
+		if n := len(word); n < minTokenLength || n > maxTokenLength {
+			continue
+		}
+		t.add(prefix + word)
+	}
+}
+
+func (t *tokenizer) add(token string) {
+	if len(t.tokens) >= maxTokens || t.seen[token] {
+		return
+	}
+	t.seen[token] = true
+	t.tokens = append(t.tokens, token)
+}
+
+// isSeparator keeps letters, digits and the characters that tell prices and
+// obfuscated words apart
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("$€£!-'", r)
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/audit"
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/domain/suppression"
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+	"github.com/bezata/blockchainml-email/internal/domain/thread"
+	"github.com/bezata/blockchainml-email/internal/jobs"
+)
+
+// ErrInvalidCursor is returned by List when the cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// Cursor marks the position after the last item of a page. Key is the value
+// of the sort field and ID breaks ties between items with the same key.
+type Cursor struct {
+	Key string
+	ID  string
+}
+
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Key + "\x00" + c.ID))
+}
+
+// DecodeCursor parses a cursor produced by Encode. An empty string is the
+// first page and decodes to nil.
+func DecodeCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	key, id, ok := strings.Cut(string(data), "\x00")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{Key: key, ID: id}, nil
+}
+
+// Time returns the key of a cursor over a time field
+func (c Cursor) Time() (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, c.Key)
+	if err != nil {
+		return time.Time{}, ErrInvalidCursor
+	}
+	return t, nil
+}
+
+// Emails are listed newest first by createdAt
+func EmailCursor(e *email.Email) string {
+	return Cursor{Key: e.CreatedAt.UTC().Format(time.RFC3339Nano), ID: e.ID.Hex()}.Encode()
+}
+
+// Staff are listed alphabetically by full name
+func StaffCursor(s *staff.Staff) string {
+	return Cursor{Key: s.FullName, ID: s.ID.Hex()}.Encode()
+}
+
+// Templates are listed alphabetically by name
+func TemplateCursor(t *template.Template) string {
+	return Cursor{Key: t.Name, ID: t.ID.Hex()}.Encode()
+}
+
+// Campaigns are listed newest first by createdAt
+func CampaignCursor(c *campaign.Campaign) string {
+	return Cursor{Key: c.CreatedAt.UTC().Format(time.RFC3339Nano), ID: c.ID.Hex()}.Encode()
+}
+
+// Recipients are listed in the order they were added, by ID
+func RecipientCursor(r *campaign.Recipient) string {
+	return Cursor{ID: r.ID.Hex()}.Encode()
+}
+
+// Suppressions are listed alphabetically by address
+func SuppressionCursor(s *suppression.Suppression) string {
+	return Cursor{ID: s.Email}.Encode()
+}
+
+// Threads are listed by most recent message first
+func ThreadCursor(t *thread.Thread) string {
+	return Cursor{Key: t.LastMessage.SentAt.UTC().Format(time.RFC3339Nano), ID: t.ThreadID}.Encode()
+}
+
+// Audit events are listed newest first by timestamp
+func AuditCursor(e *audit.Event) string {
+	return Cursor{Key: e.Timestamp.UTC().Format(time.RFC3339Nano), ID: e.ID}.Encode()
+}
+
+// Jobs are listed newest first by createdAt
+func JobCursor(j *jobs.Job) string {
+	return Cursor{Key: j.CreatedAt.UTC().Format(time.RFC3339Nano), ID: j.ID}.Encode()
+}
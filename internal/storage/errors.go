@@ -0,0 +1,33 @@
+package storage
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is returned when a write violates a unique constraint
+	ErrDuplicate = errors.New("record already exists")
+	// ErrBlobDeleting is returned when a blob is being garbage collected
+	ErrBlobDeleting = errors.New("blob is being deleted")
+	// ErrConflict is returned when a conditional write finds the record
+	// changed since it was read
+	ErrConflict = errors.New("record was modified concurrently")
+)
+
+const (
+	// DefaultPageSize is used by List when a query sets no limit
+	DefaultPageSize = 50
+	// MaxPageSize caps the limit of a single List call
+	MaxPageSize = 500
+)
+
+// PageSize clamps a requested limit to the supported range
+func PageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
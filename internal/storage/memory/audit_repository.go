@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/audit"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+)
+
+type AuditRepository struct {
+	mu     sync.RWMutex
+	events map[string]*audit.Event
+}
+
+func NewAuditRepository() *AuditRepository {
+	return &AuditRepository{events: make(map[string]*audit.Event)}
+}
+
+func (r *AuditRepository) StoreAuditEvent(ctx context.Context, event *audit.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if _, ok := r.events[event.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.events[event.ID] = clone(event)
+	return nil
+}
+
+// ListAuditEvents returns one page of audit events, newest first
+func (r *AuditRepository) ListAuditEvents(ctx context.Context, query *audit.ListQuery) ([]*audit.Event, error) {
+	if query == nil {
+		query = &audit.ListQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var last *audit.Event
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		last = &audit.Event{ID: after.ID, Timestamp: t}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*audit.Event
+	for _, e := range r.events {
+		if matchAuditEvent(e, query) {
+			matches = append(matches, e)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return auditBefore(matches[i], matches[j]) })
+
+	if last != nil {
+		matches = dropThrough(matches, func(e *audit.Event) bool { return !auditBefore(last, e) })
+	}
+
+	results := make([]*audit.Event, 0, pageSize(query.Limit, len(matches)))
+	for _, e := range matches[:cap(results)] {
+		results = append(results, clone(e))
+	}
+	return results, nil
+}
+
+// auditBefore orders events by timestamp then ID, both descending
+func auditBefore(a, b *audit.Event) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.After(b.Timestamp)
+	}
+	return a.ID > b.ID
+}
+
+func matchAuditEvent(e *audit.Event, query *audit.ListQuery) bool {
+	for _, f := range []struct{ want, got string }{
+		{query.ActorID, e.ActorID},
+		{query.Action, e.Action},
+		{query.Resource, e.Resource},
+		{query.ResourceID, e.ResourceID},
+		{query.Risk, e.Risk},
+	} {
+		if f.want != "" && f.want != f.got {
+			return false
+		}
+	}
+	if query.Since != nil && e.Timestamp.Before(millis(*query.Since)) {
+		return false
+	}
+	if query.Until != nil && !e.Timestamp.Before(millis(*query.Until)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/blob"
+	"github.com/bezata/blockchainml-email/internal/storage"
+)
+
+type BlobRepository struct {
+	mu    sync.Mutex
+	blobs map[string]*blob.Blob
+}
+
+func NewBlobRepository() *BlobRepository {
+	return &BlobRepository{blobs: make(map[string]*blob.Blob)}
+}
+
+func (r *BlobRepository) Acquire(ctx context.Context, hash string, size int64) (*blob.Blob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	stored, ok := r.blobs[hash]
+	if !ok {
+		stored = &blob.Blob{Hash: hash, Size: size, CreatedAt: now}
+		r.blobs[hash] = stored
+	} else if stored.Deleting && !deleteExpired(stored, now) {
+		return nil, storage.ErrBlobDeleting
+	}
+
+	stored.RefCount++
+	stored.Deleting = false
+	stored.UpdatedAt = now
+	r.blobs[hash] = clone(stored)
+	return clone(stored), nil
+}
+
+func (r *BlobRepository) Release(ctx context.Context, hash string) (*blob.Blob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.blobs[hash]
+	if !ok || stored.RefCount <= 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	stored.RefCount--
+	stored.UpdatedAt = time.Now()
+	r.blobs[hash] = clone(stored)
+	return clone(stored), nil
+}
+
+func (r *BlobRepository) MarkDeleting(ctx context.Context, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	stored, ok := r.blobs[hash]
+	if !ok || stored.RefCount != 0 || (stored.Deleting && !deleteExpired(stored, now)) {
+		return storage.ErrNotFound
+	}
+
+	stored.Deleting = true
+	stored.UpdatedAt = now
+	return nil
+}
+
+func (r *BlobRepository) Delete(ctx context.Context, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.blobs[hash]
+	if !ok || !stored.Deleting {
+		return storage.ErrNotFound
+	}
+
+	delete(r.blobs, hash)
+	return nil
+}
+
+func (r *BlobRepository) Stats(ctx context.Context) (*blob.Stats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var stats blob.Stats
+	for _, stored := range r.blobs {
+		if stored.RefCount <= 0 {
+			continue
+		}
+		stats.Blobs++
+		stats.References += stored.RefCount
+		stats.StoredBytes += stored.Size
+		stats.LogicalBytes += stored.Size * stored.RefCount
+	}
+	return &stats, nil
+}
+
+// deleteExpired reports whether a deletion mark was left behind long enough
+// ago to be taken over
+func deleteExpired(b *blob.Blob, now time.Time) bool {
+	return b.UpdatedAt.Before(now.Add(-blob.DeleteTimeout))
+}
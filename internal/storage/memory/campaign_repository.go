@@ -0,0 +1,259 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CampaignRepository struct {
+	mu         sync.RWMutex
+	campaigns  map[primitive.ObjectID]*campaign.Campaign
+	recipients map[primitive.ObjectID]*campaign.Recipient
+}
+
+func NewCampaignRepository() *CampaignRepository {
+	return &CampaignRepository{
+		campaigns:  make(map[primitive.ObjectID]*campaign.Campaign),
+		recipients: make(map[primitive.ObjectID]*campaign.Recipient),
+	}
+}
+
+func (r *CampaignRepository) Create(ctx context.Context, c *campaign.Campaign) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.campaigns[c.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.campaigns[c.ID] = clone(c)
+	return nil
+}
+
+func (r *CampaignRepository) Get(ctx context.Context, id string) (*campaign.Campaign, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.campaigns[oid]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(stored), nil
+}
+
+func (r *CampaignRepository) Update(ctx context.Context, c *campaign.Campaign) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.campaigns[c.ID]; !ok {
+		return storage.ErrNotFound
+	}
+	r.campaigns[c.ID] = clone(c)
+	return nil
+}
+
+func (r *CampaignRepository) Delete(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.campaigns[oid]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(r.campaigns, oid)
+	for rid, recipient := range r.recipients {
+		if recipient.CampaignID == id {
+			delete(r.recipients, rid)
+		}
+	}
+	return nil
+}
+
+// List returns one page of campaigns, newest first
+func (r *CampaignRepository) List(ctx context.Context, query *campaign.ListQuery) ([]*campaign.Campaign, error) {
+	if query == nil {
+		query = &campaign.ListQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var last *campaign.Campaign
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		last = &campaign.Campaign{ID: id, CreatedAt: t}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*campaign.Campaign
+	for _, c := range r.campaigns {
+		if (query.CreatedBy == "" || c.CreatedBy == query.CreatedBy) && (query.Status == "" || c.Status == query.Status) {
+			matches = append(matches, c)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return campaignBefore(matches[i], matches[j]) })
+
+	if last != nil {
+		matches = dropThrough(matches, func(c *campaign.Campaign) bool { return !campaignBefore(last, c) })
+	}
+
+	results := make([]*campaign.Campaign, 0, pageSize(query.Limit, len(matches)))
+	for _, c := range matches[:cap(results)] {
+		results = append(results, clone(c))
+	}
+	return results, nil
+}
+
+func (r *CampaignRepository) AddRecipients(ctx context.Context, recipients []*campaign.Recipient) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	listed := make(map[[2]string]bool)
+	for _, stored := range r.recipients {
+		listed[[2]string{stored.CampaignID, stored.Email}] = true
+	}
+
+	added := 0
+	for _, recipient := range recipients {
+		key := [2]string{recipient.CampaignID, recipient.Email}
+		if listed[key] {
+			continue
+		}
+		if recipient.ID.IsZero() {
+			recipient.ID = primitive.NewObjectID()
+		}
+		listed[key] = true
+		r.recipients[recipient.ID] = clone(recipient)
+		added++
+	}
+	return added, nil
+}
+
+// ListRecipients returns one page of recipients in the order they were
+// added
+func (r *CampaignRepository) ListRecipients(ctx context.Context, query *campaign.RecipientQuery) ([]*campaign.Recipient, error) {
+	if query == nil {
+		query = &campaign.RecipientQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var last primitive.ObjectID
+	if after != nil {
+		if last, err = primitive.ObjectIDFromHex(after.ID); err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*campaign.Recipient
+	for _, recipient := range r.recipients {
+		if matchRecipient(recipient, query) && (after == nil || compareIDs(recipient.ID, last) > 0) {
+			matches = append(matches, recipient)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return compareIDs(matches[i].ID, matches[j].ID) < 0 })
+
+	results := make([]*campaign.Recipient, 0, pageSize(query.Limit, len(matches)))
+	for _, recipient := range matches[:cap(results)] {
+		results = append(results, clone(recipient))
+	}
+	return results, nil
+}
+
+func (r *CampaignRepository) UpdateRecipient(ctx context.Context, recipient *campaign.Recipient) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.recipients[recipient.ID]; !ok {
+		return storage.ErrNotFound
+	}
+	r.recipients[recipient.ID] = clone(recipient)
+	return nil
+}
+
+func (r *CampaignRepository) ReplaceRecipient(ctx context.Context, recipient *campaign.Recipient, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.recipients[recipient.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if stored.Status != status {
+		return storage.ErrConflict
+	}
+	r.recipients[recipient.ID] = clone(recipient)
+	return nil
+}
+
+func (r *CampaignRepository) CountRecipients(ctx context.Context, campaignID string) (map[string]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, recipient := range r.recipients {
+		if recipient.CampaignID == campaignID {
+			counts[recipient.Status]++
+		}
+	}
+	return counts, nil
+}
+
+// campaignBefore orders campaigns by createdAt then ID, both descending
+func campaignBefore(a, b *campaign.Campaign) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return compareIDs(a.ID, b.ID) > 0
+}
+
+func matchRecipient(recipient *campaign.Recipient, query *campaign.RecipientQuery) bool {
+	switch {
+	case query.CampaignID != "" && recipient.CampaignID != query.CampaignID,
+		query.Email != "" && recipient.Email != query.Email,
+		query.Token != "" && recipient.Token != query.Token,
+		query.MessageID != "" && recipient.MessageID != query.MessageID:
+		return false
+	}
+	if len(query.Statuses) == 0 {
+		return true
+	}
+	for _, status := range query.Statuses {
+		if recipient.Status == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailRepository also holds the labels of mailboxes, see Labels, so
+// that their counters change under the same lock as the emails
+type EmailRepository struct {
+	mu     sync.RWMutex
+	emails map[primitive.ObjectID]*email.Email
+	// messageIDs holds the email with each message ID in every mailbox
+	messageIDs map[messageKey]primitive.ObjectID
+	labels     map[primitive.ObjectID]*label.Label
+}
+
+// messageKey identifies a message in a mailbox. Every recipient's copy of
+// a message shares its message ID.
+type messageKey struct {
+	mailbox   string
+	messageID string
+}
+
+func keyOf(e *email.Email) messageKey {
+	return messageKey{mailbox: e.Mailbox, messageID: e.MessageID}
+}
+
+func NewEmailRepository() *EmailRepository {
+	return &EmailRepository{
+		emails:     make(map[primitive.ObjectID]*email.Email),
+		messageIDs: make(map[messageKey]primitive.ObjectID),
+		labels:     make(map[primitive.ObjectID]*label.Label),
+	}
+}
+
+func (r *EmailRepository) Create(ctx context.Context, e *email.Email) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e.ID.IsZero() {
+		e.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.emails[e.ID]; ok {
+		return storage.ErrDuplicate
+	}
+	if _, ok := r.messageIDs[keyOf(e)]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.emails[e.ID] = clone(e)
+	r.messageIDs[keyOf(e)] = e.ID
+	r.countLabels(nil, e)
+	return nil
+}
+
+func (r *EmailRepository) Get(ctx context.Context, id string) (*email.Email, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.emails[oid]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(stored), nil
+}
+
+func (r *EmailRepository) Update(ctx context.Context, e *email.Email) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.emails[e.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if owner, ok := r.messageIDs[keyOf(e)]; ok && owner != e.ID {
+		return storage.ErrDuplicate
+	}
+
+	r.replace(stored, e)
+	return nil
+}
+
+func (r *EmailRepository) ReplaceVersion(ctx context.Context, e *email.Email, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.emails[e.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if stored.Version != version {
+		return storage.ErrConflict
+	}
+	if owner, ok := r.messageIDs[keyOf(e)]; ok && owner != e.ID {
+		return storage.ErrDuplicate
+	}
+
+	r.replace(stored, e)
+	return nil
+}
+
+// replace stores e over stored, keeping the stored delivery state and
+// advancing the version
+func (r *EmailRepository) replace(stored, e *email.Email) {
+	e.Version = stored.Version + 1
+	updated := clone(e)
+	updated.Delivery, updated.DeliveryVersion = stored.Delivery, stored.DeliveryVersion
+	delete(r.messageIDs, keyOf(stored))
+	r.emails[e.ID] = updated
+	r.messageIDs[keyOf(e)] = e.ID
+	r.countLabels(stored, e)
+}
+
+func (r *EmailRepository) ReplaceDraft(ctx context.Context, e *email.Email, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.emails[e.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if stored.Draft == nil || stored.Draft.Version != version {
+		return storage.ErrConflict
+	}
+	if owner, ok := r.messageIDs[keyOf(e)]; ok && owner != e.ID {
+		return storage.ErrDuplicate
+	}
+
+	e.Version = stored.Version + 1
+	delete(r.messageIDs, keyOf(stored))
+	r.emails[e.ID] = clone(e)
+	r.messageIDs[keyOf(e)] = e.ID
+	r.countLabels(stored, e)
+	return nil
+}
+
+func (r *EmailRepository) ReplaceDelivery(ctx context.Context, e *email.Email, version int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.emails[e.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if stored.DeliveryVersion != version {
+		return storage.ErrConflict
+	}
+
+	updated := clone(e)
+	stored.Delivery, stored.DeliveryVersion, stored.UpdatedAt = updated.Delivery, updated.DeliveryVersion, updated.UpdatedAt
+	return nil
+}
+
+func (r *EmailRepository) Delete(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.emails[oid]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	delete(r.messageIDs, keyOf(stored))
+	delete(r.emails, oid)
+	r.countLabels(stored, nil)
+	return nil
+}
+
+// List returns one page of emails matching the query, newest first
+func (r *EmailRepository) List(ctx context.Context, query *email.ListQuery) ([]*email.Email, error) {
+	if query == nil {
+		query = &email.ListQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var last *email.Email
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		last = &email.Email{ID: id, CreatedAt: t}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*email.Email
+	for _, e := range r.emails {
+		if matchEmail(e, query) {
+			matches = append(matches, e)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return emailBefore(matches[i], matches[j]) })
+
+	if last != nil {
+		matches = dropThrough(matches, func(e *email.Email) bool { return !emailBefore(last, e) })
+	}
+
+	results := make([]*email.Email, 0, pageSize(query.Limit, len(matches)))
+	for _, e := range matches[:cap(results)] {
+		results = append(results, clone(e))
+	}
+	return results, nil
+}
+
+// emailBefore orders emails by createdAt then ID, both descending
+func emailBefore(a, b *email.Email) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return compareIDs(a.ID, b.ID) > 0
+}
+
+// dropThrough removes the leading items for which atOrBeforeCursor holds
+func dropThrough[T any](items []T, atOrBeforeCursor func(T) bool) []T {
+	i := sort.Search(len(items), func(i int) bool { return !atOrBeforeCursor(items[i]) })
+	return items[i:]
+}
+
+func matchEmail(e *email.Email, query *email.ListQuery) bool {
+	if query.Mailbox != "" && e.Mailbox != query.Mailbox {
+		return false
+	}
+	if query.Participant != "" && !hasParticipant(e, query.Participant) {
+		return false
+	}
+	if query.From != "" && e.From.Email != query.From {
+		return false
+	}
+	if query.MessageID != "" && e.MessageID != query.MessageID {
+		return false
+	}
+	if query.ThreadID != "" && (e.ThreadID == nil || *e.ThreadID != query.ThreadID) {
+		return false
+	}
+	for _, label := range query.Labels {
+		if !contains(e.Labels, label) {
+			return false
+		}
+	}
+	if query.IsRead != nil && e.Flags.IsRead != *query.IsRead {
+		return false
+	}
+	if query.IsStarred != nil && e.Flags.IsStarred != *query.IsStarred {
+		return false
+	}
+	if query.IsDraft != nil && e.Flags.IsDraft != *query.IsDraft {
+		return false
+	}
+	if query.Since != nil && e.CreatedAt.Before(millis(*query.Since)) {
+		return false
+	}
+	if query.Until != nil && !e.CreatedAt.Before(millis(*query.Until)) {
+		return false
+	}
+	return true
+}
+
+func hasParticipant(e *email.Email, address string) bool {
+	if e.From.Email == address {
+		return true
+	}
+	for _, list := range [][]email.Participant{e.To, e.CC, e.BCC} {
+		for _, p := range list {
+			if p.Email == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/jobs"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+)
+
+type JobRepository struct {
+	mu   sync.Mutex
+	jobs map[string]*jobs.Job
+}
+
+func NewJobRepository() *JobRepository {
+	return &JobRepository{jobs: make(map[string]*jobs.Job)}
+}
+
+func (r *JobRepository) Enqueue(ctx context.Context, job *jobs.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	if job.Status == "" {
+		job.Status = jobs.StatusPending
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	job.CreatedAt, job.UpdatedAt = now, now
+
+	if _, ok := r.jobs[job.ID]; ok {
+		return storage.ErrDuplicate
+	}
+	r.jobs[job.ID] = clone(job)
+	return nil
+}
+
+func (r *JobRepository) Get(ctx context.Context, id string) (*jobs.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.jobs[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(stored), nil
+}
+
+func (r *JobRepository) Claim(ctx context.Context, worker string, lease time.Duration) (*jobs.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := millis(time.Now())
+	var next *jobs.Job
+	for _, job := range r.jobs {
+		due := job.Status == jobs.StatusPending && !job.RunAt.After(now)
+		expired := job.Status == jobs.StatusRunning && job.LockedUntil != nil && job.LockedUntil.Before(now)
+		if !due && !expired {
+			continue
+		}
+		if next == nil || job.RunAt.Before(next.RunAt) || (job.RunAt.Equal(next.RunAt) && job.ID < next.ID) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, storage.ErrNotFound
+	}
+
+	lockedUntil := now.Add(lease)
+	next.Status = jobs.StatusRunning
+	next.LockedBy = worker
+	next.LockedUntil = &lockedUntil
+	next.UpdatedAt = now
+	next.Attempts++
+
+	// Round trip so the caller sees the same precision a later Get returns
+	r.jobs[next.ID] = clone(next)
+	return clone(next), nil
+}
+
+func (r *JobRepository) Complete(ctx context.Context, id, worker string) error {
+	return r.finish(id, worker, func(job *jobs.Job, now time.Time) {
+		job.Status = jobs.StatusCompleted
+		job.CompletedAt = &now
+	})
+}
+
+func (r *JobRepository) Fail(ctx context.Context, id, worker, reason string, retryAt *time.Time) error {
+	return r.finish(id, worker, func(job *jobs.Job, now time.Time) {
+		job.Status = jobs.StatusFailed
+		job.LastError = reason
+		if retryAt != nil {
+			job.Status = jobs.StatusPending
+			job.RunAt = *retryAt
+		}
+	})
+}
+
+func (r *JobRepository) finish(id, worker string, apply func(job *jobs.Job, now time.Time)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok || job.Status != jobs.StatusRunning || job.LockedBy != worker {
+		return storage.ErrNotFound
+	}
+
+	now := time.Now()
+	apply(job, now)
+	job.LockedBy = ""
+	job.LockedUntil = nil
+	job.UpdatedAt = now
+	r.jobs[id] = clone(job)
+	return nil
+}
+
+// List returns one page of jobs, newest first
+func (r *JobRepository) List(ctx context.Context, query *jobs.ListQuery) ([]*jobs.Job, error) {
+	if query == nil {
+		query = &jobs.ListQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var last *jobs.Job
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		last = &jobs.Job{ID: after.ID, CreatedAt: t}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matches []*jobs.Job
+	for _, job := range r.jobs {
+		if (query.Type == "" || job.Type == query.Type) && (query.Status == "" || job.Status == query.Status) {
+			matches = append(matches, job)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return jobBefore(matches[i], matches[j]) })
+
+	if last != nil {
+		matches = dropThrough(matches, func(job *jobs.Job) bool { return !jobBefore(last, job) })
+	}
+
+	results := make([]*jobs.Job, 0, pageSize(query.Limit, len(matches)))
+	for _, job := range matches[:cap(results)] {
+		results = append(results, clone(job))
+	}
+	return results, nil
+}
+
+// jobBefore orders jobs by createdAt then ID, both descending
+func jobBefore(a, b *jobs.Job) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
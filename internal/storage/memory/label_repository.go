@@ -0,0 +1,192 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LabelRepository stores labels in the EmailRepository it belongs to
+type LabelRepository struct {
+	r *EmailRepository
+}
+
+// Labels returns the repository of the labels counting the emails of r
+func (r *EmailRepository) Labels() *LabelRepository {
+	return &LabelRepository{r: r}
+}
+
+func (l *LabelRepository) Create(ctx context.Context, lbl *label.Label) error {
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+
+	if lbl.ID.IsZero() {
+		lbl.ID = primitive.NewObjectID()
+	}
+	if _, ok := l.r.labels[lbl.ID]; ok {
+		return storage.ErrDuplicate
+	}
+	if l.r.findLabel(lbl.Owner, lbl.Name) != nil {
+		return storage.ErrDuplicate
+	}
+
+	l.r.labels[lbl.ID] = clone(lbl)
+	return nil
+}
+
+func (l *LabelRepository) Get(ctx context.Context, id string) (*label.Label, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	l.r.mu.RLock()
+	defer l.r.mu.RUnlock()
+
+	stored, ok := l.r.labels[oid]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(stored), nil
+}
+
+// List returns the labels of the query's owner by name
+func (l *LabelRepository) List(ctx context.Context, query *label.ListQuery) ([]*label.Label, error) {
+	if query == nil {
+		query = &label.ListQuery{}
+	}
+
+	l.r.mu.RLock()
+	defer l.r.mu.RUnlock()
+
+	results := []*label.Label{}
+	for _, stored := range l.r.labels {
+		if query.Owner == "" || stored.Owner == query.Owner {
+			results = append(results, clone(stored))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return compareIDs(results[i].ID, results[j].ID) < 0
+	})
+	return results, nil
+}
+
+func (l *LabelRepository) Update(ctx context.Context, lbl *label.Label) error {
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+
+	stored, ok := l.r.labels[lbl.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	stored.Color = lbl.Color
+	stored.UpdatedAt = millis(lbl.UpdatedAt)
+	return nil
+}
+
+func (l *LabelRepository) Rename(ctx context.Context, owner, from, to string) error {
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+
+	if l.r.findLabel(owner, from) == nil {
+		return storage.ErrNotFound
+	}
+	renamed := make(map[string]bool)
+	for _, stored := range l.r.labels {
+		if stored.Owner == owner && label.Within(stored.Name, from) {
+			renamed[label.Renamed(stored.Name, from, to)] = true
+		}
+	}
+	for _, stored := range l.r.labels {
+		if stored.Owner == owner && !label.Within(stored.Name, from) && renamed[stored.Name] {
+			return storage.ErrDuplicate
+		}
+	}
+
+	now := millis(time.Now())
+	for _, stored := range l.r.labels {
+		if stored.Owner == owner && label.Within(stored.Name, from) {
+			stored.Name = label.Renamed(stored.Name, from, to)
+			stored.UpdatedAt = now
+		}
+	}
+	l.r.relabel(owner, func(name string) (string, bool) {
+		return label.Renamed(name, from, to), true
+	})
+	return nil
+}
+
+func (l *LabelRepository) Delete(ctx context.Context, owner, name string) error {
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+
+	if l.r.findLabel(owner, name) == nil {
+		return storage.ErrNotFound
+	}
+
+	for id, stored := range l.r.labels {
+		if stored.Owner == owner && label.Within(stored.Name, name) {
+			delete(l.r.labels, id)
+		}
+	}
+	l.r.relabel(owner, func(labelName string) (string, bool) {
+		return labelName, !label.Within(labelName, name)
+	})
+	return nil
+}
+
+// relabel maps the labels of the emails of owner's mailbox, dropping those
+// for which keep is false. Counters move along with the labels they count,
+// so they are left alone.
+func (r *EmailRepository) relabel(owner string, mapLabel func(name string) (renamed string, keep bool)) {
+	for _, e := range r.emails {
+		if e.Mailbox != owner {
+			continue
+		}
+		labels := make([]string, 0, len(e.Labels))
+		for _, name := range e.Labels {
+			if renamed, keep := mapLabel(name); keep {
+				labels = append(labels, renamed)
+			}
+		}
+		e.Labels = labels
+	}
+}
+
+func (r *EmailRepository) findLabel(owner, name string) *label.Label {
+	for _, stored := range r.labels {
+		if stored.Owner == owner && stored.Name == name {
+			return stored
+		}
+	}
+	return nil
+}
+
+// countLabels applies the counter changes of an email changing from
+// before to after. The caller holds the lock.
+func (r *EmailRepository) countLabels(before, after *email.Email) {
+	for _, delta := range label.Deltas(before, after) {
+		stored := r.findLabel(delta.Owner, delta.Name)
+		if stored == nil {
+			now := millis(time.Now())
+			stored = &label.Label{
+				ID:        primitive.NewObjectID(),
+				Owner:     delta.Owner,
+				Name:      delta.Name,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			r.labels[stored.ID] = stored
+		}
+		stored.Total += delta.Total
+		stored.Unread += delta.Unread
+	}
+}
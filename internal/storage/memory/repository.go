@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewRepositories returns empty in-memory repositories. They follow the
+// same query semantics as the MongoDB backend and are meant for tests and
+// local development; nothing is persisted.
+func NewRepositories() *storage.Repositories {
+	emails := NewEmailRepository()
+	return &storage.Repositories{
+		Email:        emails,
+		Staff:        NewStaffRepository(),
+		Thread:       NewThreadRepository(),
+		Audit:        NewAuditRepository(),
+		Jobs:         NewJobRepository(),
+		Blobs:        NewBlobRepository(),
+		Spam:         NewSpamRepository(),
+		Templates:    NewTemplateRepository(),
+		Campaigns:    NewCampaignRepository(),
+		Suppressions: NewSuppressionRepository(),
+		Labels:       emails.Labels(),
+	}
+}
+
+// clone deep copies a record through BSON so stored values are isolated
+// from callers and round trip exactly like they would through MongoDB,
+// including the millisecond precision of timestamps.
+func clone[T any](v *T) *T {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("memory: failed to encode %T: %v", v, err))
+	}
+	var out T
+	if err := bson.Unmarshal(data, &out); err != nil {
+		panic(fmt.Sprintf("memory: failed to decode %T: %v", v, err))
+	}
+	return &out
+}
+
+func compareIDs(a, b primitive.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// millis truncates t to the precision MongoDB stores
+func millis(t time.Time) time.Time {
+	return time.UnixMilli(t.UnixMilli()).UTC()
+}
+
+func pageSize(limit, total int) int {
+	if n := storage.PageSize(limit); n < total {
+		return n
+	}
+	return total
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+)
+
+type spamModel struct {
+	messages spam.Counts
+	tokens   map[string]spam.Counts
+}
+
+type SpamRepository struct {
+	mu     sync.Mutex
+	models map[string]*spamModel
+}
+
+func NewSpamRepository() *SpamRepository {
+	return &SpamRepository{models: make(map[string]*spamModel)}
+}
+
+func (r *SpamRepository) Train(ctx context.Context, owner string, isSpam bool, tokens []string, delta int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	model, ok := r.models[owner]
+	if !ok {
+		model = &spamModel{tokens: make(map[string]spam.Counts)}
+		r.models[owner] = model
+	}
+
+	model.messages = addCounts(model.messages, isSpam, delta)
+	for _, token := range tokens {
+		model.tokens[token] = addCounts(model.tokens[token], isSpam, delta)
+	}
+	return nil
+}
+
+func (r *SpamRepository) Model(ctx context.Context, owner string, tokens []string) (*spam.Model, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := &spam.Model{Tokens: make(map[string]spam.Counts)}
+	model, ok := r.models[owner]
+	if !ok {
+		return result, nil
+	}
+
+	result.Messages = model.messages
+	for _, token := range tokens {
+		if counts, ok := model.tokens[token]; ok {
+			result.Tokens[token] = counts
+		}
+	}
+	return result, nil
+}
+
+func addCounts(c spam.Counts, isSpam bool, delta int64) spam.Counts {
+	if isSpam {
+		c.Spam += delta
+	} else {
+		c.Ham += delta
+	}
+	return c
+}
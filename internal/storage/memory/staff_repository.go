@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type StaffRepository struct {
+	mu      sync.RWMutex
+	staff   map[primitive.ObjectID]*staff.Staff
+	byEmail map[string]primitive.ObjectID
+}
+
+func NewStaffRepository() *StaffRepository {
+	return &StaffRepository{
+		staff:   make(map[primitive.ObjectID]*staff.Staff),
+		byEmail: make(map[string]primitive.ObjectID),
+	}
+}
+
+func (r *StaffRepository) Create(ctx context.Context, s *staff.Staff) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.staff[s.ID]; ok {
+		return storage.ErrDuplicate
+	}
+	if _, ok := r.byEmail[s.Email]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.staff[s.ID] = clone(s)
+	r.byEmail[s.Email] = s.ID
+	return nil
+}
+
+func (r *StaffRepository) Get(ctx context.Context, id string) (*staff.Staff, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.staff[oid]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(stored), nil
+}
+
+func (r *StaffRepository) GetByEmail(ctx context.Context, address string) (*staff.Staff, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.byEmail[strings.ToLower(address)]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(r.staff[id]), nil
+}
+
+func (r *StaffRepository) Update(ctx context.Context, s *staff.Staff) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.staff[s.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if owner, ok := r.byEmail[s.Email]; ok && owner != s.ID {
+		return storage.ErrDuplicate
+	}
+
+	delete(r.byEmail, stored.Email)
+	r.staff[s.ID] = clone(s)
+	r.byEmail[s.Email] = s.ID
+	return nil
+}
+
+func (r *StaffRepository) Delete(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.staff[oid]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	delete(r.byEmail, stored.Email)
+	delete(r.staff, oid)
+	return nil
+}
+
+// List returns one page of the directory ordered by full name
+func (r *StaffRepository) List(ctx context.Context, query *staff.ListQuery) ([]*staff.Staff, error) {
+	if query == nil {
+		query = &staff.ListQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var last *staff.Staff
+	if after != nil {
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		last = &staff.Staff{ID: id, FullName: after.Key}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*staff.Staff
+	for _, s := range r.staff {
+		if matchStaff(s, query) {
+			matches = append(matches, s)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return staffBefore(matches[i], matches[j]) })
+
+	if last != nil {
+		matches = dropThrough(matches, func(s *staff.Staff) bool { return !staffBefore(last, s) })
+	}
+
+	results := make([]*staff.Staff, 0, pageSize(query.Limit, len(matches)))
+	for _, s := range matches[:cap(results)] {
+		results = append(results, clone(s))
+	}
+	return results, nil
+}
+
+// staffBefore orders staff by full name then ID, both ascending
+func staffBefore(a, b *staff.Staff) bool {
+	if a.FullName != b.FullName {
+		return a.FullName < b.FullName
+	}
+	return compareIDs(a.ID, b.ID) < 0
+}
+
+func matchStaff(s *staff.Staff, query *staff.ListQuery) bool {
+	if search := strings.ToLower(strings.TrimSpace(query.Search)); search != "" {
+		if !strings.Contains(strings.ToLower(s.FullName), search) && !strings.Contains(strings.ToLower(s.Email), search) {
+			return false
+		}
+	}
+	if query.Department != "" && s.Department != query.Department {
+		return false
+	}
+	if query.Role != "" && s.Role != query.Role {
+		return false
+	}
+	if query.Status != "" && s.Status != query.Status {
+		return false
+	}
+	if query.ManagerID != "" && s.ManagerID != query.ManagerID {
+		return false
+	}
+	return true
+}
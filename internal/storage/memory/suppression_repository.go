@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/bezata/blockchainml-email/internal/domain/suppression"
+	"github.com/bezata/blockchainml-email/internal/storage"
+)
+
+type SuppressionRepository struct {
+	mu           sync.RWMutex
+	suppressions map[string]*suppression.Suppression
+}
+
+func NewSuppressionRepository() *SuppressionRepository {
+	return &SuppressionRepository{
+		suppressions: make(map[string]*suppression.Suppression),
+	}
+}
+
+func (r *SuppressionRepository) Add(ctx context.Context, s *suppression.Suppression) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.suppressions[s.Email]; ok {
+		return storage.ErrDuplicate
+	}
+	r.suppressions[s.Email] = clone(s)
+	return nil
+}
+
+func (r *SuppressionRepository) Get(ctx context.Context, address string) (*suppression.Suppression, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.suppressions[address]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(stored), nil
+}
+
+func (r *SuppressionRepository) Delete(ctx context.Context, address string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.suppressions[address]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(r.suppressions, address)
+	return nil
+}
+
+// List returns one page of suppressions ordered by address
+func (r *SuppressionRepository) List(ctx context.Context, query *suppression.ListQuery) ([]*suppression.Suppression, error) {
+	if query == nil {
+		query = &suppression.ListQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*suppression.Suppression
+	for _, s := range r.suppressions {
+		if (query.Reason == "" || s.Reason == query.Reason) && (after == nil || s.Email > after.ID) {
+			matches = append(matches, s)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Email < matches[j].Email })
+
+	results := make([]*suppression.Suppression, 0, pageSize(query.Limit, len(matches)))
+	for _, s := range matches[:cap(results)] {
+		results = append(results, clone(s))
+	}
+	return results, nil
+}
+
+func (r *SuppressionRepository) Find(ctx context.Context, addresses []string) ([]*suppression.Suppression, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results := []*suppression.Suppression{}
+	seen := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		if s, ok := r.suppressions[address]; ok && !seen[address] {
+			seen[address] = true
+			results = append(results, clone(s))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Email < results[j].Email })
+	return results, nil
+}
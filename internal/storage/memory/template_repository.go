@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TemplateRepository struct {
+	mu        sync.RWMutex
+	templates map[primitive.ObjectID]*template.Template
+}
+
+func NewTemplateRepository() *TemplateRepository {
+	return &TemplateRepository{templates: make(map[primitive.ObjectID]*template.Template)}
+}
+
+func (r *TemplateRepository) Create(ctx context.Context, t *template.Template) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.templates[t.ID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.templates[t.ID] = clone(t)
+	return nil
+}
+
+func (r *TemplateRepository) Get(ctx context.Context, id string) (*template.Template, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.templates[oid]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(stored), nil
+}
+
+func (r *TemplateRepository) Update(ctx context.Context, t *template.Template) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.templates[t.ID]; !ok {
+		return storage.ErrNotFound
+	}
+	r.templates[t.ID] = clone(t)
+	return nil
+}
+
+func (r *TemplateRepository) Delete(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.templates[oid]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(r.templates, oid)
+	return nil
+}
+
+// List returns one page of templates ordered by name
+func (r *TemplateRepository) List(ctx context.Context, query *template.ListQuery) ([]*template.Template, error) {
+	if query == nil {
+		query = &template.ListQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var last *template.Template
+	if after != nil {
+		id, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		last = &template.Template{ID: id, Name: after.Key}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*template.Template
+	for _, t := range r.templates {
+		if matchTemplate(t, query) {
+			matches = append(matches, t)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return templateBefore(matches[i], matches[j]) })
+
+	if last != nil {
+		matches = dropThrough(matches, func(t *template.Template) bool { return !templateBefore(last, t) })
+	}
+
+	results := make([]*template.Template, 0, pageSize(query.Limit, len(matches)))
+	for _, t := range matches[:cap(results)] {
+		results = append(results, clone(t))
+	}
+	return results, nil
+}
+
+// templateBefore orders templates by name then ID, both ascending
+func templateBefore(a, b *template.Template) bool {
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return compareIDs(a.ID, b.ID) < 0
+}
+
+func matchTemplate(t *template.Template, query *template.ListQuery) bool {
+	if query.Kind != "" && t.Kind != query.Kind {
+		return false
+	}
+	if scope := query.VisibleTo; scope != nil {
+		switch {
+		case t.OwnerID != "":
+			return t.OwnerID == scope.OwnerID
+		case t.Department != "":
+			return t.Department == scope.Department
+		}
+	}
+	return true
+}
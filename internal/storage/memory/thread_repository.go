@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/bezata/blockchainml-email/internal/domain/thread"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ThreadRepository stores threads keyed by their ThreadID
+type ThreadRepository struct {
+	mu      sync.RWMutex
+	threads map[string]*thread.Thread
+}
+
+func NewThreadRepository() *ThreadRepository {
+	return &ThreadRepository{threads: make(map[string]*thread.Thread)}
+}
+
+func (r *ThreadRepository) Create(ctx context.Context, t *thread.Thread) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.threads[t.ThreadID]; ok {
+		return storage.ErrDuplicate
+	}
+
+	r.threads[t.ThreadID] = clone(t)
+	return nil
+}
+
+func (r *ThreadRepository) Get(ctx context.Context, threadID string) (*thread.Thread, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.threads[threadID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return clone(stored), nil
+}
+
+func (r *ThreadRepository) Update(ctx context.Context, t *thread.Thread) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.threads[t.ThreadID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	// Like a Mongo replace, the stored _id survives a zero ID in the update
+	updated := clone(t)
+	if updated.ID.IsZero() {
+		updated.ID = stored.ID
+	}
+	r.threads[t.ThreadID] = updated
+	return nil
+}
+
+func (r *ThreadRepository) Delete(ctx context.Context, threadID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.threads[threadID]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(r.threads, threadID)
+	return nil
+}
+
+// List returns one page of threads, most recently active first
+func (r *ThreadRepository) List(ctx context.Context, query *thread.ListQuery) ([]*thread.Thread, error) {
+	if query == nil {
+		query = &thread.ListQuery{}
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	var last *thread.Thread
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		last = &thread.Thread{ThreadID: after.ID, LastMessage: thread.LastMessage{SentAt: t}}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*thread.Thread
+	for _, t := range r.threads {
+		if query.Participant == "" || hasThreadParticipant(t, query.Participant) {
+			matches = append(matches, t)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return threadBefore(matches[i], matches[j]) })
+
+	if last != nil {
+		matches = dropThrough(matches, func(t *thread.Thread) bool { return !threadBefore(last, t) })
+	}
+
+	results := make([]*thread.Thread, 0, pageSize(query.Limit, len(matches)))
+	for _, t := range matches[:cap(results)] {
+		results = append(results, clone(t))
+	}
+	return results, nil
+}
+
+// threadBefore orders threads by last message time then ThreadID, both
+// descending
+func threadBefore(a, b *thread.Thread) bool {
+	if !a.LastMessage.SentAt.Equal(b.LastMessage.SentAt) {
+		return a.LastMessage.SentAt.After(b.LastMessage.SentAt)
+	}
+	return a.ThreadID > b.ThreadID
+}
+
+func hasThreadParticipant(t *thread.Thread, address string) bool {
+	for _, p := range t.Participants {
+		if p.Email == address {
+			return true
+		}
+	}
+	return false
+}
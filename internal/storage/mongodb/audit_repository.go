@@ -0,0 +1,138 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/audit"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type AuditRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewAuditRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *AuditRepository {
+	return &AuditRepository{
+		collection: db.Collection("audit_events"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the indexes used by List filters
+func (r *AuditRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "resource", Value: 1}, {Key: "resourceId", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "risk", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	if err != nil {
+		r.logger.Error("failed to create audit indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *AuditRepository) StoreAuditEvent(ctx context.Context, event *audit.Event) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("store_audit_event").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	if _, err := r.collection.InsertOne(ctx, event); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to store audit event", zap.String("action", event.Action), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListAuditEvents returns one page of audit events, newest first
+func (r *AuditRepository) ListAuditEvents(ctx context.Context, query *audit.ListQuery) ([]*audit.Event, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_audit_events").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &audit.ListQuery{}
+	}
+
+	filter := bson.D{}
+	for key, value := range map[string]string{
+		"actorId":    query.ActorID,
+		"action":     query.Action,
+		"resource":   query.Resource,
+		"resourceId": query.ResourceID,
+		"risk":       query.Risk,
+	} {
+		if value != "" {
+			filter = append(filter, bson.E{Key: key, Value: value})
+		}
+	}
+
+	timestamp := bson.M{}
+	if query.Since != nil {
+		timestamp["$gte"] = *query.Since
+	}
+	if query.Until != nil {
+		timestamp["$lt"] = *query.Until
+	}
+	if len(timestamp) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestamp})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"timestamp": bson.M{"$lt": t}},
+			bson.M{"timestamp": t, "_id": bson.M{"$lt": after.ID}},
+		}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list audit events", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*audit.Event{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode audit events", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
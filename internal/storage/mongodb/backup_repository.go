@@ -0,0 +1,129 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type BackupRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewBackupRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *BackupRepository {
+	return &BackupRepository{
+		collection: db.Collection("backups"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+func (r *BackupRepository) CreateBackup(ctx context.Context, b *backup.Backup) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_backup").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if _, err := r.collection.InsertOne(ctx, b); err != nil {
+		r.logger.Error("failed to create backup", zap.String("id", b.ID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *BackupRepository) GetBackup(ctx context.Context, id string) (*backup.Backup, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_backup").Observe(time.Since(startTime).Seconds())
+	}()
+
+	var result backup.Backup
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get backup", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *BackupRepository) UpdateBackup(ctx context.Context, b *backup.Backup) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_backup").Observe(time.Since(startTime).Seconds())
+	}()
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := r.collection.ReplaceOne(ctx, bson.M{"_id": b.ID}, b, opts); err != nil {
+		r.logger.Error("failed to update backup", zap.String("id", b.ID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *BackupRepository) DeleteBackup(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_backup").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if _, err := r.collection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		r.logger.Error("failed to delete backup", zap.String("id", id), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListBackups returns backups matching the query, oldest first
+func (r *BackupRepository) ListBackups(ctx context.Context, query *backup.ListQuery) ([]*backup.Backup, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_backups").Observe(time.Since(startTime).Seconds())
+	}()
+
+	filter := bson.M{}
+	if query != nil {
+		if query.Type != "" {
+			filter["type"] = query.Type
+		}
+		if query.Status != "" {
+			filter["status"] = query.Status
+		}
+		if query.BaseID != "" {
+			filter["$or"] = bson.A{bson.M{"_id": query.BaseID}, bson.M{"baseId": query.BaseID}}
+		}
+		if query.Before != nil {
+			filter["timestamp"] = bson.M{"$lte": *query.Before}
+		}
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}))
+	if err != nil {
+		r.logger.Error("failed to list backups", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []*backup.Backup
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode backups", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,183 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/blob"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// BlobRepository keeps one document per blob, keyed by its hash. Reference
+// counts change with atomic $inc updates so concurrent senders of the same
+// file never lose a reference.
+type BlobRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewBlobRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *BlobRepository {
+	return &BlobRepository{
+		collection: db.Collection("blobs"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the index used by Stats
+func (r *BlobRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "refCount", Value: 1}},
+	})
+	if err != nil {
+		r.logger.Error("failed to create blob indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Acquire upserts the blob unless it is marked for deletion. A marked blob
+// does not match the filter, so the upsert tries to insert a second
+// document with the same _id and fails with a duplicate key error.
+func (r *BlobRepository) Acquire(ctx context.Context, hash string, size int64) (*blob.Blob, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("acquire_blob").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	filter := bson.M{"_id": hash, "$or": bson.A{
+		bson.M{"deleting": false},
+		bson.M{"updatedAt": bson.M{"$lt": now.Add(-blob.DeleteTimeout)}},
+	}}
+	update := bson.M{
+		"$inc":         bson.M{"refCount": 1},
+		"$set":         bson.M{"deleting": false, "updatedAt": now},
+		"$setOnInsert": bson.M{"size": size, "createdAt": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var result blob.Blob
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, storage.ErrBlobDeleting
+		}
+		r.logger.Error("failed to acquire blob", zap.String("hash", hash), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *BlobRepository) Release(ctx context.Context, hash string) (*blob.Blob, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("release_blob").Observe(time.Since(startTime).Seconds())
+	}()
+
+	filter := bson.M{"_id": hash, "refCount": bson.M{"$gt": 0}}
+	update := bson.M{
+		"$inc": bson.M{"refCount": -1},
+		"$set": bson.M{"updatedAt": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var result blob.Blob
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to release blob", zap.String("hash", hash), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *BlobRepository) MarkDeleting(ctx context.Context, hash string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("mark_blob_deleting").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	filter := bson.M{"_id": hash, "refCount": 0, "$or": bson.A{
+		bson.M{"deleting": false},
+		bson.M{"updatedAt": bson.M{"$lt": now.Add(-blob.DeleteTimeout)}},
+	}}
+	update := bson.M{"$set": bson.M{"deleting": true, "updatedAt": now}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		r.logger.Error("failed to mark blob for deletion", zap.String("hash", hash), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *BlobRepository) Delete(ctx context.Context, hash string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_blob").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": hash, "deleting": true})
+	if err != nil {
+		r.logger.Error("failed to delete blob", zap.String("hash", hash), zap.Error(err))
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *BlobRepository) Stats(ctx context.Context) (*blob.Stats, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("blob_stats").Observe(time.Since(startTime).Seconds())
+	}()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"refCount": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          nil,
+			"blobs":        bson.M{"$sum": 1},
+			"references":   bson.M{"$sum": "$refCount"},
+			"storedBytes":  bson.M{"$sum": "$size"},
+			"logicalBytes": bson.M{"$sum": bson.M{"$multiply": bson.A{"$size", "$refCount"}}},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		r.logger.Error("failed to aggregate blob stats", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result blob.Stats
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			r.logger.Error("failed to decode blob stats", zap.Error(err))
+			return nil, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		r.logger.Error("failed to aggregate blob stats", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,389 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type CampaignRepository struct {
+	campaigns  *mongo.Collection
+	recipients *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewCampaignRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *CampaignRepository {
+	return &CampaignRepository{
+		campaigns:  db.Collection("campaigns"),
+		recipients: db.Collection("campaign_recipients"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the indexes used by List filters and the unique
+// index that lists an address once per campaign
+func (r *CampaignRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.campaigns.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "createdBy", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		r.logger.Error("failed to create campaign indexes", zap.Error(err))
+		return err
+	}
+
+	_, err = r.recipients.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "campaignId", Value: 1}, {Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "campaignId", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "token", Value: 1}}},
+		{Keys: bson.D{{Key: "messageId", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		r.logger.Error("failed to create campaign recipient indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *CampaignRepository) Create(ctx context.Context, c *campaign.Campaign) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_campaign").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+
+	if _, err := r.campaigns.InsertOne(ctx, c); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create campaign", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *CampaignRepository) Get(ctx context.Context, id string) (*campaign.Campaign, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_campaign").Observe(time.Since(startTime).Seconds())
+	}()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	var result campaign.Campaign
+	if err := r.campaigns.FindOne(ctx, bson.M{"_id": oid}).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get campaign", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *CampaignRepository) Update(ctx context.Context, c *campaign.Campaign) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_campaign").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.campaigns.ReplaceOne(ctx, bson.M{"_id": c.ID}, c)
+	if err != nil {
+		r.logger.Error("failed to update campaign", zap.String("id", c.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *CampaignRepository) Delete(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_campaign").Observe(time.Since(startTime).Seconds())
+	}()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	result, err := r.campaigns.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		r.logger.Error("failed to delete campaign", zap.String("id", id), zap.Error(err))
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	if _, err := r.recipients.DeleteMany(ctx, bson.M{"campaignId": id}); err != nil {
+		r.logger.Error("failed to delete campaign recipients", zap.String("id", id), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// List returns one page of campaigns, newest first
+func (r *CampaignRepository) List(ctx context.Context, query *campaign.ListQuery) ([]*campaign.Campaign, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_campaigns").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &campaign.ListQuery{}
+	}
+
+	filter := bson.D{}
+	if query.CreatedBy != "" {
+		filter = append(filter, bson.E{Key: "createdBy", Value: query.CreatedBy})
+	}
+	if query.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: query.Status})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		oid, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"createdAt": bson.M{"$lt": t}},
+			bson.M{"createdAt": t, "_id": bson.M{"$lt": oid}},
+		}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	cursor, err := r.campaigns.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list campaigns", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*campaign.Campaign{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode campaigns", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// AddRecipients inserts recipients unordered, so addresses the campaign
+// already lists fail on the unique index without stopping the others
+func (r *CampaignRepository) AddRecipients(ctx context.Context, recipients []*campaign.Recipient) (int, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("add_campaign_recipients").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if len(recipients) == 0 {
+		return 0, nil
+	}
+
+	docs := make([]any, len(recipients))
+	for i, recipient := range recipients {
+		if recipient.ID.IsZero() {
+			recipient.ID = primitive.NewObjectID()
+		}
+		docs[i] = recipient
+	}
+
+	result, err := r.recipients.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) {
+			r.logger.Error("failed to add campaign recipients", zap.Error(err))
+			return 0, err
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				r.logger.Error("failed to add campaign recipients", zap.Error(err))
+				return 0, err
+			}
+		}
+		return len(recipients) - len(bulkErr.WriteErrors), nil
+	}
+
+	return len(result.InsertedIDs), nil
+}
+
+// ListRecipients returns one page of recipients in the order they were
+// added
+func (r *CampaignRepository) ListRecipients(ctx context.Context, query *campaign.RecipientQuery) ([]*campaign.Recipient, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_campaign_recipients").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &campaign.RecipientQuery{}
+	}
+
+	filter := bson.D{}
+	for key, value := range map[string]string{
+		"campaignId": query.CampaignID,
+		"email":      query.Email,
+		"token":      query.Token,
+		"messageId":  query.MessageID,
+	} {
+		if value != "" {
+			filter = append(filter, bson.E{Key: key, Value: value})
+		}
+	}
+	if len(query.Statuses) > 0 {
+		filter = append(filter, bson.E{Key: "status", Value: bson.M{"$in": query.Statuses}})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		oid, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		filter = append(filter, bson.E{Key: "_id", Value: bson.M{"$gt": oid}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	cursor, err := r.recipients.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list campaign recipients", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*campaign.Recipient{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode campaign recipients", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *CampaignRepository) UpdateRecipient(ctx context.Context, recipient *campaign.Recipient) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_campaign_recipient").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.recipients.ReplaceOne(ctx, bson.M{"_id": recipient.ID}, recipient)
+	if err != nil {
+		r.logger.Error("failed to update campaign recipient", zap.String("id", recipient.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *CampaignRepository) ReplaceRecipient(ctx context.Context, recipient *campaign.Recipient, status string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("replace_campaign_recipient").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.recipients.ReplaceOne(ctx, bson.M{"_id": recipient.ID, "status": status}, recipient)
+	if err != nil {
+		r.logger.Error("failed to replace campaign recipient", zap.String("id", recipient.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Tell a changed recipient from a missing one
+	count, err := r.recipients.CountDocuments(ctx, bson.M{"_id": recipient.ID})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return storage.ErrNotFound
+	}
+	return storage.ErrConflict
+}
+
+func (r *CampaignRepository) CountRecipients(ctx context.Context, campaignID string) (map[string]int, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("count_campaign_recipients").Observe(time.Since(startTime).Seconds())
+	}()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"campaignId": campaignID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := r.recipients.Aggregate(ctx, pipeline)
+	if err != nil {
+		r.logger.Error("failed to count campaign recipients", zap.String("campaign_id", campaignID), zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := make(map[string]int)
+	for cursor.Next(ctx) {
+		var doc struct {
+			Status string `bson:"_id"`
+			Count  int    `bson:"count"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			r.logger.Error("failed to decode campaign recipient counts", zap.Error(err))
+			return nil, err
+		}
+		counts[doc.Status] = doc.Count
+	}
+	if err := cursor.Err(); err != nil {
+		r.logger.Error("failed to count campaign recipients", zap.String("campaign_id", campaignID), zap.Error(err))
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,71 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// ErrStandalone is returned by Connect for a standalone mongod. Email and
+// label writes update counters in multi-document transactions, which need
+// a replica set or a sharded cluster; a single-member replica set will do,
+// started with --replSet and initialized once with rs.initiate().
+var ErrStandalone = errors.New("MongoDB is a standalone server, but transactions need a replica set or sharded cluster")
+
+// Connect opens a client with the configured pool settings and verifies the
+// connection, and that the deployment supports transactions, before
+// returning the database.
+func Connect(ctx context.Context, cfg config.MongoDBConfig) (*mongo.Database, error) {
+	opts := options.Client().ApplyURI(cfg.URI)
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(time.Duration(cfg.MaxConnIdleTime) * time.Second)
+	}
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := client.Ping(pingCtx, readpref.Primary()); err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	if err := checkTransactions(pingCtx, client); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	return client.Database(cfg.Database), nil
+}
+
+// checkTransactions fails with ErrStandalone unless the server is a
+// replica set member or a mongos router
+func checkTransactions(ctx context.Context, client *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return fmt.Errorf("failed to query MongoDB topology: %w", err)
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return ErrStandalone
+	}
+	return nil
+}
@@ -2,18 +2,26 @@ package mongodb
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
 	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
-	"github.com/bezata/blockchainml-email/internal/domain/email"
 )
 
+// EmailRepository writes emails in transactions with the counters of
+// their labels, in the labels collection
 type EmailRepository struct {
 	collection *mongo.Collection
+	labels     *mongo.Collection
 	logger     *zap.Logger
 	metrics    *metrics.Metrics
 }
@@ -21,19 +29,69 @@ type EmailRepository struct {
 func NewEmailRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *EmailRepository {
 	return &EmailRepository{
 		collection: db.Collection("emails"),
+		labels:     db.Collection("labels"),
 		logger:     logger,
 		metrics:    metrics,
 	}
 }
 
-func (r *EmailRepository) Create(ctx context.Context, email *email.Email) error {
+// countedFields are the fields label counters depend on
+var countedFields = bson.M{"mailbox": 1, "labels": 1, "flags": 1}
+
+// EnsureIndexes creates the indexes used by lookups and List filters. A
+// message ID is unique within a mailbox, as every recipient's copy of a
+// message has the same one. Databases created before that need migration
+// 3, which drops the index that made message IDs unique everywhere.
+func (r *EmailRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "mailbox", Value: 1}, {Key: "messageId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "messageId", Value: 1}}},
+		{Keys: bson.D{{Key: "threadId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "labels", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "mailbox", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "from.email", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "to.email", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "cc.email", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "bcc.email", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if isIndexConflict(err) {
+		return fmt.Errorf("failed to create email indexes, apply pending migrations first: %w", err)
+	}
+	if err != nil {
+		r.logger.Error("failed to create email indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// isIndexConflict reports whether an index exists with the same name or
+// keys as a requested one, but other options
+func isIndexConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.HasErrorCode(85) || cmdErr.HasErrorCode(86))
+}
+
+func (r *EmailRepository) Create(ctx context.Context, e *email.Email) error {
 	startTime := time.Now()
 	defer func() {
 		r.metrics.DatabaseLatency.WithLabelValues("create_email").Observe(time.Since(startTime).Seconds())
 	}()
 
-	_, err := r.collection.InsertOne(ctx, email)
+	if e.ID.IsZero() {
+		e.ID = primitive.NewObjectID()
+	}
+
+	err := inTransaction(ctx, r.collection.Database(), func(ctx mongo.SessionContext) error {
+		if _, err := r.collection.InsertOne(ctx, e); err != nil {
+			return err
+		}
+		return countLabels(ctx, r.labels, label.Deltas(nil, e))
+	})
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
 		r.logger.Error("failed to create email", zap.Error(err))
 		return err
 	}
@@ -47,15 +105,299 @@ func (r *EmailRepository) Get(ctx context.Context, id string) (*email.Email, err
 		r.metrics.DatabaseLatency.WithLabelValues("get_email").Observe(time.Since(startTime).Seconds())
 	}()
 
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
 	var result email.Email
-	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+	err = r.collection.FindOne(ctx, bson.M{"_id": oid}).Decode(&result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, nil
+			return nil, storage.ErrNotFound
 		}
-		r.logger.Error("failed to get email", zap.Error(err))
+		r.logger.Error("failed to get email", zap.String("id", id), zap.Error(err))
 		return nil, err
 	}
 
 	return &result, nil
 }
+
+func (r *EmailRepository) Update(ctx context.Context, e *email.Email) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := r.replace(ctx, bson.M{"_id": e.ID}, e, false)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to update email", zap.String("id", e.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return err
+}
+
+// replace replaces the email matching filter with e, advancing its
+// version, and updates the label counters, or returns ErrNotFound when no
+// email matches. The stored delivery state is kept unless withDelivery is
+// set, as ReplaceDelivery owns it.
+func (r *EmailRepository) replace(ctx context.Context, filter bson.M, e *email.Email, withDelivery bool) error {
+	return inTransaction(ctx, r.collection.Database(), func(ctx mongo.SessionContext) error {
+		var stored email.Email
+		projection := options.FindOne().SetProjection(bson.M{"delivery": 1, "deliveryVersion": 1, "version": 1})
+		if err := r.collection.FindOne(ctx, filter, projection).Decode(&stored); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+		e.Version = stored.Version + 1
+		if !withDelivery {
+			kept := *e
+			kept.Delivery, kept.DeliveryVersion = stored.Delivery, stored.DeliveryVersion
+			e = &kept
+		}
+
+		var before email.Email
+		opts := options.FindOneAndReplace().SetProjection(countedFields)
+		if err := r.collection.FindOneAndReplace(ctx, filter, e, opts).Decode(&before); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+		return countLabels(ctx, r.labels, label.Deltas(&before, e))
+	})
+}
+
+func (r *EmailRepository) ReplaceVersion(ctx context.Context, e *email.Email, version int64) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("replace_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	// Emails never written since they were created have no stored version
+	filter := bson.M{"_id": e.ID, "version": version}
+	if version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	err := r.replace(ctx, filter, e, false)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to replace email", zap.String("id", e.ID.Hex()), zap.Error(err))
+		return err
+	}
+	return r.conflictOrNotFound(ctx, e)
+}
+
+// conflictOrNotFound tells a conditional write that lost to another
+// writer from one to a missing email
+func (r *EmailRepository) conflictOrNotFound(ctx context.Context, e *email.Email) error {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"_id": e.ID})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return storage.ErrNotFound
+	}
+	return storage.ErrConflict
+}
+
+func (r *EmailRepository) ReplaceDraft(ctx context.Context, e *email.Email, version int64) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("replace_draft").Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := r.replace(ctx, bson.M{"_id": e.ID, "draft.version": version}, e, true)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to replace draft", zap.String("id", e.ID.Hex()), zap.Error(err))
+		return err
+	}
+	return r.conflictOrNotFound(ctx, e)
+}
+
+func (r *EmailRepository) ReplaceDelivery(ctx context.Context, e *email.Email, version int64) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("replace_delivery").Observe(time.Since(startTime).Seconds())
+	}()
+
+	// Emails never delivered to have no stored version
+	filter := bson.M{"_id": e.ID, "deliveryVersion": version}
+	if version == 0 {
+		filter["deliveryVersion"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"delivery":        e.Delivery,
+		"deliveryVersion": e.DeliveryVersion,
+		"updatedAt":       e.UpdatedAt,
+	}})
+	if err != nil {
+		r.logger.Error("failed to replace delivery", zap.String("id", e.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Tell a changed delivery state from a missing email
+	count, err := r.collection.CountDocuments(ctx, bson.M{"_id": e.ID})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return storage.ErrNotFound
+	}
+	return storage.ErrConflict
+}
+
+func (r *EmailRepository) Delete(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	err = inTransaction(ctx, r.collection.Database(), func(ctx mongo.SessionContext) error {
+		var before email.Email
+		opts := options.FindOneAndDelete().SetProjection(countedFields)
+		if err := r.collection.FindOneAndDelete(ctx, bson.M{"_id": oid}, opts).Decode(&before); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+		return countLabels(ctx, r.labels, label.Deltas(&before, nil))
+	})
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		r.logger.Error("failed to delete email", zap.String("id", id), zap.Error(err))
+		return err
+	}
+
+	return err
+}
+
+// List returns one page of emails matching the query, newest first
+func (r *EmailRepository) List(ctx context.Context, query *email.ListQuery) ([]*email.Email, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_emails").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &email.ListQuery{}
+	}
+
+	filter, err := emailFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list emails", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*email.Email{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode emails", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func emailFilter(query *email.ListQuery) (bson.D, error) {
+	filter := bson.D{}
+
+	if query.Mailbox != "" {
+		filter = append(filter, bson.E{Key: "mailbox", Value: query.Mailbox})
+	}
+	if query.Participant != "" {
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"from.email": query.Participant},
+			bson.M{"to.email": query.Participant},
+			bson.M{"cc.email": query.Participant},
+			bson.M{"bcc.email": query.Participant},
+		}})
+	}
+	if query.From != "" {
+		filter = append(filter, bson.E{Key: "from.email", Value: query.From})
+	}
+	if query.MessageID != "" {
+		filter = append(filter, bson.E{Key: "messageId", Value: query.MessageID})
+	}
+	if query.ThreadID != "" {
+		filter = append(filter, bson.E{Key: "threadId", Value: query.ThreadID})
+	}
+	if len(query.Labels) > 0 {
+		filter = append(filter, bson.E{Key: "labels", Value: bson.M{"$all": query.Labels}})
+	}
+	if query.IsRead != nil {
+		filter = append(filter, bson.E{Key: "flags.isRead", Value: *query.IsRead})
+	}
+	if query.IsStarred != nil {
+		filter = append(filter, bson.E{Key: "flags.isStarred", Value: *query.IsStarred})
+	}
+	if query.IsDraft != nil {
+		filter = append(filter, bson.E{Key: "flags.isDraft", Value: *query.IsDraft})
+	}
+
+	createdAt := bson.M{}
+	if query.Since != nil {
+		createdAt["$gte"] = *query.Since
+	}
+	if query.Until != nil {
+		createdAt["$lt"] = *query.Until
+	}
+	if len(createdAt) > 0 {
+		filter = append(filter, bson.E{Key: "createdAt", Value: createdAt})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		oid, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		filter = append(filter, bson.E{Key: "$and", Value: bson.A{bson.M{"$or": bson.A{
+			bson.M{"createdAt": bson.M{"$lt": t}},
+			bson.M{"createdAt": t, "_id": bson.M{"$lt": oid}},
+		}}}})
+	}
+
+	return filter, nil
+}
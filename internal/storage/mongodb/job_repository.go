@@ -0,0 +1,221 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/jobs"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type JobRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewJobRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *JobRepository {
+	return &JobRepository{
+		collection: db.Collection("jobs"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the indexes used by Claim and List
+func (r *JobRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "runAt", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lockedUntil", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		r.logger.Error("failed to create job indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *JobRepository) Enqueue(ctx context.Context, job *jobs.Job) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("enqueue_job").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	if job.Status == "" {
+		job.Status = jobs.StatusPending
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	job.CreatedAt, job.UpdatedAt = now, now
+
+	if _, err := r.collection.InsertOne(ctx, job); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to enqueue job", zap.String("type", job.Type), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *JobRepository) Get(ctx context.Context, id string) (*jobs.Job, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_job").Observe(time.Since(startTime).Seconds())
+	}()
+
+	var result jobs.Job
+	if err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get job", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *JobRepository) Claim(ctx context.Context, worker string, lease time.Duration) (*jobs.Job, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("claim_job").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": jobs.StatusPending, "runAt": bson.M{"$lte": now}},
+		bson.M{"status": jobs.StatusRunning, "lockedUntil": bson.M{"$lt": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":      jobs.StatusRunning,
+			"lockedBy":    worker,
+			"lockedUntil": now.Add(lease),
+			"updatedAt":   now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "runAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var result jobs.Job
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to claim job", zap.String("worker", worker), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *JobRepository) Complete(ctx context.Context, id, worker string) error {
+	now := time.Now()
+	return r.finish(ctx, "complete_job", id, worker, bson.M{
+		"$set":   bson.M{"status": jobs.StatusCompleted, "completedAt": now, "updatedAt": now},
+		"$unset": bson.M{"lockedBy": "", "lockedUntil": ""},
+	})
+}
+
+func (r *JobRepository) Fail(ctx context.Context, id, worker, reason string, retryAt *time.Time) error {
+	set := bson.M{"status": jobs.StatusFailed, "lastError": reason, "updatedAt": time.Now()}
+	if retryAt != nil {
+		set["status"] = jobs.StatusPending
+		set["runAt"] = *retryAt
+	}
+	return r.finish(ctx, "fail_job", id, worker, bson.M{
+		"$set":   set,
+		"$unset": bson.M{"lockedBy": "", "lockedUntil": ""},
+	})
+}
+
+// finish applies update to a running job held by worker
+func (r *JobRepository) finish(ctx context.Context, op, id, worker string, update bson.M) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues(op).Observe(time.Since(startTime).Seconds())
+	}()
+
+	filter := bson.M{"_id": id, "status": jobs.StatusRunning, "lockedBy": worker}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		r.logger.Error("failed to update job", zap.String("id", id), zap.String("op", op), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// List returns one page of jobs, newest first
+func (r *JobRepository) List(ctx context.Context, query *jobs.ListQuery) ([]*jobs.Job, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_jobs").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &jobs.ListQuery{}
+	}
+
+	filter := bson.D{}
+	if query.Type != "" {
+		filter = append(filter, bson.E{Key: "type", Value: query.Type})
+	}
+	if query.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: query.Status})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"createdAt": bson.M{"$lt": t}},
+			bson.M{"createdAt": t, "_id": bson.M{"$lt": after.ID}},
+		}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list jobs", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*jobs.Job{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode jobs", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,293 @@
+package mongodb
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// LabelRepository stores labels unique by owner and name. Renames and
+// deletes cascade to the emails in transactions, which need a replica set.
+type LabelRepository struct {
+	collection *mongo.Collection
+	emails     *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewLabelRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *LabelRepository {
+	return &LabelRepository{
+		collection: db.Collection("labels"),
+		emails:     db.Collection("emails"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the unique index counter upserts rely on
+func (r *LabelRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		r.logger.Error("failed to create label indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *LabelRepository) Create(ctx context.Context, l *label.Label) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if l.ID.IsZero() {
+		l.ID = primitive.NewObjectID()
+	}
+
+	if _, err := r.collection.InsertOne(ctx, l); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create label", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *LabelRepository) Get(ctx context.Context, id string) (*label.Label, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	var result label.Label
+	if err := r.collection.FindOne(ctx, bson.M{"_id": oid}).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get label", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// List returns the labels of the query's owner by name
+func (r *LabelRepository) List(ctx context.Context, query *label.ListQuery) ([]*label.Label, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_labels").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &label.ListQuery{}
+	}
+
+	filter := bson.D{}
+	if query.Owner != "" {
+		filter = append(filter, bson.E{Key: "owner", Value: query.Owner})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list labels", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*label.Label{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode labels", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *LabelRepository) Update(ctx context.Context, l *label.Label) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": l.ID}, bson.M{
+		"$set": bson.M{"color": l.Color, "updatedAt": l.UpdatedAt},
+	})
+	if err != nil {
+		r.logger.Error("failed to update label", zap.String("id", l.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *LabelRepository) Rename(ctx context.Context, owner, from, to string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("rename_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	within := withinPattern(from)
+	err := inTransaction(ctx, r.collection.Database(), func(ctx mongo.SessionContext) error {
+		var moved []label.Label
+		cursor, err := r.collection.Find(ctx, bson.M{"owner": owner, "name": within},
+			options.Find().SetProjection(bson.M{"name": 1}))
+		if err != nil {
+			return err
+		}
+		if err := cursor.All(ctx, &moved); err != nil {
+			return err
+		}
+		if len(moved) == 0 {
+			return storage.ErrNotFound
+		}
+
+		names := make([]string, len(moved))
+		for i, l := range moved {
+			names[i] = label.Renamed(l.Name, from, to)
+		}
+		taken, err := r.collection.CountDocuments(ctx, bson.M{
+			"owner": owner,
+			"name":  bson.M{"$in": names, "$not": within},
+		})
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return storage.ErrDuplicate
+		}
+
+		_, err = r.collection.UpdateMany(ctx, bson.M{"owner": owner, "name": within}, mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"name":      renamedExpr("$name", from, to),
+				"updatedAt": time.Now(),
+			}}},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Counters move along with the labels they count
+		_, err = r.emails.UpdateMany(ctx, bson.M{"mailbox": owner, "labels": within}, mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"labels": bson.M{"$map": bson.M{
+					"input": "$labels",
+					"as":    "label",
+					"in": bson.M{"$cond": bson.A{
+						bson.M{"$regexMatch": bson.M{"input": "$$label", "regex": within.Pattern}},
+						renamedExpr("$$label", from, to),
+						"$$label",
+					}},
+				}},
+			}}},
+		})
+		return err
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		if err != storage.ErrNotFound && err != storage.ErrDuplicate {
+			r.logger.Error("failed to rename label", zap.String("owner", owner), zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *LabelRepository) Delete(ctx context.Context, owner, name string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	within := withinPattern(name)
+	err := inTransaction(ctx, r.collection.Database(), func(ctx mongo.SessionContext) error {
+		result, err := r.collection.DeleteMany(ctx, bson.M{"owner": owner, "name": within})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return storage.ErrNotFound
+		}
+
+		_, err = r.emails.UpdateMany(ctx, bson.M{"mailbox": owner, "labels": within},
+			bson.M{"$pull": bson.M{"labels": within}})
+		return err
+	})
+	if err != nil && err != storage.ErrNotFound {
+		r.logger.Error("failed to delete label", zap.String("owner", owner), zap.Error(err))
+		return err
+	}
+
+	return err
+}
+
+// withinPattern matches the label name and the labels nested in it
+func withinPattern(name string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(name) + "(" + regexp.QuoteMeta(label.Separator) + "|$)"}
+}
+
+// renamedExpr is the aggregation expression of label.Renamed for a name
+// within from
+func renamedExpr(name, from, to string) bson.M {
+	return bson.M{"$concat": bson.A{
+		to,
+		bson.M{"$substrBytes": bson.A{
+			name,
+			len(from),
+			bson.M{"$subtract": bson.A{bson.M{"$strLenBytes": name}, len(from)}},
+		}},
+	}}
+}
+
+// countLabels applies label counter changes, creating the labels missing
+func countLabels(ctx context.Context, labels *mongo.Collection, deltas []label.Delta) error {
+	for _, delta := range deltas {
+		now := time.Now()
+		_, err := labels.UpdateOne(ctx,
+			bson.M{"owner": delta.Owner, "name": delta.Name},
+			bson.M{
+				"$inc":         bson.M{"total": delta.Total, "unread": delta.Unread},
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "createdAt": now, "updatedAt": now},
+			},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTransaction runs fn in a transaction, retrying it on transient errors
+func inTransaction(ctx context.Context, db *mongo.Database, fn func(ctx mongo.SessionContext) error) error {
+	return db.Client().UseSession(ctx, func(ctx mongo.SessionContext) error {
+		_, err := ctx.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+			return nil, fn(ctx)
+		})
+		return err
+	})
+}
@@ -0,0 +1,47 @@
+package mongodb_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/storage/mongodb"
+	"github.com/bezata/blockchainml-email/internal/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+// TestConformance runs against the MongoDB deployment of MONGODB_TEST_URI,
+// in a fresh database per subtest. Transactions need a replica set, which
+// may have a single member.
+func TestConformance(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx := context.Background()
+	db, err := mongodb.Connect(ctx, config.MongoDBConfig{URI: uri, Database: "storagetest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Client().Disconnect(ctx) })
+
+	m := metrics.NewMetrics("storagetest")
+	n := 0
+	storagetest.Run(t, func(t *testing.T) *storage.Repositories {
+		n++
+		db := db.Client().Database(fmt.Sprintf("storagetest_%d_%d", time.Now().UnixNano(), n))
+		t.Cleanup(func() { db.Drop(ctx) })
+
+		repos := mongodb.NewRepository(db, zap.NewNop(), m)
+		if err := repos.EnsureIndexes(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return repos.Repositories()
+	})
+}
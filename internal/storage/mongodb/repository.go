@@ -1,40 +1,100 @@
 package mongodb
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 )
 
 type Repository struct {
-	db      *mongo.Database
-	logger  *zap.Logger
-	metrics *metrics.Metrics
-	email   *EmailRepository
-	staff   *StaffRepository
-	thread  *ThreadRepository
+	db           *mongo.Database
+	logger       *zap.Logger
+	metrics      *metrics.Metrics
+	email        *EmailRepository
+	staff        *StaffRepository
+	thread       *ThreadRepository
+	audit        *AuditRepository
+	jobs         *JobRepository
+	blobs        *BlobRepository
+	spam         *SpamRepository
+	templates    *TemplateRepository
+	campaigns    *CampaignRepository
+	suppressions *SuppressionRepository
+	labels       *LabelRepository
 }
 
 func NewRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *Repository {
 	return &Repository{
-		db:      db,
-		logger:  logger,
-		metrics: metrics,
-		email:   NewEmailRepository(db, logger, metrics),
-		staff:   NewStaffRepository(db, logger, metrics),
-		thread:  NewThreadRepository(db, logger, metrics),
+		db:           db,
+		logger:       logger,
+		metrics:      metrics,
+		email:        NewEmailRepository(db, logger, metrics),
+		staff:        NewStaffRepository(db, logger, metrics),
+		thread:       NewThreadRepository(db, logger, metrics),
+		audit:        NewAuditRepository(db, logger, metrics),
+		jobs:         NewJobRepository(db, logger, metrics),
+		blobs:        NewBlobRepository(db, logger, metrics),
+		spam:         NewSpamRepository(db, logger, metrics),
+		templates:    NewTemplateRepository(db, logger, metrics),
+		campaigns:    NewCampaignRepository(db, logger, metrics),
+		suppressions: NewSuppressionRepository(db, logger, metrics),
+		labels:       NewLabelRepository(db, logger, metrics),
 	}
 }
 
 // Implement storage.Repository interface
-func (r *Repository) Email() EmailRepository {
+func (r *Repository) Email() storage.EmailRepository {
 	return r.email
 }
 
-func (r *Repository) Staff() StaffRepository {
+func (r *Repository) Staff() storage.StaffRepository {
 	return r.staff
 }
 
-func (r *Repository) Thread() ThreadRepository {
+func (r *Repository) Thread() storage.ThreadRepository {
 	return r.thread
 }
+
+// Repositories returns the repositories in the form the services expect
+func (r *Repository) Repositories() *storage.Repositories {
+	return &storage.Repositories{
+		Email:        r.email,
+		Staff:        r.staff,
+		Thread:       r.thread,
+		Audit:        r.audit,
+		Jobs:         r.jobs,
+		Blobs:        r.blobs,
+		Spam:         r.spam,
+		Templates:    r.templates,
+		Campaigns:    r.campaigns,
+		Suppressions: r.suppressions,
+		Labels:       r.labels,
+	}
+}
+
+// EnsureIndexes creates all collection indexes. Creating an index that
+// already exists is a no-op, so this runs on every startup.
+func (r *Repository) EnsureIndexes(ctx context.Context) error {
+	for name, ensure := range map[string]func(context.Context) error{
+		"emails":       r.email.EnsureIndexes,
+		"staff":        r.staff.EnsureIndexes,
+		"threads":      r.thread.EnsureIndexes,
+		"audit":        r.audit.EnsureIndexes,
+		"jobs":         r.jobs.EnsureIndexes,
+		"blobs":        r.blobs.EnsureIndexes,
+		"spam":         r.spam.EnsureIndexes,
+		"templates":    r.templates.EnsureIndexes,
+		"campaigns":    r.campaigns.EnsureIndexes,
+		"suppressions": r.suppressions.EnsureIndexes,
+		"labels":       r.labels.EnsureIndexes,
+	} {
+		if err := ensure(ctx); err != nil {
+			return fmt.Errorf("failed to create %s indexes: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// messagesToken holds the message counts of a model. Real tokens are never
+// empty.
+const messagesToken = ""
+
+type spamToken struct {
+	Token       string `bson:"token"`
+	spam.Counts `bson:",inline"`
+}
+
+// SpamRepository keeps one document per owner and token in spam_tokens
+type SpamRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewSpamRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *SpamRepository {
+	return &SpamRepository{
+		collection: db.Collection("spam_tokens"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the unique index upserts and lookups rely on
+func (r *SpamRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "token", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		r.logger.Error("failed to create spam indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *SpamRepository) Train(ctx context.Context, owner string, isSpam bool, tokens []string, delta int64) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("train_spam").Observe(time.Since(startTime).Seconds())
+	}()
+
+	field := "ham"
+	if isSpam {
+		field = "spam"
+	}
+	update := bson.M{"$inc": bson.M{field: delta}}
+
+	models := make([]mongo.WriteModel, 0, len(tokens)+1)
+	for _, token := range append([]string{messagesToken}, tokens...) {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"owner": owner, "token": token}).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+
+	opts := options.BulkWrite().SetOrdered(false)
+	_, err := r.collection.BulkWrite(ctx, models, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		// Two upserts inserted the same new token at once; the retry
+		// updates the document the other one created
+		_, err = r.collection.BulkWrite(ctx, failedWrites(models, err), opts)
+	}
+	if err != nil {
+		r.logger.Error("failed to train spam model", zap.String("owner", owner), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *SpamRepository) Model(ctx context.Context, owner string, tokens []string) (*spam.Model, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_spam_model").Observe(time.Since(startTime).Seconds())
+	}()
+
+	filter := bson.M{"owner": owner, "token": bson.M{"$in": append([]string{messagesToken}, tokens...)}}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 0, "owner": 0}))
+	if err != nil {
+		r.logger.Error("failed to get spam model", zap.String("owner", owner), zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	model := &spam.Model{Tokens: make(map[string]spam.Counts, len(tokens))}
+	for cursor.Next(ctx) {
+		var doc spamToken
+		if err := cursor.Decode(&doc); err != nil {
+			r.logger.Error("failed to decode spam token", zap.Error(err))
+			return nil, err
+		}
+		if doc.Token == messagesToken {
+			model.Messages = doc.Counts
+		} else {
+			model.Tokens[doc.Token] = doc.Counts
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		r.logger.Error("failed to get spam model", zap.String("owner", owner), zap.Error(err))
+		return nil, err
+	}
+
+	return model, nil
+}
+
+// failedWrites returns the models of a bulk write that failed
+func failedWrites(models []mongo.WriteModel, err error) []mongo.WriteModel {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) {
+		return models
+	}
+
+	failed := make([]mongo.WriteModel, 0, len(bulkErr.WriteErrors))
+	for _, writeErr := range bulkErr.WriteErrors {
+		failed = append(failed, models[writeErr.Index])
+	}
+	return failed
+}
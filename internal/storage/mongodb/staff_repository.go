@@ -0,0 +1,223 @@
+package mongodb
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type StaffRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewStaffRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *StaffRepository {
+	return &StaffRepository{
+		collection: db.Collection("staff"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the indexes used by lookups and directory filters
+func (r *StaffRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "fullName", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "department", Value: 1}, {Key: "fullName", Value: 1}}},
+		{Keys: bson.D{{Key: "role", Value: 1}}},
+		{Keys: bson.D{{Key: "managerId", Value: 1}}},
+	})
+	if err != nil {
+		r.logger.Error("failed to create staff indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *StaffRepository) Create(ctx context.Context, s *staff.Staff) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+
+	if _, err := r.collection.InsertOne(ctx, s); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create staff", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *StaffRepository) Get(ctx context.Context, id string) (*staff.Staff, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+	return r.findOne(ctx, bson.M{"_id": oid})
+}
+
+func (r *StaffRepository) GetByEmail(ctx context.Context, address string) (*staff.Staff, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_staff_by_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	return r.findOne(ctx, bson.M{"email": strings.ToLower(address)})
+}
+
+func (r *StaffRepository) findOne(ctx context.Context, filter bson.M) (*staff.Staff, error) {
+	var result staff.Staff
+	if err := r.collection.FindOne(ctx, filter).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get staff", zap.Error(err))
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *StaffRepository) Update(ctx context.Context, s *staff.Staff) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": s.ID}, s)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to update staff", zap.String("id", s.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *StaffRepository) Delete(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		r.logger.Error("failed to delete staff", zap.String("id", id), zap.Error(err))
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// List returns one page of the directory ordered by full name
+func (r *StaffRepository) List(ctx context.Context, query *staff.ListQuery) ([]*staff.Staff, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &staff.ListQuery{}
+	}
+
+	filter, err := staffFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "fullName", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list staff", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*staff.Staff{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode staff", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func staffFilter(query *staff.ListQuery) (bson.D, error) {
+	filter := bson.D{}
+
+	if search := strings.TrimSpace(query.Search); search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"fullName": pattern},
+			bson.M{"email": pattern},
+		}})
+	}
+	if query.Department != "" {
+		filter = append(filter, bson.E{Key: "department", Value: query.Department})
+	}
+	if query.Role != "" {
+		filter = append(filter, bson.E{Key: "role", Value: query.Role})
+	}
+	if query.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: query.Status})
+	}
+	if query.ManagerID != "" {
+		filter = append(filter, bson.E{Key: "managerId", Value: query.ManagerID})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		oid, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		filter = append(filter, bson.E{Key: "$and", Value: bson.A{bson.M{"$or": bson.A{
+			bson.M{"fullName": bson.M{"$gt": after.Key}},
+			bson.M{"fullName": after.Key, "_id": bson.M{"$gt": oid}},
+		}}}})
+	}
+
+	return filter, nil
+}
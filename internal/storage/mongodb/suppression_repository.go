@@ -0,0 +1,154 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/suppression"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// SuppressionRepository stores suppressions keyed by address
+type SuppressionRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewSuppressionRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *SuppressionRepository {
+	return &SuppressionRepository{
+		collection: db.Collection("suppressions"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the index used by the reason filter
+func (r *SuppressionRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "reason", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		r.logger.Error("failed to create suppression indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *SuppressionRepository) Add(ctx context.Context, s *suppression.Suppression) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("add_suppression").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if _, err := r.collection.InsertOne(ctx, s); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to add suppression", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *SuppressionRepository) Get(ctx context.Context, address string) (*suppression.Suppression, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_suppression").Observe(time.Since(startTime).Seconds())
+	}()
+
+	var result suppression.Suppression
+	if err := r.collection.FindOne(ctx, bson.M{"_id": address}).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get suppression", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *SuppressionRepository) Delete(ctx context.Context, address string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_suppression").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": address})
+	if err != nil {
+		r.logger.Error("failed to delete suppression", zap.Error(err))
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// List returns one page of suppressions ordered by address
+func (r *SuppressionRepository) List(ctx context.Context, query *suppression.ListQuery) ([]*suppression.Suppression, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_suppressions").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &suppression.ListQuery{}
+	}
+
+	filter := bson.D{}
+	if query.Reason != "" {
+		filter = append(filter, bson.E{Key: "reason", Value: query.Reason})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.M{"$gt": after.ID}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	return r.find(ctx, filter, opts)
+}
+
+func (r *SuppressionRepository) Find(ctx context.Context, addresses []string) ([]*suppression.Suppression, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("find_suppressions").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if len(addresses) == 0 {
+		return []*suppression.Suppression{}, nil
+	}
+	return r.find(ctx, bson.M{"_id": bson.M{"$in": addresses}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+func (r *SuppressionRepository) find(ctx context.Context, filter any, opts *options.FindOptions) ([]*suppression.Suppression, error) {
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list suppressions", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*suppression.Suppression{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode suppressions", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
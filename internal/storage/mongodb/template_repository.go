@@ -0,0 +1,196 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type TemplateRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewTemplateRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *TemplateRepository {
+	return &TemplateRepository{
+		collection: db.Collection("templates"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the indexes used by List filters
+func (r *TemplateRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "ownerId", Value: 1}, {Key: "department", Value: 1}}},
+	})
+	if err != nil {
+		r.logger.Error("failed to create template indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *TemplateRepository) Create(ctx context.Context, t *template.Template) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_template").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+
+	if _, err := r.collection.InsertOne(ctx, t); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create template", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *TemplateRepository) Get(ctx context.Context, id string) (*template.Template, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_template").Observe(time.Since(startTime).Seconds())
+	}()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrNotFound
+	}
+
+	var result template.Template
+	if err := r.collection.FindOne(ctx, bson.M{"_id": oid}).Decode(&result); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get template", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *TemplateRepository) Update(ctx context.Context, t *template.Template) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_template").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": t.ID}, t)
+	if err != nil {
+		r.logger.Error("failed to update template", zap.String("id", t.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *TemplateRepository) Delete(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_template").Observe(time.Since(startTime).Seconds())
+	}()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return storage.ErrNotFound
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		r.logger.Error("failed to delete template", zap.String("id", id), zap.Error(err))
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// List returns one page of templates ordered by name
+func (r *TemplateRepository) List(ctx context.Context, query *template.ListQuery) ([]*template.Template, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_templates").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &template.ListQuery{}
+	}
+
+	filter, err := templateFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list templates", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*template.Template{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode templates", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func templateFilter(query *template.ListQuery) (bson.D, error) {
+	filter := bson.D{}
+
+	if query.Kind != "" {
+		filter = append(filter, bson.E{Key: "kind", Value: query.Kind})
+	}
+	if scope := query.VisibleTo; scope != nil {
+		// Empty owners and departments are omitted from documents
+		unowned := bson.M{"$in": bson.A{nil, ""}}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"ownerId": scope.OwnerID},
+			bson.M{"ownerId": unowned, "department": bson.M{"$in": bson.A{nil, "", scope.Department}}},
+		}})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		oid, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		filter = append(filter, bson.E{Key: "$and", Value: bson.A{bson.M{"$or": bson.A{
+			bson.M{"name": bson.M{"$gt": after.Key}},
+			bson.M{"name": after.Key, "_id": bson.M{"$gt": oid}},
+		}}}})
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,172 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/thread"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// ThreadRepository stores threads keyed by their ThreadID, which is the
+// identifier referenced by emails.
+type ThreadRepository struct {
+	collection *mongo.Collection
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewThreadRepository(db *mongo.Database, logger *zap.Logger, metrics *metrics.Metrics) *ThreadRepository {
+	return &ThreadRepository{
+		collection: db.Collection("threads"),
+		logger:     logger,
+		metrics:    metrics,
+	}
+}
+
+// EnsureIndexes creates the indexes used by lookups and List filters
+func (r *ThreadRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "threadId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "lastMessage.sentAt", Value: -1}, {Key: "threadId", Value: -1}}},
+		{Keys: bson.D{{Key: "participants.email", Value: 1}, {Key: "lastMessage.sentAt", Value: -1}}},
+	})
+	if err != nil {
+		r.logger.Error("failed to create thread indexes", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (r *ThreadRepository) Create(ctx context.Context, t *thread.Thread) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_thread").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+
+	if _, err := r.collection.InsertOne(ctx, t); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create thread", zap.String("thread_id", t.ThreadID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *ThreadRepository) Get(ctx context.Context, threadID string) (*thread.Thread, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_thread").Observe(time.Since(startTime).Seconds())
+	}()
+
+	var result thread.Thread
+	err := r.collection.FindOne(ctx, bson.M{"threadId": threadID}).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get thread", zap.String("thread_id", threadID), zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *ThreadRepository) Update(ctx context.Context, t *thread.Thread) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_thread").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"threadId": t.ThreadID}, t)
+	if err != nil {
+		r.logger.Error("failed to update thread", zap.String("thread_id", t.ThreadID), zap.Error(err))
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *ThreadRepository) Delete(ctx context.Context, threadID string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_thread").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"threadId": threadID})
+	if err != nil {
+		r.logger.Error("failed to delete thread", zap.String("thread_id", threadID), zap.Error(err))
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// List returns one page of threads, most recently active first
+func (r *ThreadRepository) List(ctx context.Context, query *thread.ListQuery) ([]*thread.Thread, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_threads").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &thread.ListQuery{}
+	}
+
+	filter := bson.D{}
+	if query.Participant != "" {
+		filter = append(filter, bson.E{Key: "participants.email", Value: query.Participant})
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"lastMessage.sentAt": bson.M{"$lt": t}},
+			bson.M{"lastMessage.sentAt": t, "threadId": bson.M{"$lt": after.ID}},
+		}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "lastMessage.sentAt", Value: -1}, {Key: "threadId", Value: -1}}).
+		SetLimit(int64(storage.PageSize(query.Limit)))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		r.logger.Error("failed to list threads", zap.Error(err))
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*thread.Thread{}
+	if err := cursor.All(ctx, &results); err != nil {
+		r.logger.Error("failed to decode threads", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/audit"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const auditColumns = `id, occurred_at, actor_id, action, resource, resource_id, ip, user_agent, risk, details`
+
+type AuditRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewAuditRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *AuditRepository {
+	return &AuditRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *AuditRepository) StoreAuditEvent(ctx context.Context, event *audit.Event) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("store_audit_event").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	var details any
+	if event.Details != nil {
+		details = jsonb{event.Details}
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO audit_events (`+auditColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		event.ID, event.Timestamp, event.ActorID, event.Action, event.Resource,
+		event.ResourceID, event.IP, event.UserAgent, event.Risk, details)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to store audit event", zap.String("action", event.Action), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListAuditEvents returns one page of audit events, newest first
+func (r *AuditRepository) ListAuditEvents(ctx context.Context, query *audit.ListQuery) ([]*audit.Event, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_audit_events").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &audit.ListQuery{}
+	}
+
+	conds := &conditions{}
+	for _, filter := range []struct{ column, value string }{
+		{"actor_id", query.ActorID},
+		{"action", query.Action},
+		{"resource", query.Resource},
+		{"resource_id", query.ResourceID},
+		{"risk", query.Risk},
+	} {
+		if filter.value != "" {
+			conds.add(filter.column + " = " + conds.arg(filter.value))
+		}
+	}
+	if query.Since != nil {
+		conds.add("occurred_at >= " + conds.arg(*query.Since))
+	}
+	if query.Until != nil {
+		conds.add("occurred_at < " + conds.arg(*query.Until))
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		conds.add("(occurred_at, id) < (" + conds.arg(t) + ", " + conds.arg(after.ID) + ")")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_events`+conds.where()+
+		` ORDER BY occurred_at DESC, id DESC LIMIT `+conds.arg(storage.PageSize(query.Limit)),
+		conds.args...)
+	if err != nil {
+		r.logger.Error("failed to list audit events", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*audit.Event{}
+	for rows.Next() {
+		var event audit.Event
+		err := rows.Scan(&event.ID, &event.Timestamp, &event.ActorID, &event.Action, &event.Resource,
+			&event.ResourceID, &event.IP, &event.UserAgent, &event.Risk, jsonb{&event.Details})
+		if err != nil {
+			r.logger.Error("failed to decode audit events", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, &event)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list audit events", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
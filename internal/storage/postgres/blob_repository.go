@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/blob"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.uber.org/zap"
+)
+
+const blobColumns = `hash, size, ref_count, deleting, created_at, updated_at`
+
+type BlobRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewBlobRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *BlobRepository {
+	return &BlobRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+// Acquire inserts the blob or adds a reference to it. The conflict update
+// is skipped while the blob is marked for deletion, which returns no row.
+func (r *BlobRepository) Acquire(ctx context.Context, hash string, size int64) (*blob.Blob, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("acquire_blob").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	row := r.db.QueryRowContext(ctx, `INSERT INTO blobs (`+blobColumns+`)
+		VALUES ($1, $2, 1, FALSE, $3, $3)
+		ON CONFLICT (hash) DO UPDATE SET ref_count = blobs.ref_count + 1, deleting = FALSE, updated_at = $3
+		WHERE NOT blobs.deleting OR blobs.updated_at < $4
+		RETURNING `+blobColumns,
+		hash, size, now, now.Add(-blob.DeleteTimeout))
+
+	result, err := scanBlob(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrBlobDeleting
+		}
+		r.logger.Error("failed to acquire blob", zap.String("hash", hash), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *BlobRepository) Release(ctx context.Context, hash string) (*blob.Blob, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("release_blob").Observe(time.Since(startTime).Seconds())
+	}()
+
+	row := r.db.QueryRowContext(ctx, `UPDATE blobs SET ref_count = ref_count - 1, updated_at = $2
+		WHERE hash = $1 AND ref_count > 0
+		RETURNING `+blobColumns,
+		hash, time.Now())
+
+	result, err := scanBlob(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to release blob", zap.String("hash", hash), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *BlobRepository) MarkDeleting(ctx context.Context, hash string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("mark_blob_deleting").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, `UPDATE blobs SET deleting = TRUE, updated_at = $2
+		WHERE hash = $1 AND ref_count = 0 AND (NOT deleting OR updated_at < $3)`,
+		hash, now, now.Add(-blob.DeleteTimeout))
+	if err != nil {
+		r.logger.Error("failed to mark blob for deletion", zap.String("hash", hash), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+func (r *BlobRepository) Delete(ctx context.Context, hash string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_blob").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM blobs WHERE hash = $1 AND deleting`, hash)
+	if err != nil {
+		r.logger.Error("failed to delete blob", zap.String("hash", hash), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+func (r *BlobRepository) Stats(ctx context.Context) (*blob.Stats, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("blob_stats").Observe(time.Since(startTime).Seconds())
+	}()
+
+	var result blob.Stats
+	err := r.db.QueryRowContext(ctx, `SELECT count(*), coalesce(sum(ref_count), 0), coalesce(sum(size), 0),
+		coalesce(sum(size * ref_count), 0)
+		FROM blobs WHERE ref_count > 0`).
+		Scan(&result.Blobs, &result.References, &result.StoredBytes, &result.LogicalBytes)
+	if err != nil {
+		r.logger.Error("failed to aggregate blob stats", zap.Error(err))
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func scanBlob(row scanner) (*blob.Blob, error) {
+	var b blob.Blob
+	err := row.Scan(&b.Hash, &b.Size, &b.RefCount, &b.Deleting, &b.CreatedAt, &b.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
@@ -0,0 +1,459 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const campaignColumns = `id, name, template_id, subject, signature, variables, rate, status,
+	created_by, created_at, updated_at, started_at, completed_at, run`
+
+const recipientColumns = `id, campaign_id, email, name, variables, status, token, email_id, message_id,
+	error, attempts, sent_at, created_at, updated_at`
+
+// recipientBatch bounds the rows of one insert, keeping it well below the
+// limit of 65535 arguments per statement
+const recipientBatch = 1000
+
+type CampaignRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewCampaignRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *CampaignRepository {
+	return &CampaignRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *CampaignRepository) Create(ctx context.Context, c *campaign.Campaign) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_campaign").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO campaigns (`+campaignColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		campaignArgs(c)...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create campaign", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *CampaignRepository) Get(ctx context.Context, id string) (*campaign.Campaign, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_campaign").Observe(time.Since(startTime).Seconds())
+	}()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+campaignColumns+` FROM campaigns WHERE id = $1`, id)
+	result, err := scanCampaign(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get campaign", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *CampaignRepository) Update(ctx context.Context, c *campaign.Campaign) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_campaign").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE campaigns SET
+		name = $2, template_id = $3, subject = $4, signature = $5, variables = $6, rate = $7, status = $8,
+		created_by = $9, created_at = $10, updated_at = $11, started_at = $12, completed_at = $13, run = $14
+		WHERE id = $1`,
+		campaignArgs(c)...)
+	if err != nil {
+		r.logger.Error("failed to update campaign", zap.String("id", c.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+// Delete removes a campaign; its recipients are deleted by cascade
+func (r *CampaignRepository) Delete(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_campaign").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM campaigns WHERE id = $1`, id)
+	if err != nil {
+		r.logger.Error("failed to delete campaign", zap.String("id", id), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+// List returns one page of campaigns, newest first
+func (r *CampaignRepository) List(ctx context.Context, query *campaign.ListQuery) ([]*campaign.Campaign, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_campaigns").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &campaign.ListQuery{}
+	}
+
+	conds := &conditions{}
+	if query.CreatedBy != "" {
+		conds.add("created_by = " + conds.arg(query.CreatedBy))
+	}
+	if query.Status != "" {
+		conds.add("status = " + conds.arg(query.Status))
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		conds.add("(created_at, id) < (" + conds.arg(t) + ", " + conds.arg(after.ID) + ")")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+campaignColumns+` FROM campaigns`+conds.where()+
+		` ORDER BY created_at DESC, id DESC LIMIT `+conds.arg(storage.PageSize(query.Limit)),
+		conds.args...)
+	if err != nil {
+		r.logger.Error("failed to list campaigns", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*campaign.Campaign{}
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			r.logger.Error("failed to decode campaign", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, c)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list campaigns", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// AddRecipients inserts recipients in batches, skipping addresses the
+// campaign already lists
+func (r *CampaignRepository) AddRecipients(ctx context.Context, recipients []*campaign.Recipient) (int, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("add_campaign_recipients").Observe(time.Since(startTime).Seconds())
+	}()
+
+	added := 0
+	for start := 0; start < len(recipients); start += recipientBatch {
+		batch := recipients[start:min(start+recipientBatch, len(recipients))]
+
+		conds := &conditions{}
+		values := make([]string, len(batch))
+		for i, recipient := range batch {
+			if recipient.ID.IsZero() {
+				recipient.ID = primitive.NewObjectID()
+			}
+			placeholders := make([]string, 0, 14)
+			for _, arg := range recipientArgs(recipient) {
+				placeholders = append(placeholders, conds.arg(arg))
+			}
+			values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+		}
+
+		result, err := r.db.ExecContext(ctx, `INSERT INTO campaign_recipients (`+recipientColumns+`)
+			VALUES `+strings.Join(values, ", ")+`
+			ON CONFLICT (campaign_id, email) DO NOTHING`,
+			conds.args...)
+		if err != nil {
+			r.logger.Error("failed to add campaign recipients", zap.Error(err))
+			return added, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return added, err
+		}
+		added += int(n)
+	}
+
+	return added, nil
+}
+
+// ListRecipients returns one page of recipients in the order they were
+// added
+func (r *CampaignRepository) ListRecipients(ctx context.Context, query *campaign.RecipientQuery) ([]*campaign.Recipient, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_campaign_recipients").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &campaign.RecipientQuery{}
+	}
+
+	conds := &conditions{}
+	if query.CampaignID != "" {
+		conds.add("campaign_id = " + conds.arg(query.CampaignID))
+	}
+	if query.Email != "" {
+		conds.add("email = " + conds.arg(query.Email))
+	}
+	if query.Token != "" {
+		conds.add("token = " + conds.arg(query.Token))
+	}
+	if query.MessageID != "" {
+		conds.add("message_id = " + conds.arg(query.MessageID))
+	}
+	if len(query.Statuses) > 0 {
+		conds.add("status = ANY(" + conds.arg(pq.Array(query.Statuses)) + "::text[])")
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		conds.add("id > " + conds.arg(after.ID))
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+recipientColumns+` FROM campaign_recipients`+conds.where()+
+		` ORDER BY id LIMIT `+conds.arg(storage.PageSize(query.Limit)),
+		conds.args...)
+	if err != nil {
+		r.logger.Error("failed to list campaign recipients", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*campaign.Recipient{}
+	for rows.Next() {
+		recipient, err := scanRecipient(rows)
+		if err != nil {
+			r.logger.Error("failed to decode campaign recipient", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, recipient)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list campaign recipients", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *CampaignRepository) UpdateRecipient(ctx context.Context, recipient *campaign.Recipient) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_campaign_recipient").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE campaign_recipients SET
+		campaign_id = $2, email = $3, name = $4, variables = $5, status = $6, token = $7, email_id = $8,
+		message_id = $9, error = $10, attempts = $11, sent_at = $12, created_at = $13, updated_at = $14
+		WHERE id = $1`,
+		recipientArgs(recipient)...)
+	if err != nil {
+		r.logger.Error("failed to update campaign recipient", zap.String("id", recipient.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+func (r *CampaignRepository) ReplaceRecipient(ctx context.Context, recipient *campaign.Recipient, status string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("replace_campaign_recipient").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE campaign_recipients SET
+		campaign_id = $2, email = $3, name = $4, variables = $5, status = $6, token = $7, email_id = $8,
+		message_id = $9, error = $10, attempts = $11, sent_at = $12, created_at = $13, updated_at = $14
+		WHERE id = $1 AND status = $15`,
+		append(recipientArgs(recipient), status)...)
+	if err != nil {
+		r.logger.Error("failed to replace campaign recipient", zap.String("id", recipient.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if err := rowsAffected(result); !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	// Tell a changed recipient from a missing one
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM campaign_recipients WHERE id = $1)`,
+		recipient.ID.Hex()).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return storage.ErrNotFound
+	}
+	return storage.ErrConflict
+}
+
+func (r *CampaignRepository) CountRecipients(ctx context.Context, campaignID string) (map[string]int, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("count_campaign_recipients").Observe(time.Since(startTime).Seconds())
+	}()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM campaign_recipients
+		WHERE campaign_id = $1 GROUP BY status`, campaignID)
+	if err != nil {
+		r.logger.Error("failed to count campaign recipients", zap.String("campaign_id", campaignID), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			r.logger.Error("failed to decode campaign recipient counts", zap.Error(err))
+			return nil, err
+		}
+		counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to count campaign recipients", zap.String("campaign_id", campaignID), zap.Error(err))
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+func campaignArgs(c *campaign.Campaign) []any {
+	return []any{
+		c.ID.Hex(),
+		c.Name,
+		c.TemplateID,
+		c.Subject,
+		c.Signature,
+		jsonb{c.Variables},
+		c.Rate,
+		c.Status,
+		c.CreatedBy,
+		c.CreatedAt,
+		c.UpdatedAt,
+		c.StartedAt,
+		c.CompletedAt,
+		c.Run,
+	}
+}
+
+func scanCampaign(row scanner) (*campaign.Campaign, error) {
+	var c campaign.Campaign
+	var id string
+	err := row.Scan(
+		&id,
+		&c.Name,
+		&c.TemplateID,
+		&c.Subject,
+		&c.Signature,
+		jsonb{&c.Variables},
+		&c.Rate,
+		&c.Status,
+		&c.CreatedBy,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.StartedAt,
+		&c.CompletedAt,
+		&c.Run,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func recipientArgs(recipient *campaign.Recipient) []any {
+	return []any{
+		recipient.ID.Hex(),
+		recipient.CampaignID,
+		recipient.Email,
+		recipient.Name,
+		jsonb{recipient.Variables},
+		recipient.Status,
+		recipient.Token,
+		recipient.EmailID,
+		recipient.MessageID,
+		recipient.Error,
+		recipient.Attempts,
+		recipient.SentAt,
+		recipient.CreatedAt,
+		recipient.UpdatedAt,
+	}
+}
+
+func scanRecipient(row scanner) (*campaign.Recipient, error) {
+	var recipient campaign.Recipient
+	var id string
+	err := row.Scan(
+		&id,
+		&recipient.CampaignID,
+		&recipient.Email,
+		&recipient.Name,
+		jsonb{&recipient.Variables},
+		&recipient.Status,
+		&recipient.Token,
+		&recipient.EmailID,
+		&recipient.MessageID,
+		&recipient.Error,
+		&recipient.Attempts,
+		&recipient.SentAt,
+		&recipient.CreatedAt,
+		&recipient.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipient.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return &recipient, nil
+}
@@ -0,0 +1,498 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const emailColumns = `id, message_id, thread_id, sender, to_recipients, cc, bcc, subject, content,
+	attachments, labels, flags, thread_info, metadata, created_at, updated_at, draft,
+	reply_to, in_reply_to, message_references, mailbox, delivery, delivery_version, version`
+
+// emailAssignments sets every column but the id and the delivery state to
+// the arguments of emailArgs
+const emailAssignments = `message_id = $2, thread_id = $3, sender = $4, to_recipients = $5, cc = $6, bcc = $7,
+	subject = $8, content = $9, attachments = $10, labels = $11, flags = $12,
+	thread_info = $13, metadata = $14, created_at = $15, updated_at = $16, draft = $17,
+	reply_to = $18, in_reply_to = $19, message_references = $20, mailbox = $21`
+
+// deliveryAssignments sets the delivery state to the arguments of emailArgs
+// that follow those of emailAssignments
+const deliveryAssignments = `delivery = $22, delivery_version = $23`
+
+// countedColumns are the columns label counters depend on
+const countedColumns = `mailbox, labels, flags`
+
+// EmailRepository stores emails with their nested fields as JSONB. IDs are
+// ObjectIDs in hex so they are interchangeable with the MongoDB backend.
+// Emails are written in transactions with the counters of their labels.
+type EmailRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewEmailRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *EmailRepository {
+	return &EmailRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *EmailRepository) Create(ctx context.Context, e *email.Email) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if e.ID.IsZero() {
+		e.ID = primitive.NewObjectID()
+	}
+
+	err := inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO emails (`+emailColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)`,
+			emailArgs(e)...)
+		if err != nil {
+			return err
+		}
+		return countLabels(ctx, tx, label.Deltas(nil, e))
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create email", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *EmailRepository) Get(ctx context.Context, id string) (*email.Email, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+emailColumns+` FROM emails WHERE id = $1`, id)
+	result, err := scanEmail(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get email", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *EmailRepository) Update(ctx context.Context, e *email.Email) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		before, err := scanCounted(tx.QueryRowContext(ctx,
+			`SELECT `+countedColumns+` FROM emails WHERE id = $1 FOR UPDATE`, e.ID.Hex()))
+		if err != nil {
+			return err
+		}
+		return r.replace(ctx, tx, before, e, false)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrNotFound
+		}
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to update email", zap.String("id", e.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// replace stores e over the locked row of the email, advancing its
+// version, and updates the label counters for its change from before. The
+// stored delivery state is kept unless withDelivery is set, as
+// ReplaceDelivery owns it.
+func (r *EmailRepository) replace(ctx context.Context, tx *sql.Tx, before, e *email.Email, withDelivery bool) error {
+	assignments, args := emailAssignments, emailArgs(e)
+	if withDelivery {
+		assignments += `, ` + deliveryAssignments
+		args = args[:23]
+	} else {
+		args = args[:21]
+	}
+	query := `UPDATE emails SET ` + assignments + `, version = version + 1 WHERE id = $1 RETURNING version`
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&e.Version); err != nil {
+		return err
+	}
+	return countLabels(ctx, tx, label.Deltas(before, e))
+}
+
+func (r *EmailRepository) ReplaceVersion(ctx context.Context, e *email.Email, version int64) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("replace_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		var stored int64
+		before, err := scanCounted(tx.QueryRowContext(ctx,
+			`SELECT `+countedColumns+`, version FROM emails WHERE id = $1 FOR UPDATE`, e.ID.Hex()), &stored)
+		if err != nil {
+			return err
+		}
+		if stored != version {
+			return storage.ErrConflict
+		}
+		return r.replace(ctx, tx, before, e, false)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrNotFound
+		}
+		if errors.Is(err, storage.ErrConflict) {
+			return err
+		}
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to replace email", zap.String("id", e.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *EmailRepository) ReplaceDraft(ctx context.Context, e *email.Email, version int64) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("replace_draft").Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		var stored sql.NullInt64
+		before, err := scanCounted(tx.QueryRowContext(ctx,
+			`SELECT `+countedColumns+`, (draft->>'version')::BIGINT FROM emails WHERE id = $1 FOR UPDATE`,
+			e.ID.Hex()), &stored)
+		if err != nil {
+			return err
+		}
+		// A changed draft, or an email that is no longer a draft
+		if !stored.Valid || stored.Int64 != version {
+			return storage.ErrConflict
+		}
+		return r.replace(ctx, tx, before, e, true)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrNotFound
+		}
+		if errors.Is(err, storage.ErrConflict) {
+			return err
+		}
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to replace draft", zap.String("id", e.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *EmailRepository) ReplaceDelivery(ctx context.Context, e *email.Email, version int64) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("replace_delivery").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE emails SET delivery = $2, delivery_version = $3, updated_at = $4
+		WHERE id = $1 AND delivery_version = $5`,
+		e.ID.Hex(), jsonb{e.Delivery}, e.DeliveryVersion, e.UpdatedAt, version)
+	if err != nil {
+		r.logger.Error("failed to replace delivery", zap.String("id", e.ID.Hex()), zap.Error(err))
+		return err
+	}
+	if err := rowsAffected(result); !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	// Tell a changed delivery state from a missing email
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM emails WHERE id = $1)`,
+		e.ID.Hex()).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return storage.ErrNotFound
+	}
+	return storage.ErrConflict
+}
+
+func (r *EmailRepository) Delete(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		before, err := scanCounted(tx.QueryRowContext(ctx,
+			`DELETE FROM emails WHERE id = $1 RETURNING `+countedColumns, id))
+		if err != nil {
+			return err
+		}
+		return countLabels(ctx, tx, label.Deltas(before, nil))
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrNotFound
+		}
+		r.logger.Error("failed to delete email", zap.String("id", id), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// List returns one page of emails matching the query, newest first
+func (r *EmailRepository) List(ctx context.Context, query *email.ListQuery) ([]*email.Email, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_emails").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &email.ListQuery{}
+	}
+
+	conds, err := emailConditions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		conds.add("(created_at, id) < (" + conds.arg(t) + ", " + conds.arg(after.ID) + ")")
+	}
+
+	sqlQuery := `SELECT ` + emailColumns + ` FROM emails` + conds.where() +
+		` ORDER BY created_at DESC, id DESC LIMIT ` + conds.arg(storage.PageSize(query.Limit))
+
+	return r.query(ctx, "list emails", sqlQuery, conds.args)
+}
+
+// Search ranks emails by PostgreSQL full-text search over the subject and
+// plain text body. It backs email search when no search engine is
+// configured.
+func (r *EmailRepository) Search(ctx context.Context, text string, query *email.ListQuery) ([]*email.Email, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("search_emails").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &email.ListQuery{}
+	}
+
+	conds, err := emailConditions(query)
+	if err != nil {
+		return nil, err
+	}
+	tsquery := "websearch_to_tsquery('english', " + conds.arg(text) + ")"
+	conds.add("search @@ " + tsquery)
+
+	sqlQuery := `SELECT ` + emailColumns + ` FROM emails` + conds.where() +
+		` ORDER BY ts_rank(search, ` + tsquery + `) DESC, created_at DESC, id DESC LIMIT ` +
+		conds.arg(storage.PageSize(query.Limit))
+
+	return r.query(ctx, "search emails", sqlQuery, conds.args)
+}
+
+func (r *EmailRepository) query(ctx context.Context, op, sqlQuery string, args []any) ([]*email.Email, error) {
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		r.logger.Error("failed to "+op, zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*email.Email{}
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			r.logger.Error("failed to decode emails", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, e)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to "+op, zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// emailConditions translates the filters of query, except the cursor
+func emailConditions(query *email.ListQuery) (*conditions, error) {
+	conds := &conditions{}
+
+	if query.Mailbox != "" {
+		conds.add("mailbox = " + conds.arg(query.Mailbox))
+	}
+	if query.Participant != "" {
+		address := conds.arg(query.Participant)
+		recipient := "jsonb_build_array(jsonb_build_object('email', " + address + "::text))"
+		conds.add("(sender->>'email' = " + address +
+			" OR to_recipients @> " + recipient +
+			" OR cc @> " + recipient +
+			" OR bcc @> " + recipient + ")")
+	}
+	if query.From != "" {
+		conds.add("sender->>'email' = " + conds.arg(query.From))
+	}
+	if query.MessageID != "" {
+		conds.add("message_id = " + conds.arg(query.MessageID))
+	}
+	if query.ThreadID != "" {
+		conds.add("thread_id = " + conds.arg(query.ThreadID))
+	}
+	if len(query.Labels) > 0 {
+		conds.add("labels @> " + conds.arg(pq.Array(query.Labels)) + "::text[]")
+	}
+
+	flags := map[string]bool{}
+	if query.IsRead != nil {
+		flags["isRead"] = *query.IsRead
+	}
+	if query.IsStarred != nil {
+		flags["isStarred"] = *query.IsStarred
+	}
+	if query.IsDraft != nil {
+		flags["isDraft"] = *query.IsDraft
+	}
+	if len(flags) > 0 {
+		conds.add("flags @> " + conds.arg(jsonb{flags}) + "::jsonb")
+	}
+
+	if query.Since != nil {
+		conds.add("created_at >= " + conds.arg(*query.Since))
+	}
+	if query.Until != nil {
+		conds.add("created_at < " + conds.arg(*query.Until))
+	}
+
+	return conds, nil
+}
+
+func emailArgs(e *email.Email) []any {
+	return []any{
+		e.ID.Hex(),
+		e.MessageID,
+		e.ThreadID,
+		jsonb{e.From},
+		jsonb{e.To},
+		jsonb{e.CC},
+		jsonb{e.BCC},
+		e.Subject,
+		jsonb{e.Content},
+		jsonb{e.Attachments},
+		pq.Array(e.Labels),
+		jsonb{e.Flags},
+		jsonb{e.ThreadInfo},
+		jsonb{e.Metadata},
+		e.CreatedAt,
+		e.UpdatedAt,
+		draftArg(e.Draft),
+		jsonb{e.ReplyTo},
+		e.InReplyTo,
+		pq.Array(e.References),
+		e.Mailbox,
+		jsonb{e.Delivery},
+		e.DeliveryVersion,
+		e.Version,
+	}
+}
+
+// draftArg stores sent emails with a NULL draft
+func draftArg(d *email.Draft) any {
+	if d == nil {
+		return nil
+	}
+	return jsonb{d}
+}
+
+func scanEmail(row scanner) (*email.Email, error) {
+	var e email.Email
+	var id string
+	err := row.Scan(
+		&id,
+		&e.MessageID,
+		&e.ThreadID,
+		jsonb{&e.From},
+		jsonb{&e.To},
+		jsonb{&e.CC},
+		jsonb{&e.BCC},
+		&e.Subject,
+		jsonb{&e.Content},
+		jsonb{&e.Attachments},
+		pq.Array(&e.Labels),
+		jsonb{&e.Flags},
+		jsonb{&e.ThreadInfo},
+		jsonb{&e.Metadata},
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		jsonb{&e.Draft},
+		jsonb{&e.ReplyTo},
+		&e.InReplyTo,
+		pq.Array(&e.References),
+		&e.Mailbox,
+		jsonb{&e.Delivery},
+		&e.DeliveryVersion,
+		&e.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// scanCounted scans the countedColumns of an email, followed by extra
+func scanCounted(row scanner, extra ...any) (*email.Email, error) {
+	var e email.Email
+	dest := append([]any{&e.Mailbox, pq.Array(&e.Labels), jsonb{&e.Flags}}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
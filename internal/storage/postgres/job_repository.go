@@ -0,0 +1,247 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/jobs"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const jobColumns = `id, type, payload, status, attempts, max_attempts, run_at, locked_by, locked_until,
+	last_error, created_at, updated_at, completed_at`
+
+type JobRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewJobRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *JobRepository {
+	return &JobRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *JobRepository) Enqueue(ctx context.Context, job *jobs.Job) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("enqueue_job").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	if job.Status == "" {
+		job.Status = jobs.StatusPending
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	job.CreatedAt, job.UpdatedAt = now, now
+
+	var payload any
+	if len(job.Payload) > 0 {
+		payload = string(job.Payload)
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO jobs (`+jobColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		job.ID, job.Type, payload, job.Status, job.Attempts, job.MaxAttempts, job.RunAt,
+		job.LockedBy, job.LockedUntil, job.LastError, job.CreatedAt, job.UpdatedAt, job.CompletedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to enqueue job", zap.String("type", job.Type), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *JobRepository) Get(ctx context.Context, id string) (*jobs.Job, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_job").Observe(time.Since(startTime).Seconds())
+	}()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id)
+	result, err := scanJob(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get job", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Claim locks the due row with SKIP LOCKED, so concurrent workers each take
+// a different job instead of waiting on one another
+func (r *JobRepository) Claim(ctx context.Context, worker string, lease time.Duration) (*jobs.Job, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("claim_job").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	row := r.db.QueryRowContext(ctx, `UPDATE jobs SET
+		status = $1, locked_by = $2, locked_until = $3, updated_at = $4, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = $5 AND run_at <= $4) OR (status = $1 AND locked_until < $4)
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		jobs.StatusRunning, worker, now.Add(lease), now, jobs.StatusPending)
+
+	result, err := scanJob(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to claim job", zap.String("worker", worker), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *JobRepository) Complete(ctx context.Context, id, worker string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("complete_job").Observe(time.Since(startTime).Seconds())
+	}()
+
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, `UPDATE jobs SET
+		status = $1, completed_at = $2, updated_at = $2, locked_by = '', locked_until = NULL
+		WHERE id = $3 AND status = $4 AND locked_by = $5`,
+		jobs.StatusCompleted, now, id, jobs.StatusRunning, worker)
+	if err != nil {
+		r.logger.Error("failed to update job", zap.String("id", id), zap.String("op", "complete_job"), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+func (r *JobRepository) Fail(ctx context.Context, id, worker, reason string, retryAt *time.Time) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("fail_job").Observe(time.Since(startTime).Seconds())
+	}()
+
+	status := jobs.StatusFailed
+	if retryAt != nil {
+		status = jobs.StatusPending
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE jobs SET
+		status = $1, last_error = $2, run_at = COALESCE($3, run_at), updated_at = $4,
+		locked_by = '', locked_until = NULL
+		WHERE id = $5 AND status = $6 AND locked_by = $7`,
+		status, reason, retryAt, time.Now(), id, jobs.StatusRunning, worker)
+	if err != nil {
+		r.logger.Error("failed to update job", zap.String("id", id), zap.String("op", "fail_job"), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+// List returns one page of jobs, newest first
+func (r *JobRepository) List(ctx context.Context, query *jobs.ListQuery) ([]*jobs.Job, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_jobs").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &jobs.ListQuery{}
+	}
+
+	conds := &conditions{}
+	if query.Type != "" {
+		conds.add("type = " + conds.arg(query.Type))
+	}
+	if query.Status != "" {
+		conds.add("status = " + conds.arg(query.Status))
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		conds.add("(created_at, id) < (" + conds.arg(t) + ", " + conds.arg(after.ID) + ")")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+jobColumns+` FROM jobs`+conds.where()+
+		` ORDER BY created_at DESC, id DESC LIMIT `+conds.arg(storage.PageSize(query.Limit)),
+		conds.args...)
+	if err != nil {
+		r.logger.Error("failed to list jobs", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*jobs.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			r.logger.Error("failed to decode jobs", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, job)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list jobs", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func scanJob(row scanner) (*jobs.Job, error) {
+	var job jobs.Job
+	var payload []byte
+	err := row.Scan(
+		&job.ID,
+		&job.Type,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedBy,
+		&job.LockedUntil,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Payload = payload
+	return &job, nil
+}
@@ -0,0 +1,270 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const labelColumns = `id, owner, name, color, total, unread, created_at, updated_at`
+
+// LabelRepository stores labels unique by owner and name. Renames and
+// deletes cascade to the emails in the same transaction.
+type LabelRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewLabelRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *LabelRepository {
+	return &LabelRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *LabelRepository) Create(ctx context.Context, l *label.Label) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if l.ID.IsZero() {
+		l.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO labels (`+labelColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		l.ID.Hex(), l.Owner, l.Name, l.Color, l.Total, l.Unread, l.CreatedAt, l.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create label", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *LabelRepository) Get(ctx context.Context, id string) (*label.Label, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := scanLabel(r.db.QueryRowContext(ctx, `SELECT `+labelColumns+` FROM labels WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get label", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// List returns the labels of the query's owner by name
+func (r *LabelRepository) List(ctx context.Context, query *label.ListQuery) ([]*label.Label, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_labels").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &label.ListQuery{}
+	}
+
+	conds := &conditions{}
+	if query.Owner != "" {
+		conds.add("owner = " + conds.arg(query.Owner))
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+labelColumns+` FROM labels`+conds.where()+` ORDER BY name, id`, conds.args...)
+	if err != nil {
+		r.logger.Error("failed to list labels", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*label.Label{}
+	for rows.Next() {
+		l, err := scanLabel(rows)
+		if err != nil {
+			r.logger.Error("failed to scan label", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, l)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list labels", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *LabelRepository) Update(ctx context.Context, l *label.Label) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE labels SET color = $2, updated_at = $3 WHERE id = $1`,
+		l.ID.Hex(), l.Color, l.UpdatedAt)
+	if err != nil {
+		r.logger.Error("failed to update label", zap.String("id", l.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+func (r *LabelRepository) Rename(ctx context.Context, owner, from, to string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("rename_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		// Count the labels moved and check none of their new names is taken
+		var moved int
+		var taken bool
+		err := tx.QueryRowContext(ctx, `SELECT count(*), EXISTS (
+			SELECT 1 FROM labels AS other WHERE other.owner = $1
+			AND other.name IN (SELECT `+renamed("l.name")+` FROM labels AS l
+				WHERE l.owner = $1 AND `+within("l.name")+`)
+			AND NOT `+within("other.name")+`)
+			FROM labels WHERE owner = $1 AND `+within("name"),
+			owner, from, to).Scan(&moved, &taken)
+		if err != nil {
+			return err
+		}
+		if moved == 0 {
+			return storage.ErrNotFound
+		}
+		if taken {
+			return storage.ErrDuplicate
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE labels SET name = `+renamed("name")+`, updated_at = $4
+			WHERE owner = $1 AND `+within("name"),
+			owner, from, to, time.Now())
+		if err != nil {
+			return err
+		}
+
+		// Counters move along with the labels they count
+		_, err = tx.ExecContext(ctx, `UPDATE emails SET labels = ARRAY(
+				SELECT CASE WHEN `+within("l")+` THEN `+renamed("l")+` ELSE l END
+				FROM unnest(labels) WITH ORDINALITY AS t(l, n) ORDER BY n)
+			WHERE mailbox = $1 AND EXISTS (SELECT 1 FROM unnest(labels) AS l WHERE `+within("l")+`)`,
+			owner, from, to)
+		return err
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		if !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrDuplicate) {
+			r.logger.Error("failed to rename label", zap.String("owner", owner), zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *LabelRepository) Delete(ctx context.Context, owner, name string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_label").Observe(time.Since(startTime).Seconds())
+	}()
+
+	err := inTransaction(ctx, r.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM labels WHERE owner = $1 AND `+within("name"), owner, name)
+		if err != nil {
+			return err
+		}
+		if err := rowsAffected(result); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE emails SET labels = ARRAY(
+				SELECT l FROM unnest(labels) WITH ORDINALITY AS t(l, n) WHERE NOT `+within("l")+` ORDER BY n)
+			WHERE mailbox = $1 AND EXISTS (SELECT 1 FROM unnest(labels) AS l WHERE `+within("l")+`)`,
+			owner, name)
+		return err
+	})
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		r.logger.Error("failed to delete label", zap.String("owner", owner), zap.Error(err))
+		return err
+	}
+
+	return err
+}
+
+// within matches the label named by argument $2, and the labels nested in
+// it, in expr
+func within(expr string) string {
+	return "(" + expr + " = $2::text OR starts_with(" + expr + ", $2::text || '" + label.Separator + "'))"
+}
+
+// renamed is label.Renamed from argument $2 to $3 of the name in expr,
+// which must be within $2
+func renamed(expr string) string {
+	return "$3::text || substr(" + expr + ", length($2::text) + 1)"
+}
+
+func scanLabel(row scanner) (*label.Label, error) {
+	var l label.Label
+	var id string
+	if err := row.Scan(&id, &l.Owner, &l.Name, &l.Color, &l.Total, &l.Unread, &l.CreatedAt, &l.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if l.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// countLabels applies label counter changes, creating the labels missing.
+// ON CONFLICT locks each row, so concurrent changes never lose counts.
+func countLabels(ctx context.Context, q querier, deltas []label.Delta) error {
+	for _, delta := range deltas {
+		now := time.Now()
+		_, err := q.ExecContext(ctx, `INSERT INTO labels (`+labelColumns+`)
+			VALUES ($1, $2, $3, '', $4, $5, $6, $6)
+			ON CONFLICT (owner, name) DO UPDATE
+			SET total = labels.total + EXCLUDED.total, unread = labels.unread + EXCLUDED.unread`,
+			primitive.NewObjectID().Hex(), delta.Owner, delta.Name, delta.Total, delta.Unread, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTransaction runs fn in a transaction, committed when fn succeeds
+func inTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
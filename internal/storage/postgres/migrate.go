@@ -0,0 +1,361 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/migrations"
+	"go.uber.org/zap"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey identifies the advisory lock held while migrating
+const migrationLockKey = 0x6d6c6d69677261
+
+const defaultLockWait = 2 * time.Minute
+
+// Migration is one versioned SQL schema change, loaded from
+// migrations/NNNN_description.up.sql and the optional .down.sql
+type Migration struct {
+	Version     int
+	Description string
+	Up          string
+	// Down reverts Up; empty marks the migration as irreversible
+	Down string
+}
+
+// Migrator applies the embedded SQL migrations. Each migration runs in one
+// transaction together with its record in schema_migrations, and replicas
+// are serialized with an advisory lock.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	owner      string
+	lockWait   time.Duration
+	logger     *zap.Logger
+}
+
+// NewMigrator creates a migrator for the embedded migrations
+func NewMigrator(db *sql.DB, logger *zap.Logger) (*Migrator, error) {
+	all, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		migrations: all,
+		owner:      fmt.Sprintf("%s:%d", host, os.Getpid()),
+		lockWait:   defaultLockWait,
+		logger:     logger,
+	}, nil
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, name := range names {
+		base := strings.TrimPrefix(name, "migrations/")
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", base)
+		}
+		prefix, description, _ := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", base)
+		}
+
+		body, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Description: strings.ReplaceAll(description, "_", " ")}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	all := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up step", m.Version)
+		}
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
+
+// Status lists every migration with whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]migrations.Status, error) {
+	if err := m.ensureTable(ctx, m.db); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []migrations.Status
+	known := make(map[int]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		status := migrations.Status{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Reversible:  migration.Down != "",
+		}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	for version, record := range applied {
+		if !known[version] {
+			statuses = append(statuses, migrations.Status{
+				Version:     version,
+				Description: record.Description,
+				Applied:     true,
+				AppliedAt:   record.AppliedAt,
+				Unknown:     true,
+			})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies pending migrations in version order and returns the ones it
+// applied, or would apply for a dry run.
+func (m *Migrator) Up(ctx context.Context, opts migrations.RunOptions) ([]Migration, error) {
+	return m.run(ctx, func(ctx context.Context, q querier) ([]Migration, error) {
+		return m.pending(ctx, q, opts.Target)
+	}, opts.DryRun, m.apply)
+}
+
+// Down rolls back applied migrations newest first and returns the ones it
+// rolled back, or would roll back for a dry run. Nothing is rolled back if
+// any migration in the plan is irreversible or unknown.
+func (m *Migrator) Down(ctx context.Context, opts migrations.RunOptions) ([]Migration, error) {
+	return m.run(ctx, func(ctx context.Context, q querier) ([]Migration, error) {
+		return m.rollbackPlan(ctx, q, opts)
+	}, opts.DryRun, m.revert)
+}
+
+// run plans and executes migrations on a single connection holding the
+// advisory lock, which is released when the connection is returned
+func (m *Migrator) run(
+	ctx context.Context,
+	plan func(context.Context, querier) ([]Migration, error),
+	dryRun bool,
+	step func(context.Context, *sql.Conn, Migration) error,
+) ([]Migration, error) {
+	if dryRun {
+		if err := m.ensureTable(ctx, m.db); err != nil {
+			return nil, err
+		}
+		return plan(ctx, m.db)
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	lockCtx, cancel := context.WithTimeout(ctx, m.lockWait)
+	defer cancel()
+	if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			m.logger.Error("failed to release migration lock", zap.Error(err))
+		}
+	}()
+
+	// Read the plan under the lock, another replica may have just migrated
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	todo, err := plan(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range todo {
+		if err := step(ctx, conn, migration); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	m.logger.Info("applying migration", zap.Int("version", migration.Version), zap.String("description", migration.Description))
+
+	start := time.Now()
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, description, applied_at, applied_by, duration_ms)
+			VALUES ($1, $2, $3, $4, $5)`,
+			migration.Version, migration.Description, time.Now(), m.owner, time.Since(start).Milliseconds())
+		if err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	m.logger.Info("rolling back migration", zap.Int("version", migration.Version), zap.String("description", migration.Description))
+
+	return inTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return fmt.Errorf("rollback of migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version); err != nil {
+			return fmt.Errorf("failed to remove record of migration %d: %w", migration.Version, err)
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) pending(ctx context.Context, q querier, target int) ([]Migration, error) {
+	applied, err := m.applied(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []Migration
+	for _, migration := range m.migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			plan = append(plan, migration)
+		}
+	}
+	return plan, nil
+}
+
+func (m *Migrator) rollbackPlan(ctx context.Context, q querier, opts migrations.RunOptions) ([]Migration, error) {
+	applied, err := m.applied(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		if opts.Target == 0 || version > opts.Target {
+			versions = append(versions, version)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	if opts.Target == 0 {
+		steps := opts.Steps
+		if steps <= 0 {
+			steps = 1
+		}
+		if steps < len(versions) {
+			versions = versions[:steps]
+		}
+	}
+
+	byVersion := make(map[int]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	plan := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		migration, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d is applied but unknown to this release", version)
+		}
+		if migration.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, migrations.ErrIrreversible)
+		}
+		plan = append(plan, migration)
+	}
+	return plan, nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, q querier) error {
+	_, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at  TIMESTAMPTZ NOT NULL,
+		applied_by  TEXT NOT NULL,
+		duration_ms BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context, q querier) (map[int]migrations.Record, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT version, description, applied_at, applied_by, duration_ms FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]migrations.Record{}
+	for rows.Next() {
+		var record migrations.Record
+		if err := rows.Scan(&record.Version, &record.Description, &record.AppliedAt, &record.AppliedBy, &record.DurationMs); err != nil {
+			return nil, fmt.Errorf("failed to decode applied migrations: %w", err)
+		}
+		applied[record.Version] = record
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
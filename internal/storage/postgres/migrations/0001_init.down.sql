@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS threads;
+DROP TABLE IF EXISTS staff;
+DROP TABLE IF EXISTS emails;
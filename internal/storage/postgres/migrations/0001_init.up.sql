@@ -0,0 +1,110 @@
+-- Identifiers and sort keys use the C collation so that ordering and cursor
+-- comparisons are bytewise, as in MongoDB.
+
+CREATE TABLE emails (
+    id            TEXT COLLATE "C" PRIMARY KEY,
+    message_id    TEXT NOT NULL UNIQUE,
+    thread_id     TEXT,
+    sender        JSONB NOT NULL,
+    to_recipients JSONB,
+    cc            JSONB,
+    bcc           JSONB,
+    subject       TEXT NOT NULL DEFAULT '',
+    content       JSONB NOT NULL,
+    attachments   JSONB,
+    labels        TEXT[],
+    flags         JSONB NOT NULL,
+    thread_info   JSONB NOT NULL,
+    metadata      JSONB NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL,
+    search        TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', subject), 'A') ||
+        setweight(to_tsvector('english', coalesce(content->>'text', '')), 'B')
+    ) STORED
+);
+
+CREATE INDEX emails_created_at_idx ON emails (created_at DESC, id DESC);
+CREATE INDEX emails_thread_id_idx ON emails (thread_id, created_at DESC);
+CREATE INDEX emails_sender_idx ON emails ((sender->>'email'), created_at DESC);
+CREATE INDEX emails_to_idx ON emails USING GIN (to_recipients jsonb_path_ops);
+CREATE INDEX emails_cc_idx ON emails USING GIN (cc jsonb_path_ops);
+CREATE INDEX emails_bcc_idx ON emails USING GIN (bcc jsonb_path_ops);
+CREATE INDEX emails_labels_idx ON emails USING GIN (labels);
+CREATE INDEX emails_flags_idx ON emails USING GIN (flags jsonb_path_ops);
+CREATE INDEX emails_search_idx ON emails USING GIN (search);
+
+CREATE TABLE staff (
+    id            TEXT COLLATE "C" PRIMARY KEY,
+    email         TEXT NOT NULL UNIQUE,
+    full_name     TEXT COLLATE "C" NOT NULL,
+    role          TEXT NOT NULL DEFAULT '',
+    department    TEXT NOT NULL DEFAULT '',
+    title         TEXT NOT NULL DEFAULT '',
+    manager_id    TEXT NOT NULL DEFAULT '',
+    profile_photo JSONB NOT NULL,
+    status        TEXT NOT NULL DEFAULT '',
+    mailbox       JSONB NOT NULL,
+    offboarding   JSONB,
+    last_active   TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX staff_full_name_idx ON staff (full_name, id);
+CREATE INDEX staff_department_idx ON staff (department);
+CREATE INDEX staff_manager_id_idx ON staff (manager_id);
+
+CREATE TABLE threads (
+    thread_id       TEXT COLLATE "C" PRIMARY KEY,
+    id              TEXT NOT NULL UNIQUE,
+    subject         TEXT NOT NULL DEFAULT '',
+    participants    JSONB,
+    last_message    JSONB NOT NULL,
+    last_message_at TIMESTAMPTZ NOT NULL,
+    message_count   INTEGER NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX threads_last_message_at_idx ON threads (last_message_at DESC, thread_id DESC);
+CREATE INDEX threads_participants_idx ON threads USING GIN (participants jsonb_path_ops);
+
+CREATE TABLE audit_events (
+    id          TEXT COLLATE "C" PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    actor_id    TEXT NOT NULL DEFAULT '',
+    action      TEXT NOT NULL DEFAULT '',
+    resource    TEXT NOT NULL DEFAULT '',
+    resource_id TEXT NOT NULL DEFAULT '',
+    ip          TEXT NOT NULL DEFAULT '',
+    user_agent  TEXT NOT NULL DEFAULT '',
+    risk        TEXT NOT NULL DEFAULT '',
+    details     JSONB
+);
+
+CREATE INDEX audit_events_occurred_at_idx ON audit_events (occurred_at DESC, id DESC);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id, occurred_at DESC);
+CREATE INDEX audit_events_resource_idx ON audit_events (resource, resource_id, occurred_at DESC);
+CREATE INDEX audit_events_risk_idx ON audit_events (risk, occurred_at DESC);
+
+CREATE TABLE jobs (
+    id           TEXT COLLATE "C" PRIMARY KEY,
+    type         TEXT NOT NULL,
+    payload      JSONB,
+    status       TEXT NOT NULL,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 0,
+    run_at       TIMESTAMPTZ NOT NULL,
+    locked_by    TEXT NOT NULL DEFAULT '',
+    locked_until TIMESTAMPTZ,
+    last_error   TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX jobs_pending_idx ON jobs (run_at, id) WHERE status = 'pending';
+CREATE INDEX jobs_running_idx ON jobs (locked_until) WHERE status = 'running';
+CREATE INDEX jobs_created_at_idx ON jobs (created_at DESC, id DESC);
+CREATE INDEX jobs_type_idx ON jobs (type, created_at DESC);
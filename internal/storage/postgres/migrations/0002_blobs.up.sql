@@ -0,0 +1,8 @@
+CREATE TABLE blobs (
+    hash       TEXT COLLATE "C" PRIMARY KEY,
+    size       BIGINT NOT NULL,
+    ref_count  BIGINT NOT NULL DEFAULT 0,
+    deleting   BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,9 @@
+-- Token counts of the Bayesian spam models. The empty token holds the
+-- message counts of a model.
+CREATE TABLE spam_tokens (
+    owner TEXT COLLATE "C" NOT NULL,
+    token TEXT COLLATE "C" NOT NULL,
+    spam  BIGINT NOT NULL DEFAULT 0,
+    ham   BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (owner, token)
+);
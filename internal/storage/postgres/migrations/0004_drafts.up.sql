@@ -0,0 +1,2 @@
+-- Version and revision history of unsent emails, NULL once sent
+ALTER TABLE emails ADD COLUMN draft JSONB;
@@ -0,0 +1,4 @@
+ALTER TABLE emails
+    DROP COLUMN IF EXISTS reply_to,
+    DROP COLUMN IF EXISTS in_reply_to,
+    DROP COLUMN IF EXISTS message_references;
@@ -0,0 +1,5 @@
+-- Reply-To and the threading headers of replies
+ALTER TABLE emails
+    ADD COLUMN reply_to JSONB,
+    ADD COLUMN in_reply_to TEXT NOT NULL DEFAULT '',
+    ADD COLUMN message_references TEXT[];
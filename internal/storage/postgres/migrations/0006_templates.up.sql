@@ -0,0 +1,19 @@
+-- Email templates and signatures. Owner and department are empty for
+-- templates shared with everyone.
+CREATE TABLE templates (
+    id          TEXT COLLATE "C" PRIMARY KEY,
+    kind        TEXT NOT NULL,
+    name        TEXT COLLATE "C" NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    owner_id    TEXT NOT NULL DEFAULT '',
+    department  TEXT NOT NULL DEFAULT '',
+    subject     TEXT NOT NULL DEFAULT '',
+    text_body   TEXT NOT NULL DEFAULT '',
+    html_body   TEXT NOT NULL DEFAULT '',
+    created_by  TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX templates_name_idx ON templates (name, id);
+CREATE INDEX templates_scope_idx ON templates (kind, owner_id, department);
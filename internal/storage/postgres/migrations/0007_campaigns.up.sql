@@ -0,0 +1,42 @@
+-- Bulk campaigns and their recipients. A campaign lists an address once.
+CREATE TABLE campaigns (
+    id           TEXT COLLATE "C" PRIMARY KEY,
+    name         TEXT NOT NULL,
+    template_id  TEXT NOT NULL,
+    subject      TEXT NOT NULL DEFAULT '',
+    signature    BOOLEAN NOT NULL DEFAULT FALSE,
+    variables    JSONB,
+    rate         INTEGER NOT NULL,
+    status       TEXT NOT NULL,
+    created_by   TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    updated_at   TIMESTAMPTZ NOT NULL,
+    started_at   TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX campaigns_created_idx ON campaigns (created_at DESC, id DESC);
+CREATE INDEX campaigns_created_by_idx ON campaigns (created_by, created_at DESC);
+
+CREATE TABLE campaign_recipients (
+    id          TEXT COLLATE "C" PRIMARY KEY,
+    campaign_id TEXT NOT NULL REFERENCES campaigns (id) ON DELETE CASCADE,
+    email       TEXT NOT NULL,
+    name        TEXT NOT NULL DEFAULT '',
+    variables   JSONB,
+    status      TEXT NOT NULL,
+    token       TEXT NOT NULL,
+    email_id    TEXT NOT NULL DEFAULT '',
+    message_id  TEXT NOT NULL DEFAULT '',
+    error       TEXT NOT NULL DEFAULT '',
+    attempts    INTEGER NOT NULL DEFAULT 0,
+    sent_at     TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL,
+    UNIQUE (campaign_id, email)
+);
+
+CREATE INDEX campaign_recipients_status_idx ON campaign_recipients (campaign_id, status, id);
+CREATE INDEX campaign_recipients_email_idx ON campaign_recipients (email, status);
+CREATE INDEX campaign_recipients_token_idx ON campaign_recipients (token);
+CREATE INDEX campaign_recipients_message_idx ON campaign_recipients (message_id) WHERE message_id <> '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS suppressions;
+ALTER TABLE emails DROP COLUMN IF EXISTS delivery;
@@ -0,0 +1,14 @@
+-- Delivery state of sent emails by recipient, and the addresses no email is
+-- sent to
+ALTER TABLE emails ADD COLUMN delivery JSONB;
+
+CREATE TABLE suppressions (
+    email      TEXT COLLATE "C" PRIMARY KEY,
+    reason     TEXT NOT NULL,
+    diagnostic TEXT NOT NULL DEFAULT '',
+    email_id   TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX suppressions_reason_idx ON suppressions (reason, email);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS labels;
+ALTER TABLE emails DROP COLUMN IF EXISTS mailbox;
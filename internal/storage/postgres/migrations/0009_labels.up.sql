@@ -0,0 +1,17 @@
+-- Mailbox labels of staff members with the counts of the emails carrying
+-- them, kept in the transactions that change the emails
+ALTER TABLE emails ADD COLUMN mailbox TEXT COLLATE "C" NOT NULL DEFAULT '';
+
+CREATE INDEX emails_mailbox_idx ON emails (mailbox, created_at DESC, id DESC);
+
+CREATE TABLE labels (
+    id         TEXT COLLATE "C" PRIMARY KEY,
+    owner      TEXT COLLATE "C" NOT NULL,
+    name       TEXT COLLATE "C" NOT NULL,
+    color      TEXT NOT NULL DEFAULT '',
+    total      BIGINT NOT NULL DEFAULT 0,
+    unread     BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (owner, name)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS emails_message_id_idx;
+DROP INDEX IF EXISTS emails_mailbox_message_id_idx;
+ALTER TABLE emails ADD CONSTRAINT emails_message_id_key UNIQUE (message_id);
@@ -0,0 +1,6 @@
+-- Every recipient's copy of a message has its message ID, which is unique
+-- within a mailbox only
+ALTER TABLE emails DROP CONSTRAINT IF EXISTS emails_message_id_key;
+
+CREATE UNIQUE INDEX emails_mailbox_message_id_idx ON emails (mailbox, message_id);
+CREATE INDEX emails_message_id_idx ON emails (message_id);
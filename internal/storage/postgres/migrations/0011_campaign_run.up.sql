@@ -0,0 +1,2 @@
+-- The number of times a campaign was started, which its batch jobs carry
+ALTER TABLE campaigns ADD COLUMN run INTEGER NOT NULL DEFAULT 0;
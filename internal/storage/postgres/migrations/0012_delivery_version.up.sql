@@ -0,0 +1,3 @@
+-- The version of the delivery state, which delivery updates are
+-- conditional on
+ALTER TABLE emails ADD COLUMN delivery_version BIGINT NOT NULL DEFAULT 0;
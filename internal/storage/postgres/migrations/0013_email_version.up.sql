@@ -0,0 +1,3 @@
+-- The version of the rest of the email, which ReplaceVersion is
+-- conditional on and every write advances
+ALTER TABLE emails ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
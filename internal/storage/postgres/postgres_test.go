@@ -0,0 +1,59 @@
+package postgres_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/bezata/blockchainml-email/internal/config"
+	"github.com/bezata/blockchainml-email/internal/migrations"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/bezata/blockchainml-email/internal/storage/postgres"
+	"github.com/bezata/blockchainml-email/internal/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+// TestConformance runs against the database of POSTGRES_TEST_DSN. The
+// migrations are applied once and every table but schema_migrations is
+// emptied before each subtest, so the database must be dedicated to tests.
+func TestConformance(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	ctx := context.Background()
+	db, err := postgres.Connect(ctx, config.PostgresConfig{DSN: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := postgres.NewMigrator(db, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx, migrations.RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := metrics.NewMetrics("storagetest")
+	storagetest.Run(t, func(t *testing.T) *storage.Repositories {
+		_, err := db.ExecContext(ctx, `DO $$
+			DECLARE tables TEXT;
+			BEGIN
+				SELECT string_agg(quote_ident(table_name), ', ') INTO tables
+				FROM information_schema.tables
+				WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+				AND table_name <> 'schema_migrations';
+				IF tables IS NOT NULL THEN
+					EXECUTE 'TRUNCATE ' || tables;
+				END IF;
+			END $$`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return postgres.NewRepository(db, zap.NewNop(), m).Repositories()
+	})
+}
@@ -0,0 +1,157 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+type Repository struct {
+	db           *sql.DB
+	logger       *zap.Logger
+	metrics      *metrics.Metrics
+	email        *EmailRepository
+	staff        *StaffRepository
+	thread       *ThreadRepository
+	audit        *AuditRepository
+	jobs         *JobRepository
+	blobs        *BlobRepository
+	spam         *SpamRepository
+	templates    *TemplateRepository
+	campaigns    *CampaignRepository
+	suppressions *SuppressionRepository
+	labels       *LabelRepository
+}
+
+func NewRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *Repository {
+	return &Repository{
+		db:           db,
+		logger:       logger,
+		metrics:      metrics,
+		email:        NewEmailRepository(db, logger, metrics),
+		staff:        NewStaffRepository(db, logger, metrics),
+		thread:       NewThreadRepository(db, logger, metrics),
+		audit:        NewAuditRepository(db, logger, metrics),
+		jobs:         NewJobRepository(db, logger, metrics),
+		blobs:        NewBlobRepository(db, logger, metrics),
+		spam:         NewSpamRepository(db, logger, metrics),
+		templates:    NewTemplateRepository(db, logger, metrics),
+		campaigns:    NewCampaignRepository(db, logger, metrics),
+		suppressions: NewSuppressionRepository(db, logger, metrics),
+		labels:       NewLabelRepository(db, logger, metrics),
+	}
+}
+
+// Repositories returns the repositories in the form the services expect
+func (r *Repository) Repositories() *storage.Repositories {
+	return &storage.Repositories{
+		Email:        r.email,
+		Staff:        r.staff,
+		Thread:       r.thread,
+		Audit:        r.audit,
+		Jobs:         r.jobs,
+		Blobs:        r.blobs,
+		Spam:         r.spam,
+		Templates:    r.templates,
+		Campaigns:    r.campaigns,
+		Suppressions: r.suppressions,
+		Labels:       r.labels,
+	}
+}
+
+// querier is implemented by *sql.DB, *sql.Conn and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// jsonb stores a nested domain value in a JSONB column. It encodes v as a
+// query argument and decodes into v, which must then be a pointer, when
+// scanned. NULL leaves v unchanged.
+type jsonb struct {
+	v any
+}
+
+func (j jsonb) Value() (driver.Value, error) {
+	data, err := json.Marshal(j.v)
+	if err != nil {
+		return nil, err
+	}
+	// lib/pq sends []byte as bytea, which JSONB does not accept
+	return string(data), nil
+}
+
+func (j jsonb) Scan(src any) error {
+	switch data := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(data, j.v)
+	case string:
+		return json.Unmarshal([]byte(data), j.v)
+	}
+	return fmt.Errorf("cannot scan %T into JSONB", src)
+}
+
+// conditions collects the WHERE clause of a query with numbered arguments
+type conditions struct {
+	clauses []string
+	args    []any
+}
+
+// arg adds an argument and returns its placeholder
+func (c *conditions) arg(v any) string {
+	c.args = append(c.args, v)
+	return "$" + strconv.Itoa(len(c.args))
+}
+
+func (c *conditions) add(clause string) {
+	c.clauses = append(c.clauses, clause)
+}
+
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// likePattern matches s anywhere in a LIKE or ILIKE operand
+func likePattern(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(s) + "%"
+}
+
+// rowsAffected maps an update or delete that matched nothing to ErrNotFound
+func rowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
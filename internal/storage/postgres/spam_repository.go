@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// messagesToken holds the message counts of a model. Real tokens are never
+// empty.
+const messagesToken = ""
+
+type SpamRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewSpamRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *SpamRepository {
+	return &SpamRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+// Train upserts every token in one statement. ON CONFLICT locks each row,
+// so concurrent training never loses counts.
+func (r *SpamRepository) Train(ctx context.Context, owner string, isSpam bool, tokens []string, delta int64) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("train_spam").Observe(time.Since(startTime).Seconds())
+	}()
+
+	var spamDelta, hamDelta int64
+	if isSpam {
+		spamDelta = delta
+	} else {
+		hamDelta = delta
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO spam_tokens (owner, token, spam, ham)
+		SELECT $1, token, $3, $4 FROM unnest($2::text[]) AS token
+		ON CONFLICT (owner, token) DO UPDATE
+		SET spam = spam_tokens.spam + EXCLUDED.spam, ham = spam_tokens.ham + EXCLUDED.ham`,
+		owner, pq.Array(append([]string{messagesToken}, tokens...)), spamDelta, hamDelta)
+	if err != nil {
+		r.logger.Error("failed to train spam model", zap.String("owner", owner), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *SpamRepository) Model(ctx context.Context, owner string, tokens []string) (*spam.Model, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_spam_model").Observe(time.Since(startTime).Seconds())
+	}()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT token, spam, ham FROM spam_tokens
+		WHERE owner = $1 AND token = ANY($2::text[])`,
+		owner, pq.Array(append([]string{messagesToken}, tokens...)))
+	if err != nil {
+		r.logger.Error("failed to get spam model", zap.String("owner", owner), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	model := &spam.Model{Tokens: make(map[string]spam.Counts, len(tokens))}
+	for rows.Next() {
+		var token string
+		var counts spam.Counts
+		if err := rows.Scan(&token, &counts.Spam, &counts.Ham); err != nil {
+			r.logger.Error("failed to scan spam token", zap.Error(err))
+			return nil, err
+		}
+		if token == messagesToken {
+			model.Messages = counts
+		} else {
+			model.Tokens[token] = counts
+		}
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to get spam model", zap.String("owner", owner), zap.Error(err))
+		return nil, err
+	}
+
+	return model, nil
+}
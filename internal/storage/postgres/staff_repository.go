@@ -0,0 +1,240 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const staffColumns = `id, email, full_name, role, department, title, manager_id, profile_photo,
+	status, mailbox, offboarding, last_active, created_at, updated_at`
+
+type StaffRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewStaffRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *StaffRepository {
+	return &StaffRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *StaffRepository) Create(ctx context.Context, s *staff.Staff) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO staff (`+staffColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		staffArgs(s)...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create staff", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *StaffRepository) Get(ctx context.Context, id string) (*staff.Staff, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	return r.findOne(ctx, "id", id)
+}
+
+func (r *StaffRepository) GetByEmail(ctx context.Context, address string) (*staff.Staff, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_staff_by_email").Observe(time.Since(startTime).Seconds())
+	}()
+
+	return r.findOne(ctx, "email", strings.ToLower(address))
+}
+
+func (r *StaffRepository) findOne(ctx context.Context, column, value string) (*staff.Staff, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+staffColumns+` FROM staff WHERE `+column+` = $1`, value)
+	result, err := scanStaff(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get staff", zap.String(column, value), zap.Error(err))
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *StaffRepository) Update(ctx context.Context, s *staff.Staff) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE staff SET
+		email = $2, full_name = $3, role = $4, department = $5, title = $6, manager_id = $7,
+		profile_photo = $8, status = $9, mailbox = $10, offboarding = $11, last_active = $12,
+		created_at = $13, updated_at = $14
+		WHERE id = $1`,
+		staffArgs(s)...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to update staff", zap.String("id", s.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+func (r *StaffRepository) Delete(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM staff WHERE id = $1`, id)
+	if err != nil {
+		r.logger.Error("failed to delete staff", zap.String("id", id), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+// List returns one page of the directory ordered by full name
+func (r *StaffRepository) List(ctx context.Context, query *staff.ListQuery) ([]*staff.Staff, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_staff").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &staff.ListQuery{}
+	}
+
+	conds := &conditions{}
+	if search := strings.TrimSpace(query.Search); search != "" {
+		pattern := conds.arg(likePattern(search))
+		conds.add("(full_name ILIKE " + pattern + " OR email ILIKE " + pattern + ")")
+	}
+	if query.Department != "" {
+		conds.add("department = " + conds.arg(query.Department))
+	}
+	if query.Role != "" {
+		conds.add("role = " + conds.arg(query.Role))
+	}
+	if query.Status != "" {
+		conds.add("status = " + conds.arg(query.Status))
+	}
+	if query.ManagerID != "" {
+		conds.add("manager_id = " + conds.arg(query.ManagerID))
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		conds.add("(full_name, id) > (" + conds.arg(after.Key) + ", " + conds.arg(after.ID) + ")")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+staffColumns+` FROM staff`+conds.where()+
+		` ORDER BY full_name, id LIMIT `+conds.arg(storage.PageSize(query.Limit)),
+		conds.args...)
+	if err != nil {
+		r.logger.Error("failed to list staff", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*staff.Staff{}
+	for rows.Next() {
+		s, err := scanStaff(rows)
+		if err != nil {
+			r.logger.Error("failed to decode staff", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, s)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list staff", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func staffArgs(s *staff.Staff) []any {
+	var offboarding any
+	if s.Offboarding != nil {
+		offboarding = jsonb{s.Offboarding}
+	}
+	return []any{
+		s.ID.Hex(),
+		s.Email,
+		s.FullName,
+		s.Role,
+		s.Department,
+		s.Title,
+		s.ManagerID,
+		jsonb{s.ProfilePhoto},
+		s.Status,
+		jsonb{s.Mailbox},
+		offboarding,
+		s.LastActive,
+		s.CreatedAt,
+		s.UpdatedAt,
+	}
+}
+
+func scanStaff(row scanner) (*staff.Staff, error) {
+	var s staff.Staff
+	var id string
+	err := row.Scan(
+		&id,
+		&s.Email,
+		&s.FullName,
+		&s.Role,
+		&s.Department,
+		&s.Title,
+		&s.ManagerID,
+		jsonb{&s.ProfilePhoto},
+		&s.Status,
+		jsonb{&s.Mailbox},
+		jsonb{&s.Offboarding},
+		&s.LastActive,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/suppression"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const suppressionColumns = `email, reason, diagnostic, email_id, created_by, created_at`
+
+type SuppressionRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewSuppressionRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *SuppressionRepository {
+	return &SuppressionRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *SuppressionRepository) Add(ctx context.Context, s *suppression.Suppression) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("add_suppression").Observe(time.Since(startTime).Seconds())
+	}()
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO suppressions (`+suppressionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		s.Email, s.Reason, s.Diagnostic, s.EmailID, s.CreatedBy, s.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to add suppression", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *SuppressionRepository) Get(ctx context.Context, address string) (*suppression.Suppression, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_suppression").Observe(time.Since(startTime).Seconds())
+	}()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+suppressionColumns+` FROM suppressions WHERE email = $1`, address)
+	result, err := scanSuppression(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get suppression", zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *SuppressionRepository) Delete(ctx context.Context, address string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_suppression").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM suppressions WHERE email = $1`, address)
+	if err != nil {
+		r.logger.Error("failed to delete suppression", zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+// List returns one page of suppressions ordered by address
+func (r *SuppressionRepository) List(ctx context.Context, query *suppression.ListQuery) ([]*suppression.Suppression, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_suppressions").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &suppression.ListQuery{}
+	}
+
+	conds := &conditions{}
+	if query.Reason != "" {
+		conds.add("reason = " + conds.arg(query.Reason))
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		conds.add("email > " + conds.arg(after.ID))
+	}
+
+	return r.query(ctx, `SELECT `+suppressionColumns+` FROM suppressions`+conds.where()+
+		` ORDER BY email LIMIT `+conds.arg(storage.PageSize(query.Limit)), conds.args...)
+}
+
+func (r *SuppressionRepository) Find(ctx context.Context, addresses []string) ([]*suppression.Suppression, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("find_suppressions").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if len(addresses) == 0 {
+		return []*suppression.Suppression{}, nil
+	}
+	return r.query(ctx, `SELECT `+suppressionColumns+` FROM suppressions WHERE email = ANY($1::text[]) ORDER BY email`,
+		pq.Array(addresses))
+}
+
+func (r *SuppressionRepository) query(ctx context.Context, sqlQuery string, args ...any) ([]*suppression.Suppression, error) {
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		r.logger.Error("failed to list suppressions", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*suppression.Suppression{}
+	for rows.Next() {
+		s, err := scanSuppression(rows)
+		if err != nil {
+			r.logger.Error("failed to decode suppression", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, s)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list suppressions", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func scanSuppression(row scanner) (*suppression.Suppression, error) {
+	var s suppression.Suppression
+	err := row.Scan(&s.Email, &s.Reason, &s.Diagnostic, &s.EmailID, &s.CreatedBy, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,206 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const templateColumns = `id, kind, name, description, owner_id, department, subject, text_body, html_body,
+	created_by, created_at, updated_at`
+
+type TemplateRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewTemplateRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *TemplateRepository {
+	return &TemplateRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *TemplateRepository) Create(ctx context.Context, t *template.Template) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_template").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO templates (`+templateColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		templateArgs(t)...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create template", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *TemplateRepository) Get(ctx context.Context, id string) (*template.Template, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_template").Observe(time.Since(startTime).Seconds())
+	}()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+templateColumns+` FROM templates WHERE id = $1`, id)
+	result, err := scanTemplate(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get template", zap.String("id", id), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *TemplateRepository) Update(ctx context.Context, t *template.Template) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_template").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE templates SET
+		kind = $2, name = $3, description = $4, owner_id = $5, department = $6, subject = $7,
+		text_body = $8, html_body = $9, created_by = $10, created_at = $11, updated_at = $12
+		WHERE id = $1`,
+		templateArgs(t)...)
+	if err != nil {
+		r.logger.Error("failed to update template", zap.String("id", t.ID.Hex()), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+func (r *TemplateRepository) Delete(ctx context.Context, id string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_template").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM templates WHERE id = $1`, id)
+	if err != nil {
+		r.logger.Error("failed to delete template", zap.String("id", id), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+// List returns one page of templates ordered by name
+func (r *TemplateRepository) List(ctx context.Context, query *template.ListQuery) ([]*template.Template, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_templates").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &template.ListQuery{}
+	}
+
+	conds := &conditions{}
+	if query.Kind != "" {
+		conds.add("kind = " + conds.arg(query.Kind))
+	}
+	if scope := query.VisibleTo; scope != nil {
+		conds.add("(owner_id = " + conds.arg(scope.OwnerID) +
+			" OR (owner_id = '' AND department IN ('', " + conds.arg(scope.Department) + ")))")
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		conds.add("(name, id) > (" + conds.arg(after.Key) + ", " + conds.arg(after.ID) + ")")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+templateColumns+` FROM templates`+conds.where()+
+		` ORDER BY name, id LIMIT `+conds.arg(storage.PageSize(query.Limit)),
+		conds.args...)
+	if err != nil {
+		r.logger.Error("failed to list templates", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*template.Template{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			r.logger.Error("failed to decode template", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, t)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list templates", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func templateArgs(t *template.Template) []any {
+	return []any{
+		t.ID.Hex(),
+		t.Kind,
+		t.Name,
+		t.Description,
+		t.OwnerID,
+		t.Department,
+		t.Subject,
+		t.Text,
+		t.HTML,
+		t.CreatedBy,
+		t.CreatedAt,
+		t.UpdatedAt,
+	}
+}
+
+func scanTemplate(row scanner) (*template.Template, error) {
+	var t template.Template
+	var id string
+	err := row.Scan(
+		&id,
+		&t.Kind,
+		&t.Name,
+		&t.Description,
+		&t.OwnerID,
+		&t.Department,
+		&t.Subject,
+		&t.Text,
+		&t.HTML,
+		&t.CreatedBy,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
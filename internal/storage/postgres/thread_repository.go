@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/thread"
+	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const threadColumns = `thread_id, id, subject, participants, last_message, message_count, created_at, updated_at,
+	last_message_at`
+
+// ThreadRepository stores threads keyed by their ThreadID. The time of the
+// last message is also kept in its own column for ordering, and that column
+// is what LastMessage.SentAt is read from so cursors match it exactly.
+type ThreadRepository struct {
+	db      *sql.DB
+	logger  *zap.Logger
+	metrics *metrics.Metrics
+}
+
+func NewThreadRepository(db *sql.DB, logger *zap.Logger, metrics *metrics.Metrics) *ThreadRepository {
+	return &ThreadRepository{
+		db:      db,
+		logger:  logger,
+		metrics: metrics,
+	}
+}
+
+func (r *ThreadRepository) Create(ctx context.Context, t *thread.Thread) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("create_thread").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.db.ExecContext(ctx, `INSERT INTO threads (`+threadColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		threadArgs(t)...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrDuplicate
+		}
+		r.logger.Error("failed to create thread", zap.String("thread_id", t.ThreadID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (r *ThreadRepository) Get(ctx context.Context, threadID string) (*thread.Thread, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("get_thread").Observe(time.Since(startTime).Seconds())
+	}()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+threadColumns+` FROM threads WHERE thread_id = $1`, threadID)
+	result, err := scanThread(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		r.logger.Error("failed to get thread", zap.String("thread_id", threadID), zap.Error(err))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *ThreadRepository) Update(ctx context.Context, t *thread.Thread) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("update_thread").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE threads SET
+		id = $2, subject = $3, participants = $4, last_message = $5, message_count = $6,
+		created_at = $7, updated_at = $8, last_message_at = $9
+		WHERE thread_id = $1`,
+		threadArgs(t)...)
+	if err != nil {
+		r.logger.Error("failed to update thread", zap.String("thread_id", t.ThreadID), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+func (r *ThreadRepository) Delete(ctx context.Context, threadID string) error {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("delete_thread").Observe(time.Since(startTime).Seconds())
+	}()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM threads WHERE thread_id = $1`, threadID)
+	if err != nil {
+		r.logger.Error("failed to delete thread", zap.String("thread_id", threadID), zap.Error(err))
+		return err
+	}
+
+	return rowsAffected(result)
+}
+
+// List returns one page of threads by most recent message first
+func (r *ThreadRepository) List(ctx context.Context, query *thread.ListQuery) ([]*thread.Thread, error) {
+	startTime := time.Now()
+	defer func() {
+		r.metrics.DatabaseLatency.WithLabelValues("list_threads").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if query == nil {
+		query = &thread.ListQuery{}
+	}
+
+	conds := &conditions{}
+	if query.Participant != "" {
+		conds.add("participants @> jsonb_build_array(jsonb_build_object('email', " + conds.arg(query.Participant) + "::text))")
+	}
+
+	after, err := storage.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		t, err := after.Time()
+		if err != nil {
+			return nil, err
+		}
+		conds.add("(last_message_at, thread_id) < (" + conds.arg(t) + ", " + conds.arg(after.ID) + ")")
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+threadColumns+` FROM threads`+conds.where()+
+		` ORDER BY last_message_at DESC, thread_id DESC LIMIT `+conds.arg(storage.PageSize(query.Limit)),
+		conds.args...)
+	if err != nil {
+		r.logger.Error("failed to list threads", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*thread.Thread{}
+	for rows.Next() {
+		t, err := scanThread(rows)
+		if err != nil {
+			r.logger.Error("failed to decode threads", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, t)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger.Error("failed to list threads", zap.Error(err))
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func threadArgs(t *thread.Thread) []any {
+	return []any{
+		t.ThreadID,
+		t.ID.Hex(),
+		t.Subject,
+		jsonb{t.Participants},
+		jsonb{t.LastMessage},
+		t.MessageCount,
+		t.CreatedAt,
+		t.UpdatedAt,
+		t.LastMessage.SentAt,
+	}
+}
+
+func scanThread(row scanner) (*thread.Thread, error) {
+	var t thread.Thread
+	var id string
+	err := row.Scan(
+		&t.ThreadID,
+		&id,
+		&t.Subject,
+		jsonb{&t.Participants},
+		jsonb{&t.LastMessage},
+		&t.MessageCount,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.LastMessage.SentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
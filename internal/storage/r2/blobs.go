@@ -0,0 +1,133 @@
+package r2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// BlobPrefix is where content-addressed attachment objects are stored
+	BlobPrefix = "blobs/"
+	// QuarantinePrefix is where infected attachments are moved to
+	QuarantinePrefix = "quarantine/"
+
+	acquireAttempts = 5
+	acquireBackoff  = 100 * time.Millisecond
+)
+
+// BlobKey returns the key of the blob whose content has the given hex
+// encoded SHA-256
+func BlobKey(sum string) string {
+	return fmt.Sprintf("%ssha256/%s/%s", BlobPrefix, sum[:2], sum)
+}
+
+// commitBlob adds a reference to the blob sum and makes sure its object
+// exists, copying it from src when the content is new. src is deleted
+// either way.
+func (s *Storage) commitBlob(ctx context.Context, src, sum string, size int64) (string, error) {
+	defer func() {
+		if err := s.client.Delete(context.WithoutCancel(ctx), src); err != nil {
+			s.logger.Warn("failed to delete attachment source", zap.String("key", src), zap.Error(err))
+		}
+	}()
+
+	if err := s.acquire(ctx, sum, size); err != nil {
+		return "", fmt.Errorf("failed to reference attachment blob: %w", err)
+	}
+
+	key := BlobKey(sum)
+	exists, err := s.client.Exists(ctx, key)
+	if err == nil && !exists {
+		err = s.client.Copy(ctx, src, key)
+	}
+	if err != nil {
+		if releaseErr := s.release(context.WithoutCancel(ctx), sum); releaseErr != nil {
+			s.logger.Error("failed to release attachment blob", zap.String("sha256", sum), zap.Error(releaseErr))
+		}
+		return "", err
+	}
+
+	return key, nil
+}
+
+// acquire retries while the blob is being collected, which takes no longer
+// than deleting one object
+func (s *Storage) acquire(ctx context.Context, sum string, size int64) error {
+	for attempt := 1; ; attempt++ {
+		_, err := s.blobs.Acquire(ctx, sum, size)
+		if !errors.Is(err, storage.ErrBlobDeleting) || attempt == acquireAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * acquireBackoff):
+		}
+	}
+}
+
+// release drops a reference and collects the blob once nothing references
+// it any more
+func (s *Storage) release(ctx context.Context, sum string) error {
+	b, err := s.blobs.Release(ctx, sum)
+	if err != nil {
+		return err
+	}
+	if b.RefCount > 0 {
+		return nil
+	}
+
+	return s.collect(ctx, sum)
+}
+
+// collect deletes an unreferenced blob. The record is marked first so that
+// a concurrent Acquire of the same content waits for the object to be gone
+// and then stores it again, instead of referencing an object about to be
+// deleted.
+func (s *Storage) collect(ctx context.Context, sum string) error {
+	if err := s.blobs.MarkDeleting(ctx, sum); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// Referenced again, or already being collected elsewhere
+			return nil
+		}
+		return err
+	}
+
+	if err := s.client.Delete(ctx, BlobKey(sum)); err != nil {
+		return err
+	}
+	return s.blobs.Delete(ctx, sum)
+}
+
+// hashingReader computes the SHA-256 and size of what is read through it
+type hashingReader struct {
+	r    io.Reader
+	hash hash.Hash
+	n    int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, hash: sha256.New()}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+// Sum returns the hex encoded SHA-256 of the bytes read so far
+func (h *hashingReader) Sum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
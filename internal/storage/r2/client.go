@@ -3,10 +3,16 @@ package r2
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"go.uber.org/zap"
@@ -33,10 +39,18 @@ func NewClient(cfg Config, logger *zap.Logger) (*Client, error) {
 		"",
 	))
 
+	// Endpoint overrides the account's R2 endpoint, for S3-compatible
+	// stores that only serve path-style requests
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cfg.AccountID)
+	}
+
 	client := s3.New(s3.Options{
-		Credentials: creds,
-		Region:      "auto",
-		BaseEndpoint: aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cfg.AccountID)),
+		Credentials:  creds,
+		Region:       "auto",
+		BaseEndpoint: aws.String(endpoint),
+		UsePathStyle: cfg.Endpoint != "",
 	})
 
 	return &Client{
@@ -46,13 +60,29 @@ func NewClient(cfg Config, logger *zap.Logger) (*Client, error) {
 	}, nil
 }
 
-// Upload uploads data to R2
-func (c *Client) Upload(ctx context.Context, key string, data []byte, contentType string) error {
+// Upload streams body to R2. Objects smaller than one part are stored with a
+// single PUT, larger ones with a multipart upload, so at most PartSize bytes
+// are held in memory whatever the object size.
+func (c *Client) Upload(ctx context.Context, key string, body io.Reader, contentType string) error {
+	buf := make([]byte, PartSize)
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return c.put(ctx, key, buf[:n], contentType)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload body: %w", err)
+	}
+
+	return c.uploadMultipart(ctx, key, body, buf, contentType)
+}
+
+func (c *Client) put(ctx context.Context, key string, data []byte, contentType string) error {
 	input := &s3.PutObjectInput{
-		Bucket:      aws.String(c.bucketName),
-		Key:         aws.String(key),
-		Body:        io.NopCloser(bytes.NewReader(data)),
-		ContentType: aws.String(contentType),
+		Bucket:        aws.String(c.bucketName),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String(contentType),
 	}
 
 	_, err := c.client.PutObject(ctx, input)
@@ -87,6 +117,26 @@ func (c *Client) Download(ctx context.Context, key string) ([]byte, error) {
 	return io.ReadAll(result.Body)
 }
 
+// Open streams an object from R2. The caller closes the returned body.
+func (c *Client) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	result, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		c.logger.Error("failed to download from R2",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("failed to download from R2: %w", err)
+	}
+
+	return result.Body, nil
+}
+
 // Delete deletes an object from R2
 func (c *Client) Delete(ctx context.Context, key string) error {
 	input := &s3.DeleteObjectInput{
@@ -132,3 +182,149 @@ func (c *Client) ListObjects(ctx context.Context, prefix string) ([]string, erro
 
 	return keys, nil
 }
+
+// Copy copies an object to a new key within the bucket
+func (c *Client) Copy(ctx context.Context, srcKey, dstKey string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(c.bucketName),
+		CopySource: aws.String((&url.URL{Path: c.bucketName + "/" + srcKey}).EscapedPath()),
+		Key:        aws.String(dstKey),
+	}
+
+	_, err := c.client.CopyObject(ctx, input)
+	if err != nil {
+		c.logger.Error("failed to copy R2 object",
+			zap.String("src", srcKey),
+			zap.String("dst", dstKey),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to copy R2 object: %w", err)
+	}
+
+	return nil
+}
+
+// Exists reports whether an object exists in R2
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	}
+
+	_, err := c.client.HeadObject(ctx, input)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		c.logger.Error("failed to stat R2 object",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return false, fmt.Errorf("failed to stat R2 object: %w", err)
+	}
+
+	return true, nil
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+}
+
+// Stat returns the size and content type of an object, or ErrNotFound
+func (c *Client) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	result, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		c.logger.Error("failed to stat R2 object",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("failed to stat R2 object: %w", err)
+	}
+
+	return &ObjectInfo{
+		Size:        aws.ToInt64(result.ContentLength),
+		ContentType: aws.ToString(result.ContentType),
+	}, nil
+}
+
+// PresignGet returns a URL that allows downloading an object until it expires
+func (c *Client) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	presigner := s3.NewPresignClient(c.client)
+
+	req, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		c.logger.Error("failed to presign R2 download",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return "", fmt.Errorf("failed to presign R2 download: %w", err)
+	}
+
+	return req.URL, nil
+}
+
+// PresignDownload returns a URL that downloads an object as an attachment
+// named filename until it expires
+func (c *Client) PresignDownload(ctx context.Context, key, filename, contentType string, expires time.Duration) (string, error) {
+	presigner := s3.NewPresignClient(c.client)
+
+	input := &s3.GetObjectInput{
+		Bucket:                     aws.String(c.bucketName),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(mime.FormatMediaType("attachment", map[string]string{"filename": filename})),
+	}
+	if contentType != "" {
+		input.ResponseContentType = aws.String(contentType)
+	}
+
+	req, err := presigner.PresignGetObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		c.logger.Error("failed to presign R2 download",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return "", fmt.Errorf("failed to presign R2 download: %w", err)
+	}
+
+	return req.URL, nil
+}
+
+// PresignPut returns a URL that accepts one PUT of the object until it
+// expires. Content-Type and Content-Length are part of the signature, so the
+// upload must match contentType and size exactly.
+func (c *Client) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (string, error) {
+	presigner := s3.NewPresignClient(c.client)
+
+	req, err := presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(c.bucketName),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		c.logger.Error("failed to presign R2 upload",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return "", fmt.Errorf("failed to presign R2 upload: %w", err)
+	}
+
+	return req.URL, nil
+}
+
+// isNotFound reports whether err is a 404 response
+func isNotFound(err error) bool {
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
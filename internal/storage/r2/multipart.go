@@ -0,0 +1,221 @@
+package r2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// PartSize is the size of every multipart upload part but the last. It
+	// must be at least the 5 MiB minimum R2 accepts.
+	PartSize = 8 << 20
+
+	// MaxParts is the most parts a multipart upload may have
+	MaxParts = 10000
+)
+
+// ErrNotFound is returned when an object or multipart upload does not exist
+var ErrNotFound = errors.New("r2: not found")
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number int32
+	ETag   string
+	Size   int64
+}
+
+// uploadMultipart sends first and the rest of body as a multipart upload,
+// reusing first as the buffer for every following part. The upload is
+// aborted if any part fails so R2 does not keep the orphaned parts.
+func (c *Client) uploadMultipart(ctx context.Context, key string, body io.Reader, first []byte, contentType string) error {
+	uploadID, err := c.CreateMultipartUpload(ctx, key, contentType)
+	if err != nil {
+		return err
+	}
+
+	parts, err := c.uploadParts(ctx, key, uploadID, body, first)
+	if err == nil {
+		err = c.CompleteMultipartUpload(ctx, key, uploadID, parts)
+	}
+	if err != nil {
+		if abortErr := c.AbortMultipartUpload(context.WithoutCancel(ctx), key, uploadID); abortErr != nil {
+			c.logger.Warn("failed to abort multipart upload",
+				zap.String("key", key),
+				zap.Error(abortErr),
+			)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) uploadParts(ctx context.Context, key, uploadID string, body io.Reader, buf []byte) ([]Part, error) {
+	var parts []Part
+	n := len(buf)
+	for number := int32(1); ; number++ {
+		if number > MaxParts {
+			return nil, fmt.Errorf("failed to upload to R2: object exceeds %d parts", MaxParts)
+		}
+
+		part, err := c.UploadPart(ctx, key, uploadID, number, buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, *part)
+
+		n, err = io.ReadFull(body, buf)
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read upload body: %w", err)
+		}
+	}
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its ID
+func (c *Client) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	result, err := c.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(c.bucketName),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		c.logger.Error("failed to create multipart upload",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	return aws.ToString(result.UploadId), nil
+}
+
+// UploadPart stores one part of a multipart upload. Uploading the same part
+// number again replaces the earlier part.
+func (c *Client) UploadPart(ctx context.Context, key, uploadID string, number int32, data []byte) (*Part, error) {
+	result, err := c.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(c.bucketName),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(number),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		if isNoSuchUpload(err) {
+			return nil, ErrNotFound
+		}
+		c.logger.Error("failed to upload part",
+			zap.String("key", key),
+			zap.Int32("part", number),
+			zap.Error(err),
+		)
+		return nil, fmt.Errorf("failed to upload part: %w", err)
+	}
+
+	return &Part{
+		Number: number,
+		ETag:   aws.ToString(result.ETag),
+		Size:   int64(len(data)),
+	}, nil
+}
+
+// ListParts returns the parts uploaded so far, ordered by part number
+func (c *Client) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	var parts []Part
+	paginator := s3.NewListPartsPaginator(c.client, &s3.ListPartsInput{
+		Bucket:   aws.String(c.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			if isNoSuchUpload(err) {
+				return nil, ErrNotFound
+			}
+			c.logger.Error("failed to list parts",
+				zap.String("key", key),
+				zap.Error(err),
+			)
+			return nil, fmt.Errorf("failed to list parts: %w", err)
+		}
+
+		for _, p := range page.Parts {
+			parts = append(parts, Part{
+				Number: aws.ToInt32(p.PartNumber),
+				ETag:   aws.ToString(p.ETag),
+				Size:   aws.ToInt64(p.Size),
+			})
+		}
+	}
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles parts into the final object
+func (c *Client) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	completed := make([]types.CompletedPart, len(parts))
+	for i, p := range parts {
+		completed[i] = types.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int32(p.Number),
+		}
+	}
+
+	_, err := c.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(c.bucketName),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		if isNoSuchUpload(err) {
+			return ErrNotFound
+		}
+		c.logger.Error("failed to complete multipart upload",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (c *Client) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := c.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(c.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		if isNoSuchUpload(err) {
+			return ErrNotFound
+		}
+		c.logger.Error("failed to abort multipart upload",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+func isNoSuchUpload(err error) bool {
+	var noSuchUpload *types.NoSuchUpload
+	return errors.As(err, &noSuchUpload) || isNotFound(err)
+}
@@ -2,66 +2,186 @@ package r2
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
+	"github.com/bezata/blockchainml-email/internal/domain/blob"
 	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// Storage stores attachments content-addressed: each distinct content is
+// one object under BlobKey, shared by every attachment with that SHA-256 and
+// deleted when the last reference goes.
 type Storage struct {
     client *Client
+    blobs  storage.BlobRepository
     logger *zap.Logger
 }
 
-func NewStorage(client *Client, logger *zap.Logger) *Storage {
+func NewStorage(client *Client, blobs storage.BlobRepository, logger *zap.Logger) *Storage {
     return &Storage{
         client: client,
+        blobs:  blobs,
         logger: logger,
     }
 }
 
-// StoreAttachment stores an email attachment in R2
-func (s *Storage) StoreAttachment(ctx context.Context, emailID string, attachment email.AttachmentInput) (*email.Attachment, error) {
-    key := fmt.Sprintf("attachments/%s/%s", emailID, attachment.Filename)
-    
-    if err := s.client.Upload(ctx, key, attachment.Content, attachment.ContentType); err != nil {
+// StoreAttachment stores an email attachment in R2. The content is hashed
+// while it streams to a temporary key and then moved to its blob, unless
+// the same content is already stored.
+func (s *Storage) StoreAttachment(ctx context.Context, attachment email.AttachmentInput) (*email.Attachment, error) {
+    tmpKey := fmt.Sprintf("tmp/%s", uuid.New().String())
+
+    content := newHashingReader(attachment.Content)
+    if err := s.client.Upload(ctx, tmpKey, content, attachment.ContentType); err != nil {
+        return nil, err
+    }
+
+    sum := content.Sum()
+    key, err := s.commitBlob(ctx, tmpKey, sum, content.n)
+    if err != nil {
         return nil, err
     }
 
     return &email.Attachment{
         Filename:    attachment.Filename,
-        R2Key:      key,
+        R2Key:       key,
+        SHA256:      sum,
         ContentType: attachment.ContentType,
-        Size:       int64(len(attachment.Content)),
-        UploadedAt: time.Now(),
+        Size:        content.n,
+        UploadedAt:  time.Now(),
     }, nil
 }
 
-// GetAttachment retrieves an attachment from R2
-func (s *Storage) GetAttachment(ctx context.Context, key string) ([]byte, error) {
-    return s.client.Download(ctx, key)
+// StoreObject turns an object already in R2, such as a staged upload, into
+// an attachment named filename. The object is read once to hash it and is
+// removed afterwards.
+func (s *Storage) StoreObject(ctx context.Context, key, filename string) (*email.Attachment, error) {
+    info, err := s.client.Stat(ctx, key)
+    if err != nil {
+        return nil, err
+    }
+
+    body, err := s.client.Open(ctx, key)
+    if err != nil {
+        return nil, err
+    }
+    content := newHashingReader(body)
+    _, err = io.Copy(io.Discard, content)
+    body.Close()
+    if err != nil {
+        return nil, fmt.Errorf("failed to hash attachment: %w", err)
+    }
+
+    sum := content.Sum()
+    blobKey, err := s.commitBlob(ctx, key, sum, content.n)
+    if err != nil {
+        return nil, err
+    }
+
+    return &email.Attachment{
+        Filename:    filename,
+        R2Key:       blobKey,
+        SHA256:      sum,
+        ContentType: info.ContentType,
+        Size:        content.n,
+        UploadedAt:  time.Now(),
+    }, nil
 }
 
-// DeleteAttachments deletes all attachments for an email
-func (s *Storage) DeleteAttachments(ctx context.Context, emailID string) error {
-    prefix := fmt.Sprintf("attachments/%s/", emailID)
-    
-    keys, err := s.client.ListObjects(ctx, prefix)
+// CopyAttachment stores the content of an attachment of one email again
+// for another. Blobs only gain a reference; quarantined attachments and
+// ones stored before deduplication are copied to a key of their own.
+func (s *Storage) CopyAttachment(ctx context.Context, attachment email.Attachment) (*email.Attachment, error) {
+    if strings.HasPrefix(attachment.R2Key, BlobPrefix) {
+        if err := s.acquire(ctx, attachment.SHA256, attachment.Size); err != nil {
+            return nil, fmt.Errorf("failed to reference attachment blob: %w", err)
+        }
+        attachment.UploadedAt = time.Now()
+        return &attachment, nil
+    }
+
+    if strings.HasPrefix(attachment.R2Key, QuarantinePrefix) {
+        key := QuarantinePrefix + uuid.New().String()
+        if err := s.client.Copy(ctx, attachment.R2Key, key); err != nil {
+            return nil, err
+        }
+        attachment.R2Key = key
+        attachment.UploadedAt = time.Now()
+        return &attachment, nil
+    }
+
+    tmpKey := fmt.Sprintf("tmp/%s", uuid.New().String())
+    if err := s.client.Copy(ctx, attachment.R2Key, tmpKey); err != nil {
+        return nil, err
+    }
+    copied, err := s.StoreObject(ctx, tmpKey, attachment.Filename)
     if err != nil {
-        return err
+        return nil, err
     }
+    copied.ContentType = attachment.ContentType
+    copied.Scan = attachment.Scan
+    return copied, nil
+}
 
-    for _, key := range keys {
-        if err := s.client.Delete(ctx, key); err != nil {
+// GetAttachment retrieves an attachment from R2
+func (s *Storage) GetAttachment(ctx context.Context, key string) ([]byte, error) {
+    return s.client.Download(ctx, key)
+}
+
+// DeleteAttachments drops the references of an email's attachments and
+// deletes the blobs no other email references
+func (s *Storage) DeleteAttachments(ctx context.Context, attachments []email.Attachment) error {
+    var errs []error
+    for _, attachment := range attachments {
+        var err error
+        if !strings.HasPrefix(attachment.R2Key, BlobPrefix) {
+            // Quarantined or stored before deduplication, under a key of
+            // its own
+            err = s.client.Delete(ctx, attachment.R2Key)
+        } else {
+            err = s.release(ctx, attachment.SHA256)
+        }
+        if err != nil {
             s.logger.Error("failed to delete attachment",
-                zap.String("key", key),
+                zap.String("key", attachment.R2Key),
                 zap.Error(err),
             )
             // Continue deleting other attachments
-            continue
+            errs = append(errs, err)
+        }
+    }
+
+    return errors.Join(errs...)
+}
+
+// Quarantine moves an infected attachment out of the shared blobs to a key
+// of its own under QuarantinePrefix. It is kept there for inspection until
+// its email is deleted.
+func (s *Storage) Quarantine(ctx context.Context, attachment *email.Attachment) error {
+    key := QuarantinePrefix + uuid.New().String()
+    if err := s.client.Copy(ctx, attachment.R2Key, key); err != nil {
+        return err
+    }
+
+    if err := s.release(ctx, attachment.SHA256); err != nil {
+        if deleteErr := s.client.Delete(context.WithoutCancel(ctx), key); deleteErr != nil {
+            s.logger.Warn("failed to delete quarantine copy", zap.String("key", key), zap.Error(deleteErr))
         }
+        return fmt.Errorf("failed to release attachment blob: %w", err)
     }
 
+    attachment.R2Key = key
     return nil
 }
+
+// DedupStats sums up the stored blobs and their references
+func (s *Storage) DedupStats(ctx context.Context) (*blob.Stats, error) {
+    return s.blobs.Stats(ctx)
+}
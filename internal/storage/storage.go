@@ -2,19 +2,66 @@ package storage
 
 import (
 	"context"
+	"time"
 
+	"github.com/bezata/blockchainml-email/internal/domain/audit"
+	"github.com/bezata/blockchainml-email/internal/domain/backup"
+	"github.com/bezata/blockchainml-email/internal/domain/blob"
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
 	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
 	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/domain/suppression"
+	"github.com/bezata/blockchainml-email/internal/domain/template"
 	"github.com/bezata/blockchainml-email/internal/domain/thread"
+	"github.com/bezata/blockchainml-email/internal/jobs"
 )
 
-// EmailRepository defines email storage operations
+// Repositories groups the repositories the services are built on
+type Repositories struct {
+    Email        EmailRepository
+    Staff        StaffRepository
+    Thread       ThreadRepository
+    Audit        AuditRepository
+    Jobs         JobRepository
+    Blobs        BlobRepository
+    Spam         SpamRepository
+    Templates    TemplateRepository
+    Campaigns    CampaignRepository
+    Suppressions SuppressionRepository
+    Labels       LabelRepository
+}
+
+// EmailRepository defines email storage operations. Writes keep the
+// counters of the labels of the email's mailbox in the same transaction,
+// creating the labels the email carries that do not exist yet.
 type EmailRepository interface {
     Create(ctx context.Context, email *email.Email) error
     Get(ctx context.Context, id string) (*email.Email, error)
+    // Update replaces an email whatever its stored version, and advances
+    // the version
     Update(ctx context.Context, email *email.Email) error
+    // ReplaceVersion replaces an email only if its stored version is
+    // still version, advancing it, and returns ErrConflict otherwise
+    ReplaceVersion(ctx context.Context, email *email.Email, version int64) error
     Delete(ctx context.Context, id string) error
     List(ctx context.Context, query *email.ListQuery) ([]*email.Email, error)
+    // ReplaceDraft replaces a draft only if its stored draft version is
+    // still version, and returns ErrConflict otherwise
+    ReplaceDraft(ctx context.Context, email *email.Email, version int64) error
+    // ReplaceDelivery stores the delivery state and version of an email
+    // only if its stored delivery version is still version, and returns
+    // ErrConflict otherwise. Update keeps the stored delivery state.
+    ReplaceDelivery(ctx context.Context, email *email.Email, version int64) error
+}
+
+// EmailSearcher is implemented by backends with native full-text search. It
+// is the search fallback when no search engine is configured.
+type EmailSearcher interface {
+    // Search returns emails matching text, best match first, restricted by
+    // the filters of query. The query cursor is ignored.
+    Search(ctx context.Context, text string, query *email.ListQuery) ([]*email.Email, error)
 }
 
 // StaffRepository defines staff storage operations
@@ -35,3 +82,122 @@ type ThreadRepository interface {
     Delete(ctx context.Context, id string) error
     List(ctx context.Context, query *thread.ListQuery) ([]*thread.Thread, error)
 }
+
+// AuditRepository defines audit trail storage operations
+type AuditRepository interface {
+    StoreAuditEvent(ctx context.Context, event *audit.Event) error
+    ListAuditEvents(ctx context.Context, query *audit.ListQuery) ([]*audit.Event, error)
+}
+
+// JobRepository defines background job queue operations
+type JobRepository interface {
+    Enqueue(ctx context.Context, job *jobs.Job) error
+    Get(ctx context.Context, id string) (*jobs.Job, error)
+    // Claim leases the due job with the earliest RunAt to worker and
+    // increments its attempts. It returns ErrNotFound when no job is due.
+    Claim(ctx context.Context, worker string, lease time.Duration) (*jobs.Job, error)
+    // Complete and Fail return ErrNotFound unless worker holds the job
+    Complete(ctx context.Context, id, worker string) error
+    // Fail schedules a retry at retryAt, or marks the job failed when
+    // retryAt is nil
+    Fail(ctx context.Context, id, worker, reason string, retryAt *time.Time) error
+    List(ctx context.Context, query *jobs.ListQuery) ([]*jobs.Job, error)
+}
+
+// BlobRepository counts references to content-addressed attachment blobs
+type BlobRepository interface {
+    // Acquire adds a reference, creating the record of a new blob. It
+    // returns ErrBlobDeleting while the blob is being collected.
+    Acquire(ctx context.Context, hash string, size int64) (*blob.Blob, error)
+    // Release drops a reference. It returns ErrNotFound when the blob has
+    // no references left to drop.
+    Release(ctx context.Context, hash string) (*blob.Blob, error)
+    // MarkDeleting claims an unreferenced blob for collection. It returns
+    // ErrNotFound when the blob is referenced or claimed by someone else.
+    MarkDeleting(ctx context.Context, hash string) error
+    // Delete removes a blob claimed with MarkDeleting
+    Delete(ctx context.Context, hash string) error
+    Stats(ctx context.Context) (*blob.Stats, error)
+}
+
+// SpamRepository stores the token counts of Bayesian spam models, one per
+// owner: a staff ID or spam.GlobalModel
+type SpamRepository interface {
+    // Train adds delta, 1 to learn a message or -1 to forget it, to the
+    // message count of its class and to the count of each token. tokens
+    // must not repeat.
+    Train(ctx context.Context, owner string, isSpam bool, tokens []string, delta int64) error
+    // Model returns the message counts of owner's model and the counts of
+    // the given tokens
+    Model(ctx context.Context, owner string, tokens []string) (*spam.Model, error)
+}
+
+// TemplateRepository defines email template storage operations
+type TemplateRepository interface {
+    Create(ctx context.Context, template *template.Template) error
+    Get(ctx context.Context, id string) (*template.Template, error)
+    Update(ctx context.Context, template *template.Template) error
+    Delete(ctx context.Context, id string) error
+    List(ctx context.Context, query *template.ListQuery) ([]*template.Template, error)
+}
+
+// CampaignRepository defines bulk campaign and recipient storage operations
+type CampaignRepository interface {
+    Create(ctx context.Context, campaign *campaign.Campaign) error
+    Get(ctx context.Context, id string) (*campaign.Campaign, error)
+    Update(ctx context.Context, campaign *campaign.Campaign) error
+    // Delete removes a campaign with its recipients
+    Delete(ctx context.Context, id string) error
+    List(ctx context.Context, query *campaign.ListQuery) ([]*campaign.Campaign, error)
+    // AddRecipients stores recipients, skipping addresses their campaign
+    // already lists, and returns how many were added
+    AddRecipients(ctx context.Context, recipients []*campaign.Recipient) (int, error)
+    ListRecipients(ctx context.Context, query *campaign.RecipientQuery) ([]*campaign.Recipient, error)
+    UpdateRecipient(ctx context.Context, recipient *campaign.Recipient) error
+    // ReplaceRecipient updates a recipient only if its stored status is
+    // still status, and returns ErrConflict otherwise
+    ReplaceRecipient(ctx context.Context, recipient *campaign.Recipient, status string) error
+    // CountRecipients returns the number of recipients of a campaign by
+    // status
+    CountRecipients(ctx context.Context, campaignID string) (map[string]int, error)
+}
+
+// SuppressionRepository defines suppression list operations. Addresses are
+// lowercase and suppressed once.
+type SuppressionRepository interface {
+    // Add returns ErrDuplicate when the address is already suppressed
+    Add(ctx context.Context, suppression *suppression.Suppression) error
+    Get(ctx context.Context, email string) (*suppression.Suppression, error)
+    Delete(ctx context.Context, email string) error
+    List(ctx context.Context, query *suppression.ListQuery) ([]*suppression.Suppression, error)
+    // Find returns the suppressions of those of addresses that are
+    // suppressed
+    Find(ctx context.Context, addresses []string) ([]*suppression.Suppression, error)
+}
+
+// LabelRepository defines mailbox label operations. Names are unique per
+// owner; counters are kept by the EmailRepository.
+type LabelRepository interface {
+    // Create returns ErrDuplicate when the owner has a label of that name
+    Create(ctx context.Context, label *label.Label) error
+    Get(ctx context.Context, id string) (*label.Label, error)
+    List(ctx context.Context, query *label.ListQuery) ([]*label.Label, error)
+    // Update stores the color of a label; names change with Rename
+    Update(ctx context.Context, label *label.Label) error
+    // Rename renames a label and the labels nested in it, on the labels
+    // and on the emails of the owner's mailbox, in one transaction. It
+    // returns ErrDuplicate when a new name is taken.
+    Rename(ctx context.Context, owner, from, to string) error
+    // Delete removes a label and the labels nested in it, and takes them
+    // off the emails of the owner's mailbox, in one transaction
+    Delete(ctx context.Context, owner, name string) error
+}
+
+// BackupRepository defines backup catalog operations
+type BackupRepository interface {
+    CreateBackup(ctx context.Context, backup *backup.Backup) error
+    GetBackup(ctx context.Context, id string) (*backup.Backup, error)
+    UpdateBackup(ctx context.Context, backup *backup.Backup) error
+    DeleteBackup(ctx context.Context, id string) error
+    ListBackups(ctx context.Context, query *backup.ListQuery) ([]*backup.Backup, error)
+}
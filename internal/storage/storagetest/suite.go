@@ -0,0 +1,1590 @@
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/domain/audit"
+	"github.com/bezata/blockchainml-email/internal/domain/blob"
+	"github.com/bezata/blockchainml-email/internal/domain/campaign"
+	"github.com/bezata/blockchainml-email/internal/domain/email"
+	"github.com/bezata/blockchainml-email/internal/domain/label"
+	"github.com/bezata/blockchainml-email/internal/domain/spam"
+	"github.com/bezata/blockchainml-email/internal/domain/staff"
+	"github.com/bezata/blockchainml-email/internal/domain/suppression"
+	"github.com/bezata/blockchainml-email/internal/domain/template"
+	"github.com/bezata/blockchainml-email/internal/domain/thread"
+	"github.com/bezata/blockchainml-email/internal/jobs"
+	"github.com/bezata/blockchainml-email/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Factory returns empty repositories for one subtest. Backends that need
+// cleanup register it with t.Cleanup.
+type Factory func(t *testing.T) *storage.Repositories
+
+// Run checks that a storage backend implements the repository contracts:
+// ErrNotFound and ErrDuplicate semantics, every List filter, ordering, and
+// cursor pagination. Every backend's tests call it:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) *storage.Repositories {
+//			return memory.NewRepositories()
+//		})
+//	}
+func Run(t *testing.T, newRepos Factory) {
+	t.Run("Email", func(t *testing.T) { testEmails(t, newRepos) })
+	t.Run("Staff", func(t *testing.T) { testStaff(t, newRepos) })
+	t.Run("Thread", func(t *testing.T) { testThreads(t, newRepos) })
+	t.Run("Audit", func(t *testing.T) { testAudit(t, newRepos) })
+	t.Run("Jobs", func(t *testing.T) { testJobs(t, newRepos) })
+	t.Run("Blobs", func(t *testing.T) { testBlobs(t, newRepos) })
+	t.Run("Spam", func(t *testing.T) { testSpam(t, newRepos) })
+	t.Run("Templates", func(t *testing.T) { testTemplates(t, newRepos) })
+	t.Run("Campaigns", func(t *testing.T) { testCampaigns(t, newRepos) })
+	t.Run("Suppressions", func(t *testing.T) { testSuppressions(t, newRepos) })
+	t.Run("Labels", func(t *testing.T) { testLabels(t, newRepos) })
+}
+
+// base is millisecond aligned so timestamps compare equal after a round
+// trip through any backend
+var base = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testEmails(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	t.Run("CRUD", func(t *testing.T) {
+		repo := newRepos(t).Email
+
+		e := newEmail("crud", base, "alice@example.com", "bob@example.com")
+		mustNoErr(t, repo.Create(ctx, e))
+		if e.ID.IsZero() {
+			t.Fatal("Create did not assign an ID")
+		}
+
+		got, err := repo.Get(ctx, e.ID.Hex())
+		mustNoErr(t, err)
+		if got.MessageID != e.MessageID || got.Subject != e.Subject || !got.CreatedAt.Equal(e.CreatedAt) {
+			t.Fatalf("Get returned %+v, want %+v", got, e)
+		}
+
+		// Returned records must not alias stored ones
+		got.Subject = "changed locally"
+		again, err := repo.Get(ctx, e.ID.Hex())
+		mustNoErr(t, err)
+		if again.Subject != e.Subject {
+			t.Fatal("modifying a returned email changed the stored one")
+		}
+
+		got.Flags.IsRead = true
+		mustNoErr(t, repo.Update(ctx, got))
+		again, err = repo.Get(ctx, e.ID.Hex())
+		mustNoErr(t, err)
+		if !again.Flags.IsRead || again.Subject != "changed locally" {
+			t.Fatal("Update was not persisted")
+		}
+
+		got.Delivery = []email.RecipientDelivery{{
+			Recipient: "bob@example.com",
+			Status:    email.DeliveryBounced,
+			Code:      "5.1.1",
+			UpdatedAt: base,
+			Attempts: []email.DeliveryAttempt{{
+				Status:    email.DeliveryBounced,
+				Reply:     550,
+				Code:      "5.1.1",
+				RemoteMX:  "mx.example.com",
+				TLS:       "TLS 1.3",
+				StartedAt: base,
+				EndedAt:   base,
+			}},
+		}}
+		got.DeliveryVersion = 1
+		mustNoErr(t, repo.ReplaceDelivery(ctx, got, 0))
+		mustErr(t, repo.ReplaceDelivery(ctx, got, 0), storage.ErrConflict)
+		again, err = repo.Get(ctx, e.ID.Hex())
+		mustNoErr(t, err)
+		if len(again.Delivery) != 1 || again.Delivery[0].Code != "5.1.1" || !again.Delivery[0].UpdatedAt.Equal(base) {
+			t.Fatalf("ReplaceDelivery stored delivery %+v", again.Delivery)
+		}
+		if attempts := again.Delivery[0].Attempts; len(attempts) != 1 || attempts[0].Reply != 550 ||
+			attempts[0].TLS != "TLS 1.3" || !attempts[0].EndedAt.Equal(base) {
+			t.Fatalf("ReplaceDelivery stored delivery attempts %+v", again.Delivery[0].Attempts)
+		}
+		if again.DeliveryVersion != 1 {
+			t.Fatalf("ReplaceDelivery stored version %d, want 1", again.DeliveryVersion)
+		}
+
+		// Updates from before the delivery change keep the stored state
+		stale := *again
+		stale.Delivery, stale.DeliveryVersion = nil, 0
+		stale.Flags.IsStarred = true
+		mustNoErr(t, repo.Update(ctx, &stale))
+		again, err = repo.Get(ctx, e.ID.Hex())
+		mustNoErr(t, err)
+		if !again.Flags.IsStarred || len(again.Delivery) != 1 || again.DeliveryVersion != 1 {
+			t.Fatalf("Update changed delivery to %+v, version %d", again.Delivery, again.DeliveryVersion)
+		}
+
+		// Every write advances the version ReplaceVersion is conditional on
+		version := again.Version
+		if version == 0 {
+			t.Fatal("Update did not advance the version")
+		}
+		modified := *again
+		modified.Flags.IsRead = false
+		mustNoErr(t, repo.ReplaceVersion(ctx, &modified, version))
+		if modified.Version != version+1 {
+			t.Fatalf("ReplaceVersion left version %d, want %d", modified.Version, version+1)
+		}
+		mustErr(t, repo.ReplaceVersion(ctx, again, version), storage.ErrConflict)
+		mustNoErr(t, repo.Update(ctx, again))
+		mustErr(t, repo.ReplaceVersion(ctx, &modified, modified.Version), storage.ErrConflict)
+		again, err = repo.Get(ctx, e.ID.Hex())
+		mustNoErr(t, err)
+		if again.Version != version+2 || !again.Flags.IsRead || len(again.Delivery) != 1 {
+			t.Fatalf("stored version %d, read %v, delivery %+v", again.Version, again.Flags.IsRead, again.Delivery)
+		}
+
+		mustNoErr(t, repo.Delete(ctx, e.ID.Hex()))
+		_, err = repo.Get(ctx, e.ID.Hex())
+		mustErr(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		repo := newRepos(t).Email
+		missing := primitive.NewObjectID().Hex()
+
+		_, err := repo.Get(ctx, missing)
+		mustErr(t, err, storage.ErrNotFound)
+		_, err = repo.Get(ctx, "not-an-id")
+		mustErr(t, err, storage.ErrNotFound)
+		mustErr(t, repo.Delete(ctx, missing), storage.ErrNotFound)
+		mustErr(t, repo.Update(ctx, newEmail("missing", base, "a@example.com")), storage.ErrNotFound)
+		mustErr(t, repo.ReplaceDelivery(ctx, newEmail("missing", base, "a@example.com"), 0), storage.ErrNotFound)
+		mustErr(t, repo.ReplaceVersion(ctx, newEmail("missing", base, "a@example.com"), 0), storage.ErrNotFound)
+	})
+
+	t.Run("DuplicateMessageID", func(t *testing.T) {
+		repo := newRepos(t).Email
+		mustNoErr(t, repo.Create(ctx, newEmail("dup", base, "a@example.com")))
+		mustErr(t, repo.Create(ctx, newEmail("dup", base, "a@example.com")), storage.ErrDuplicate)
+
+		// Every recipient's mailbox holds a copy with the same message ID
+		first := newEmail("copy", base, "a@example.com", "b@example.com", "c@example.com")
+		first.Mailbox = "mailbox-b"
+		second := newEmail("copy", base, "a@example.com", "b@example.com", "c@example.com")
+		second.Mailbox = "mailbox-c"
+		mustNoErr(t, repo.Create(ctx, first))
+		mustNoErr(t, repo.Create(ctx, second))
+
+		again := newEmail("copy", base, "a@example.com", "b@example.com", "c@example.com")
+		again.Mailbox = "mailbox-b"
+		mustErr(t, repo.Create(ctx, again), storage.ErrDuplicate)
+		second.Mailbox = "mailbox-b"
+		mustErr(t, repo.Update(ctx, second), storage.ErrDuplicate)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		repo := newRepos(t).Email
+		threadID := "thread-1"
+
+		inbox := newEmail("inbox", base, "carol@example.com", "alice@example.com")
+		inbox.Labels = []string{"inbox", "work"}
+		inbox.ThreadID = &threadID
+
+		cc := newEmail("cc", base.Add(time.Minute), "dave@example.com", "erin@example.com")
+		cc.CC = []email.Participant{{Email: "alice@example.com"}}
+		cc.Labels = []string{"inbox"}
+		cc.Flags.IsRead = true
+
+		sent := newEmail("sent", base.Add(2*time.Minute), "alice@example.com", "frank@example.com")
+		sent.Labels = []string{"sent", "work"}
+		sent.ThreadID = &threadID
+		sent.Flags.IsStarred = true
+
+		other := newEmail("other", base.Add(3*time.Minute), "grace@example.com", "heidi@example.com")
+
+		for _, e := range []*email.Email{inbox, cc, sent, other} {
+			mustNoErr(t, repo.Create(ctx, e))
+		}
+
+		yes, no := true, false
+		since, until := base.Add(time.Minute), base.Add(3*time.Minute)
+		cases := []struct {
+			name  string
+			query email.ListQuery
+			want  []*email.Email
+		}{
+			{"all newest first", email.ListQuery{}, []*email.Email{other, sent, cc, inbox}},
+			{"participant", email.ListQuery{Participant: "alice@example.com"}, []*email.Email{sent, cc, inbox}},
+			{"from", email.ListQuery{From: "alice@example.com"}, []*email.Email{sent}},
+			{"message id", email.ListQuery{MessageID: cc.MessageID}, []*email.Email{cc}},
+			{"thread", email.ListQuery{ThreadID: threadID}, []*email.Email{sent, inbox}},
+			{"one label", email.ListQuery{Labels: []string{"work"}}, []*email.Email{sent, inbox}},
+			{"all labels", email.ListQuery{Labels: []string{"inbox", "work"}}, []*email.Email{inbox}},
+			{"read", email.ListQuery{IsRead: &yes}, []*email.Email{cc}},
+			{"unread", email.ListQuery{IsRead: &no, Participant: "alice@example.com"}, []*email.Email{sent, inbox}},
+			{"starred", email.ListQuery{IsStarred: &yes}, []*email.Email{sent}},
+			{"since inclusive until exclusive", email.ListQuery{Since: &since, Until: &until}, []*email.Email{sent, cc}},
+			{"limit", email.ListQuery{Limit: 2}, []*email.Email{other, sent}},
+		}
+		for _, tc := range cases {
+			query := tc.query
+			got, err := repo.List(ctx, &query)
+			mustNoErr(t, err)
+			if err := sameEmails(got, tc.want); err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+		}
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		repo := newRepos(t).Email
+
+		// Pairs share a timestamp so the ID tie-breaker is exercised
+		var all []*email.Email
+		for i := 0; i < 7; i++ {
+			e := newEmail(fmt.Sprintf("page-%d", i), base.Add(time.Duration(i/2)*time.Second), "a@example.com")
+			mustNoErr(t, repo.Create(ctx, e))
+			all = append(all, e)
+		}
+
+		var paged []*email.Email
+		query := email.ListQuery{Limit: 3}
+		for {
+			page, err := repo.List(ctx, &query)
+			mustNoErr(t, err)
+			paged = append(paged, page...)
+			if len(page) < query.Limit {
+				break
+			}
+			query.Cursor = storage.EmailCursor(page[len(page)-1])
+		}
+
+		full, err := repo.List(ctx, &email.ListQuery{})
+		mustNoErr(t, err)
+		if err := sameEmails(paged, full); err != nil {
+			t.Fatalf("paging does not match a full listing: %v", err)
+		}
+		if len(paged) != len(all) {
+			t.Fatalf("paging returned %d emails, want %d", len(paged), len(all))
+		}
+
+		_, err = repo.List(ctx, &email.ListQuery{Cursor: "%%%"})
+		mustErr(t, err, storage.ErrInvalidCursor)
+	})
+	t.Run("Drafts", func(t *testing.T) {
+		repo := newRepos(t).Email
+
+		draft := newEmail("draft", base, "alice@example.com", "bob@example.com")
+		draft.Flags.IsDraft = true
+		draft.Draft = &email.Draft{Version: 1}
+		mustNoErr(t, repo.Create(ctx, draft))
+
+		draft.Subject = "second"
+		draft.Draft = &email.Draft{Version: 2, Revisions: []email.DraftRevision{{Version: 1, Subject: "first", SavedAt: base}}}
+		mustNoErr(t, repo.ReplaceDraft(ctx, draft, 1))
+
+		// A save based on the replaced version is refused
+		stale := *draft
+		stale.Subject = "stale"
+		stale.Draft = &email.Draft{Version: 2}
+		mustErr(t, repo.ReplaceDraft(ctx, &stale, 1), storage.ErrConflict)
+
+		got, err := repo.Get(ctx, draft.ID.Hex())
+		mustNoErr(t, err)
+		if got.Subject != "second" || got.Draft == nil || got.Draft.Version != 2 || len(got.Draft.Revisions) != 1 {
+			t.Fatalf("ReplaceDraft stored %+v", got)
+		}
+
+		// Sending clears the draft, after which it cannot be replaced
+		sent := *got
+		sent.Flags.IsDraft = false
+		sent.Draft = nil
+		mustNoErr(t, repo.ReplaceDraft(ctx, &sent, 2))
+		got, err = repo.Get(ctx, draft.ID.Hex())
+		mustNoErr(t, err)
+		if got.Draft != nil || got.Flags.IsDraft {
+			t.Fatal("sent email is still a draft")
+		}
+		mustErr(t, repo.ReplaceDraft(ctx, &sent, 2), storage.ErrConflict)
+		mustErr(t, repo.ReplaceDraft(ctx, newEmail("missing", base, "a@example.com"), 1), storage.ErrNotFound)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		repo := newRepos(t).Email
+		searcher, ok := repo.(storage.EmailSearcher)
+		if !ok {
+			t.Skip("backend has no native full-text search")
+		}
+
+		invoice := newEmail("invoice", base, "billing@example.com", "alice@example.com")
+		invoice.Subject = "Invoice for March"
+		invoice.Content.Text = "Your invoices are attached"
+		invoice.Mailbox = "alice"
+		mention := newEmail("mention", base.Add(time.Minute), "bob@example.com", "alice@example.com")
+		mention.Content.Text = "Did the invoice arrive?"
+		mention.Mailbox = "bob"
+		other := newEmail("other", base.Add(2*time.Minute), "bob@example.com", "carol@example.com")
+		other.Content.Text = "Lunch on Friday"
+		for _, e := range []*email.Email{invoice, mention, other} {
+			mustNoErr(t, repo.Create(ctx, e))
+		}
+
+		// A subject match outranks a body match, and stemming matches plurals
+		found, err := searcher.Search(ctx, "invoice", nil)
+		mustNoErr(t, err)
+		if err := sameEmails(found, []*email.Email{invoice, mention}); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err = searcher.Search(ctx, "invoice", &email.ListQuery{From: "bob@example.com"})
+		mustNoErr(t, err)
+		if err := sameEmails(found, []*email.Email{mention}); err != nil {
+			t.Fatalf("filters are not applied: %v", err)
+		}
+
+		found, err = searcher.Search(ctx, "invoice", &email.ListQuery{Mailbox: "alice"})
+		mustNoErr(t, err)
+		if err := sameEmails(found, []*email.Email{invoice}); err != nil {
+			t.Fatalf("mailbox is not applied: %v", err)
+		}
+	})
+}
+
+func testStaff(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	t.Run("CRUD", func(t *testing.T) {
+		repo := newRepos(t).Staff
+
+		s := newStaff("Alice Adams", "alice@example.com", "engineering", staff.RoleAdmin)
+		mustNoErr(t, repo.Create(ctx, s))
+		if s.ID.IsZero() {
+			t.Fatal("Create did not assign an ID")
+		}
+
+		got, err := repo.Get(ctx, s.ID.Hex())
+		mustNoErr(t, err)
+		if got.Email != s.Email || got.FullName != s.FullName {
+			t.Fatalf("Get returned %+v, want %+v", got, s)
+		}
+
+		byEmail, err := repo.GetByEmail(ctx, "ALICE@example.com")
+		mustNoErr(t, err)
+		if byEmail.ID != s.ID {
+			t.Fatal("GetByEmail is not case-insensitive")
+		}
+
+		got.Title = "Staff Engineer"
+		mustNoErr(t, repo.Update(ctx, got))
+		got, err = repo.Get(ctx, s.ID.Hex())
+		mustNoErr(t, err)
+		if got.Title != "Staff Engineer" {
+			t.Fatal("Update was not persisted")
+		}
+
+		mustNoErr(t, repo.Delete(ctx, s.ID.Hex()))
+		_, err = repo.Get(ctx, s.ID.Hex())
+		mustErr(t, err, storage.ErrNotFound)
+		_, err = repo.GetByEmail(ctx, s.Email)
+		mustErr(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		repo := newRepos(t).Staff
+		missing := primitive.NewObjectID().Hex()
+
+		_, err := repo.Get(ctx, missing)
+		mustErr(t, err, storage.ErrNotFound)
+		_, err = repo.GetByEmail(ctx, "nobody@example.com")
+		mustErr(t, err, storage.ErrNotFound)
+		mustErr(t, repo.Delete(ctx, missing), storage.ErrNotFound)
+		mustErr(t, repo.Update(ctx, newStaff("Nobody", "nobody@example.com", "", staff.RoleMember)), storage.ErrNotFound)
+	})
+
+	t.Run("DuplicateEmail", func(t *testing.T) {
+		repo := newRepos(t).Staff
+		first := newStaff("First", "same@example.com", "", staff.RoleMember)
+		second := newStaff("Second", "other@example.com", "", staff.RoleMember)
+		mustNoErr(t, repo.Create(ctx, first))
+		mustNoErr(t, repo.Create(ctx, second))
+
+		mustErr(t, repo.Create(ctx, newStaff("Third", "same@example.com", "", staff.RoleMember)), storage.ErrDuplicate)
+
+		second.Email = first.Email
+		mustErr(t, repo.Update(ctx, second), storage.ErrDuplicate)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		repo := newRepos(t).Staff
+
+		alice := newStaff("Alice Adams", "alice@example.com", "engineering", staff.RoleAdmin)
+		bob := newStaff("Bob Brown", "bob@example.com", "engineering", staff.RoleMember)
+		carol := newStaff("Carol Clark", "carol@sales.example.com", "sales", staff.RoleMember)
+		dan := newStaff("Dan Adamson", "dan@example.com", "sales", staff.RoleMember)
+		dan.Status = staff.StatusOffboarded
+		for _, s := range []*staff.Staff{dan, carol, bob, alice} {
+			mustNoErr(t, repo.Create(ctx, s))
+		}
+		bob.ManagerID = alice.ID.Hex()
+		mustNoErr(t, repo.Update(ctx, bob))
+
+		cases := []struct {
+			name  string
+			query staff.ListQuery
+			want  []*staff.Staff
+		}{
+			{"all by name", staff.ListQuery{}, []*staff.Staff{alice, bob, carol, dan}},
+			{"search name", staff.ListQuery{Search: "adam"}, []*staff.Staff{alice, dan}},
+			{"search email", staff.ListQuery{Search: "SALES.example"}, []*staff.Staff{carol}},
+			{"search is literal", staff.ListQuery{Search: "a.*"}, nil},
+			{"department", staff.ListQuery{Department: "engineering"}, []*staff.Staff{alice, bob}},
+			{"role", staff.ListQuery{Role: staff.RoleMember}, []*staff.Staff{bob, carol, dan}},
+			{"status", staff.ListQuery{Status: staff.StatusOffboarded}, []*staff.Staff{dan}},
+			{"manager", staff.ListQuery{ManagerID: alice.ID.Hex()}, []*staff.Staff{bob}},
+			{"combined", staff.ListQuery{Department: "sales", Status: staff.StatusActive}, []*staff.Staff{carol}},
+		}
+		for _, tc := range cases {
+			query := tc.query
+			got, err := repo.List(ctx, &query)
+			mustNoErr(t, err)
+			if err := sameStaff(got, tc.want); err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+		}
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		repo := newRepos(t).Staff
+
+		// Duplicate names exercise the ID tie-breaker
+		for i := 0; i < 7; i++ {
+			name := fmt.Sprintf("Member %d", i/2)
+			mustNoErr(t, repo.Create(ctx, newStaff(name, fmt.Sprintf("member%d@example.com", i), "", staff.RoleMember)))
+		}
+
+		var paged []*staff.Staff
+		query := staff.ListQuery{Limit: 3}
+		for {
+			page, err := repo.List(ctx, &query)
+			mustNoErr(t, err)
+			paged = append(paged, page...)
+			if len(page) < query.Limit {
+				break
+			}
+			query.Cursor = storage.StaffCursor(page[len(page)-1])
+		}
+
+		full, err := repo.List(ctx, &staff.ListQuery{})
+		mustNoErr(t, err)
+		if err := sameStaff(paged, full); err != nil {
+			t.Fatalf("paging does not match a full listing: %v", err)
+		}
+		if len(paged) != 7 {
+			t.Fatalf("paging returned %d members, want 7", len(paged))
+		}
+	})
+}
+
+func testThreads(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	t.Run("CRUD", func(t *testing.T) {
+		repo := newRepos(t).Thread
+
+		th := newThread("t-crud", base, "alice@example.com")
+		mustNoErr(t, repo.Create(ctx, th))
+
+		got, err := repo.Get(ctx, th.ThreadID)
+		mustNoErr(t, err)
+		if got.Subject != th.Subject || !got.LastMessage.SentAt.Equal(th.LastMessage.SentAt) {
+			t.Fatalf("Get returned %+v, want %+v", got, th)
+		}
+
+		got.MessageCount = 2
+		mustNoErr(t, repo.Update(ctx, got))
+		got, err = repo.Get(ctx, th.ThreadID)
+		mustNoErr(t, err)
+		if got.MessageCount != 2 {
+			t.Fatal("Update was not persisted")
+		}
+
+		mustErr(t, repo.Create(ctx, newThread("t-crud", base, "bob@example.com")), storage.ErrDuplicate)
+
+		mustNoErr(t, repo.Delete(ctx, th.ThreadID))
+		_, err = repo.Get(ctx, th.ThreadID)
+		mustErr(t, err, storage.ErrNotFound)
+		mustErr(t, repo.Delete(ctx, th.ThreadID), storage.ErrNotFound)
+		mustErr(t, repo.Update(ctx, th), storage.ErrNotFound)
+	})
+
+	t.Run("ListAndPagination", func(t *testing.T) {
+		repo := newRepos(t).Thread
+
+		for i := 0; i < 7; i++ {
+			participant := "alice@example.com"
+			if i%3 == 0 {
+				participant = "bob@example.com"
+			}
+			th := newThread(fmt.Sprintf("t-%d", i), base.Add(time.Duration(i/2)*time.Minute), participant)
+			mustNoErr(t, repo.Create(ctx, th))
+		}
+
+		bobs, err := repo.List(ctx, &thread.ListQuery{Participant: "bob@example.com"})
+		mustNoErr(t, err)
+		if got := threadIDs(bobs); fmt.Sprint(got) != "[t-6 t-3 t-0]" {
+			t.Fatalf("participant filter returned %v, want [t-6 t-3 t-0]", got)
+		}
+
+		var paged []*thread.Thread
+		query := thread.ListQuery{Limit: 3}
+		for {
+			page, err := repo.List(ctx, &query)
+			mustNoErr(t, err)
+			paged = append(paged, page...)
+			if len(page) < query.Limit {
+				break
+			}
+			query.Cursor = storage.ThreadCursor(page[len(page)-1])
+		}
+		if got := threadIDs(paged); fmt.Sprint(got) != "[t-6 t-5 t-4 t-3 t-2 t-1 t-0]" {
+			t.Fatalf("paging returned %v, want newest first", got)
+		}
+	})
+}
+
+func testAudit(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	t.Run("StoreAndList", func(t *testing.T) {
+		repo := newRepos(t).Audit
+
+		for i := 0; i < 7; i++ {
+			event := &audit.Event{
+				Timestamp: base.Add(time.Duration(i/2) * time.Minute),
+				ActorID:   "alice",
+				Action:    fmt.Sprintf("action-%d", i),
+				Resource:  "email",
+				Risk:      audit.RiskLow,
+				Details:   map[string]string{"n": fmt.Sprint(i)},
+			}
+			if i%3 == 0 {
+				event.ActorID, event.Risk = "bob", audit.RiskHigh
+			}
+			mustNoErr(t, repo.StoreAuditEvent(ctx, event))
+			if event.ID == "" {
+				t.Fatal("StoreAuditEvent did not assign an ID")
+			}
+			if i == 0 {
+				mustErr(t, repo.StoreAuditEvent(ctx, event), storage.ErrDuplicate)
+			}
+		}
+
+		bobs, err := repo.ListAuditEvents(ctx, &audit.ListQuery{ActorID: "bob"})
+		mustNoErr(t, err)
+		if got := auditActions(bobs); fmt.Sprint(got) != "[action-6 action-3 action-0]" {
+			t.Fatalf("actor filter returned %v, want [action-6 action-3 action-0]", got)
+		}
+		if bobs[0].Details["n"] != "6" {
+			t.Fatalf("details were not stored: %v", bobs[0].Details)
+		}
+
+		since := base.Add(time.Minute)
+		high, err := repo.ListAuditEvents(ctx, &audit.ListQuery{Risk: audit.RiskHigh, Since: &since})
+		mustNoErr(t, err)
+		if got := auditActions(high); fmt.Sprint(got) != "[action-6 action-3]" {
+			t.Fatalf("risk and since filters returned %v, want [action-6 action-3]", got)
+		}
+
+		var paged []*audit.Event
+		query := audit.ListQuery{Limit: 3}
+		for {
+			page, err := repo.ListAuditEvents(ctx, &query)
+			mustNoErr(t, err)
+			paged = append(paged, page...)
+			if len(page) < query.Limit {
+				break
+			}
+			query.Cursor = storage.AuditCursor(page[len(page)-1])
+		}
+		if len(paged) != 7 {
+			t.Fatalf("paging returned %d events, want 7", len(paged))
+		}
+		for i := 1; i < len(paged); i++ {
+			if paged[i].Timestamp.After(paged[i-1].Timestamp) {
+				t.Fatalf("paging returned %v, want newest first", auditActions(paged))
+			}
+		}
+	})
+}
+
+func testJobs(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	t.Run("EnqueueAndGet", func(t *testing.T) {
+		repo := newRepos(t).Jobs
+
+		job := &jobs.Job{Type: "send", Payload: []byte(`{"to":"bob@example.com"}`), MaxAttempts: 3}
+		mustNoErr(t, repo.Enqueue(ctx, job))
+		if job.ID == "" || job.Status != jobs.StatusPending || job.RunAt.IsZero() {
+			t.Fatalf("Enqueue did not fill defaults: %+v", job)
+		}
+		mustErr(t, repo.Enqueue(ctx, job), storage.ErrDuplicate)
+
+		got, err := repo.Get(ctx, job.ID)
+		mustNoErr(t, err)
+		var payload struct{ To string }
+		mustNoErr(t, json.Unmarshal(got.Payload, &payload))
+		if got.Type != "send" || got.MaxAttempts != 3 || payload.To != "bob@example.com" {
+			t.Fatalf("Get returned %+v", got)
+		}
+
+		_, err = repo.Get(ctx, "missing")
+		mustErr(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("Claim", func(t *testing.T) {
+		repo := newRepos(t).Jobs
+
+		now := time.Now()
+		later := &jobs.Job{Type: "later", RunAt: now.Add(time.Hour)}
+		second := &jobs.Job{Type: "second", RunAt: now.Add(-time.Minute)}
+		first := &jobs.Job{Type: "first", RunAt: now.Add(-2 * time.Minute)}
+		for _, job := range []*jobs.Job{later, second, first} {
+			mustNoErr(t, repo.Enqueue(ctx, job))
+		}
+
+		claimed, err := repo.Claim(ctx, "w1", time.Minute)
+		mustNoErr(t, err)
+		if claimed.ID != first.ID || claimed.Status != jobs.StatusRunning || claimed.LockedBy != "w1" || claimed.Attempts != 1 {
+			t.Fatalf("Claim returned %+v, want the earliest due job leased to w1", claimed)
+		}
+
+		claimed, err = repo.Claim(ctx, "w2", time.Minute)
+		mustNoErr(t, err)
+		if claimed.ID != second.ID {
+			t.Fatalf("second Claim returned %s, want %s", claimed.Type, second.Type)
+		}
+
+		_, err = repo.Claim(ctx, "w3", time.Minute)
+		mustErr(t, err, storage.ErrNotFound)
+
+		mustErr(t, repo.Complete(ctx, first.ID, "w2"), storage.ErrNotFound)
+		mustNoErr(t, repo.Complete(ctx, first.ID, "w1"))
+		got, err := repo.Get(ctx, first.ID)
+		mustNoErr(t, err)
+		if got.Status != jobs.StatusCompleted || got.CompletedAt == nil || got.LockedBy != "" {
+			t.Fatalf("Complete left %+v", got)
+		}
+		mustErr(t, repo.Complete(ctx, first.ID, "w1"), storage.ErrNotFound)
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		repo := newRepos(t).Jobs
+
+		job := &jobs.Job{Type: "flaky", RunAt: time.Now().Add(-time.Minute)}
+		mustNoErr(t, repo.Enqueue(ctx, job))
+		_, err := repo.Claim(ctx, "w1", time.Minute)
+		mustNoErr(t, err)
+
+		retryAt := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+		mustErr(t, repo.Fail(ctx, job.ID, "w2", "boom", &retryAt), storage.ErrNotFound)
+		mustNoErr(t, repo.Fail(ctx, job.ID, "w1", "boom", &retryAt))
+		got, err := repo.Get(ctx, job.ID)
+		mustNoErr(t, err)
+		if got.Status != jobs.StatusPending || got.LastError != "boom" || !got.RunAt.Equal(retryAt) {
+			t.Fatalf("Fail with retry left %+v", got)
+		}
+
+		claimed, err := repo.Claim(ctx, "w1", time.Minute)
+		mustNoErr(t, err)
+		if claimed.Attempts != 2 {
+			t.Fatalf("retried job has %d attempts, want 2", claimed.Attempts)
+		}
+		mustNoErr(t, repo.Fail(ctx, job.ID, "w1", "gave up", nil))
+		got, err = repo.Get(ctx, job.ID)
+		mustNoErr(t, err)
+		if got.Status != jobs.StatusFailed || got.LastError != "gave up" {
+			t.Fatalf("Fail without retry left %+v", got)
+		}
+
+		_, err = repo.Claim(ctx, "w1", time.Minute)
+		mustErr(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("ExpiredLease", func(t *testing.T) {
+		repo := newRepos(t).Jobs
+
+		job := &jobs.Job{Type: "slow", RunAt: time.Now().Add(-time.Minute)}
+		mustNoErr(t, repo.Enqueue(ctx, job))
+		_, err := repo.Claim(ctx, "w1", time.Millisecond)
+		mustNoErr(t, err)
+		time.Sleep(20 * time.Millisecond)
+
+		claimed, err := repo.Claim(ctx, "w2", time.Minute)
+		mustNoErr(t, err)
+		if claimed.ID != job.ID || claimed.LockedBy != "w2" || claimed.Attempts != 2 {
+			t.Fatalf("Claim after lease expiry returned %+v", claimed)
+		}
+		mustErr(t, repo.Complete(ctx, job.ID, "w1"), storage.ErrNotFound)
+		mustNoErr(t, repo.Complete(ctx, job.ID, "w2"))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		repo := newRepos(t).Jobs
+
+		for i := 0; i < 7; i++ {
+			jobType := "digest"
+			if i%3 == 0 {
+				jobType = "send"
+			}
+			mustNoErr(t, repo.Enqueue(ctx, &jobs.Job{Type: jobType, RunAt: time.Now().Add(time.Hour)}))
+		}
+
+		sends, err := repo.List(ctx, &jobs.ListQuery{Type: "send", Status: jobs.StatusPending})
+		mustNoErr(t, err)
+		if len(sends) != 3 {
+			t.Fatalf("type filter returned %d jobs, want 3", len(sends))
+		}
+		running, err := repo.List(ctx, &jobs.ListQuery{Status: jobs.StatusRunning})
+		mustNoErr(t, err)
+		if len(running) != 0 {
+			t.Fatalf("status filter returned %d jobs, want 0", len(running))
+		}
+
+		seen := map[string]bool{}
+		query := jobs.ListQuery{Limit: 3}
+		for {
+			page, err := repo.List(ctx, &query)
+			mustNoErr(t, err)
+			for _, job := range page {
+				if seen[job.ID] {
+					t.Fatalf("job %s returned on two pages", job.ID)
+				}
+				seen[job.ID] = true
+			}
+			if len(page) < query.Limit {
+				break
+			}
+			query.Cursor = storage.JobCursor(page[len(page)-1])
+		}
+		if len(seen) != 7 {
+			t.Fatalf("paging returned %d jobs, want 7", len(seen))
+		}
+	})
+}
+
+func testBlobs(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	t.Run("References", func(t *testing.T) {
+		repo := newRepos(t).Blobs
+
+		for i := 1; i <= 3; i++ {
+			b, err := repo.Acquire(ctx, "aaaa", 100)
+			mustNoErr(t, err)
+			if b.RefCount != int64(i) || b.Size != 100 {
+				t.Fatalf("Acquire %d returned refCount %d size %d", i, b.RefCount, b.Size)
+			}
+		}
+		_, err := repo.Acquire(ctx, "bbbb", 40)
+		mustNoErr(t, err)
+
+		stats, err := repo.Stats(ctx)
+		mustNoErr(t, err)
+		want := blob.Stats{Blobs: 2, References: 4, StoredBytes: 140, LogicalBytes: 340}
+		if *stats != want {
+			t.Fatalf("Stats returned %+v, want %+v", *stats, want)
+		}
+
+		mustErr(t, repo.MarkDeleting(ctx, "aaaa"), storage.ErrNotFound)
+		for i := 2; i >= 0; i-- {
+			b, err := repo.Release(ctx, "aaaa")
+			mustNoErr(t, err)
+			if b.RefCount != int64(i) {
+				t.Fatalf("Release returned refCount %d, want %d", b.RefCount, i)
+			}
+		}
+		_, err = repo.Release(ctx, "aaaa")
+		mustErr(t, err, storage.ErrNotFound)
+		_, err = repo.Release(ctx, "cccc")
+		mustErr(t, err, storage.ErrNotFound)
+
+		stats, err = repo.Stats(ctx)
+		mustNoErr(t, err)
+		if want := (blob.Stats{Blobs: 1, References: 1, StoredBytes: 40, LogicalBytes: 40}); *stats != want {
+			t.Fatalf("Stats after release returned %+v, want %+v", *stats, want)
+		}
+	})
+
+	t.Run("Collect", func(t *testing.T) {
+		repo := newRepos(t).Blobs
+
+		_, err := repo.Acquire(ctx, "aaaa", 100)
+		mustNoErr(t, err)
+		_, err = repo.Release(ctx, "aaaa")
+		mustNoErr(t, err)
+
+		mustErr(t, repo.Delete(ctx, "aaaa"), storage.ErrNotFound)
+		mustNoErr(t, repo.MarkDeleting(ctx, "aaaa"))
+		mustErr(t, repo.MarkDeleting(ctx, "aaaa"), storage.ErrNotFound)
+		_, err = repo.Acquire(ctx, "aaaa", 100)
+		mustErr(t, err, storage.ErrBlobDeleting)
+
+		mustNoErr(t, repo.Delete(ctx, "aaaa"))
+		mustErr(t, repo.Delete(ctx, "aaaa"), storage.ErrNotFound)
+
+		b, err := repo.Acquire(ctx, "aaaa", 100)
+		mustNoErr(t, err)
+		if b.RefCount != 1 || b.Deleting {
+			t.Fatalf("Acquire after delete returned refCount %d deleting %v", b.RefCount, b.Deleting)
+		}
+	})
+}
+
+func newEmail(messageID string, createdAt time.Time, from string, to ...string) *email.Email {
+	e := &email.Email{
+		MessageID: messageID,
+		From:      email.Participant{Email: from},
+		Subject:   "Subject " + messageID,
+		Content:   email.EmailContent{Text: "body of " + messageID},
+		Labels:    []string{},
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+	for _, address := range to {
+		e.To = append(e.To, email.Participant{Email: address})
+	}
+	return e
+}
+
+func newStaff(name, address, department, role string) *staff.Staff {
+	return &staff.Staff{
+		Email:      address,
+		FullName:   name,
+		Department: department,
+		Role:       role,
+		Status:     staff.StatusActive,
+		CreatedAt:  base,
+		UpdatedAt:  base,
+	}
+}
+
+func newThread(threadID string, lastMessage time.Time, participant string) *thread.Thread {
+	return &thread.Thread{
+		ThreadID:     threadID,
+		Subject:      "Subject " + threadID,
+		Participants: []thread.Participant{{Email: participant}},
+		LastMessage:  thread.LastMessage{MessageID: threadID + "-last", SentAt: lastMessage},
+		MessageCount: 1,
+		CreatedAt:    base,
+		UpdatedAt:    lastMessage,
+	}
+}
+
+func sameEmails(got, want []*email.Email) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("got %d emails %v, want %d %v", len(got), emailIDs(got), len(want), emailIDs(want))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID {
+			return fmt.Errorf("got %v, want %v", emailIDs(got), emailIDs(want))
+		}
+	}
+	return nil
+}
+
+func emailIDs(emails []*email.Email) []string {
+	ids := make([]string, len(emails))
+	for i, e := range emails {
+		ids[i] = e.MessageID
+	}
+	return ids
+}
+
+func sameStaff(got, want []*staff.Staff) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("got %d members %v, want %d %v", len(got), staffNames(got), len(want), staffNames(want))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID {
+			return fmt.Errorf("got %v, want %v", staffNames(got), staffNames(want))
+		}
+	}
+	return nil
+}
+
+func staffNames(members []*staff.Staff) []string {
+	names := make([]string, len(members))
+	for i, s := range members {
+		names[i] = s.FullName
+	}
+	return names
+}
+
+func threadIDs(threads []*thread.Thread) []string {
+	ids := make([]string, len(threads))
+	for i, t := range threads {
+		ids[i] = t.ThreadID
+	}
+	return ids
+}
+
+func auditActions(events []*audit.Event) []string {
+	actions := make([]string, len(events))
+	for i, e := range events {
+		actions[i] = e.Action
+	}
+	return actions
+}
+
+func mustNoErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func mustErr(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+}
+
+func testSpam(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+	repo := newRepos(t).Spam
+
+	mustNoErr(t, repo.Train(ctx, "alice", true, []string{"viagra", "free"}, 1))
+	mustNoErr(t, repo.Train(ctx, "alice", true, []string{"free", "offer"}, 1))
+	mustNoErr(t, repo.Train(ctx, "alice", false, []string{"free", "meeting"}, 1))
+	mustNoErr(t, repo.Train(ctx, spam.GlobalModel, false, []string{"viagra"}, 1))
+
+	model, err := repo.Model(ctx, "alice", []string{"viagra", "free", "meeting", "unseen"})
+	mustNoErr(t, err)
+	if want := (spam.Counts{Spam: 2, Ham: 1}); model.Messages != want {
+		t.Fatalf("Model returned messages %+v, want %+v", model.Messages, want)
+	}
+	want := map[string]spam.Counts{
+		"viagra":  {Spam: 1},
+		"free":    {Spam: 2, Ham: 1},
+		"meeting": {Ham: 1},
+	}
+	if len(model.Tokens) != len(want) {
+		t.Fatalf("Model returned tokens %+v, want %+v", model.Tokens, want)
+	}
+	for token, counts := range want {
+		if model.Tokens[token] != counts {
+			t.Fatalf("Model returned %q counts %+v, want %+v", token, model.Tokens[token], counts)
+		}
+	}
+
+	// Forgetting a message undoes its training
+	mustNoErr(t, repo.Train(ctx, "alice", true, []string{"free", "offer"}, -1))
+	model, err = repo.Model(ctx, "alice", []string{"free", "offer"})
+	mustNoErr(t, err)
+	if want := (spam.Counts{Spam: 1, Ham: 1}); model.Messages != want {
+		t.Fatalf("Model after forgetting returned messages %+v, want %+v", model.Messages, want)
+	}
+	if model.Tokens["free"] != (spam.Counts{Spam: 1, Ham: 1}) || model.Tokens["offer"] != (spam.Counts{}) {
+		t.Fatalf("Model after forgetting returned tokens %+v", model.Tokens)
+	}
+
+	// Models are separate per owner
+	model, err = repo.Model(ctx, spam.GlobalModel, []string{"viagra", "free"})
+	mustNoErr(t, err)
+	if model.Messages != (spam.Counts{Ham: 1}) || len(model.Tokens) != 1 || model.Tokens["viagra"] != (spam.Counts{Ham: 1}) {
+		t.Fatalf("global Model returned %+v", model)
+	}
+	model, err = repo.Model(ctx, "bob", []string{"viagra"})
+	mustNoErr(t, err)
+	if model.Messages != (spam.Counts{}) || len(model.Tokens) != 0 {
+		t.Fatalf("Model of an untrained owner returned %+v", model)
+	}
+}
+
+func testTemplates(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	newTemplate := func(name, kind, owner, department string) *template.Template {
+		return &template.Template{
+			Kind:       kind,
+			Name:       name,
+			OwnerID:    owner,
+			Department: department,
+			Subject:    "Hello {{.Recipient.Name}}",
+			Text:       "Hi",
+			CreatedBy:  "admin",
+			CreatedAt:  base,
+			UpdatedAt:  base,
+		}
+	}
+
+	t.Run("CRUD", func(t *testing.T) {
+		repo := newRepos(t).Templates
+
+		tmpl := newTemplate("welcome", template.KindMessage, "", "sales")
+		mustNoErr(t, repo.Create(ctx, tmpl))
+		if tmpl.ID.IsZero() {
+			t.Fatal("Create did not assign an ID")
+		}
+
+		got, err := repo.Get(ctx, tmpl.ID.Hex())
+		mustNoErr(t, err)
+		if got.Name != tmpl.Name || got.Department != "sales" || got.Subject != tmpl.Subject || !got.CreatedAt.Equal(base) {
+			t.Fatalf("Get returned %+v, want %+v", got, tmpl)
+		}
+
+		got.HTML = "<p>Hi</p>"
+		mustNoErr(t, repo.Update(ctx, got))
+		again, err := repo.Get(ctx, tmpl.ID.Hex())
+		mustNoErr(t, err)
+		if again.HTML != "<p>Hi</p>" {
+			t.Fatal("Update was not persisted")
+		}
+
+		mustNoErr(t, repo.Delete(ctx, tmpl.ID.Hex()))
+		_, err = repo.Get(ctx, tmpl.ID.Hex())
+		mustErr(t, err, storage.ErrNotFound)
+		mustErr(t, repo.Delete(ctx, tmpl.ID.Hex()), storage.ErrNotFound)
+		mustErr(t, repo.Update(ctx, newTemplate("missing", template.KindMessage, "", "")), storage.ErrNotFound)
+		_, err = repo.Get(ctx, "not-an-id")
+		mustErr(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("VisibleTo", func(t *testing.T) {
+		repo := newRepos(t).Templates
+
+		global := newTemplate("a-global", template.KindMessage, "", "")
+		sales := newTemplate("b-sales", template.KindMessage, "", "sales")
+		support := newTemplate("c-support", template.KindMessage, "", "support")
+		own := newTemplate("d-own", template.KindMessage, "alice", "")
+		other := newTemplate("e-other", template.KindMessage, "bob", "")
+		signature := newTemplate("f-signature", template.KindSignature, "alice", "")
+		for _, tmpl := range []*template.Template{global, sales, support, own, other, signature} {
+			mustNoErr(t, repo.Create(ctx, tmpl))
+		}
+
+		scope := &template.Scope{OwnerID: "alice", Department: "sales"}
+		for _, tc := range []struct {
+			name  string
+			query template.ListQuery
+			want  []*template.Template
+		}{
+			{"all", template.ListQuery{}, []*template.Template{global, sales, support, own, other, signature}},
+			{"visible", template.ListQuery{VisibleTo: scope}, []*template.Template{global, sales, own, signature}},
+			{"kind", template.ListQuery{Kind: template.KindMessage, VisibleTo: scope}, []*template.Template{global, sales, own}},
+			{"signature", template.ListQuery{Kind: template.KindSignature}, []*template.Template{signature}},
+		} {
+			got, err := repo.List(ctx, &tc.query)
+			mustNoErr(t, err)
+			if err := sameTemplates(got, tc.want); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+		}
+
+		// Pages continue after the cursor
+		page, err := repo.List(ctx, &template.ListQuery{Limit: 4})
+		mustNoErr(t, err)
+		rest, err := repo.List(ctx, &template.ListQuery{Cursor: storage.TemplateCursor(page[len(page)-1])})
+		mustNoErr(t, err)
+		if err := sameTemplates(append(page, rest...), []*template.Template{global, sales, support, own, other, signature}); err != nil {
+			t.Fatalf("pagination: %v", err)
+		}
+	})
+}
+
+func sameTemplates(got, want []*template.Template) error {
+	names := func(list []*template.Template) []string {
+		result := make([]string, len(list))
+		for i, t := range list {
+			result[i] = t.Name
+		}
+		return result
+	}
+	if fmt.Sprint(names(got)) != fmt.Sprint(names(want)) {
+		return fmt.Errorf("got templates %v, want %v", names(got), names(want))
+	}
+	return nil
+}
+
+func testCampaigns(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	newCampaign := func(name, createdBy string, createdAt time.Time) *campaign.Campaign {
+		return &campaign.Campaign{
+			Name:       name,
+			TemplateID: primitive.NewObjectID().Hex(),
+			Variables:  map[string]string{"event": "launch"},
+			Rate:       60,
+			Status:     campaign.StatusDraft,
+			CreatedBy:  createdBy,
+			CreatedAt:  createdAt,
+			UpdatedAt:  createdAt,
+		}
+	}
+	newRecipient := func(c *campaign.Campaign, address string) *campaign.Recipient {
+		return &campaign.Recipient{
+			CampaignID: c.ID.Hex(),
+			Email:      address,
+			Variables:  map[string]string{"company": "Acme"},
+			Status:     campaign.RecipientPending,
+			Token:      "token-" + address,
+			CreatedAt:  base,
+			UpdatedAt:  base,
+		}
+	}
+
+	t.Run("CRUD", func(t *testing.T) {
+		repo := newRepos(t).Campaigns
+
+		c := newCampaign("launch", "alice", base)
+		mustNoErr(t, repo.Create(ctx, c))
+		if c.ID.IsZero() {
+			t.Fatal("Create did not assign an ID")
+		}
+
+		got, err := repo.Get(ctx, c.ID.Hex())
+		mustNoErr(t, err)
+		if got.Name != c.Name || got.Variables["event"] != "launch" || got.Rate != 60 || !got.CreatedAt.Equal(base) || got.StartedAt != nil {
+			t.Fatalf("Get returned %+v, want %+v", got, c)
+		}
+
+		started := base.Add(time.Hour)
+		got.Status = campaign.StatusRunning
+		got.StartedAt = &started
+		got.Run = 2
+		mustNoErr(t, repo.Update(ctx, got))
+		again, err := repo.Get(ctx, c.ID.Hex())
+		mustNoErr(t, err)
+		if again.Status != campaign.StatusRunning || again.Run != 2 || again.StartedAt == nil || !again.StartedAt.Equal(started) {
+			t.Fatal("Update was not persisted")
+		}
+
+		_, err = repo.AddRecipients(ctx, []*campaign.Recipient{newRecipient(c, "a@example.com")})
+		mustNoErr(t, err)
+		mustNoErr(t, repo.Delete(ctx, c.ID.Hex()))
+		_, err = repo.Get(ctx, c.ID.Hex())
+		mustErr(t, err, storage.ErrNotFound)
+		recipients, err := repo.ListRecipients(ctx, &campaign.RecipientQuery{CampaignID: c.ID.Hex()})
+		mustNoErr(t, err)
+		if len(recipients) != 0 {
+			t.Fatalf("Delete left %d recipients", len(recipients))
+		}
+		mustErr(t, repo.Delete(ctx, c.ID.Hex()), storage.ErrNotFound)
+		mustErr(t, repo.Update(ctx, newCampaign("missing", "alice", base)), storage.ErrNotFound)
+		_, err = repo.Get(ctx, "not-an-id")
+		mustErr(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		repo := newRepos(t).Campaigns
+
+		first := newCampaign("first", "alice", base)
+		second := newCampaign("second", "bob", base.Add(time.Minute))
+		third := newCampaign("third", "alice", base.Add(2*time.Minute))
+		third.Status = campaign.StatusCompleted
+		for _, c := range []*campaign.Campaign{first, second, third} {
+			mustNoErr(t, repo.Create(ctx, c))
+		}
+
+		for _, tc := range []struct {
+			name  string
+			query campaign.ListQuery
+			want  []string
+		}{
+			{"all", campaign.ListQuery{}, []string{"third", "second", "first"}},
+			{"createdBy", campaign.ListQuery{CreatedBy: "alice"}, []string{"third", "first"}},
+			{"status", campaign.ListQuery{Status: campaign.StatusDraft}, []string{"second", "first"}},
+		} {
+			got, err := repo.List(ctx, &tc.query)
+			mustNoErr(t, err)
+			names := make([]string, len(got))
+			for i, c := range got {
+				names[i] = c.Name
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tc.want) {
+				t.Fatalf("%s: got campaigns %v, want %v", tc.name, names, tc.want)
+			}
+		}
+
+		page, err := repo.List(ctx, &campaign.ListQuery{Limit: 2})
+		mustNoErr(t, err)
+		rest, err := repo.List(ctx, &campaign.ListQuery{Cursor: storage.CampaignCursor(page[len(page)-1])})
+		mustNoErr(t, err)
+		if len(page) != 2 || len(rest) != 1 || rest[0].Name != "first" {
+			t.Fatalf("pagination returned %d then %d campaigns", len(page), len(rest))
+		}
+	})
+
+	t.Run("Recipients", func(t *testing.T) {
+		repo := newRepos(t).Campaigns
+
+		c := newCampaign("launch", "alice", base)
+		other := newCampaign("other", "alice", base)
+		mustNoErr(t, repo.Create(ctx, c))
+		mustNoErr(t, repo.Create(ctx, other))
+
+		added, err := repo.AddRecipients(ctx, []*campaign.Recipient{
+			newRecipient(c, "a@example.com"),
+			newRecipient(c, "b@example.com"),
+			newRecipient(c, "c@example.com"),
+			newRecipient(other, "a@example.com"),
+		})
+		mustNoErr(t, err)
+		if added != 4 {
+			t.Fatalf("AddRecipients added %d, want 4", added)
+		}
+
+		// Addresses already listed are skipped
+		added, err = repo.AddRecipients(ctx, []*campaign.Recipient{
+			newRecipient(c, "a@example.com"),
+			newRecipient(c, "d@example.com"),
+		})
+		mustNoErr(t, err)
+		if added != 1 {
+			t.Fatalf("AddRecipients added %d, want 1 skipping the duplicate", added)
+		}
+
+		all, err := repo.ListRecipients(ctx, &campaign.RecipientQuery{CampaignID: c.ID.Hex()})
+		mustNoErr(t, err)
+		if len(all) != 4 || all[0].Email != "a@example.com" || all[3].Email != "d@example.com" {
+			t.Fatalf("ListRecipients returned %d recipients out of order", len(all))
+		}
+		if all[0].Variables["company"] != "Acme" {
+			t.Fatal("recipient variables were not stored")
+		}
+
+		sent := base.Add(time.Minute)
+		all[1].Status = campaign.RecipientSent
+		all[1].MessageID = "<1@example.com>"
+		all[1].SentAt = &sent
+		all[1].Attempts = 1
+		mustNoErr(t, repo.UpdateRecipient(ctx, all[1]))
+		all[2].Status = campaign.RecipientBounced
+		mustNoErr(t, repo.UpdateRecipient(ctx, all[2]))
+		mustErr(t, repo.UpdateRecipient(ctx, newRecipient(c, "missing@example.com")), storage.ErrNotFound)
+
+		for _, tc := range []struct {
+			name  string
+			query campaign.RecipientQuery
+			want  []string
+		}{
+			{"pending", campaign.RecipientQuery{CampaignID: c.ID.Hex(), Statuses: []string{campaign.RecipientPending}},
+				[]string{"a@example.com", "d@example.com"}},
+			{"statuses", campaign.RecipientQuery{Statuses: []string{campaign.RecipientSent, campaign.RecipientBounced}},
+				[]string{"b@example.com", "c@example.com"}},
+			{"email", campaign.RecipientQuery{Email: "a@example.com"}, []string{"a@example.com", "a@example.com"}},
+			{"token", campaign.RecipientQuery{Token: "token-c@example.com"}, []string{"c@example.com"}},
+			{"messageId", campaign.RecipientQuery{MessageID: "<1@example.com>"}, []string{"b@example.com"}},
+		} {
+			got, err := repo.ListRecipients(ctx, &tc.query)
+			mustNoErr(t, err)
+			addresses := make([]string, len(got))
+			for i, recipient := range got {
+				addresses[i] = recipient.Email
+			}
+			if fmt.Sprint(addresses) != fmt.Sprint(tc.want) {
+				t.Fatalf("%s: got recipients %v, want %v", tc.name, addresses, tc.want)
+			}
+		}
+
+		page, err := repo.ListRecipients(ctx, &campaign.RecipientQuery{CampaignID: c.ID.Hex(), Limit: 3})
+		mustNoErr(t, err)
+		rest, err := repo.ListRecipients(ctx, &campaign.RecipientQuery{
+			CampaignID: c.ID.Hex(),
+			Cursor:     storage.RecipientCursor(page[len(page)-1]),
+		})
+		mustNoErr(t, err)
+		if len(page) != 3 || len(rest) != 1 || rest[0].Email != "d@example.com" {
+			t.Fatalf("pagination returned %d then %d recipients", len(page), len(rest))
+		}
+
+		counts, err := repo.CountRecipients(ctx, c.ID.Hex())
+		mustNoErr(t, err)
+		want := map[string]int{campaign.RecipientPending: 2, campaign.RecipientSent: 1, campaign.RecipientBounced: 1}
+		if fmt.Sprint(counts) != fmt.Sprint(want) {
+			t.Fatalf("CountRecipients returned %v, want %v", counts, want)
+		}
+	})
+
+	t.Run("ReplaceRecipient", func(t *testing.T) {
+		repo := newRepos(t).Campaigns
+		c := newCampaign("replace", "alice", base)
+		mustNoErr(t, repo.Create(ctx, c))
+		_, err := repo.AddRecipients(ctx, []*campaign.Recipient{newRecipient(c, "a@example.com")})
+		mustNoErr(t, err)
+		all, err := repo.ListRecipients(ctx, &campaign.RecipientQuery{CampaignID: c.ID.Hex()})
+		mustNoErr(t, err)
+
+		claimed := *all[0]
+		claimed.Status = campaign.RecipientSending
+		mustNoErr(t, repo.ReplaceRecipient(ctx, &claimed, campaign.RecipientPending))
+		// Claimed already
+		mustErr(t, repo.ReplaceRecipient(ctx, &claimed, campaign.RecipientPending), storage.ErrConflict)
+		mustErr(t, repo.ReplaceRecipient(ctx, newRecipient(c, "missing@example.com"), campaign.RecipientPending), storage.ErrNotFound)
+
+		got, err := repo.ListRecipients(ctx, &campaign.RecipientQuery{CampaignID: c.ID.Hex()})
+		mustNoErr(t, err)
+		if got[0].Status != campaign.RecipientSending {
+			t.Fatalf("status = %s, want %s", got[0].Status, campaign.RecipientSending)
+		}
+	})
+}
+
+func testSuppressions(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	newSuppression := func(address, reason string) *suppression.Suppression {
+		return &suppression.Suppression{
+			Email:      address,
+			Reason:     reason,
+			Diagnostic: "550 5.1.1 User unknown",
+			CreatedAt:  base,
+		}
+	}
+
+	t.Run("CRUD", func(t *testing.T) {
+		repo := newRepos(t).Suppressions
+
+		s := newSuppression("bob@example.com", suppression.ReasonBounce)
+		s.EmailID = primitive.NewObjectID().Hex()
+		mustNoErr(t, repo.Add(ctx, s))
+		mustErr(t, repo.Add(ctx, newSuppression("bob@example.com", suppression.ReasonManual)), storage.ErrDuplicate)
+
+		got, err := repo.Get(ctx, "bob@example.com")
+		mustNoErr(t, err)
+		if got.Reason != s.Reason || got.EmailID != s.EmailID || got.Diagnostic != s.Diagnostic || !got.CreatedAt.Equal(base) {
+			t.Fatalf("Get returned %+v, want %+v", got, s)
+		}
+
+		mustNoErr(t, repo.Delete(ctx, "bob@example.com"))
+		_, err = repo.Get(ctx, "bob@example.com")
+		mustErr(t, err, storage.ErrNotFound)
+		mustErr(t, repo.Delete(ctx, "bob@example.com"), storage.ErrNotFound)
+	})
+
+	t.Run("ListAndFind", func(t *testing.T) {
+		repo := newRepos(t).Suppressions
+		for _, s := range []*suppression.Suppression{
+			newSuppression("dave@example.com", suppression.ReasonBounce),
+			newSuppression("alice@example.com", suppression.ReasonManual),
+			newSuppression("carol@example.com", suppression.ReasonBounce),
+		} {
+			mustNoErr(t, repo.Add(ctx, s))
+		}
+
+		addresses := func(list []*suppression.Suppression) string {
+			names := make([]string, len(list))
+			for i, s := range list {
+				names[i] = s.Email
+			}
+			return fmt.Sprint(names)
+		}
+
+		page, err := repo.List(ctx, &suppression.ListQuery{Limit: 2})
+		mustNoErr(t, err)
+		if got := addresses(page); got != "[alice@example.com carol@example.com]" {
+			t.Fatalf("first page %s", got)
+		}
+		rest, err := repo.List(ctx, &suppression.ListQuery{Cursor: storage.SuppressionCursor(page[1])})
+		mustNoErr(t, err)
+		if got := addresses(rest); got != "[dave@example.com]" {
+			t.Fatalf("second page %s", got)
+		}
+
+		bounces, err := repo.List(ctx, &suppression.ListQuery{Reason: suppression.ReasonBounce})
+		mustNoErr(t, err)
+		if got := addresses(bounces); got != "[carol@example.com dave@example.com]" {
+			t.Fatalf("reason filter returned %s", got)
+		}
+
+		found, err := repo.Find(ctx, []string{"dave@example.com", "erin@example.com", "alice@example.com"})
+		mustNoErr(t, err)
+		if got := addresses(found); got != "[alice@example.com dave@example.com]" {
+			t.Fatalf("Find returned %s", got)
+		}
+		none, err := repo.Find(ctx, nil)
+		mustNoErr(t, err)
+		if len(none) != 0 {
+			t.Fatalf("Find without addresses returned %d", len(none))
+		}
+	})
+}
+
+func testLabels(t *testing.T, newRepos Factory) {
+	ctx := context.Background()
+
+	newLabel := func(owner, name string) *label.Label {
+		return &label.Label{Owner: owner, Name: name, CreatedAt: base, UpdatedAt: base}
+	}
+	// counts lists the names and counters of owner's labels by name
+	counts := func(t *testing.T, repo storage.LabelRepository, owner string) string {
+		t.Helper()
+		labels, err := repo.List(ctx, &label.ListQuery{Owner: owner})
+		mustNoErr(t, err)
+		result := ""
+		for _, l := range labels {
+			result += fmt.Sprintf("%s:%d/%d ", l.Name, l.Unread, l.Total)
+		}
+		return result
+	}
+	labelsOf := func(t *testing.T, repo storage.EmailRepository, e *email.Email) string {
+		t.Helper()
+		got, err := repo.Get(ctx, e.ID.Hex())
+		mustNoErr(t, err)
+		return fmt.Sprint(got.Labels)
+	}
+
+	t.Run("CRUD", func(t *testing.T) {
+		repo := newRepos(t).Labels
+
+		l := newLabel("alice", "Projects")
+		l.Color = "#1a73e8"
+		mustNoErr(t, repo.Create(ctx, l))
+		if l.ID.IsZero() {
+			t.Fatal("Create did not assign an ID")
+		}
+		mustErr(t, repo.Create(ctx, newLabel("alice", "Projects")), storage.ErrDuplicate)
+		mustNoErr(t, repo.Create(ctx, newLabel("bob", "Projects")))
+
+		got, err := repo.Get(ctx, l.ID.Hex())
+		mustNoErr(t, err)
+		if got.Owner != "alice" || got.Name != "Projects" || got.Color != "#1a73e8" || !got.CreatedAt.Equal(base) {
+			t.Fatalf("Get returned %+v, want %+v", got, l)
+		}
+
+		l.Color = "#d93025"
+		l.UpdatedAt = base.Add(time.Hour)
+		mustNoErr(t, repo.Update(ctx, l))
+		got, err = repo.Get(ctx, l.ID.Hex())
+		mustNoErr(t, err)
+		if got.Color != "#d93025" || !got.UpdatedAt.Equal(l.UpdatedAt) {
+			t.Fatalf("Update stored %+v", got)
+		}
+
+		_, err = repo.Get(ctx, primitive.NewObjectID().Hex())
+		mustErr(t, err, storage.ErrNotFound)
+		mustErr(t, repo.Update(ctx, newLabel("alice", "Missing")), storage.ErrNotFound)
+		mustErr(t, repo.Rename(ctx, "alice", "Missing", "Other"), storage.ErrNotFound)
+		mustErr(t, repo.Delete(ctx, "alice", "Missing"), storage.ErrNotFound)
+
+		mustNoErr(t, repo.Delete(ctx, "alice", "Projects"))
+		_, err = repo.Get(ctx, l.ID.Hex())
+		mustErr(t, err, storage.ErrNotFound)
+		if got := counts(t, repo, "bob"); got != "Projects:0/0 " {
+			t.Fatalf("other owner's labels are %s", got)
+		}
+	})
+
+	t.Run("Counters", func(t *testing.T) {
+		repos := newRepos(t)
+
+		mustNoErr(t, repos.Labels.Create(ctx, newLabel("alice", "Work")))
+		read := newEmail("read", base, "carol@example.com", "alice@example.com")
+		read.Mailbox = "alice"
+		read.Labels = []string{"inbox", "Work"}
+		read.Flags.IsRead = true
+		unread := newEmail("unread", base, "carol@example.com", "alice@example.com")
+		unread.Mailbox = "alice"
+		unread.Labels = []string{"inbox"}
+		// Emails outside any mailbox are not counted
+		unfiled := newEmail("unfiled", base, "carol@example.com", "alice@example.com")
+		unfiled.Labels = []string{"inbox"}
+		for _, e := range []*email.Email{read, unread, unfiled} {
+			mustNoErr(t, repos.Email.Create(ctx, e))
+		}
+		if got := counts(t, repos.Labels, "alice"); got != "Work:0/1 inbox:1/2 " {
+			t.Fatalf("after create counters are %s", got)
+		}
+
+		unread.Flags.IsRead = true
+		unread.Labels = []string{"inbox", "Work"}
+		mustNoErr(t, repos.Email.Update(ctx, unread))
+		read.Flags.IsRead = false
+		read.Labels = []string{"Work"}
+		mustNoErr(t, repos.Email.Update(ctx, read))
+		if got := counts(t, repos.Labels, "alice"); got != "Work:1/2 inbox:0/1 " {
+			t.Fatalf("after update counters are %s", got)
+		}
+
+		mustNoErr(t, repos.Email.Delete(ctx, read.ID.Hex()))
+		if got := counts(t, repos.Labels, "alice"); got != "Work:0/1 inbox:0/1 " {
+			t.Fatalf("after delete counters are %s", got)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		repos := newRepos(t)
+
+		for _, name := range []string{"Projects", "Projects/Alpha", "Projects/Alpha/Docs", "ProjectsOld", "Archive2"} {
+			mustNoErr(t, repos.Labels.Create(ctx, newLabel("alice", name)))
+		}
+		mustNoErr(t, repos.Labels.Create(ctx, newLabel("bob", "Projects")))
+		e := newEmail("rename", base, "carol@example.com", "alice@example.com")
+		e.Mailbox = "alice"
+		e.Labels = []string{"Projects/Alpha/Docs", "ProjectsOld", "inbox"}
+		mustNoErr(t, repos.Email.Create(ctx, e))
+		theirs := newEmail("theirs", base, "carol@example.com", "bob@example.com")
+		theirs.Mailbox = "bob"
+		theirs.Labels = []string{"Projects"}
+		mustNoErr(t, repos.Email.Create(ctx, theirs))
+
+		mustErr(t, repos.Labels.Rename(ctx, "alice", "Projects", "Archive2"), storage.ErrDuplicate)
+		mustErr(t, repos.Labels.Rename(ctx, "alice", "ProjectsOld", "Projects/Alpha"), storage.ErrDuplicate)
+
+		mustNoErr(t, repos.Labels.Rename(ctx, "alice", "Projects", "Archive2/Work"))
+		if got := counts(t, repos.Labels, "alice"); got != "Archive2:0/0 Archive2/Work:0/0 Archive2/Work/Alpha:0/0 Archive2/Work/Alpha/Docs:1/1 ProjectsOld:1/1 inbox:1/1 " {
+			t.Fatalf("after rename labels are %s", got)
+		}
+		if got := labelsOf(t, repos.Email, e); got != "[Archive2/Work/Alpha/Docs ProjectsOld inbox]" {
+			t.Fatalf("after rename email labels are %s", got)
+		}
+		if got := labelsOf(t, repos.Email, theirs); got != "[Projects]" {
+			t.Fatalf("rename changed another mailbox: %s", got)
+		}
+
+		// Counters follow the renamed labels
+		e.Labels = []string{"Archive2/Work/Alpha/Docs"}
+		e.Flags.IsRead = true
+		mustNoErr(t, repos.Email.Update(ctx, e))
+		if got := counts(t, repos.Labels, "alice"); got != "Archive2:0/0 Archive2/Work:0/0 Archive2/Work/Alpha:0/0 Archive2/Work/Alpha/Docs:0/1 ProjectsOld:0/0 inbox:0/0 " {
+			t.Fatalf("after update labels are %s", got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repos := newRepos(t)
+
+		for _, name := range []string{"Projects", "Projects/Alpha", "ProjectsOld"} {
+			mustNoErr(t, repos.Labels.Create(ctx, newLabel("alice", name)))
+		}
+		e := newEmail("delete", base, "carol@example.com", "alice@example.com")
+		e.Mailbox = "alice"
+		e.Labels = []string{"inbox", "Projects/Alpha", "ProjectsOld", "Projects"}
+		mustNoErr(t, repos.Email.Create(ctx, e))
+
+		mustNoErr(t, repos.Labels.Delete(ctx, "alice", "Projects"))
+		if got := counts(t, repos.Labels, "alice"); got != "ProjectsOld:1/1 inbox:1/1 " {
+			t.Fatalf("after delete labels are %s", got)
+		}
+		if got := labelsOf(t, repos.Email, e); got != "[inbox ProjectsOld]" {
+			t.Fatalf("after delete email labels are %s", got)
+		}
+
+		// The deleted labels are not counted when the email changes
+		got, err := repos.Email.Get(ctx, e.ID.Hex())
+		mustNoErr(t, err)
+		mustNoErr(t, repos.Email.Delete(ctx, got.ID.Hex()))
+		if got := counts(t, repos.Labels, "alice"); got != "ProjectsOld:0/0 inbox:0/0 " {
+			t.Fatalf("after deleting the email labels are %s", got)
+		}
+	})
+}
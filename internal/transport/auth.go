@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// SMTP AUTH mechanisms of SMTPConfig.Auth
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthXOAUTH2 = "xoauth2"
+)
+
+var errUnencryptedAuth = errors.New("refusing to authenticate over an unencrypted connection")
+
+// newAuth returns the smtp.Auth of a mechanism. Credentials are only sent
+// over TLS, or to localhost, like smtp.PlainAuth does.
+func newAuth(mechanism, host, username, secret string) (smtp.Auth, error) {
+	switch mechanism {
+	case AuthPlain:
+		return smtp.PlainAuth("", username, secret, host), nil
+	case AuthLogin:
+		return &loginAuth{host: host, username: username, password: secret}, nil
+	case AuthXOAUTH2:
+		return &xoauth2Auth{host: host, username: username, token: secret}, nil
+	}
+	return nil, fmt.Errorf("unsupported SMTP AUTH mechanism %q", mechanism)
+}
+
+func checkEncrypted(server *smtp.ServerInfo, host string) error {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return errUnencryptedAuth
+	}
+	if server.Name != host {
+		return errors.New("wrong host name")
+	}
+	return nil
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// loginAuth is the LOGIN mechanism: the server prompts for the username
+// and then the password
+type loginAuth struct {
+	host     string
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkEncrypted(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch prompt := strings.ToLower(strings.TrimSpace(string(fromServer))); {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN prompt %q", fromServer)
+	}
+}
+
+// xoauth2Auth is the XOAUTH2 mechanism of Google and Microsoft, which
+// authenticates with an OAuth 2.0 access token
+type xoauth2Auth struct {
+	host     string
+	username string
+	token    string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkEncrypted(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// The server sent its error details; an empty reply ends the
+		// exchange so that it reports the failure
+		return []byte{}, nil
+	}
+	return nil, nil
+}
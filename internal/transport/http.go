@@ -0,0 +1,282 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of HTTP transport requests and provider webhooks
+const (
+	HeaderIdempotencyKey   = "Idempotency-Key"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+// Types of provider delivery events
+const (
+	EventDelivered = "delivered"
+	EventDeferred  = "deferred"
+	EventBounced   = "bounced"
+)
+
+const (
+	defaultHTTPTimeout = 30 * time.Second
+	defaultRetryDelay  = time.Second
+	// maxResponseSize bounds the provider response read for diagnostics
+	maxResponseSize = 64 << 10
+	// webhookTolerance is how far the timestamp of a signed webhook may
+	// be from now, which bounds replays
+	webhookTolerance = 5 * time.Minute
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidEvents    = errors.New("invalid delivery events")
+)
+
+// HTTPConfig configures an HTTP email API. Every message is POSTed to URL
+// as JSON:
+//
+//	{"id": "<envelope ID>", "from": "<return path>", "to": ["..."], "raw": "<base64 message>"}
+//
+// with an Idempotency-Key header that is the same for every attempt of the
+// same message, so that providers can drop duplicates. A 2xx response
+// accepts the message for every recipient; other 4xx responses except 408
+// and 429 reject it for good.
+type HTTPConfig struct {
+	URL string
+	// Token is sent as a bearer token when set. TokenFile is read at every
+	// request instead when set.
+	Token     string
+	TokenFile string
+	// Headers are added to every request, such as a provider's API key
+	Headers map[string]string
+	Timeout time.Duration
+	// Retries is how often a request failing temporarily is repeated
+	// before the attempt is reported deferred, waiting RetryDelay first
+	// and twice as long before each further retry
+	Retries    int
+	RetryDelay time.Duration
+	// WebhookSecret verifies the delivery events the provider posts back,
+	// see ParseEvents
+	WebhookSecret string
+	// Client sends the requests, a client with Timeout if nil
+	Client *http.Client
+}
+
+// HTTP is a transport posting messages to an HTTP email API, such as a
+// Cloudflare Email Worker or a JSON webhook
+type HTTP struct {
+	name   string
+	cfg    HTTPConfig
+	client *http.Client
+}
+
+func NewHTTP(name string, cfg HTTPConfig) *HTTP {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHTTPTimeout
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+	return &HTTP{name: name, cfg: cfg, client: client}
+}
+
+func (t *HTTP) Name() string {
+	return t.name
+}
+
+// Close has nothing to release; idle connections belong to the client
+func (t *HTTP) Close() error {
+	return nil
+}
+
+type httpMessage struct {
+	ID   string   `json:"id,omitempty"`
+	From string   `json:"from"`
+	To   []string `json:"to"`
+	Raw  string   `json:"raw"`
+}
+
+// Send posts message, retrying temporary failures
+func (t *HTTP) Send(ctx context.Context, envelope Envelope, message []byte) []Result {
+	start := time.Now()
+	result := Result{Server: t.host(), StartedAt: start}
+
+	body, err := json.Marshal(httpMessage{
+		ID:   envelope.ID,
+		From: envelope.From,
+		To:   envelope.To,
+		Raw:  base64.StdEncoding.EncodeToString(message),
+	})
+	if err != nil {
+		result.Response = err.Error()
+		result.EndedAt = time.Now()
+		return forAll(envelope, result)
+	}
+	key := IdempotencyKey(envelope, message)
+
+	delay := t.cfg.RetryDelay
+	for attempt := 0; ; attempt++ {
+		result = t.post(ctx, body, key, result)
+		if result.Accepted || result.Permanent || attempt >= t.cfg.Retries {
+			break
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			result.Response = ctx.Err().Error()
+			return forAll(envelope, result)
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+	return forAll(envelope, result)
+}
+
+// post makes one request and describes its outcome
+func (t *HTTP) post(ctx context.Context, body []byte, key string, base Result) (result Result) {
+	result = base
+	result.Accepted, result.Permanent, result.Response = false, false, ""
+	defer func() { result.EndedAt = time.Now() }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		result.Response = err.Error()
+		result.Permanent = true
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderIdempotencyKey, key)
+	for name, value := range t.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	token, err := t.token()
+	if err != nil {
+		result.Response = err.Error()
+		return result
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		result.Response = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+
+	result.Response = strings.TrimSpace(fmt.Sprintf("%s %s", resp.Status, text))
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		result.Accepted = true
+	case code == http.StatusRequestTimeout || code == http.StatusConflict || code == http.StatusTooManyRequests:
+		// A conflict is a request with the same key still in progress
+	case code >= 400 && code < 500:
+		result.Permanent = true
+	}
+	return result
+}
+
+func (t *HTTP) token() (string, error) {
+	if t.cfg.TokenFile == "" {
+		return t.cfg.Token, nil
+	}
+	data, err := os.ReadFile(t.cfg.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func (t *HTTP) host() string {
+	host := strings.TrimPrefix(strings.TrimPrefix(t.cfg.URL, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	return host
+}
+
+// IdempotencyKey identifies a message to a provider. It depends only on
+// the envelope and content, so every attempt of the same message sends
+// the same key.
+func IdempotencyKey(envelope Envelope, message []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(envelope.ID + "\x00" + envelope.From + "\x00" + strings.Join(envelope.To, ",") + "\x00"))
+	hash.Write(message)
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// Event is a delivery event a provider reports after accepting a message
+type Event struct {
+	// ID is the envelope ID the message was sent with
+	ID        string `json:"id"`
+	Recipient string `json:"recipient"`
+	// Type is EventDelivered, EventDeferred or EventBounced
+	Type      string    `json:"event"`
+	Reply     int       `json:"reply,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	Response  string    `json:"response,omitempty"`
+	Server    string    `json:"server,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ParseEvents verifies and decodes the delivery events a provider posts
+// as {"events": [...]}. Requests are signed with the webhook secret: the
+// X-Webhook-Signature header is "sha256=" and the hex HMAC-SHA256 of the
+// X-Webhook-Timestamp header (Unix seconds), a dot and the body.
+func (t *HTTP) ParseEvents(header http.Header, body []byte, now time.Time) ([]Event, error) {
+	if t.cfg.WebhookSecret == "" {
+		return nil, ErrInvalidSignature
+	}
+
+	timestamp := header.Get(HeaderWebhookTimestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return nil, ErrInvalidSignature
+	}
+
+	signature, ok := strings.CutPrefix(header.Get(HeaderWebhookSignature), "sha256=")
+	if !ok || !hmac.Equal([]byte(signature), []byte(SignWebhook(t.cfg.WebhookSecret, timestamp, body))) {
+		return nil, ErrInvalidSignature
+	}
+
+	var payload struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEvents, err)
+	}
+	return payload.Events, nil
+}
+
+// SignWebhook returns the hex signature of a webhook body sent at
+// timestamp, see ParseEvents
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
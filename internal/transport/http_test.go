@@ -0,0 +1,302 @@
+package transport_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/transport"
+)
+
+// provider is a fake HTTP email API answering with the next of its
+// statuses, the last one repeatedly
+type provider struct {
+	*httptest.Server
+	statuses []int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func newProvider(t *testing.T, statuses ...int) *provider {
+	t.Helper()
+
+	p := &provider{statuses: statuses}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+
+		p.mu.Lock()
+		n := len(p.requests)
+		p.requests = append(p.requests, r)
+		p.bodies = append(p.bodies, body)
+		p.times = append(p.times, time.Now())
+		p.mu.Unlock()
+
+		status := p.statuses[min(n, len(p.statuses)-1)]
+		w.WriteHeader(status)
+		w.Write([]byte(http.StatusText(status)))
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *provider) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.requests)
+}
+
+var (
+	testEnvelope = transport.Envelope{
+		ID:   "65f1c0ffee0000000000beef",
+		From: "bounces+65f1c0ffee0000000000beef@example.com",
+		To:   []string{"bob@example.org", "carol@example.org"},
+	}
+	testMessage = []byte("From: alice@example.com\r\nSubject: Quarterly report\r\n\r\nSee attached\r\n")
+)
+
+func TestHTTPSendRetriesServerErrors(t *testing.T) {
+	p := newProvider(t, http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusAccepted)
+	delay := 20 * time.Millisecond
+	h := transport.NewHTTP("api", transport.HTTPConfig{
+		URL:        p.URL + "/send",
+		Token:      "secret-token",
+		Headers:    map[string]string{"X-Api-Key": "key"},
+		Retries:    3,
+		RetryDelay: delay,
+	})
+
+	results := h.Send(context.Background(), testEnvelope, testMessage)
+	if len(results) != len(testEnvelope.To) {
+		t.Fatalf("got %d results, want %d", len(results), len(testEnvelope.To))
+	}
+	for i, result := range results {
+		if result.Recipient != testEnvelope.To[i] || !result.Accepted || result.Permanent {
+			t.Errorf("result %d = %+v, want accepted for %s", i, result, testEnvelope.To[i])
+		}
+	}
+	if p.count() != 3 {
+		t.Fatalf("provider got %d requests, want 3", p.count())
+	}
+
+	// Every attempt sends the same key, so the provider can drop duplicates
+	want := transport.IdempotencyKey(testEnvelope, testMessage)
+	for i, r := range p.requests {
+		if key := r.Header.Get(transport.HeaderIdempotencyKey); key != want {
+			t.Errorf("request %d has idempotency key %q, want %q", i, key, want)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret-token" {
+			t.Errorf("request %d has authorization %q", i, auth)
+		}
+		if r.Header.Get("X-Api-Key") != "key" {
+			t.Errorf("request %d lacks the configured headers", i)
+		}
+	}
+
+	// The delay doubles before each further retry
+	if gap := p.times[1].Sub(p.times[0]); gap < delay {
+		t.Errorf("first retry after %v, want at least %v", gap, delay)
+	}
+	if gap := p.times[2].Sub(p.times[1]); gap < 2*delay {
+		t.Errorf("second retry after %v, want at least %v", gap, 2*delay)
+	}
+
+	var message struct {
+		ID   string   `json:"id"`
+		From string   `json:"from"`
+		To   []string `json:"to"`
+		Raw  string   `json:"raw"`
+	}
+	if err := json.Unmarshal(p.bodies[0], &message); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(message.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.ID != testEnvelope.ID || message.From != testEnvelope.From ||
+		strings.Join(message.To, ",") != strings.Join(testEnvelope.To, ",") || string(raw) != string(testMessage) {
+		t.Errorf("provider got message %+v", message)
+	}
+}
+
+func TestHTTPSendDefersAfterRetries(t *testing.T) {
+	p := newProvider(t, http.StatusInternalServerError)
+	h := transport.NewHTTP("api", transport.HTTPConfig{URL: p.URL, Retries: 2, RetryDelay: time.Millisecond})
+
+	results := h.Send(context.Background(), testEnvelope, testMessage)
+	if p.count() != 3 {
+		t.Fatalf("provider got %d requests, want 3", p.count())
+	}
+	for _, result := range results {
+		if result.Accepted || result.Permanent || !strings.Contains(result.Response, "500") {
+			t.Errorf("result = %+v, want a temporary failure with the status", result)
+		}
+	}
+}
+
+func TestHTTPSendStatuses(t *testing.T) {
+	for _, tc := range []struct {
+		status    int
+		requests  int
+		permanent bool
+	}{
+		{http.StatusBadRequest, 1, true},
+		{http.StatusUnauthorized, 1, true},
+		{http.StatusUnprocessableEntity, 1, true},
+		// Timeouts, rate limits and requests with the same key in
+		// progress are retried
+		{http.StatusRequestTimeout, 2, false},
+		{http.StatusConflict, 2, false},
+		{http.StatusTooManyRequests, 2, false},
+	} {
+		t.Run(strconv.Itoa(tc.status), func(t *testing.T) {
+			p := newProvider(t, tc.status)
+			h := transport.NewHTTP("api", transport.HTTPConfig{URL: p.URL, Retries: 1, RetryDelay: time.Millisecond})
+
+			results := h.Send(context.Background(), testEnvelope, testMessage)
+			if p.count() != tc.requests {
+				t.Errorf("provider got %d requests, want %d", p.count(), tc.requests)
+			}
+			for _, result := range results {
+				if result.Accepted || result.Permanent != tc.permanent {
+					t.Errorf("result = %+v, want permanent %v", result, tc.permanent)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPSendKeepsKeyAcrossSends(t *testing.T) {
+	p := newProvider(t, http.StatusServiceUnavailable, http.StatusOK)
+	h := transport.NewHTTP("api", transport.HTTPConfig{URL: p.URL})
+
+	// A deferred delivery job sends the same message again later
+	if results := h.Send(context.Background(), testEnvelope, testMessage); results[0].Accepted {
+		t.Fatal("first send accepted, want deferred")
+	}
+	if results := h.Send(context.Background(), testEnvelope, testMessage); !results[0].Accepted {
+		t.Fatalf("second send got %+v, want accepted", results[0])
+	}
+	if p.count() != 2 {
+		t.Fatalf("provider got %d requests, want 2", p.count())
+	}
+	first := p.requests[0].Header.Get(transport.HeaderIdempotencyKey)
+	if second := p.requests[1].Header.Get(transport.HeaderIdempotencyKey); first == "" || first != second {
+		t.Errorf("idempotency keys %q and %q, want the same", first, second)
+	}
+
+	other := testEnvelope
+	other.To = []string{"dave@example.org"}
+	if transport.IdempotencyKey(other, testMessage) == first {
+		t.Error("a different envelope has the same idempotency key")
+	}
+}
+
+func TestHTTPSendStopsRetryingOnCancel(t *testing.T) {
+	p := newProvider(t, http.StatusServiceUnavailable)
+	h := transport.NewHTTP("api", transport.HTTPConfig{URL: p.URL, Retries: 3, RetryDelay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results := h.Send(ctx, testEnvelope, testMessage)
+	if p.count() != 1 {
+		t.Errorf("provider got %d requests, want 1", p.count())
+	}
+	if results[0].Accepted || results[0].Permanent || !strings.Contains(results[0].Response, "deadline") {
+		t.Errorf("result = %+v, want a temporary failure from the context", results[0])
+	}
+}
+
+func TestHTTPParseEvents(t *testing.T) {
+	const secret = "webhook-secret"
+	now := time.Unix(1_700_000_000, 0)
+	h := transport.NewHTTP("api", transport.HTTPConfig{URL: "https://api.example.net", WebhookSecret: secret})
+
+	body := []byte(`{"events": [
+		{"id": "65f1c0ffee0000000000beef", "recipient": "bob@example.org", "event": "delivered", "timestamp": "2023-11-14T22:13:20Z"},
+		{"id": "65f1c0ffee0000000000beef", "recipient": "carol@example.org", "event": "bounced", "reply": 550, "code": "5.1.1", "timestamp": "2023-11-14T22:13:20Z"}
+	]}`)
+	signed := func(timestamp string, body []byte, secret string) http.Header {
+		header := http.Header{}
+		header.Set(transport.HeaderWebhookTimestamp, timestamp)
+		header.Set(transport.HeaderWebhookSignature, "sha256="+transport.SignWebhook(secret, timestamp, body))
+		return header
+	}
+	at := func(offset time.Duration) string {
+		return strconv.FormatInt(now.Add(offset).Unix(), 10)
+	}
+
+	events, err := h.ParseEvents(signed(at(0), body, secret), body, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != transport.EventDelivered || events[1].Recipient != "carol@example.org" ||
+		events[1].Reply != 550 || events[1].Code != "5.1.1" {
+		t.Fatalf("events = %+v", events)
+	}
+
+	// Within the tolerance either way
+	for _, offset := range []time.Duration{-4 * time.Minute, 4 * time.Minute} {
+		if _, err := h.ParseEvents(signed(at(offset), body, secret), body, now); err != nil {
+			t.Errorf("timestamp off by %v: %v", offset, err)
+		}
+	}
+
+	tampered := append([]byte(nil), body...)
+	tampered[len(tampered)-3] = ' '
+	unprefixed := signed(at(0), body, secret)
+	unprefixed.Set(transport.HeaderWebhookSignature, transport.SignWebhook(secret, at(0), body))
+	// Signed at one time but claiming another
+	moved := signed(at(0), body, secret)
+	moved.Set(transport.HeaderWebhookTimestamp, at(time.Second))
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		body   []byte
+	}{
+		{"WrongSecret", signed(at(0), body, "other-secret"), body},
+		{"TamperedBody", signed(at(0), body, secret), tampered},
+		{"NoPrefix", unprefixed, body},
+		{"NoSignature", http.Header{transport.HeaderWebhookTimestamp: {at(0)}}, body},
+		{"MovedTimestamp", moved, body},
+		{"Stale", signed(at(-6*time.Minute), body, secret), body},
+		{"Future", signed(at(6*time.Minute), body, secret), body},
+		{"MalformedTimestamp", signed("yesterday", body, secret), body},
+		{"NoTimestamp", http.Header{transport.HeaderWebhookSignature: {"sha256=" + transport.SignWebhook(secret, "", body)}}, body},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := h.ParseEvents(tc.header, tc.body, now); !errors.Is(err, transport.ErrInvalidSignature) {
+				t.Fatalf("got %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+
+	t.Run("NoSecret", func(t *testing.T) {
+		unsigned := transport.NewHTTP("api", transport.HTTPConfig{URL: "https://api.example.net"})
+		if _, err := unsigned.ParseEvents(signed(at(0), body, ""), body, now); !errors.Is(err, transport.ErrInvalidSignature) {
+			t.Fatalf("got %v, want ErrInvalidSignature", err)
+		}
+	})
+
+	t.Run("MalformedEvents", func(t *testing.T) {
+		malformed := []byte(`{"events": "none"}`)
+		if _, err := h.ParseEvents(signed(at(0), malformed, secret), malformed, now); !errors.Is(err, transport.ErrInvalidEvents) {
+			t.Fatalf("got %v, want ErrInvalidEvents", err)
+		}
+	})
+}
@@ -0,0 +1,388 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connection security of SMTPConfig.Security
+const (
+	// SecurityTLS connects with implicit TLS, usually on port 465
+	SecurityTLS = "tls"
+	// SecurityStartTLS upgrades a plaintext connection with STARTTLS,
+	// usually on port 587, and fails when the server does not offer it
+	SecurityStartTLS = "starttls"
+	SecurityNone     = "none"
+)
+
+const (
+	defaultMaxConnections = 4
+	defaultIdleTimeout    = 30 * time.Second
+	defaultTimeout        = 2 * time.Minute
+)
+
+// SMTPConfig configures a smarthost: an SMTP relay all mail of the
+// transport is handed to
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Security string
+	// Auth is the SMTP AUTH mechanism, or empty to send without logging in
+	Auth     string
+	Username string
+	// Password is the password, or the OAuth 2.0 access token for
+	// XOAUTH2. PasswordFile is read instead at every login when set, so
+	// that rotated passwords and refreshed tokens are picked up.
+	Password     string
+	PasswordFile string
+	// HeloName is the name the transport greets the relay with, the host
+	// name by default
+	HeloName string
+	// MaxConnections bounds the concurrent connections to the relay;
+	// connections are kept open for reuse for IdleTimeout
+	MaxConnections int
+	IdleTimeout    time.Duration
+	// Timeout bounds connecting and each transaction
+	Timeout time.Duration
+	// RootCAs verifies the relay's certificate, the system roots when nil
+	RootCAs *x509.CertPool
+}
+
+// SMTP is a transport relaying through a smarthost over a pool of
+// authenticated connections
+type SMTP struct {
+	name  string
+	cfg   SMTPConfig
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*smtpConn
+	closed bool
+}
+
+type smtpConn struct {
+	conn      net.Conn
+	client    *smtp.Client
+	tls       string
+	idleSince time.Time
+}
+
+func NewSMTP(name string, cfg SMTPConfig) *SMTP {
+	if cfg.MaxConnections <= 0 {
+		cfg.MaxConnections = defaultMaxConnections
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultIdleTimeout
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.HeloName == "" {
+		cfg.HeloName, _ = os.Hostname()
+	}
+	return &SMTP{
+		name:  name,
+		cfg:   cfg,
+		slots: make(chan struct{}, cfg.MaxConnections),
+	}
+}
+
+func (t *SMTP) Name() string {
+	return t.name
+}
+
+// Send relays message in one SMTP transaction. The connection is returned
+// to the pool unless it failed.
+func (t *SMTP) Send(ctx context.Context, envelope Envelope, message []byte) []Result {
+	start := time.Now()
+
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return forAll(envelope, t.result(nil, ctx.Err(), start))
+	}
+	defer func() { <-t.slots }()
+
+	c, err := t.conn(ctx)
+	if err != nil {
+		return forAll(envelope, t.result(nil, err, start))
+	}
+
+	results, err := t.transaction(c, envelope, message, start)
+	if err != nil {
+		c.close()
+	} else {
+		t.release(c)
+	}
+	return results
+}
+
+// Close closes the idle connections; connections in use are closed when
+// their transaction ends
+func (t *SMTP) Close() error {
+	t.mu.Lock()
+	idle := t.idle
+	t.idle = nil
+	t.closed = true
+	t.mu.Unlock()
+
+	for _, c := range idle {
+		c.quit()
+	}
+	return nil
+}
+
+// transaction sends message over c. A returned error means the connection
+// is no longer usable; rejections by the relay are reported in the
+// results only.
+func (t *SMTP) transaction(c *smtpConn, envelope Envelope, message []byte, start time.Time) ([]Result, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(t.cfg.Timeout)); err != nil {
+		return forAll(envelope, t.result(c, err, start)), err
+	}
+
+	if err := c.client.Mail(envelope.From); err != nil {
+		return forAll(envelope, t.result(c, err, start)), c.reset(err)
+	}
+
+	results := make([]Result, len(envelope.To))
+	var accepted []int
+	for i, recipient := range envelope.To {
+		if err := c.client.Rcpt(recipient); err != nil {
+			if !isReply(err) {
+				return forAll(envelope, t.result(c, err, start)), err
+			}
+			results[i] = t.result(c, err, start)
+			results[i].Recipient = recipient
+			continue
+		}
+		accepted = append(accepted, i)
+	}
+	if len(accepted) == 0 {
+		return results, c.reset(nil)
+	}
+
+	err := t.data(c, message)
+	outcome := t.result(c, err, start)
+	for _, i := range accepted {
+		results[i] = outcome
+		results[i].Recipient = envelope.To[i]
+	}
+	if err != nil {
+		return results, c.reset(err)
+	}
+	return results, nil
+}
+
+func (t *SMTP) data(c *smtpConn, message []byte) error {
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+	// Closing reads the relay's reply to the message
+	return w.Close()
+}
+
+// result describes the outcome of a transaction that ended with err, or
+// succeeded when err is nil
+func (t *SMTP) result(c *smtpConn, err error, start time.Time) Result {
+	result := Result{
+		Server:    t.cfg.Host,
+		StartedAt: start,
+		EndedAt:   time.Now(),
+	}
+	if c != nil {
+		result.TLS = c.tls
+	}
+
+	var reply *textproto.Error
+	switch {
+	case err == nil:
+		result.Accepted = true
+		result.Reply = 250
+	case errors.As(err, &reply):
+		result.Reply = reply.Code
+		result.Code = enhancedCode(reply.Msg)
+		result.Response = reply.Msg
+		result.Permanent = reply.Code >= 500
+	default:
+		result.Response = err.Error()
+	}
+	return result
+}
+
+// conn returns an idle connection that still works, or a new one
+func (t *SMTP) conn(ctx context.Context) (*smtpConn, error) {
+	for {
+		t.mu.Lock()
+		if len(t.idle) == 0 {
+			t.mu.Unlock()
+			break
+		}
+		c := t.idle[len(t.idle)-1]
+		t.idle = t.idle[:len(t.idle)-1]
+		t.mu.Unlock()
+
+		if time.Since(c.idleSince) > t.cfg.IdleTimeout {
+			c.quit()
+			continue
+		}
+		if err := c.conn.SetDeadline(time.Now().Add(t.cfg.Timeout)); err == nil && c.client.Reset() == nil {
+			return c, nil
+		}
+		c.close()
+	}
+	return t.dial(ctx)
+}
+
+func (t *SMTP) release(c *smtpConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		go c.quit()
+		return
+	}
+	c.idleSince = time.Now()
+	t.idle = append(t.idle, c)
+}
+
+// dial connects to the relay, secures the connection and logs in
+func (t *SMTP) dial(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(t.cfg.Host, strconv.Itoa(t.cfg.Port))
+	dialer := &net.Dialer{Timeout: t.cfg.Timeout}
+	tlsConfig := t.tlsConfig()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if t.cfg.Security == SecurityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(t.cfg.Timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, t.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to greet %s: %w", addr, err)
+	}
+	c := &smtpConn{conn: conn, client: client}
+	if err := t.setup(c); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	if state, ok := client.TLSConnectionState(); ok {
+		c.tls = tls.VersionName(state.Version)
+	}
+	return c, nil
+}
+
+func (t *SMTP) setup(c *smtpConn) error {
+	if err := c.client.Hello(t.cfg.HeloName); err != nil {
+		return fmt.Errorf("failed to say hello: %w", err)
+	}
+
+	if t.cfg.Security == SecurityStartTLS {
+		if ok, _ := c.client.Extension("STARTTLS"); !ok {
+			return errors.New("relay does not offer STARTTLS")
+		}
+		if err := c.client.StartTLS(t.tlsConfig()); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if t.cfg.Auth == "" {
+		return nil
+	}
+	secret, err := t.secret()
+	if err != nil {
+		return err
+	}
+	auth, err := newAuth(t.cfg.Auth, t.cfg.Host, t.cfg.Username, secret)
+	if err != nil {
+		return err
+	}
+	if err := c.client.Auth(auth); err != nil {
+		return fmt.Errorf("failed to authenticate: %w", err)
+	}
+	return nil
+}
+
+func (t *SMTP) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: t.cfg.Host, RootCAs: t.cfg.RootCAs, MinVersion: tls.VersionTLS12}
+}
+
+func (t *SMTP) secret() (string, error) {
+	if t.cfg.PasswordFile == "" {
+		return t.cfg.Password, nil
+	}
+	data, err := os.ReadFile(t.cfg.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// reset ends a failed transaction so the connection can be reused. Only
+// rejections by the relay leave it usable.
+func (c *smtpConn) reset(err error) error {
+	if err != nil && !isReply(err) {
+		return err
+	}
+	return c.client.Reset()
+}
+
+func (c *smtpConn) quit() {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.client.Quit(); err != nil {
+		c.close()
+	}
+}
+
+func (c *smtpConn) close() {
+	c.client.Close()
+}
+
+func isReply(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply)
+}
+
+// enhancedCode returns the RFC 3463 status code a reply text starts with,
+// such as 5.1.1 in "5.1.1 User unknown"
+func enhancedCode(text string) string {
+	code, _, _ := strings.Cut(strings.TrimSpace(text), " ")
+	parts := strings.Split(code, ".")
+	if len(parts) != 3 || len(parts[0]) != 1 || !strings.ContainsAny(parts[0], "245") {
+		return ""
+	}
+	for _, part := range parts[1:] {
+		if _, err := strconv.Atoi(part); err != nil || len(part) == 0 || len(part) > 3 {
+			return ""
+		}
+	}
+	return code
+}
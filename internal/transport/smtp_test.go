@@ -0,0 +1,402 @@
+package transport_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/transport"
+)
+
+// relay is an in-process SMTP smarthost. It offers STARTTLS, or speaks
+// TLS from the start, and AUTH PLAIN and LOGIN, and answers RCPT with the
+// reply configured for a recipient.
+type relay struct {
+	addr     string
+	cert     tls.Certificate
+	roots    *x509.CertPool
+	startTLS bool
+	implicit bool
+	username string
+	password string
+	// replies maps recipients to the reply to their RCPT command
+	replies map[string]string
+
+	mu       sync.Mutex
+	conns    int
+	tls      []bool
+	authed   []string
+	rcpts    []string
+	messages []string
+}
+
+type relayOption func(r *relay)
+
+func withStartTLS(r *relay)    { r.startTLS = true }
+func withImplicitTLS(r *relay) { r.implicit = true }
+func withAuth(user, pass string) relayOption {
+	return func(r *relay) { r.username, r.password = user, pass }
+}
+func withReply(recipient, reply string) relayOption {
+	return func(r *relay) { r.replies[recipient] = reply }
+}
+
+func newRelay(t *testing.T, options ...relayOption) *relay {
+	t.Helper()
+
+	// The httptest certificate is valid for 127.0.0.1
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	r := &relay{
+		cert:    ts.TLS.Certificates[0],
+		roots:   ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+		replies: make(map[string]string),
+	}
+	ts.Close()
+	for _, option := range options {
+		option(r)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.implicit {
+		ln = tls.NewListener(ln, r.tlsConfig())
+	}
+	t.Cleanup(func() { ln.Close() })
+	r.addr = ln.Addr().String()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			r.conns++
+			r.mu.Unlock()
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *relay) tlsConfig() *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{r.cert}}
+}
+
+// transport returns an SMTP transport relaying through r
+func (r *relay) transport(t *testing.T, cfg transport.SMTPConfig) *transport.SMTP {
+	t.Helper()
+
+	host, port, _ := net.SplitHostPort(r.addr)
+	cfg.Host = host
+	cfg.Port, _ = strconv.Atoi(port)
+	cfg.RootCAs = r.roots
+	cfg.HeloName = "client.test"
+	cfg.Timeout = 5 * time.Second
+	s := transport.NewSMTP("relay", cfg)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func (r *relay) serve(conn net.Conn) {
+	defer conn.Close()
+
+	_, secure := conn.(*tls.Conn)
+	text := textproto.NewConn(conn)
+	authed := r.username == ""
+	text.PrintfLine("220 relay.test ESMTP")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"relay.test"}
+			if r.startTLS && !secure {
+				lines = append(lines, "STARTTLS")
+			}
+			if r.username != "" {
+				lines = append(lines, "AUTH PLAIN LOGIN")
+			}
+			lines = append(lines, "8BITMIME")
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				text.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(conn, r.tlsConfig())
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			user, ok := r.auth(text, arg)
+			if !ok {
+				text.PrintfLine("535 5.7.8 Authentication credentials invalid")
+				continue
+			}
+			authed = true
+			r.mu.Lock()
+			r.authed = append(r.authed, user)
+			r.mu.Unlock()
+			text.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			if !authed {
+				text.PrintfLine("530 5.7.0 Authentication required")
+				continue
+			}
+			text.PrintfLine("250 2.1.0 Ok")
+		case "RCPT":
+			recipient := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if reply, ok := r.replies[recipient]; ok {
+				text.PrintfLine("%s", reply)
+				continue
+			}
+			r.mu.Lock()
+			r.rcpts = append(r.rcpts, recipient)
+			r.mu.Unlock()
+			text.PrintfLine("250 2.1.5 Ok")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			r.messages = append(r.messages, string(data))
+			r.tls = append(r.tls, secure)
+			r.mu.Unlock()
+			text.PrintfLine("250 2.0.0 Ok: queued")
+		case "RSET", "NOOP":
+			text.PrintfLine("250 2.0.0 Ok")
+		case "QUIT":
+			text.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			text.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// auth runs an AUTH exchange and returns the username logged in with
+func (r *relay) auth(text *textproto.Conn, arg string) (string, bool) {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		decoded, err := base64.StdEncoding.DecodeString(initial)
+		if err != nil {
+			return "", false
+		}
+		parts := strings.Split(string(decoded), "\x00")
+		return parts[1], len(parts) == 3 && parts[1] == r.username && parts[2] == r.password
+	case "LOGIN":
+		var answers []string
+		for _, prompt := range []string{"Username:", "Password:"} {
+			text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+			line, err := text.ReadLine()
+			if err != nil {
+				return "", false
+			}
+			answer, err := base64.StdEncoding.DecodeString(line)
+			if err != nil {
+				return "", false
+			}
+			answers = append(answers, string(answer))
+		}
+		return answers[0], answers[0] == r.username && answers[1] == r.password
+	}
+	return "", false
+}
+
+func (r *relay) snapshot() (conns int, authed, rcpts, messages []string, secure []bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns, append([]string(nil), r.authed...), append([]string(nil), r.rcpts...),
+		append([]string(nil), r.messages...), append([]bool(nil), r.tls...)
+}
+
+const smtpMessage = "From: a@example.com\r\nTo: b@example.org\r\nSubject: Hi\r\n\r\nHello\r\n.leading dot\r\n"
+
+func TestSMTPStartTLSPlainAuthAndRecipientFailures(t *testing.T) {
+	r := newRelay(t, withStartTLS, withAuth("mailer", "s3cret"),
+		withReply("gone@example.org", "550 5.1.1 User unknown"),
+		withReply("busy@example.org", "451 4.2.1 Mailbox busy, try later"),
+	)
+	s := r.transport(t, transport.SMTPConfig{
+		Security: transport.SecurityStartTLS,
+		Auth:     transport.AuthPlain,
+		Username: "mailer",
+		Password: "s3cret",
+	})
+
+	results := s.Send(context.Background(), transport.Envelope{
+		ID:   "1",
+		From: "bounces@example.com",
+		To:   []string{"b@example.org", "gone@example.org", "busy@example.org"},
+	}, []byte(smtpMessage))
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+
+	got := make(map[string]transport.Result)
+	for _, result := range results {
+		got[result.Recipient] = result
+	}
+	if ok := got["b@example.org"]; !ok.Accepted || ok.Reply != 250 || ok.TLS == "" {
+		t.Fatalf("accepted recipient: %+v, want accepted over TLS", ok)
+	}
+	if gone := got["gone@example.org"]; gone.Accepted || !gone.Permanent || gone.Reply != 550 || gone.Code != "5.1.1" {
+		t.Fatalf("unknown recipient: %+v, want a permanent 5.1.1 rejection", gone)
+	}
+	if busy := got["busy@example.org"]; busy.Accepted || busy.Permanent || busy.Reply != 451 || busy.Code != "4.2.1" {
+		t.Fatalf("busy recipient: %+v, want a temporary 4.2.1 rejection", busy)
+	}
+
+	_, authed, rcpts, messages, secure := r.snapshot()
+	if len(authed) != 1 || authed[0] != "mailer" {
+		t.Fatalf("logged in as %v, want mailer", authed)
+	}
+	if len(rcpts) != 1 || rcpts[0] != "b@example.org" {
+		t.Fatalf("relay accepted %v", rcpts)
+	}
+	if len(messages) != 1 || !secure[0] {
+		t.Fatalf("relay received %d messages, over TLS %v", len(messages), secure)
+	}
+	if !strings.Contains(messages[0], "\n.leading dot\n") {
+		t.Fatalf("message not dot-unstuffed intact: %q", messages[0])
+	}
+}
+
+func TestSMTPImplicitTLSLoginAuthReusesConnection(t *testing.T) {
+	r := newRelay(t, withImplicitTLS, withAuth("mailer", "s3cret"))
+	s := r.transport(t, transport.SMTPConfig{
+		Security: transport.SecurityTLS,
+		Auth:     transport.AuthLogin,
+		Username: "mailer",
+		Password: "s3cret",
+	})
+
+	for i := 0; i < 3; i++ {
+		results := s.Send(context.Background(), transport.Envelope{
+			From: "bounces@example.com",
+			To:   []string{"b@example.org"},
+		}, []byte(smtpMessage))
+		if len(results) != 1 || !results[0].Accepted {
+			t.Fatalf("send %d: %+v", i, results)
+		}
+	}
+
+	conns, authed, _, messages, _ := r.snapshot()
+	if conns != 1 || len(authed) != 1 {
+		t.Fatalf("%d connections and %d logins for 3 messages, want one of each", conns, len(authed))
+	}
+	if len(messages) != 3 {
+		t.Fatalf("relay received %d messages, want 3", len(messages))
+	}
+}
+
+func TestSMTPAuthFailure(t *testing.T) {
+	r := newRelay(t, withStartTLS, withAuth("mailer", "s3cret"))
+	s := r.transport(t, transport.SMTPConfig{
+		Security: transport.SecurityStartTLS,
+		Auth:     transport.AuthPlain,
+		Username: "mailer",
+		Password: "wrong",
+	})
+
+	results := s.Send(context.Background(), transport.Envelope{
+		From: "bounces@example.com",
+		To:   []string{"b@example.org", "c@example.org"},
+	}, []byte(smtpMessage))
+	for _, result := range results {
+		if result.Accepted || result.Reply != 535 || result.Code != "5.7.8" {
+			t.Fatalf("result %+v, want the 535 of the failed login", result)
+		}
+	}
+	if _, _, _, messages, _ := r.snapshot(); len(messages) != 0 {
+		t.Fatalf("relay received %d messages", len(messages))
+	}
+}
+
+func TestSMTPStartTLSRequired(t *testing.T) {
+	r := newRelay(t)
+	s := r.transport(t, transport.SMTPConfig{Security: transport.SecurityStartTLS})
+
+	results := s.Send(context.Background(), transport.Envelope{
+		From: "bounces@example.com",
+		To:   []string{"b@example.org"},
+	}, []byte(smtpMessage))
+	if len(results) != 1 || results[0].Accepted || !strings.Contains(results[0].Response, "STARTTLS") {
+		t.Fatalf("results %+v, want a failure for the missing STARTTLS", results)
+	}
+	if _, _, _, messages, _ := r.snapshot(); len(messages) != 0 {
+		t.Fatal("message sent in plaintext")
+	}
+}
+
+func TestSMTPUntrustedCertificate(t *testing.T) {
+	r := newRelay(t, withStartTLS)
+	s := r.transport(t, transport.SMTPConfig{Security: transport.SecurityStartTLS})
+	// A transport trusting only the system roots refuses the relay
+	host, port, _ := net.SplitHostPort(r.addr)
+	portNumber, _ := strconv.Atoi(port)
+	untrusting := transport.NewSMTP("relay", transport.SMTPConfig{
+		Host:     host,
+		Port:     portNumber,
+		Security: transport.SecurityStartTLS,
+		Timeout:  5 * time.Second,
+	})
+	defer untrusting.Close()
+
+	envelope := transport.Envelope{From: "bounces@example.com", To: []string{"b@example.org"}}
+	if results := untrusting.Send(context.Background(), envelope, []byte(smtpMessage)); results[0].Accepted {
+		t.Fatal("sent over TLS to a relay with an untrusted certificate")
+	}
+	if results := s.Send(context.Background(), envelope, []byte(smtpMessage)); !results[0].Accepted {
+		t.Fatalf("trusted relay: %+v", results[0])
+	}
+}
+
+func TestSMTPAllRecipientsRejectedKeepsConnection(t *testing.T) {
+	r := newRelay(t, withReply("gone@example.org", "550 5.1.1 User unknown"))
+	s := r.transport(t, transport.SMTPConfig{Security: transport.SecurityNone})
+
+	results := s.Send(context.Background(), transport.Envelope{
+		From: "bounces@example.com",
+		To:   []string{"gone@example.org"},
+	}, []byte(smtpMessage))
+	if len(results) != 1 || !results[0].Permanent {
+		t.Fatalf("results %+v, want a permanent rejection", results)
+	}
+	results = s.Send(context.Background(), transport.Envelope{
+		From: "bounces@example.com",
+		To:   []string{"b@example.org"},
+	}, []byte(smtpMessage))
+	if len(results) != 1 || !results[0].Accepted || results[0].TLS != "" {
+		t.Fatalf("results %+v, want accepted in plaintext", results)
+	}
+	if conns, _, _, _, _ := r.snapshot(); conns != 1 {
+		t.Fatalf("%d connections, want the first one reused", conns)
+	}
+}
@@ -0,0 +1,145 @@
+// Package transport delivers outbound messages. A Transport hands a
+// message to the next hop, such as a smarthost, and reports the outcome
+// for every recipient; a Router picks the transport of each sender and
+// recipient from per-transport domain rules.
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Envelope is the SMTP envelope of a message: the return path bounces are
+// sent to and the recipients the message is delivered to. ID identifies
+// the message to HTTP providers, which report it back in their delivery
+// events.
+type Envelope struct {
+	ID   string
+	From string
+	To   []string
+}
+
+// Result is the outcome of delivering a message to one recipient
+type Result struct {
+	Recipient string
+	// Accepted is set when the next hop took responsibility for the
+	// message; Permanent tells failures not worth retrying apart
+	Accepted  bool
+	Permanent bool
+	// Reply is the SMTP reply code, or 0 when the next hop was not reached
+	Reply int
+	// Code is the RFC 3463 enhanced status code of the reply, if any
+	Code string
+	// Response is the reply text or the error that prevented delivery
+	Response string
+	// Server is the host the message was handed to
+	Server string
+	// TLS is the TLS version of the connection, as named by
+	// tls.VersionName, and empty for plaintext
+	TLS       string
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// Transport delivers messages. Send returns a result for every recipient
+// of the envelope, in order; failures to reach the next hop are reported
+// as temporary failures of every recipient.
+type Transport interface {
+	Name() string
+	Send(ctx context.Context, envelope Envelope, message []byte) []Result
+	Close() error
+}
+
+// EventSource is a transport whose provider reports delivery events after
+// accepting a message, through a webhook
+type EventSource interface {
+	ParseEvents(header http.Header, body []byte, now time.Time) ([]Event, error)
+}
+
+// Route sends the mail matching its domain rules through Transport. Empty
+// domain lists match every domain; a domain such as "*.example.com" also
+// matches its subdomains.
+type Route struct {
+	Transport        Transport
+	SenderDomains    []string
+	RecipientDomains []string
+}
+
+// Router picks the transport of a message by sender and recipient domain
+type Router struct {
+	routes []Route
+}
+
+// NewRouter returns a router trying routes in order
+func NewRouter(routes ...Route) *Router {
+	return &Router{routes: routes}
+}
+
+// Route returns the transport of the first route matching the sender and
+// recipient addresses, or nil when none does
+func (r *Router) Route(sender, recipient string) Transport {
+	senderDomain, recipientDomain := domain(sender), domain(recipient)
+	for _, route := range r.routes {
+		if matchDomain(route.SenderDomains, senderDomain) && matchDomain(route.RecipientDomains, recipientDomain) {
+			return route.Transport
+		}
+	}
+	return nil
+}
+
+// Transport returns the transport named name, or nil
+func (r *Router) Transport(name string) Transport {
+	for _, route := range r.routes {
+		if route.Transport.Name() == name {
+			return route.Transport
+		}
+	}
+	return nil
+}
+
+// Close closes every transport
+func (r *Router) Close() error {
+	var errs []error
+	for _, route := range r.routes {
+		if err := route.Transport.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func matchDomain(patterns []string, domain string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == domain {
+			return true
+		}
+		if parent, ok := strings.CutPrefix(pattern, "*."); ok && strings.HasSuffix(domain, "."+parent) {
+			return true
+		}
+	}
+	return false
+}
+
+func domain(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		address = address[at+1:]
+	}
+	return strings.ToLower(strings.Trim(address, "<> "))
+}
+
+// forAll returns the same result for every recipient of envelope
+func forAll(envelope Envelope, result Result) []Result {
+	results := make([]Result, len(envelope.To))
+	for i, recipient := range envelope.To {
+		results[i] = result
+		results[i].Recipient = recipient
+	}
+	return results
+}
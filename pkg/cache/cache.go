@@ -1,21 +1,106 @@
 package cache
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bezata/blockchainml-email/internal/config"
 	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
-// Cache defines the interface for caching operations
-type Cache interface {
-	Get(key string) (interface{}, error)
-	Set(key string, value interface{}) error
-	Delete(key string) error
+// keyPrefix namespaces the cache's keys in Redis
+const keyPrefix = "cache:"
+
+// ErrMiss is returned by Get when the key is not cached
+var ErrMiss = errors.New("cache miss")
+
+// Cache stores JSON-encoded values in Redis, shared by all replicas
+type Cache struct {
+	redis      *redis.Client
+	defaultTTL time.Duration
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+}
+
+func NewCache(redisCfg config.RedisConfig, cfg config.CacheConfig, logger *zap.Logger, metrics *metrics.Metrics) (*Cache, error) {
+	if redisCfg.Addr == "" {
+		return nil, errors.New("redis address is required")
+	}
+
+	return &Cache{
+		redis: redis.NewClient(&redis.Options{
+			Addr:     redisCfg.Addr,
+			Password: redisCfg.Password,
+			DB:       redisCfg.DB,
+		}),
+		defaultTTL: time.Duration(cfg.DefaultTTL),
+		logger:     logger,
+		metrics:    metrics,
+	}, nil
+}
+
+// Get decodes the value cached under key into value. It returns ErrMiss
+// when the key is not cached.
+func (c *Cache) Get(ctx context.Context, key string, value interface{}) error {
+	startTime := time.Now()
+	defer func() {
+		c.metrics.CacheLatency.WithLabelValues("get").Observe(time.Since(startTime).Seconds())
+	}()
+
+	data, err := c.redis.Get(ctx, keyPrefix+key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.metrics.CacheMisses.Inc()
+			return ErrMiss
+		}
+		return fmt.Errorf("failed to get cached value: %w", err)
+	}
+	c.metrics.CacheHits.Inc()
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("failed to decode cached value: %w", err)
+	}
+	return nil
 }
 
-type cache struct {
-	// Define cache fields
+// Set caches value under key for ttl, or for the default TTL when ttl is
+// zero
+func (c *Cache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	startTime := time.Now()
+	defer func() {
+		c.metrics.CacheLatency.WithLabelValues("set").Observe(time.Since(startTime).Seconds())
+	}()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode cached value: %w", err)
+	}
+	if ttl == 0 {
+		ttl = c.defaultTTL
+	}
+	if err := c.redis.Set(ctx, keyPrefix+key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to cache value: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	startTime := time.Now()
+	defer func() {
+		c.metrics.CacheLatency.WithLabelValues("delete").Observe(time.Since(startTime).Seconds())
+	}()
+
+	if err := c.redis.Del(ctx, keyPrefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to delete cached value: %w", err)
+	}
+	return nil
 }
 
-func NewCache(redis interface{}, cache interface{}, logger *zap.Logger, metrics *metrics.Metrics) *Cache {
-	return &cache{}
+func (c *Cache) Close() error {
+	return c.redis.Close()
 }
@@ -3,19 +3,74 @@ package realtime
 import (
     "context"
     "encoding/json"
-    "net/http"
-    "sync"
+    "fmt"
     "time"
-    "github.com/gorilla/websocket"
     "github.com/redis/go-redis/v9"
     "go.uber.org/zap"
     "github.com/bezata/blockchainml-email/internal/monitoring/metrics"
 )
 
+// Event types pushed to clients
+const (
+    // EventDelivery reports a change of an outbound email's delivery state
+    // for one recipient
+    EventDelivery = "email.delivery"
+)
+
+// channelPrefix namespaces the Redis channels of users' events
+const channelPrefix = "realtime:user:"
+
+// Event is a change pushed to the sessions of a user
+type Event struct {
+    Type      string      `json:"type"`
+    Data      interface{} `json:"data"`
+    Timestamp time.Time   `json:"timestamp"`
+}
+
+// Notifier publishes events to the sessions of a user. Events go through
+// Redis so that the replica holding a user's connection receives them
+// whichever replica made the change.
 type Notifier struct {
-    // Define notifier fields
+    redis   *redis.Client
+    logger  *zap.Logger
+    metrics *metrics.Metrics
+}
+
+func NewNotifier(client *redis.Client, logger *zap.Logger, metrics *metrics.Metrics) *Notifier {
+    return &Notifier{
+        redis:   client,
+        logger:  logger,
+        metrics: metrics,
+    }
+}
+
+// Channel returns the Redis channel the events of userID are published on
+func Channel(userID string) string {
+    return channelPrefix + userID
+}
+
+// Notify publishes event to the sessions of userID. A nil Notifier drops
+// events, so that callers need not check whether realtime is enabled.
+func (n *Notifier) Notify(ctx context.Context, userID string, event Event) error {
+    if n == nil || n.redis == nil {
+        return nil
+    }
+    if event.Timestamp.IsZero() {
+        event.Timestamp = time.Now()
+    }
+
+    payload, err := json.Marshal(event)
+    if err != nil {
+        return fmt.Errorf("failed to encode event: %w", err)
+    }
+    if err := n.redis.Publish(ctx, Channel(userID), payload).Err(); err != nil {
+        return fmt.Errorf("failed to publish event: %w", err)
+    }
+    return nil
 }
 
-func NewNotifier(redis interface{}, logger *zap.Logger, metrics *metrics.Metrics) *Notifier {
-    return &Notifier{}
+// Close stops publishing. The Redis client belongs to the caller and is
+// left open.
+func (n *Notifier) Close() {
+    n.redis = nil
 }
@@ -1,14 +1,47 @@
 package search
 
 import (
+	"fmt"
+
+	"github.com/bezata/blockchainml-email/internal/config"
 	"github.com/bezata/blockchainml-email/internal/monitoring/metrics"
+	"github.com/elastic/go-elasticsearch/v8"
 	"go.uber.org/zap"
 )
 
+// SearchEngine holds the Elasticsearch client, nil when no Elasticsearch
+// node is configured
 type SearchEngine struct {
-	// Define search engine fields
+	client      *elasticsearch.Client
+	indexPrefix string
+	logger      *zap.Logger
+	metrics     *metrics.Metrics
+}
+
+func NewSearchEngine(cfg config.SearchConfig, logger *zap.Logger, metrics *metrics.Metrics) (*SearchEngine, error) {
+	engine := &SearchEngine{
+		indexPrefix: cfg.IndexPrefix,
+		logger:      logger,
+		metrics:     metrics,
+	}
+	if len(cfg.ElasticsearchURLs) == 0 {
+		return engine, nil
+	}
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: cfg.ElasticsearchURLs,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create elasticsearch client: %w", err)
+	}
+	engine.client = client
+	return engine, nil
 }
 
-func NewSearchEngine(config interface{}, logger *zap.Logger, metrics *metrics.Metrics) (*SearchEngine, error) {
-	return &SearchEngine{}, nil
+// Close releases the engine. The client holds no connections beyond the
+// idle ones of its HTTP transport, so there is nothing to release yet.
+func (e *SearchEngine) Close() error {
+	return nil
 }